@@ -0,0 +1,114 @@
+// Package consensus provides a common interface to the consensus groups
+// implemented in this repository that offer compare-and-set semantics,
+// together with a factory that starts a chosen implementation from a Config.
+//
+// This makes it easy for applications, tests, and tools
+// to experiment with or migrate between consensus algorithms
+// without changing their calling code.
+//
+package consensus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+	"github.com/dedis/tlc/go/model/quepaxa/qpcas"
+)
+
+// Group represents a running consensus group,
+// providing compare-and-set (CAS) access to the group's logical state
+// via the cas.Store interface.
+//
+// Close shuts down the group's background goroutines.
+// Any CompareAndSet calls in progress or made after Close return an error.
+//
+type Group interface {
+	cas.Store
+	Close() error
+}
+
+// Backend identifies a consensus algorithm implementation.
+type Backend string
+
+const (
+	// QSCOD selects client-driven Que Sera Consensus (package qscas)
+	QSCOD Backend = "qscod"
+	// QuePaxa selects QuePaxa consensus (package qpcas)
+	QuePaxa Backend = "quepaxa"
+)
+
+// Config describes a consensus group for Open to start.
+//
+// Members holds a cas.Store for each group member's persistent state,
+// which the chosen Backend uses in its own algorithm-specific format.
+// The zero Backend selects QSCOD.
+//
+// Faulty is the maximum number of faulty members the group must tolerate.
+// Since the zero value tolerates no failures at all,
+// most callers will want to set Faulty to -1,
+// which selects the maximum tolerance the chosen algorithm allows.
+//
+type Config struct {
+	Backend Backend     // Consensus algorithm to use
+	Members []cas.Store // Persistent state of each group member
+	Faulty  int         // Maximum number of faulty members to tolerate
+}
+
+// Open starts a consensus group as described by conf.
+// The group operates until ctx is cancelled or the group is closed.
+func Open(ctx context.Context, conf Config) (Group, error) {
+
+	// Check the threshold configuration up front,
+	// since the backends' Start functions panic on bad configurations.
+	n := len(conf.Members)
+	var err error
+	switch conf.Backend {
+	case QSCOD, "":
+		_, _, err = qscas.Thresholds(n, conf.Faulty)
+	case QuePaxa:
+		err = qpcas.CheckThreshold(n, conf.Faulty)
+	default:
+		err = errors.New("unknown consensus backend " +
+			string(conf.Backend))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g := &group{}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	switch conf.Backend {
+	case QSCOD, "":
+		g.Store = (&qscas.Group{}).Start(g.ctx, conf.Members, conf.Faulty)
+	case QuePaxa:
+		g.Store = (&qpcas.Group{}).Start(g.ctx, conf.Members, conf.Faulty)
+	}
+	return g, nil
+}
+
+// group wraps a backend's cas.Store with a cancelable context.
+type group struct {
+	cas.Store
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (g *group) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	if err := g.ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	version, actual, err = g.Store.CompareAndSet(ctx, old, new)
+	if err == nil {
+		err = g.ctx.Err() // in case the group was closed meanwhile
+	}
+	return version, actual, err
+}
+
+func (g *group) Close() error {
+	g.cancel()
+	return nil
+}
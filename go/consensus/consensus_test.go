@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+)
+
+// Run a consensus test case with the specified backend and parameters.
+func testRun(t *testing.T, backend Backend, nnode, nclients, naccesses int) {
+
+	desc := fmt.Sprintf("Backend=%v,N=%v,Clients=%v,Accesses=%v",
+		backend, nnode, nclients, naccesses)
+	t.Run(desc, func(t *testing.T) {
+
+		// Create an in-memory CAS register representing each node
+		members := make([]cas.Store, nnode)
+		for i := range members {
+			members[i] = &cas.Register{}
+		}
+
+		// Open the consensus group from each simulated client
+		conf := Config{Backend: backend, Members: members, Faulty: -1}
+		clients := make([]cas.Store, nclients)
+		for i := range clients {
+			g, err := Open(context.Background(), conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+			clients[i] = g
+		}
+
+		// Run a standard torture-test across all the clients
+		test.Stores(t, 1, naccesses, clients...)
+
+		// Operations on a closed group must fail
+		g := clients[0].(Group)
+		g.Close()
+		if _, _, err := g.CompareAndSet(context.Background(),
+			"", "x"); err == nil {
+			t.Errorf("CompareAndSet succeeded on closed group")
+		}
+	})
+}
+
+func TestBackends(t *testing.T) {
+	testRun(t, QSCOD, 3, 1, 100)
+	testRun(t, QSCOD, 3, 5, 100)
+	testRun(t, QuePaxa, 3, 1, 100)
+	testRun(t, QuePaxa, 3, 5, 100)
+}
+
+func TestConfig(t *testing.T) {
+	bg := context.Background()
+	members := []cas.Store{&cas.Register{}, &cas.Register{}}
+
+	if _, err := Open(bg, Config{Backend: "bogus"}); err == nil {
+		t.Errorf("Open accepted unknown backend")
+	}
+	if _, err := Open(bg, Config{Backend: QuePaxa, Members: members,
+		Faulty: 1}); err == nil {
+		t.Errorf("Open accepted unsafe QuePaxa configuration")
+	}
+	if _, err := Open(bg, Config{Backend: QSCOD, Members: members,
+		Faulty: 2}); err == nil {
+		t.Errorf("Open accepted unsafe QSCOD configuration")
+	}
+}
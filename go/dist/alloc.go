@@ -0,0 +1,56 @@
+package dist
+
+import "sync"
+
+// A node handles many messages per time step in a long high-rate run,
+// so allocating each of them separately puts the garbage collector
+// under pressure. Transports therefore take the messages they receive
+// from a pool, and the node recycles each one once it has handled it.
+// Broadcasts the node logs live until history pruning drops them,
+// so the node instead copies them, and its own messages' vector clocks,
+// into larger preallocated slabs, which it never recycles:
+// transports' copies of logged messages share their vector clocks.
+
+// Number of messages or vector clocks to preallocate at a time
+const slabLen = 64
+
+var msgPool = sync.Pool{New: func() any { return new(Message) }}
+
+// Get a zeroed Message from the pool.
+func getMessage() *Message {
+	return msgPool.Get().(*Message)
+}
+
+// Return a Message no longer referenced to the pool.
+// Its slices may be shared with other messages, so we drop them.
+func putMessage(msg *Message) {
+	*msg = Message{}
+	msgPool.Put(msg)
+}
+
+// Return true if msg is a broadcast the causal layer logs.
+func (msg *Message) causal() bool {
+	return msg.Typ == Prop || msg.Typ == Wit
+}
+
+// Return a copy of msg for our causal log.
+func (n *Node) newLogged(msg *Message) *Message {
+	if len(n.slab) == 0 {
+		n.slab = make([]Message, slabLen)
+	}
+	m := &n.slab[0]
+	n.slab = n.slab[1:]
+	*m = *msg
+	return m
+}
+
+// Return a copy of vector clock v for a message we broadcast.
+func (n *Node) copyVec(v vec) vec {
+	if len(n.vecs) < len(v) {
+		n.vecs = make(vec, slabLen*len(v))
+	}
+	c := n.vecs[:len(v):len(v)]
+	n.vecs = n.vecs[len(v):]
+	copy(c, v)
+	return c
+}
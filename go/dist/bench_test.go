@@ -0,0 +1,26 @@
+package dist
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Benchmark a group's message handling per time step
+// in a steady state with bounded history,
+// reporting the allocations it makes, the garbage collector's load.
+func BenchmarkStep(b *testing.B) {
+	for _, nnodes := range []int{4, 16} {
+		b.Run(fmt.Sprintf("N=%v", nnodes), func(b *testing.B) {
+			sn := newSim(nnodes, func(int) Config {
+				return Config{Threshold: nnodes/2 + 1,
+					History: KeepLastKRounds(2)}
+			})
+			sn.run(10) // reach a steady state
+			b.ReportAllocs()
+			b.ResetTimer()
+			if !sn.run(10 + b.N) {
+				b.Fatal("group stuck")
+			}
+		})
+	}
+}
@@ -7,9 +7,10 @@ func (n *Node) broadcastCausal(msg *Message) {
 	//	"mat", len(n.mat))
 
 	// Assign the new message a sequence number
-	msg.Seq = len(n.seqLog[n.self]) // Assign sequence number
-	msg.Vec = n.mat[n.self].copy()  // Include vector time update
-	n.logCausal(n.self, msg)        // Add msg to our log
+	msg.Seq = n.logLen(n.self)         // Assign sequence number
+	msg.Vec = n.copyVec(n.mat[n.self]) // Include vector time update
+	n.stampClock(msg)                  // Include wall-clock time
+	n.logCausal(n.self, msg)           // Add msg to our log
 	//println(n.self, n.tmpl.Step, "broadcastCausal step", msg.Step,
 	//		"typ", msg.Typ, "seq", msg.Seq,
 	//		"vec", fmt.Sprintf("%v", msg.Vec))
@@ -17,7 +18,18 @@ func (n *Node) broadcastCausal(msg *Message) {
 	// We always receive our own message first.
 	n.receiveTLC(msg)
 
-	// Send it to all other peers.
+	// Let the application persist our state before we send it,
+	// so that we can rejoin without contradicting ourselves.
+	if n.persist != nil {
+		n.persist(n.snapshot())
+	}
+
+	// Send it to all other peers,
+	// at the end of the event we're handling if we're deferring sends.
+	if n.batch {
+		n.outbox = append(n.outbox, msg)
+		return
+	}
 	for dest := range n.peer {
 		if dest != n.self {
 			n.sendCausal(dest, msg)
@@ -27,37 +39,47 @@ func (n *Node) broadcastCausal(msg *Message) {
 
 // Log a peer's message, either our own (just sent)
 // or another node's (received and ready to be delivered).
+//
+// We need not record which messages each node has seen as a set:
+// the messages a node had seen by the time it sent a message
+// are exactly those its vector timestamp covers, together with itself,
+// and the proposals it had seen threshold witnessed
+// are those whose Wit messages it had seen.
 func (n *Node) logCausal(peer int, msg *Message) {
 
-	// Update peer's matrix clock and our record of what it saw by msg
-	for i := range n.peer {
-		//println(i, "mat", len(n.mat), "vec", len(msg.Vec))
-		for n.mat[peer][i] < msg.Vec[i] {
-			n.sawCausal(peer, n.seqLog[i][n.mat[peer][i]])
-			n.mat[peer][i]++
-		}
-	}
-	n.sawCausal(peer, msg)   // msg has been seen by the peer that sent it
-	n.sawCausal(n.self, msg) // and now we've seen the message too
+	// Update peer's matrix clock with what it saw by msg
+	n.mat[peer].max(n.mat[peer], msg.Vec)
+	msg.wit = 0 // our own record, not any sender's
+	n.witCausal(msg)
 
 	n.seqLog[peer] = append(n.seqLog[peer], msg) // log this msg
-	n.mat[n.self][peer] = len(n.seqLog[peer])    // update our vector time
-	if len(n.seqLog[peer]) != msg.Seq+1 {        // sanity check
+	n.mat[n.self][peer] = n.logLen(peer)         // update our vector time
+	if n.logLen(peer) != msg.Seq+1 {             // sanity check
 		panic("out of sync")
 	}
 }
 
-// Record the fact that a given peer is now known to have seen a given message.
-// For Wit messages, record the fact that the proposal was threshold witnessed.
-func (n *Node) sawCausal(peer int, msg *Message) {
-	n.saw[peer].add(msg)
-	if msg.Typ == Wit {
-		prop := n.seqLog[msg.From][msg.Prop]
-		if prop.Typ != Prop {
-			panic("not a proposal!")
-		}
-		n.wit[peer].add(prop)
+// For a Wit message we are logging,
+// record the fact that its proposal was threshold witnessed.
+func (n *Node) witCausal(msg *Message) {
+	if msg.Typ != Wit {
+		return
+	}
+	prop := n.logged(msg.From, msg.Prop)
+	if prop == nil {
+		return // proposal is from before our history horizon
+	}
+	if prop.Typ != Prop {
+		n.fault(msg, "witnesses a non-proposal")
+		return
 	}
+	prop.wit = msg.Seq
+}
+
+// Return true if a node that had seen the messages that vector time v covers
+// had seen proposal prop threshold witnessed.
+func (n *Node) witnessedCausal(v vec, prop *Message) bool {
+	return prop.wit != 0 && prop.wit < v[prop.From]
 }
 
 // Transmit a message to a particular node.
@@ -67,11 +89,48 @@ func (n *Node) sendCausal(dest int, msg *Message) {
 	n.peer[dest].Send(msg)
 }
 
+// Receive a message a transport took from the pool,
+// ignoring messages we have already received,
+// then recycle it, keeping a copy of any broadcast for our causal history.
+// Transports call this with the node's stack locked.
+func (n *Node) receiveMessage(msg *Message) {
+	defer putMessage(msg)
+	if !n.validMessage(msg) || n.duplicateCausal(msg) {
+		return
+	}
+	if msg.causal() {
+		n.receiveCausal(n.newLogged(msg))
+		return
+	}
+	n.receiveCausal(msg)
+}
+
 // Receive a possibly out-of-order message from the network.
 // Enqueue it and actually deliver messages as soon as we can.
 func (n *Node) receiveCausal(msg *Message) {
 
-	// Unicast acknowledgments don't get sequence numbers or reordering.
+	// Update our estimates of peers' wall-clock offsets
+	// and our record of which peers are live.
+	n.receiveClock(msg)
+	n.receiveLiveness(msg)
+
+	// Defer what we send in response, to piggyback acknowledgments.
+	n.batch = true
+	defer n.flushTLC()
+
+	// Liveness probes carry nothing further up the stack,
+	// and requests to resynchronize concern only this layer.
+	switch msg.Typ {
+	case Ping:
+		return
+	case Sync:
+		n.receiveSync(msg)
+		return
+	}
+
+	// Unicast acknowledgments don't get sequence numbers or reordering,
+	// nor do acknowledgments piggybacked on broadcasts.
+	n.receiveAcks(msg)
 	if msg.Typ == Ack {
 		n.receiveTLC(msg) // Just send it up the stack
 		return
@@ -79,9 +138,8 @@ func (n *Node) receiveCausal(msg *Message) {
 
 	// Ignore duplicate message deliveries
 	if msg.Seq < n.mat[n.self][msg.From] {
-		println(n.self, n.tmpl.Step, "duplicate message from", msg.From,
-			"seq", msg.Seq)
-		panic("XXX")
+		n.fault(msg, "duplicate message")
+		return
 	}
 
 	// Enqueue broadcast message for delivery in causal order.
@@ -105,6 +163,19 @@ func (n *Node) receiveCausal(msg *Message) {
 	}
 }
 
+// Return true if msg is a broadcast message we have already received,
+// as transports that retransmit messages after failures may deliver.
+// Unicast acknowledgments, probes, and resynchronization requests
+// are harmless to deliver more than once.
+func (n *Node) duplicateCausal(msg *Message) bool {
+	if msg.Typ == Ack || msg.Typ == Ping || msg.Typ == Sync {
+		return false
+	}
+	i := msg.Seq - n.mat[n.self][msg.From]
+	oom := n.oom[msg.From]
+	return i < 0 || (i < len(oom) && oom[i] != nil)
+}
+
 // Try to deliver out-of-order messages held from a given peer.
 // Returns true if we made progress, false if nothing to  do for this peer.
 func (n *Node) deliverCausal(peer int) bool {
@@ -120,8 +191,9 @@ func (n *Node) deliverCausal(peer int) bool {
 	msg := n.oom[peer][0]
 	n.logCausal(peer, msg)
 
-	// Remove it from this peer's out-of-order message queue.
-	n.oom[peer] = n.oom[peer][1:]
+	// Remove it from this peer's out-of-order message queue,
+	// which is usually short, reusing the queue's array.
+	n.oom[peer] = trim(n.oom[peer], 1)
 
 	// Deliver the message to upper layers.
 	n.receiveTLC(msg)
@@ -134,12 +206,8 @@ func (n *Node) initCausal() {
 	n.mat = make([]vec, len(n.peer))
 	n.oom = make([][]*Message, len(n.peer))
 	n.seqLog = make([][]*Message, len(n.peer))
-	n.saw = make([]set, len(n.peer))
-	n.wit = make([]set, len(n.peer))
 	for i := range n.peer {
 		n.mat[i] = make(vec, len(n.peer))
-		n.saw[i] = make(set)
-		n.wit[i] = make(set)
 	}
 
 	n.initTLC()
@@ -0,0 +1,130 @@
+package dist
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of recent round-trip samples kept per peer for skew estimation
+const clockSamples = 8
+
+// Wall-clock layer state for a Node.
+// It is protected by its own mutex rather than the node's stack mutex,
+// so that the application may call Now at any time.
+type clock struct {
+	mut  sync.Mutex
+	now  func() time.Time // Local clock, replaceable for testing
+	peer [][]clockSample  // Recent round-trip samples from each peer
+}
+
+// A Cristian-style sample of one peer's clock offset from ours
+type clockSample struct {
+	offset time.Duration // Peer's clock minus ours, estimated
+	rtt    time.Duration // Round-trip time over which it was measured
+}
+
+// Initialize the wall-clock layer state in a Node.
+func (n *Node) initClock() {
+	n.clock.now = time.Now
+	n.clock.peer = make([][]clockSample, len(n.peer))
+}
+
+// Stamp a message we're about to send with our local wall-clock time.
+func (n *Node) stampClock(msg *Message) {
+	n.clock.mut.Lock()
+	defer n.clock.mut.Unlock()
+
+	msg.Time = n.clock.now().UnixNano()
+}
+
+// Estimate a peer's clock offset from a message it sent us.
+//
+// Acknowledgments complete a round trip starting with our own proposal,
+// whether unicast or piggybacked on a broadcast the peer stamped,
+// so we estimate the peer's offset Cristian-style by assuming
+// it stamped its acknowledgment halfway through the round trip.
+// Since network delays inflate round trips unpredictably,
+// we trust the sample with the smallest round-trip time among recent ones.
+func (n *Node) receiveClock(msg *Message) {
+	if msg.Typ == Ack {
+		n.sampleClock(msg.From, msg.Prop, msg.Time)
+	}
+	for _, seq := range msg.Acks {
+		n.sampleClock(msg.From, seq, msg.Time)
+	}
+}
+
+// Estimate a peer's clock offset from its acknowledgment,
+// stamped with stamp, of our message with sequence number seq.
+func (n *Node) sampleClock(from, seq int, stamp int64) {
+	if from == n.self || seq >= n.logLen(n.self) || seq < n.seqBase[n.self] {
+		return
+	}
+	sent := n.logged(n.self, seq).Time
+
+	n.clock.mut.Lock()
+	defer n.clock.mut.Unlock()
+
+	now := n.clock.now().UnixNano()
+	rtt := time.Duration(now - sent)
+	if rtt < 0 {
+		return // our own clock went backwards; ignore the sample
+	}
+	offset := time.Duration(stamp + int64(rtt/2) - now)
+
+	s := append(n.clock.peer[from], clockSample{offset, rtt})
+	if len(s) > clockSamples {
+		s = s[1:]
+	}
+	n.clock.peer[from] = s
+}
+
+// PeerOffset returns the current estimate of a peer's wall-clock offset
+// from ours, together with the round-trip time of the sample it is based on.
+// The ok result is false if we have no estimate yet for that peer.
+func (n *Node) PeerOffset(peer int) (offset, rtt time.Duration, ok bool) {
+	n.clock.mut.Lock()
+	defer n.clock.mut.Unlock()
+
+	return n.peerOffset(peer)
+}
+
+func (n *Node) peerOffset(peer int) (offset, rtt time.Duration, ok bool) {
+	if peer == n.self {
+		return 0, 0, true
+	}
+	for i, s := range n.clock.peer[peer] {
+		if i == 0 || s.rtt < rtt {
+			offset, rtt, ok = s.offset, s.rtt, true
+		}
+	}
+	return
+}
+
+// Now returns the median of all nodes' wall-clock times as we estimate them,
+// counting our own clock and every peer for which we have an estimate.
+// Provided fewer than half the nodes' clocks are badly wrong,
+// this gives loosely-synchronized real time alongside TLC's logical time.
+func (n *Node) Now() time.Time {
+	n.clock.mut.Lock()
+	defer n.clock.mut.Unlock()
+
+	var offsets []time.Duration
+	for i := range n.clock.peer {
+		if offset, _, ok := n.peerOffset(i); ok {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	// Average the middle two offsets if there is an even number
+	m := len(offsets) / 2
+	median := offsets[m]
+	if len(offsets)%2 == 0 {
+		median = offsets[m-1] + (offsets[m]-offsets[m-1])/2
+	}
+	return n.clock.now().Add(median)
+}
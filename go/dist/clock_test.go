@@ -0,0 +1,65 @@
+package dist
+
+import (
+	"testing"
+	"time"
+)
+
+// Test peer clock offset estimation against simulated skewed clocks.
+func TestClock(t *testing.T) {
+	skew := []time.Duration{0, 3 * time.Second, -time.Second, time.Hour}
+	base := time.Unix(1000000, 0)
+	var local time.Duration // elapsed simulated real time
+
+	n := &Node{self: 0, peer: make([]peer, len(skew))}
+	n.initClock()
+	n.seqLog = make([][]*Message, len(skew))
+	n.initHistory(nil)
+	n.clock.now = func() time.Time { return base.Add(local) }
+
+	if _, _, ok := n.PeerOffset(1); ok {
+		t.Errorf("offset estimate before any samples")
+	}
+
+	for round := 0; round < 10; round++ {
+
+		// Log our proposal, stamped with our local time
+		prop := &Message{From: 0, Typ: Prop, Seq: round}
+		n.stampClock(prop)
+		n.seqLog[0] = append(n.seqLog[0], prop)
+
+		// Each peer acknowledges it after a variable network delay,
+		// with the first round suffering asymmetric delays.
+		for i := 1; i < len(skew); i++ {
+			out := time.Duration(10+round+i) * time.Millisecond
+			back := out
+			if round == 0 {
+				back += time.Second
+			}
+			ack := &Message{From: i, Typ: Ack, Prop: prop.Seq,
+				Time: base.Add(local + out + skew[i]).UnixNano()}
+			if i == 2 { // piggybacked on a broadcast
+				ack.Typ, ack.Prop, ack.Acks = Wit, 0, []int{prop.Seq}
+			}
+			local += out + back
+			n.receiveClock(ack)
+			local -= out + back
+		}
+		local += time.Second
+	}
+
+	// With symmetric delays after the first round,
+	// the estimates should be exact.
+	for i := range skew {
+		offset, _, ok := n.PeerOffset(i)
+		if !ok || offset != skew[i] {
+			t.Errorf("peer %v: offset %v, want %v", i, offset, skew[i])
+		}
+	}
+
+	// The median of offsets {-1s, 0, 3s, 1h} is 1.5s.
+	want := base.Add(local + 1500*time.Millisecond)
+	if got := n.Now(); !got.Equal(want) {
+		t.Errorf("Now: got %v, want %v", got, want)
+	}
+}
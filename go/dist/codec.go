@@ -0,0 +1,289 @@
+package dist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Codec selects the wire encoding of a TCP node's connections.
+//
+// GobCodec, the default, encodes the handshake and messages with encoding/gob,
+// whose type descriptions make each connection's first messages large
+// and which only Go peers readily speak.
+// BinaryCodec instead frames each handshake and message
+// as a length-prefixed record of varint fields,
+// which is smaller, cheaper to encode,
+// and simple to implement in other languages:
+//
+//	connection = 0x00 0x01 frame(hello) frame(message)*
+//	frame(x)   = uvarint(len(x)) x
+//	hello      = From Protocol MinProtocol Schema MinSchema [Flags]
+//	message    = From Time Seq uvarint(len(Vec)) Vec... Step Typ Prop Ticket
+//	             uvarint(len(Acks)) Acks...
+//
+// where each field other than a length is a signed varint
+// as encoding/binary encodes it.
+// The hello's optional Flags field has bit 0 set if the connection is
+// multiplexed, and is omitted if zero.
+// The leading zero byte, which never starts a gob stream,
+// lets the receiver detect each connection's codec.
+//
+type Codec int
+
+const (
+	GobCodec    Codec = iota // encoding/gob
+	BinaryCodec              // Compact length-prefixed varint framing
+)
+
+// The preamble of a connection in the binary codec:
+// a byte that never starts a gob stream, then the codec's format version.
+var binaryPreamble = []byte{0x00, 0x01}
+
+// Maximum length of a frame in the binary codec we accept.
+const maxFrame = 1 << 20
+
+var errMalformed = errors.New("malformed binary message")
+
+// An encoder writes a connection's handshake and then its messages.
+// *gob.Encoder implements it.
+type encoder interface {
+	Encode(e interface{}) error
+}
+
+// A decoder reads a connection's handshake and then its messages.
+// *gob.Decoder implements it.
+type decoder interface {
+	Decode(e interface{}) error
+}
+
+// Return an encoder writing to w in codec c.
+func newEncoder(c Codec, w io.Writer) encoder {
+	if c == BinaryCodec {
+		return &binaryEncoder{w: w}
+	}
+	return gob.NewEncoder(w)
+}
+
+// Return a decoder for the connection r,
+// detecting the codec the sender chose from the connection's first byte,
+// and return that codec.
+func newDecoder(r *bufio.Reader) (decoder, Codec, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if b[0] != binaryPreamble[0] {
+		return gob.NewDecoder(r), GobCodec, nil
+	}
+	pre := make([]byte, len(binaryPreamble))
+	if _, err := io.ReadFull(r, pre); err != nil {
+		return nil, 0, err
+	}
+	if pre[1] != binaryPreamble[1] {
+		return nil, 0, fmt.Errorf("unknown binary codec version %d",
+			pre[1])
+	}
+	return &binaryDecoder{r: r}, BinaryCodec, nil
+}
+
+// binaryEncoder writes the binary codec.
+type binaryEncoder struct {
+	w       io.Writer
+	started bool   // whether we wrote the preamble
+	buf     []byte // frame being encoded
+	pay     []byte // payload being encoded
+}
+
+func (e *binaryEncoder) Encode(v interface{}) error {
+	switch v := v.(type) {
+	case *tcpHello:
+		e.pay = appendHello(e.pay[:0], v)
+	case *Message:
+		e.pay = appendMessage(e.pay[:0], v)
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+
+	e.buf = e.buf[:0]
+	if !e.started {
+		e.buf = append(e.buf, binaryPreamble...)
+	}
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(e.pay)))
+	e.buf = append(e.buf, e.pay...)
+	if _, err := e.w.Write(e.buf); err != nil {
+		return err
+	}
+	e.started = true
+	return nil
+}
+
+// binaryDecoder reads the binary codec after its preamble.
+type binaryDecoder struct {
+	r   *bufio.Reader
+	buf []byte // frame being decoded
+}
+
+func (d *binaryDecoder) Decode(v interface{}) error {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if n > maxFrame {
+		return errMalformed
+	}
+	if uint64(cap(d.buf)) < n {
+		d.buf = make([]byte, n)
+	}
+	d.buf = d.buf[:n]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case *tcpHello:
+		return decodeHello(d.buf, v)
+	case *Message:
+		return decodeMessage(d.buf, v)
+	}
+	return fmt.Errorf("cannot decode %T", v)
+}
+
+// Flags in the binary encoding of a handshake.
+const helloMux = 1 // Mux is set
+
+// Append the binary encoding of a handshake to b.
+func appendHello(b []byte, h *tcpHello) []byte {
+	b = binary.AppendVarint(b, int64(h.From))
+	b = binary.AppendVarint(b, int64(h.Version.Protocol))
+	b = binary.AppendVarint(b, int64(h.Version.MinProtocol))
+	b = binary.AppendVarint(b, int64(h.Version.Schema))
+	b = binary.AppendVarint(b, int64(h.Version.MinSchema))
+	if h.Mux {
+		b = binary.AppendVarint(b, helloMux)
+	}
+	return b
+}
+
+// Decode the binary encoding of a handshake into h.
+func decodeHello(b []byte, h *tcpHello) error {
+	r := reader{b: b}
+	h.From = r.int()
+	h.Version.Protocol = r.int()
+	h.Version.MinProtocol = r.int()
+	h.Version.Schema = r.int()
+	h.Version.MinSchema = r.int()
+	h.Mux = false
+	if len(r.b) > 0 {
+		flags := r.int()
+		if flags&^helloMux != 0 {
+			r.err = true
+		}
+		h.Mux = flags&helloMux != 0
+	}
+	return r.done()
+}
+
+// Append the binary encoding of a message to b.
+// Only the fields a node transmits are encoded.
+func appendMessage(b []byte, msg *Message) []byte {
+	b = binary.AppendVarint(b, int64(msg.From))
+	b = binary.AppendVarint(b, msg.Time)
+	b = binary.AppendVarint(b, int64(msg.Seq))
+	b = binary.AppendUvarint(b, uint64(len(msg.Vec)))
+	for _, v := range msg.Vec {
+		b = binary.AppendVarint(b, int64(v))
+	}
+	b = binary.AppendVarint(b, int64(msg.Step))
+	b = binary.AppendVarint(b, int64(msg.Typ))
+	b = binary.AppendVarint(b, int64(msg.Prop))
+	b = binary.AppendVarint(b, int64(msg.Ticket))
+	b = binary.AppendUvarint(b, uint64(len(msg.Acks)))
+	for _, a := range msg.Acks {
+		b = binary.AppendVarint(b, int64(a))
+	}
+	return b
+}
+
+// Decode the binary encoding of a message into msg.
+// As with encoding/gob, empty slices decode as nil.
+func decodeMessage(b []byte, msg *Message) error {
+	r := reader{b: b}
+	msg.From = r.int()
+	msg.Time = r.int64()
+	msg.Seq = r.int()
+	msg.Vec = nil
+	if n := r.len(); n > 0 {
+		msg.Vec = make(vec, n)
+		for i := range msg.Vec {
+			msg.Vec[i] = r.int()
+		}
+	}
+	msg.Step = r.int()
+	msg.Typ = Type(r.int())
+	msg.Prop = r.int()
+	tkt := r.int64()
+	if tkt < math.MinInt32 || tkt > math.MaxInt32 {
+		r.err = true
+	}
+	msg.Ticket = int32(tkt)
+	msg.Acks = nil
+	if n := r.len(); n > 0 {
+		msg.Acks = make([]int, n)
+		for i := range msg.Acks {
+			msg.Acks[i] = r.int()
+		}
+	}
+	return r.done()
+}
+
+// A reader consumes a binary frame,
+// noting any error rather than returning it from each call.
+type reader struct {
+	b   []byte // encoding left to decode
+	err bool   // whether the encoding was malformed
+}
+
+func (r *reader) int64() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = true
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) int() int {
+	v := r.int64()
+	if int64(int(v)) != v {
+		r.err = true
+		return 0
+	}
+	return int(v)
+}
+
+// Decode the length of a slice, each of whose elements takes a byte or more.
+func (r *reader) len() int {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > uint64(len(r.b)-n) {
+		r.err = true
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(v)
+}
+
+// Return an error if the frame was malformed or not fully consumed.
+func (r *reader) done() error {
+	if r.err || len(r.b) != 0 {
+		return errMalformed
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package dist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Messages exercising the codecs, including extreme field values.
+var codecMessages = []Message{
+	{},
+	{From: 3, Time: 1e18, Seq: 42, Vec: vec{1, 2, 3, 0}, Step: 7,
+		Typ: Ack, Prop: 41, Ticket: 12345, Acks: []int{40, 41}},
+	{From: 1, Time: -1, Seq: 1, Vec: vec{math.MaxInt32, -5},
+		Step: math.MaxInt32, Typ: Sync, Prop: -1,
+		Ticket: math.MinInt32},
+	{Ticket: math.MaxInt32, Time: math.MinInt64},
+}
+
+// Write a handshake and messages in codec c and read them back.
+func TestCodec(t *testing.T) {
+	for _, c := range []Codec{GobCodec, BinaryCodec} {
+		buf := &bytes.Buffer{}
+		enc := newEncoder(c, buf)
+		h := tcpHello{From: 2, Version: Version{Protocol: 3,
+			MinProtocol: 1, Schema: 5, MinSchema: 4}, Mux: true}
+		if err := enc.Encode(&h); err != nil {
+			t.Fatal(err)
+		}
+		for i := range codecMessages {
+			if err := enc.Encode(&codecMessages[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec, dc, err := newDecoder(bufio.NewReader(buf))
+		if err != nil || dc != c {
+			t.Fatalf("codec %v detected as %v %v", c, dc, err)
+		}
+		var gh tcpHello
+		if err := dec.Decode(&gh); err != nil || gh != h {
+			t.Errorf("codec %v decoded hello %+v %v", c, gh, err)
+		}
+		for _, msg := range codecMessages {
+			var gm Message
+			if err := dec.Decode(&gm); err != nil ||
+				!reflect.DeepEqual(gm, msg) {
+				t.Errorf("codec %v decoded %+v %v, want %+v",
+					c, gm, err, msg)
+			}
+		}
+	}
+}
+
+// Check that the binary codec rejects malformed messages.
+func TestCodecMalformed(t *testing.T) {
+	b := appendMessage(nil, &codecMessages[1])
+	var msg Message
+	for i := 0; i < len(b); i++ {
+		if err := decodeMessage(b[:i], &msg); err == nil {
+			t.Errorf("accepted message truncated to %v bytes", i)
+		}
+	}
+	if err := decodeMessage(append(b, 0), &msg); err == nil {
+		t.Errorf("accepted message with trailing garbage")
+	}
+	big := make([]byte, 7) // zero fields up to the Ticket
+	big = binary.AppendVarint(big, math.MaxInt32+1)
+	big = append(big, 0)
+	if err := decodeMessage(big, &msg); err == nil {
+		t.Errorf("accepted out-of-range ticket")
+	}
+	huge := append([]byte{}, binaryPreamble...)
+	huge = append(huge, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	dec, _, err := newDecoder(bufio.NewReader(bytes.NewReader(huge)))
+	if err != nil || dec.Decode(&msg) != errMalformed {
+		t.Errorf("accepted oversized frame")
+	}
+	if _, _, err := newDecoder(bufio.NewReader(
+		bytes.NewReader([]byte{0, 9}))); err == nil {
+		t.Errorf("accepted unknown codec version")
+	}
+}
+
+// Run a group over plain TCP whose nodes dial with different codecs.
+func TestTCPCodec(t *testing.T) {
+	const nnodes, steps = 4, 20
+
+	lns := make([]net.Listener, nnodes)
+	hosts := make([]Host, nnodes)
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns[i] = ln
+		hosts[i] = Host{Name: fmt.Sprintf("host%v", i),
+			Addr: ln.Addr().String()}
+	}
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = &TCP{Codec: Codec(i % 2)}
+		group[i] = &nodes[i].Node
+		if err := nodes[i].Start(context.Background(), i, lns[i], hosts,
+			nil, Config{Threshold: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSteps(t, group, steps)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, steps)
+}
+
+// Benchmark encoding and decoding a connection's messages in each codec,
+// reporting the bytes each message takes on the wire.
+func BenchmarkCodec(b *testing.B) {
+	msg := Message{From: 3, Time: 1700000000000000000, Seq: 1234,
+		Vec: vec{1230, 1229, 1231, 1234}, Step: 617, Typ: Prop,
+		Ticket: 987654, Acks: []int{1228, 1229}}
+	for _, c := range []Codec{GobCodec, BinaryCodec} {
+		name := map[Codec]string{GobCodec: "gob",
+			BinaryCodec: "binary"}[c]
+		b.Run(name, func(b *testing.B) {
+			// Measure the first message, after the handshake,
+			// and the second, once gob has sent its types.
+			buf := &bytes.Buffer{}
+			enc := newEncoder(c, buf)
+			size := func() int {
+				n := buf.Len()
+				enc.Encode(&msg)
+				return buf.Len() - n
+			}
+			enc.Encode(&tcpHello{From: 3})
+			first, next := size(), size()
+
+			dec, _, err := newDecoder(bufio.NewReader(buf))
+			if err != nil {
+				b.Fatal(err)
+			}
+			dec.Decode(&tcpHello{})
+			dec.Decode(&Message{})
+			dec.Decode(&Message{})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(&msg); err != nil {
+					b.Fatal(err)
+				}
+				var gm Message
+				if err := dec.Decode(&gm); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(first), "first-B")
+			b.ReportMetric(float64(next), "msg-B")
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package dist
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Default delay before racing a connection attempt to a peer's next address,
+// as RFC 8305 recommends.
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// Resolve the host name in TCP address addr, unless it is an IP address,
+// returning the addresses to attempt in Happy Eyeballs order.
+func (t *TCP) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{addr}, nil
+	}
+
+	lookup := t.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host,
+			IsNotFound: true}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range happyOrder(ips) {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return addrs, nil
+}
+
+// Order IP addresses for Happy Eyeballs (RFC 8305),
+// alternating between IPv6 and IPv4 addresses,
+// starting with the family of the resolver's first preference
+// and otherwise keeping the resolver's order.
+func happyOrder(ips []string) []string {
+	var v6, v4 []string
+	for _, ip := range ips {
+		if a, err := netip.ParseAddr(ip); err == nil && a.Unmap().Is4() {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v6, v4
+	if len(v4) > 0 && v4[0] == ips[0] {
+		first, second = v4, v6
+	}
+	ord := make([]string, 0, len(ips))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			ord, first = append(ord, first[0]), first[1:]
+		}
+		if len(second) > 0 {
+			ord, second = append(ord, second[0]), second[1:]
+		}
+	}
+	return ord
+}
+
+// Dial a TCP connection to one of addrs, racing attempts as Happy Eyeballs does:
+// each attempt starts delay after the previous one, or as soon as it fails,
+// and the first connection established wins.
+// A negative delay makes each attempt wait for the previous one to fail.
+func dialRace(ctx context.Context, addrs []string, delay time.Duration) (
+	net.Conn, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			d := net.Dialer{}
+			c, err := d.DialContext(ctx, "tcp", addr)
+			results <- result{c, err}
+		}()
+	}
+
+	var errs []error
+	start()
+	for pending > 0 {
+		var fallback *time.Timer
+		var wait <-chan time.Time
+		if next < len(addrs) && delay >= 0 {
+			fallback = time.NewTimer(delay)
+			wait = fallback.C
+		}
+		var r result
+		select {
+		case r = <-results:
+		case <-wait:
+			start()
+			continue
+		}
+		if fallback != nil {
+			fallback.Stop()
+		}
+
+		pending--
+		if r.err == nil {
+			// Close the connections of attempts that lose the race.
+			go func(n int) {
+				for ; n > 0; n-- {
+					if r := <-results; r.c != nil {
+						r.c.Close()
+					}
+				}
+			}(pending)
+			return r.c, nil
+		}
+		errs = append(errs, r.err)
+		if next < len(addrs) {
+			start()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Return true if TCP addresses a and b are the same once parsed.
+func sameAddr(a, b string) bool {
+	pa, erra := netip.ParseAddrPort(a)
+	pb, errb := netip.ParseAddrPort(b)
+	if erra != nil || errb != nil {
+		return a == b
+	}
+	return pa.Addr().Unmap() == pb.Addr().Unmap() && pa.Port() == pb.Port()
+}
+
+// Periodically re-resolve the host names of the peers we dial,
+// so that their senders replace connections to addresses
+// the names no longer resolve to.
+func (t *TCP) runResolve(ctx context.Context) {
+	defer t.wg.Done()
+
+	tick := time.NewTicker(t.Resolve)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, tp := range t.peers {
+			if tp != nil && tp.dials {
+				tp.recheck(ctx)
+			}
+		}
+	}
+}
+
+// Check that the peer's host name still resolves to the address
+// we last dialed it at, and if not, wake the sender to redial it.
+// While the name fails to resolve, we keep the connection we have.
+func (tp *tcpPeer) recheck(ctx context.Context) {
+	tp.mut.Lock()
+	raddr := tp.raddr
+	tp.mut.Unlock()
+	if raddr == "" {
+		return
+	}
+
+	addrs, err := tp.t.resolve(ctx, tp.host.Addr)
+	if err != nil {
+		return
+	}
+	for _, a := range addrs {
+		if sameAddr(a, raddr) {
+			return
+		}
+	}
+
+	tp.mut.Lock()
+	defer tp.mut.Unlock()
+	if tp.raddr == raddr {
+		tp.moved = true
+		tp.cond.Broadcast()
+	}
+}
@@ -0,0 +1,214 @@
+package dist
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHappyOrder(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"1.1.1.1", "1.1.1.1"},
+		{"::1 ::2 1.1.1.1 2.2.2.2 3.3.3.3",
+			"::1 1.1.1.1 ::2 2.2.2.2 3.3.3.3"},
+		{"1.1.1.1 2.2.2.2 ::1", "1.1.1.1 ::1 2.2.2.2"},
+		{"::ffff:1.1.1.1 ::1", "::ffff:1.1.1.1 ::1"},
+	} {
+		out := happyOrder(strings.Fields(c.in))
+		if !reflect.DeepEqual(out, strings.Fields(c.out)) {
+			t.Errorf("happyOrder(%v) = %v, want %v", c.in, out, c.out)
+		}
+	}
+}
+
+// Return an address on which nothing listens.
+func closedAddr(t *testing.T, ip string) string {
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func TestDialRace(t *testing.T) {
+	ctx := context.Background()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	good, bad := ln.Addr().String(), closedAddr(t, "127.0.0.1")
+
+	// A failed attempt falls back to the next address immediately,
+	// however long the fallback delay.
+	for _, delay := range []time.Duration{-1, time.Hour} {
+		c, err := dialRace(ctx, []string{bad, good}, delay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.RemoteAddr().String() != good {
+			t.Errorf("dialed %v, want %v", c.RemoteAddr(), good)
+		}
+		c.Close()
+	}
+	if _, err := dialRace(ctx, []string{bad, bad}, 0); err == nil {
+		t.Errorf("dialed unreachable addresses")
+	}
+}
+
+// A listener accepting connections from several listeners.
+type multiListener struct {
+	lns   []net.Listener
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newMultiListener(lns ...net.Listener) *multiListener {
+	ml := &multiListener{lns: lns, conns: make(chan net.Conn),
+		done: make(chan struct{})}
+	for _, ln := range lns {
+		ln := ln
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				select {
+				case ml.conns <- c:
+				case <-ml.done:
+					c.Close()
+					return
+				}
+			}
+		}()
+	}
+	return ml
+}
+
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ml.conns:
+		return c, nil
+	case <-ml.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ml *multiListener) Close() error {
+	ml.once.Do(func() {
+		close(ml.done)
+		for _, ln := range ml.lns {
+			ln.Close()
+		}
+	})
+	return nil
+}
+
+func (ml *multiListener) Addr() net.Addr {
+	return ml.lns[0].Addr()
+}
+
+// Run a group over TCP whose hosts are named rather than numbered,
+// with dual-stack names whose IPv6 addresses are unreachable,
+// and move one node to a new address while the group runs.
+func TestTCPResolve(t *testing.T) {
+	const nnodes = 3
+
+	lns := make([]net.Listener, nnodes)
+	hosts := make([]Host, nnodes)
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns[i] = ln
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		hosts[i] = Host{Addr: net.JoinHostPort(
+			"node"+string(rune('0'+i))+".test", port)}
+	}
+
+	// The last node also listens on its new address.
+	_, port, _ := net.SplitHostPort(lns[nnodes-1].Addr().String())
+	ln2, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		t.Skip("cannot listen on 127.0.0.2:", err)
+	}
+	lns[nnodes-1] = newMultiListener(lns[nnodes-1], ln2)
+
+	mut := sync.Mutex{}
+	moved := false
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		if host == hosts[nnodes-1].Addr[:len("node0.test")] && moved {
+			return []string{"127.0.0.2"}, nil
+		}
+		return []string{"::1", "127.0.0.1"}, nil
+	}
+
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = &TCP{Lookup: lookup, Resolve: 10 * time.Millisecond}
+		group[i] = &nodes[i].Node
+		if err := nodes[i].Start(context.Background(), i, lns[i], hosts,
+			nil, Config{Threshold: nnodes}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSteps(t, group, 10)
+
+	// Once the last node's name resolves to its new address,
+	// its peers redial it there.
+	mut.Lock()
+	moved = true
+	mut.Unlock()
+	last := nodes[nnodes-1]
+	deadline := time.Now().Add(time.Minute)
+	for !onlyAt(last, "127.0.0.2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("peers did not redial moved node")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitSteps(t, group, 30)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, 30)
+}
+
+// Return true if all of a node's accepted connections from its peers,
+// of which there must be some, are to local IP address ip.
+func onlyAt(n *TCP, ip string) bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	peers := 0
+	for c, tc := range n.conns {
+		if tc.from < 0 {
+			continue
+		}
+		peers++
+		if h, _, _ := net.SplitHostPort(c.LocalAddr().String()); h != ip {
+			return false
+		}
+	}
+	return peers > 0
+}
@@ -8,26 +8,22 @@ import (
 	crand "crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/gob"
 	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"fmt"
 	"io"
 	"math/big"
-	mrand "math/rand"
 	"net"
 	"os"
 	"os/exec"
 	"sync"
 	"testing"
 	"time"
-)
-
-// MaxSteps to take
-var MaxSteps int
 
-// Maximum random delays to add to message deliveries for testing
-var MaxSleep time.Duration
+	"github.com/dedis/tlc/go/lib/chaos"
+	"github.com/dedis/tlc/go/lib/stats"
+)
 
 // Whether to run consensus among multiple separate processes
 var MultiProcess = true
@@ -35,6 +31,38 @@ var MultiProcess = true
 // Whether to use TLS encryption and authentication atop TCP
 var UseTLS = true
 
+// The -chaos test flag enables chaos mode with the given seed,
+// or a random seed if negative, inserting random scheduling jitter
+// at each message delivery and around each node's mutex.
+// Chaos mode is most effective together with the -race flag.
+var chaosSeed = flag.Int64("chaos", 0,
+	"seed for random scheduling jitter, -1 for random, 0 to disable")
+
+// The -stats test flag names a CSV file in which the test harness
+// records per-round statistics of each consensus test case it runs,
+// for analysis as the stats package describes.
+var statsPath = flag.String("stats", "",
+	"CSV file in which to record per-round statistics")
+
+var statsOnce sync.Once
+var statsOut *stats.Writer
+
+// Return the Writer recording per-round statistics, or nil if disabled.
+func testStatsWriter(t *testing.T) *stats.Writer {
+	statsOnce.Do(func() {
+		if *statsPath == "" {
+			return
+		}
+		f, err := os.Create(*statsPath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		statsOut = stats.NewWriter(f)
+	})
+	return statsOut
+}
+
 // Information about each virtual host passed to child processes via JSON
 type testHost struct {
 	Name string // Virtual host name
@@ -42,15 +70,24 @@ type testHost struct {
 	Cert []byte // Host's self-signed x509 certificate
 }
 
+// A child's record of one consensus round it completed
+type testRound struct {
+	Best   int  // Best proposal the child chose in this round
+	Commit bool // Whether the child observed successful commitment
+	Sent   int  // Messages the child broadcast in the round's first step
+}
+
 // Configuration information each child goroutine or process needs to launch
 type testConfig struct {
 	Self     int    // Which participant number we are
 	Nnodes   int    // Total number of participants
 	HostName string // This child's virtual hostname
 
-	MaxSteps  int
-	MaxTicket int32
-	MaxSleep  time.Duration
+	Threshold int           // TLC and consensus threshold
+	MaxSteps  int           // Number of time steps to run
+	MaxTicket int32         // Amount of entropy in lottery tickets
+	MaxSleep  time.Duration // Maximum random delay to add to deliveries
+	Chaos     int64         // Chaos mode seed, or 0 if disabled
 }
 
 func TestQSC(t *testing.T) {
@@ -90,21 +127,51 @@ func testCase(t *testing.T, threshold, nnodes, maxSteps, maxTicket int,
 		maxTicket = 10 * nnodes
 	}
 
+	// Choose one chaos mode seed for all the nodes.
+	seed := chaos.New(*chaosSeed, 0).Seed()
+
 	desc := fmt.Sprintf("T=%v,N=%v,Steps=%v,Tickets=%v,Sleep=%v",
 		threshold, nnodes, maxSteps, maxTicket, maxSleep)
+	if seed != 0 {
+		desc += fmt.Sprintf(",Chaos=%v", seed)
+	}
 	t.Run(desc, func(t *testing.T) {
 
 		// Configure and run the test case.
-		MaxSteps = maxSteps
-		MaxTicket = int32(maxTicket)
-		MaxSleep = maxSleep
-		Threshold = threshold
+		group := testConfig{
+			Threshold: threshold,
+			MaxSteps:  maxSteps,
+			MaxTicket: int32(maxTicket),
+			MaxSleep:  maxSleep,
+			Chaos:     seed,
+		}
+		testExec(t, group, nnodes, MultiProcess)
+	})
+}
 
-		testExec(t, threshold, nnodes)
+// Run two independent consensus groups with different configurations
+// concurrently within this one process, to check that they share no state.
+func TestMultiGroup(t *testing.T) {
+	t.Run("Groups", func(t *testing.T) {
+		t.Run("T=2,N=3", func(t *testing.T) {
+			t.Parallel()
+			testExec(t, testConfig{Threshold: 2, MaxSteps: 100,
+				MaxTicket: 30,
+				Chaos:     chaos.New(*chaosSeed, 0).Seed()}, 3, false)
+		})
+		t.Run("T=3,N=5", func(t *testing.T) {
+			t.Parallel()
+			testExec(t, testConfig{Threshold: 3, MaxSteps: 100,
+				MaxTicket: 50, MaxSleep: time.Microsecond,
+				Chaos: chaos.New(*chaosSeed, 0).Seed()}, 5, false)
+		})
 	})
 }
 
-func testExec(t *testing.T, threshold, nnodes int) {
+// Run one consensus group of nnodes nodes, with the group configuration
+// in the relevant fields of group, either as separate child processes
+// or as goroutines within this process according to multiProcess.
+func testExec(t *testing.T, group testConfig, nnodes int, multiProcess bool) {
 
 	// Create a cancelable context in which to execute helper processes
 	ctx, cancel := context.WithCancel(context.Background())
@@ -116,9 +183,11 @@ func testExec(t *testing.T, threshold, nnodes int) {
 		conf[i].Self = i
 		conf[i].Nnodes = nnodes
 		conf[i].HostName = fmt.Sprintf("host%v", i)
-		conf[i].MaxSteps = MaxSteps
-		conf[i].MaxTicket = MaxTicket
-		conf[i].MaxSleep = MaxSleep
+		conf[i].Threshold = group.Threshold
+		conf[i].MaxSteps = group.MaxSteps
+		conf[i].MaxTicket = group.MaxTicket
+		conf[i].MaxSleep = group.MaxSleep
+		conf[i].Chaos = group.Chaos
 	}
 
 	// Start the per-node child processes,
@@ -130,7 +199,8 @@ func testExec(t *testing.T, threshold, nnodes int) {
 	for i := range host {
 
 		childGroup.Add(1)
-		childIn, childOut := testExecChild(ctx, &conf[i], t, childGroup)
+		childIn, childOut := testExecChild(ctx, &conf[i], t, childGroup,
+			multiProcess)
 
 		// We'll communicate with the child via JSON-encoded stdin/out
 		enc[i] = json.NewEncoder(childIn)
@@ -159,12 +229,13 @@ func testExec(t *testing.T, threshold, nnodes int) {
 	}
 
 	// Wait and collect the consensus histories of each child
-	hist := make([][]choice, nnodes)
+	hist := make([][]testRound, nnodes)
 	for i := range host {
 		if err := dec[i].Decode(&hist[i]); err != nil {
 			t.Fatalf("Decode: %v", err.Error())
 		}
 	}
+	testStats(t, group, hist)
 
 	// Let all the children know they can exit
 	for i := range host {
@@ -177,11 +248,35 @@ func testExec(t *testing.T, threshold, nnodes int) {
 	childGroup.Wait()
 }
 
+// Record per-round statistics of the histories the children of a group
+// reported, if the -stats flag is set.
+func testStats(t *testing.T, group testConfig, hist [][]testRound) {
+	w := testStatsWriter(t)
+	if w == nil {
+		return
+	}
+	for i := range hist {
+		for s, r := range hist[i] {
+			err := w.Write(stats.Round{Run: t.Name(),
+				Nodes: len(hist), Threshold: group.Threshold,
+				Entropy: stats.Entropy(int64(group.MaxTicket)),
+				Node:    i, Step: s, Commit: r.Commit,
+				Chosen: r.Best, Messages: r.Sent})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Exec a child as a separate process.
 func testExecChild(ctx context.Context, conf *testConfig, t *testing.T,
-	grp *sync.WaitGroup) (io.Writer, io.Reader) {
+	grp *sync.WaitGroup, multiProcess bool) (io.Writer, io.Reader) {
 
-	if !MultiProcess {
+	if !multiProcess {
 		// Run a child as a separate goroutine in the same process.
 		childInRd, childInWr := io.Pipe()
 		childOutRd, childOutWr := io.Pipe()
@@ -330,15 +425,6 @@ func testChild(in io.Reader, out io.Writer) {
 		panic("Decode: " + err.Error())
 	}
 	self := conf.Self
-	MaxSteps = conf.MaxSteps
-	MaxTicket = conf.MaxTicket
-	MaxSleep = conf.MaxSleep
-
-	// Initialize the node appropriately
-	//println("self", self, "nnodes", conf.Nnodes)
-	n := &Node{}
-	n.init(self, make([]peer, conf.Nnodes))
-	n.mutex.Lock() // keep node's TLC state locked until fully set up
 
 	// Create a TLS/TCP listen socket for this child
 	tcpl, err := net.Listen("tcp", "")
@@ -347,7 +433,6 @@ func testChild(in io.Reader, out io.Writer) {
 	}
 
 	// Create an x509 certificate and private key for this child
-	//println(self, "createCert for", conf.HostName)
 	certb, privb := createCert(conf.HostName)
 
 	// Create a TLS certificate from it
@@ -374,199 +459,56 @@ func testChild(in io.Reader, out io.Writer) {
 
 	// Create a certificate pool containing all nodes' certificates
 	pool := x509.NewCertPool()
+	hosts := make([]Host, len(host))
 	for i := range host {
 		if !pool.AppendCertsFromPEM(host[i].Cert) {
 			panic("failed to append cert from " + host[i].Name)
 		}
+		hosts[i] = Host{Name: host[i].Name, Addr: host[i].Addr}
 	}
-
-	//println("hostName", conf.HostName, "pool", len(pool.Subjects()))
-
-	// Listen and accept TCP/TLS connections
-	donegrp := &sync.WaitGroup{}
-	go func() {
-		for {
-			// Accept a TCP connection
-			tcpc, err := tcpl.Accept()
-			if err != nil {
-				panic("Accept: " + err.Error())
-			}
-
-			// Launch a goroutine to process it
-			donegrp.Add(1)
-			go n.acceptNetwork(tcpc, &tls.Config{
-				RootCAs:      pool,
-				Certificates: []tls.Certificate{tlscert},
-				ServerName:   conf.HostName,
-				ClientAuth:   tls.RequireAndVerifyClientCert,
-				ClientCAs:    pool,
-			}, host, donegrp)
-		}
-	}()
-
-	// Open TCP and optionally TLS connections to each peer
-	//println(self, "open TLS connections to", len(host), "peers")
-	stepgrp := &sync.WaitGroup{}
-	for i := range host {
-		// Open an authenticated TLS connection to peer i
-		peerConf := tls.Config{
-			RootCAs:      pool,
-			Certificates: []tls.Certificate{tlscert},
-			ServerName:   conf.HostName,
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-			ClientCAs:    pool,
-		}
-		peerConf.ServerName = host[i].Name
-		//println(self, "Dial", host[i].Name, host[i].Addr)
-		var conn net.Conn
-		if UseTLS {
-			conn, err = tls.Dial("tcp", host[i].Addr, &peerConf)
-		} else {
-			conn, err = net.Dial("tcp", host[i].Addr)
-		}
-		if err != nil {
-			panic("Dial: " + err.Error())
-		}
-
-		// Tell the server which client we are.
-		enc := gob.NewEncoder(conn)
-		if err := enc.Encode(self); err != nil {
-			panic("gob.Encode: " + err.Error())
-		}
-
-		// Set up a peer sender object.
-		// It signals stepgrp.Done() after enough steps pass.
-		stepgrp.Add(1)
-		n.peer[i] = &testPeer{enc, stepgrp, conn}
+	var tlsConf *tls.Config
+	if UseTLS {
+		tlsConf = TLSConfig(tlscert, pool)
 	}
-	//println(self, "opened TLS connections")
-
-	// Start the consensus test
-	n.advanceTLC(0)
 
-	// Now we can let the receive goroutines process incoming messages
-	n.mutex.Unlock()
-
-	// Wait to finish enough consensus rounds
-	//println(self, "wait for test to complete")
-	stepgrp.Wait()
-
-	// Report our observed consensus history to the parent
-	if err := enc.Encode(n.choice); err != nil {
-		panic("Encode: " + err.Error())
+	// Start the node, perturbing its scheduling in chaos mode,
+	// each node differently.
+	n := &TCP{Jitter: conf.MaxSleep}
+	if conf.Chaos != 0 {
+		n.Yield = chaos.New(conf.Chaos+int64(self), 0).Point
 	}
-
-	// Finally, wait for our parent to signal when the test is complete.
-	if err := dec.Decode(&struct{}{}); err != nil {
-		panic("Decode: " + err.Error())
+	err = n.Start(context.Background(), self, tcpl, hosts, tlsConf,
+		Config{Threshold: conf.Threshold, MaxTicket: conf.MaxTicket,
+			Strict: true})
+	if err != nil {
+		panic("Start: " + err.Error())
 	}
+	defer n.Stop()
 
-	//println(self, "child finished")
-}
-
-// Accept a new TLS connection on a TCP server socket.
-func (n *Node) acceptNetwork(conn net.Conn, tlsConf *tls.Config,
-	host []testHost, donegrp *sync.WaitGroup) {
-
-	// Enable TLS on the connection and run the handshake.
-	if UseTLS {
-		conn = tls.Server(conn, tlsConf)
+	// Wait to finish enough consensus rounds
+	for n.step() < conf.MaxSteps {
+		time.Sleep(time.Millisecond)
 	}
-	defer func() { conn.Close() }()
 
-	// Receive the client's nodenumber indication
-	dec := gob.NewDecoder(conn)
-	var peer int
-	if err := dec.Decode(&peer); err != nil {
-		println(n.self, "acceptNetwork gob.Decode: "+err.Error())
-		return
-		//panic("acceptNetwork gob.Decode: " + err.Error())
-	}
-	if peer < 0 || peer >= len(host) {
-		println("acceptNetwork: bad peer number")
-		return
+	// Report our observed consensus history to the parent,
+	// with the number of messages we broadcast in each round's first step.
+	n.mutex.Lock()
+	hist := make([]testRound, len(n.choice))
+	for i, c := range n.choice {
+		hist[i] = testRound{Best: c.best, Commit: c.commit}
 	}
-
-	// Authenticate the client with TLS.
-	// XXX Why doesn't VerifyHostname work to verify a client auth?
-	// Go TLS bug to report?
-	//if err := tlsc.VerifyHostname(host[peer].Name); err != nil {
-	//	panic("VerifyHostname: " + err.Error())
-	//}
-	if UseTLS {
-		cs := conn.(*tls.Conn).ConnectionState()
-		if len(cs.PeerCertificates) < 1 {
-			println("acceptNetwork: no certificate from client")
-			return
-		}
-		err := cs.PeerCertificates[0].VerifyHostname(host[peer].Name)
-		if err != nil {
-			println("VerifyHostname: " + err.Error())
-			return
+	for seq := 0; seq < n.logLen(self); seq++ {
+		if s := n.logged(self, seq).Step; s < len(hist) {
+			hist[s].Sent++
 		}
 	}
-
-	// Receive and process arriving messages
-	n.runReceiveNetwork(peer, dec, donegrp)
-}
-
-// Receive messages from a connection and dispatch them into the TLC stack.
-func (n *Node) runReceiveNetwork(peer int, dec *gob.Decoder,
-	grp *sync.WaitGroup) {
-	for {
-		// Get next message from this peer
-		msg := Message{}
-		err := dec.Decode(&msg)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic("receiveCausal:" + err.Error())
-		}
-		//println(n.self, n.tmpl.Step, "runReceiveNetwork: recv from",
-		//	msg.From, "type", msg.Typ, "seq", msg.Seq,
-		//	"step", msg.Step)
-
-		// Optionally insert random delays on a message basis
-		time.Sleep(time.Duration(mrand.Int63n(int64(MaxSleep + 1))))
-
-		grp.Add(1)
-		go n.receiveNetwork(&msg, grp)
+	n.mutex.Unlock()
+	if err := enc.Encode(hist); err != nil {
+		panic("Encode: " + err.Error())
 	}
-	grp.Done() // signal that we're done
-}
 
-func (n *Node) receiveNetwork(msg *Message, grp *sync.WaitGroup) {
-
-	// Keep the stack single-threaded.
-	n.mutex.Lock()
-	defer func() {
-		n.mutex.Unlock()
-		grp.Done()
-	}()
-
-	// Dispatch up to the causal ordering layer
-	//println(n.self, n.tmpl.Step, "receiveNetwork from", msg.From,
-	//	"type", msg.Typ,  "seq", msg.Seq, "vec", len(msg.Vec))
-	n.receiveCausal(msg)
-}
-
-type testPeer struct {
-	e *gob.Encoder
-	w *sync.WaitGroup
-	c io.Closer
-}
-
-func (tp *testPeer) Send(msg *Message) {
-	if tp.e != nil {
-		//println("testPeer.Send seq", msg.Seq, "step", msg.Step,
-		//	"MaxSteps", MaxSteps)
-		if err := tp.e.Encode(msg); err != nil {
-			println("Encode:", err.Error())
-		}
-	}
-	if tp.w != nil && MaxSteps > 1 && msg.Step >= MaxSteps {
-		//println("testPeer.Send done")
-		tp.w.Done()
-		tp.w = nil
+	// Finally, wait for our parent to signal when the test is complete.
+	if err := dec.Decode(&struct{}{}); err != nil {
+		panic("Decode: " + err.Error())
 	}
 }
@@ -1,5 +1,19 @@
 // Package dist implements a minimalistic distributed implementation
 // of TLC and QSC for the non-Byzantine (fail-stop) threat model.
-// It uses TLS/TCP for communication, gob encoding for serialization, and
-// vector time and a basic causal ordering protocol using vector time.
+// The TCP node uses TLS/TCP for communication,
+// gob or a compact binary encoding for serialization,
+// and vector time and a basic causal ordering protocol using vector time.
+// TCP nodes may name their peers by host name, dialing dual-stack peers
+// Happy Eyeballs style and re-resolving names as peers move,
+// and may start from a group configuration file (see package config).
+// The UDP node instead communicates over unreliable datagrams,
+// with its own message-level acknowledgment and retransmission.
+// Local runs a whole group within one process, connected by channels.
+// Each node's HistoryPolicy bounds how much protocol history it retains.
+// Observer nodes track a group's consensus without participating.
+// Nodes may probe their peers' liveness and pause proposing without a quorum.
+// A node restarted from its persisted state rejoins its group
+// by exchanging only the messages it missed with its peers.
+// Nodes piggyback their acknowledgments of proposals on their own broadcasts
+// whenever they send both while handling the same message.
 package dist
@@ -0,0 +1,62 @@
+package dist
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrProtocol is the error a ProtocolError wraps,
+// so that callers may detect protocol violations with errors.Is.
+var ErrProtocol = errors.New("protocol violation")
+
+// ProtocolError reports a message that violates the protocol,
+// such as one a faulty or malicious peer sent,
+// which a node drops instead of acting on unless its Config is Strict.
+type ProtocolError struct {
+	Peer   int    // Node number the message claims to be from
+	Seq    int    // Sequence number of the message
+	Reason string // What is wrong with the message
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%v: node %d message %d: %s",
+		ErrProtocol, e.Peer, e.Seq, e.Reason)
+}
+
+// Unwrap returns ErrProtocol.
+func (e *ProtocolError) Unwrap() error {
+	return ErrProtocol
+}
+
+// Report that msg violates the protocol for the given reason,
+// so that the caller must drop it,
+// or panic if the node is strict.
+func (n *Node) fault(msg *Message, reason string) {
+	err := &ProtocolError{Peer: msg.From, Seq: msg.Seq, Reason: reason}
+	if n.strict {
+		panic(err.Error())
+	}
+	if n.onFault != nil {
+		n.onFault(msg.From, err)
+	}
+}
+
+// Return true if msg is well-formed enough for us to handle safely,
+// reporting a fault otherwise.
+func (n *Node) validMessage(msg *Message) bool {
+	switch {
+	case msg.From < 0 || msg.From >= len(n.peer):
+		n.fault(msg, "unknown sender")
+	case msg.Typ < Prop || msg.Typ > Sync:
+		n.fault(msg, "unknown message type")
+	case msg.Seq < 0:
+		n.fault(msg, "negative sequence number")
+	case msg.causal() && len(msg.Vec) != len(n.peer):
+		n.fault(msg, "vector clock of the wrong size")
+	case msg.Typ == Wit && (msg.Prop < 0 || msg.Prop >= msg.Seq):
+		n.fault(msg, "witnesses no earlier message")
+	default:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package dist
+
+import (
+	"errors"
+	"testing"
+)
+
+// Test that a node drops messages violating the protocol,
+// reporting them instead of panicking unless it is strict.
+func TestFault(t *testing.T) {
+	var faults []error
+	conf := Config{Threshold: 2,
+		Fault: func(peer int, err error) {
+			faults = append(faults, err)
+		}}
+	n := &Node{}
+	n.init(0, []peer{&recPeer{}, &recPeer{}, &recPeer{}}, conf)
+	n.advanceTLC(0)
+
+	msgs := []struct {
+		msg   Message
+		fault bool
+	}{
+		{Message{From: 3, Typ: Prop, Vec: vec{0, 0, 0}}, true},
+		{Message{From: 1, Typ: 9}, true},
+		{Message{From: 1, Typ: Prop, Vec: vec{0, 0}}, true},
+		{Message{From: 1, Seq: 1, Typ: Wit, Prop: 1,
+			Vec: vec{0, 1, 0}}, true}, // witnesses itself
+
+		// A proposal, its witness, and a witness of that witness
+		{Message{From: 1, Typ: Prop, Vec: vec{0, 0, 0}}, false},
+		{Message{From: 1, Seq: 1, Typ: Wit, Prop: 0,
+			Vec: vec{0, 1, 0}}, false},
+		{Message{From: 1, Seq: 2, Typ: Wit, Prop: 1,
+			Vec: vec{0, 2, 0}}, true},
+
+		// Two proposals for the same step, and a duplicate
+		{Message{From: 2, Typ: Prop, Vec: vec{0, 0, 0}}, false},
+		{Message{From: 2, Seq: 1, Typ: Prop, Vec: vec{0, 0, 1}}, true},
+		{Message{From: 2, Typ: Prop, Vec: vec{0, 0, 0}}, false},
+	}
+	nfault := 0
+	for _, m := range msgs {
+		msg := getMessage()
+		*msg = m.msg
+		n.receiveMessage(msg)
+		if m.fault {
+			nfault++
+		}
+		if len(faults) != nfault {
+			t.Fatalf("message %+v: faults %v", m.msg, faults)
+		}
+	}
+	var pe *ProtocolError
+	if !errors.As(faults[0], &pe) || pe.Peer != 3 ||
+		!errors.Is(faults[0], ErrProtocol) {
+		t.Errorf("fault %v", faults[0])
+	}
+
+	// A strict node panics instead.
+	conf.Strict = true
+	n = &Node{}
+	n.init(0, []peer{&recPeer{}, &recPeer{}, &recPeer{}}, conf)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("strict node accepted a malformed message")
+		}
+	}()
+	msg := getMessage()
+	*msg = msgs[0].msg
+	n.receiveMessage(msg)
+}
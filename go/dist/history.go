@@ -0,0 +1,180 @@
+package dist
+
+// HistoryPolicy decides how much protocol history a Node retains:
+// its causal log of messages, its log of each node's view
+// at the start of each time step, and its record of consensus decisions.
+//
+// At each time step the Node asks its policy for a horizon,
+// the earliest time step whose history it must keep,
+// and discards the history of earlier steps.
+// The Node never discards history the protocol itself still needs,
+// however eager the policy, and never reclaims discarded history,
+// so a policy's horizon is effectively only ever advanced.
+//
+type HistoryPolicy interface {
+	Horizon(p Progress) int
+}
+
+// Progress summarizes a Node's progress, for its HistoryPolicy.
+type Progress struct {
+	Step     int // Time step the node has just advanced to
+	Commit   int // Start step of the latest round it saw commit, or -1
+	Consumed int // Latest step the application consumed, or -1
+}
+
+// KeepAll is a HistoryPolicy that retains all history,
+// which Nodes use by default.
+type KeepAll struct{}
+
+// Horizon returns zero, the start of time.
+func (KeepAll) Horizon(p Progress) int {
+	return 0
+}
+
+// KeepLastKRounds is a HistoryPolicy that retains the history
+// of the given number of most recent consensus rounds.
+type KeepLastKRounds int
+
+// Horizon returns the start of the k-th most recent round.
+func (k KeepLastKRounds) Horizon(p Progress) int {
+	return p.Step - int(k)*RoundSteps
+}
+
+// KeepSinceCommit is a HistoryPolicy that retains the history
+// since the start of the latest round the Node saw commit.
+type KeepSinceCommit struct{}
+
+// Horizon returns the start of the latest committed round.
+func (KeepSinceCommit) Horizon(p Progress) int {
+	return p.Commit
+}
+
+// KeepUnconsumed is a HistoryPolicy that retains the history
+// the application has not yet consumed, as reported via Node.Consume,
+// e.g., until it has durably stored the consensus decisions.
+type KeepUnconsumed struct{}
+
+// Horizon returns the step after the latest consumed step.
+func (KeepUnconsumed) Horizon(p Progress) int {
+	return p.Consumed + 1
+}
+
+// Consume informs the Node that the application has durably consumed
+// the results of all time steps up to and including step,
+// allowing a HistoryPolicy to discard their history.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) Consume(step int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if step > n.consumed {
+		n.consumed = step
+	}
+}
+
+// Decision returns the Node's decision in the consensus round
+// that started at time step s:
+// the node whose proposal it chose, and whether it saw the round commit.
+// Returns ok false if the round has not completed,
+// or if its history has been discarded.
+func (n *Node) Decision(s int) (best int, commit, ok bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	i := s - n.choiceBase
+	if i < 0 || i >= len(n.choice) {
+		return 0, false, false
+	}
+	return n.choice[i].best, n.choice[i].commit, true
+}
+
+// Initialize the history layer state in a Node.
+func (n *Node) initHistory(policy HistoryPolicy) {
+	if policy == nil {
+		policy = KeepAll{}
+	}
+	n.policy = policy
+	n.horizon = 0
+	n.consumed = -1
+	n.commit = -1
+	n.seqBase = make([]int, len(n.peer))
+	n.stepBase = make([]int, len(n.peer))
+	n.choiceBase = 0
+}
+
+// Return the message a peer sent with a given sequence number,
+// or nil if it is no longer in our causal log.
+func (n *Node) logged(peer, seq int) *Message {
+	if seq < n.seqBase[peer] {
+		return nil
+	}
+	return n.seqLog[peer][seq-n.seqBase[peer]]
+}
+
+// Return the number of messages from a peer we have ever logged.
+func (n *Node) logLen(peer int) int {
+	return n.seqBase[peer] + len(n.seqLog[peer])
+}
+
+// Discard history before the horizon our policy chooses,
+// upon advancing to a new time step.
+func (n *Node) pruneHistory() {
+	h := n.policy.Horizon(Progress{n.tmpl.Step, n.commit, n.consumed})
+	if h > n.save {
+		h = n.save // the protocol still needs everything since save
+	}
+	if h <= n.horizon {
+		return
+	}
+	n.horizon = h
+
+	for i := range n.peer {
+
+		// Keep any message some node might not yet have seen,
+		// since we may yet need to record it as seen by that node.
+		// Nodes that have never sent anything, such as observers,
+		// need not hold us back: should they start sending,
+		// their views need only what we retain.
+		keep := n.logLen(i)
+		for j, v := range n.mat {
+			if j != n.self && n.logLen(j) == 0 {
+				continue
+			}
+			if v[i] < keep {
+				keep = v[i]
+			}
+		}
+		d := 0
+		for d < keep-n.seqBase[i] && n.seqLog[i][d].Step < h {
+			d++
+		}
+		n.seqLog[i] = trim(n.seqLog[i], d)
+		n.seqBase[i] += d
+
+		d = h - n.stepBase[i]
+		if d > len(n.stepLog[i]) {
+			d = len(n.stepLog[i])
+		}
+		if d < 0 {
+			d = 0 // a promoted observer's log starts after h
+		}
+		n.stepLog[i] = trim(n.stepLog[i], d)
+		n.stepBase[i] += d
+	}
+
+	if d := h - n.choiceBase; d > 0 {
+		if d > len(n.choice) {
+			d = len(n.choice)
+		}
+		n.choice = trim(n.choice, d)
+		n.choiceBase += d
+	}
+}
+
+// Drop the first d elements of a log in place, so that the log's array
+// neither grows without bound nor keeps dropped elements alive.
+func trim[T any](log []T, d int) []T {
+	n := copy(log, log[d:])
+	clear(log[n:])
+	return log[:n]
+}
@@ -0,0 +1,88 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/checker"
+)
+
+func TestHistory(t *testing.T) {
+	testHistory(t, KeepLastKRounds(2), 5, 500, 0)
+	testHistory(t, KeepLastKRounds(0), 5, 500, 0)
+	testHistory(t, KeepSinceCommit{}, 5, 500, 0)
+	testHistory(t, KeepUnconsumed{}, 5, 500, 400)
+}
+
+// Run a consensus group of nnodes nodes retaining history per policy,
+// with the application consuming results up to step consume,
+// and check that consensus remains consistent while history stays bounded.
+func testHistory(t *testing.T, policy HistoryPolicy, nnodes, maxSteps,
+	consume int) {
+
+	t.Run(fmt.Sprintf("%T(%v)", policy, policy), func(t *testing.T) {
+		var mut sync.Mutex
+		chk := &checker.Checker{}
+		trace := func(v *checker.View) {
+			mut.Lock()
+			defer mut.Unlock()
+			chk.View(v)
+		}
+
+		l := &Local{}
+		l.Start(context.Background(), nnodes,
+			Config{Threshold: nnodes/2 + 1, MaxTicket: int32(10 * nnodes),
+				Trace: trace, History: policy})
+		group := make([]*Node, nnodes)
+		for i := range group {
+			group[i] = l.Node(i)
+			group[i].Consume(consume)
+		}
+		waitSteps(t, group, maxSteps)
+		l.Stop()
+		if err := chk.Err(); err != nil {
+			t.Error(err)
+		}
+
+		for _, n := range group {
+			s := n.Snapshot()
+			h := s.Template.Step - 2*maxSteps/10
+			if consume > 0 {
+				h = consume // nodes may run on past maxSteps
+			}
+			for i, l := range s.Log {
+				if len(l) > 2*(s.Template.Step-h+RoundSteps) {
+					t.Errorf("node %v kept %v messages from node %v",
+						n.self, len(l), i)
+				}
+			}
+			for i, b := range s.StepBase {
+				if b < h {
+					t.Errorf("node %v kept node %v's views from step %v",
+						n.self, i, b)
+				}
+			}
+			if s.ChoiceBase < h {
+				t.Errorf("node %v kept choices from round %v",
+					n.self, s.ChoiceBase)
+			}
+			if _, _, ok := n.Decision(s.ChoiceBase - 1); ok {
+				t.Errorf("node %v kept a discarded decision", n.self)
+			}
+			_, _, ok := n.Decision(s.ChoiceBase)
+			if ok != (s.ChoiceBase <= s.Template.Step-RoundSteps) {
+				t.Errorf("node %v: decision retention inconsistent", n.self)
+			}
+
+			// A node's retained history must still be restorable.
+			r := &Replica{}
+			r.Restore(s)
+			if !reflect.DeepEqual(r.Snapshot(), s) {
+				t.Errorf("node %v: restored snapshot differs", n.self)
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package dist
+
+import (
+	"context"
+	"time"
+)
+
+// Number of probe intervals after which we consider a silent peer dead
+const probeMisses = 3
+
+// Liveness layer state for a Node.
+// It is protected by the node's stack mutex.
+type liveness struct {
+	probe  time.Duration          // Probe interval, or 0 if disabled
+	event  func(live int, p bool) // Pause and resume event hook, or nil
+	heard  []time.Time            // When we last heard from each peer
+	live   int                    // Live nodes as of the last count
+	paused bool                   // Whether we're holding our proposals
+	held   bool                   // Whether we hold this step's proposal
+}
+
+// Initialize the liveness layer state in a Node.
+// Every peer starts out presumed live.
+func (n *Node) initLiveness(conf Config) {
+	now := time.Now()
+	n.live = liveness{probe: conf.Probe, event: conf.Liveness,
+		heard: make([]time.Time, len(n.peer)), live: len(n.peer)}
+	for i := range n.live.heard {
+		n.live.heard[i] = now
+	}
+}
+
+// Send liveness probes to all peers every probe interval,
+// and check which peers remain live, until ctx is cancelled.
+// Transports start this goroutine along with the node.
+func (n *Node) runProbe(ctx context.Context) {
+	if n.live.probe <= 0 {
+		return
+	}
+	tick := time.NewTicker(n.live.probe)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			n.mutex.Lock()
+			n.probeLiveness(now)
+			n.mutex.Unlock()
+		}
+	}
+}
+
+// Probe all peers, then count the live ones as of time now.
+// Observers send no messages, so they neither probe nor pause.
+func (n *Node) probeLiveness(now time.Time) {
+	if n.observer {
+		return
+	}
+	msg := Message{From: n.self, Step: n.tmpl.Step, Typ: Ping}
+	n.stampClock(&msg)
+	for dest := range n.peer {
+		if dest != n.self {
+			n.sendCausal(dest, &msg)
+		}
+	}
+	n.checkLiveness(now)
+}
+
+// Record that we just heard from the sender of a message.
+// A paused node resumes as soon as it hears from enough peers.
+func (n *Node) receiveLiveness(msg *Message) {
+	now := time.Now()
+	n.live.heard[msg.From] = now
+	if n.live.paused {
+		n.checkLiveness(now)
+	}
+}
+
+// Count the nodes we consider live as of time now, including ourselves,
+// and pause or resume proposing if quorum became unavailable or available.
+func (n *Node) checkLiveness(now time.Time) {
+	live := 0
+	for i, t := range n.live.heard {
+		if i == n.self || now.Sub(t) < probeMisses*n.live.probe {
+			live++
+		}
+	}
+	n.live.live = live
+
+	paused := live < n.thres
+	if paused == n.live.paused {
+		return
+	}
+	n.live.paused = paused
+	if n.live.event != nil {
+		n.live.event(live, paused)
+	}
+
+	// Broadcast the proposal we held back while paused, if any.
+	if !paused && n.live.held {
+		n.live.held = false
+		prop := n.broadcastTLC()
+		n.tmpl.Prop = prop.Seq
+	}
+}
+
+// Live returns the number of nodes in the group this Node counted live
+// when it last checked, including itself,
+// and whether it has paused proposing for lack of a live quorum.
+// Without liveness probing, every node counts as live.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) Live() (live int, paused bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.live.live, n.live.paused
+}
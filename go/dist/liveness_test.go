@@ -0,0 +1,56 @@
+package dist
+
+import (
+	"testing"
+	"time"
+)
+
+// recPeer records the messages a node sends to a peer.
+type recPeer struct {
+	sent []Message
+}
+
+func (rp *recPeer) Send(msg *Message) {
+	rp.sent = append(rp.sent, *msg)
+}
+
+// Test that a node pauses proposing when its peers fall silent,
+// and resumes with the proposal it held back once they return.
+func TestLiveness(t *testing.T) {
+	var events []bool
+	conf := Config{Threshold: 2, Probe: time.Second,
+		Liveness: func(live int, paused bool) {
+			events = append(events, paused)
+		}}
+	rp := &recPeer{}
+	n := &Node{}
+	n.init(0, []peer{&recPeer{}, rp, &recPeer{}}, conf)
+	n.advanceTLC(0)
+
+	// Nothing from either peer for several probe intervals.
+	n.probeLiveness(time.Now().Add(probeMisses * conf.Probe))
+	if live, paused := n.Live(); live != 1 || !paused {
+		t.Fatalf("live %v paused %v after peers fell silent", live, paused)
+	}
+	if len(rp.sent) != 2 || rp.sent[1].Typ != Ping {
+		t.Fatalf("sent %+v", rp.sent)
+	}
+
+	// A paused node holds back its proposal for a new step.
+	n.advanceTLC(1)
+	if len(rp.sent) != 2 {
+		t.Fatalf("paused node sent %+v", rp.sent[2:])
+	}
+
+	// Hearing from a peer restores the quorum.
+	n.receiveCausal(&Message{From: 1, Typ: Ping})
+	if _, paused := n.Live(); paused {
+		t.Fatalf("still paused after peer returned")
+	}
+	if len(rp.sent) != 3 || rp.sent[2].Typ != Prop || rp.sent[2].Step != 1 {
+		t.Fatalf("resumed node sent %+v", rp.sent[2:])
+	}
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Errorf("events %v", events)
+	}
+}
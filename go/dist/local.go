@@ -0,0 +1,224 @@
+package dist
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultQueueLen is the default capacity of each node's incoming message
+// queue in a Local group.
+const DefaultQueueLen = 64
+
+// Local runs a whole consensus group of Nodes within one process,
+// connected by Go channels rather than a network,
+// so that an application can embed a complete group in one binary,
+// e.g., for single-box high availability
+// or for unit-testing application logic built atop consensus.
+//
+// Each node has a bounded incoming message queue.
+// Each directed link between two nodes has its own sender goroutine,
+// which blocks when the destination's queue is full,
+// so that a slow node applies backpressure without stalling the others.
+// Links may optionally simulate network latency.
+//
+type Local struct {
+	// QueueLen is the capacity of each node's incoming message queue,
+	// or 0 for DefaultQueueLen.
+	QueueLen int
+
+	// Latency is the minimum simulated delay of each message,
+	// and Jitter the maximum random delay added to it.
+	// Messages on each link remain in order regardless of jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Observers is the number of nodes, at the end of the group,
+	// that start as read-only observers: see Config.Observer.
+	Observers int
+
+	// Yield is an optional hook called at each message delivery
+	// and around each node's mutex when dispatching messages.
+	// Tests may use it to perturb goroutine scheduling: see package chaos.
+	Yield func()
+
+	nodes []*Node         // the group's nodes
+	inbox []chan *Message // incoming message queue of each node
+	wg    sync.WaitGroup  // counts running goroutines
+	stop  context.CancelFunc
+}
+
+// Start creates and runs a consensus group of nnodes nodes,
+// including any observers, with group configuration conf.
+// The group runs until Stop is called or ctx is cancelled.
+//
+func (l *Local) Start(ctx context.Context, nnodes int, conf Config) {
+	if l.QueueLen == 0 {
+		l.QueueLen = DefaultQueueLen
+	}
+	ctx, l.stop = context.WithCancel(ctx)
+
+	// Create all the nodes before any messages flow between them.
+	l.nodes = make([]*Node, nnodes)
+	l.inbox = make([]chan *Message, nnodes)
+	var links []*localLink
+	for i := range l.nodes {
+		l.nodes[i] = &Node{}
+		l.inbox[i] = make(chan *Message, l.QueueLen)
+	}
+	for i, n := range l.nodes {
+		sender := make([]peer, nnodes)
+		for j := range sender {
+			ll := &localLink{l: l, dest: j}
+			ll.cond.L = &ll.mut
+			sender[j] = ll
+			links = append(links, ll)
+		}
+		c := conf
+		c.Observer = c.Observer || i >= nnodes-l.Observers
+		n.init(i, sender, c)
+
+		// All the nodes run the same release, so need no handshakes.
+		for j := range sender {
+			n.helloVersion(j, n.vers.local)
+		}
+	}
+
+	// Start the links and the nodes' receive loops.
+	for _, ll := range links {
+		l.wg.Add(1)
+		go ll.run(ctx)
+	}
+	for i, n := range l.nodes {
+		l.wg.Add(2)
+		go l.runReceive(ctx, i)
+		go func(n *Node) {
+			defer l.wg.Done()
+			n.runProbe(ctx)
+		}(n)
+	}
+
+	// Start the first time step on each node
+	for _, n := range l.nodes {
+		n.mutex.Lock()
+		n.advanceTLC(0)
+		n.mutex.Unlock()
+	}
+}
+
+// Node returns node number i of the group.
+func (l *Local) Node(i int) *Node {
+	return l.nodes[i]
+}
+
+// Stop shuts down the whole group.
+func (l *Local) Stop() {
+	l.stop()
+	l.wg.Wait()
+}
+
+// Dispatch messages from a node's incoming queue into its protocol stack.
+func (l *Local) runReceive(ctx context.Context, i int) {
+	defer l.wg.Done()
+
+	n := l.nodes[i]
+	for {
+		select {
+		case msg := <-l.inbox[i]:
+			l.yield()
+			n.mutex.Lock()
+			n.receiveMessage(msg)
+			n.mutex.Unlock()
+			l.yield()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Call the Yield hook, if any.
+func (l *Local) yield() {
+	if l.Yield != nil {
+		l.Yield()
+	}
+}
+
+// localLink queues messages from one node to another
+// and delivers them in order to the destination's incoming queue.
+type localLink struct {
+	l    *Local // group we're part of
+	dest int    // node number of the destination
+
+	mut  sync.Mutex      // protects the fields below
+	cond sync.Cond       // signals new messages or shutdown
+	q    []localDelivery // messages not yet delivered
+	done bool            // set once the link shuts down
+}
+
+// localDelivery is a message in transit with its simulated arrival time.
+type localDelivery struct {
+	msg *Message
+	due time.Time
+}
+
+// Send queues a message without blocking,
+// since the sender holds its node's mutex.
+func (ll *localLink) Send(msg *Message) {
+	m := getMessage()
+	*m = *msg
+	due := time.Now().Add(ll.l.Latency)
+	if ll.l.Jitter > 0 {
+		due = due.Add(time.Duration(rand.Int63n(int64(ll.l.Jitter))))
+	}
+
+	ll.mut.Lock()
+	defer ll.mut.Unlock()
+
+	if !ll.done {
+		ll.q = append(ll.q, localDelivery{m, due})
+		ll.cond.Signal()
+	}
+}
+
+// Deliver queued messages until the context is cancelled.
+func (ll *localLink) run(ctx context.Context) {
+	defer ll.l.wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		ll.mut.Lock()
+		ll.done = true
+		ll.cond.Broadcast()
+		ll.mut.Unlock()
+	}()
+
+	for {
+		ll.mut.Lock()
+		for len(ll.q) == 0 && !ll.done {
+			ll.cond.Wait()
+		}
+		if ll.done {
+			ll.mut.Unlock()
+			return
+		}
+		d := ll.q[0]
+		ll.q = ll.q[1:]
+		ll.mut.Unlock()
+
+		// Simulate latency, then wait for room in the destination queue.
+		if wait := time.Until(d.due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		ll.l.yield()
+		select {
+		case ll.l.inbox[ll.dest] <- d.msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
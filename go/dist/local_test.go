@@ -0,0 +1,63 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/chaos"
+	"github.com/dedis/tlc/go/lib/checker"
+)
+
+func TestLocal(t *testing.T) {
+	testLocal(t, 2, 3, 1000, 0, 0, 0) // Standard f=1 case
+	testLocal(t, 3, 5, 1000, 0, 0, 0) // Standard f=2 case
+	testLocal(t, 4, 7, 100, 0, 0, 0)  // Standard f=3 case
+	testLocal(t, 2, 3, 1000, 1, 0, 0) // Minimal queues
+	testLocal(t, 3, 5, 100, 0, time.Millisecond, 0)
+	testLocal(t, 3, 5, 100, 0, 0, time.Millisecond)
+}
+
+// Run a consensus group of nnodes nodes within this process
+// with the given queue length and simulated latency,
+// until all nodes reach time-step maxSteps,
+// then check that the nodes' committed choices are consistent.
+func testLocal(t *testing.T, threshold, nnodes, maxSteps, queueLen int,
+	latency, jitter time.Duration) {
+
+	ch := chaos.New(*chaosSeed, 0)
+	desc := fmt.Sprintf("T=%v,N=%v,Steps=%v,Queue=%v,Latency=%v,Jitter=%v",
+		threshold, nnodes, maxSteps, queueLen, latency, jitter)
+	if ch != nil {
+		desc += fmt.Sprintf(",Chaos=%v", ch.Seed())
+	}
+	t.Run(desc, func(t *testing.T) {
+		// Validate every node's view of every round as it completes.
+		var mut sync.Mutex
+		chk := &checker.Checker{}
+		trace := func(v *checker.View) {
+			mut.Lock()
+			defer mut.Unlock()
+			chk.View(v)
+		}
+
+		l := &Local{QueueLen: queueLen, Latency: latency, Jitter: jitter,
+			Yield: ch.Hook()}
+		l.Start(context.Background(), nnodes,
+			Config{Threshold: threshold, MaxTicket: int32(10 * nnodes),
+				Trace: trace, Strict: true})
+
+		group := make([]*Node, nnodes)
+		for i := range group {
+			group[i] = l.Node(i)
+		}
+		waitSteps(t, group, maxSteps)
+		l.Stop()
+		checkCommits(t, group, maxSteps)
+		if err := chk.Err(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -2,13 +2,116 @@ package dist
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/checker"
+	"github.com/dedis/tlc/go/lib/witness"
 )
 
-// Threshold is the TLC and consensus threshold
-var Threshold int
+// DefaultMaxTicket is the default amount of entropy in lottery tickets
+const DefaultMaxTicket int32 = 100
+
+// Config holds the consensus group configuration of a Node.
+// Each Node has its own configuration,
+// so multiple independent groups may run in one process.
+type Config struct {
+	Threshold int   // TLC and consensus threshold
+	MaxTicket int32 // Amount of entropy in lottery tickets, or 0 for default
+
+	// Trace, if non-nil, is called with the node's view of each
+	// consensus round as the round completes, for validation by a
+	// checker.Checker. It is called with the node's stack locked.
+	Trace func(*checker.View)
+
+	// Commit, if non-nil, is called each time the node sees
+	// a consensus round commit, with the step at which the round started
+	// and the node whose proposal the round committed.
+	// It is called with the node's stack locked.
+	Commit func(step, best int)
+
+	// Step, if non-nil, is called with a snapshot of the node's state
+	// each time the node advances to a new time step,
+	// e.g., for time-travel debugging. It too is called with the stack locked.
+	Step func(*Snapshot)
+
+	// History decides how much protocol history the node retains,
+	// or nil to retain all of it.
+	History HistoryPolicy
+
+	// Observer makes the node a read-only observer of the group,
+	// e.g., for a dashboard, backup archiver, or audit node.
+	// An observer receives all the group's messages and tracks
+	// its consensus decisions, but never sends any messages itself,
+	// so it neither proposes nor counts toward any threshold.
+	// Since an observer may later be promoted to a full member,
+	// the group's Threshold must account for all nodes including observers.
+	Observer bool
+
+	// Backoff configures how the TCP transport backs off
+	// between attempts to redial a peer,
+	// tracking backoff state separately for each peer.
+	// Other transports ignore it: UDP, for example,
+	// paces its retransmissions by its own Retransmit timeout.
+	Backoff backoff.Config
+
+	// Probe, if positive, enables liveness probing:
+	// the node sends a Ping to each peer every Probe interval,
+	// and considers a peer live if it has heard anything from it
+	// within the last three intervals.
+	// While fewer than Threshold nodes, counting itself, are live,
+	// no quorum is available to witness its proposals,
+	// so the node pauses proposing, holding back its proposal
+	// for the current time step until enough peers return.
+	// All nodes in a group should use the same Probe interval.
+	Probe time.Duration
+
+	// Liveness, if non-nil, is called each time the node pauses
+	// or resumes proposing, with the number of nodes it counts live,
+	// including itself. It is called with the node's stack locked.
+	Liveness func(live int, paused bool)
 
-// MaxTicket is the Amount of entropy in lottery tickets
-var MaxTicket int32 = 100
+	// Version is the range of protocol and application schema versions
+	// the node advertises to its peers in a handshake on connecting,
+	// and the zero value speaks every protocol version this release does
+	// and schema version zero. See Version for how peers negotiate.
+	Version Version
+
+	// Mismatch, if non-nil, is called with a VersionError
+	// each time the node starts rejecting a peer's messages
+	// because the peer advertised an incompatible Version.
+	// It is called with the node's stack locked.
+	Mismatch func(peer int, err error)
+
+	// Fault, if non-nil, is called with a ProtocolError
+	// each time the node drops a message that violates the protocol,
+	// as a faulty or malicious peer might send.
+	// It is called with the node's stack locked.
+	Fault func(peer int, err error)
+
+	// Strict makes the node panic on a message that violates the protocol
+	// instead of dropping it, as tests may wish to catch bugs early.
+	// Applications should leave it false,
+	// so that no peer's behavior can crash them.
+	Strict bool
+
+	// Persist, if non-nil, is called with a snapshot of the node's state
+	// each time the node broadcasts a message, before sending it,
+	// so that the application may durably record the latest snapshot
+	// and later restart the node from it via Rejoin.
+	// It is called with the node's stack locked.
+	Persist func(*Snapshot)
+
+	// Rejoin, if non-nil, is the latest snapshot Persist recorded
+	// before the node last stopped, from which the node resumes
+	// at the snapshot's time step instead of starting afresh.
+	// The node then resynchronizes with each peer by exchanging
+	// only the messages either missed while it was down,
+	// which the peer must still retain under its HistoryPolicy.
+	// Transports that run a single node, such as TCP and UDP, support it.
+	Rejoin *Snapshot
+}
 
 // Type of message
 type Type int
@@ -20,6 +123,11 @@ const (
 	Ack
 	// Wit is a threshold witness confirmation of proposal
 	Wit
+	// Ping is a liveness probe, which needs no reply
+	Ping
+	// Sync requests the sender's missing messages after it rejoins,
+	// or after a connection carrying the receiver's messages failed
+	Sync
 )
 
 // Message over the network
@@ -29,6 +137,10 @@ type Message struct {
 	// From designates the node which originally sent this message
 	From int
 
+	// Wall-clock layer
+	// Time is the sender's wall-clock time in Unix nanoseconds when sent
+	Time int64
+
 	// Causality layer
 	// Seq is the Node-local sequence number for vector time
 	Seq int
@@ -44,53 +156,141 @@ type Message struct {
 	Prop int
 	// Ticket is the genetic fitness ticket for this proposal
 	Ticket int32
+	// Acks lists the Seqs of the recipient's proposals
+	// that the sender acknowledges on this broadcast
+	Acks []int
+
+	// Local state of a logged proposal, never transmitted:
+	// wit is the Seq of the Wit message confirming it, or 0 if none logged
+	wit int
 }
 
 // Node definition
 type Node struct {
+	// Group configuration
+	thres     int                 // TLC and consensus threshold
+	maxTicket int32               // Amount of entropy in lottery tickets, atomic access
+	trace     func(*checker.View) // Consensus round tracer, or nil
+	report    func(int, int)      // Commit hook, or nil
+	snap      func(*Snapshot)     // Time step snapshot hook, or nil
+	persist   func(*Snapshot)     // Pre-send snapshot hook, or nil
+	observer  bool                // Whether we only observe the group
+	onFault   func(int, error)    // Protocol violation hook, or nil
+	strict    bool                // Whether to panic on protocol violations
+
 	// Network/peering layer
 	self  int        // This node's participant number
 	peer  []peer     // How to send messages to each peer
 	mutex sync.Mutex // Mutex protecting node's protocol stack
 
+	// Wall-clock layer
+	clock clock // Local clock and peer clock offset estimates
+
+	// Liveness layer
+	live liveness // Peer liveness and proposal pausing state
+
+	// Version negotiation layer
+	vers versions // Versions we agreed to speak with each peer
+
+	// Rejoin layer
+	resync []bool // Peers we have yet to request missed messages from
+
 	// Causal history layer
 	mat    []vec        // Node's current matrix clock
 	oom    [][]*Message // Out-of-order messages not yet delivered
 	seqLog [][]*Message // Nodes' message received and delivered by seq
-	saw    []set        // Messages each node saw recently
-	wit    []set        // Witnessed messages each node saw recently
+	slab   []Message    // Preallocated messages for our log
+	vecs   vec          // Preallocated vector clock entries
 
 	// Threshold time (TLC) layer
-	tmpl    Message      // Template for messages we send
-	save    int          // Earliest step for which we maintain history
-	acks    set          // Acknowledgments we've received in this step
-	wits    set          // Threshold witnessed messages seen this step
-	stepLog [][]logEntry // Nodes' messages seen by start of recent steps
+	tmpl    Message         // Template for messages we send
+	save    int             // Earliest step the protocol still needs
+	witness witness.Tracker // Threshold witnessing progress this step
+	stepLog [][]*Message    // Nodes' proposals, and so views, in recent steps
+	batch   bool            // Whether we defer sends to the end of an event
+	outbox  []*Message      // Broadcasts deferred to the end of this event
+	acks    [][]int         // Seqs of peers' proposals we must acknowledge
 
 	// This node's record of QSC consensus history
 	choice []choice // Best proposal this node chose each round
+
+	// History retention
+	policy     HistoryPolicy // Decides how much history we retain
+	horizon    int           // Earliest step whose history we retain
+	consumed   int           // Latest step the application consumed
+	commit     int           // Start step of latest round we saw commit
+	seqBase    []int         // Seq of the first message in each seqLog
+	stepBase   []int         // Step of the first entry in each stepLog
+	choiceBase int           // Round start step of the first choice
 }
 
+// A peer sends messages to one node.
+// Send must copy msg if it retains it, since the caller may reuse it.
 type peer interface {
 	Send(msg *Message)
 }
 
-// Info each node logs about other nodes' views at the start of each time-step
-type logEntry struct {
-	saw set // All nodes' messages the node had seen by then
-	wit set // Threshold witnessed messages it had seen
-}
-
 // Record of one node's QSC decision in one time-step
 type choice struct {
 	best   int  // Best proposal this node chose in this round
 	commit bool // Whether node observed successful commitment
 }
 
-func (n *Node) init(self int, peer []peer) {
+func (n *Node) init(self int, peer []peer, conf Config) {
 	n.self = self
 	n.peer = peer
 
+	n.thres = conf.Threshold
+	n.trace = conf.Trace
+	n.report = conf.Commit
+	n.snap = conf.Step
+	n.persist = conf.Persist
+	n.observer = conf.Observer
+	n.onFault = conf.Fault
+	n.strict = conf.Strict
+	n.SetMaxTicket(conf.MaxTicket)
+
+	n.initClock()
+	n.initLiveness(conf)
+	n.initVersions(conf)
 	n.initCausal()
 	n.initTLC()
+	n.initHistory(conf.History)
+}
+
+// SetMaxTicket changes the amount of entropy in this Node's lottery tickets,
+// taking effect from the next time step the Node advances to.
+// A value of zero or less restores the default.
+// It may safely be called at any time, concurrently with the Node's operation.
+// All nodes in a group should normally use the same ticket distribution.
+func (n *Node) SetMaxTicket(max int32) {
+	if max <= 0 {
+		max = DefaultMaxTicket
+	}
+	atomic.StoreInt32(&n.maxTicket, max)
+}
+
+// MaxTicket returns the amount of entropy in this Node's lottery tickets.
+func (n *Node) MaxTicket() int32 {
+	return atomic.LoadInt32(&n.maxTicket)
+}
+
+// Promote converts an observer Node into a full member of its group,
+// which starts proposing and acknowledging proposals
+// from the next time step it advances to.
+// The other nodes learn of the new member from its first message.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) Promote() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.observer = false
+}
+
+// Observer returns true if the Node is a read-only observer of its group.
+func (n *Node) Observer() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.observer
 }
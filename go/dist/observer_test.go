@@ -0,0 +1,85 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/checker"
+)
+
+func TestObserver(t *testing.T) {
+	testObserver(t, nil, 4, 1)
+	testObserver(t, nil, 5, 2)
+	testObserver(t, KeepLastKRounds(2), 5, 2)
+}
+
+// Run a consensus group of nnodes nodes, the last nobs of them observers,
+// then promote the observers to full members and run it some more,
+// checking that consensus remains consistent throughout.
+func testObserver(t *testing.T, policy HistoryPolicy, nnodes, nobs int) {
+	const maxSteps = 300
+
+	t.Run(fmt.Sprintf("N=%v,Observers=%v,History=%T", nnodes, nobs, policy),
+		func(t *testing.T) {
+			var mut sync.Mutex
+			chk := &checker.Checker{}
+			trace := func(v *checker.View) {
+				mut.Lock()
+				defer mut.Unlock()
+				chk.View(v)
+			}
+
+			l := &Local{Observers: nobs}
+			l.Start(context.Background(), nnodes,
+				Config{Threshold: nnodes/2 + 1,
+					MaxTicket: int32(10 * nnodes),
+					Trace:     trace, History: policy})
+			defer l.Stop()
+			group := make([]*Node, nnodes)
+			for i := range group {
+				group[i] = l.Node(i)
+			}
+
+			// Observers must track consensus without sending anything.
+			waitSteps(t, group, maxSteps)
+			for i, n := range group {
+				if n.Observer() != (i >= nnodes-nobs) {
+					t.Errorf("node %v observer %v", i, n.Observer())
+				}
+				s := n.Snapshot()
+				for j := nnodes - nobs; j < nnodes; j++ {
+					if s.LogBase[j]+len(s.Log[j]) != 0 {
+						t.Errorf("node %v received messages "+
+							"from observer %v", i, j)
+					}
+				}
+			}
+			if policy == nil {
+				checkCommits(t, group, maxSteps)
+			}
+
+			// Promoted observers must join in consensus.
+			for _, n := range group[nnodes-nobs:] {
+				n.Promote()
+			}
+			waitSteps(t, group, 2*maxSteps)
+			for i, n := range group {
+				s := n.Snapshot()
+				for j := nnodes - nobs; j < nnodes; j++ {
+					if s.LogBase[j]+len(s.Log[j]) == 0 {
+						t.Errorf("node %v received nothing "+
+							"from promoted node %v", i, j)
+					}
+				}
+			}
+			l.Stop()
+			if policy == nil {
+				checkCommits(t, group, 2*maxSteps)
+			}
+			if err := chk.Err(); err != nil {
+				t.Error(err)
+			}
+		})
+}
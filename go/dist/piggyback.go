@@ -0,0 +1,89 @@
+package dist
+
+// A node acknowledges each proposal it receives in the proposal's time step,
+// which costs a unicast Ack from each node to each other node in each step.
+// Yet a node often broadcasts a message of its own while handling the
+// same event that led it to acknowledge a proposal:
+// e.g., the Wit that completes its threshold for a step
+// both advances it to the next step, broadcasting its new proposal,
+// and releases proposals for that step it had held for causal delivery.
+// A node therefore defers all the messages it sends while handling
+// a received message until it finishes handling it,
+// then carries its acknowledgments for each peer's proposals
+// on its first broadcast to that peer, in the Acks field,
+// sending unicast Acks only if it has no broadcast to send.
+//
+// Piggybacking preserves TLC's behavior because the receiver handles
+// the acknowledgments a broadcast carries as soon as the broadcast arrives,
+// before and regardless of its causal delivery, exactly as it would
+// handle unicast Acks arriving at the same time.
+// TLC never relied on the order of Acks relative to other messages,
+// since Acks were never causally ordered, and the witness tracker
+// counts each acknowledgment of the current proposal only once.
+// Nor does piggybacking delay any acknowledgment:
+// the node sends the messages of an event only after handling it completely,
+// but nothing observes the difference, since transports only queue
+// the messages they are given while the node holds its stack locked.
+// Every deferred acknowledgment leaves by the end of the event
+// that produced it, whether or not a broadcast carries it,
+// so no node can wait on another's deferred acknowledgment,
+// and QSC's safety, which rests on TLC's threshold witnessing
+// and not on how acknowledgments travel, is unaffected.
+//
+// Nodes strip the acknowledgments from broadcasts they receive
+// before logging them, so acknowledgments never enter the causal history,
+// snapshots, or messages resent to rejoining peers, just as unicast Acks
+// never did. Peers that speak an older protocol version
+// would silently ignore the Acks field, so they get unicast Acks instead.
+
+// Protocol version that introduced acknowledgments piggybacked on broadcasts
+const piggybackProtocol = 3
+
+// Return true if we may piggyback our acknowledgments of a peer's proposals
+// on our broadcasts, deferring them until the end of the current event.
+func (n *Node) piggybackTLC(peer int) bool {
+	return n.batch && peer != n.self && n.vers.known[peer] &&
+		n.vers.agreed[peer].Protocol >= piggybackProtocol
+}
+
+// Send the messages we deferred during the event now ending,
+// carrying the acknowledgments for each peer's proposals
+// on our first broadcast to it, or in unicast Acks if we have none.
+func (n *Node) flushTLC() {
+	n.batch = false
+	out := n.outbox
+	n.outbox = nil
+	for dest := range n.peer {
+		acks := n.acks[dest]
+		n.acks[dest] = nil
+		if dest == n.self {
+			continue
+		}
+		for i, msg := range out {
+			if i == 0 && len(acks) > 0 {
+				m := getMessage()
+				*m = *msg
+				m.Acks = acks
+				n.sendCausal(dest, m)
+				putMessage(m)
+				continue
+			}
+			n.sendCausal(dest, msg)
+		}
+		if len(out) == 0 {
+			for _, seq := range acks {
+				n.unicastAck(dest, seq)
+			}
+		}
+	}
+}
+
+// Handle the acknowledgments of our proposals a broadcast carries,
+// then strip them, so that they never enter our causal history.
+func (n *Node) receiveAcks(msg *Message) {
+	acks := msg.Acks
+	msg.Acks = nil
+	for _, seq := range acks {
+		n.ackTLC(msg.From, seq)
+	}
+}
@@ -0,0 +1,147 @@
+package dist
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// simNet delivers the messages of a group of nodes one at a time,
+// in a random order that preserves the order of each link's messages.
+type simNet struct {
+	nodes []*Node
+	q     [][][]Message // messages in transit on each link
+	rand  *rand.Rand
+	sent  [Sync + 1]int // messages sent of each type
+	acks  int           // acknowledgments piggybacked on broadcasts
+}
+
+type simPeer struct {
+	net      *simNet
+	from, to int
+}
+
+func (sp *simPeer) Send(msg *Message) {
+	sn := sp.net
+	sn.q[sp.from][sp.to] = append(sn.q[sp.from][sp.to], *msg)
+	sn.sent[msg.Typ]++
+	sn.acks += len(msg.Acks)
+}
+
+// Run a group of nnodes nodes, each speaking the version vers returns,
+// until all reach time-step maxSteps, and check their commits.
+func runSim(t *testing.T, threshold, nnodes, maxSteps int,
+	vers func(i int) Version) *simNet {
+
+	sn := newSim(nnodes, func(i int) Config {
+		return Config{Threshold: threshold, Version: vers(i)}
+	})
+	if !sn.run(maxSteps) {
+		t.Fatalf("group stuck")
+	}
+	checkCommits(t, sn.nodes, maxSteps)
+	return sn
+}
+
+// Create a group of nnodes nodes with the configurations conf returns,
+// and start the first time step on each.
+func newSim(nnodes int, conf func(i int) Config) *simNet {
+	sn := &simNet{nodes: make([]*Node, nnodes),
+		q: make([][][]Message, nnodes), rand: rand.New(rand.NewSource(1))}
+	for i := range sn.nodes {
+		sn.q[i] = make([][]Message, nnodes)
+		sender := make([]peer, nnodes)
+		for j := range sender {
+			sender[j] = &simPeer{sn, i, j}
+		}
+		sn.nodes[i] = &Node{}
+		sn.nodes[i].init(i, sender, conf(i))
+	}
+	for _, n := range sn.nodes {
+		for _, p := range sn.nodes {
+			n.helloVersion(p.self, p.vers.local)
+		}
+	}
+	for _, n := range sn.nodes {
+		n.advanceTLC(0)
+	}
+	return sn
+}
+
+// Deliver messages until all nodes reach time-step maxSteps,
+// returning false if the group gets stuck.
+func (sn *simNet) run(maxSteps int) bool {
+	var links [][2]int
+	for done := false; !done; {
+		links = links[:0]
+		for i := range sn.q {
+			for j := range sn.q[i] {
+				if len(sn.q[i][j]) > 0 {
+					links = append(links, [2]int{i, j})
+				}
+			}
+		}
+		if len(links) == 0 {
+			return false
+		}
+		l := links[sn.rand.Intn(len(links))]
+		msg := getMessage()
+		*msg = sn.q[l[0]][l[1]][0]
+		sn.q[l[0]][l[1]] = sn.q[l[0]][l[1]][1:]
+		sn.nodes[l[1]].receiveMessage(msg)
+
+		done = true
+		for _, n := range sn.nodes {
+			done = done && n.tmpl.Step >= maxSteps
+		}
+	}
+	return true
+}
+
+// Test that piggybacking acknowledgments on broadcasts
+// saves messages without changing the protocol's outcome,
+// and that nodes still send unicast Acks to peers speaking older versions.
+func TestPiggyback(t *testing.T) {
+	const threshold, nnodes, maxSteps = 6, 10, 100
+	old := func(int) Version { return Version{Protocol: 2} }
+	cur := func(int) Version { return Version{} }
+	mixed := func(i int) Version {
+		if i == 0 {
+			return Version{Protocol: 2}
+		}
+		return Version{}
+	}
+
+	before := runSim(t, threshold, nnodes, maxSteps, old)
+	after := runSim(t, threshold, nnodes, maxSteps, cur)
+	t.Logf("sent %v messages without piggybacking, %v with, "+
+		"piggybacking %v acknowledgments",
+		sum(before.sent[:]), sum(after.sent[:]), after.acks)
+	if before.acks != 0 || after.acks == 0 {
+		t.Errorf("piggybacked %v and %v acknowledgments",
+			before.acks, after.acks)
+	}
+	if after.sent[Ack] >= before.sent[Ack] ||
+		sum(after.sent[:]) >= sum(before.sent[:]) {
+		t.Errorf("sent %v messages without piggybacking, %v with",
+			before.sent, after.sent)
+	}
+
+	// Node 0 gets only unicast Acks, but sends its own piggybacked
+	// to nodes that speak the latest version.
+	sn := runSim(t, threshold, nnodes, maxSteps, mixed)
+	for _, msg := range sn.q[1][0] {
+		if len(msg.Acks) != 0 {
+			t.Errorf("piggybacked acknowledgments to node 0")
+		}
+	}
+	if sn.acks == 0 {
+		t.Errorf("piggybacked no acknowledgments in mixed group")
+	}
+}
+
+func sum(v []int) (s int) {
+	for _, x := range v {
+		s += x
+	}
+	return s
+}
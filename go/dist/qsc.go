@@ -1,14 +1,18 @@
 package dist
 
+import (
+	"github.com/dedis/tlc/go/lib/checker"
+)
+
 // RoundSteps is three because the witnessed QSC requires three TLC
 // time-steps per consensus round.
 const RoundSteps = 3
 
-// The TLC layer upcalls this method on advancing to a new time-step,
-// with sets of proposals seen (saw) and threshold witnessed (wit) recently.
-func (n *Node) advanceQSC(saw, wit set) {
-	//println(n.self, n.tmpl.Step, "advanceQSC saw", len(saw),
-	//	"wit", len(wit))
+// The TLC layer upcalls this method on advancing to a new time-step.
+// The proposals we have seen are those in our log,
+// and those we have seen threshold witnessed are those whose Wits are too.
+func (n *Node) advanceQSC() {
+	//println(n.self, n.tmpl.Step, "advanceQSC")
 
 	// Calculate the starting step of the round that's just now completing.
 	s := n.tmpl.Step - RoundSteps
@@ -20,23 +24,30 @@ func (n *Node) advanceQSC(saw, wit set) {
 	// and that is in our view by the end of the round at s+3.
 	var bestProp *Message
 	var bestTicket int32
-	for p := range wit {
-		if p.Typ != Prop {
-			panic("wit should contain only proposals")
-		}
-		if p.Step == s+0 && p.Ticket >= bestTicket {
+	for i := range n.peer {
+		p := n.proposal(i, s+0)
+		if p != nil && p.wit != 0 && p.Ticket >= bestTicket {
 			bestProp = p
 			bestTicket = p.Ticket
 		}
 	}
 
 	// Determine if we can consider this proposal permanently committed.
-	spoiled := n.spoiledQSC(s, saw, bestProp, bestTicket)
-	reconfirmed := n.reconfirmedQSC(s, wit, bestProp)
+	spoiled := n.spoiledQSC(s, bestProp, bestTicket)
+	reconfirmed := n.reconfirmedQSC(s, bestProp)
 	committed := !spoiled && reconfirmed
 
 	// Record the consensus results for this round (from s to s+3).
 	n.choice = append(n.choice, choice{bestProp.From, committed})
+	if committed {
+		n.commit = s
+		if n.report != nil {
+			n.report(s, bestProp.From)
+		}
+	}
+	if n.trace != nil {
+		n.traceQSC(s, bestProp, committed)
+	}
 	//println(n.self, n.tmpl.Step, "choice", bestProp.From, "spoiled", spoiled,
 	// "reconfirmed", reconfirmed, "committed", committed)
 
@@ -44,11 +55,19 @@ func (n *Node) advanceQSC(saw, wit set) {
 	n.save = s + 1
 }
 
+// Return node i's proposal for step s, or nil if we have not logged one.
+func (n *Node) proposal(i, s int) *Message {
+	if k := s - n.stepBase[i]; k >= 0 && k < len(n.stepLog[i]) {
+		return n.stepLog[i][k]
+	}
+	return nil
+}
+
 // Return true if there's another proposal competitive with a given candidate.
-func (n *Node) spoiledQSC(s int, saw set, prop *Message, ticket int32) bool {
-	for p := range saw {
-		if p.Step == s+0 && p.Typ == Prop && p != prop &&
-			p.Ticket >= ticket {
+func (n *Node) spoiledQSC(s int, prop *Message, ticket int32) bool {
+	for i := range n.peer {
+		p := n.proposal(i, s+0)
+		if p != nil && p != prop && p.Ticket >= ticket {
 			return true // victory spoiled by competition!
 		}
 	}
@@ -56,11 +75,33 @@ func (n *Node) spoiledQSC(s int, saw set, prop *Message, ticket int32) bool {
 }
 
 // Return true if given proposal was doubly confirmed (reconfirmed).
-func (n *Node) reconfirmedQSC(s int, wit set, prop *Message) bool {
-	for p := range wit { // search for a paparazzi witness at s+1
-		if p.Step == s+1 && n.stepLog[p.From][s+1].wit.has(prop) {
+func (n *Node) reconfirmedQSC(s int, prop *Message) bool {
+	for i := range n.peer { // search for a paparazzi witness at s+1
+		p := n.proposal(i, s+1)
+		if p != nil && p.wit != 0 && n.witnessedCausal(p.Vec, prop) {
 			return true
 		}
 	}
 	return false
 }
+
+// Report our view of the round starting at s to the tracer.
+func (n *Node) traceQSC(s int, best *Message, committed bool) {
+	v := &checker.View{Node: n.self, Step: s,
+		Choice: best.From, Commit: committed}
+	for i := range n.peer {
+		p := n.proposal(i, s)
+		if p == nil {
+			continue
+		}
+		v.Seen = append(v.Seen,
+			checker.Proposal{From: p.From, Ticket: uint64(p.Ticket)})
+		if p.wit != 0 {
+			v.Confirmed = append(v.Confirmed, p.From)
+			if n.reconfirmedQSC(s, p) {
+				v.Reconfirmed = append(v.Reconfirmed, p.From)
+			}
+		}
+	}
+	n.trace(v)
+}
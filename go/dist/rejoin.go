@@ -0,0 +1,141 @@
+package dist
+
+import (
+	"errors"
+)
+
+// Rejoining a group after a planned restart, such as for maintenance,
+// requires a snapshot of the node's state that includes every message
+// the node ever sent, so that it never sends conflicting messages
+// under the same sequence numbers after restarting.
+// Config.Persist delivers exactly such snapshots, and Config.Rejoin
+// restores one when the node restarts.
+//
+// The restarted node then requests from each peer, in a Sync message
+// carrying its vector time, only the delta of the peer's messages
+// that it has not yet logged, instead of a full copy of the peer's state,
+// and resends its own messages the peer may have missed.
+// Peers can supply the delta only while their HistoryPolicy retains it,
+// so the group's nodes must retain history long enough
+// to cover the expected duration of a restart.
+//
+// The snapshot's matrix clock includes the node's own vector time,
+// the count of each peer's messages it has delivered in causal order,
+// consistent with the rest of the snapshot's state.
+// After a restart, the node discards any message that vector time covers,
+// however often peers resend it on reconnecting or answering a Sync,
+// so each message reaches the TLC layer exactly once across restarts.
+// Messages the node delivered after its last snapshot
+// were never reflected in any persisted state,
+// so it delivers them anew when peers resend them.
+// Persisting that vector time apart from the rest of the snapshot,
+// say after every delivery, would instead discard such messages and lose them.
+
+// Protocol version that introduced Sync messages
+const syncProtocol = 2
+
+// Return an error if a snapshot cannot restore node self
+// of a group of nnodes nodes.
+func checkRejoin(s *Snapshot, self, nnodes int) error {
+	if s.Self != self || len(s.Log) != nnodes || len(s.Mat) != nnodes {
+		return errors.New("snapshot to rejoin from is of another node")
+	}
+	return nil
+}
+
+// Start the first time step, or rejoin the group at the time step
+// of a persisted snapshot, if s is non-nil.
+// Transports call this with the node's stack locked once they start.
+func (n *Node) startTLC(s *Snapshot) {
+	if s == nil {
+		n.advanceTLC(0)
+		return
+	}
+	n.load(s)
+	n.observer = s.Observer
+
+	// The node persists its snapshot before sending each message,
+	// but records the sequence number of its proposal only afterwards.
+	own := n.logged(n.self, n.logLen(n.self)-1)
+	switch {
+	case n.observer:
+	case own == nil || own.Step != n.tmpl.Step:
+		// The snapshot predates our proposal for this step,
+		// e.g., a snapshot from Config.Step taken while paused.
+		prop := n.broadcastTLC()
+		n.tmpl.Prop = prop.Seq
+	case own.Typ == Prop:
+		n.tmpl.Prop = own.Seq
+	}
+
+	// Resend our messages our peers had not seen as of the snapshot,
+	// since we may have crashed before they received them,
+	// and request from each peer the delta of its messages we missed
+	// once we know it speaks a protocol version supporting that.
+	n.resync = make([]bool, len(n.peer))
+	for i := range n.peer {
+		if i == n.self {
+			continue
+		}
+		for seq := n.mat[i][n.self]; seq < n.logLen(n.self); seq++ {
+			if msg := n.logged(n.self, seq); msg != nil {
+				n.sendCausal(i, msg)
+			}
+		}
+		n.resync[i] = true
+		n.requestSync(i)
+	}
+}
+
+// Request from a peer the messages it may have sent us on a connection
+// that failed before we read them, once the peer connects to us anew.
+// Transports that send messages on connections, which lose the messages
+// in transit when they fail, call this with the node's stack locked
+// on each new connection from a peer, once its handshake succeeds.
+// The peer can resend the messages only while its HistoryPolicy retains them.
+func (n *Node) reconnected(peer int) {
+	if n.resync == nil {
+		n.resync = make([]bool, len(n.peer))
+	}
+	n.resync[peer] = true
+	n.requestSync(peer)
+}
+
+// Request from a peer the messages we have not yet logged, via Sync,
+// once we know it speaks a protocol version that supports Sync messages.
+func (n *Node) requestSync(peer int) {
+	v, ok := n.vers.agreed[peer], n.vers.known[peer]
+	if n.resync == nil || !n.resync[peer] || !ok ||
+		v.Protocol < syncProtocol {
+		return
+	}
+	n.resync[peer] = false
+
+	msg := Message{From: n.self, Step: n.tmpl.Step, Typ: Sync,
+		Prop: n.tmpl.Prop, Vec: n.mat[n.self].copy()}
+	n.stampClock(&msg)
+	n.sendCausal(peer, &msg)
+}
+
+// Handle a peer's Sync request by resending it the delta of our messages
+// it has not yet logged, and acknowledging its current proposal again
+// in case it lost our original acknowledgment.
+func (n *Node) receiveSync(msg *Message) {
+	if len(msg.Vec) != len(n.peer) {
+		return // malformed request
+	}
+	for seq := msg.Vec[n.self]; seq < n.logLen(n.self); seq++ {
+		if own := n.logged(n.self, seq); own != nil {
+			n.sendCausal(msg.From, own)
+		}
+	}
+
+	if msg.Step == n.tmpl.Step && !n.observer &&
+		msg.Prop >= 0 && msg.Prop < n.logLen(msg.From) {
+
+		prop := n.logged(msg.From, msg.Prop)
+		if prop != nil && prop.Typ == Prop && prop.Step == msg.Step {
+			n.acknowledgeTLC(prop)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"testing"
+)
+
+// Test that a UDP node restarted from its persisted state
+// rejoins its group at the step it left off, and stays consistent.
+func TestRejoin(t *testing.T) {
+	const nnodes, threshold = 4, 3
+	conns := make([]net.PacketConn, nnodes)
+	addrs := make([]net.Addr, nnodes)
+	for i := range conns {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func(i int) { conns[i].Close() }(i)
+		conns[i], addrs[i] = conn, conn.LocalAddr()
+	}
+
+	// Node 3 persists its state as if to disk before every send.
+	var saved []byte
+	persist := func(s *Snapshot) {
+		buf := &bytes.Buffer{}
+		if err := gob.NewEncoder(buf).Encode(s); err != nil {
+			t.Error(err)
+		}
+		saved = buf.Bytes()
+	}
+	start := func(i int, rejoin *Snapshot) *UDP {
+		conf := Config{Threshold: threshold, MaxTicket: 100,
+			Rejoin: rejoin}
+		if i == 3 {
+			conf.Persist = persist
+			conf.Fault = func(peer int, err error) {
+				t.Errorf("node 3: %v", err)
+			}
+		}
+		u := &UDP{}
+		if err := u.Start(context.Background(), i, conns[i], addrs,
+			conf); err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	nodes := make([]*UDP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = start(i, nil)
+		group[i] = &nodes[i].Node
+	}
+
+	// Take node 3 down for maintenance while the others continue.
+	waitSteps(t, group, 20)
+	nodes[3].Stop()
+	var snap Snapshot
+	nodes[3].mutex.Lock()
+	err := gob.NewDecoder(bytes.NewReader(saved)).Decode(&snap)
+	nodes[3].mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := snap.Template.Step
+	waitSteps(t, group[:3], left+20)
+
+	// Restart it from its persisted state on the same address.
+	conns[3].Close()
+	if conns[3], err = net.ListenPacket("udp", addrs[3].String()); err != nil {
+		t.Fatal(err)
+	}
+	nodes[3] = start(3, &snap)
+	group[3] = &nodes[3].Node
+	if s := nodes[3].step(); s != left {
+		t.Errorf("node rejoined at step %v, not %v", s, left)
+	}
+
+	// Peers replaying their whole histories, as on reconnecting,
+	// must deliver to the rejoined node only what it has not yet seen.
+	waitSteps(t, group, left+25)
+	for i := 0; i < 3; i++ {
+		nodes[i].mutex.Lock()
+		for seq := nodes[i].seqBase[i]; seq < nodes[i].logLen(i); seq++ {
+			nodes[i].sendCausal(3, nodes[i].logged(i, seq))
+		}
+		nodes[i].mutex.Unlock()
+	}
+
+	waitSteps(t, group, left+40)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, left+40)
+	checkLogs(t, group[3], group[:3])
+
+	// The rejoined node must have reached its own consensus decisions.
+	if _, _, ok := group[3].Decision(left + 20); !ok {
+		t.Errorf("rejoined node made no decision")
+	}
+}
+
+// Check that node n logged each peer's messages it retains
+// once each and as that peer itself logged them.
+func checkLogs(t *testing.T, n *Node, peers []*Node) {
+	for i, p := range peers {
+		if n.logLen(i) > p.logLen(i) {
+			t.Errorf("logged %v messages from node %v, which sent %v",
+				n.logLen(i), i, p.logLen(i))
+			continue
+		}
+		for seq := n.seqBase[i]; seq < n.logLen(i); seq++ {
+			m, pm := n.logged(i, seq), p.logged(i, seq)
+			if pm != nil && (m.Seq != seq || m.Step != pm.Step ||
+				m.Typ != pm.Typ) {
+				t.Errorf("message %v from node %v logged as %+v, "+
+					"sent as %+v", seq, i, m, pm)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package dist
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/witness"
+)
+
+// Snapshot is a serializable copy of a Node's complete protocol state,
+// suitable for encoding with encoding/gob or encoding/json.
+// Snapshots support time-travel debugging:
+// a debugger may record a Node's snapshot at each time step,
+// then restore any of them into a Replica to explore what-if scenarios.
+//
+// A snapshot includes the Node's entire retained causal message log,
+// so snapshots grow as the Node's history does,
+// unless its HistoryPolicy discards old history.
+//
+type Snapshot struct {
+	Self      int   // Node's participant number
+	Threshold int   // TLC and consensus threshold
+	MaxTicket int32 // Amount of entropy in lottery tickets
+	Observer  bool  // Whether the Node only observes the group
+
+	Template Message       // Template for messages the Node sends
+	Save     int           // Earliest step the protocol still needs
+	Witness  witness.State // Threshold witnessing progress this step
+
+	Horizon  int // Earliest step whose history the Node retains
+	Consumed int // Latest step the application consumed, or -1
+	Commit   int // Start step of the latest round seen to commit, or -1
+
+	Mat      [][]int            // Node's matrix clock
+	Log      [][]Message        // Each node's messages, in causal order
+	LogBase  []int              // Seq of the first message in each Log
+	Pending  [][]PendingMessage // Out-of-order messages not yet delivered
+	Saw      [][]MessageRef     // Messages each node saw recently
+	Wit      [][]MessageRef     // Witnessed proposals each node saw recently
+	StepLog  [][]StepView       // Each node's views at recent step starts
+	StepBase []int              // Step of the first view in each StepLog
+
+	Choices    []Choice        // Node's QSC decision in each retained round
+	ChoiceBase int             // Round start step of the first choice
+	Clock      [][]ClockSample // Recent clock samples from each peer
+}
+
+// MessageRef refers to a message in a Snapshot's Log
+// by the node that sent it and its sequence number.
+type MessageRef struct {
+	From, Seq int
+}
+
+// PendingMessage is a message received out of causal order,
+// at a given position in its sender's queue of undelivered messages.
+type PendingMessage struct {
+	Index int     // Position in the sender's queue
+	Msg   Message // The message itself
+}
+
+// StepView records the messages a node had seen,
+// and threshold witnessed proposals among them,
+// by the start of one time step.
+type StepView struct {
+	Saw, Wit []MessageRef
+}
+
+// Choice records a Node's QSC decision in one consensus round.
+type Choice struct {
+	Best   int  // Node whose proposal this node chose
+	Commit bool // Whether this node observed the round commit
+}
+
+// ClockSample is a round-trip estimate of a peer's clock offset from ours.
+type ClockSample struct {
+	Offset, RTT time.Duration
+}
+
+// Snapshot returns a copy of the Node's complete protocol state.
+// It may be called at any time, concurrently with the Node's operation.
+func (n *Node) Snapshot() *Snapshot {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.snapshot()
+}
+
+func (n *Node) snapshot() *Snapshot {
+	s := &Snapshot{Self: n.self, Threshold: n.thres,
+		MaxTicket: n.MaxTicket(), Observer: n.observer,
+		Template: n.tmpl, Save: n.save,
+		Witness: n.witness.State(), Horizon: n.horizon,
+		Consumed: n.consumed, Commit: n.commit,
+		LogBase:    append([]int{}, n.seqBase...),
+		StepBase:   append([]int{}, n.stepBase...),
+		ChoiceBase: n.choiceBase}
+	s.Template.Vec = n.tmpl.Vec.copy()
+
+	nn := len(n.peer)
+	s.Mat = make([][]int, nn)
+	s.Log = make([][]Message, nn)
+	s.Pending = make([][]PendingMessage, nn)
+	s.Saw = make([][]MessageRef, nn)
+	s.Wit = make([][]MessageRef, nn)
+	s.StepLog = make([][]StepView, nn)
+	for i := 0; i < nn; i++ {
+		s.Mat[i] = append([]int{}, n.mat[i]...)
+		for _, msg := range n.seqLog[i] {
+			s.Log[i] = append(s.Log[i], *msg)
+		}
+		for j, msg := range n.oom[i] {
+			if msg != nil {
+				s.Pending[i] = append(s.Pending[i],
+					PendingMessage{j, *msg})
+			}
+		}
+		// A node has seen its own messages, though its vector
+		// time at each message counts only those before it.
+		v := n.mat[i].copy()
+		v[i] = n.logLen(i)
+		s.Saw[i], s.Wit[i] = n.refs(v, n.save)
+		for _, p := range n.stepLog[i] {
+			v := p.Vec.copy()
+			v[i] = p.Seq + 1
+			saw, wit := n.refs(v, p.Step-RoundSteps)
+			s.StepLog[i] = append(s.StepLog[i], StepView{saw, wit})
+		}
+	}
+
+	for _, c := range n.choice {
+		s.Choices = append(s.Choices, Choice{c.best, c.commit})
+	}
+
+	n.clock.mut.Lock()
+	defer n.clock.mut.Unlock()
+	s.Clock = make([][]ClockSample, nn)
+	for i, samples := range n.clock.peer {
+		for _, c := range samples {
+			s.Clock[i] = append(s.Clock[i],
+				ClockSample{c.offset, c.rtt})
+		}
+	}
+	return s
+}
+
+// Return references to the messages from step since onward
+// that a node had seen if it had seen those vector time v covers,
+// and to the proposals among them it had seen threshold witnessed,
+// omitting any messages no longer in our log, which the protocol no longer needs.
+func (n *Node) refs(v vec, since int) (saw, wit []MessageRef) {
+	saw, wit = []MessageRef{}, []MessageRef{}
+	for i, log := range n.seqLog {
+		log = log[:max(0, min(len(log), v[i]-n.seqBase[i]))]
+		first := sort.Search(len(log), func(k int) bool {
+			return log[k].Step >= since
+		})
+		for _, msg := range log[first:] {
+			saw = append(saw, MessageRef{msg.From, msg.Seq})
+			if msg.Typ == Prop && n.witnessedCausal(v, msg) {
+				wit = append(wit, MessageRef{msg.From, msg.Seq})
+			}
+		}
+	}
+	return saw, wit
+}
+
+// Restore this Node's protocol state from a snapshot,
+// with the given peers to send messages to.
+func (n *Node) restore(s *Snapshot, peer []peer) {
+	n.init(s.Self, peer, Config{Threshold: s.Threshold,
+		MaxTicket: s.MaxTicket, Observer: s.Observer})
+	n.load(s)
+}
+
+// Load the protocol state from a snapshot into a freshly initialized Node.
+func (n *Node) load(s *Snapshot) {
+	n.tmpl = s.Template
+	n.tmpl.Vec = s.Template.Vec.copy()
+	n.save = s.Save
+	n.witness.SetState(s.Witness)
+	n.horizon = s.Horizon
+	n.consumed = s.Consumed
+	n.commit = s.Commit
+	copy(n.seqBase, s.LogBase)
+	copy(n.stepBase, s.StepBase)
+	n.choiceBase = s.ChoiceBase
+
+	// Rebuild the message log, from which the views follow.
+	for i := range s.Log {
+		n.mat[i] = append(vec{}, s.Mat[i]...)
+		for j := range s.Log[i] {
+			msg := s.Log[i][j]
+			msg.wit = 0
+			n.seqLog[i] = append(n.seqLog[i], &msg)
+			n.witCausal(&msg)
+			if msg.Typ == Prop && msg.Step >= n.stepBase[i] &&
+				msg.Step < n.stepBase[i]+len(s.StepLog[i]) {
+				n.stepLog[i] = append(n.stepLog[i], &msg)
+			}
+		}
+		for _, p := range s.Pending[i] {
+			for len(n.oom[i]) <= p.Index {
+				n.oom[i] = append(n.oom[i], nil)
+			}
+			msg := p.Msg
+			n.oom[i][p.Index] = &msg
+		}
+	}
+
+	for _, c := range s.Choices {
+		n.choice = append(n.choice, choice{c.Best, c.Commit})
+	}
+	for i := range s.Clock {
+		for _, c := range s.Clock[i] {
+			n.clock.peer[i] = append(n.clock.peer[i],
+				clockSample{c.Offset, c.RTT})
+		}
+	}
+}
+
+// Replica is a detached copy of a Node restored from a Snapshot,
+// for exploring what-if scenarios such as alternative message deliveries.
+// A Replica communicates with no one:
+// the caller delivers messages to it explicitly,
+// and it records the messages it sends instead of transmitting them.
+type Replica struct {
+	Node
+
+	// Sent holds the messages the Replica has sent since its restoration
+	Sent []Outgoing
+}
+
+// Outgoing is a message a Replica sent, with its destination.
+type Outgoing struct {
+	To  int
+	Msg Message
+}
+
+// Restore sets the Replica's state to a copy of the state in a snapshot.
+func (r *Replica) Restore(s *Snapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peer := make([]peer, len(s.Log))
+	for i := range peer {
+		peer[i] = &replicaPeer{r, i}
+	}
+	r.restore(s, peer)
+	r.Sent = nil
+}
+
+// Deliver delivers a message to the Replica as if received from the network.
+// Messages the Replica already received are ignored,
+// and messages that arrive out of causal order wait for their predecessors.
+func (r *Replica) Deliver(msg *Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := getMessage()
+	*m = *msg
+	r.receiveMessage(m)
+
+	// Unlike a live node, a replica may hold messages that causally
+	// depend on messages it has only now sent, in its altered history,
+	// so retry delivering them after anything it sent.
+	for progress := true; progress; {
+		progress = false
+		for i := range r.peer {
+			progress = progress || r.deliverCausal(i)
+		}
+	}
+}
+
+// replicaPeer records the messages a Replica sends to one destination.
+type replicaPeer struct {
+	r    *Replica
+	dest int
+}
+
+func (rp *replicaPeer) Send(msg *Message) {
+	rp.r.Sent = append(rp.r.Sent, Outgoing{rp.dest, *msg})
+}
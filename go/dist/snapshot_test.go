@@ -0,0 +1,84 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	// Record node 0's snapshot at every time step of a consensus run.
+	var snaps []*Snapshot
+	conf := Config{Threshold: 2, MaxTicket: 30,
+		Step: func(s *Snapshot) {
+			if s.Self == 0 {
+				snaps = append(snaps, s)
+			}
+		}}
+	l := &Local{}
+	l.Start(context.Background(), 3, conf)
+	waitSteps(t, []*Node{l.Node(0), l.Node(1), l.Node(2)}, 50)
+	l.Stop()
+
+	for i, s := range snaps {
+		if s.Template.Step != i {
+			t.Fatalf("snapshot %v is of step %v", i, s.Template.Step)
+		}
+	}
+	last := l.Node(0).Snapshot()
+
+	// Snapshots must survive serialization and restoration intact.
+	for _, s := range []*Snapshot{snaps[0], snaps[20], last} {
+		var g, j Snapshot
+		buf := &bytes.Buffer{}
+		if err := gob.NewEncoder(buf).Encode(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := gob.NewDecoder(buf).Decode(&g); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &j); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range []*Snapshot{&g, &j} {
+			r := &Replica{}
+			r.Restore(d)
+			if !reflect.DeepEqual(r.Snapshot(), s) {
+				t.Errorf("step %v: restored snapshot differs",
+					s.Template.Step)
+			}
+		}
+	}
+
+	// Travel back in time, then explore what happens if node 0's
+	// proposals are always acknowledged by the other nodes,
+	// while replaying the other nodes' later broadcasts.
+	// The replica must again make progress.
+	r := &Replica{}
+	r.Restore(snaps[20])
+	ack := func() {
+		for i := 1; i < 3 && r.tmpl.Typ == Prop; i++ {
+			r.Deliver(&Message{From: i, Typ: Ack,
+				Step: r.tmpl.Step, Prop: r.tmpl.Prop})
+		}
+	}
+	ack()
+	for i := 1; i < 3; i++ {
+		for j := range last.Log[i] {
+			r.Deliver(&last.Log[i][j])
+			ack()
+		}
+	}
+	if r.tmpl.Step < 30 || len(r.Sent) == 0 {
+		t.Errorf("replica stuck at step %v after %v messages sent",
+			r.tmpl.Step, len(r.Sent))
+	}
+}
@@ -0,0 +1,73 @@
+package dist
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dedis/tlc/go/lib/status"
+)
+
+// Status reports the Node's current state for an embedded status page,
+// implementing status.Reporter.
+// A peer is reported healthy if we have seen a message from it
+// within the last round's worth of time steps.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) Status() status.Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	st := status.Status{Kind: "dist.Node", Step: int64(n.tmpl.Step)}
+
+	// Report the latest step we have seen from each peer.
+	for i := range n.peer {
+		p := status.Peer{Name: fmt.Sprintf("node %d", i)}
+		if i == n.self {
+			p.Name += " (self)"
+		}
+		if l := n.logLen(i); l > 0 && n.logged(i, l-1) != nil {
+			step := n.logged(i, l-1).Step
+			p.Healthy = step > n.tmpl.Step-RoundSteps
+			p.Detail = fmt.Sprintf("step %d", step)
+		} else {
+			p.Detail = "no messages"
+		}
+		n.clock.mut.Lock()
+		if offset, rtt, ok := n.peerOffset(i); ok && i != n.self {
+			p.Detail += fmt.Sprintf(", clock offset %v, rtt %v",
+				offset, rtt)
+		}
+		n.clock.mut.Unlock()
+		if v := n.vers.agreed[i]; n.vers.known[i] && i != n.self {
+			p.Detail += fmt.Sprintf(", protocol %d schema %d",
+				v.Protocol, v.Schema)
+		} else if !n.vers.known[i] && n.vers.remote[i] != (Version{}) {
+			p.Healthy = false
+			p.Detail += ", incompatible " + n.vers.remote[i].String()
+		}
+		st.Peers = append(st.Peers, p)
+	}
+
+	// Report the most recent rounds we saw commit.
+	for i := len(n.choice) - 1; i >= 0; i-- {
+		if len(st.History) == status.HistoryTail {
+			break
+		}
+		if c := n.choice[i]; c.commit {
+			st.History = append([]status.Commit{{
+				Step:  int64(n.choiceBase + i),
+				Value: fmt.Sprintf("proposal of node %d", c.best),
+			}}, st.History...)
+		}
+	}
+
+	st.Config = map[string]string{
+		"self":      strconv.Itoa(n.self),
+		"nodes":     strconv.Itoa(len(n.peer)),
+		"threshold": strconv.Itoa(n.thres),
+		"maxTicket": strconv.Itoa(int(n.MaxTicket())),
+		"observer":  strconv.FormatBool(n.observer),
+		"history":   fmt.Sprintf("%T", n.policy),
+		"version":   n.vers.local.String(),
+	}
+	return st
+}
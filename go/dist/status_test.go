@@ -0,0 +1,48 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/status"
+)
+
+func TestStatus(t *testing.T) {
+	l := &Local{}
+	l.Start(context.Background(), 3, Config{Threshold: 2, MaxTicket: 100})
+	waitSteps(t, []*Node{l.Node(0), l.Node(1), l.Node(2)}, 30)
+	l.Stop()
+
+	n := l.Node(0)
+	st := n.Status()
+	if st.Step < 30 || len(st.Peers) != 3 || st.Config["threshold"] != "2" {
+		t.Fatalf("bad status %+v", st)
+	}
+	for _, p := range st.Peers {
+		if !p.Healthy {
+			t.Errorf("peer %v unhealthy: %v", p.Name, p.Detail)
+		}
+	}
+	if len(st.History) == 0 || len(st.History) > status.HistoryTail {
+		t.Fatalf("bad history %v", st.History)
+	}
+	for i, c := range st.History {
+		best, commit, ok := n.Decision(int(c.Step))
+		if !ok || !commit ||
+			c.Value != fmt.Sprintf("proposal of node %d", best) {
+			t.Errorf("history %v: decision %v %v %v", c, best, commit, ok)
+		}
+		if i > 0 && c.Step <= st.History[i-1].Step {
+			t.Errorf("history out of order: %v", st.History)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	status.Handler(n).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "node 0 (self)") {
+		t.Errorf("status page lacks self:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,730 @@
+package dist
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+)
+
+// Host describes one member of a group that communicates over TLS/TCP.
+type Host struct {
+	Name string // Host name the member's certificate is issued for
+	Addr string // TCP address the member accepts connections on
+}
+
+// TCP runs a Node over TLS/TCP connections,
+// authenticating each member of the group by a certificate
+// issued for its host name.
+// Each node dials a connection to each other node to send its messages on,
+// and accepts connections from the others to receive theirs on.
+// Senders redial failed connections with exponential backoff
+// as Config.Backoff specifies.
+// On each new connection from a peer, the node requests via Sync
+// the messages the peer may have sent on a failed connection
+// that the node never read, so that failures lose no messages
+// while the peer's HistoryPolicy retains them.
+//
+// With Multiplex set, each pair of nodes instead shares one connection,
+// which the node with the lower node number dials,
+// and both nodes send their messages on it,
+// halving the number of connections and letting firewalls
+// admit connections in one direction only.
+// All nodes in a group must set Multiplex alike:
+// nodes close connections from peers that disagree.
+//
+// Each connection starts with a handshake carrying the sender's node number
+// and Version. The receiver checks that the client certificate
+// the connection authenticated is issued for that node's host name,
+// and closes connections from peers whose versions are incompatible.
+//
+// Reload replaces the TLS configuration while the node runs,
+// e.g., to renew certificates before they expire,
+// and WatchTLS does so each time the certificate files change
+// or the process receives a signal such as SIGHUP.
+// Alternatively, the TLS configuration's own callbacks,
+// such as GetCertificate, may supply fresh certificates for each connection.
+//
+// Peers' addresses may name their hosts by DNS name rather than IP address.
+// The node resolves a peer's name each time it dials the peer,
+// racing connection attempts to the name's IPv6 and IPv4 addresses
+// as Happy Eyeballs (RFC 8305) does, so that dual-stack peers
+// are reachable even if one address family is broken.
+// With Resolve set, the node also re-resolves peers' names periodically,
+// replacing connections to addresses the names no longer resolve to,
+// so that peers may move to new addresses while the group runs.
+//
+// With a nil TLS configuration, the transport uses plain TCP
+// and trusts the node numbers in handshakes,
+// which is suitable only for tests and trusted networks.
+//
+type TCP struct {
+	Node
+
+	// Jitter is the maximum random delay to add before delivering
+	// each message received, for experimenting with network delays.
+	Jitter time.Duration
+
+	// Yield is an optional hook called at each message delivery
+	// and around the node's mutex when dispatching messages.
+	// Tests may use it to perturb goroutine scheduling: see package chaos.
+	Yield func()
+
+	// Codec selects the wire encoding of the connections the node dials.
+	// The node accepts connections in either encoding,
+	// so a group may switch codecs one node at a time.
+	// If used, Codec must be set before calling Start.
+	Codec Codec
+
+	// Multiplex sends messages in both directions
+	// on a single connection between each pair of nodes.
+	// If used, Multiplex must be set before calling Start.
+	Multiplex bool
+
+	// Lookup optionally resolves host names in peers' addresses
+	// to IP addresses, and defaults to net.DefaultResolver.LookupHost.
+	Lookup func(ctx context.Context, host string) ([]string, error)
+
+	// Resolve is how often to re-resolve peers' host names, or 0 for never.
+	Resolve time.Duration
+
+	// FallbackDelay is how long to wait for a connection attempt
+	// to one of a peer's addresses before racing an attempt to the next,
+	// or 0 for 250ms. A negative delay disables racing.
+	FallbackDelay time.Duration
+
+	ln    net.Listener       // listener accepting connections from peers
+	hosts []Host             // host name and address of each node
+	peers []*tcpPeer         // sender for each peer, nil for ourselves
+	wg    sync.WaitGroup     // counts running goroutines
+	stop  context.CancelFunc // shuts down the sender goroutines
+
+	mut    sync.Mutex            // protects the fields below
+	tls    *tls.Config           // TLS configuration, or nil for plain TCP
+	gen    int                   // number of times tls was reloaded
+	conns  map[net.Conn]*tcpConn // accepted connections to close on Stop
+	closed bool                  // set once Stop closes them
+}
+
+// tcpConn describes a connection accepted from a peer.
+type tcpConn struct {
+	tls  *tls.Conn // TLS layer of the connection, or nil for plain TCP
+	from int       // node number of the peer, or -1 before the handshake
+}
+
+// How long to wait for a peer to finish reading a connection we close
+const lingerTimeout = 10 * time.Second
+
+// Reported when a connection we dialed fails, before we redial it.
+var errLost = errors.New("connection to peer lost")
+
+// tcpHello is the handshake at the start of each connection.
+type tcpHello struct {
+	From    int     // sender's node number
+	Version Version // sender's version
+	Mux     bool    // whether the connection is multiplexed
+}
+
+// TLSConfig returns a TLS configuration for a TCP node
+// that presents certificate cert,
+// and trusts the certificates in pool, such as the group's own certificates,
+// to authenticate both the nodes it dials and the nodes dialing it.
+func TLSConfig(cert tls.Certificate, pool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+// Start runs this node as member self of a consensus group
+// whose members are hosts, in the same order on all nodes,
+// accepting connections from peers on ln,
+// which must be listening on hosts[self].Addr.
+// The node authenticates connections via tlsConf,
+// which must present this node's certificate and verify its peers' both
+// as a client and as a server, as TLSConfig's result does,
+// or uses plain TCP if tlsConf is nil.
+// The node runs until Stop is called or ctx is cancelled.
+//
+func (t *TCP) Start(ctx context.Context, self int, ln net.Listener,
+	hosts []Host, tlsConf *tls.Config, conf Config) error {
+
+	if self < 0 || self >= len(hosts) {
+		return errors.New("node number out of range")
+	}
+	if conf.Rejoin != nil {
+		if err := checkRejoin(conf.Rejoin, self, len(hosts)); err != nil {
+			return err
+		}
+	}
+	t.ln, t.hosts, t.tls = ln, hosts, tlsConf
+	t.conns = make(map[net.Conn]*tcpConn)
+	ctx, t.stop = context.WithCancel(ctx)
+
+	// Create a sender for each peer, which delivers to ourselves locally.
+	sender := make([]peer, len(hosts))
+	t.peers = make([]*tcpPeer, len(hosts))
+	for i := range hosts {
+		if i == self {
+			sender[i] = &tcpSelf{t}
+			continue
+		}
+		tp := &tcpPeer{t: t, host: hosts[i], index: i,
+			mux: t.Multiplex, dials: !t.Multiplex || self < i,
+			bo: backoff.Backoff{Config: conf.Backoff},
+			in: make(chan tcpMux, 1)}
+		tp.cond.L = &tp.mut
+		sender[i], t.peers[i] = tp, tp
+		t.wg.Add(1)
+		go tp.run(ctx)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.init(self, sender, conf)
+	if t.Resolve > 0 {
+		t.wg.Add(1)
+		go t.runResolve(ctx)
+	}
+	t.wg.Add(2)
+	go t.runAccept()
+	go func() {
+		defer t.wg.Done()
+		t.runProbe(ctx)
+	}()
+
+	// Start the first time step, or rejoin the group
+	t.startTLC(conf.Rejoin)
+	return nil
+}
+
+// Stop shuts down the node's communication with its peers,
+// closing its listener and all its connections.
+func (t *TCP) Stop() {
+	t.stop()
+	t.ln.Close()
+
+	t.mut.Lock()
+	t.closed = true
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mut.Unlock()
+
+	t.wg.Wait()
+}
+
+// Reload replaces the TLS configuration the node authenticates
+// its connections with, without restarting the node.
+// The node's senders gracefully close their connections to peers,
+// letting the peers read the messages in transit,
+// and redial them with the new configuration
+// before sending their next messages.
+// The node also closes connections accepted from peers
+// whose certificates the new configuration no longer trusts,
+// e.g., because they have expired or been issued by a retired root,
+// and requests the messages lost in transit on them
+// once the peers reconnect, as on any failed connection.
+// Connections the new configuration still trusts remain open,
+// until the peers that dialed them reload their own configurations.
+//
+func (t *TCP) Reload(tlsConf *tls.Config) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.tls = tlsConf
+	t.gen++
+	for c, tc := range t.conns {
+		if tc.tls != nil && tc.from >= 0 && tlsConf != nil &&
+			!verifyClient(tc.tls, t.hosts[tc.from].Name, tlsConf) {
+			c.Close()
+		}
+	}
+}
+
+// Return the current TLS configuration and the number of reloads so far.
+func (t *TCP) config() (*tls.Config, int) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	return t.tls, t.gen
+}
+
+// Return true if the client certificate a connection authenticated
+// is still valid for host name under TLS configuration conf.
+func verifyClient(tc *tls.Conn, name string, conf *tls.Config) bool {
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return false
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         conf.ClientCAs,
+		Intermediates: inter,
+		DNSName:       name,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// Accept connections from peers until the listener is closed.
+func (t *TCP) runAccept() {
+	defer t.wg.Done()
+
+	for {
+		c, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		t.mut.Lock()
+		if t.closed {
+			t.mut.Unlock()
+			c.Close()
+			return
+		}
+		tc := &tcpConn{from: -1}
+		if t.tls != nil {
+			tc.tls = tls.Server(c, t.tls)
+		}
+		t.conns[c] = tc
+		t.wg.Add(1)
+		t.mut.Unlock()
+
+		go t.handle(c, tc)
+	}
+}
+
+// Handle an incoming connection carrying messages from another node.
+func (t *TCP) handle(c net.Conn, tc *tcpConn) {
+	defer func() {
+		t.mut.Lock()
+		delete(t.conns, c)
+		t.mut.Unlock()
+		c.Close()
+		t.wg.Done()
+	}()
+
+	conn := c
+	if tc.tls != nil {
+		conn = tc.tls
+	}
+
+	// Check the sender's identity and version before accepting messages.
+	dec, codec, err := newDecoder(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	var h tcpHello
+	if err := dec.Decode(&h); err != nil ||
+		h.From < 0 || h.From >= len(t.hosts) {
+		return
+	}
+	if tc.tls != nil {
+		certs := tc.tls.ConnectionState().PeerCertificates
+		if len(certs) == 0 ||
+			certs[0].VerifyHostname(t.hosts[h.From].Name) != nil {
+			return
+		}
+	}
+	if h.Mux != t.Multiplex || (h.Mux && h.From >= t.self) {
+		return // only lower-numbered peers dial multiplexed connections
+	}
+	if t.hello(h.From, h.Version) != nil {
+		return
+	}
+	t.mut.Lock()
+	tc.from = h.From
+	t.mut.Unlock()
+
+	// Reply to a multiplexed connection with our own handshake,
+	// then hand it to our sender for that peer.
+	if h.Mux {
+		enc := newEncoder(codec, conn)
+		if err := enc.Encode(t.helloMsg()); err != nil {
+			return
+		}
+		tp := t.peers[h.From]
+		defer tp.broken(conn)
+		tp.accept(conn, enc)
+	}
+	t.read(dec, h.From)
+}
+
+// Receive messages from peer from on a connection until it fails.
+func (t *TCP) read(dec decoder, from int) {
+	for {
+		msg := getMessage()
+		if err := dec.Decode(msg); err != nil {
+			return
+		}
+
+		// The connection authenticates the sender; don't let it impersonate.
+		if msg.From != from {
+			return
+		}
+		if t.Jitter > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(t.Jitter) + 1)))
+		}
+		t.receive(msg)
+	}
+}
+
+// Return the handshake we send at the start of each connection.
+func (t *TCP) helloMsg() *tcpHello {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return &tcpHello{From: t.self, Version: t.vers.local, Mux: t.Multiplex}
+}
+
+// Check the version a peer sent at the start of a connection,
+// and request the messages the peer may have sent us
+// on an earlier connection that failed before we read them.
+func (t *TCP) hello(from int, v Version) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.helloVersion(from, v); err != nil {
+		return err
+	}
+	t.reconnected(from)
+	return nil
+}
+
+// Dispatch a received message into the node's protocol stack.
+func (t *TCP) receive(msg *Message) {
+	t.yield()
+	t.mutex.Lock()
+	defer func() {
+		t.mutex.Unlock()
+		t.yield()
+	}()
+
+	// A sender may retransmit messages after its connection fails,
+	// so ignore messages we have already received.
+	t.receiveMessage(msg)
+}
+
+func (t *TCP) yield() {
+	if t.Yield != nil {
+		t.Yield()
+	}
+}
+
+// tcpPeer queues messages for a remote node
+// and sends them in order on a TLS/TCP connection.
+// On multiplexed connections the peer with the higher node number
+// accepts rather than dials the connection,
+// and both peers also receive messages on it.
+type tcpPeer struct {
+	t     *TCP            // node we're sending for
+	host  Host            // host name and address of the destination
+	index int             // node number of the destination
+	mux   bool            // whether connections are multiplexed
+	dials bool            // whether we dial rather than accept connections
+	bo    backoff.Backoff // backoff state for redialing it
+	in    chan tcpMux     // multiplexed connection accepted from the peer
+
+	mut   sync.Mutex        // protects the fields below
+	cond  sync.Cond         // signals messages, broken connections, shutdown
+	q     []Message         // messages not yet sent
+	lost  map[net.Conn]bool // connections found broken since last checked
+	raddr string            // address we last dialed the peer at
+	moved bool              // set when the name stops resolving to raddr
+	done  bool              // set once the sender shuts down
+}
+
+// tcpMux is a multiplexed connection accepted from a peer,
+// with an encoder that has sent our handshake on it.
+type tcpMux struct {
+	c   net.Conn
+	enc encoder
+}
+
+func (tp *tcpPeer) Send(msg *Message) {
+	tp.mut.Lock()
+	defer tp.mut.Unlock()
+
+	if !tp.done {
+		tp.q = append(tp.q, *msg)
+		tp.cond.Signal()
+	}
+}
+
+// Send queued messages until the context is cancelled,
+// redialing the connection with exponential backoff on errors.
+func (tp *tcpPeer) run(ctx context.Context) {
+	defer tp.t.wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		tp.mut.Lock()
+		tp.done = true
+		tp.cond.Broadcast()
+		tp.mut.Unlock()
+	}()
+
+	var c net.Conn
+	var enc encoder
+	var unhook func() bool
+	var gen int // number of reloads when we dialed c
+	drop := func() {
+		unhook()
+		c.Close()
+		c = nil
+	}
+	defer func() {
+		if c != nil {
+			drop()
+		}
+	}()
+
+	for {
+		// Wait for a message to send, or for a broken connection,
+		// which we redial right away so that the peer can request
+		// the messages lost on it, and send on it again if multiplexed.
+		tp.mut.Lock()
+		for len(tp.q) == 0 && !tp.done && (c == nil || !tp.lost[c]) &&
+			!(tp.mux && tp.dials && c == nil) && !(c != nil && tp.moved) {
+			tp.cond.Wait()
+		}
+		if tp.done {
+			tp.mut.Unlock()
+			return
+		}
+		var msg *Message
+		if len(tp.q) > 0 {
+			m := tp.q[0]
+			msg = &m
+		}
+		lost := c != nil && tp.lost[c]
+		moved := tp.moved
+		tp.lost, tp.moved = nil, false
+		tp.mut.Unlock()
+
+		if lost {
+			drop()
+		}
+
+		// Replace the connection once the TLS configuration is reloaded,
+		// or the peer has moved, letting the peer finish reading the old one.
+		if _, g := tp.t.config(); tp.dials && c != nil &&
+			(g != gen || moved) {
+			unhook()
+			switch { // our receiver or watcher closes it at EOF
+			case !closeWrite(c):
+				c.Close()
+			case !tp.mux:
+				c.SetReadDeadline(time.Now().Add(lingerTimeout))
+			}
+			c = nil
+		}
+
+		try := func() error {
+			if lost && tp.dials {
+				// Back off before redialing a connection lost,
+				// in case the peer keeps closing our connections.
+				lost = false
+				return errLost
+			}
+			if !tp.dials {
+				if err := tp.await(ctx, &c, &enc); err != nil {
+					return err
+				}
+				unhook = func() bool { return false }
+			} else if c == nil {
+				conf, g := tp.t.config()
+				nc, err := tp.dial(ctx, conf)
+				if err != nil {
+					return err
+				}
+				gen = g
+				c, enc = nc, newEncoder(tp.t.Codec, nc)
+				unhook = context.AfterFunc(ctx, func() { nc.Close() })
+				if err := enc.Encode(tp.t.helloMsg()); err != nil {
+					drop()
+					return err
+				}
+				tp.t.wg.Add(1)
+				if tp.mux {
+					go tp.receive(ctx, nc)
+				} else {
+					go tp.watch(ctx, nc)
+				}
+			}
+			if msg == nil {
+				return nil
+			}
+			if err := enc.Encode(msg); err != nil {
+				drop()
+				return err
+			}
+			return nil
+		}
+		if err := tp.bo.Retry(ctx, try); err != nil {
+			return // context cancelled
+		}
+
+		if msg != nil {
+			tp.mut.Lock()
+			tp.q = tp.q[1:]
+			tp.mut.Unlock()
+		}
+	}
+}
+
+// Switch to the latest multiplexed connection the peer dialed us,
+// waiting for one if we have none.
+// We leave any connection we switch from for its receiver to close
+// once the peer finishes with it.
+func (tp *tcpPeer) await(ctx context.Context, c *net.Conn, enc *encoder) error {
+	select {
+	case m := <-tp.in:
+		*c, *enc = m.c, m.enc
+		return nil
+	default:
+	}
+	if *c != nil {
+		return nil
+	}
+	select {
+	case m := <-tp.in:
+		*c, *enc = m.c, m.enc
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Hand a multiplexed connection the peer dialed us to our sender,
+// replacing any earlier one the sender has not yet taken.
+func (tp *tcpPeer) accept(c net.Conn, enc encoder) {
+	for {
+		select {
+		case tp.in <- tcpMux{c, enc}:
+			return
+		default:
+		}
+		select {
+		case <-tp.in:
+		default:
+		}
+	}
+}
+
+// Note that a connection has failed or the peer has closed it,
+// waking the sender to drop it and redial or await a new one.
+func (tp *tcpPeer) broken(c net.Conn) {
+	tp.mut.Lock()
+	defer tp.mut.Unlock()
+
+	if tp.lost == nil {
+		tp.lost = make(map[net.Conn]bool)
+	}
+	tp.lost[c] = true
+	tp.cond.Broadcast()
+}
+
+// Receive the messages the peer sends on a multiplexed connection we dialed,
+// after checking its handshake, until the connection fails.
+func (tp *tcpPeer) receive(ctx context.Context, c net.Conn) {
+	defer tp.t.wg.Done()
+	defer c.Close()
+	defer context.AfterFunc(ctx, func() { c.Close() })()
+	defer tp.broken(c)
+
+	dec, _, err := newDecoder(bufio.NewReader(c))
+	if err != nil {
+		return
+	}
+	var h tcpHello
+	if err := dec.Decode(&h); err != nil || h.From != tp.index || !h.Mux {
+		return
+	}
+	if tp.t.hello(h.From, h.Version) != nil {
+		return
+	}
+	tp.t.read(dec, h.From)
+}
+
+// Dial a connection to the destination node
+// with TLS configuration tlsConf, or plain TCP if it is nil.
+func (tp *tcpPeer) dial(ctx context.Context, tlsConf *tls.Config) (
+	net.Conn, error) {
+
+	addrs, err := tp.t.resolve(ctx, tp.host.Addr)
+	if err != nil {
+		return nil, err
+	}
+	delay := tp.t.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	c, err := dialRace(ctx, addrs, delay)
+	if err != nil {
+		return nil, err
+	}
+	tp.mut.Lock()
+	tp.raddr, tp.moved = c.RemoteAddr().String(), false
+	tp.mut.Unlock()
+
+	if tlsConf == nil {
+		return c, nil
+	}
+	conf := tlsConf.Clone()
+	conf.ServerName = tp.host.Name
+	tc := tls.Client(c, conf)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Watch a connection we dialed to send messages on, which the peer
+// sends nothing on, until the peer closes it or it fails,
+// waking the sender to redial the peer, which then requests
+// the messages it may have lost on this connection.
+// When we close our side of the connection to replace it,
+// the peer closes its side once it has read everything we sent.
+func (tp *tcpPeer) watch(ctx context.Context, c net.Conn) {
+	defer tp.t.wg.Done()
+	defer c.Close()
+	defer context.AfterFunc(ctx, func() { c.Close() })()
+	defer tp.broken(c)
+
+	io.Copy(io.Discard, c)
+}
+
+// Shut down our side of a connection, if it supports that,
+// returning true if it does.
+func closeWrite(c net.Conn) bool {
+	cw, ok := c.(interface{ CloseWrite() error })
+	return ok && cw.CloseWrite() == nil
+}
+
+// tcpSelf delivers messages a node sends to itself.
+type tcpSelf struct {
+	t *TCP
+}
+
+func (ts *tcpSelf) Send(msg *Message) {
+	m := getMessage()
+	*m = *msg
+	go ts.t.receive(m) // the sender holds the node's mutex
+}
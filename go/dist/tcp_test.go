@@ -0,0 +1,458 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/gob"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run a group of four nodes over TLS/TCP on loopback,
+// one of which holds a certificate for the wrong host name,
+// and check that the others commit consistently without it.
+func TestTCP(t *testing.T) {
+	const nnodes, steps = 4, 30
+
+	lns := make([]net.Listener, nnodes)
+	hosts := make([]Host, nnodes)
+	certs := make([]tls.Certificate, nnodes)
+	pool := x509.NewCertPool()
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i] = Host{Name: fmt.Sprintf("host%v", i),
+			Addr: ln.Addr().String()}
+		name := hosts[i].Name
+		if i == nnodes-1 {
+			name = "impostor"
+		}
+		certb, privb := createCert(name)
+		if certs[i], err = tls.X509KeyPair(certb, privb); err != nil {
+			t.Fatal(err)
+		}
+		pool.AppendCertsFromPEM(certb)
+		lns[i] = ln
+	}
+
+	// Record the commits each node reports.
+	mut := sync.Mutex{}
+	commits := make([]map[int]int, nnodes)
+	nodes := make([]*TCP, nnodes)
+	for i := range nodes {
+		i := i
+		commits[i] = make(map[int]int)
+		conf := Config{Threshold: 3, MaxTicket: 40,
+			Commit: func(step, best int) {
+				mut.Lock()
+				commits[i][step] = best
+				mut.Unlock()
+			}}
+		nodes[i] = &TCP{}
+		if err := nodes[i].Start(context.Background(), i, lns[i], hosts,
+			TLSConfig(certs[i], pool), conf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group := make([]*Node, nnodes-1)
+	for i := range group {
+		group[i] = &nodes[i].Node
+	}
+	waitSteps(t, group, steps)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, steps)
+
+	// The honest nodes accepted no messages from the impostor.
+	for _, n := range group {
+		if n.logLen(nnodes-1) != 0 {
+			t.Errorf("node %v accepted messages from impostor", n.self)
+		}
+	}
+
+	// The reported commits match the nodes' decisions.
+	for i, n := range group {
+		for s := 0; s < steps-RoundSteps; s++ {
+			best, commit, _ := n.Decision(s)
+			if b, ok := commits[i][s]; ok != commit || ok && b != best {
+				t.Errorf("node %v reported commit %v %v at step %v",
+					i, b, ok, s)
+			}
+		}
+	}
+}
+
+// Rotate the certificates and roots of a running group over TLS/TCP,
+// first to new certificates trusted alongside the old ones,
+// then to the new roots alone, checking that the group keeps progressing
+// and that connections authenticated by retired certificates close.
+func TestTCPReload(t *testing.T) {
+	const nnodes = 3
+	dir := t.TempDir()
+
+	// Create a generation of certificates for the group's hosts.
+	hosts := make([]Host, nnodes)
+	for i := range hosts {
+		hosts[i].Name = fmt.Sprintf("host%v", i)
+	}
+	type generation struct {
+		cert, key [][]byte
+		roots     []byte
+	}
+	newGen := func() (g generation) {
+		for _, h := range hosts {
+			certb, privb := createCert(h.Name)
+			g.cert = append(g.cert, certb)
+			g.key = append(g.key, privb)
+			g.roots = append(g.roots, certb...)
+		}
+		return g
+	}
+	config := func(g generation, i int, roots []byte) *tls.Config {
+		cert, err := tls.X509KeyPair(g.cert[i], g.key[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(roots)
+		return TLSConfig(cert, pool)
+	}
+
+	// Node 0 reloads its configuration from files as they change.
+	files := TLSFiles{Cert: dir + "/cert.pem", Key: dir + "/key.pem",
+		Roots: dir + "/roots.pem"}
+	write := func(g generation, roots []byte) {
+		for name, b := range map[string][]byte{files.Cert: g.cert[0],
+			files.Key: g.key[0], files.Roots: roots} {
+			if err := os.WriteFile(name, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	reload := func(nodes []*TCP, g generation, roots []byte) {
+		write(g, roots)
+		for i := 1; i < nnodes; i++ {
+			nodes[i].Reload(config(g, i, roots))
+		}
+	}
+
+	old := newGen()
+	write(old, old.roots)
+	lns := make([]net.Listener, nnodes)
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns[i], hosts[i].Addr = ln, ln.Addr().String()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = &TCP{}
+		group[i] = &nodes[i].Node
+		err := nodes[i].Start(ctx, i, lns[i], hosts,
+			config(old, i, old.roots), Config{Threshold: nnodes})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	go nodes[0].WatchTLS(ctx, files, nil, time.Millisecond,
+		func(error) {})
+	waitSteps(t, group, 10)
+
+	// An old client connection to node 1, claiming to be node 0.
+	oldConf := config(old, 0, old.roots)
+	oldConf.ServerName = hosts[1].Name
+	oc, err := tls.Dial("tcp", hosts[1].Addr, oldConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oc.Close()
+	gob.NewEncoder(oc).Encode(&tcpHello{From: 0,
+		Version: nodes[0].vers.local})
+
+	// Switch to new certificates, trusting both generations,
+	// so nodes that have not yet switched can still connect.
+	cur := newGen()
+	both := append(append([]byte{}, old.roots...), cur.roots...)
+	reload(nodes, cur, both)
+	waitSteps(t, group, 20)
+	waitCerts(t, nodes, cur.cert, oc)
+
+	// Then retire the old generation, which closes the old connection.
+	reload(nodes, cur, cur.roots)
+	oc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := oc.Read(make([]byte, 1)); err == nil ||
+		errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("retired certificate's connection remains open: %v", err)
+	}
+	waitSteps(t, group, 30)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, 30)
+}
+
+// Wait until each node's accepted connections from its peers,
+// other than the one from client oc, present the certificates in certs.
+func waitCerts(t *testing.T, nodes []*TCP, certs [][]byte, oc *tls.Conn) {
+	deadline := time.Now().Add(time.Minute)
+	for _, n := range nodes {
+		for !presents(n, certs, oc) {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %v still has old connections", n.self)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// Return true if all of a node's connections besides oc present certs.
+func presents(n *TCP, certs [][]byte, oc *tls.Conn) bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	for _, tc := range n.conns {
+		if tc.from < 0 ||
+			tc.tls.RemoteAddr().String() == oc.LocalAddr().String() {
+			continue
+		}
+		pc := tc.tls.ConnectionState().PeerCertificates
+		b, _ := pem.Decode(certs[tc.from])
+		if len(pc) == 0 || !bytes.Equal(pc[0].Raw, b.Bytes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run a group over TLS/TCP with multiplexed connections,
+// reloading every node's configuration midway so that they redial,
+// and check that each pair of nodes shares a single connection.
+func TestTCPMultiplex(t *testing.T) {
+	const nnodes = 4
+
+	lns := make([]*countListener, nnodes)
+	hosts := make([]Host, nnodes)
+	certs := make([]tls.Certificate, nnodes)
+	pool := x509.NewCertPool()
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i] = Host{Name: fmt.Sprintf("host%v", i),
+			Addr: ln.Addr().String()}
+		certb, privb := createCert(hosts[i].Name)
+		if certs[i], err = tls.X509KeyPair(certb, privb); err != nil {
+			t.Fatal(err)
+		}
+		pool.AppendCertsFromPEM(certb)
+		lns[i] = &countListener{Listener: ln}
+	}
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = &TCP{Multiplex: true, Codec: Codec(i % 2)}
+		group[i] = &nodes[i].Node
+		if err := nodes[i].Start(context.Background(), i, lns[i], hosts,
+			TLSConfig(certs[i], pool), Config{Threshold: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSteps(t, group, 10)
+
+	// A peer that does not multiplex its connection is turned away.
+	conf := TLSConfig(certs[0], pool)
+	conf.ServerName = hosts[1].Name
+	oc, err := tls.Dial("tcp", hosts[1].Addr, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oc.Close()
+	gob.NewEncoder(oc).Encode(&tcpHello{From: 0,
+		Version: nodes[0].vers.local})
+	oc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := oc.Read(make([]byte, 1)); err == nil ||
+		errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unmultiplexed connection remains open: %v", err)
+	}
+
+	for i, n := range nodes {
+		n.Reload(TLSConfig(certs[i], pool))
+	}
+	waitSteps(t, group, 30)
+
+	// Each node has live connections from every lower-numbered node,
+	// and node 0, which no node dials, accepted none.
+	for _, n := range nodes {
+		from := map[int]bool{}
+		n.mut.Lock()
+		for _, tc := range n.conns {
+			if tc.from >= 0 {
+				from[tc.from] = true
+			}
+		}
+		n.mut.Unlock()
+		if len(from) != n.self {
+			t.Errorf("node %v has connections from %v", n.self, from)
+		}
+	}
+	if n := lns[0].n.Load(); n != 0 {
+		t.Errorf("node 0 accepted %v connections", n)
+	}
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, 30)
+}
+
+// Run groups over TLS/TCP, with and without multiplexing,
+// while repeatedly breaking the connections nodes accept from their peers,
+// and check that every node still reaches the target step:
+// each node must recover the messages lost in transit on a broken connection.
+func TestTCPBreak(t *testing.T) {
+	for _, mux := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Multiplex=%v", mux), func(t *testing.T) {
+			testTCPDisrupt(t, mux, func(nodes []*TCP,
+				lns []*breakListener, i int) {
+				lns[i].breakAll()
+			})
+		})
+	}
+}
+
+// Run groups over TLS/TCP, with and without multiplexing,
+// while the nodes repeatedly reload their TLS configurations,
+// and check that every node still reaches the target step.
+func TestTCPReloadTraffic(t *testing.T) {
+	for _, mux := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Multiplex=%v", mux), func(t *testing.T) {
+			testTCPDisrupt(t, mux, func(nodes []*TCP,
+				lns []*breakListener, i int) {
+				conf, _ := nodes[i].config()
+				nodes[i].Reload(conf)
+			})
+		})
+	}
+}
+
+// Run a group of three nodes over TLS/TCP that needs every node's messages
+// to make progress, calling disrupt on a random node every millisecond
+// for a while, and check that the group then reaches a target step
+// and commits consistently.
+func testTCPDisrupt(t *testing.T, mux bool,
+	disrupt func(nodes []*TCP, lns []*breakListener, i int)) {
+
+	const nnodes = 3
+
+	lns := make([]*breakListener, nnodes)
+	hosts := make([]Host, nnodes)
+	certs := make([]tls.Certificate, nnodes)
+	pool := x509.NewCertPool()
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i] = Host{Name: fmt.Sprintf("host%v", i),
+			Addr: ln.Addr().String()}
+		certb, privb := createCert(hosts[i].Name)
+		if certs[i], err = tls.X509KeyPair(certb, privb); err != nil {
+			t.Fatal(err)
+		}
+		pool.AppendCertsFromPEM(certb)
+		lns[i] = &breakListener{Listener: ln}
+	}
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	for i := range nodes {
+		nodes[i] = &TCP{Multiplex: mux}
+		group[i] = &nodes[i].Node
+		if err := nodes[i].Start(context.Background(), i, lns[i], hosts,
+			TLSConfig(certs[i], pool),
+			Config{Threshold: nnodes}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitSteps(t, group, 10)
+	for i := 0; i < 200; i++ {
+		time.Sleep(time.Millisecond)
+		disrupt(nodes, lns, rand.Intn(nnodes))
+	}
+
+	// Messages lost in transit would leave some node stuck.
+	steps := 0
+	for _, n := range group {
+		if s := n.step(); s > steps {
+			steps = s
+		}
+	}
+	steps += 10
+	waitSteps(t, group, steps)
+
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, steps)
+}
+
+// A listener that can break all the connections it has accepted.
+type breakListener struct {
+	net.Listener
+	mut   sync.Mutex
+	conns []net.Conn
+}
+
+func (l *breakListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mut.Lock()
+		l.conns = append(l.conns, c)
+		l.mut.Unlock()
+	}
+	return c, err
+}
+
+// Abruptly close all the connections accepted so far.
+func (l *breakListener) breakAll() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	for _, c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+}
+
+// A listener counting the connections it accepts.
+type countListener struct {
+	net.Listener
+	n atomic.Int64
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.n.Add(1)
+	}
+	return c, err
+}
@@ -0,0 +1,142 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/config"
+)
+
+// StartFile runs this node as the member of a consensus group
+// that the node section of configuration file f selects,
+// as Start does with the file's members as hosts.
+// The node listens on the node's listen address, or else its member's,
+// and authenticates connections with the node's TLS files, if any.
+// The file's threshold, and any tuning knobs it sets,
+// override those in conf and t, which may set further options such as hooks.
+//
+// If the node section names a snapshot file,
+// the node persists its state there before each message it sends,
+// and rejoins the group from the file if it already exists.
+// Since a node could not safely rejoin after sending messages
+// its snapshot lacks, the node panics if it fails to persist its state.
+//
+func (t *TCP) StartFile(ctx context.Context, f *config.File,
+	conf Config) error {
+
+	n := f.Node
+	if n == nil {
+		return errors.New("configuration has no node section")
+	}
+	self := f.Index(n.Self)
+	hosts := make([]Host, len(f.Members))
+	for i, m := range f.Members {
+		hosts[i] = Host{Name: m.Name, Addr: m.Addr}
+	}
+
+	var tlsConf *tls.Config
+	if n.Cert != "" {
+		var err error
+		tlsConf, err = TLSFiles{n.Cert, n.Key, n.Roots}.Load()
+		if err != nil {
+			return err
+		}
+	}
+
+	conf.Threshold = f.Group.Threshold
+	if n.MaxTicket != 0 {
+		conf.MaxTicket = n.MaxTicket
+	}
+	if n.Probe != 0 {
+		conf.Probe = time.Duration(n.Probe)
+	}
+	if n.Snapshot != "" {
+		s, err := readSnapshot(n.Snapshot)
+		if err != nil {
+			return err
+		}
+		conf.Rejoin = s
+		conf.Persist = func(s *Snapshot) {
+			if err := writeSnapshot(n.Snapshot, s); err != nil {
+				panic("persisting snapshot: " + err.Error())
+			}
+		}
+	}
+
+	switch n.Codec {
+	case "gob":
+		t.Codec = GobCodec
+	case "binary":
+		t.Codec = BinaryCodec
+	}
+	if n.Multiplex {
+		t.Multiplex = true
+	}
+	if n.Resolve != 0 {
+		t.Resolve = time.Duration(n.Resolve)
+	}
+	if n.FallbackDelay != 0 {
+		t.FallbackDelay = time.Duration(n.FallbackDelay)
+	}
+
+	listen := n.Listen
+	if listen == "" {
+		listen = hosts[self].Addr
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	if err := t.Start(ctx, self, ln, hosts, tlsConf, conf); err != nil {
+		ln.Close()
+		return err
+	}
+	return nil
+}
+
+// Read a snapshot persisted in a file, or return nil if there is none.
+func readSnapshot(name string) (*Snapshot, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(s); err != nil {
+		return nil, errors.New(name + ": " + err.Error())
+	}
+	return s, nil
+}
+
+// Durably replace the snapshot persisted in a file.
+func writeSnapshot(name string, s *Snapshot) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(s); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,88 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/config"
+)
+
+// Run a group over TCP whose nodes start from configuration files,
+// restarting one node, which rejoins from its snapshot file.
+func TestTCPFile(t *testing.T) {
+	const nnodes = 4
+	snap := filepath.Join(t.TempDir(), "node3.snapshot")
+
+	// Find free ports for the members' addresses.
+	members := &strings.Builder{}
+	for i := 0; i < nnodes; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+		fmt.Fprintf(members, "[[member]]\nname = \"node%v\"\n"+
+			"addr = %q\n", i, ln.Addr().String())
+	}
+	file := func(i int) *config.File {
+		f, err := config.ParseTOML([]byte(fmt.Sprintf(`%v
+[node]
+self = "node%v"
+snapshot = %q
+codec = "binary"
+multiplex = true
+`, members, i, map[bool]string{true: snap}[i == 3])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	nodes := make([]*TCP, nnodes)
+	group := make([]*Node, nnodes)
+	start := func(i int) {
+		nodes[i] = &TCP{}
+		group[i] = &nodes[i].Node
+		if err := nodes[i].StartFile(context.Background(), file(i),
+			Config{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range nodes {
+		start(i)
+	}
+	if nodes[0].Codec != BinaryCodec || !nodes[0].Multiplex ||
+		nodes[0].thres != 3 {
+		t.Errorf("node not configured as its file specifies")
+	}
+	waitSteps(t, group, 10)
+
+	// Restart the last node, which rejoins where it left off.
+	nodes[3].Stop()
+	s, err := readSnapshot(snap)
+	if err != nil || s == nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	left := s.Template.Step
+	waitSteps(t, group[:3], left+10)
+	start(3)
+	if s := nodes[3].step(); s != left {
+		t.Errorf("node rejoined at step %v, not %v", s, left)
+	}
+	waitSteps(t, group, left+20)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, left+20)
+
+	f := file(0)
+	f.Node = nil
+	if err := (&TCP{}).StartFile(context.Background(), f,
+		Config{}); err == nil {
+		t.Errorf("started node with no node section")
+	}
+}
@@ -7,47 +7,90 @@ import (
 // Initialize the TLC layer state in a Node
 func (n *Node) initTLC() {
 	n.tmpl = Message{From: n.self, Step: -1}
-	n.stepLog = make([][]logEntry, len(n.peer))
+	n.stepLog = make([][]*Message, len(n.peer))
+	n.acks = make([][]int, len(n.peer))
 }
 
 // Broadcast a copy of our current message template to all nodes
 func (n *Node) broadcastTLC() *Message {
 
 	//println(n.self, n.tmpl.Step, "broadcast", msg, "typ", msg.Typ)
-	msg := n.tmpl
-	n.broadcastCausal(&msg)
-	return &msg
+	msg := n.newLogged(&n.tmpl)
+	n.broadcastCausal(msg)
+	return msg
 }
 
-// Unicast an acknowledgment of a given proposal to its sender
+// Acknowledge a given proposal to its sender,
+// on our next broadcast if we can piggyback it on one.
 func (n *Node) acknowledgeTLC(prop *Message) {
+	if n.piggybackTLC(prop.From) {
+		n.acks[prop.From] = append(n.acks[prop.From], prop.Seq)
+		return
+	}
+	n.unicastAck(prop.From, prop.Seq)
+}
+
+// Unicast an acknowledgment of proposal seq to node dest
+func (n *Node) unicastAck(dest, seq int) {
 
-	msg := n.tmpl
+	msg := getMessage()
+	*msg = n.tmpl
 	msg.Typ = Ack
-	msg.Prop = prop.Seq
-	n.sendCausal(prop.From, &msg)
+	msg.Prop = seq
+	n.stampClock(msg)
+	n.sendCausal(dest, msg)
+	putMessage(msg)
 }
 
 // Advance to a new time step.
 func (n *Node) advanceTLC(step int) {
-	//println(n.self, step, "advanceTLC",
-	//	"saw", len(n.saw[n.self]), "wit", len(n.wit[n.self]))
+	//println(n.self, step, "advanceTLC")
 
 	// Initialize our message template for new time step
-	n.tmpl.Step = step                     // Advance to new time step
-	n.tmpl.Typ = Prop                      // Raw unwitnessed proposal message initially
-	n.tmpl.Ticket = rand.Int31n(MaxTicket) // Choose a ticket
+	n.tmpl.Step = step                         // Advance to new time step
+	n.tmpl.Typ = Prop                          // Raw unwitnessed proposal message initially
+	n.tmpl.Ticket = rand.Int31n(n.MaxTicket()) // Choose a ticket
 
-	n.acks = make(set) // No acknowledgments received yet in this step
-	n.wits = make(set) // No threshold witnessed messages received yet
+	n.witness.Init(n.thres) // No acknowledgments or witnesses yet
 
 	// Notify the upper (QSC) layer of the advancement of time,
 	// and let it fill in its part of the new message to broadcast.
-	n.advanceQSC(n.saw[n.self], n.wit[n.self])
+	n.advanceQSC()
+
+	n.pruneHistory() // discard history our policy no longer needs
+
+	switch {
+	case n.observer:
+		// Just track our own view, which our log records.
+
+	case n.live.paused:
+		// Hold our proposal until a quorum is live to witness it.
+		// We can advance again only by hearing from a quorum,
+		// which resumes proposing first, so we never skip a proposal.
+		n.live.held = true
+		n.tmpl.Prop = -1 // no acknowledgments are for us yet
+
+	default:
+		prop := n.broadcastTLC() // broadcast our raw proposal
+		n.tmpl.Prop = prop.Seq   // save proposal's sequence number
+	}
+
+	if n.snap != nil {
+		n.snap(n.snapshot())
+	}
+}
+
+// Handle node from's acknowledgment of our proposal seq.
+func (n *Node) ackTLC(from, seq int) {
+	if seq == n.tmpl.Prop { // only if it acks our proposal
+		//println(n.self, n.tmpl.Step, "got ack", from)
+		if n.witness.Ack(from) {
 
-	prop := n.broadcastTLC() // broadcast our raw proposal
-	n.tmpl.Prop = prop.Seq   // save proposal's sequence number
-	n.acks.add(prop)         // automatically self-acknowledge  it
+			// Broadcast a threshold-witnesed certification
+			n.tmpl.Typ = Wit
+			n.broadcastTLC()
+		}
+	}
 }
 
 func (n *Node) receiveTLC(msg *Message) {
@@ -58,45 +101,38 @@ func (n *Node) receiveTLC(msg *Message) {
 	switch msg.Typ {
 	case Prop: // A raw unwitnessed proposal broadcast.
 
-		// Record the set of messages this node had seen
-		// by the time it advanced to this new time-step.
-		if len(n.stepLog[msg.From]) != msg.Step {
-			panic("out of sync")
+		// A promoted observer's step log starts at its first proposal.
+		if len(n.stepLog[msg.From]) == 0 &&
+			msg.Step > n.stepBase[msg.From] {
+			n.stepBase[msg.From] = msg.Step
 		}
-		n.stepLog[msg.From] = append(n.stepLog[msg.From],
-			logEntry{n.saw[msg.From], n.wit[msg.From]})
 
-		// Continue from pruned copies in the next time step
-		n.saw[msg.From] = n.saw[msg.From].copy(n.save)
-		n.wit[msg.From] = n.wit[msg.From].copy(n.save)
+		// Record the proposal, whose vector timestamp records
+		// the messages this node had seen by the time it advanced
+		// to this new time-step.
+		if n.stepBase[msg.From]+len(n.stepLog[msg.From]) != msg.Step {
+			n.fault(msg, "proposal for the wrong step")
+			return
+		}
+		n.stepLog[msg.From] = append(n.stepLog[msg.From], msg)
 
-		if msg.Step == n.tmpl.Step {
+		if msg.Step == n.tmpl.Step && !n.observer {
 			//println(n.self, n.tmpl.Step, "ack", msg.From)
 			n.acknowledgeTLC(msg)
 		}
 
 	case Ack: // An acknowledgment. Collect a threshold of acknowledgments.
-		if msg.Prop == n.tmpl.Prop { // only if it acks our proposal
-			n.acks.add(msg)
-			//println(n.self, n.tmpl.Step,  "got ack", len(n.acks))
-			if n.tmpl.Typ == Prop && len(n.acks) >= Threshold {
-
-				// Broadcast a threshold-witnesed certification
-				n.tmpl.Typ = Wit
-				n.broadcastTLC()
-			}
-		}
+		n.ackTLC(msg.From, msg.Prop)
 
 	case Wit: // A threshold-witnessed message. Collect a threshold of them.
-		prop := n.seqLog[msg.From][msg.Prop]
-		if prop.Typ != Prop {
-			panic("doesn't refer to a proposal!")
-		}
 		if msg.Step == n.tmpl.Step {
+			prop := n.logged(msg.From, msg.Prop)
+			if prop == nil || prop.Typ != Prop {
+				return // witCausal reported any fault
+			}
 
 			// Collect a threshold of Wit witnessed messages.
-			n.wits.add(prop) // witnessed messages in this step
-			if len(n.wits) >= Threshold {
+			if n.witness.Wit(prop.From) {
 
 				// We've met the condition to advance time.
 				n.advanceTLC(n.tmpl.Step + 1)
@@ -0,0 +1,93 @@
+package dist
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// TLSFiles names the PEM files holding a TCP node's TLS credentials.
+type TLSFiles struct {
+	Cert  string // Node's certificate, followed by any intermediates
+	Key   string // Node's private key
+	Roots string // Certificates of the roots the group trusts
+}
+
+// Load reads the files and returns a TLS configuration
+// that presents the node's certificate and trusts the roots,
+// as TLSConfig builds.
+func (f TLSFiles) Load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := os.ReadFile(f.Roots)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		return nil, errors.New(f.Roots + ": no certificates found")
+	}
+	return TLSConfig(cert, pool), nil
+}
+
+// Return the modification times of the files.
+func (f TLSFiles) stat() (mod [3]time.Time) {
+	for i, name := range []string{f.Cert, f.Key, f.Roots} {
+		if fi, err := os.Stat(name); err == nil {
+			mod[i] = fi.ModTime()
+		}
+	}
+	return mod
+}
+
+// WatchTLS reloads the node's TLS configuration from files
+// each time a value arrives on signal,
+// e.g., a SIGHUP the caller directed to it via signal.Notify,
+// and, if interval is positive, each time any of the files
+// changes, checking their modification times every interval.
+// If loading the files fails, e.g., because they are only partly updated,
+// WatchTLS reports the error via report, or log.Println if report is nil,
+// keeps the current configuration, and tries again at the next check.
+// WatchTLS runs until ctx is cancelled.
+//
+func (t *TCP) WatchTLS(ctx context.Context, files TLSFiles,
+	signal <-chan os.Signal, interval time.Duration, report func(error)) {
+
+	if report == nil {
+		report = func(err error) { log.Println(err.Error()) }
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	loaded := files.stat()
+	for {
+		select {
+		case <-signal:
+		case <-tick:
+			if files.stat() == loaded {
+				continue
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		mod := files.stat()
+		conf, err := files.Load()
+		if err != nil {
+			report(err)
+			continue
+		}
+		t.Reload(conf)
+		loaded = mod
+	}
+}
@@ -0,0 +1,287 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultRetransmit is the default initial retransmission timeout
+// for messages sent over unreliable datagrams.
+const DefaultRetransmit = 20 * time.Millisecond
+
+// Maximum retransmission timeout after exponential backoff
+const maxRetransmit = 2 * time.Second
+
+// Maximum size of a datagram we expect to receive
+const maxDatagram = 65536
+
+// UDP runs a Node over unreliable datagrams, such as UDP,
+// with its own message-level acknowledgment and retransmission layer.
+// Each message travels in its own datagram, which the receiver acknowledges
+// and the sender retransmits with exponential backoff until acknowledged.
+//
+// Because TLC's messages are idempotent, this layer needs no
+// link-level sequencing or receive windows:
+// datagrams may arrive in any order, the causal layer restores order,
+// and duplicates caused by lost acknowledgments are simply dropped.
+//
+// Datagrams involve no connections to perform a version handshake on,
+// so each message's datagram carries the sender's Version instead,
+// and the receiver neither acknowledges nor delivers messages
+// from peers whose versions are incompatible with its own.
+// Datagrams carrying no version come from releases
+// that predate negotiation, speaking protocol version 1.
+//
+// The transport does not authenticate or encrypt messages,
+// so it is intended for experiments on lossy networks
+// and for deployments on trusted LANs where latency matters most.
+//
+type UDP struct {
+	Node
+
+	// Retransmit is the initial retransmission timeout,
+	// or 0 for DefaultRetransmit.
+	Retransmit time.Duration
+
+	// Loss is the fraction of outgoing datagrams to drop deliberately,
+	// for experimenting with lossy networks.
+	Loss float64
+
+	conn  net.PacketConn // datagram socket we communicate through
+	addrs []net.Addr     // network address of each node in the group
+	index map[string]int // node number of each network address
+	wg    sync.WaitGroup // counts running goroutines
+	stop  context.CancelFunc
+
+	mut  sync.Mutex           // protects the fields below
+	next uint64               // next datagram identifier to assign
+	sent []map[uint64]*udpOut // unacknowledged datagrams to each peer
+}
+
+// udpPacket is the content of one datagram.
+type udpPacket struct {
+	ID    uint64   // sender-assigned datagram identifier
+	Msg   *Message // message carried, or nil for an acknowledgment
+	Hello *Version // sender's version, with each message carried
+}
+
+// udpOut records an unacknowledged datagram we sent.
+type udpOut struct {
+	buf  []byte        // encoded datagram
+	due  time.Time     // when to retransmit it
+	wait time.Duration // current retransmission timeout
+}
+
+// Start runs this node as member self of a consensus group
+// whose members communicate at addrs, in the same order on all nodes,
+// sending and receiving datagrams on conn,
+// which must be bound to addrs[self].
+// The node runs until Stop is called or ctx is cancelled.
+//
+func (u *UDP) Start(ctx context.Context, self int, conn net.PacketConn,
+	addrs []net.Addr, conf Config) error {
+
+	if self < 0 || self >= len(addrs) {
+		return errors.New("node number out of range")
+	}
+	if conf.Rejoin != nil {
+		if err := checkRejoin(conf.Rejoin, self, len(addrs)); err != nil {
+			return err
+		}
+	}
+	if u.Retransmit == 0 {
+		u.Retransmit = DefaultRetransmit
+	}
+	u.conn, u.addrs = conn, addrs
+	u.next = rand.Uint64() // don't reuse a previous run's identifiers
+	u.index = make(map[string]int)
+	u.sent = make([]map[uint64]*udpOut, len(addrs))
+	sender := make([]peer, len(addrs))
+	for i, addr := range addrs {
+		u.index[addr.String()] = i
+		u.sent[i] = make(map[uint64]*udpOut)
+		sender[i] = &udpPeer{u, i}
+	}
+	sender[self] = &udpSelf{u}
+	ctx, u.stop = context.WithCancel(ctx)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.init(self, sender, conf)
+
+	u.wg.Add(3)
+	go u.runReceive()
+	go u.runRetransmit(ctx)
+	go func() {
+		defer u.wg.Done()
+		u.runProbe(ctx)
+	}()
+
+	// Start the first time step, or rejoin the group
+	u.startTLC(conf.Rejoin)
+	return nil
+}
+
+// Stop shuts down the node's communication with its peers.
+// It leaves the datagram socket open for the caller to close.
+func (u *UDP) Stop() {
+	u.stop()
+	u.conn.SetReadDeadline(time.Now()) // unblock the receiver
+	u.wg.Wait()
+}
+
+// Receive datagrams, acknowledge them,
+// and dispatch their messages into the node's protocol stack.
+func (u *UDP) runReceive() {
+	defer u.wg.Done()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := u.conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) ||
+			errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue // e.g., ICMP errors from peers not yet up
+		}
+		from, ok := u.index[addr.String()]
+		if !ok {
+			continue // not a member of our group
+		}
+
+		var pkt udpPacket
+		dec := gob.NewDecoder(bytes.NewReader(buf[:n]))
+		if err := dec.Decode(&pkt); err != nil {
+			continue // corrupt datagram
+		}
+
+		if pkt.Msg == nil { // an acknowledgment of our datagram
+			u.mut.Lock()
+			delete(u.sent[from], pkt.ID)
+			u.mut.Unlock()
+			continue
+		}
+		if pkt.Msg.From != from {
+			continue // peers may not impersonate each other
+		}
+
+		// Acknowledge every copy, since earlier acks may have been lost,
+		// unless we reject the sender's version.
+		hello := legacyVersion
+		if pkt.Hello != nil {
+			hello = *pkt.Hello
+		}
+		if u.hello(from, hello) != nil {
+			continue // the sender will retransmit until it upgrades
+		}
+		u.write(from, u.encode(&udpPacket{ID: pkt.ID}))
+		u.receive(pkt.Msg)
+	}
+}
+
+// Check the version a peer sent with a message.
+func (u *UDP) hello(from int, v Version) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.helloVersion(from, v)
+}
+
+// Dispatch a received message into the node's protocol stack.
+func (u *UDP) receive(msg *Message) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	// Lost acknowledgments cause retransmissions,
+	// so ignore messages we have already received.
+	u.receiveMessage(msg)
+}
+
+// Periodically retransmit datagrams whose acknowledgments are overdue,
+// backing off exponentially for each datagram, until ctx is cancelled.
+func (u *UDP) runRetransmit(ctx context.Context) {
+	defer u.wg.Done()
+
+	tick := time.NewTicker(u.Retransmit / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			u.mut.Lock()
+			for dest, sent := range u.sent {
+				for _, out := range sent {
+					if now.Before(out.due) {
+						continue
+					}
+					out.wait *= 2
+					if out.wait > maxRetransmit {
+						out.wait = maxRetransmit
+					}
+					out.due = now.Add(out.wait)
+					u.write(dest, out.buf)
+				}
+			}
+			u.mut.Unlock()
+		}
+	}
+}
+
+// Encode a packet into a self-contained datagram.
+func (u *UDP) encode(pkt *udpPacket) []byte {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(pkt); err != nil {
+		panic("gob.Encode: " + err.Error())
+	}
+	return buf.Bytes()
+}
+
+// Send a datagram to a peer, perhaps dropping it deliberately.
+// Errors are ignored, as lost datagrams will be retransmitted.
+func (u *UDP) write(dest int, buf []byte) {
+	if u.Loss > 0 && rand.Float64() < u.Loss {
+		return
+	}
+	u.conn.WriteTo(buf, u.addrs[dest])
+}
+
+// udpPeer sends messages to a remote node via datagrams.
+type udpPeer struct {
+	u    *UDP // node we're sending for
+	dest int  // node number of the destination
+}
+
+func (up *udpPeer) Send(msg *Message) {
+	u := up.u
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	m := *msg
+	id := u.next
+	u.next++
+	out := &udpOut{buf: u.encode(&udpPacket{ID: id, Msg: &m,
+		Hello: &u.vers.local}), wait: u.Retransmit}
+	out.due = time.Now().Add(out.wait)
+	u.sent[up.dest][id] = out
+	u.write(up.dest, out.buf)
+}
+
+// udpSelf delivers messages a node sends to itself.
+type udpSelf struct {
+	u *UDP
+}
+
+func (us *udpSelf) Send(msg *Message) {
+	m := getMessage()
+	*m = *msg
+	go us.u.receive(m) // the sender holds the node's mutex
+}
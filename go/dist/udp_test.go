@@ -0,0 +1,102 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDP(t *testing.T) {
+	testUDP(t, 2, 3, 100, 0)   // Standard f=1 case, no loss
+	testUDP(t, 3, 5, 100, 0)   // Standard f=2 case, no loss
+	testUDP(t, 2, 3, 100, 0.2) // Lossy networks
+	testUDP(t, 3, 5, 50, 0.2)
+	testUDP(t, 3, 5, 20, 0.5)
+}
+
+// Run a consensus group of nnodes nodes over loopback UDP
+// with a given fraction of datagrams deliberately dropped,
+// until all nodes reach time-step maxSteps,
+// then check that the nodes' committed choices are consistent.
+func testUDP(t *testing.T, threshold, nnodes, maxSteps int, loss float64) {
+	desc := fmt.Sprintf("T=%v,N=%v,Steps=%v,Loss=%v",
+		threshold, nnodes, maxSteps, loss)
+	t.Run(desc, func(t *testing.T) {
+		conns := make([]net.PacketConn, nnodes)
+		addrs := make([]net.Addr, nnodes)
+		for i := range conns {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conns[i], addrs[i] = conn, conn.LocalAddr()
+		}
+
+		ctx := context.Background()
+		conf := Config{Threshold: threshold, MaxTicket: int32(10 * nnodes)}
+		nodes := make([]*UDP, nnodes)
+		for i := range nodes {
+			nodes[i] = &UDP{Loss: loss}
+			if err := nodes[i].Start(ctx, i, conns[i], addrs,
+				conf); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		group := make([]*Node, nnodes)
+		for i, n := range nodes {
+			group[i] = &n.Node
+		}
+		waitSteps(t, group, maxSteps)
+		for _, n := range nodes {
+			n.Stop()
+		}
+		checkCommits(t, group, maxSteps)
+	})
+}
+
+// Return the node's current time-step.
+func (n *Node) step() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.tmpl.Step
+}
+
+// Wait for all the nodes of a group to reach a given time-step.
+func waitSteps(t *testing.T, nodes []*Node, steps int) {
+	deadline := time.Now().Add(time.Minute)
+	for _, n := range nodes {
+		for n.step() < steps {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %v stuck at step %v", n.self, n.step())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// Check that the nodes of a group, having all completed at least steps
+// time-steps, committed the same proposal in each step they committed.
+func checkCommits(t *testing.T, nodes []*Node, steps int) {
+	hist := make([][]choice, len(nodes))
+	for i, n := range nodes {
+		n.mutex.Lock()
+		hist[i] = n.choice
+		n.mutex.Unlock()
+	}
+	for s := 0; s < steps-RoundSteps; s++ {
+		best := -1
+		for _, h := range hist {
+			if !h[s].commit {
+				continue
+			}
+			if best >= 0 && h[s].best != best {
+				t.Errorf("inconsistent commits at step %v", s)
+			}
+			best = h[s].best
+		}
+	}
+}
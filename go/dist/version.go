@@ -0,0 +1,188 @@
+package dist
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ProtocolVersion is the latest version of the message format
+// that this release of the package speaks.
+// Version 2 adds the Sync messages with which nodes rejoin their groups,
+// and version 3 acknowledgments piggybacked on broadcasts.
+const ProtocolVersion = 3
+
+// MinProtocolVersion is the earliest version of the message format
+// that this release of the package still speaks.
+const MinProtocolVersion = 1
+
+// Peers running releases that predate version negotiation
+// send no handshake, and speak this version of the message format.
+var legacyVersion = Version{Protocol: 1}
+
+// Version describes the range of message formats a node can speak,
+// both of this package's protocol and of the application's payloads,
+// which the node advertises to each peer in a handshake on connecting.
+//
+// Two nodes agree to speak the latest version each of the protocol and
+// the schema that both of them support, downgrading the newer node
+// to the version the older one speaks, and reject each other's messages
+// if they have no version of either in common.
+// A rolling upgrade across an incompatible change to the message format
+// therefore proceeds in two phases: first upgrade every node
+// to a release that speaks both formats but prefers the old one,
+// then, once GroupVersion reports that all peers speak the new format,
+// switch to it, raising the minimum version to drop the old format.
+// Never run a node that some peers accept but others reject:
+// nodes cannot deliver messages causally dependent on messages they reject,
+// so such a node stalls the peers that reject it.
+//
+type Version struct {
+	Protocol    int // Latest protocol version spoken, or 0 for ProtocolVersion
+	MinProtocol int // Earliest protocol version, or 0 for MinProtocolVersion
+	Schema      int // Latest application schema version spoken
+	MinSchema   int // Earliest application schema version, or 0 for Schema
+}
+
+// Fill in the defaults of a locally configured version.
+func (v Version) normalize() Version {
+	if v.Protocol <= 0 {
+		v.Protocol = ProtocolVersion
+	}
+	if v.MinProtocol <= 0 {
+		v.MinProtocol = MinProtocolVersion
+	}
+	if v.MinProtocol > v.Protocol {
+		v.MinProtocol = v.Protocol
+	}
+	if v.MinSchema <= 0 || v.MinSchema > v.Schema {
+		v.MinSchema = v.Schema
+	}
+	return v
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("protocol %d-%d, schema %d-%d",
+		v.MinProtocol, v.Protocol, v.MinSchema, v.Schema)
+}
+
+// ErrVersion is the error a VersionError wraps,
+// so that callers may detect version mismatches with errors.Is.
+var ErrVersion = errors.New("incompatible protocol version")
+
+// VersionError reports that a peer advertised a Version
+// having no protocol or schema version in common with our own,
+// so that we reject its messages.
+type VersionError struct {
+	Peer   int     // Node number of the peer
+	Local  Version // Version we advertised
+	Remote Version // Version the peer advertised
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%v: node %d speaks %v, we speak %v",
+		ErrVersion, e.Peer, e.Remote, e.Local)
+}
+
+// Unwrap returns ErrVersion.
+func (e *VersionError) Unwrap() error {
+	return ErrVersion
+}
+
+// Negotiate the version that two nodes advertising versions a and b speak,
+// returning false if they have no protocol or schema version in common.
+func negotiate(a, b Version) (Version, bool) {
+	b = b.normalize()
+	v := Version{Protocol: min(a.Protocol, b.Protocol),
+		Schema: min(a.Schema, b.Schema)}
+	if v.Protocol < max(a.MinProtocol, b.MinProtocol) ||
+		v.Schema < max(a.MinSchema, b.MinSchema) {
+		return Version{}, false
+	}
+	v.MinProtocol, v.MinSchema = v.Protocol, v.Schema
+	return v, true
+}
+
+// Version negotiation layer state for a Node.
+// It is protected by the node's stack mutex.
+type versions struct {
+	local  Version                 // Version we advertise to peers
+	remote []Version               // Version each peer last advertised
+	agreed []Version               // Version we speak with each peer
+	known  []bool                  // Whether we agreed on one with each peer
+	event  func(peer int, e error) // Mismatch event hook, or nil
+}
+
+// Initialize the version negotiation layer state in a Node.
+// We know only our own version until peers complete their handshakes.
+func (n *Node) initVersions(conf Config) {
+	v := conf.Version.normalize()
+	n.vers = versions{local: v, event: conf.Mismatch,
+		remote: make([]Version, len(n.peer)),
+		agreed: make([]Version, len(n.peer)),
+		known:  make([]bool, len(n.peer))}
+	n.vers.remote[n.self], n.vers.known[n.self] = v, true
+	n.vers.agreed[n.self], _ = negotiate(v, v)
+}
+
+// Handle the version a peer advertised in a handshake,
+// returning a VersionError if we must reject its messages.
+// Transports call it with the node's stack locked
+// on each new connection or, for datagrams, on each message,
+// so it does nothing if the peer's version did not change.
+func (n *Node) helloVersion(from int, remote Version) error {
+	vs := &n.vers
+	if remote == vs.remote[from] && vs.known[from] {
+		return nil
+	}
+	v, ok := negotiate(vs.local, remote)
+	if !ok {
+		err := &VersionError{Peer: from, Local: vs.local,
+			Remote: remote}
+		if vs.known[from] || remote != vs.remote[from] {
+			vs.remote[from], vs.known[from] = remote, false
+			if vs.event != nil {
+				vs.event(from, err)
+			}
+		}
+		return err
+	}
+	vs.remote[from], vs.agreed[from], vs.known[from] = remote, v, true
+	n.requestSync(from) // if we're rejoining and now know we can
+	return nil
+}
+
+// PeerVersion returns the protocol and schema version this Node
+// agreed to speak with peer node i, with MinProtocol equal to Protocol
+// and MinSchema equal to Schema,
+// or false if no handshake with that peer has yet succeeded.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) PeerVersion(i int) (Version, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.vers.agreed[i], n.vers.known[i]
+}
+
+// GroupVersion returns the latest protocol and schema version
+// that every node in the group speaks, as far as this Node knows,
+// which the application may safely use for messages to the whole group.
+// Peers with which no handshake has yet succeeded
+// are presumed to speak only this Node's minimum versions.
+// It may safely be called at any time, concurrently with the Node's operation.
+func (n *Node) GroupVersion() Version {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	vs := &n.vers
+	g := Version{Protocol: vs.local.Protocol, Schema: vs.local.Schema}
+	for i, v := range vs.agreed {
+		if !vs.known[i] {
+			v = Version{Protocol: vs.local.MinProtocol,
+				Schema: vs.local.MinSchema}
+		}
+		g.Protocol = min(g.Protocol, v.Protocol)
+		g.Schema = min(g.Schema, v.Schema)
+	}
+	g.MinProtocol, g.MinSchema = g.Protocol, g.Schema
+	return g
+}
@@ -0,0 +1,111 @@
+package dist
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// Test the negotiation of versions between pairs of nodes.
+func TestNegotiate(t *testing.T) {
+	for i, c := range []struct {
+		a, b Version
+		v    Version
+		ok   bool
+	}{
+		{Version{}, Version{}, Version{Protocol: 3, MinProtocol: 3}, true},
+		{Version{}, Version{Protocol: 1},
+			Version{Protocol: 1, MinProtocol: 1}, true},
+		{Version{MinProtocol: 2}, Version{Protocol: 1}, Version{}, false},
+		{Version{Schema: 3, MinSchema: 1}, Version{Schema: 2},
+			Version{Protocol: 3, MinProtocol: 3,
+				Schema: 2, MinSchema: 2}, true},
+		{Version{Schema: 3, MinSchema: 2}, Version{Schema: 1},
+			Version{}, false},
+	} {
+		v, ok := negotiate(c.a.normalize(), c.b)
+		if v != c.v || ok != c.ok {
+			t.Errorf("%d: negotiated %+v %v", i, v, ok)
+		}
+		if _, ok2 := negotiate(c.b.normalize(), c.a); ok2 != ok {
+			t.Errorf("%d: negotiation is asymmetric", i)
+		}
+	}
+}
+
+// Test a rolling upgrade of a UDP group, in which one node
+// has yet to upgrade from an older protocol the others still speak,
+// and another speaks only a protocol none of its peers speak.
+func TestUDPVersion(t *testing.T) {
+	const nnodes, maxSteps = 4, 50
+	conns := make([]net.PacketConn, nnodes)
+	addrs := make([]net.Addr, nnodes)
+	for i := range conns {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[i], addrs[i] = conn, conn.LocalAddr()
+	}
+
+	var mut sync.Mutex
+	var mismatches []error
+	ctx := context.Background()
+	nodes := make([]*UDP, nnodes)
+	for i := range nodes {
+		conf := Config{Threshold: 3, MaxTicket: 100,
+			Mismatch: func(peer int, err error) {
+				mut.Lock()
+				defer mut.Unlock()
+				mismatches = append(mismatches, err)
+			}}
+		switch i {
+		case 1: // not yet upgraded
+			conf.Version = Version{Protocol: 1}
+		case 3: // incompatible with every other node
+			conf.Version = Version{Protocol: 4, MinProtocol: 4}
+		}
+		nodes[i] = &UDP{}
+		if err := nodes[i].Start(ctx, i, conns[i], addrs,
+			conf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nodes 0-2 form a quorum, while node 3 gets stuck.
+	group := make([]*Node, nnodes-1)
+	for i := range group {
+		group[i] = &nodes[i].Node
+	}
+	waitSteps(t, group, maxSteps)
+	for _, n := range nodes {
+		n.Stop()
+	}
+	checkCommits(t, group, maxSteps)
+
+	if v, ok := nodes[0].PeerVersion(1); !ok || v.Protocol != 1 {
+		t.Errorf("node 0 speaks %+v %v with node 1", v, ok)
+	}
+	if v, ok := nodes[0].PeerVersion(2); !ok || v.Protocol != 3 {
+		t.Errorf("node 0 speaks %+v %v with node 2", v, ok)
+	}
+	for i := 0; i < 3; i++ {
+		if _, ok := nodes[i].PeerVersion(3); ok {
+			t.Errorf("node %d accepted node 3's version", i)
+		}
+	}
+	if v := nodes[0].GroupVersion(); v.Protocol != 1 {
+		t.Errorf("group version %+v", v)
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	var ve *VersionError
+	if len(mismatches) == 0 || !errors.As(mismatches[0], &ve) ||
+		!errors.Is(mismatches[0], ErrVersion) {
+		t.Errorf("mismatches %v", mismatches)
+	}
+}
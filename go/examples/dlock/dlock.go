@@ -0,0 +1,366 @@
+// Package dlock implements distributed mutexes and reader/writer locks
+// on top of any cas.Store, such as a replicated qscas.Group,
+// as an end-to-end example of building a service on the CAS abstraction.
+//
+// Clients sharing a Store each use a Mutex or RWMutex to lock it.
+// The Store's state records the leases of the lock's current holders:
+// either one writer, or any number of readers.
+// Each lease expires after a TTL unless its holder renews it,
+// so that a lock held by a client that crashes is recovered
+// once the client's lease expires, without any explicit cleanup.
+//
+// Each lease carries a fencing token that increases with each acquisition.
+// Since a client that pauses for longer than its TTL,
+// or whose clock runs slow, may believe it still holds a lock
+// after another client has acquired it,
+// the resources a lock protects should check fencing tokens,
+// rejecting requests bearing a lower token than the highest they have seen.
+// The CAS operations themselves never rely on clocks for safety:
+// no two leases ever bear the same token.
+//
+// Lock operations retry Store errors, such as timeouts,
+// until they succeed or the caller's context is done,
+// and recognize their own effects on the state when a failed access
+// in fact took effect, so that a retried acquisition never leaks a lease.
+//
+package dlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/lease"
+)
+
+// Lease describes one client's hold on a lock.
+type Lease struct {
+	lease.Lease      // Holder, fencing token, and expiry of the lease
+	Write       bool // Whether the lease is exclusive
+
+	nonce string // Unique identifier of the acquisition
+}
+
+// HeldError reports that a client could not acquire a lock
+// because another client holds a conflicting, unexpired lease.
+type HeldError = lease.HeldError
+
+// ErrHeld is the error a HeldError wraps,
+// so that callers may detect it with errors.Is.
+var ErrHeld = lease.ErrHeld
+
+// ErrLost is returned by Renew once the caller's lease has expired
+// and another client may have acquired the lock.
+var ErrLost = lease.ErrLost
+
+// RWMutex is a distributed reader/writer lock stored in a cas.Store.
+// Any number of clients may hold read leases on the lock at once,
+// or a single client may hold a write lease.
+//
+// ID identifies the client in the leases it acquires,
+// for diagnostic purposes only.
+// TTL is how long each lease lasts before it expires,
+// unless the client renews it.
+// Now optionally supplies the current time, and defaults to time.Now.
+// Backoff configures how lock operations retry failed Store accesses.
+// Unless Backoff's Report function aborts retries,
+// they continue until the caller's context is done,
+// except on errors that cas.Fatal classifies as fatal.
+//
+// Read leases do not prevent new readers from acquiring the lock
+// while a writer waits, so a steady stream of readers may starve writers.
+//
+// The caller must set Store and TTL before use,
+// after which an RWMutex is safe for concurrent use.
+//
+type RWMutex struct {
+	Store   cas.Store        // Store holding the lock's state
+	ID      string           // Identity of this client
+	TTL     time.Duration    // Lease duration
+	Now     func() time.Time // Clock, or nil for time.Now
+	Backoff backoff.Config   // Retry policy for Store accesses
+
+	reg lease.Register[state] // last state observed in the Store
+}
+
+// Mutex is a distributed mutual exclusion lock stored in a cas.Store.
+// It is an RWMutex without read leases,
+// and the caller configures it the same way.
+//
+type Mutex RWMutex
+
+// State of the lock in the Store.
+type state struct {
+	Token   int64    // Fencing token of the latest acquisition
+	Holders []holder // Current, and possibly expired, lease holders
+}
+
+// A lease holder in the Store's state.
+type holder struct {
+	Owner   string // Identity of the client
+	Nonce   string // Unique identifier of the acquisition
+	Token   int64  // Fencing token of the acquisition
+	Expires int64  // Expiry in Unix nanoseconds
+	Write   bool   // Whether the lease is exclusive
+}
+
+// Return the lease a holder describes.
+func (h *holder) lease() Lease {
+	return Lease{Lease: lease.Lease{Holder: h.Owner, Token: h.Token,
+		Expires: time.Unix(0, h.Expires)}, Write: h.Write, nonce: h.Nonce}
+}
+
+// Find the holder with a given nonce, if it has not expired.
+func (st *state) find(nonce string, now int64) *holder {
+	for i := range st.Holders {
+		h := &st.Holders[i]
+		if h.Nonce == nonce && now < h.Expires {
+			return h
+		}
+	}
+	return nil
+}
+
+// Return a copy of the state without its expired holders,
+// and without the holder with nonce drop, if any.
+func (st *state) prune(now int64, drop string) *state {
+	n := &state{Token: st.Token}
+	for _, h := range st.Holders {
+		if now < h.Expires && h.Nonce != drop {
+			n.Holders = append(n.Holders, h)
+		}
+	}
+	return n
+}
+
+// Return the current time.
+func (rw *RWMutex) now() time.Time {
+	return lease.Clock(rw.Now).Now()
+}
+
+// Update the lock state via f, as lease.Register.Update does,
+// retrying failed Store accesses according to rw.Backoff.
+func (rw *RWMutex) update(ctx context.Context,
+	f func(st *state) (*state, error)) (*state, error) {
+
+	return rw.reg.Update(ctx, rw.Store, &rw.Backoff, f)
+}
+
+// Return a new random acquisition nonce.
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("error generating nonce: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Try once to acquire a lease, of the given nonce.
+func (rw *RWMutex) acquire(ctx context.Context, write bool, nonce string) (
+	Lease, error) {
+
+	var l Lease
+	_, err := rw.update(ctx, func(st *state) (*state, error) {
+		now := rw.now().UnixNano()
+		if h := st.find(nonce, now); h != nil {
+			l = h.lease() // an earlier failed attempt took effect
+			return nil, nil
+		}
+
+		// Find the conflicting lease that expires last, if any.
+		var conflict *holder
+		for i := range st.Holders {
+			h := &st.Holders[i]
+			if now < h.Expires && (write || h.Write) &&
+				(conflict == nil || h.Expires > conflict.Expires) {
+				conflict = h
+			}
+		}
+		if conflict != nil {
+			return nil, &HeldError{Lease: conflict.lease().Lease}
+		}
+
+		n := st.prune(now, "")
+		n.Token++
+		h := holder{Owner: rw.ID, Nonce: nonce, Token: n.Token,
+			Expires: now + int64(rw.TTL), Write: write}
+		n.Holders = append(n.Holders, h)
+		l = h.lease()
+		return n, nil
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	return l, nil
+}
+
+// Acquire a lease, waiting with exponential backoff
+// while another client holds a conflicting lease.
+func (rw *RWMutex) lock(ctx context.Context, write bool) (Lease, error) {
+	nonce := newNonce()
+	wait := time.Millisecond
+	for {
+		l, err := rw.acquire(ctx, write, nonce)
+		var held *HeldError
+		if !errors.As(err, &held) {
+			return l, err
+		}
+
+		// Wait no longer than the conflicting lease has left,
+		// since its holder may have crashed, but not so long
+		// that we miss a release by much.
+		d := wait
+		if left := held.Lease.Expires.Sub(rw.now()); d > left {
+			d = left
+		}
+		if wait *= 2; wait > rw.TTL/4 {
+			wait = rw.TTL / 4
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return Lease{}, ctx.Err()
+		}
+	}
+}
+
+// TryLock tries once to acquire a write lease on the lock,
+// returning the new lease if it succeeds,
+// or a HeldError if another client holds an unexpired lease.
+//
+func (rw *RWMutex) TryLock(ctx context.Context) (Lease, error) {
+	return rw.acquire(ctx, true, newNonce())
+}
+
+// TryRLock tries once to acquire a read lease on the lock,
+// returning the new lease if it succeeds,
+// or a HeldError if another client holds an unexpired write lease.
+//
+func (rw *RWMutex) TryRLock(ctx context.Context) (Lease, error) {
+	return rw.acquire(ctx, false, newNonce())
+}
+
+// Lock acquires a write lease on the lock,
+// waiting until no other client holds an unexpired lease,
+// and returns the new lease.
+// Lock returns an error only if ctx is done or the Store fails fatally.
+//
+func (rw *RWMutex) Lock(ctx context.Context) (Lease, error) {
+	return rw.lock(ctx, true)
+}
+
+// RLock acquires a read lease on the lock,
+// waiting until no other client holds an unexpired write lease,
+// and returns the new lease.
+// RLock returns an error only if ctx is done or the Store fails fatally.
+//
+func (rw *RWMutex) RLock(ctx context.Context) (Lease, error) {
+	return rw.lock(ctx, false)
+}
+
+// Renew extends the caller's lease l for another TTL,
+// returning the renewed lease, which keeps l's fencing token.
+// Renew returns ErrLost if l has expired,
+// even if no other client has acquired the lock yet,
+// since the caller cannot know whether any has.
+// A client holding a lock for long should renew its lease
+// well before it expires, such as each time a third of its TTL passes.
+//
+func (rw *RWMutex) Renew(ctx context.Context, l Lease) (Lease, error) {
+	var r Lease
+	_, err := rw.update(ctx, func(st *state) (*state, error) {
+		now := rw.now().UnixNano()
+		if st.find(l.nonce, now) == nil {
+			return nil, ErrLost
+		}
+		n := st.prune(now, "")
+		h := n.find(l.nonce, now)
+		h.Expires = now + int64(rw.TTL)
+		r = h.lease()
+		return n, nil
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	return r, nil
+}
+
+// Unlock releases the caller's lease l,
+// so that other clients may acquire the lock without waiting.
+// Unlock succeeds even if l has already expired,
+// since the lease is released either way:
+// callers that must know whether they held the lock throughout
+// should rely on fencing tokens.
+//
+func (rw *RWMutex) Unlock(ctx context.Context, l Lease) error {
+	_, err := rw.update(ctx, func(st *state) (*state, error) {
+		now := rw.now().UnixNano()
+		if st.find(l.nonce, now) == nil {
+			return nil, nil // already released or expired
+		}
+		return st.prune(now, l.nonce), nil
+	})
+	return err
+}
+
+// Holders reads the unexpired leases on the lock from the Store.
+//
+func (rw *RWMutex) Holders(ctx context.Context) ([]Lease, error) {
+	st, err := rw.update(ctx, func(*state) (*state, error) {
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ls []Lease
+	for _, h := range st.prune(rw.now().UnixNano(), "").Holders {
+		ls = append(ls, h.lease())
+	}
+	return ls, nil
+}
+
+// TryLock tries once to acquire the lock,
+// returning the new lease if it succeeds,
+// or a HeldError if another client holds an unexpired lease.
+//
+func (m *Mutex) TryLock(ctx context.Context) (Lease, error) {
+	return (*RWMutex)(m).TryLock(ctx)
+}
+
+// Lock acquires the lock, waiting until no other client
+// holds an unexpired lease, and returns the new lease.
+// Lock returns an error only if ctx is done or the Store fails fatally.
+//
+func (m *Mutex) Lock(ctx context.Context) (Lease, error) {
+	return (*RWMutex)(m).Lock(ctx)
+}
+
+// Renew extends the caller's lease l for another TTL,
+// as RWMutex.Renew does.
+//
+func (m *Mutex) Renew(ctx context.Context, l Lease) (Lease, error) {
+	return (*RWMutex)(m).Renew(ctx, l)
+}
+
+// Unlock releases the caller's lease l, as RWMutex.Unlock does.
+//
+func (m *Mutex) Unlock(ctx context.Context, l Lease) error {
+	return (*RWMutex)(m).Unlock(ctx, l)
+}
+
+// Holder reads the unexpired lease on the lock from the Store,
+// returning ok false if no client holds one.
+//
+func (m *Mutex) Holder(ctx context.Context) (l Lease, ok bool, err error) {
+	ls, err := (*RWMutex)(m).Holders(ctx)
+	if err != nil || len(ls) == 0 {
+		return Lease{}, false, err
+	}
+	return ls[0], true, nil
+}
@@ -0,0 +1,267 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+	leasetest "github.com/dedis/tlc/go/lib/lease/test"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// A resource protected by a lock, which checks fencing tokens.
+type fenced struct {
+	mut sync.Mutex
+	max int64 // highest token seen
+}
+
+func (f *fenced) write(token int64) bool {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if token < f.max {
+		return false
+	}
+	f.max = token
+	return true
+}
+
+// Quietly retry all Store errors.
+var quiet = backoff.Config{Report: func(error) error { return nil }}
+
+func TestMutex(t *testing.T) {
+	ctx := context.Background()
+	c := leasetest.NewClock(time.Unix(1000, 0))
+	r := &cas.Register{}
+	a := &Mutex{Store: r, ID: "a", TTL: time.Second, Now: c.Now}
+	b := &Mutex{Store: r, ID: "b", TTL: time.Second, Now: c.Now}
+
+	la, err := a.TryLock(ctx)
+	if err != nil || la.Holder != "a" || la.Token != 1 || !la.Write {
+		t.Fatalf("a locked %+v %v", la, err)
+	}
+	var held *HeldError
+	if _, err := b.TryLock(ctx); !errors.As(err, &held) ||
+		held.Lease != la.Lease || !errors.Is(err, ErrHeld) {
+		t.Errorf("b locked held mutex: %v", err)
+	}
+	if l, ok, err := b.Holder(ctx); err != nil || !ok || l != la {
+		t.Errorf("b sees holder %+v %v %v", l, ok, err)
+	}
+
+	// The holder keeps the lock by renewing its lease.
+	c.Advance(800 * time.Millisecond)
+	if la, err = a.Renew(ctx, la); err != nil || la.Token != 1 {
+		t.Fatalf("a renewed %+v %v", la, err)
+	}
+	c.Advance(800 * time.Millisecond)
+	if _, err := b.TryLock(ctx); !errors.Is(err, ErrHeld) {
+		t.Errorf("b locked renewed mutex: %v", err)
+	}
+
+	// Once the lease expires, another client takes over
+	// with a higher fencing token, and the old holder cannot renew.
+	c.Advance(time.Second)
+	lb, err := b.TryLock(ctx)
+	if err != nil || lb.Holder != "b" || lb.Token != 2 {
+		t.Fatalf("b locked %+v %v", lb, err)
+	}
+	if _, err := a.Renew(ctx, la); err != ErrLost {
+		t.Errorf("a renewed lost lease: %v", err)
+	}
+
+	// Releasing an expired lease does not disturb the new holder.
+	if err := a.Unlock(ctx, la); err != nil {
+		t.Fatal(err)
+	}
+	if l, ok, err := a.Holder(ctx); err != nil || !ok || l != lb {
+		t.Errorf("a sees holder %+v %v %v", l, ok, err)
+	}
+	if err := b.Unlock(ctx, lb); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := a.Holder(ctx); err != nil || ok {
+		t.Errorf("holder after unlock: %v %v", ok, err)
+	}
+	if la, err = a.Lock(ctx); err != nil || la.Token != 3 {
+		t.Errorf("a locked %+v %v", la, err)
+	}
+}
+
+func TestRWMutex(t *testing.T) {
+	ctx := context.Background()
+	c := leasetest.NewClock(time.Unix(1000, 0))
+	r := &cas.Register{}
+	rw := func(id string) *RWMutex {
+		return &RWMutex{Store: r, ID: id, TTL: time.Second, Now: c.Now}
+	}
+	a, b, w := rw("a"), rw("b"), rw("w")
+
+	// Readers share the lock, excluding writers.
+	la, err := a.TryRLock(ctx)
+	if err != nil || la.Token != 1 || la.Write {
+		t.Fatalf("a read-locked %+v %v", la, err)
+	}
+	c.Advance(100 * time.Millisecond)
+	lb, err := b.TryRLock(ctx)
+	if err != nil || lb.Token != 2 {
+		t.Fatalf("b read-locked %+v %v", lb, err)
+	}
+	var held *HeldError
+	if _, err := w.TryLock(ctx); !errors.As(err, &held) ||
+		held.Lease != lb.Lease {
+		t.Errorf("w locked read-locked mutex: %v", err)
+	}
+	if ls, err := w.Holders(ctx); err != nil || len(ls) != 2 {
+		t.Errorf("holders %+v %v", ls, err)
+	}
+
+	// A writer excludes readers once the readers release the lock.
+	if err := a.Unlock(ctx, la); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unlock(ctx, lb); err != nil {
+		t.Fatal(err)
+	}
+	lw, err := w.TryLock(ctx)
+	if err != nil || lw.Token != 3 {
+		t.Fatalf("w locked %+v %v", lw, err)
+	}
+	if _, err := a.TryRLock(ctx); !errors.As(err, &held) ||
+		held.Lease != lw.Lease {
+		t.Errorf("a read-locked write-locked mutex: %v", err)
+	}
+
+	// Readers need not wait for an expired writer.
+	c.Advance(time.Second)
+	if la, err = a.RLock(ctx); err != nil || la.Token != 4 {
+		t.Errorf("a read-locked %+v %v", la, err)
+	}
+	if ls, err := w.Holders(ctx); err != nil || len(ls) != 1 ||
+		ls[0] != la {
+		t.Errorf("holders %+v %v", ls, err)
+	}
+}
+
+// Test that a lock held by a crashed client is recovered after its TTL,
+// and that fencing tokens reject the crashed client's late writes.
+func TestCrash(t *testing.T) {
+	ctx := context.Background()
+	r := &cas.Register{}
+	res := &fenced{}
+	a := &Mutex{Store: r, ID: "a", TTL: 100 * time.Millisecond}
+	b := &Mutex{Store: r, ID: "b", TTL: 100 * time.Millisecond}
+
+	la, err := a.Lock(ctx)
+	if err != nil || !res.write(la.Token) {
+		t.Fatalf("a locked %+v %v", la, err)
+	}
+
+	// Client a crashes, or pauses, while holding the lock.
+	start := time.Now()
+	lb, err := b.Lock(ctx)
+	if err != nil || !res.write(lb.Token) {
+		t.Fatalf("b locked %+v %v", lb, err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("b locked before a's lease expired")
+	}
+	if res.write(la.Token) {
+		t.Errorf("fenced resource accepted stale token")
+	}
+}
+
+// Test mutual exclusion among clients whose Store accesses
+// suffer injected faults.
+func testFaulty(t *testing.T, stores []cas.Store, naccesses int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	mut := sync.Mutex{}
+	readers, writers := 0, 0
+	res := &fenced{}
+	wg := sync.WaitGroup{}
+	for i, st := range stores {
+		f := test.Faults{Timeout: 0.2, Cancel: 0.2,
+			Delay: 20 * time.Microsecond, Seed: int64(i)}
+		rw := &RWMutex{Store: test.Faulty(st, f), ID: fmt.Sprint(i),
+			TTL: 5 * time.Second, Backoff: quiet}
+		write := i%2 == 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < naccesses; j++ {
+				var l Lease
+				var err error
+				if write {
+					l, err = rw.Lock(ctx)
+				} else {
+					l, err = rw.RLock(ctx)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mut.Lock()
+				if write {
+					writers++
+				} else {
+					readers++
+				}
+				if writers > 1 || (writers > 0 && readers > 0) {
+					t.Errorf("%v writers and %v readers",
+						writers, readers)
+				}
+				mut.Unlock()
+				if write && !res.write(l.Token) {
+					t.Errorf("token %v fenced", l.Token)
+				}
+
+				time.Sleep(time.Millisecond)
+				mut.Lock()
+				if write {
+					writers--
+				} else {
+					readers--
+				}
+				mut.Unlock()
+
+				if err := rw.Unlock(ctx, l); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every lease must have been released despite the faults.
+	rw := &RWMutex{Store: stores[0], TTL: time.Second}
+	if ls, err := rw.Holders(ctx); err != nil || len(ls) != 0 {
+		t.Errorf("leaked leases %+v %v", ls, err)
+	}
+}
+
+func TestFaultyRegister(t *testing.T) {
+	r := &cas.Register{}
+	testFaulty(t, []cas.Store{r, r, r, r, r, r}, 20)
+}
+
+func TestFaultyGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	clients := make([]cas.Store, 4)
+	for i := range clients {
+		clients[i] = (&qscas.Group{Backoff: quiet}).Start(ctx, members, 1)
+	}
+	testFaulty(t, clients, 5)
+}
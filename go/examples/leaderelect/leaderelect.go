@@ -0,0 +1,200 @@
+// Package leaderelect implements lease-based leader election
+// on top of any cas.Store, such as a replicated qscas.Group,
+// as an end-to-end example of building a service on the CAS abstraction.
+//
+// Candidates sharing a Store each run an Elector.
+// The Store's state records the current leader's lease:
+// the leader's identity, when the lease expires,
+// and a fencing token that increases with each new term of leadership.
+// A candidate acquires leadership by compare-and-setting the state
+// to a lease of its own, but only once the current lease has expired,
+// and keeps it by renewing the lease before it expires.
+//
+// Leases rely on loosely synchronized clocks:
+// a leader whose clock runs slow, or that pauses for longer than its TTL,
+// may still believe itself leader after another candidate takes over.
+// Resources the leader acts on should therefore check fencing tokens,
+// rejecting requests bearing a lower token than the highest they have seen,
+// so that a deposed leader cannot interfere with its successor.
+// The CAS operations themselves never rely on clocks for safety:
+// no two candidates ever hold leases with the same token.
+//
+package leaderelect
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/lease"
+)
+
+// Lease describes a term of leadership.
+type Lease = lease.Lease
+
+// HeldError reports that a candidate could not acquire leadership
+// because another candidate holds an unexpired lease.
+type HeldError = lease.HeldError
+
+// ErrHeld is the error a HeldError wraps,
+// so that callers may detect it with errors.Is.
+var ErrHeld = lease.ErrHeld
+
+// ErrLost is returned by Renew and Resign once the caller's lease
+// has expired and another candidate may have taken over.
+var ErrLost = lease.ErrLost
+
+// ErrNoLeader is returned by Leader when no candidate holds a valid lease.
+var ErrNoLeader = errors.New("no leader")
+
+// Elector campaigns for leadership on behalf of one candidate.
+//
+// ID identifies the candidate, and must be unique among the candidates
+// sharing the Store. TTL is how long each lease lasts before it expires,
+// unless the leader renews it. Now optionally supplies the current time,
+// and defaults to time.Now.
+//
+// The caller must set Store, ID, and TTL before use,
+// after which an Elector is safe for concurrent use.
+//
+type Elector struct {
+	Store cas.Store        // Store shared by the candidates
+	ID    string           // Unique identity of this candidate
+	TTL   time.Duration    // Lease duration
+	Now   func() time.Time // Clock, or nil for time.Now
+
+	reg lease.Register[state] // last state observed in the Store
+}
+
+// State of the election in the Store.
+type state struct {
+	Holder  string // Current or last leader, or "" if none ever
+	Token   int64  // Fencing token of the leader's term
+	Expires int64  // Expiry of the leader's lease, in Unix nanoseconds
+}
+
+// Return the lease an election state describes.
+func (st *state) lease() Lease {
+	return Lease{Holder: st.Holder, Token: st.Token,
+		Expires: time.Unix(0, st.Expires)}
+}
+
+// Return the current time.
+func (e *Elector) now() time.Time {
+	return lease.Clock(e.Now).Now()
+}
+
+// Update the election state via f, as lease.Register.Update does,
+// returning the errors of failed Store accesses.
+func (e *Elector) update(ctx context.Context,
+	f func(st *state) (*state, error)) (*state, error) {
+
+	return e.reg.Update(ctx, e.Store, nil, f)
+}
+
+// Acquire tries once to acquire leadership,
+// returning the new lease if it succeeds,
+// or a HeldError if another candidate holds an unexpired lease.
+// If the caller already holds an unexpired lease, Acquire renews it.
+//
+func (e *Elector) Acquire(ctx context.Context) (Lease, error) {
+	now := e.now()
+	st, err := e.update(ctx, func(st *state) (*state, error) {
+		switch {
+		case st.Holder == e.ID && now.UnixNano() < st.Expires:
+			return &state{e.ID, st.Token, now.Add(e.TTL).UnixNano()},
+				nil
+		case st.Holder != "" && now.UnixNano() < st.Expires:
+			return nil, &HeldError{Lease: st.lease()}
+		}
+		return &state{e.ID, st.Token + 1, now.Add(e.TTL).UnixNano()}, nil
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	return st.lease(), nil
+}
+
+// Campaign acquires leadership, waiting until the current leader's lease
+// expires if another candidate holds it, and returns the new lease.
+// Campaign returns an error only if ctx is done or the Store fails.
+//
+func (e *Elector) Campaign(ctx context.Context) (Lease, error) {
+	for {
+		l, err := e.Acquire(ctx)
+		var held *HeldError
+		if !errors.As(err, &held) {
+			return l, err
+		}
+
+		// Wait for the leader's lease to expire, but not so long
+		// that we miss a resignation by much.
+		wait := held.Lease.Expires.Sub(e.now())
+		if wait > e.TTL/4 {
+			wait = e.TTL / 4
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return Lease{}, ctx.Err()
+		}
+	}
+}
+
+// Renew extends the caller's lease l for another TTL,
+// returning the renewed lease, which keeps l's fencing token.
+// Renew returns ErrLost if l has expired,
+// even if no other candidate has taken over yet,
+// since the caller cannot know whether any has.
+// The leader should renew its lease well before it expires,
+// such as each time a third of its TTL passes.
+//
+func (e *Elector) Renew(ctx context.Context, l Lease) (Lease, error) {
+	now := e.now()
+	st, err := e.update(ctx, func(st *state) (*state, error) {
+		if st.Holder != e.ID || st.Token != l.Token ||
+			now.UnixNano() >= st.Expires {
+			return nil, ErrLost
+		}
+		return &state{e.ID, st.Token, now.Add(e.TTL).UnixNano()}, nil
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	return st.lease(), nil
+}
+
+// Resign gives up the caller's lease l before it expires,
+// so that other candidates may take over without waiting.
+// Resign returns ErrLost if l has already expired.
+//
+func (e *Elector) Resign(ctx context.Context, l Lease) error {
+	now := e.now()
+	_, err := e.update(ctx, func(st *state) (*state, error) {
+		if st.Holder != e.ID || st.Token != l.Token ||
+			now.UnixNano() >= st.Expires {
+			return nil, ErrLost
+		}
+		return &state{e.ID, st.Token, 0}, nil
+	})
+	return err
+}
+
+// Leader reads the current leader's lease from the Store,
+// or returns ErrNoLeader if no candidate holds an unexpired lease.
+//
+func (e *Elector) Leader(ctx context.Context) (Lease, error) {
+	st, err := e.update(ctx, func(*state) (*state, error) {
+		return nil, nil
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	if st.Holder == "" || e.now().UnixNano() >= st.Expires {
+		return Lease{}, ErrNoLeader
+	}
+	return st.lease(), nil
+}
@@ -0,0 +1,160 @@
+package leaderelect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	leasetest "github.com/dedis/tlc/go/lib/lease/test"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// Test the lease lifecycle with a manually advanced clock.
+func testLeases(t *testing.T, store cas.Store) {
+	ctx := context.Background()
+	c := leasetest.NewClock(time.Unix(1000, 0))
+	a := &Elector{Store: store, ID: "a", TTL: time.Second, Now: c.Now}
+	b := &Elector{Store: store, ID: "b", TTL: time.Second, Now: c.Now}
+
+	if _, err := b.Leader(ctx); err != ErrNoLeader {
+		t.Errorf("leader before election: %v", err)
+	}
+	la, err := a.Acquire(ctx)
+	if err != nil || la.Holder != "a" || la.Token != 1 {
+		t.Fatalf("a acquired %+v %v", la, err)
+	}
+	var held *HeldError
+	if _, err := b.Acquire(ctx); !errors.As(err, &held) ||
+		held.Lease != la || !errors.Is(err, ErrHeld) {
+		t.Errorf("b acquired held leadership: %v", err)
+	}
+	if l, err := b.Leader(ctx); err != nil || l != la {
+		t.Errorf("b sees leader %+v %v", l, err)
+	}
+
+	// The leader keeps its lease by renewing it.
+	c.Advance(800 * time.Millisecond)
+	if la, err = a.Renew(ctx, la); err != nil || la.Token != 1 {
+		t.Fatalf("a renewed %+v %v", la, err)
+	}
+	c.Advance(800 * time.Millisecond)
+	if _, err := b.Acquire(ctx); !errors.Is(err, ErrHeld) {
+		t.Errorf("b acquired renewed leadership: %v", err)
+	}
+
+	// Once the lease expires, another candidate takes over
+	// with a higher fencing token, and the old leader cannot renew.
+	c.Advance(time.Second)
+	lb, err := b.Acquire(ctx)
+	if err != nil || lb.Holder != "b" || lb.Token != 2 {
+		t.Fatalf("b acquired %+v %v", lb, err)
+	}
+	if _, err := a.Renew(ctx, la); err != ErrLost {
+		t.Errorf("a renewed lost lease: %v", err)
+	}
+	if err := a.Resign(ctx, la); err != ErrLost {
+		t.Errorf("a resigned lost lease: %v", err)
+	}
+
+	// A resignation lets others take over immediately.
+	if err := b.Resign(ctx, lb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Leader(ctx); err != ErrNoLeader {
+		t.Errorf("leader after resignation: %v", err)
+	}
+	if la, err = a.Campaign(ctx); err != nil || la.Token != 3 {
+		t.Errorf("a campaigned %+v %v", la, err)
+	}
+}
+
+// Test that concurrent candidates with real clocks never hold
+// overlapping leases, and that each term has a distinct token.
+func testCampaign(t *testing.T, stores []cas.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	type term struct {
+		holder     string
+		begin, end time.Time
+	}
+	mut := sync.Mutex{}
+	terms := map[int64]term{}
+	wg := sync.WaitGroup{}
+	for i, st := range stores {
+		e := &Elector{Store: st, ID: fmt.Sprint(i), TTL: time.Second}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2; j++ {
+				l, err := e.Campaign(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				begin := time.Now()
+
+				// Lead for a while, then resign.
+				time.Sleep(10 * time.Millisecond)
+				end := time.Now()
+				if err := e.Resign(ctx, l); err != nil {
+					t.Error(err)
+					return
+				}
+
+				mut.Lock()
+				if tm, ok := terms[l.Token]; ok {
+					t.Errorf("token %v held by %v and %v",
+						l.Token, tm.holder, e.ID)
+				}
+				terms[l.Token] = term{e.ID, begin, end}
+				mut.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Terms must follow each other in token order without overlapping.
+	if len(terms) != 2*len(stores) {
+		t.Fatalf("%v terms, want %v", len(terms), 2*len(stores))
+	}
+	for tok := int64(2); tok <= int64(len(terms)); tok++ {
+		prev, ok1 := terms[tok-1]
+		cur, ok2 := terms[tok]
+		if !ok1 || !ok2 {
+			t.Fatalf("tokens not consecutive: %v", terms)
+		}
+		if cur.begin.Before(prev.end) {
+			t.Errorf("term %v overlaps the previous one", tok)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	testLeases(t, &cas.Register{})
+
+	r := &cas.Register{}
+	testCampaign(t, []cas.Store{r, r, r, r})
+}
+
+func TestGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	testLeases(t, (&qscas.Group{}).Start(ctx, members, 1))
+
+	// Each candidate runs its own client of the replicated group.
+	members = []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	clients := make([]cas.Store, 3)
+	for i := range clients {
+		clients[i] = (&qscas.Group{}).Start(ctx, members, 1)
+	}
+	testCampaign(t, clients)
+}
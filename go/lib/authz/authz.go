@@ -0,0 +1,160 @@
+// Package authz defines authorization hooks for consensus state stores,
+// so that multi-tenant deployments can restrict which clients
+// may drive consensus on, or read, a given store's state.
+//
+// A store server identifies each client,
+// for example by an authentication token or a TLS client certificate,
+// and attaches the resulting Identity to the context of each operation
+// via NewContext.
+// Stores consult an Authorizer with this Identity
+// before performing each read, write, or expiry operation.
+//
+package authz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Op identifies the kind of store operation being authorized.
+type Op int
+
+const (
+	// Read is reading the latest or a particular version of state
+	Read Op = iota
+	// Write is writing a new version of state
+	Write
+	// Expire is garbage-collecting old versions of state
+	Expire
+)
+
+func (op Op) String() string {
+	switch op {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Expire:
+		return "expire"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Identity describes the client on whose behalf an operation is performed.
+// Name is the client's authenticated name, if any,
+// such as the subject of its token or its certificate's common name.
+// Token is the bearer token the client presented, if any,
+// and Certs is the client's verified TLS certificate chain, if any.
+type Identity struct {
+	Name  string              // Authenticated client name
+	Token string              // Bearer token presented by the client
+	Certs []*x509.Certificate // Verified client certificate chain
+}
+
+// FromTLS returns the Identity of the client on a TLS connection,
+// named by the common name of its leaf certificate.
+func FromTLS(cs tls.ConnectionState) Identity {
+	id := Identity{Certs: cs.PeerCertificates}
+	if len(id.Certs) > 0 {
+		id.Name = id.Certs[0].Subject.CommonName
+	}
+	return id
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the client identity id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the client identity ctx carries, if any.
+// Contexts without an identity yield the zero, anonymous Identity.
+func FromContext(ctx context.Context) Identity {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id
+}
+
+// Authorizer decides whether a client may perform an operation on a store.
+// Authorize returns nil if the operation is permitted,
+// and otherwise an error, normally wrapping ErrDenied.
+// The ver parameter is the state version number the operation concerns,
+// where known: for Expire, the version before which state is to be expired.
+type Authorizer interface {
+	Authorize(ctx context.Context, id Identity, op Op, ver int64) error
+}
+
+// AuthorizerFunc adapts an ordinary function into an Authorizer.
+type AuthorizerFunc func(ctx context.Context, id Identity, op Op,
+	ver int64) error
+
+// Authorize calls f(ctx, id, op, ver).
+func (f AuthorizerFunc) Authorize(ctx context.Context, id Identity, op Op,
+	ver int64) error {
+	return f(ctx, id, op, ver)
+}
+
+// ErrDenied is the error wrapped by denied authorization requests.
+var ErrDenied = errors.New("permission denied")
+
+// Denied returns an error wrapping ErrDenied for client id and operation op.
+func Denied(id Identity, op Op) error {
+	return fmt.Errorf("%w: %q may not %v", ErrDenied, id.Name, op)
+}
+
+// ACL is a simple Authorizer mapping client names to permitted operations.
+// The entry for the empty name applies to anonymous clients.
+type ACL map[string][]Op
+
+// Authorize implements the Authorizer interface for an ACL.
+func (acl ACL) Authorize(ctx context.Context, id Identity, op Op,
+	ver int64) error {
+	for _, o := range acl[id.Name] {
+		if o == op {
+			return nil
+		}
+	}
+	return Denied(id, op)
+}
+
+// Check calls a.Authorize with the identity carried by ctx,
+// permitting all operations if a is nil.
+func Check(ctx context.Context, a Authorizer, op Op, ver int64) error {
+	if a == nil {
+		return nil
+	}
+	return a.Authorize(ctx, FromContext(ctx), op, ver)
+}
+
+// Store wraps a cas.Store with authorization checks,
+// as a store server would do before passing client requests through.
+// A CompareAndSet call that proposes changing the state requires permission
+// to Write, and every CompareAndSet call requires permission to Read,
+// since it returns the latest state.
+func Store(st cas.Store, a Authorizer) cas.Store {
+	return &authStore{st, a}
+}
+
+type authStore struct {
+	st cas.Store
+	a  Authorizer
+}
+
+func (as *authStore) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	if err := Check(ctx, as.a, Read, 0); err != nil {
+		return 0, "", err
+	}
+	if new != old {
+		if err := Check(ctx, as.a, Write, 0); err != nil {
+			return 0, "", err
+		}
+	}
+	return as.st.CompareAndSet(ctx, old, new)
+}
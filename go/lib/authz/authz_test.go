@@ -0,0 +1,42 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestStore(t *testing.T) {
+	acl := ACL{
+		"writer": {Read, Write},
+		"reader": {Read},
+	}
+	st := Store(&cas.Register{}, acl)
+	bg := context.Background()
+	writer := NewContext(bg, Identity{Name: "writer"})
+	reader := NewContext(bg, Identity{Name: "reader"})
+
+	// Writers may change the state
+	ver, val, err := st.CompareAndSet(writer, "", "hello")
+	if err != nil || val != "hello" {
+		t.Fatalf("writer CompareAndSet: %v %q %v", ver, val, err)
+	}
+
+	// Readers may read the state but not change it
+	_, val, err = st.CompareAndSet(reader, "hello", "hello")
+	if err != nil || val != "hello" {
+		t.Errorf("reader read: %q %v", val, err)
+	}
+	_, _, err = st.CompareAndSet(reader, "hello", "goodbye")
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("reader write: got error %v", err)
+	}
+
+	// Anonymous clients may do nothing
+	_, _, err = st.CompareAndSet(bg, "hello", "hello")
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("anonymous read: got error %v", err)
+	}
+}
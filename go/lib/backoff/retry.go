@@ -7,6 +7,7 @@ import (
 	"context"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -33,8 +34,12 @@ func Retry(ctx context.Context, try func() error) error {
 // Report may also return a non-nil error to abort the Retry loop if it
 // determines that the detected error is permanent and waiting will not help.
 //
+// MinWait and MaxWait bound each backoff wait period,
+// if positive.
+//
 type Config struct {
 	Report  func(error) error // Function to report errors
+	MinWait time.Duration     // Minimum (initial) backoff wait period
 	MaxWait time.Duration     // Maximum backoff wait period
 
 	mayGrow struct{} // Ensure Config remains extensible
@@ -48,6 +53,14 @@ func defaultReport(err error) error {
 // Retry calls try() repeatedly until it returns without an error,
 // using exponential backoff configuration c.
 func (c Config) Retry(ctx context.Context, try func() error) error {
+	_, err := c.retry(ctx, try, 0)
+	return err
+}
+
+// Retry try() with backoff waits starting from at least start,
+// returning the last wait period used, or zero if try never failed.
+func (c Config) retry(ctx context.Context, try func() error,
+	start time.Duration) (time.Duration, error) {
 
 	// Make sure we have a valid error reporter
 	if c.Report == nil {
@@ -56,22 +69,29 @@ func (c Config) Retry(ctx context.Context, try func() error) error {
 
 	// Return immediately if ctx was already cancelled
 	if ctx.Err() != nil {
-		return ctx.Err()
+		return 0, ctx.Err()
 	}
 
 	backoff := time.Duration(1) // minimum backoff duration
+	if backoff < c.MinWait {
+		backoff = c.MinWait
+	}
+	if backoff < start {
+		backoff = start
+	}
+	waited := time.Duration(0)
 	for {
 		before := time.Now()
 		err := try()
 		if err == nil { // success
-			return nil
+			return waited, nil
 		}
 		elapsed := time.Since(before)
 
 		// Report the error as appropriate
 		err = c.Report(err)
 		if err != nil {
-			return err // abort the retry loop
+			return waited, err // abort the retry loop
 		}
 
 		// Wait for an exponentially-growing random backoff period,
@@ -83,6 +103,7 @@ func (c Config) Retry(ctx context.Context, try func() error) error {
 		if c.MaxWait > 0 && backoff > c.MaxWait {
 			backoff = c.MaxWait
 		}
+		waited = backoff
 
 		// Wait for either the backoff timer or a cancel signal.
 		t := time.NewTimer(backoff)
@@ -92,7 +113,46 @@ func (c Config) Retry(ctx context.Context, try func() error) error {
 
 		case <-ctx.Done(): // Our context got cancelled
 			t.Stop()
-			return ctx.Err()
+			return waited, ctx.Err()
 		}
 	}
 }
+
+// Backoff applies a backoff configuration to the operations
+// on one particular target, such as a network peer or a storage node,
+// remembering across calls to Retry how long the target has been failing.
+// A target that keeps failing intermittently, even if each Retry
+// eventually succeeds, is thus retried with increasingly long waits,
+// while the waits decay again as the target recovers.
+//
+// The zero Backoff uses the default configuration, and is ready to use.
+// A Backoff is safe for concurrent use, but must not be copied after use.
+//
+type Backoff struct {
+	Config // Configuration for exponential backoff
+
+	mut  sync.Mutex    // protects wait
+	wait time.Duration // initial wait period for the next failure
+}
+
+// Retry calls try() repeatedly until it returns without an error,
+// starting from the backoff wait period the target's past failures reached.
+func (b *Backoff) Retry(ctx context.Context, try func() error) error {
+	b.mut.Lock()
+	start := b.wait
+	b.mut.Unlock()
+
+	waited, err := b.Config.retry(ctx, try, start)
+
+	// Remember the wait a further failure should start from,
+	// halving it each time the target succeeds on the first try.
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	switch {
+	case waited > b.wait:
+		b.wait = waited
+	case waited == 0 && err == nil:
+		b.wait /= 2
+	}
+	return err
+}
@@ -42,3 +42,37 @@ func TestTimeout(t *testing.T) {
 	// for good measure
 	cancel()
 }
+
+func TestBackoff(t *testing.T) {
+	bg := context.Background()
+	quiet := func(error) error { return nil }
+	b := &Backoff{Config: Config{Report: quiet, MinWait: time.Microsecond,
+		MaxWait: 10 * time.Millisecond}}
+
+	// A target that fails on every other try gets increasing waits.
+	fail := false
+	flaky := func() error {
+		fail = !fail
+		if fail {
+			return errors.New("flaky")
+		}
+		return nil
+	}
+	for i := 0; i < 50; i++ {
+		if err := b.Retry(bg, flaky); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.wait != b.MaxWait {
+		t.Errorf("intermittent failures reached %v backoff, not %v",
+			b.wait, b.MaxWait)
+	}
+
+	// The waits decay again once the target recovers.
+	for i := 0; i < 20; i++ {
+		b.Retry(bg, func() error { return nil })
+	}
+	if b.wait > time.Microsecond {
+		t.Errorf("backoff did not decay: %v", b.wait)
+	}
+}
@@ -0,0 +1,152 @@
+package rfq
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/status"
+)
+
+// Metrics summarizes how fairly a Server is serving its clients,
+// so that operators can verify that it delivers its fairness goals.
+//
+// Fairness is Jain's fairness index among the clients
+// with requests in service or queued, of the number of requests
+// each has been admitted since it last had none.
+// It ranges from 1/Clients, when one client obtains every admission,
+// to 1, when all clients obtain equal numbers of admissions.
+//
+type Metrics struct {
+	Clients  int           // Clients with requests in service or queued
+	Fairness float64       // Jain's index of those clients' admissions
+	Waiting  time.Duration // Longest wait so far of a queued request
+	MaxWait  time.Duration // Longest wait of any request admitted
+	Admitted uint64        // Requests admitted
+	Rejected uint64        // Requests rejected with a BusyError
+	Bumped   uint64        // Queued requests rejected to make room
+	Alarms   uint64        // Admissions and rejections beyond WaitBound
+}
+
+// Cumulative counts a Server keeps for Metrics
+type counts struct {
+	admitted, rejected, bumped, alarms uint64
+	maxWait                            time.Duration
+}
+
+// Metrics returns the Server's current fairness metrics.
+func (s *Server) Metrics() Metrics {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.metrics(time.Now())
+}
+
+func (s *Server) metrics(now time.Time) Metrics {
+	m := Metrics{Clients: len(s.load), Fairness: 1,
+		MaxWait: s.stat.maxWait, Admitted: s.stat.admitted,
+		Rejected: s.stat.rejected, Bumped: s.stat.bumped,
+		Alarms: s.stat.alarms}
+
+	var sum, sumsq float64
+	for _, a := range s.load {
+		sum += float64(a.served)
+		sumsq += float64(a.served) * float64(a.served)
+	}
+	if sumsq > 0 {
+		m.Fairness = sum * sum / (float64(len(s.load)) * sumsq)
+	}
+
+	for _, w := range s.wait {
+		m.Waiting = max(m.Waiting, now.Sub(w.arrival))
+	}
+	return m
+}
+
+// Account for the admission of a client's request that arrived at arrival.
+func (s *Server) admitted(a *account, id string, arrival, now time.Time) {
+	a.active++
+	a.served++
+	s.stat.admitted++
+	s.stat.maxWait = max(s.stat.maxWait, now.Sub(arrival))
+	s.check(id, now.Sub(arrival))
+}
+
+// Account for the rejection of a client's request that arrived at arrival.
+func (s *Server) rejected(id string, arrival, now time.Time) {
+	s.stat.rejected++
+	s.check(id, now.Sub(arrival))
+}
+
+// Raise an alarm if a client's request has waited longer than WaitBound.
+func (s *Server) check(id string, wait time.Duration) {
+	if s.WaitBound > 0 && wait > s.WaitBound {
+		s.stat.alarms++
+		if s.Alarm != nil {
+			s.Alarm(id, wait)
+		}
+	}
+}
+
+// Status reports the Server's clients and metrics for an embedded status page,
+// implementing status.Reporter.
+// A client is reported healthy unless one of its queued requests
+// has waited longer than WaitBound.
+// It may safely be called at any time, concurrently with the Server's operation.
+func (s *Server) Status() status.Status {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.init()
+	now := time.Now()
+	st := status.Status{Kind: "rfq.Server"}
+
+	// Report the load of each client and its longest-waiting request.
+	oldest := make(map[string]time.Time)
+	for _, w := range s.wait {
+		if t, ok := oldest[w.id]; !ok || w.arrival.Before(t) {
+			oldest[w.id] = w.arrival
+		}
+	}
+	for id, a := range s.load {
+		p := status.Peer{Name: id, Healthy: true}
+		if id == "" {
+			p.Name = "(anonymous)"
+		}
+		p.Detail = fmt.Sprintf("%d active, %d queued, %d admitted",
+			a.active, a.queued, a.served)
+		if t, ok := oldest[id]; ok {
+			wait := now.Sub(t)
+			p.Healthy = s.WaitBound <= 0 || wait <= s.WaitBound
+			p.Detail += fmt.Sprintf(", waiting %v",
+				wait.Round(time.Millisecond))
+		}
+		st.Peers = append(st.Peers, p)
+	}
+	sort.Slice(st.Peers, func(i, j int) bool {
+		return st.Peers[i].Name < st.Peers[j].Name
+	})
+
+	st.Config = map[string]string{
+		"slots":      strconv.Itoa(s.Slots),
+		"queue":      strconv.Itoa(s.Queue),
+		"maxAge":     s.MaxAge.String(),
+		"tolerance":  s.Tolerance.String(),
+		"outsourced": strconv.Itoa(s.Outsourced),
+		"waitBound":  s.WaitBound.String(),
+	}
+
+	m := s.metrics(now)
+	st.Metrics = map[string]float64{
+		"clients":  float64(m.Clients),
+		"fairness": m.Fairness,
+		"waiting":  m.Waiting.Seconds(),
+		"maxWait":  m.MaxWait.Seconds(),
+		"admitted": float64(m.Admitted),
+		"rejected": float64(m.Rejected),
+		"bumped":   float64(m.Bumped),
+		"alarms":   float64(m.Alarms),
+	}
+	return st
+}
@@ -0,0 +1,68 @@
+package rfq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	alarms := make(chan string, 10)
+	s := &Server{Slots: 1, Queue: 2, WaitBound: 5 * time.Millisecond,
+		Alarm: func(id string, wait time.Duration) { alarms <- id }}
+
+	// Client a occupies the slot and fills the queue,
+	// then client c bumps a's younger queued request.
+	done, _ := s.AdmitAs(ctx, "a", nil)
+	results := make(chan func(), 3)
+	submit := func(id string) {
+		go func() {
+			d, err := s.AdmitAs(ctx, id, nil)
+			if err != nil {
+				d = nil
+			}
+			results <- d
+		}()
+	}
+	for _, id := range []string{"a", "a", "c"} {
+		n := waiting(s)
+		submit(id)
+		for waiting(s) == n && len(results) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if d := <-results; d != nil {
+		t.Fatalf("no request bumped")
+	}
+	m := s.Metrics()
+	if m.Clients != 2 || m.Fairness != 0.5 || m.Admitted != 1 ||
+		m.Rejected != 1 || m.Bumped != 1 || m.Alarms != 0 {
+		t.Errorf("metrics %+v", m)
+	}
+
+	// Once the queued requests have waited too long,
+	// the status page shows both clients unhealthy.
+	time.Sleep(2 * s.WaitBound)
+	st := s.Status()
+	if len(st.Peers) != 2 || st.Peers[0].Name != "a" ||
+		st.Peers[0].Healthy || st.Peers[1].Healthy ||
+		st.Metrics["bumped"] != 1 || st.Metrics["waiting"] < 0.01 {
+		t.Errorf("status %+v", st)
+	}
+
+	// Admitting them raises alarms, in fair order.
+	done()
+	(<-results)()
+	(<-results)()
+	for _, want := range []string{"a", "c"} {
+		if id := <-alarms; id != want {
+			t.Errorf("alarm for client %v, expected %v", id, want)
+		}
+	}
+	m = s.Metrics()
+	if m.Clients != 0 || m.Fairness != 1 || m.Admitted != 3 ||
+		m.Alarms != 2 || m.MaxWait < 2*s.WaitBound || m.Waiting != 0 {
+		t.Errorf("metrics %+v", m)
+	}
+}
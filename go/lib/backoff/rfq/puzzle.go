@@ -0,0 +1,76 @@
+package rfq
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// DefaultMaxPuzzle is the default maximum difficulty, in bits,
+// of the puzzles a Server attaches to its tokens.
+const DefaultMaxPuzzle = 20
+
+// Largest puzzle difficulty a token can carry
+const maxPuzzle = 64
+
+// Difficulty of puzzles once the outsourced backlog first exceeds Outsourced
+const minPuzzle = 8
+
+// Length of a puzzle solution appended to a token
+const nonceLen = 8
+
+// Account for one more request outsourced to a client,
+// which the server suggests wait before resubmitting it,
+// and return the difficulty of the puzzle its token should carry.
+func (s *Server) puzzle(now time.Time, wait time.Duration) int {
+	if s.Outsourced <= 0 {
+		return 0
+	}
+
+	// Rejected clients stay away for about wait,
+	// so by Little's law, the number of rejections within about wait
+	// estimates how many requests clients are holding.
+	if dt := now.Sub(s.btime); dt > 0 {
+		s.backlog *= math.Exp(-float64(dt) / float64(wait))
+	}
+	s.btime = now
+	s.backlog++
+	if s.backlog <= float64(s.Outsourced) {
+		return 0
+	}
+
+	// Double the work for each doubling of the excess backlog.
+	d := minPuzzle + int(math.Log2(s.backlog/float64(s.Outsourced)))
+	return min(d, s.MaxPuzzle)
+}
+
+// Solve returns a copy of a token from a BusyError
+// with a solution to the puzzle it carries appended,
+// or the token itself if it carries no puzzle.
+// Solving a puzzle of difficulty d takes about 2^d hash computations.
+//
+func Solve(token []byte) []byte {
+	if len(token) < tokenLen || token[puzzleOfs] == 0 {
+		return token
+	}
+	d := int(token[puzzleOfs])
+	t := append(token[:tokenLen:tokenLen], make([]byte, nonceLen)...)
+	for n := uint64(0); ; n++ {
+		binary.BigEndian.PutUint64(t[tokenLen:], n)
+		if solved(t, d) {
+			return t
+		}
+	}
+}
+
+// Return true if a token carries a valid solution to a puzzle of difficulty d,
+// i.e., if its hash begins with d zero bits.
+func solved(token []byte, d int) bool {
+	if len(token) != tokenLen+nonceLen || d > maxPuzzle {
+		return false
+	}
+	h := sha256.Sum256(token)
+	return bits.LeadingZeros64(binary.BigEndian.Uint64(h[:])) >= d
+}
@@ -0,0 +1,511 @@
+package rfq
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSlots is the default number of requests a Server serves at once.
+const DefaultSlots = 4
+
+// DefaultQueue is the default capacity of a Server's internal queue.
+const DefaultQueue = 64
+
+// DefaultMaxAge is the default age beyond which a Server
+// presumes the tokens it issued to be replays.
+const DefaultMaxAge = time.Minute
+
+// DefaultTolerance is the default time before its promised service time
+// that a Server accepts a resubmitted request as due.
+const DefaultTolerance = 10 * time.Millisecond
+
+// Length of the MAC protecting each token
+const macLen = 16
+
+// Length of a token, not counting any puzzle solution:
+// arrival time, promised service time, puzzle difficulty, and MAC.
+const tokenLen = 8 + 8 + 1 + macLen
+
+// Offset of the puzzle difficulty in a token
+const puzzleOfs = 16
+
+// ErrBusy is the error a BusyError wraps,
+// so that callers may detect rejected requests with errors.Is.
+var ErrBusy = errors.New("server busy")
+
+// BusyError reports that a Server rejected a request for lack of capacity,
+// outsourcing the request's place in the queue to the client as a token.
+type BusyError struct {
+	Token []byte        // Token to resubmit the request with
+	Wait  time.Duration // Suggested delay before resubmitting it
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%v: retry in %v", ErrBusy, e.Wait)
+}
+
+// Unwrap returns ErrBusy.
+func (e *BusyError) Unwrap() error {
+	return ErrBusy
+}
+
+// Server is an RFQ admission engine, which admits requests
+// to a fixed number of service slots in responsively-fair order.
+//
+// A request that finds all slots busy waits in a bounded internal queue,
+// kept sorted oldest-request-first by the server's own clock.
+// Once the internal queue is full, the server rejects further requests
+// with a BusyError carrying a token that records when the request
+// first arrived, authenticated with a MAC under the server's key,
+// so that the client rather than the server remembers the request's age.
+// A resubmitted request regains its original age from its token,
+// and bumps the youngest internally queued request if it is older,
+// so clients that must wait long between attempts, such as slow clients,
+// are not starved by fast clients that keep refilling the queue.
+//
+// Each token also promises an approximate service time by the server's clock,
+// which the server schedules by its moving average of service times
+// so that the outsourced requests it promises do not all come due at once.
+// A request resubmitted before its promised time, less Tolerance,
+// is rejected again right away with the same token, without being queued.
+// A request resubmitted once its time has arrived, however late,
+// is due, and goes ahead of all queued requests that are not,
+// bumping one of them if the queue is full.
+// Since the server reads only its own clock from tokens,
+// clients' clocks do not matter, and since tokens expire after MaxAge,
+// neither do promises made before the server's clock jumped far.
+//
+// Fairness is enforced per client identity, as the caller of AdmitAs
+// determines it, rather than per request or connection.
+// The server queues requests in start-time fair order:
+// each queued request gets a virtual start time one past that of
+// the previous request of its client, or of the request last admitted
+// if the client's previous request is older than that,
+// and the server admits requests in order of virtual start time,
+// and then of age, so clients with many requests take turns with others
+// rather than crowding them out.
+// Each client's own requests, in turn, take its start times in order of age.
+// Each token is bound to the identity it was issued to.
+// Since the server keeps state only for clients with requests
+// in service or queued, its state remains bounded by its capacity.
+//
+// The server cannot remember which tokens it has seen,
+// so it presumes tokens older than MaxAge to be replays,
+// and treats requests carrying them as newly arrived.
+// Clients must therefore resubmit requests within MaxAge.
+//
+// Under extreme overload, even outsourcing the queue to clients
+// does not stop aggressive clients from resubmitting tokens at will.
+// If Outsourced is set, the server estimates how many rejected requests
+// its clients are holding, and once that exceeds Outsourced,
+// attaches to each token a puzzle whose difficulty grows with the excess.
+// A resubmitted token then regains its age only with a valid solution,
+// which Solve computes, and otherwise counts as a new request.
+// Since difficulty is capped at MaxPuzzle and a solved request keeps its age,
+// patient clients still eventually obtain service.
+//
+// Set the configuration fields before the first call to Admit,
+// after which a Server is safe for concurrent use.
+//
+type Server struct {
+	Slots  int           // Requests served at once, or 0 for DefaultSlots
+	Queue  int           // Internal queue capacity, or 0 for DefaultQueue
+	MaxAge time.Duration // Maximum token age, or 0 for DefaultMaxAge
+	Key    []byte        // MAC key for tokens, or nil for a random key
+
+	// Early resubmission tolerance, or 0 for DefaultTolerance
+	Tolerance time.Duration
+
+	// WaitBound, if nonzero, is the longest a request should wait
+	// from its first arrival until its admission.
+	// Alarm, if non-nil, is called with the client's identity
+	// and the wait so far each time a request is admitted or rejected
+	// after waiting longer than WaitBound.
+	// It is called with the server locked, so must not call the server.
+	WaitBound time.Duration
+	Alarm     func(id string, wait time.Duration)
+
+	Outsourced int // Outsourced backlog beyond which tokens carry puzzles
+	MaxPuzzle  int // Maximum puzzle difficulty, or 0 for DefaultMaxPuzzle
+
+	mut  sync.Mutex          // protects the fields below
+	key  []byte              // MAC key in use, once initialized
+	busy int                 // slots currently in use
+	wait []*waiter           // internally queued requests
+	load map[string]*account // load of each client with requests
+	now  int64               // virtual start time of the last admission
+	svc  time.Duration       // moving average of service times
+	due  time.Time           // earliest service time not yet promised
+	stat counts              // cumulative counts for Metrics
+
+	backlog float64   // estimated requests outsourced to clients
+	btime   time.Time // when backlog was last updated
+}
+
+// A request waiting in a Server's internal queue
+type waiter struct {
+	id      string     // identity of the client making the request
+	arrival time.Time  // when the request first arrived
+	start   int64      // virtual start time in fair order
+	due     bool       // whether its promised service time has arrived
+	ready   chan error // receives nil when admitted, or a BusyError
+}
+
+// Accounting of one client's requests
+type account struct {
+	active int   // requests in service
+	queued int   // requests in the internal queue
+	served int   // requests admitted since the account was created
+	last   int64 // virtual start time of its latest request
+}
+
+// Fill in the defaults of the configuration, once.
+func (s *Server) init() {
+	if s.key != nil {
+		return
+	}
+	if s.Slots <= 0 {
+		s.Slots = DefaultSlots
+	}
+	if s.Queue <= 0 {
+		s.Queue = DefaultQueue
+	}
+	if s.MaxAge <= 0 {
+		s.MaxAge = DefaultMaxAge
+	}
+	if s.Tolerance <= 0 {
+		s.Tolerance = DefaultTolerance
+	}
+	if s.MaxPuzzle <= 0 || s.MaxPuzzle > maxPuzzle {
+		s.MaxPuzzle = DefaultMaxPuzzle
+	}
+	s.load = make(map[string]*account)
+	s.key = s.Key
+	if s.key == nil {
+		s.key = make([]byte, 32)
+		if _, err := rand.Read(s.key); err != nil {
+			panic("rand.Read: " + err.Error())
+		}
+	}
+}
+
+// Admit admits a request for service, carrying the token from a BusyError
+// if this is a resubmission of a rejected request, or nil otherwise.
+// Admit waits for a service slot if the internal queue has room,
+// and otherwise returns a BusyError.
+// On success, the caller must call done once it has served the request.
+// Admit returns ctx.Err() if ctx is cancelled while the request waits.
+// Requests admitted via Admit all share one anonymous client identity,
+// so that the server orders them purely by age.
+//
+func (s *Server) Admit(ctx context.Context, token []byte) (
+	done func(), err error) {
+
+	return s.AdmitAs(ctx, "", token)
+}
+
+// AdmitAs admits a request for service like Admit,
+// on behalf of the client with identity id,
+// whose requests the server accounts for together.
+//
+func (s *Server) AdmitAs(ctx context.Context, id string, token []byte) (
+	done func(), err error) {
+
+	s.mut.Lock()
+	s.init()
+	now := time.Now()
+	arrival, promise := s.open(token, id, now)
+	a := s.account(id)
+
+	// Serve the request right away if a slot is free and no one waits.
+	if s.busy < s.Slots && len(s.wait) == 0 {
+		s.busy++
+		s.admitted(a, id, arrival, now)
+		s.now = s.next(a)
+		s.mut.Unlock()
+		return s.release(id, time.Now()), nil
+	}
+
+	// Turn away requests resubmitted well before their promised time.
+	due := !promise.IsZero()
+	if due && now.Before(promise.Add(-s.Tolerance)) {
+		s.forget(id, a)
+		s.rejected(id, arrival, now)
+		s.mut.Unlock()
+		return nil, &BusyError{Token: token, Wait: promise.Sub(now)}
+	}
+
+	// Queue the request in its fair position.
+	// If that overfills the queue, the last request in fair order
+	// loses its place, whether that is this request or another.
+	w := &waiter{id: id, arrival: arrival, due: due,
+		ready: make(chan error, 1)}
+	s.enqueue(w, a)
+	if len(s.wait) > s.Queue {
+		s.order()
+		y := s.wait[len(s.wait)-1]
+		s.wait = s.wait[:len(s.wait)-1]
+		s.dequeued(y)
+		if y == w {
+			err := s.busyError(w)
+			s.mut.Unlock()
+			return nil, err
+		}
+		s.stat.bumped++
+		y.ready <- s.busyError(y)
+	}
+	s.mut.Unlock()
+
+	select {
+	case err := <-w.ready:
+		if err != nil {
+			return nil, err
+		}
+		return s.release(id, time.Now()), nil
+
+	case <-ctx.Done():
+	}
+
+	// Leave the queue, unless we were admitted or bumped meanwhile.
+	s.mut.Lock()
+	removed := s.remove(w)
+	s.mut.Unlock()
+	if !removed {
+		if err := <-w.ready; err == nil {
+			s.release(id, time.Now())()
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// Load returns the number of requests the client with identity id
+// has in service and in the internal queue.
+func (s *Server) Load(id string) (active, queued int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if a := s.load[id]; a != nil {
+		return a.active, a.queued
+	}
+	return 0, 0
+}
+
+// Return the account of a client, creating it if necessary.
+func (s *Server) account(id string) *account {
+	a := s.load[id]
+	if a == nil {
+		a = &account{}
+		s.load[id] = a
+	}
+	return a
+}
+
+// Account for a request leaving the internal queue.
+func (s *Server) dequeued(w *waiter) {
+	a := s.load[w.id]
+	a.queued--
+	s.forget(w.id, a)
+}
+
+// Forget the account of a client with no more requests.
+func (s *Server) forget(id string, a *account) {
+	if a.active == 0 && a.queued == 0 {
+		delete(s.load, id)
+	}
+}
+
+// Return a function that releases a service slot a client used since start,
+// admitting the first queued request in fair order in its place.
+func (s *Server) release(id string, start time.Time) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			s.mut.Lock()
+			defer s.mut.Unlock()
+
+			s.busy--
+			a := s.load[id]
+			a.active--
+			s.forget(id, a)
+			s.svc += (time.Since(start) - s.svc) / 8
+			if s.busy < s.Slots && len(s.wait) > 0 {
+				s.order()
+				w := s.wait[0]
+				s.wait = s.wait[1:]
+				s.dequeued(w)
+				s.busy++
+				s.admitted(s.account(w.id), w.id, w.arrival,
+					time.Now())
+				if w.start > s.now {
+					s.now = w.start
+				}
+				w.ready <- nil
+			}
+		})
+	}
+}
+
+// Remove a request from the internal queue,
+// returning false if it is no longer there.
+func (s *Server) remove(w *waiter) bool {
+	for i := range s.wait {
+		if s.wait[i] == w {
+			s.wait = append(s.wait[:i], s.wait[i+1:]...)
+			s.dequeued(w)
+			return true
+		}
+	}
+	return false
+}
+
+// Return the virtual start time of a client's next request.
+func (s *Server) next(a *account) int64 {
+	if a.last < s.now {
+		a.last = s.now
+	}
+	a.last++
+	return a.last
+}
+
+// Add a request of a client to the internal queue,
+// redistributing the client's start times among its requests by age.
+func (s *Server) enqueue(w *waiter, a *account) {
+	w.start = s.next(a)
+	a.queued++
+	s.wait = append(s.wait, w)
+
+	var mine []*waiter
+	var starts []int64
+	for _, x := range s.wait {
+		if x.id == w.id {
+			mine = append(mine, x)
+			starts = append(starts, x.start)
+		}
+	}
+	sort.Slice(mine, func(i, j int) bool {
+		return mine[i].arrival.Before(mine[j].arrival)
+	})
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	for i, x := range mine {
+		x.start = starts[i]
+	}
+}
+
+// Sort the internal queue into fair order:
+// due requests first, then by virtual start time, and then by age.
+func (s *Server) order() {
+	sort.SliceStable(s.wait, func(i, j int) bool {
+		x, y := s.wait[i], s.wait[j]
+		if x.due != y.due {
+			return x.due
+		}
+		return x.start < y.start || (x.start == y.start &&
+			x.arrival.Before(y.arrival))
+	})
+}
+
+// Return a BusyError rejecting a queued request,
+// promising it service about when the queue will have drained
+// and all requests already promised earlier service have been served.
+func (s *Server) busyError(w *waiter) error {
+	now := time.Now()
+	s.rejected(w.id, w.arrival, now)
+	wait := s.svc * time.Duration(len(s.wait)+1) / time.Duration(s.Slots)
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	promise := now.Add(wait)
+	if promise.Before(s.due) {
+		promise = s.due
+		wait = promise.Sub(now)
+	}
+	s.due = promise.Add(s.svc / time.Duration(s.Slots))
+
+	d := s.puzzle(now, wait)
+	return &BusyError{Token: s.seal(w.arrival, promise, w.id, d), Wait: wait}
+}
+
+// Seal a request's arrival and promised service times
+// into a token for client id, carrying a puzzle of difficulty d bits.
+func (s *Server) seal(arrival, promise time.Time, id string, d int) []byte {
+	t := make([]byte, puzzleOfs+1, tokenLen)
+	binary.BigEndian.PutUint64(t, uint64(arrival.UnixNano()))
+	binary.BigEndian.PutUint64(t[8:], uint64(promise.UnixNano()))
+	t[puzzleOfs] = byte(d)
+	return append(t, s.mac(t, id)...)
+}
+
+// Open a token presented by client id,
+// returning the arrival and promised service times it records,
+// or now and the zero time if the token is absent, invalid,
+// issued to another client, expired,
+// or carries a puzzle without a valid solution.
+func (s *Server) open(token []byte, id string, now time.Time) (
+	arrival, promise time.Time) {
+
+	if (len(token) != tokenLen && len(token) != tokenLen+nonceLen) ||
+		!hmac.Equal(token[puzzleOfs+1:tokenLen],
+			s.mac(token[:puzzleOfs+1], id)) {
+		return now, time.Time{}
+	}
+	if d := int(token[puzzleOfs]); d > 0 && !solved(token, d) {
+		return now, time.Time{}
+	}
+	arrival = time.Unix(0, int64(binary.BigEndian.Uint64(token)))
+	promise = time.Unix(0, int64(binary.BigEndian.Uint64(token[8:])))
+	if now.Sub(arrival) > s.MaxAge || arrival.After(now) ||
+		promise.Sub(now) > s.MaxAge {
+		return now, time.Time{}
+	}
+	return arrival, promise
+}
+
+// Compute the MAC of a token's content, binding it to client id.
+func (s *Server) mac(b []byte, id string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(b)
+	h.Write([]byte(id))
+	return h.Sum(nil)[:macLen]
+}
+
+// Retry calls try with no token, then, as long as try returns a BusyError,
+// waits for about the delay the error suggests
+// and calls try again with the error's token,
+// solving any puzzle the token carries while it waits,
+// so that the request keeps its place in the server's fair order.
+// Retry returns nil once try succeeds, the first other error try returns,
+// or ctx.Err() if ctx is cancelled while waiting.
+//
+func Retry(ctx context.Context, try func(token []byte) error) error {
+	var token []byte
+	for {
+		err := try(token)
+		var be *BusyError
+		if !errors.As(err, &be) {
+			return err
+		}
+
+		// Spread resubmissions out a bit so they don't arrive in bursts.
+		wait := be.Wait + time.Duration(mrand.Int63n(int64(be.Wait)/2+1))
+		start := time.Now()
+		token = Solve(be.Token)
+		t := time.NewTimer(wait - time.Since(start))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
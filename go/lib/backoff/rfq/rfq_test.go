@@ -0,0 +1,375 @@
+package rfq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that a Server serves, queues, and rejects requests in turn.
+func TestAdmit(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{Slots: 1, Queue: 1}
+
+	done, err := s.Admit(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next request waits for the slot.
+	admitted := make(chan func())
+	go func() {
+		d, err := s.Admit(ctx, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		admitted <- d
+	}()
+	for waiting(s) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A third fills the queue, so is rejected with a token.
+	_, err = s.Admit(ctx, nil)
+	var be *BusyError
+	if !errors.As(err, &be) || !errors.Is(err, ErrBusy) ||
+		len(be.Token) == 0 || be.Wait <= 0 {
+		t.Fatalf("full server returned %v", err)
+	}
+
+	done()
+	(<-admitted)()
+
+	// A cancelled request gives up its place.
+	done, _ = s.Admit(ctx, nil)
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		for waiting(s) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := s.Admit(cctx, nil); err != context.Canceled {
+		t.Errorf("cancelled request returned %v", err)
+	}
+	if waiting(s) != 0 {
+		t.Errorf("cancelled request still queued")
+	}
+	done()
+}
+
+// Return the number of requests waiting in a Server's internal queue.
+func waiting(s *Server) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return len(s.wait)
+}
+
+// Test that a resubmitted request bumps a younger queued request,
+// and that forged tokens confer no priority.
+func TestBump(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{Slots: 1, Queue: 1}
+
+	// Fill the slot and the queue, then obtain a token.
+	done, _ := s.Admit(ctx, nil)
+	actx, cancel := context.WithCancel(ctx)
+	cancelled := make(chan error)
+	go func() {
+		_, err := s.Admit(actx, nil)
+		cancelled <- err
+	}()
+	for waiting(s) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := s.Admit(ctx, nil)
+	var be *BusyError
+	if !errors.As(err, &be) {
+		t.Fatalf("full server returned %v", err)
+	}
+
+	// Replace the queued request with one younger than the token.
+	cancel()
+	<-cancelled
+	time.Sleep(time.Millisecond)
+	bumped := make(chan error)
+	go func() {
+		_, err := s.Admit(ctx, nil)
+		bumped <- err
+	}()
+	for waiting(s) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A forged token is worth nothing.
+	forged := append([]byte{}, be.Token...)
+	forged[len(forged)-1] ^= 1
+	if _, err := s.Admit(ctx, forged); !errors.Is(err, ErrBusy) {
+		t.Errorf("forged token returned %v", err)
+	}
+
+	// But the genuine token bumps the younger request.
+	admitted := make(chan error)
+	go func() {
+		_, err := s.Admit(ctx, be.Token)
+		admitted <- err
+	}()
+	if err := <-bumped; !errors.Is(err, ErrBusy) {
+		t.Errorf("younger request returned %v", err)
+	}
+	done()
+	if err := <-admitted; err != nil {
+		t.Errorf("older request returned %v", err)
+	}
+}
+
+// Test that a slow client, which waits long between resubmissions,
+// is not starved by fast clients that keep the server saturated,
+// whether the fast clients discard their tokens or keep them too.
+func TestStarvation(t *testing.T) {
+	t.Run("fresh", func(t *testing.T) { testStarvation(t, false) })
+	t.Run("tokens", func(t *testing.T) { testStarvation(t, true) })
+}
+
+func testStarvation(t *testing.T, tokens bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Server{Slots: 1, Queue: 2}
+
+	// Fast clients submit requests back to back,
+	// resubmitting them promptly with their tokens if tokens is set.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				Retry(ctx, func(token []byte) error {
+					if !tokens {
+						token = nil
+					}
+					done, err := s.Admit(ctx, token)
+					if err == nil {
+						time.Sleep(100 * time.Microsecond)
+						done()
+					}
+					return err
+				})
+			}
+		}()
+	}
+
+	// The slow client resubmits only after a delay far exceeding
+	// the service time, so would never find room in the queue.
+	for waiting(s) < s.Queue {
+		time.Sleep(time.Millisecond)
+	}
+	sctx, scancel := context.WithTimeout(ctx, 5*time.Second)
+	defer scancel()
+	tries := 0
+	err := Retry(sctx, func(token []byte) error {
+		tries++
+		if tries > 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		done, err := s.Admit(sctx, token)
+		if err == nil {
+			done()
+		}
+		return err
+	})
+	cancel()
+	wg.Wait()
+	if err != nil || tries > 3 {
+		t.Errorf("slow client got %v after %d tries", err, tries)
+	}
+}
+
+// Test that a Server orders queued requests fairly among clients,
+// so that a client submitting many requests cannot crowd out others.
+func TestClients(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{Slots: 1, Queue: 4}
+
+	// Client a occupies the slot and queues three more requests.
+	done, _ := s.AdmitAs(ctx, "a", nil)
+	type result struct {
+		id   string
+		done func()
+		err  error
+	}
+	results := make(chan result, 10)
+	admit := func(id string) {
+		go func() {
+			d, err := s.AdmitAs(ctx, id, nil)
+			results <- result{id, d, err}
+		}()
+	}
+	submit := func(id string) {
+		n := waiting(s)
+		admit(id)
+		for waiting(s) == n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		submit("a")
+	}
+	if active, queued := s.Load("a"); active != 1 || queued != 3 {
+		t.Fatalf("client a has %v active, %v queued", active, queued)
+	}
+
+	// Clients b and c queue one request each,
+	// so that one of a's requests loses its place to c's.
+	submit("b")
+	admit("c")
+	r := <-results
+	var be *BusyError
+	if r.id != "a" || !errors.As(r.err, &be) {
+		t.Fatalf("client %v got %v", r.id, r.err)
+	}
+
+	// Client a's token is worthless to other clients.
+	now := time.Now()
+	if a, _ := s.open(be.Token, "a", now); a == now {
+		t.Errorf("token rejected for its own client")
+	}
+	if d, _ := s.open(be.Token, "d", now); d != now {
+		t.Errorf("token accepted for another client")
+	}
+
+	// Each client's first queued request then goes in order of age,
+	// ahead of a's second queued request,
+	// although a's second request is older than b's and c's.
+	for _, want := range []string{"a", "b", "c", "a"} {
+		done()
+		r := <-results
+		if r.id != want || r.err != nil {
+			t.Fatalf("admitted %v %v, expected %v", r.id, r.err, want)
+		}
+		done = r.done
+	}
+	done()
+	if active, queued := s.Load("a"); active != 0 || queued != 0 {
+		t.Errorf("idle client a has %v active, %v queued", active, queued)
+	}
+}
+
+// Test that an extremely overloaded Server attaches puzzles to its tokens,
+// which confer priority only once solved.
+func TestPuzzle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Server{Slots: 1, Queue: 1, Outsourced: 2, MaxPuzzle: 12}
+
+	// Fill the slot and the queue.
+	done, _ := s.Admit(ctx, nil)
+	defer done()
+	go s.Admit(ctx, nil)
+	for waiting(s) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Pretend service is slow, so the backlog estimate decays slowly.
+	s.mut.Lock()
+	s.svc = time.Second
+	s.mut.Unlock()
+
+	// Rejections carry no puzzles until the backlog exceeds Outsourced,
+	// then increasingly difficult ones.
+	var be *BusyError
+	prev := 0
+	for i := 0; i < 20; i++ {
+		_, err := s.Admit(ctx, nil)
+		if !errors.As(err, &be) {
+			t.Fatalf("full server returned %v", err)
+		}
+		d := int(be.Token[puzzleOfs])
+		if i < s.Outsourced && d != 0 || d < prev || d > s.MaxPuzzle {
+			t.Fatalf("rejection %v carries difficulty %v", i, d)
+		}
+		prev = d
+	}
+	if prev < minPuzzle {
+		t.Fatalf("overloaded server issued difficulty %v", prev)
+	}
+
+	// The token confers its age only with a valid solution.
+	now := time.Now()
+	if a, _ := s.open(be.Token, "", now); a != now {
+		t.Errorf("unsolved token accepted")
+	}
+	sol := Solve(be.Token)
+	if a, _ := s.open(sol, "", now); a == now {
+		t.Errorf("solved token rejected")
+	}
+	sol[len(sol)-1] ^= 1
+	if a, _ := s.open(sol, "", now); a != now && !solved(sol, prev) {
+		t.Errorf("invalid solution accepted")
+	}
+}
+
+// Test that a Server turns away requests resubmitted before their promised
+// service time, and gives them priority once it arrives.
+func TestPromise(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{Slots: 1, Queue: 1, Tolerance: time.Millisecond}
+
+	// Fill the slot and the queue, pretending service is slow.
+	done, _ := s.Admit(ctx, nil)
+	bumped := make(chan error)
+	go func() {
+		_, err := s.Admit(ctx, nil)
+		bumped <- err
+	}()
+	for waiting(s) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.mut.Lock()
+	s.svc = 20 * time.Millisecond
+	s.mut.Unlock()
+
+	// Successive rejections are promised successive service times.
+	var be1, be2 *BusyError
+	_, err1 := s.Admit(ctx, nil)
+	_, err2 := s.Admit(ctx, nil)
+	if !errors.As(err1, &be1) || !errors.As(err2, &be2) {
+		t.Fatalf("full server returned %v, %v", err1, err2)
+	}
+	_, p1 := s.open(be1.Token, "", time.Now())
+	_, p2 := s.open(be2.Token, "", time.Now())
+	if p2.Sub(p1) < s.svc || be2.Wait <= be1.Wait {
+		t.Errorf("promised %v then %v", be1.Wait, be2.Wait)
+	}
+
+	// An early resubmission is turned away with the same token.
+	_, err := s.Admit(ctx, be1.Token)
+	var be *BusyError
+	if !errors.As(err, &be) || string(be.Token) != string(be1.Token) ||
+		be.Wait <= 0 || be.Wait > be1.Wait {
+		t.Fatalf("early resubmission returned %v", err)
+	}
+	if waiting(s) != 1 {
+		t.Fatalf("early resubmission disturbed the queue")
+	}
+
+	// Once due, it bumps the queued request, although that one is older.
+	time.Sleep(time.Until(p1))
+	admitted := make(chan error)
+	go func() {
+		d, err := s.Admit(ctx, be1.Token)
+		if err == nil {
+			d()
+		}
+		admitted <- err
+	}()
+	if err := <-bumped; !errors.Is(err, ErrBusy) {
+		t.Errorf("queued request returned %v", err)
+	}
+	done()
+	if err := <-admitted; err != nil {
+		t.Errorf("due request returned %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Watcher is an optional interface a Store may implement
+// to notify callers of changes to its state as they happen.
+//
+// Watch calls f with the version and value of each new state
+// the Store observes, in increasing version order,
+// until ctx is cancelled or f returns an error,
+// and then returns the error.
+// Watch may skip intermediate states that are quickly replaced.
+//
+type Watcher interface {
+	Watch(ctx context.Context, f func(version int64, value string) error) error
+}
+
+// ErrNoWatch is the error Cached.Follow returns
+// if the underlying Store does not implement Watcher.
+var ErrNoWatch = errors.New("store does not support watching")
+
+// Cached decorates a Store with a client-side cache
+// of the latest version and value it has observed,
+// from which it serves reads without accessing the underlying Store,
+// so as to cut the load of read-mostly workloads
+// such as configuration distribution.
+//
+// A read is a CompareAndSet whose old and new values are equal,
+// which leaves the state unchanged and simply returns it.
+// Cached serves a read from its cache if it observed the cached state
+// no longer than MaxStale ago, and otherwise forwards it to the Store.
+// A cached read may thus miss changes other clients made
+// within the last MaxStale period,
+// which is why caching is safe only where the caller tolerates such lag.
+// Cached always forwards CompareAndSet operations that change the state,
+// caching the state they return.
+//
+// If the Store implements Watcher, Follow keeps the cache up to date
+// with the changes the Store reports, so that reads miss fewer changes.
+// Invalidate discards the cache, as when a caller learns by other means
+// that the state has changed.
+//
+// The caller must set Store before use,
+// after which a Cached store is safe for concurrent use.
+//
+type Cached struct {
+	Store    Store         // Underlying store whose state to cache
+	MaxStale time.Duration // Staleness bound for cached reads, or 0
+
+	mut sync.Mutex // protects the fields below
+	ok  bool       // whether the cache holds a state
+	ver int64      // version of the cached state
+	val string     // value of the cached state
+	at  time.Time  // when the cached state was observed
+	inv time.Time  // when the cache was last invalidated
+}
+
+// CompareAndSet implements the Store interface,
+// serving reads from the cache while it is fresh enough.
+func (c *Cached) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	now := time.Now()
+	if old == new {
+		c.mut.Lock()
+		ok, ver, val := c.ok && now.Sub(c.at) < c.MaxStale, c.ver, c.val
+		c.mut.Unlock()
+		if ok {
+			return ver, val, nil
+		}
+	}
+
+	version, actual, err = c.Store.CompareAndSet(ctx, old, new)
+	if err != nil {
+		return 0, "", err
+	}
+	c.observe(version, actual, now)
+	return version, actual, nil
+}
+
+// Record a state observed at a given time,
+// unless the cache already holds a later one.
+func (c *Cached) observe(ver int64, val string, at time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if at.Before(c.inv) ||
+		(c.ok && (ver < c.ver || (ver == c.ver && at.Before(c.at)))) {
+		return // invalidated since, or older than what we have
+	}
+	c.ok, c.ver, c.val, c.at = true, ver, val, at
+}
+
+// Invalidate discards the cached state,
+// along with any state observed by operations already in progress,
+// so that the next read accesses the underlying Store.
+func (c *Cached) Invalidate() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.ok, c.inv = false, time.Now()
+}
+
+// Follow watches the underlying Store for changes
+// and caches each new state it reports,
+// until ctx is cancelled or the watch fails,
+// and then returns the error.
+// Follow returns ErrNoWatch immediately if the Store does not implement Watcher.
+// If the watch fails, Follow invalidates the cache,
+// since it may have missed changes.
+//
+func (c *Cached) Follow(ctx context.Context) error {
+	w, ok := c.Store.(Watcher)
+	if !ok {
+		return ErrNoWatch
+	}
+	err := w.Watch(ctx, func(ver int64, val string) error {
+		c.observe(ver, val, time.Now())
+		return nil
+	})
+	c.Invalidate()
+	return err
+}
@@ -0,0 +1,106 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A Register that counts its accesses and reports changes to watchers.
+type watchedRegister struct {
+	Register
+	calls   atomic.Int64
+	changes chan [2]interface{}
+}
+
+func (r *watchedRegister) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	r.calls.Add(1)
+	return r.Register.CompareAndSet(ctx, old, new)
+}
+
+func (r *watchedRegister) Watch(ctx context.Context,
+	f func(version int64, value string) error) error {
+
+	for {
+		select {
+		case c := <-r.changes:
+			if err := f(c[0].(int64), c[1].(string)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	check := func(c *Cached, ver int64, val string) {
+		t.Helper()
+		v, s, err := c.CompareAndSet(ctx, "?", "?")
+		if err != nil || v != ver || s != val {
+			t.Errorf("read %v %q %v, want %v %q", v, s, err, ver, val)
+		}
+	}
+
+	r := &watchedRegister{changes: make(chan [2]interface{})}
+	c := &Cached{Store: r, MaxStale: time.Hour}
+	other := &Cached{Store: r}
+
+	// Reads hit the Store only until the cache is filled,
+	// while changes always go through to it.
+	check(c, 0, "")
+	check(c, 0, "")
+	if _, _, err := c.CompareAndSet(ctx, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	check(c, 1, "a")
+	if n := r.calls.Load(); n != 2 {
+		t.Errorf("%v Store accesses, want 2", n)
+	}
+
+	// Another client's change goes unseen until invalidation,
+	// or without a staleness bound.
+	if _, _, err := other.CompareAndSet(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	check(other, 2, "b")
+	check(c, 1, "a")
+	c.Invalidate()
+	check(c, 2, "b")
+
+	// A watch keeps the cache up to date.
+	unwatched := &Cached{Store: &Register{}}
+	if err := unwatched.Follow(ctx); err != ErrNoWatch {
+		t.Errorf("Follow on unwatched store yielded %v", err)
+	}
+	wctx, wcancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- c.Follow(wctx) }()
+	if _, _, err := other.CompareAndSet(ctx, "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	r.changes <- [2]interface{}{int64(3), "c"}
+	r.changes <- [2]interface{}{int64(1), "a"} // stale event ignored
+	calls := r.calls.Load()
+	check(c, 3, "c")
+	if n := r.calls.Load(); n != calls {
+		t.Errorf("watched read accessed the Store")
+	}
+
+	// The cache is discarded when the watch ends.
+	wcancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Follow yielded %v", err)
+	}
+	check(c, 3, "c")
+	if n := r.calls.Load(); n != calls+1 {
+		t.Errorf("read after watch did not access the Store")
+	}
+}
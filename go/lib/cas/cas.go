@@ -6,6 +6,8 @@ package cas
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -21,6 +23,8 @@ import (
 // While values in principle have no particular length limit, in practice
 // Store implementations may expect them to be "reasonably small", i.e.,
 // efficient for storing metadata but not necessarily for bulk data storage.
+// A Store may enforce a maximum value size,
+// returning a SizeError when asked to write a larger value.
 //
 // The Store assigns a version number to each value CompareAndSet returns.
 // Version numbers must be monotonic but need not be assigned consecutively.
@@ -48,9 +52,77 @@ type Store interface {
 		version int64, actual string, err error)
 }
 
+// History is an optional interface a Store may implement
+// to provide read access to past state versions it retains,
+// in addition to the latest state that CompareAndSet returns.
+//
+// ReadAt returns the latest retained version at or before ver,
+// with its version number, which may be earlier than ver
+// since version numbers need not be consecutive.
+// ListVersions returns the retained version numbers
+// from through to inclusive, in increasing order.
+// Both return an error if the relevant versions are no longer retained.
+//
+type History interface {
+	ReadAt(ctx context.Context, ver int64) (
+		version int64, value string, err error)
+	ListVersions(ctx context.Context, from, to int64) ([]int64, error)
+}
+
+// Nonced is an optional interface a Store may implement
+// to identify the incarnation of its underlying state.
+//
+// Nonce returns a random nonce the Store chose when its state was created,
+// and which remains the same for as long as the state survives.
+// If the state is lost, as on a disk wipe, and recreated empty,
+// its nonce changes, so clients that recorded the nonce earlier
+// can detect that the Store has lost its memory
+// rather than silently trusting its empty state.
+//
+type Nonced interface {
+	Nonce(ctx context.Context) (string, error)
+}
+
+// ErrTooLarge is the error a SizeError wraps,
+// so that callers may detect oversized values with errors.Is.
+var ErrTooLarge = errors.New("value too large")
+
+// SizeError reports that a Store refused to write a value
+// of Size bytes, exceeding its maximum value size Max.
+type SizeError struct {
+	Size int // Size of the value the caller tried to write
+	Max  int // Maximum value size the Store permits
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes exceeds maximum of %d",
+		ErrTooLarge, e.Size, e.Max)
+}
+
+// Unwrap returns ErrTooLarge.
+func (e *SizeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// CheckSize returns a SizeError if value val exceeds max bytes,
+// or nil if it does not or if max is zero, meaning no limit.
+func CheckSize(val string, max int) error {
+	if max > 0 && len(val) > max {
+		return &SizeError{Size: len(val), Max: max}
+	}
+	return nil
+}
+
 // Register implements a simple local-memory CAS register.
 // It is thread-safe and ready for use on instantiation.
+//
+// MaxValueSize optionally limits the size in bytes of the values
+// the Register accepts, or is zero for no limit.
+// It must not be changed while the Register is in use.
+//
 type Register struct {
+	MaxValueSize int // Maximum value size in bytes, or 0 for no limit
+
 	mut sync.Mutex // for synchronizing accesses
 	ver int64      // version number of the latest value
 	val string     // the latest value written
@@ -60,6 +132,10 @@ type Register struct {
 func (r *Register) CompareAndSet(ctx context.Context, old, new string) (
 	version int64, actual string, err error) {
 
+	if err := CheckSize(new, r.MaxValueSize); err != nil {
+		return 0, "", err
+	}
+
 	r.mut.Lock()
 	defer r.mut.Unlock()
 
@@ -0,0 +1,80 @@
+package cas
+
+import "errors"
+
+// Classes of errors that Stores and the consensus layers above them return,
+// so that callers can tell programmatically, with errors.Is,
+// whether retrying a failed operation may help.
+// Each package keeps its own more specific errors,
+// such as verst.ErrExpired or qscas.ErrStaleEpoch,
+// and classifies them with Classify so that they match one of these too.
+var (
+	// ErrQuorumUnavailable means too few members of a group responded
+	// to form the quorum an operation needs. Retrying may succeed
+	// once enough members recover.
+	ErrQuorumUnavailable = errors.New("quorum unavailable")
+
+	// ErrStale means the state an operation observed
+	// is older than the operation requires. Retrying may succeed
+	// once the state catches up, or after refreshing the caller's view.
+	ErrStale = errors.New("state is stale")
+
+	// ErrExpired means an operation addressed a version of the state
+	// that has been expired and is no longer retained,
+	// so retrying the same operation cannot succeed.
+	ErrExpired = errors.New("version expired")
+
+	// ErrConflict means a concurrent change by another client
+	// won over the operation's. Retrying may succeed
+	// after re-reading the state the other client changed.
+	ErrConflict = errors.New("conflicting change")
+
+	// ErrUnknownOutcome means an operation may or may not
+	// have taken effect. Retrying a compare-and-set is safe
+	// and reveals the state as it is now.
+	ErrUnknownOutcome = errors.New("outcome unknown")
+
+	// ErrCorrupt means stored state is malformed or otherwise
+	// cannot be interpreted, so retrying cannot succeed.
+	ErrCorrupt = errors.New("corrupt state")
+)
+
+// Classify returns an error with the same message as err
+// that errors.Is matches both to class and to err,
+// along with any error err wraps.
+func Classify(class, err error) error {
+	return &classError{err, class}
+}
+
+type classError struct {
+	err   error // the specific error
+	class error // the class it belongs to
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.err, e.class}
+}
+
+// Retryable returns true if err is in a class of errors
+// that retrying the failed operation may overcome:
+// ErrQuorumUnavailable, ErrStale, ErrConflict, or ErrUnknownOutcome.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrQuorumUnavailable) ||
+		errors.Is(err, ErrStale) || errors.Is(err, ErrConflict) ||
+		errors.Is(err, ErrUnknownOutcome)
+}
+
+// Fatal returns true if err is in a class of errors
+// that retrying the same operation cannot overcome:
+// ErrExpired, ErrCorrupt, or ErrTooLarge.
+// Unclassified errors, such as network errors,
+// are neither Retryable nor Fatal:
+// the caller must judge whether they might be temporary.
+func Fatal(err error) bool {
+	return errors.Is(err, ErrExpired) ||
+		errors.Is(err, ErrCorrupt) || errors.Is(err, ErrTooLarge)
+}
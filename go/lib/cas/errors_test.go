@@ -0,0 +1,33 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	base := errors.New("base")
+	err := fmt.Errorf("op: %w", Classify(ErrStale, base))
+	if !errors.Is(err, base) || !errors.Is(err, ErrStale) ||
+		errors.Is(err, ErrConflict) {
+		t.Errorf("classified error matches wrongly")
+	}
+	if err.Error() != "op: base" {
+		t.Errorf("classified error message %q", err.Error())
+	}
+	if !Retryable(err) || Fatal(err) {
+		t.Errorf("stale error not retryable")
+	}
+
+	err = Classify(ErrCorrupt, base)
+	if Retryable(err) || !Fatal(err) {
+		t.Errorf("corrupt error not fatal")
+	}
+	if Retryable(base) || Fatal(base) {
+		t.Errorf("unclassified error classified")
+	}
+	if !Fatal(CheckSize("xx", 1)) {
+		t.Errorf("oversized value not fatal")
+	}
+}
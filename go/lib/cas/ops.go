@@ -0,0 +1,194 @@
+package cas
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+)
+
+// Idempotent is an optional interface a Store may implement
+// to let callers tag CompareAndSet operations with operation IDs,
+// so that a client that crashes and restarts, or otherwise loses track
+// of an operation in flight, can safely retry it or determine its outcome.
+//
+// CompareAndSetOp performs a CompareAndSet operation tagged with id,
+// a string unique to the operation such as a random UUID,
+// recording id with the new version if the operation takes effect.
+// Retrying an operation that already took effect does not write again,
+// even if the state has since returned to old,
+// but simply returns the latest version and value.
+//
+// Committed reports whether the operation tagged with id took effect.
+// A Store may retain only the IDs of its most recent operations,
+// in which case Committed reports older operations as not committed.
+//
+type Idempotent interface {
+	Store
+	CompareAndSetOp(ctx context.Context, id, old, new string) (
+		version int64, actual string, err error)
+	Committed(ctx context.Context, id string) (bool, error)
+}
+
+// DefaultKeep is the default number of recent operation IDs
+// that a Tagged store retains.
+const DefaultKeep = 64
+
+// Tagged adapts any Store to the Idempotent interface
+// by recording the IDs of the most recent operations
+// in the state values it writes to the underlying Store,
+// so that operation IDs survive client crashes and restarts
+// in the same way the state itself does.
+// All clients of the underlying Store must access it via Tagged wrappers.
+//
+// The caller must set Store before use,
+// after which a Tagged store is safe for concurrent use.
+//
+type Tagged struct {
+	Store Store // Underlying store holding values and operation IDs
+	Keep  int   // Number of operation IDs to retain, or 0 for DefaultKeep
+
+	mut sync.Mutex // protects raw
+	raw string     // last value observed in the underlying Store
+}
+
+// CompareAndSet performs an untagged CompareAndSet operation,
+// implementing the Store interface.
+func (t *Tagged) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	return t.CompareAndSetOp(ctx, "", old, new)
+}
+
+// CompareAndSetOp performs a CompareAndSet operation tagged with id,
+// or untagged if id is empty,
+// implementing the Idempotent interface.
+func (t *Tagged) CompareAndSetOp(ctx context.Context, id, old, new string) (
+	version int64, actual string, err error) {
+
+	t.mut.Lock()
+	raw := t.raw
+	t.mut.Unlock()
+
+	for {
+		// Write new, with id recorded, if the state is still old
+		// and the operation hasn't already taken effect.
+		// Otherwise just confirm that raw is the latest state.
+		ids, val := splitOps(raw)
+		done := id != "" && hasOp(ids, id)
+		next := raw
+		if !done && val == old {
+			next = joinOps(t.record(ids, id), new)
+		}
+
+		version, act, err := t.Store.CompareAndSet(ctx, raw, next)
+		if err != nil {
+			return 0, "", err
+		}
+		t.mut.Lock()
+		t.raw = act
+		t.mut.Unlock()
+
+		if act == next || done {
+			_, actual = splitOps(act)
+			return version, actual, nil
+		}
+		raw = act // the state changed underneath us, so try again
+	}
+}
+
+// Committed reports whether the operation tagged with id took effect,
+// implementing the Idempotent interface.
+func (t *Tagged) Committed(ctx context.Context, id string) (bool, error) {
+	t.mut.Lock()
+	raw := t.raw
+	t.mut.Unlock()
+
+	// Read the latest state, without changing it.
+	for {
+		_, act, err := t.Store.CompareAndSet(ctx, raw, raw)
+		if err != nil {
+			return false, err
+		}
+		t.mut.Lock()
+		t.raw = act
+		t.mut.Unlock()
+
+		if act == raw {
+			ids, _ := splitOps(raw)
+			return hasOp(ids, id), nil
+		}
+		raw = act
+	}
+}
+
+// Add id, if any, to the operation IDs to record with a new value,
+// keeping only the most recent ones.
+func (t *Tagged) record(ids []string, id string) []string {
+	if id == "" {
+		return ids
+	}
+	keep := t.Keep
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	ids = append(append([]string{}, ids...), id)
+	if len(ids) > keep {
+		ids = ids[len(ids)-keep:]
+	}
+	return ids
+}
+
+// A value recording operation IDs starts with this prefix,
+// followed by the number of IDs as a uvarint,
+// each ID preceded by its length as a uvarint,
+// and finally the caller's value.
+const opsPrefix = "\x00cas-ops\x00"
+
+// Join operation IDs, if any, with a caller's value.
+func joinOps(ids []string, val string) string {
+	if len(ids) == 0 && !strings.HasPrefix(val, opsPrefix) {
+		return val
+	}
+	var l [binary.MaxVarintLen64]byte
+	b := []byte(opsPrefix)
+	b = append(b, l[:binary.PutUvarint(l[:], uint64(len(ids)))]...)
+	for _, id := range ids {
+		b = append(b, l[:binary.PutUvarint(l[:], uint64(len(id)))]...)
+		b = append(b, id...)
+	}
+	return string(b) + val
+}
+
+// Split a stored value into its operation IDs and the caller's value.
+func splitOps(raw string) ([]string, string) {
+	if !strings.HasPrefix(raw, opsPrefix) {
+		return nil, raw
+	}
+	b := []byte(raw[len(opsPrefix):])
+	n, l := binary.Uvarint(b)
+	if l <= 0 || n > uint64(len(b)) {
+		return nil, raw // not a valid encoding after all
+	}
+	b = b[l:]
+	ids := make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		m, l := binary.Uvarint(b)
+		if l <= 0 || m > uint64(len(b)-l) {
+			return nil, raw
+		}
+		ids = append(ids, string(b[l:l+int(m)]))
+		b = b[l+int(m):]
+	}
+	return ids, string(b)
+}
+
+// Return true if an operation ID is among ids.
+func hasOp(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ package test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -25,7 +26,7 @@ type History struct {
 // checks it for consistency against all prior recorded old/new value pairs,
 // and reports any errors via testing context t.
 //
-func (to *History) Observe(t *testing.T, version int64, value string) {
+func (to *History) Observe(t testing.TB, version int64, value string) {
 	to.mut.Lock()
 	defer to.mut.Unlock()
 
@@ -52,13 +53,15 @@ func (to *History) Observe(t *testing.T, version int64, value string) {
 // e.g., that the provided old value is indeed the last version retrieved.
 // This means that when checking a Store that is shared across goroutines,
 // each goroutine must have its own Checked wrapper around that Store.
+// If h is nil, the wrapper checks only the caller's accesses,
+// e.g., because a Linearizable wrapper below it records the history.
 //
-func Checked(t *testing.T, h *History, store cas.Store) cas.Store {
+func Checked(t testing.TB, h *History, store cas.Store) cas.Store {
 	return &checkedStore{t: t, h: h, s: store}
 }
 
 type checkedStore struct {
-	t *testing.T // Testing context
+	t testing.TB // Testing context
 	h *History   // History we're using for consistency-checking
 	s cas.Store  // Underlying compare-and-set Store
 
@@ -85,6 +88,9 @@ func (cs *checkedStore) CompareAndSet(ctx context.Context, old, new string) (
 
 	// Try to change old to new atomically.
 	version, actual, err = cs.s.CompareAndSet(ctx, old, new)
+	if err != nil {
+		return 0, "", err // the caller re-reads or reports it
+	}
 
 	// Sanity-check the Store-assigned version numbers
 	if version < cs.lver {
@@ -95,7 +101,9 @@ func (cs *checkedStore) CompareAndSet(ctx context.Context, old, new string) (
 	}
 
 	// Record and consistency-check all version/value pairs we observe.
-	cs.h.Observe(cs.t, version, actual)
+	if cs.h != nil {
+		cs.h.Observe(cs.t, version, actual)
+	}
 
 	// Produce our own informational version numbers to return
 	// that increase a bit unpredictability for testing purposes.
@@ -116,23 +124,74 @@ func (cs *checkedStore) CompareAndSet(ctx context.Context, old, new string) (
 // The test is driven by nthreads goroutines per Store interface,
 // each of which performs naccesses CAS operations on its interface.
 //
-func Stores(t *testing.T, nthreads, naccesses int, store ...cas.Store) {
+func Stores(t testing.TB, nthreads, naccesses int, store ...cas.Store) {
+	h := &History{}
+	stores(t, nthreads, naccesses, store, false,
+		func(s cas.Store) cas.Store {
+			return Checked(t, h, s)
+		})
+}
+
+// LinearStores torture-tests one or more cas.Store interfaces like Stores,
+// but checks that the observed history is linearizable
+// rather than only that each version has a consistent value.
+//
+func LinearStores(t testing.TB, nthreads, naccesses int, store ...cas.Store) {
+	l := &Linear{}
+	stores(t, nthreads, naccesses, store, false,
+		func(s cas.Store) cas.Store {
+			return Checked(t, nil, Linearizable(t, l, s))
+		})
+	l.Check(t)
+}
+
+// FaultyStores torture-tests one or more cas.Store interfaces
+// like LinearStores, but injects the faults configured in f
+// into every access, retrying each access that fails until it succeeds.
+// Each thread's Faulty wrapper is seeded differently, starting from f.Seed.
+//
+// Injected timeouts and cancellations check that the Store
+// remains linearizable when clients retry accesses of unknown outcome,
+// some of which took effect before they failed.
+// Duplicate responses and stale reads are faults of the wrapper itself,
+// which the check always reports, so f should inject neither.
+//
+func FaultyStores(t testing.TB, f Faults, nthreads, naccesses int,
+	store ...cas.Store) {
+
+	l := &Linear{}
+	stores(t, nthreads, naccesses, store, true,
+		func(s cas.Store) cas.Store {
+			f.Seed++
+			return Linearizable(t, l, Faulty(s, f))
+		})
+	l.Check(t)
+}
+
+// Run a torture test on store, wrapping each thread's Store with check,
+// and retrying accesses that fail if retry is true
+// or whose outcome is unknown (cas.ErrUnknownOutcome).
+func stores(t testing.TB, nthreads, naccesses int, store []cas.Store,
+	retry bool, check func(cas.Store) cas.Store) {
 
 	bg := context.Background()
 	wg := sync.WaitGroup{}
-	h := &History{}
 
-	tester := func(i, j int) {
-		cs := Checked(t, h, store[i])
-		old, err := "", error(nil)
+	tester := func(i, j int, cs cas.Store) {
+		old := ""
 		for k := 0; k < naccesses; k++ {
 			new := fmt.Sprintf("store %v thread %v access %v",
 				i, j, k)
 			//println("tester", i, j, "access", k)
-			_, old, err = cs.CompareAndSet(bg, old, new)
+			_, actual, err := cs.CompareAndSet(bg, old, new)
+			for err != nil && (retry ||
+				errors.Is(err, cas.ErrUnknownOutcome)) {
+				_, actual, err = cs.CompareAndSet(bg, old, new)
+			}
 			if err != nil {
 				t.Error("CompareAndSet: " + err.Error())
 			}
+			old = actual
 		}
 		//println("tester", i, j, "done")
 		wg.Done()
@@ -144,7 +203,7 @@ func Stores(t *testing.T, nthreads, naccesses int, store ...cas.Store) {
 	for j := 0; j < nthreads; j++ {
 		for i := range store {
 			wg.Add(1)
-			go tester(i, j)
+			go tester(i, j, check(store[i]))
 		}
 	}
 
@@ -1,7 +1,9 @@
 package test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/dedis/tlc/go/lib/cas"
 )
@@ -10,3 +12,87 @@ import (
 func TestRegister(t *testing.T) {
 	Stores(t, 100, 100000, &cas.Register{})
 }
+
+// Test the linearizability checker with the in-memory Register.
+func TestLinearRegister(t *testing.T) {
+	LinearStores(t, 10, 10000, &cas.Register{})
+}
+
+// A Register whose accesses take a moment and honor cancellation,
+// sometimes after taking effect.
+type slowRegister struct {
+	cas.Register
+}
+
+func (r *slowRegister) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	version, actual, err = r.Register.CompareAndSet(ctx, old, new)
+	select {
+	case <-time.After(10 * time.Microsecond):
+		return version, actual, err
+	case <-ctx.Done():
+		return 0, "", ctx.Err()
+	}
+}
+
+// Test that retried accesses to correct Stores remain linearizable
+// despite injected timeouts and cancellations.
+func TestFaultyStores(t *testing.T) {
+	FaultyStores(t, Faults{Timeout: 0.2}, 10, 1000, &cas.Register{})
+	r := &slowRegister{}
+	FaultyStores(t, Faults{Cancel: 0.5, Delay: 20 * time.Microsecond},
+		4, 500, r, r)
+}
+
+// Test the Tagged wrapper's idempotent operations.
+func TestTagged(t *testing.T) {
+	Stores(t, 10, 10000, &cas.Tagged{Store: &cas.Register{}})
+
+	ctx := context.Background()
+	reg := &cas.Register{}
+	a := &cas.Tagged{Store: reg, Keep: 2}
+	if _, val, err := a.CompareAndSetOp(ctx, "op1", "", "x"); val != "x" ||
+		err != nil {
+		t.Fatalf("op1 yielded %q %v", val, err)
+	}
+	if _, val, _ := a.CompareAndSet(ctx, "x", ""); val != "" {
+		t.Fatalf("reset yielded %q", val)
+	}
+
+	// A restarted client retrying op1 must not apply it again.
+	b := &cas.Tagged{Store: reg, Keep: 2}
+	if _, val, err := b.CompareAndSetOp(ctx, "op1", "", "x"); val != "" ||
+		err != nil {
+		t.Errorf("retried op1 yielded %q %v", val, err)
+	}
+	if ok, err := b.Committed(ctx, "op1"); !ok || err != nil {
+		t.Errorf("op1 committed %v %v", ok, err)
+	}
+	if ok, err := b.Committed(ctx, "op2"); ok || err != nil {
+		t.Errorf("op2 committed %v %v", ok, err)
+	}
+
+	// A failed operation is not committed,
+	// and only the most recent operation IDs are retained.
+	if _, val, _ := b.CompareAndSetOp(ctx, "op2", "y", "z"); val != "" {
+		t.Errorf("op2 yielded %q", val)
+	}
+	for _, op := range []string{"op2", "op3", "op4"} {
+		if ok, _ := b.Committed(ctx, op); ok {
+			t.Errorf("%v committed before it ran", op)
+		}
+		if _, _, err := b.CompareAndSetOp(ctx, op, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for op, want := range map[string]bool{"op1": false, "op2": false,
+		"op3": true, "op4": true} {
+		if ok, _ := a.Committed(ctx, op); ok != want {
+			t.Errorf("%v committed %v", op, ok)
+		}
+	}
+	if _, val, _ := reg.CompareAndSet(ctx, "", ""); val == "" {
+		t.Errorf("underlying store lacks operation IDs")
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// ErrTimeout is the error a Faulty wrapper returns to simulate a timeout.
+var ErrTimeout = errors.New("injected timeout")
+
+// Faults configures the faults a Faulty wrapper injects into a Store.
+// Each fault probability is the chance that a given CompareAndSet
+// suffers that fault, and the probabilities should sum to at most 1.
+//
+// A Timeout fails the operation with ErrTimeout,
+// either before or after it takes effect on the underlying Store.
+// A Duplicate response applies the operation to the underlying Store
+// but returns a duplicate of an earlier response instead of its own.
+// A Stale read skips the operation and returns an earlier response.
+// A Cancel fault cancels the operation's context
+// after a random delay of up to Delay while the underlying Store performs it,
+// returning whatever the Store returns.
+//
+// A client can tolerate timeouts and cancellations by retrying,
+// but duplicate responses and stale reads violate linearizability
+// and should be caught by a Linear checker.
+//
+type Faults struct {
+	Timeout   float64 // probability of timing out
+	Duplicate float64 // probability of a duplicated response
+	Stale     float64 // probability of a stale read
+	Cancel    float64 // probability of cancelling the operation
+
+	Delay time.Duration // maximum delay before cancelling an operation
+	Seed  int64         // seed for reproducible fault injection
+}
+
+// Faulty wraps the provided CAS store with a fault injector
+// that simulates the faults configured in f.
+// A Faulty wrapper may be shared across goroutines
+// if the underlying Store may be.
+//
+func Faulty(store cas.Store, f Faults) cas.Store {
+	return &faultyStore{s: store, f: f, r: rand.New(rand.NewSource(f.Seed))}
+}
+
+type faultyStore struct {
+	s cas.Store // Underlying compare-and-set Store
+	f Faults    // Fault probabilities
+
+	mut   sync.Mutex         // Protects the fields below
+	r     *rand.Rand         // Source of randomness for fault injection
+	past  [faultyPast]result // Recent responses to return again
+	npast int                // Number of responses recorded in past
+}
+
+// Number of recent responses a Faulty wrapper remembers.
+const faultyPast = 8
+
+// A response a Faulty wrapper may return again.
+type result struct {
+	ver int64
+	val string
+}
+
+func (fs *faultyStore) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	// Pick the fault to inject, if any, and an earlier response.
+	fs.mut.Lock()
+	p, late := fs.r.Float64(), fs.r.Intn(2) == 0
+	var delay time.Duration
+	if fs.f.Delay > 0 {
+		delay = time.Duration(fs.r.Int63n(int64(fs.f.Delay) + 1))
+	}
+	var r result
+	if fs.npast > 0 {
+		n := fs.npast
+		if n > faultyPast {
+			n = faultyPast
+		}
+		r = fs.past[fs.r.Intn(n)]
+	}
+	fs.mut.Unlock()
+
+	f := fs.f
+	switch {
+	case p < f.Timeout:
+		if late {
+			fs.s.CompareAndSet(ctx, old, new)
+		}
+		return 0, "", ErrTimeout
+
+	case p < f.Timeout+f.Duplicate:
+		version, actual, err = fs.s.CompareAndSet(ctx, old, new)
+		if err != nil {
+			return 0, "", err
+		}
+		fs.record(version, actual)
+		return r.ver, r.val, nil
+
+	case p < f.Timeout+f.Duplicate+f.Stale:
+		return r.ver, r.val, nil
+
+	case p < f.Timeout+f.Duplicate+f.Stale+f.Cancel:
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, delay)
+		defer cancel()
+	}
+
+	version, actual, err = fs.s.CompareAndSet(ctx, old, new)
+	if err == nil {
+		fs.record(version, actual)
+	}
+	return
+}
+
+// Remember a response to return later as a duplicate or stale response.
+func (fs *faultyStore) record(version int64, actual string) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	fs.past[fs.npast%faultyPast] = result{version, actual}
+	fs.npast++
+}
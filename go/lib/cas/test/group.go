@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// GroupConfig describes the parameters of one consensus group test case.
+type GroupConfig struct {
+	Faulty   int // number of faulty members to tolerate, -1 for default
+	Nodes    int // number of member Stores in the group
+	Clients  int // number of clients accessing the group concurrently
+	Threads  int // number of goroutines per client
+	Accesses int // number of CAS operations per goroutine
+}
+
+// Members creates the persistent state of nnode consensus group members
+// for one test case, and returns a function that each client calls
+// to obtain its own set of Store interfaces to those members.
+type Members func(t *testing.T, nnode int) func() []cas.Store
+
+// Start starts a client of a consensus group comprised of members,
+// tolerating up to faulty failed members,
+// which runs until ctx is cancelled.
+// If the client has a Close(context.Context) error method,
+// such as a qscas.Group's, Groups calls it to await the client's shutdown.
+type Start func(ctx context.Context, members []cas.Store, faulty int) cas.Store
+
+// Registers is a Members function for consensus groups
+// whose members are trivial in-memory CAS registers,
+// which all clients share.
+func Registers(t *testing.T, nnode int) func() []cas.Store {
+	members := make([]cas.Store, nnode)
+	for i := range members {
+		members[i] = &cas.Register{}
+	}
+	return func() []cas.Store { return members }
+}
+
+// Groups torture-tests consensus groups of member Stores
+// that members creates, accessed through clients that start creates,
+// with each group configuration in configs.
+// Each test case runs Stores across all the clients of the group.
+//
+func Groups(t *testing.T, members Members, start Start,
+	configs ...GroupConfig) {
+
+	for _, c := range configs {
+		desc := fmt.Sprintf("F=%v,N=%v,Clients=%v,Threads=%v,Accesses=%v",
+			c.Faulty, c.Nodes, c.Clients, c.Threads, c.Accesses)
+		t.Run(desc, func(t *testing.T) {
+
+			// Create a cancelable context for the test run
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Create a consensus group Store for each client
+			client := members(t, c.Nodes)
+			clients := make([]cas.Store, c.Clients)
+			for i := range clients {
+				clients[i] = start(ctx, client(), c.Faulty)
+			}
+
+			// Run a standard torture-test across all the clients
+			Stores(t, c.Threads, c.Accesses, clients...)
+
+			// Shut down clients that can report when they have,
+			// before the test case cleans up their members' state.
+			cancel()
+			for _, cl := range clients {
+				if cl, ok := cl.(interface {
+					Close(context.Context) error
+				}); ok {
+					cl.Close(context.Background())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Linear records the CompareAndSet operations that any number of clients
+// perform on a shared cas.Store, and checks that the observed history
+// is linearizable, i.e., consistent with some sequential execution
+// in which each operation takes effect atomically
+// at some point between its invocation and its response.
+//
+// Besides the per-version consistency that History checks, Linear checks:
+//
+//	- real-time order: an operation invoked after another completed
+//	  never observes an older version than the completed one did;
+//	- write justification: a successful CompareAndSet from old to new
+//	  immediately follows a state holding old, when old was observed;
+//	- no phantom writes: the new value of a CompareAndSet that
+//	  reported failure without error is never observed as the state,
+//	  unless some CompareAndSet with the same new value returned an error.
+//
+// Checking write justification and phantom writes assumes
+// that clients propose unique new values, as LinearStores does.
+//
+type Linear struct {
+	History // Per-version consistency history
+
+	mut    sync.Mutex      // protects the fields below
+	done   int64           // highest version any completed op observed
+	writes []linearWrite   // successful writes
+	failed []string        // new values of failed writes
+	maybe  map[string]bool // new values of writes that returned errors
+}
+
+// A successful write recorded for checking write justification.
+type linearWrite struct {
+	ver int64  // version at which new value was written
+	old string // value the write replaced
+	new string // value the write wrote
+}
+
+// Linearizable wraps a cas.Store with a recorder
+// that logs all operations into l and checks real-time order as they occur,
+// reporting violations via testing context t.
+// Each client may use its own wrapper, but all must share the same Linear.
+// Call l.Check after all operations complete to finish checking.
+//
+func Linearizable(t testing.TB, l *Linear, store cas.Store) cas.Store {
+	return &linearStore{t, l, store}
+}
+
+type linearStore struct {
+	t testing.TB // Testing context
+	l *Linear    // Shared history being checked
+	s cas.Store  // Underlying compare-and-set Store
+}
+
+func (ls *linearStore) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	inv := ls.l.invoke()
+	version, actual, err = ls.s.CompareAndSet(ctx, old, new)
+	ls.l.complete(ls.t, inv, old, new, version, actual, err != nil)
+	return
+}
+
+// Record the invocation of an operation,
+// returning the highest version completed before it.
+func (l *Linear) invoke() int64 {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.done
+}
+
+// Record the completion of an operation invoked after version inv completed,
+// which either returned an error or version and actual.
+func (l *Linear) complete(t testing.TB, inv int64, old, new string,
+	version int64, actual string, failed bool) {
+
+	if failed {
+		// The operation may or may not have taken effect.
+		l.mut.Lock()
+		if l.maybe == nil {
+			l.maybe = make(map[string]bool)
+		}
+		l.maybe[new] = true
+		l.mut.Unlock()
+		return
+	}
+	l.Observe(t, version, actual)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if version < inv {
+		t.Errorf("\nStale read:\n ver %v value %q\n"+
+			" after completed ver %v\n", version, actual, inv)
+	}
+	if version > l.done {
+		l.done = version
+	}
+	if new != old {
+		if actual == new {
+			l.writes = append(l.writes,
+				linearWrite{version, old, new})
+		} else {
+			l.failed = append(l.failed, new)
+		}
+	}
+}
+
+// Op is a CompareAndSet operation recorded in a history,
+// for checking via Linear.Replay,
+// e.g., by clients running in separate processes.
+// Invocation and completion times must be comparable across all clients.
+// An operation that returned an error, or whose client crashed
+// before it completed, has Failed set and no completion results.
+//
+type Op struct {
+	Invoke   int64  // Time of invocation in Unix nanoseconds
+	Complete int64  // Time of completion in Unix nanoseconds
+	Old, New string // Arguments to CompareAndSet
+	Version  int64  // Version CompareAndSet returned
+	Actual   string // Value CompareAndSet returned
+	Failed   bool   // Operation failed or never completed
+}
+
+// Replay records the operations in ops, as if they had been performed
+// via Linearizable wrappers, reporting violations via testing context t.
+// Call l.Check after replaying all operations to finish checking.
+func (l *Linear) Replay(t testing.TB, ops []Op) {
+
+	// Replay invocations and completions in the order they occurred,
+	// completions first when they coincide.
+	type event struct {
+		time int64
+		op   int
+		inv  bool
+	}
+	ev := make([]event, 0, 2*len(ops))
+	for i, op := range ops {
+		ev = append(ev, event{op.Invoke, i, true})
+		if !op.Failed {
+			ev = append(ev, event{op.Complete, i, false})
+		}
+	}
+	sort.SliceStable(ev, func(i, j int) bool {
+		if ev[i].time != ev[j].time {
+			return ev[i].time < ev[j].time
+		}
+		return !ev[i].inv && ev[j].inv
+	})
+	inv := make([]int64, len(ops))
+	for _, e := range ev {
+		op := &ops[e.op]
+		switch {
+		case e.inv && op.Failed:
+			l.complete(t, 0, op.Old, op.New, 0, "", true)
+		case e.inv:
+			inv[e.op] = l.invoke()
+		default:
+			l.complete(t, inv[e.op], op.Old, op.New,
+				op.Version, op.Actual, false)
+		}
+	}
+}
+
+// Check completes the linearizability check of all recorded operations,
+// reporting any violations via testing context t.
+func (l *Linear) Check(t testing.TB) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.History.mut.Lock()
+	defer l.History.mut.Unlock()
+
+	// Index the observed states by version and by value.
+	type state struct {
+		ver int64
+		val string
+	}
+	states := make([]state, 0, len(l.hist))
+	for ver, val := range l.hist {
+		states = append(states, state{ver, val})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ver < states[j].ver
+	})
+	seen := make(map[string]int64, len(states))
+	for _, s := range states {
+		seen[s.val] = s.ver
+	}
+
+	// No failed write's value may appear as the state.
+	for _, new := range l.failed {
+		if v, ok := seen[new]; ok && !l.maybe[new] {
+			t.Errorf("\nPhantom write:\n value %q observed at ver %v"+
+				"\n but its CompareAndSet failed\n", new, v)
+		}
+	}
+
+	// Each successful write must immediately follow its old value,
+	// among the versions observed, if its old value was observed at all.
+	// Since a Store may advance the version without changing the value,
+	// the write may have been observed at earlier versions
+	// than the one it reported.
+	for _, w := range l.writes {
+		if _, ok := seen[w.old]; !ok {
+			continue // can't check writes from unobserved states
+		}
+		i := sort.Search(len(states), func(i int) bool {
+			return states[i].ver >= w.ver
+		})
+		for i > 0 && states[i-1].val == w.new {
+			i--
+		}
+		if i == 0 || states[i-1].val != w.old {
+			prev := "(none)"
+			if i > 0 {
+				prev = states[i-1].val
+			}
+			t.Errorf("\nUnjustified write:\n ver %v replaced %q\n"+
+				" but preceding state was %q\n", w.ver, w.old, prev)
+		}
+	}
+}
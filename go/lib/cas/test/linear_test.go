@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// errCounter counts the errors reported to it instead of failing a test.
+type errCounter struct {
+	testing.TB
+	mut sync.Mutex
+	n   int
+}
+
+func (c *errCounter) Errorf(format string, args ...interface{}) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.n++
+}
+
+// Run nclients goroutines each performing naccesses operations
+// on a Faulty wrapper around a shared Register,
+// retrying operations that fail, and return the Linear checker's errors.
+func testFaulty(t *testing.T, f Faults, nclients, naccesses int) int {
+	bg := context.Background()
+	reg := &cas.Register{}
+	l := &Linear{}
+	c := &errCounter{TB: t}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < nclients; i++ {
+		f.Seed = int64(i)
+		ls := Linearizable(c, l, Faulty(reg, f))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old := ""
+			for k := 0; k < naccesses; k++ {
+				new := fmt.Sprintf("client %v access %v", i, k)
+				for {
+					_, actual, err := ls.CompareAndSet(bg, old,
+						new)
+					if err == nil {
+						old = actual
+						break
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	l.Check(c)
+	return c.n
+}
+
+// Test that the Linear checker tolerates injected timeouts
+// but catches injected duplicate responses and stale reads.
+func TestFaulty(t *testing.T) {
+	if n := testFaulty(t, Faults{}, 10, 1000); n != 0 {
+		t.Errorf("%v errors without faults", n)
+	}
+	if n := testFaulty(t, Faults{Timeout: 0.2}, 10, 1000); n != 0 {
+		t.Errorf("%v errors with only timeouts", n)
+	}
+	if n := testFaulty(t, Faults{Duplicate: 0.05}, 10, 1000); n == 0 {
+		t.Errorf("duplicate responses not detected")
+	}
+	if n := testFaulty(t, Faults{Stale: 0.05}, 10, 1000); n == 0 {
+		t.Errorf("stale reads not detected")
+	}
+}
+
+// Test that replaying a recorded history checks it as Linearizable does.
+func TestReplay(t *testing.T) {
+	bg := context.Background()
+	reg := &cas.Register{}
+	var mut sync.Mutex
+	var ops []Op
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old := ""
+			for k := 0; k < 100; k++ {
+				new := fmt.Sprintf("client %v access %v", i, k)
+				op := Op{Invoke: time.Now().UnixNano(),
+					Old: old, New: new}
+				op.Version, op.Actual, _ = reg.CompareAndSet(bg,
+					old, new)
+				op.Complete = time.Now().UnixNano()
+				old = op.Actual
+
+				mut.Lock()
+				ops = append(ops, op)
+				mut.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Replay the history with a lost response and a stale read added.
+	check := func(ops []Op) int {
+		l := &Linear{}
+		c := &errCounter{TB: t}
+		l.Replay(c, ops)
+		l.Check(c)
+		return c.n
+	}
+	last := ops[len(ops)-1]
+	lost := Op{Invoke: last.Invoke, Old: last.Actual, New: "lost",
+		Failed: true}
+	if n := check(append(ops, lost)); n != 0 {
+		t.Errorf("%v errors in valid history", n)
+	}
+	stale := ops[0]
+	stale.Invoke, stale.Complete = last.Complete+1, last.Complete+2
+	if n := check(append(ops, stale)); n == 0 {
+		t.Errorf("stale read not detected")
+	}
+}
@@ -0,0 +1,318 @@
+// Package cbor implements a minimal subset of the Concise Binary Object
+// Representation (CBOR, RFC 8949) sufficient to represent JSON data:
+// null, booleans, integers, floating-point numbers, text strings,
+// arrays, and maps with text string keys.
+//
+// Marshal and Unmarshal convert between CBOR and Go values via their
+// JSON representations, so they honor the same struct field tags
+// and Marshaler interfaces that encoding/json does.
+// Marshal produces deterministic output, with map keys sorted.
+//
+package cbor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Major types of CBOR data items
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Simple values and floating-point additional information
+const (
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+	simpleHalf  = 25 // IEEE 754 half-precision float follows
+	simpleFloat = 26 // single-precision float follows
+	simpleDbl   = 27 // double-precision float follows
+)
+
+// Tags marking byte strings as unsigned or negative bignums,
+// which Unmarshal rejects rather than decoding as byte strings.
+const (
+	tagPosBignum = 2
+	tagNegBignum = 3
+)
+
+// Maximum nesting depth that Unmarshal accepts,
+// to bound the recursion that malicious input can cause.
+const maxDepth = 1000
+
+// ErrMalformed is returned by Unmarshal for input that is not valid CBOR
+// within the subset this package supports.
+var ErrMalformed = errors.New("cbor: malformed or unsupported data item")
+
+// Marshal returns the CBOR encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+
+	// Reduce v to generic JSON data first.
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var g interface{}
+	if err := d.Decode(&g); err != nil {
+		return nil, err
+	}
+	return encode(nil, g)
+}
+
+// Unmarshal decodes the CBOR-encoded data b, which must consist of
+// exactly one data item, and stores the result in the value pointed to by v.
+func Unmarshal(b []byte, v interface{}) error {
+	g, rest, err := decode(b, 0)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrMalformed
+	}
+
+	// Convert the generic data to v via its JSON representation.
+	j, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+// Append the CBOR encoding of generic JSON data g to b.
+func encode(b []byte, g interface{}) ([]byte, error) {
+	switch g := g.(type) {
+	case nil:
+		return append(b, majorSimple<<5|simpleNull), nil
+	case bool:
+		if g {
+			return append(b, majorSimple<<5|simpleTrue), nil
+		}
+		return append(b, majorSimple<<5|simpleFalse), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(g), 10, 64); err == nil {
+			if i < 0 {
+				return head(b, majorNegInt, uint64(-(i + 1))), nil
+			}
+			return head(b, majorUint, uint64(i)), nil
+		}
+		if u, err := strconv.ParseUint(string(g), 10, 64); err == nil {
+			return head(b, majorUint, u), nil
+		}
+		f, err := g.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendUint(append(b, majorSimple<<5|simpleDbl),
+			math.Float64bits(f), 8), nil
+	case string:
+		b = head(b, majorText, uint64(len(g)))
+		return append(b, g...), nil
+	case []interface{}:
+		b = head(b, majorArray, uint64(len(g)))
+		for _, e := range g {
+			var err error
+			if b, err = encode(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(g))
+		for k := range g {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = head(b, majorMap, uint64(len(g)))
+		for _, k := range keys {
+			b = head(b, majorText, uint64(len(k)))
+			b = append(b, k...)
+			var err error
+			if b, err = encode(b, g[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", g)
+	}
+}
+
+// Append the head of a data item with major type major and argument n to b,
+// in the shortest form.
+func head(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint(append(b, major|25), n, 2)
+	case n <= math.MaxUint32:
+		return appendUint(append(b, major|26), n, 4)
+	default:
+		return appendUint(append(b, major|27), n, 8)
+	}
+}
+
+// Append the low size bytes of n to b in big-endian order.
+func appendUint(b []byte, n uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(n>>(8*uint(i))))
+	}
+	return b
+}
+
+// Decode one data item from b into generic JSON data,
+// returning the rest of b following it.
+func decode(b []byte, depth int) (g interface{}, rest []byte, err error) {
+	if depth > maxDepth || len(b) == 0 {
+		return nil, nil, ErrMalformed
+	}
+	major, info := b[0]>>5, b[0]&31
+
+	// Simple values and floats carry no integer argument.
+	if major == majorSimple {
+		return decodeSimple(b, info)
+	}
+	n, b, err := argument(b, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case majorUint:
+		return n, b, nil
+	case majorNegInt:
+		if n > math.MaxInt64 {
+			return nil, nil, ErrMalformed // beyond int64 range
+		}
+		return -1 - int64(n), b, nil
+	case majorBytes, majorText:
+		if uint64(len(b)) < n {
+			return nil, nil, ErrMalformed
+		}
+		if major == majorBytes { // represent as in encoding/json
+			return base64.StdEncoding.EncodeToString(b[:n]), b[n:], nil
+		}
+		return string(b[:n]), b[n:], nil
+	case majorArray:
+		if uint64(len(b)) < n { // each item takes at least a byte
+			return nil, nil, ErrMalformed
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, b, nil
+	case majorMap:
+		if uint64(len(b))/2 < n { // each entry takes at least two
+			return nil, nil, ErrMalformed
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			if k, b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, nil, ErrMalformed // only text keys
+			}
+			if v, b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[ks] = v
+		}
+		return m, b, nil
+	case majorTag: // ignore tags, keeping the tagged item
+		if n == tagPosBignum || n == tagNegBignum {
+			return nil, nil, ErrMalformed
+		}
+		return decode(b, depth+1)
+	}
+	return nil, nil, ErrMalformed
+}
+
+// Decode the integer argument of a data item's head.
+func argument(b []byte, info byte) (n uint64, rest []byte, err error) {
+	switch {
+	case info < 24:
+		return uint64(info), b[1:], nil
+	case info == 24 && len(b) >= 2:
+		return uint64(b[1]), b[2:], nil
+	case info == 25 && len(b) >= 3:
+		return uint64(binary.BigEndian.Uint16(b[1:])), b[3:], nil
+	case info == 26 && len(b) >= 5:
+		return uint64(binary.BigEndian.Uint32(b[1:])), b[5:], nil
+	case info == 27 && len(b) >= 9:
+		return binary.BigEndian.Uint64(b[1:]), b[9:], nil
+	}
+	return 0, nil, ErrMalformed // truncated, or indefinite length
+}
+
+// Decode a simple value or floating-point number.
+func decodeSimple(b []byte, info byte) (interface{}, []byte, error) {
+	var f float64
+	switch {
+	case info == simpleFalse:
+		return false, b[1:], nil
+	case info == simpleTrue:
+		return true, b[1:], nil
+	case info == simpleNull:
+		return nil, b[1:], nil
+	case info == simpleHalf && len(b) >= 3:
+		f, b = half(binary.BigEndian.Uint16(b[1:])), b[3:]
+	case info == simpleFloat && len(b) >= 5:
+		f = float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:])))
+		b = b[5:]
+	case info == simpleDbl && len(b) >= 9:
+		f, b = math.Float64frombits(binary.BigEndian.Uint64(b[1:])), b[9:]
+	default:
+		return nil, nil, ErrMalformed
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, nil, ErrMalformed // not representable in JSON
+	}
+	return f, b, nil
+}
+
+// Convert an IEEE 754 half-precision float to a float64.
+func half(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
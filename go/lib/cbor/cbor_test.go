@@ -0,0 +1,270 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type record struct {
+	Name  string
+	Count int64
+	Ratio float64
+	Tags  []string          `json:",omitempty"`
+	Attrs map[string]string `json:",omitempty"`
+	Next  *record           `json:",omitempty"`
+}
+
+// Test encodings against the examples in RFC 8949 Appendix A
+// that Marshal can produce: Marshal encodes all non-integers
+// in double precision, rather than the shortest exact form.
+func TestEncode(t *testing.T) {
+	var a25 []int
+	for i := 1; i <= 25; i++ {
+		a25 = append(a25, i)
+	}
+	for _, c := range []struct {
+		v   interface{}
+		hex string
+	}{
+		{0, "00"},
+		{1, "01"},
+		{10, "0a"},
+		{23, "17"},
+		{24, "1818"},
+		{25, "1819"},
+		{100, "1864"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{1000000000000, "1b000000e8d4a51000"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-10, "29"},
+		{-100, "3863"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{1.0e+300, "fb7e37e43c8800759c"},
+		{-4.1, "fbc010666666666666"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"", "60"},
+		{"a", "6161"},
+		{"IETF", "6449455446"},
+		{"\"\\", "62225c"},
+		{"\u00fc", "62c3bc"},
+		{"\u6c34", "63e6b0b4"},
+		{"\U00010151", "64f0908591"},
+		{[]int{}, "80"},
+		{[]int{1, 2, 3}, "83010203"},
+		{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{a25, "98190102030405060708090a0b0c0d0e0f101112131415161718181819"},
+		{map[string]int{}, "a0"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}},
+			"a26161016162820203"},
+		{[]interface{}{"a", map[string]string{"b": "c"}},
+			"826161a161626163"},
+		{map[string]string{"a": "A", "b": "B", "c": "C", "d": "D",
+			"e": "E"}, "a56161614161626142616361436164614461656145"},
+	} {
+		b, err := Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != c.hex {
+			t.Errorf("Marshal(%v) = %x, want %s", c.v, b, c.hex)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := record{Name: "x", Count: -42, Ratio: 0.5,
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]string{"z": "1", "y": "2"},
+		Next:  &record{Name: "y", Count: 1 << 40}}
+	b, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 record
+	if err := Unmarshal(b, &r2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, r2) {
+		t.Errorf("round trip: got %+v, want %+v", r2, r)
+	}
+
+	// Encodings are deterministic.
+	b2, err := Marshal(r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Errorf("re-encoding differs: %x vs %x", b, b2)
+	}
+}
+
+// Test decoding the examples in RFC 8949 Appendix A,
+// comparing the JSON representation of each decoded item.
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		hex  string
+		json string
+	}{
+		{"00", `0`},
+		{"01", `1`},
+		{"0a", `10`},
+		{"17", `23`},
+		{"1818", `24`},
+		{"1819", `25`},
+		{"1864", `100`},
+		{"1903e8", `1000`},
+		{"1a000f4240", `1000000`},
+		{"1b000000e8d4a51000", `1000000000000`},
+		{"1bffffffffffffffff", `18446744073709551615`},
+		{"20", `-1`},
+		{"29", `-10`},
+		{"3863", `-100`},
+		{"3903e7", `-1000`},
+		{"f90000", `0`},
+		{"f98000", `-0`},
+		{"f93c00", `1`},
+		{"fb3ff199999999999a", `1.1`},
+		{"f93e00", `1.5`},
+		{"f97bff", `65504`},
+		{"fa47c35000", `100000`},
+		{"fa7f7fffff", `3.4028234663852886e+38`},
+		{"fb7e37e43c8800759c", `1e+300`},
+		{"f90001", `5.960464477539063e-8`},
+		{"f90400", `0.00006103515625`},
+		{"f9c400", `-4`},
+		{"fbc010666666666666", `-4.1`},
+		{"f4", `false`},
+		{"f5", `true`},
+		{"f6", `null`},
+		{"c074323031332d30332d32315432303a30343a30305a",
+			`"2013-03-21T20:04:00Z"`},
+		{"c11a514b67b0", `1363896240`},
+		{"c1fb41d452d9ec200000", `1363896240.5`},
+		{"d74401020304", `"AQIDBA=="`},
+		{"d818456449455446", `"ZElFVEY="`},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d",
+			`"http://www.example.com"`},
+		{"40", `""`},
+		{"4401020304", `"AQIDBA=="`},
+		{"60", `""`},
+		{"6161", `"a"`},
+		{"6449455446", `"IETF"`},
+		{"62225c", `"\"\\"`},
+		{"62c3bc", `"\u00fc"`},
+		{"63e6b0b4", `"\u6c34"`},
+		{"64f0908591", `"\ud800\udd51"`},
+		{"80", `[]`},
+		{"83010203", `[1,2,3]`},
+		{"8301820203820405", `[1,[2,3],[4,5]]`},
+		{"98190102030405060708090a0b0c0d0e0f101112131415161718181819",
+			`[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,` +
+				`21,22,23,24,25]`},
+		{"a0", `{}`},
+		{"a26161016162820203", `{"a":1,"b":[2,3]}`},
+		{"826161a161626163", `["a",{"b":"c"}]`},
+		{"a56161614161626142616361436164614461656145",
+			`{"a":"A","b":"B","c":"C","d":"D","e":"E"}`},
+	} {
+		b, _ := hex.DecodeString(c.hex)
+		var j json.RawMessage
+		if err := Unmarshal(b, &j); err != nil {
+			t.Errorf("Unmarshal(%s): %v", c.hex, err)
+		} else if !reflect.DeepEqual(exact(t, j), exact(t, []byte(c.json))) {
+			t.Errorf("Unmarshal(%s) = %s, want %s", c.hex, j, c.json)
+		}
+	}
+
+	// The examples outside the supported subset fail cleanly:
+	// bignums, integers beyond int64, infinities and NaNs,
+	// undefined and other simple values, maps with non-text keys,
+	// and indefinite-length items.
+	for _, h := range []string{
+		"c249010000000000000000", "3bffffffffffffffff",
+		"c349010000000000000000",
+		"f97c00", "f97e00", "f9fc00",
+		"fa7f800000", "fa7fc00000", "faff800000",
+		"fb7ff0000000000000", "fb7ff8000000000000", "fbfff0000000000000",
+		"f7", "f0", "f8ff", "a201020304",
+		"5f42010243030405ff", "7f657374726561646d696e67ff", "9fff",
+		"9f018202039f0405ffff", "9f01820203820405ff",
+		"83018202039f0405ff", "83019f0203ff820405",
+		"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff",
+		"bf61610161629f0203ffff", "826161bf61626163ff",
+		"bf6346756ef563416d7421ff",
+	} {
+		b, _ := hex.DecodeString(h)
+		var v interface{}
+		if err := Unmarshal(b, &v); err != ErrMalformed {
+			t.Errorf("Unmarshal(%s) = %v, want ErrMalformed", h, err)
+		}
+	}
+
+	// Malformed and truncated items fail cleanly.
+	for _, h := range []string{"", "18", "62ff", "9bffffffffffffffff",
+		"bbffffffffffffffff", "a10101", "0000"} {
+		b, _ := hex.DecodeString(h)
+		var v interface{}
+		if err := Unmarshal(b, &v); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", h)
+		}
+	}
+}
+
+// Decode JSON text j into generic data, keeping numbers exact.
+func exact(t *testing.T, j []byte) interface{} {
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// Test encoding and decoding individual data items.
+func TestItems(t *testing.T) {
+	b := AppendTag(nil, SelfDescribe)
+	b = AppendMap(b, 2)
+	b = AppendText(b, "a")
+	b = AppendBytes(b, []byte{1, 2})
+	b = AppendText(b, "b")
+	b = AppendUint(b, 1000)
+	if hex.EncodeToString(b) != "d9d9f7a261614201026162"+"1903e8" {
+		t.Errorf("encoded %x", b)
+	}
+
+	tag, b, err := ReadTag(b)
+	if err != nil || tag != SelfDescribe {
+		t.Fatalf("ReadTag: %v %v", tag, err)
+	}
+	n, b, err := ReadMap(b)
+	if err != nil || n != 2 {
+		t.Fatalf("ReadMap: %v %v", n, err)
+	}
+	if b, err = Skip(b); err != nil { // key "a"
+		t.Fatal(err)
+	}
+	p, b, err := ReadBytes(b)
+	if err != nil || !bytes.Equal(p, []byte{1, 2}) {
+		t.Fatalf("ReadBytes: %x %v", p, err)
+	}
+	s, b, err := ReadText(b)
+	if err != nil || s != "b" {
+		t.Fatalf("ReadText: %q %v", s, err)
+	}
+	u, b, err := ReadUint(b)
+	if err != nil || u != 1000 || len(b) != 0 {
+		t.Fatalf("ReadUint: %v %v %x", u, err, b)
+	}
+	if _, _, err := ReadText([]byte{0x42, 1}); err != ErrMalformed {
+		t.Errorf("ReadText of byte string: %v", err)
+	}
+}
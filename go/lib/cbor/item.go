@@ -0,0 +1,97 @@
+package cbor
+
+// The functions below encode and decode individual CBOR data items
+// in fixed binary formats, which may need byte strings or tags
+// that Marshal and Unmarshal cannot represent via JSON.
+
+// SelfDescribe is the CBOR self-describe tag (RFC 8949 section 3.4.6),
+// which may prefix a CBOR data item to mark it as such.
+const SelfDescribe = 55799
+
+// AppendUint appends the encoding of unsigned integer n to b.
+func AppendUint(b []byte, n uint64) []byte {
+	return head(b, majorUint, n)
+}
+
+// AppendBytes appends the encoding of byte string p to b.
+func AppendBytes(b, p []byte) []byte {
+	return append(head(b, majorBytes, uint64(len(p))), p...)
+}
+
+// AppendText appends the encoding of text string s to b.
+func AppendText(b []byte, s string) []byte {
+	return append(head(b, majorText, uint64(len(s))), s...)
+}
+
+// AppendMap appends the head of a map with n entries to b,
+// which the caller must follow with the n keys and values.
+func AppendMap(b []byte, n int) []byte {
+	return head(b, majorMap, uint64(n))
+}
+
+// AppendTag appends tag to b,
+// which the caller must follow with the tagged data item.
+func AppendTag(b []byte, tag uint64) []byte {
+	return head(b, majorTag, tag)
+}
+
+// ReadUint decodes an unsigned integer from the start of b,
+// returning it and the rest of b following it.
+func ReadUint(b []byte) (n uint64, rest []byte, err error) {
+	return readHead(b, majorUint)
+}
+
+// ReadBytes decodes a byte string from the start of b,
+// returning it and the rest of b following it.
+// The returned byte string shares storage with b.
+func ReadBytes(b []byte) (p, rest []byte, err error) {
+	n, b, err := readHead(b, majorBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(b)) < n {
+		return nil, nil, ErrMalformed
+	}
+	return b[:n], b[n:], nil
+}
+
+// ReadText decodes a text string from the start of b,
+// returning it and the rest of b following it.
+func ReadText(b []byte) (s string, rest []byte, err error) {
+	n, b, err := readHead(b, majorText)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(b)) < n {
+		return "", nil, ErrMalformed
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+// ReadMap decodes the head of a map from the start of b,
+// returning its number of entries and the rest of b following the head.
+func ReadMap(b []byte) (n uint64, rest []byte, err error) {
+	return readHead(b, majorMap)
+}
+
+// ReadTag decodes a tag from the start of b,
+// returning it and the rest of b following it, starting with the tagged item.
+func ReadTag(b []byte) (tag uint64, rest []byte, err error) {
+	return readHead(b, majorTag)
+}
+
+// Skip skips one complete data item at the start of b,
+// of any type that this package supports,
+// returning the rest of b following it.
+func Skip(b []byte) (rest []byte, err error) {
+	_, rest, err = decode(b, 0)
+	return rest, err
+}
+
+// Decode the head of a data item of major type major.
+func readHead(b []byte, major byte) (n uint64, rest []byte, err error) {
+	if len(b) == 0 || b[0]>>5 != major {
+		return 0, nil, ErrMalformed
+	}
+	return argument(b, b[0]&31)
+}
@@ -0,0 +1,100 @@
+// Package chaos perturbs goroutine scheduling at instrumented points
+// in concurrent code under test,
+// to help flush out latent concurrency bugs
+// that a quiet test machine's regular scheduling would hide,
+// particularly when run with the race detector enabled.
+//
+// Code to be stress-tested calls a hook, typically a Chaos's Point method,
+// at the points where a different interleaving could matter:
+// around calls to stores, message deliveries, and mutex boundaries.
+//
+package chaos
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSleep is the default maximum random sleep at each point.
+const DefaultMaxSleep = 100 * time.Microsecond
+
+// Chaos injects random scheduling jitter at instrumented points.
+// The random choices it makes derive from its seed,
+// so a failing run's seed can be reused to perturb another run
+// the same way, though the Go scheduler remains nondeterministic.
+//
+// A nil *Chaos is valid and does nothing, so that test harnesses
+// can pass one around unconditionally when chaos mode is off.
+//
+type Chaos struct {
+	seed     int64         // seed of our random choices
+	maxSleep time.Duration // maximum random sleep at each point
+
+	mut sync.Mutex // protects rnd
+	rnd *rand.Rand // source of random choices
+}
+
+// New creates a Chaos with the given seed,
+// which sleeps at most maxSleep at each point,
+// or DefaultMaxSleep if maxSleep is zero.
+// If seed is zero, New returns nil, disabling chaos mode.
+// If seed is negative, New chooses a random seed.
+//
+func New(seed int64, maxSleep time.Duration) *Chaos {
+	if seed == 0 {
+		return nil
+	}
+	if seed < 0 {
+		seed = time.Now().UnixNano() & (1<<62 - 1)
+		if seed == 0 {
+			seed = 1
+		}
+	}
+	if maxSleep == 0 {
+		maxSleep = DefaultMaxSleep
+	}
+	return &Chaos{seed: seed, maxSleep: maxSleep,
+		rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed of c's random choices, or zero if c is nil.
+func (c *Chaos) Seed() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.seed
+}
+
+// Point marks an instrumented point in the code under test.
+// At each point, c randomly either does nothing,
+// yields the processor to other goroutines,
+// or sleeps for a random duration up to its maximum.
+//
+func (c *Chaos) Point() {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	choice := c.rnd.Intn(4)
+	sleep := time.Duration(c.rnd.Int63n(int64(c.maxSleep) + 1))
+	c.mut.Unlock()
+
+	switch choice {
+	case 0: // run on undisturbed
+	case 1, 2:
+		runtime.Gosched()
+	case 3:
+		time.Sleep(sleep)
+	}
+}
+
+// Hook returns c's Point method as a hook function
+// for code that accepts an optional one, or nil if c is nil.
+func (c *Chaos) Hook() func() {
+	if c == nil {
+		return nil
+	}
+	return c.Point
+}
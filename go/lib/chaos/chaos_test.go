@@ -0,0 +1,39 @@
+package chaos
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	if New(0, 0) != nil {
+		t.Errorf("zero seed enabled chaos mode")
+	}
+	var c *Chaos
+	c.Point() // a nil Chaos does nothing
+	if c.Seed() != 0 || c.Hook() != nil {
+		t.Errorf("nil Chaos has seed %v", c.Seed())
+	}
+	if New(-1, 0).Seed() <= 0 {
+		t.Errorf("no random seed chosen")
+	}
+
+	// Points are safe to call concurrently, and sleep only briefly.
+	c = New(42, time.Millisecond)
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Hook()()
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d > 100*100*time.Millisecond {
+		t.Errorf("points took %v", d)
+	}
+}
@@ -0,0 +1,244 @@
+// Package checker validates traces of QSC consensus executions
+// against an independent, deliberately unoptimized statement
+// of QSC's decision and commit rules,
+// to catch divergence between the optimized code paths
+// of the implementations in this repository and the abstract algorithm.
+//
+// In each consensus round starting at time-step s,
+// a node chooses the proposal with the highest lottery ticket
+// among the step-s proposals confirmed (threshold witnessed) in its view,
+// and considers the round committed only if that proposal is reconfirmed
+// (its confirmation was in the view of a proposal confirmed at step s+1)
+// and not spoiled (no other step-s proposal in its view at all
+// has a ticket as high).
+// Whatever the views, all nodes must agree on any proposal
+// that any node considers committed.
+//
+// Implementations report each node's outcome of each round to a Checker,
+// either as a View listing the proposals in the node's view,
+// or, for implementations that keep only the best proposals,
+// as a Summary of those.
+//
+package checker
+
+import (
+	"fmt"
+)
+
+// Proposal identifies a proposal and its lottery ticket.
+type Proposal struct {
+	From   int    // Node that made the proposal
+	Ticket uint64 // Proposal's lottery ticket
+}
+
+// View is one node's view of one consensus round at the round's end,
+// together with the decision the node reported.
+type View struct {
+	Node int // Node whose view this is
+	Step int // Time-step at which the round started
+
+	Seen        []Proposal // All step proposals in the node's view
+	Confirmed   []int      // Proposers whose proposals were confirmed
+	Reconfirmed []int      // Proposers whose proposals were reconfirmed
+
+	Choice int  // Proposer of the proposal the node chose
+	Commit bool // Whether the node considered the round committed
+}
+
+// Best is a best proposal record, as kept by implementations
+// that merge views without keeping the complete sets of proposals.
+// A From of -1 denotes a tie between proposals from different nodes.
+// A Ticket of zero denotes that no proposal is known.
+type Best struct {
+	From   int
+	Ticket uint64
+}
+
+// Summary is one node's summary of one consensus round at the round's end,
+// listing only the best proposals of each kind in the node's view.
+type Summary struct {
+	Node int // Node whose summary this is
+	Step int // Time-step at which the round started
+
+	Spoil  Best // Best proposal seen, with ties marked
+	Conf   Best // Best confirmed proposal seen
+	Reconf Best // Best reconfirmed proposal seen
+
+	Commit bool // Whether the node considered the round committed
+}
+
+// Checker validates rounds reported by the nodes of one consensus group.
+// The zero value is ready to use.
+// A Checker is not safe for concurrent use.
+//
+type Checker struct {
+	ticket map[[2]int]uint64    // Ticket of each proposal by proposer, step
+	choice map[int]map[int]bool // Proposers chosen by nodes in each round
+	commit map[int]int          // Proposer committed by some node in a round
+	err    error                // First violation detected
+}
+
+// Propose records the ticket of node's proposal at step.
+// Recording proposals is optional, but lets the Checker verify
+// that the tickets nodes report are the tickets actually proposed.
+func (c *Checker) Propose(node, step int, ticket uint64) error {
+	return c.fail(c.proposal(step, Proposal{node, ticket}))
+}
+
+// View checks a node's view of a round and the decision it reported.
+func (c *Checker) View(v *View) error {
+	return c.fail(c.view(v))
+}
+
+// Summary checks a node's summary of a round and the decision it reported.
+func (c *Checker) Summary(s *Summary) error {
+	return c.fail(c.summary(s))
+}
+
+// Err returns the first violation the Checker detected, or nil if none.
+func (c *Checker) Err() error {
+	return c.err
+}
+
+// Record the first violation detected.
+func (c *Checker) fail(err error) error {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+// Check that a proposal's ticket is consistent with what we know of it.
+func (c *Checker) proposal(step int, p Proposal) error {
+	if c.ticket == nil {
+		c.ticket = make(map[[2]int]uint64)
+	}
+	k := [2]int{p.From, step}
+	if t, ok := c.ticket[k]; ok && t != p.Ticket {
+		return fmt.Errorf("step %v: node %v's proposal has ticket %v, "+
+			"previously %v", step, p.From, p.Ticket, t)
+	}
+	c.ticket[k] = p.Ticket
+	return nil
+}
+
+func (c *Checker) view(v *View) error {
+	where := fmt.Sprintf("node %v round %v", v.Node, v.Step)
+
+	// Index the proposals in the view, checking their tickets.
+	seen := make(map[int]uint64)
+	for _, p := range v.Seen {
+		if err := c.proposal(v.Step, p); err != nil {
+			return err
+		}
+		seen[p.From] = p.Ticket
+	}
+	conf := make(map[int]bool)
+	for _, from := range v.Confirmed {
+		if _, ok := seen[from]; !ok {
+			return fmt.Errorf("%v: confirmed proposal %v not seen",
+				where, from)
+		}
+		conf[from] = true
+	}
+	reconf := make(map[int]bool)
+	for _, from := range v.Reconfirmed {
+		if !conf[from] {
+			return fmt.Errorf("%v: reconfirmed proposal %v "+
+				"not confirmed", where, from)
+		}
+		reconf[from] = true
+	}
+
+	// The choice must be a confirmed proposal with the highest ticket.
+	if !conf[v.Choice] {
+		return fmt.Errorf("%v: chose unconfirmed proposal %v",
+			where, v.Choice)
+	}
+	best := seen[v.Choice]
+	for from := range conf {
+		if seen[from] > best {
+			return fmt.Errorf("%v: chose %v over better confirmed %v",
+				where, v.Choice, from)
+		}
+	}
+
+	// The round commits if the choice is reconfirmed and not spoiled.
+	spoiled := false
+	for from, t := range seen {
+		if from != v.Choice && t >= best {
+			spoiled = true
+		}
+	}
+	commit := reconf[v.Choice] && !spoiled
+	if v.Commit != commit {
+		return fmt.Errorf("%v: reported commit %v, expected %v",
+			where, v.Commit, commit)
+	}
+	return c.decide(v.Node, v.Step, v.Choice, v.Commit)
+}
+
+func (c *Checker) summary(s *Summary) error {
+	where := fmt.Sprintf("node %v round %v", s.Node, s.Step)
+
+	// Check the best records' tickets against the proposals
+	for _, b := range []Best{s.Spoil, s.Conf, s.Reconf} {
+		if b.From >= 0 && b.Ticket != 0 {
+			err := c.proposal(s.Step, Proposal{b.From, b.Ticket})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Every reconfirmed proposal is confirmed,
+	// and every confirmed proposal is seen.
+	if s.Conf.Ticket == 0 {
+		return fmt.Errorf("%v: no confirmed proposal", where)
+	}
+	if s.Reconf.Ticket > s.Conf.Ticket || s.Conf.Ticket > s.Spoil.Ticket {
+		return fmt.Errorf("%v: tickets out of order: reconfirmed %v, "+
+			"confirmed %v, seen %v", where, s.Reconf.Ticket,
+			s.Conf.Ticket, s.Spoil.Ticket)
+	}
+
+	// The round commits if the best confirmed proposal is reconfirmed,
+	// and is also the best proposal seen, with no ties.
+	reconfirmed := s.Reconf == s.Conf
+	spoiled := s.Spoil.From < 0 || s.Spoil != s.Conf
+	commit := reconfirmed && !spoiled
+	if s.Commit != commit {
+		return fmt.Errorf("%v: reported commit %v, expected %v",
+			where, s.Commit, commit)
+	}
+	return c.decide(s.Node, s.Step, s.Conf.From, s.Commit)
+}
+
+// Check a node's decision in a round for agreement with other nodes'.
+func (c *Checker) decide(node, step, choice int, commit bool) error {
+	if c.choice == nil {
+		c.choice = make(map[int]map[int]bool)
+		c.commit = make(map[int]int)
+	}
+	if commit {
+		if _, ok := c.commit[step]; !ok {
+			c.commit[step] = choice
+			for other := range c.choice[step] {
+				if other != choice {
+					return fmt.Errorf("round %v: node %v "+
+						"committed %v but a node chose %v",
+						step, node, choice, other)
+				}
+			}
+		}
+	}
+	if com, ok := c.commit[step]; ok && com != choice {
+		return fmt.Errorf("round %v: node %v chose %v but %v committed",
+			step, node, choice, com)
+	}
+	if c.choice[step] == nil {
+		c.choice[step] = make(map[int]bool)
+	}
+	c.choice[step][choice] = true
+	return nil
+}
@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	seen := []Proposal{{0, 10}, {1, 30}, {2, 20}}
+	good := []View{
+		{Node: 0, Step: 0, Seen: seen, Confirmed: []int{1, 2},
+			Reconfirmed: []int{1}, Choice: 1, Commit: true},
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{1, 2},
+			Choice: 1, Commit: false},
+		{Node: 2, Step: 0, Seen: seen[1:], Confirmed: []int{1},
+			Reconfirmed: []int{1}, Choice: 1, Commit: true},
+		{Node: 0, Step: 1, Seen: seen, Confirmed: []int{0, 2},
+			Reconfirmed: []int{2}, Choice: 2, Commit: false},
+	}
+	c := &Checker{}
+	for i := range good {
+		if err := c.View(&good[i]); err != nil {
+			t.Errorf("view %v: %v", i, err)
+		}
+	}
+
+	bad := []View{
+		// Choice is not the best confirmed proposal
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{1, 2},
+			Choice: 2},
+		// Choice is not confirmed at all
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{2},
+			Choice: 1},
+		// Commit despite not being reconfirmed
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{1},
+			Choice: 1, Commit: true},
+		// No commit despite being reconfirmed and unspoiled
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{1},
+			Reconfirmed: []int{1}, Choice: 1},
+		// Commit despite a spoiler
+		{Node: 1, Step: 1, Seen: seen, Confirmed: []int{0, 2},
+			Reconfirmed: []int{2}, Choice: 2, Commit: true},
+		// Disagreement with a committed choice
+		{Node: 2, Step: 0, Seen: seen[2:], Confirmed: []int{2},
+			Choice: 2},
+		// Inconsistent ticket
+		{Node: 1, Step: 0, Seen: []Proposal{{1, 31}},
+			Confirmed: []int{1}, Choice: 1},
+		// Reconfirmed but not confirmed
+		{Node: 1, Step: 0, Seen: seen, Confirmed: []int{1},
+			Reconfirmed: []int{2}, Choice: 1},
+	}
+	for i := range bad {
+		if err := c.View(&bad[i]); err == nil {
+			t.Errorf("bad view %v accepted", i)
+		}
+	}
+	if c.Err() == nil {
+		t.Errorf("no violation recorded")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	c := &Checker{}
+	c.Propose(0, 0, 10)
+	c.Propose(1, 0, 30)
+	c.Propose(2, 0, 20)
+
+	b1, b2 := Best{1, 30}, Best{2, 20}
+	good := []Summary{
+		{Node: 0, Step: 0, Spoil: b1, Conf: b1, Reconf: b1, Commit: true},
+		{Node: 1, Step: 0, Spoil: b1, Conf: b1, Reconf: b2},
+		{Node: 2, Step: 0, Spoil: b1, Conf: b1},
+	}
+	for i := range good {
+		if err := c.Summary(&good[i]); err != nil {
+			t.Errorf("summary %v: %v", i, err)
+		}
+	}
+
+	bad := []Summary{
+		// Commit despite not being reconfirmed
+		{Node: 1, Step: 0, Spoil: b1, Conf: b1, Reconf: b2, Commit: true},
+		// Commit despite a tie
+		{Node: 1, Step: 0, Spoil: Best{-1, 30}, Conf: b1, Reconf: b1,
+			Commit: true},
+		// Confirmed proposal better than any seen
+		{Node: 1, Step: 0, Spoil: b2, Conf: b1, Reconf: b1},
+		// Disagreement with a committed choice
+		{Node: 1, Step: 0, Spoil: b1, Conf: b2, Reconf: b2},
+		// Ticket that wasn't proposed
+		{Node: 1, Step: 0, Spoil: Best{1, 31}, Conf: Best{1, 31}},
+		// No confirmed proposal at all
+		{Node: 1, Step: 1, Spoil: b1},
+	}
+	for i := range bad {
+		if err := c.Summary(&bad[i]); err == nil {
+			t.Errorf("bad summary %v accepted", i)
+		}
+	}
+}
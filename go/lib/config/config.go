@@ -0,0 +1,283 @@
+// Package config loads the canonical configuration file of a consensus group,
+// which both the nodes running the group and tools such as qsc consume.
+// A file lists the group's members in group order,
+// its fault tolerance and thresholds,
+// and optionally the settings of one node running as a member:
+//
+//	[group]
+//	faulty = 1              # members that may fail, default N/3
+//	threshold = 3           # TLC threshold, default N - faulty
+//	keys = "keys.json"      # key file of an encrypted group
+//
+//	[[member]]
+//	name = "a.example.com"  # host name the member's certificate is for
+//	addr = "a.example.com:7000"
+//	store = "/srv/qsc/a"    # member store: a directory or URL
+//	domain = "zone-1"       # failure domain
+//
+//	[[member]]
+//	...
+//
+//	[node]
+//	self = "a.example.com"  # name of the member this node runs as
+//	cert = "a.pem"
+//	key = "a-key.pem"
+//	roots = "ca.pem"
+//	snapshot = "/var/lib/tlc/a.snapshot"
+//	codec = "binary"
+//	fallback_delay = "100ms"
+//
+// Files are in TOML, or in YAML if their names end in .yaml or .yml,
+// or in JSON if their names end in .json, with the same field names.
+// Paths in a file are relative to the working directory
+// of the program loading it.
+//
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the contents of a configuration file.
+type File struct {
+	Group   Group    `json:"group"`
+	Members []Member `json:"member"`         // Members in group order
+	Node    *Node    `json:"node,omitempty"` // Node settings, if any
+}
+
+// Group holds a group's fault tolerance and thresholds.
+type Group struct {
+	Faulty    int    `json:"faulty"`         // Failed members tolerated
+	Threshold int    `json:"threshold"`      // TLC and consensus threshold
+	Keys      string `json:"keys,omitempty"` // Key file of encrypted groups
+}
+
+// Member describes one member of a group.
+// Nodes need each member's Addr and tools operating on stores its Store.
+type Member struct {
+	Name   string `json:"name,omitempty"`   // Host name of its certificate
+	Addr   string `json:"addr,omitempty"`   // TCP address of its node
+	Store  string `json:"store,omitempty"`  // Identifier of its store
+	Domain string `json:"domain,omitempty"` // Failure domain, if known
+}
+
+// Node holds the settings of a node running as one of a group's members.
+// The TLS files are either all set or all empty for plain TCP.
+type Node struct {
+	Self   string `json:"self"`             // Name of the member it runs as
+	Listen string `json:"listen,omitempty"` // Listen address if not Addr
+
+	Cert  string `json:"cert,omitempty"`  // PEM certificate file
+	Key   string `json:"key,omitempty"`   // PEM private key file
+	Roots string `json:"roots,omitempty"` // PEM file of trusted roots
+
+	// Snapshot is the file persisting the node's state,
+	// from which it rejoins the group after restarting, or "" for none.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Tuning knobs, as the fields of dist.Config and dist.TCP
+	// of the same names document, with their defaults if zero.
+	MaxTicket     int32    `json:"max_ticket,omitempty"`
+	Probe         Duration `json:"probe,omitempty"`
+	Codec         string   `json:"codec,omitempty"` // "gob" or "binary"
+	Multiplex     bool     `json:"multiplex,omitempty"`
+	Resolve       Duration `json:"resolve,omitempty"`
+	FallbackDelay Duration `json:"fallback_delay,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("duration must be a string such as \"1s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Load reads, defaults, and validates a configuration file.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parse := ParseTOML
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		parse = ParseJSON
+	case strings.HasSuffix(path, ".yaml"), strings.HasSuffix(path, ".yml"):
+		parse = ParseYAML
+	}
+	f, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// ParseTOML parses, defaults, and validates a configuration in TOML.
+func ParseTOML(b []byte) (*File, error) {
+	var m map[string]any
+	if err := toml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return parseMap(m)
+}
+
+// ParseYAML parses, defaults, and validates a configuration in YAML.
+func ParseYAML(b []byte) (*File, error) {
+	var m map[string]any
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return parseMap(m)
+}
+
+// Parse a configuration decoded generically from TOML or YAML
+// by way of JSON, so that all formats share the same field names,
+// checks for unknown fields, and decoding of values such as Durations.
+func parseMap(m map[string]any) (*File, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSON(j)
+}
+
+// ParseJSON parses, defaults, and validates a configuration in JSON.
+func ParseJSON(b []byte) (*File, error) {
+	f := &File{Group: Group{Faulty: -1}}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(f); err != nil {
+		return nil, err
+	}
+	if err := f.Check(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Check fills in defaults for the settings f leaves unset,
+// then checks that its settings are consistent.
+func (f *File) Check() error {
+	n := len(f.Members)
+	if n == 0 {
+		return errors.New("no members")
+	}
+	if f.Group.Faulty < 0 {
+		f.Group.Faulty = n / 3
+	}
+	if f.Group.Faulty >= n {
+		return fmt.Errorf("%v faulty members of %v", f.Group.Faulty, n)
+	}
+	if f.Group.Threshold == 0 {
+		f.Group.Threshold = n - f.Group.Faulty
+	}
+	if t := f.Group.Threshold; t <= n/2 || t > n {
+		return fmt.Errorf("threshold %v of %v members is not a majority",
+			t, n)
+	}
+
+	names, addrs, stores := map[string]bool{}, map[string]bool{},
+		map[string]bool{}
+	for i, m := range f.Members {
+		for _, u := range []struct {
+			field, val string
+			seen       map[string]bool
+		}{{"name", m.Name, names}, {"addr", m.Addr, addrs},
+			{"store", m.Store, stores}} {
+			if u.val != "" && u.seen[u.val] {
+				return fmt.Errorf("member %v: duplicate %s %q",
+					i, u.field, u.val)
+			}
+			u.seen[u.val] = true
+		}
+	}
+
+	if f.Node != nil {
+		return f.Node.check(f)
+	}
+	return nil
+}
+
+// Check a node's settings in file f.
+func (n *Node) check(f *File) error {
+	if f.Index(n.Self) < 0 {
+		return fmt.Errorf("node runs as %q, which is not a member", n.Self)
+	}
+	for i, m := range f.Members {
+		if m.Addr == "" {
+			return fmt.Errorf("member %v has no addr", i)
+		}
+	}
+	if (n.Cert == "") != (n.Key == "") || (n.Cert == "") != (n.Roots == "") {
+		return errors.New("node sets only some of cert, key, and roots")
+	}
+	switch n.Codec {
+	case "", "gob", "binary":
+	default:
+		return fmt.Errorf("unknown codec %q", n.Codec)
+	}
+	if n.MaxTicket < 0 || n.Probe < 0 || n.Resolve < 0 {
+		return errors.New("negative max_ticket, probe, or resolve")
+	}
+	return nil
+}
+
+// Index returns the index of the member with the given name,
+// or -1 if there is none.
+func (f *File) Index(name string) int {
+	for i, m := range f.Members {
+		if name != "" && m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Stores returns the identifiers of the members' stores, in group order.
+func (f *File) Stores() ([]string, error) {
+	stores := make([]string, len(f.Members))
+	for i, m := range f.Members {
+		if m.Store == "" {
+			return nil, fmt.Errorf("member %v has no store", i)
+		}
+		stores[i] = m.Store
+	}
+	return stores, nil
+}
+
+// Domains returns the failure domains of the members, in group order,
+// or nil if the file records none.
+func (f *File) Domains() []string {
+	var domains []string
+	for i, m := range f.Members {
+		if m.Domain != "" && domains == nil {
+			domains = make([]string, len(f.Members))
+		}
+		if domains != nil {
+			domains[i] = m.Domain
+		}
+	}
+	return domains
+}
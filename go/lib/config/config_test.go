@@ -0,0 +1,212 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const example = `
+[group]
+keys = "keys.json"
+
+[[member]]
+name = "a"
+addr = "a:7000"
+store = "/srv/a"
+domain = "zone-1"
+
+[[member]]
+name = "b"
+addr = "b:7000"
+store = "s3://bucket/b"
+
+[[member]]
+name = "c"
+addr = "c:7000"
+store = "c:/srv/c"
+domain = "zone-2"
+
+[[member]]
+name = "d"
+addr = "d:7000"
+store = "/srv/d"
+
+[node]
+self = "b"
+cert = "b.pem"
+key = "b-key.pem"
+roots = "ca.pem"
+snapshot = "b.snapshot"
+codec = "binary"
+fallback_delay = "100ms"
+`
+
+// The same configuration as example, in YAML.
+const exampleYAML = `
+group:
+  keys: keys.json
+member:
+  - name: a
+    addr: "a:7000"
+    store: /srv/a
+    domain: zone-1
+  - name: b
+    addr: "b:7000"
+    store: s3://bucket/b
+  - name: c
+    addr: "c:7000"
+    store: c:/srv/c
+    domain: zone-2
+  - name: d
+    addr: "d:7000"
+    store: /srv/d
+node:
+  self: b
+  cert: b.pem
+  key: b-key.pem
+  roots: ca.pem
+  snapshot: b.snapshot
+  codec: binary
+  fallback_delay: 100ms
+`
+
+func TestParse(t *testing.T) {
+	f, err := ParseTOML([]byte(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Group != (Group{Faulty: 1, Threshold: 3, Keys: "keys.json"}) {
+		t.Errorf("group %+v", f.Group)
+	}
+	if f.Index("b") != 1 || f.Index("x") >= 0 || f.Index("") >= 0 {
+		t.Errorf("wrong member indexes")
+	}
+	stores, err := f.Stores()
+	if err != nil || !reflect.DeepEqual(stores, []string{"/srv/a",
+		"s3://bucket/b", "c:/srv/c", "/srv/d"}) {
+		t.Errorf("stores %v %v", stores, err)
+	}
+	if d := f.Domains(); !reflect.DeepEqual(d,
+		[]string{"zone-1", "", "zone-2", ""}) {
+		t.Errorf("domains %q", d)
+	}
+	n := f.Node
+	if n.Self != "b" || n.Codec != "binary" || n.Snapshot != "b.snapshot" ||
+		time.Duration(n.FallbackDelay) != 100*time.Millisecond {
+		t.Errorf("node %+v", n)
+	}
+
+	// The same configuration in JSON parses the same.
+	j, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ParseJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, g) {
+		t.Errorf("JSON %s parsed as %+v", j, g)
+	}
+
+	// And so does the same configuration in YAML.
+	y, err := ParseYAML([]byte(exampleYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, y) {
+		t.Errorf("YAML parsed as %+v", y)
+	}
+	bad := "member:\n  - name: a\n    port: 1\n"
+	if _, err := ParseYAML([]byte(bad)); err == nil ||
+		!strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("YAML with unknown field: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	members := `
+[[member]]
+name = "a"
+addr = "a:1"
+[[member]]
+name = "b"
+addr = "b:1"
+[[member]]
+name = "c"
+addr = "c:1"
+`
+	for _, c := range []struct{ doc, err string }{
+		{``, "no members"},
+		{"[group]\nfaulty = 3\n" + members, "faulty"},
+		{"[group]\nthreshold = 1\n" + members, "majority"},
+		{"[group]\nthreshold = 4\n" + members, "majority"},
+		{"[group]\nfaulty = 0\nthreshold = 2\n" + members, ""},
+		{members + "[[member]]\nname = \"a\"", "duplicate name"},
+		{members + "[[member]]\naddr = \"a:1\"", "duplicate addr"},
+		{members + "[[member]]\nstore = \"s\"\n" +
+			"[[member]]\nstore = \"s\"", "duplicate store"},
+		{members + "[node]\nself = \"x\"", "not a member"},
+		{members + "[[member]]\nname = \"d\"\n" +
+			"[node]\nself = \"a\"", "no addr"},
+		{members + "[node]\nself = \"a\"\ncert = \"a.pem\"", "some of"},
+		{members + "[node]\nself = \"a\"\ncodec = \"xml\"", "codec"},
+		{members + "[node]\nself = \"a\"\nprobe = \"-1s\"", "negative"},
+		{members + "[node]\nself = \"a\"\nprobe = 1", "duration"},
+		{members + "[node]\nself = \"a\"\nprobe = \"1 s\"", "duration"},
+		{members + "[node]\nself = \"a\"\nfallback_delay = \"-1s\"", ""},
+		{members + "[node]\nself = \"a\"\ntypo = 1", "unknown field"},
+		{members + "[[member]]\nname = \"d\"\nport = 1", "unknown field"},
+	} {
+		_, err := ParseTOML([]byte(c.doc))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("rejected %q: %v", c.doc, err)
+		case c.err != "" && (err == nil ||
+			!strings.Contains(err.Error(), c.err)):
+			t.Errorf("checking %q: got error %v, want %q",
+				c.doc, err, c.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	tf := filepath.Join(dir, "group.toml")
+	if err := os.WriteFile(tf, []byte(example), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, _ := json.Marshal(f)
+	jf := filepath.Join(dir, "group.json")
+	if err := os.WriteFile(jf, j, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := Load(jf); err != nil || !reflect.DeepEqual(f, g) {
+		t.Errorf("loaded %+v %v from JSON", g, err)
+	}
+	yf := filepath.Join(dir, "group.yaml")
+	if err := os.WriteFile(yf, []byte(exampleYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := Load(yf); err != nil || !reflect.DeepEqual(f, g) {
+		t.Errorf("loaded %+v %v from YAML", g, err)
+	}
+
+	// Errors name the file.
+	if err := os.WriteFile(tf, []byte("x = "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(tf); err == nil ||
+		!strings.Contains(err.Error(), tf) {
+		t.Errorf("loading malformed file: %v", err)
+	}
+}
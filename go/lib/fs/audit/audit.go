@@ -0,0 +1,179 @@
+// Package audit implements a tamper-evident log of the state changes
+// a versioned state store observes.
+//
+// Each log entry records a state version number and value,
+// together with a hash chaining it to the previous entry,
+// so that no entry can later be modified, removed, or reordered
+// without invalidating the hashes of all subsequent entries.
+// Entries may optionally be signed with an Ed25519 key,
+// so that operators can prove after the fact to third parties
+// the sequence of state changes a particular store observed.
+//
+// The log is a text file holding one JSON-encoded Entry per line,
+// which the Verify function (and the qsc audit command) can check.
+//
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is a single record in an audit log.
+type Entry struct {
+	Ver  int64     // State version number written
+	Val  string    // State value associated with this version
+	Time time.Time // Local wall-clock time the entry was recorded
+	Prev []byte    // Hash of the previous entry, all zeros for the first
+	Hash []byte    // Hash of this entry, including Prev
+	Sig  []byte    // Optional Ed25519 signature of Hash
+}
+
+// Compute the hash of an entry, covering all fields other than Hash and Sig.
+func (e *Entry) hash() []byte {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:], uint64(e.Ver))
+	binary.BigEndian.PutUint64(b[8:], uint64(e.Time.UnixNano()))
+
+	h := sha256.New()
+	h.Write(e.Prev)
+	h.Write(b[:])
+	h.Write([]byte(e.Val))
+	return h.Sum(nil)
+}
+
+// ErrBroken is wrapped by all errors Verify returns for invalid logs.
+var ErrBroken = errors.New("audit log verification failed")
+
+// Verify reads an audit log from r and checks its integrity, returning
+// the number of valid entries and the last entry, if any.
+//
+// Verify checks that each entry's hash is correct
+// and chains to the previous entry's hash,
+// and that version numbers strictly increase.
+// If pub is non-nil, Verify also requires that every entry be signed
+// by the corresponding private key.
+//
+func Verify(r io.Reader, pub ed25519.PublicKey) (n int, last Entry, err error) {
+	prev := make([]byte, sha256.Size)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return n, last, fmt.Errorf("%w: entry %d: %v",
+				ErrBroken, n, err)
+		}
+		if !bytes.Equal(e.Prev, prev) {
+			return n, last, fmt.Errorf("%w: entry %d: broken chain",
+				ErrBroken, n)
+		}
+		if !bytes.Equal(e.Hash, e.hash()) {
+			return n, last, fmt.Errorf("%w: entry %d: bad hash",
+				ErrBroken, n)
+		}
+		if n > 0 && e.Ver <= last.Ver {
+			return n, last, fmt.Errorf("%w: entry %d: "+
+				"version %d does not follow %d",
+				ErrBroken, n, e.Ver, last.Ver)
+		}
+		if pub != nil && !ed25519.Verify(pub, e.Hash, e.Sig) {
+			return n, last, fmt.Errorf("%w: entry %d: bad signature",
+				ErrBroken, n)
+		}
+		prev, last = e.Hash, e
+		n++
+	}
+	return n, last, sc.Err()
+}
+
+// Log is an append-only audit log file.
+// It is safe for concurrent use by multiple goroutines,
+// but only one Log instance at a time should append to a given file.
+//
+type Log struct {
+	mut  sync.Mutex
+	f    *os.File           // log file opened for appending
+	key  ed25519.PrivateKey // optional signing key
+	prev []byte             // hash of the last entry
+	ver  int64              // version number of the last entry
+	n    int                // number of entries in the log
+}
+
+// Init opens the audit log at path for appending, creating it if necessary.
+// If key is non-nil, Init verifies that all existing entries were signed
+// with key, and the Log signs each new entry with it.
+//
+func (l *Log) Init(path string, key ed25519.PrivateKey) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Verify the existing log contents to find where the chain left off.
+	var pub ed25519.PublicKey
+	if key != nil {
+		pub = key.Public().(ed25519.PublicKey)
+	}
+	n, last, err := Verify(f, pub)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	*l = Log{f: f, key: key, prev: last.Hash, ver: last.Ver, n: n}
+	if n == 0 {
+		l.prev = make([]byte, sha256.Size)
+	}
+	return nil
+}
+
+// Record appends an entry for version ver with value val to the log,
+// and synchronizes it to stable storage before returning.
+// Versions no greater than the last recorded version are silently ignored,
+// since they must have been recorded already.
+//
+func (l *Log) Record(ver int64, val string) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.n > 0 && ver <= l.ver {
+		return nil
+	}
+
+	e := Entry{Ver: ver, Val: val, Time: time.Now().Round(0), Prev: l.prev}
+	e.Hash = e.hash()
+	if l.key != nil {
+		e.Sig = ed25519.Sign(l.key, e.Hash)
+	}
+
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	l.prev, l.ver = e.Hash, ver
+	l.n++
+	return nil
+}
+
+// Close closes the underlying audit log file.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Record some versions, reopening the log partway through.
+	l := &Log{}
+	if err := l.Init(path, key); err != nil {
+		t.Fatal(err)
+	}
+	for ver := int64(1); ver <= 10; ver++ {
+		if ver == 5 {
+			l.Close()
+			if err := l.Init(path, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Record(ver, string(rune('a'+ver))); err != nil {
+			t.Fatal(err)
+		}
+		l.Record(ver, "duplicate") // should be ignored
+	}
+	l.Close()
+
+	// Verify the log as written.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, last, err := Verify(bytes.NewReader(b), pub)
+	if err != nil || n != 10 || last.Ver != 10 {
+		t.Fatalf("Verify: %v entries, last version %v, error %v",
+			n, last.Ver, err)
+	}
+
+	// Tampering with any entry must break verification.
+	bad := bytes.Replace(b, []byte(`"Val":"f"`), []byte(`"Val":"x"`), 1)
+	if _, _, err := Verify(bytes.NewReader(bad), nil); !errors.Is(err,
+		ErrBroken) {
+		t.Errorf("tampered value: got error %v", err)
+	}
+
+	// So must removing an entry.
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	cut := append(append([]byte{}, lines[0]...), bytes.Join(lines[2:],
+		nil)...)
+	if _, _, err := Verify(bytes.NewReader(cut), nil); !errors.Is(err,
+		ErrBroken) {
+		t.Errorf("removed entry: got error %v", err)
+	}
+
+	// And verifying against the wrong key must fail.
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, _, err := Verify(bytes.NewReader(b), other); !errors.Is(err,
+		ErrBroken) {
+		t.Errorf("wrong key: got error %v", err)
+	}
+}
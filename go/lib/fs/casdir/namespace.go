@@ -0,0 +1,152 @@
+package casdir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrName is returned for a namespace name that a Tree cannot host.
+var ErrName = errors.New("casdir: invalid namespace name")
+
+// ErrQuota is returned on creating a namespace beyond a Tree's MaxNamespaces.
+var ErrQuota = errors.New("casdir: too many namespaces")
+
+// Tree hosts many named registers, or namespaces, in one directory tree,
+// each an independent Store in its own subdirectory,
+// so that an application with many small consensus values
+// need not provision and manage a separate directory for each.
+//
+// Open acts as a factory for the Stores accessing each namespace,
+// starting each from a copy of the Template Store's configuration,
+// which the Tree uses only as a template and never initializes.
+// A Tree cannot share one audit log among its namespaces,
+// so Template must not set Audit.
+// Configure, if non-nil, may then adjust the Store for a particular namespace
+// before Open initializes it, for instance to give that namespace
+// its own garbage collection Policy, or its own quota on value sizes
+// via MaxValueSize.
+// All clients of a Tree should configure each namespace the same way.
+//
+// MaxNamespaces, if positive, limits the number of namespaces Open creates.
+// Clients creating namespaces concurrently may briefly exceed the limit.
+//
+// Namespace names consist of ASCII letters, digits, underscores,
+// and dashes, so that they are portable file names,
+// and are at most 200 bytes long.
+//
+type Tree struct {
+	Template      Store                        // configuration of each Store
+	Configure     func(name string, st *Store) // per-namespace configuration
+	MaxNamespaces int                          // max namespaces, or 0
+
+	path string // root directory of the tree
+}
+
+// Init prepares the Tree to access the directory tree at path,
+// creating the root directory if create is true and it does not exist,
+// or failing if excl is true and it already exists.
+func (t *Tree) Init(path string, create, excl bool) error {
+	if t.Template.Audit != nil {
+		return errors.New("casdir: Tree cannot audit namespaces")
+	}
+	t.path = path
+	_, err := os.Stat(path)
+	switch {
+	case err == nil && excl:
+		return os.ErrExist
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err) || !create:
+		return err
+	}
+	err = os.Mkdir(path, 0755)
+	if err != nil && (excl || !os.IsExist(err)) {
+		return err
+	}
+	return nil
+}
+
+// Open returns a new Store accessing namespace name,
+// creating the namespace if create is true and it does not yet exist.
+// Each Store is for use by one goroutine at a time, as usual,
+// but a client may Open any number of Stores on the same namespace.
+func (t *Tree) Open(name string, create bool) (*Store, error) {
+	if !validName(name) {
+		return nil, ErrName
+	}
+	path := filepath.Join(t.path, name)
+	if create && t.MaxNamespaces > 0 {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			names, err := t.List()
+			if err != nil {
+				return nil, err
+			}
+			if len(names) >= t.MaxNamespaces {
+				return nil, ErrQuota
+			}
+		}
+	}
+
+	s := t.Template // Init sets the per-namespace state
+	st := &s
+	if t.Configure != nil {
+		t.Configure(name, st)
+	}
+	if err := st.Init(path, create, false); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// List returns the names of the Tree's namespaces in sorted order.
+func (t *Tree) List() ([]string, error) {
+	ents, err := os.ReadDir(t.path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ent := range ents {
+
+		// Skip the temporary directories that creating
+		// and removing namespaces leave behind.
+		if ent.IsDir() && validName(ent.Name()) {
+			names = append(names, ent.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove deletes namespace name and all its versions,
+// atomically as far as other clients of the Tree are concerned.
+// Stores still open on the namespace fail on their next access.
+func (t *Tree) Remove(name string) error {
+	if !validName(name) {
+		return ErrName
+	}
+	path := filepath.Join(t.path, name)
+	tmpPath, err := os.MkdirTemp(t.path, name+"-*.old")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+	return os.Rename(path, filepath.Join(tmpPath, name))
+}
+
+// Return true if name is a valid namespace name.
+func validName(name string) bool {
+	if name == "" || len(name) > 200 {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z',
+			c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package casdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Test that a Tree hosts independent namespaces with their own settings.
+func TestTree(t *testing.T) {
+	ctx := context.Background()
+	tree := &Tree{Template: Store{MaxValueSize: 8}, MaxNamespaces: 3,
+		Configure: func(name string, st *Store) {
+			if name == "big" {
+				st.MaxValueSize = 0
+			}
+		}}
+	path := filepath.Join(t.TempDir(), "tree")
+	if err := tree.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each namespace holds its own register.
+	for _, name := range []string{"a", "b", "big"} {
+		st, err := tree.Open(name, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := st.CompareAndSet(ctx, "", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := &Tree{}
+	if err := other.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "big"} {
+		st, err := other.Open(name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ver, val, err := st.CompareAndSet(ctx, "", "")
+		if err != nil || ver != 1 || val != name {
+			t.Errorf("namespace %v holds %v %q %v", name, ver, val, err)
+		}
+	}
+	if names, err := other.List(); err != nil ||
+		!reflect.DeepEqual(names, []string{"a", "b", "big"}) {
+		t.Errorf("List: %v %v", names, err)
+	}
+
+	// Namespaces have their own quotas on value sizes.
+	big := fmt.Sprintf("%020d", 0)
+	a, _ := tree.Open("a", false)
+	if _, _, err := a.CompareAndSet(ctx, "a", big); !errors.Is(err,
+		cas.ErrTooLarge) {
+		t.Errorf("oversized value yielded %v", err)
+	}
+	b, _ := tree.Open("big", false)
+	if _, _, err := b.CompareAndSet(ctx, "big", big); err != nil {
+		t.Error(err)
+	}
+
+	// The Tree limits the number of namespaces and their names.
+	if _, err := tree.Open("d", true); err != ErrQuota {
+		t.Errorf("fourth namespace yielded %v", err)
+	}
+	for _, name := range []string{"", "..", "a/b", "x.tmp"} {
+		if _, err := tree.Open(name, true); err != ErrName {
+			t.Errorf("namespace %q yielded %v", name, err)
+		}
+	}
+	if _, err := tree.Open("d", false); err == nil {
+		t.Errorf("opened a nonexistent namespace")
+	}
+
+	// Removing a namespace makes room for another.
+	if err := tree.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Open("b", false); err == nil {
+		t.Errorf("opened a removed namespace")
+	}
+	if _, err := tree.Open("d", true); err != nil {
+		t.Error(err)
+	}
+	if names, err := tree.List(); err != nil ||
+		!reflect.DeepEqual(names, []string{"a", "big", "d"}) {
+		t.Errorf("List: %v %v", names, err)
+	}
+}
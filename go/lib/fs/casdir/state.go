@@ -9,11 +9,17 @@
 // To implement CAS, in essence, we simply expire old versions immediately
 // as soon as any new version is written.
 //
+// A Tree hosts many independent Stores, or namespaces,
+// in subdirectories of one directory tree.
+//
 package casdir
 
 import (
 	"context"
+	"time"
 
+	"github.com/dedis/tlc/go/lib/authz"
+	"github.com/dedis/tlc/go/lib/fs/audit"
 	"github.com/dedis/tlc/go/lib/fs/verst"
 )
 
@@ -28,7 +34,60 @@ import (
 // Each Store instance is intended for use by only one goroutine at a time,
 // so the client must synchronize shared uses across multiple goroutines.
 //
+// If Auth is non-nil, the Store consults it before each operation,
+// with the client identity attached to the CompareAndSet context
+// via authz.NewContext.
+// A client permitted to read but not write may still use CompareAndSet
+// with new equal to old to read the latest state.
+//
+// If Audit is non-nil when Init is called, the Store records
+// each state version it writes in this tamper-evident audit log.
+//
+// Store implements the cas.History interface for historical reads,
+// the cas.Nonced interface for detecting a lost state directory,
+// and the cas.Watcher interface for following changes as they happen.
+// By default the Store retains no versions before the latest,
+// but if Retain is positive, the Store expires only versions
+// more than Retain versions older than the latest.
+// Policy configures how the underlying verst state
+// garbage-collects expired versions, and must be set before Init.
+// Layout similarly selects the verst layout of a newly-created state directory,
+// such as verst.LayoutLog for higher throughput on local file systems,
+// and Cache enables the verst State's caching of directory listings
+// for clients that read much more often than they write.
+//
+// MaxValueSize optionally limits the size in bytes of the values
+// the Store writes, and must be set before Init.
+// CompareAndSet returns a cas.SizeError when asked to write a larger value.
+//
+// Since the Store expires old versions as soon as it writes new ones,
+// a slower client reading concurrently may find the version it observed
+// already collected, and have to catch up via the latest version instead.
+// Grace, if positive, is a minimum time to retain expired versions
+// so that such readers don't race with garbage collection,
+// extending Policy.MinAge if that is shorter.
+// Clients that need a particular version to remain available longer,
+// such as while copying a range of historical versions,
+// may protect it and all later versions with Pin.
+//
+// CompareAndSet normally expects old to be the value it last returned,
+// and if it is not, compares old against the latest version it reads.
+// Strict instead makes CompareAndSet panic in that case,
+// to catch clients that lose track of the state they last observed.
+//
 type Store struct {
+	Auth   authz.Authorizer // optional authorization hook
+	Audit  *audit.Log       // optional audit log of state changes
+	Retain int64            // number of past versions to retain
+	Policy verst.Policy     // garbage collection policy
+	Grace  time.Duration    // minimum time to retain expired versions
+	Layout verst.Layout     // layout of a new state directory
+	Cache  bool             // cache directory listings for reads
+	Strict bool             // panic on an unexpected old value
+
+	MaxValueSize int // maximum value size in bytes, or 0 for no limit
+
+	path string      // path of the state directory
 	vs   verst.State // underlying versioned state
 	lver int64       // last version we've read
 	lval string      // application value associated with lver
@@ -39,6 +98,15 @@ type Store struct {
 // If excl is true, fails if the designated directory already exists.
 //
 func (st *Store) Init(path string, create, excl bool) error {
+	st.vs.Audit = st.Audit
+	st.vs.Policy = st.Policy
+	st.vs.Layout = st.Layout
+	st.vs.Cache = st.Cache
+	st.vs.MaxValueSize = st.MaxValueSize
+	if st.Grace > st.vs.Policy.MinAge {
+		st.vs.Policy.MinAge = st.Grace
+	}
+	st.path = path
 	return st.vs.Init(path, create, excl)
 }
 
@@ -48,34 +116,163 @@ func (st *Store) Init(path string, create, excl bool) error {
 func (st *Store) CompareAndSet(ctx context.Context, old, new string) (
 	version int64, actual string, err error) {
 
+	if err := authz.Check(ctx, st.Auth, authz.Read, st.lver); err != nil {
+		return 0, "", err
+	}
+
+	// A caller passing an old value other than the one we last returned
+	// has lost track of the state, so catch up to the latest version,
+	// and write only if it still holds old.
 	if old != st.lval {
-		panic("CompareAndSet: wrong old value")
+		if st.Strict {
+			panic("CompareAndSet: wrong old value")
+		}
+		ver, val, err := st.vs.ReadLatest()
+		if err != nil {
+			return 0, "", err
+		}
+		st.lver, st.lval = ver, val
+		if old != val {
+			return ver, val, nil
+		}
 	}
 
 	// Try to write the new version to the underlying versioned store -
 	// but don't fret if someone else wrote it or if it has expired.
+	// Clients without write permission may only read the latest version.
 	ver := st.lver + 1
-	err = st.vs.WriteVersion(ver, new)
-	if err != nil && !verst.IsExist(err) && !verst.IsNotExist(err) {
-		return 0, "", err
+	werr := authz.Check(ctx, st.Auth, authz.Write, ver)
+	if werr != nil && new != old {
+		return 0, "", werr
+	}
+	if werr == nil {
+		err = st.vs.WriteVersion(ver, new)
+		if err != nil && !verst.IsExist(err) &&
+			!verst.IsNotExist(err) {
+			return 0, "", err
+		}
 	}
 
 	// Now read back whatever value was successfully written.
+	// If it was our new value, our write took effect at ver.
+	// Otherwise someone else's write won, and perhaps many others since,
+	// so catch up to the most recent committed value:
+	// returning ver would make our read stale and thus non-linearizable.
+	// A read (old == new) always catches up this way,
+	// since other readers write the same value at ver that we would.
 	val, err := st.vs.ReadVersion(ver)
-	if err != nil && verst.IsNotExist(err) {
-
-		// The requested version has probably been aged out,
-		// so catch up to the most recent committed value.
+	if (err == nil && (val != new || old == new)) ||
+		(err != nil && verst.IsNotExist(err)) {
 		ver, val, err = st.vs.ReadLatest()
 	}
 	if err != nil {
 		return 0, "", err
 	}
 
-	// Expire all versions before this latest one
-	st.vs.Expire(ver)
+	// Expire all versions before the retained ones, if permitted
+	before := ver - st.Retain
+	if before > 0 &&
+		authz.Check(ctx, st.Auth, authz.Expire, before) == nil {
+		st.vs.Expire(before)
+	}
 
 	// Return the actual version and value that we read
 	st.lver, st.lval = ver, val
 	return ver, val, err
 }
+
+// ReadAt returns the latest retained state version at or before ver,
+// implementing the cas.History interface.
+func (st *Store) ReadAt(ctx context.Context, ver int64) (
+	version int64, value string, err error) {
+
+	if err := authz.Check(ctx, st.Auth, authz.Read, ver); err != nil {
+		return 0, "", err
+	}
+	return st.vs.ReadAt(ver)
+}
+
+// ListVersions returns the retained state version numbers
+// from through to inclusive, implementing the cas.History interface.
+func (st *Store) ListVersions(ctx context.Context, from, to int64) (
+	[]int64, error) {
+
+	if err := authz.Check(ctx, st.Auth, authz.Read, from); err != nil {
+		return nil, err
+	}
+	return st.vs.ListVersions(from, to)
+}
+
+// Pin protects version ver and all later versions
+// from garbage collection by any client sharing the state directory,
+// until the caller calls Unpin or the pin's lease lapses.
+// Pinning a version again renews its lease:
+// see verst.State.Pin for details.
+func (st *Store) Pin(ctx context.Context, ver int64) error {
+	if err := authz.Check(ctx, st.Auth, authz.Read, ver); err != nil {
+		return err
+	}
+	return st.vs.Pin(ver)
+}
+
+// Unpin releases a pin this Store holds on version ver.
+func (st *Store) Unpin(ctx context.Context, ver int64) error {
+	return st.vs.Unpin(ver)
+}
+
+// Nonce returns the random nonce identifying this incarnation
+// of the state directory, implementing the cas.Nonced interface:
+// see verst.State.Nonce for details.
+func (st *Store) Nonce(ctx context.Context) (string, error) {
+	if err := authz.Check(ctx, st.Auth, authz.Read, st.lver); err != nil {
+		return "", err
+	}
+	return st.vs.Nonce()
+}
+
+// Watch calls f with the latest state version and value,
+// and then with each later state as file system change notifications
+// reveal it, until ctx is cancelled or f returns an error,
+// implementing the cas.Watcher interface.
+// Watch follows the state directory via its own verst.State,
+// so unlike the Store's other methods,
+// it may run concurrently with them.
+// Watch returns verst.ErrWatchUnsupported
+// where the file system cannot deliver change notifications, as on NFS.
+//
+func (st *Store) Watch(ctx context.Context,
+	f func(version int64, value string) error) error {
+
+	var vs verst.State
+	if err := vs.Init(st.path, false, false); err != nil {
+		return err
+	}
+	if err := vs.Watch(); err != nil {
+		return err
+	}
+	defer vs.Unwatch()
+
+	last := int64(-1)
+	for {
+		ver, val, err := vs.ReadLatest()
+		if err != nil {
+			return err
+		}
+		if ver > last {
+			err := authz.Check(ctx, st.Auth, authz.Read, ver)
+			if err != nil {
+				return err
+			}
+			if err := f(ver, val); err != nil {
+				return err
+			}
+			last = ver
+		}
+
+		select {
+		case <-vs.Changed():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package casdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+	"github.com/dedis/tlc/go/lib/fs/verst"
+)
+
+var _ cas.History = (*Store)(nil)
+var _ cas.Watcher = (*Store)(nil)
+
+// Test historical reads of the versions a Store retains.
+func TestHistory(t *testing.T) {
+	bg := context.Background()
+	st := &Store{Retain: 15}
+	path := filepath.Join(t.TempDir(), "st")
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a sequence of values, remembering each version's value.
+	vals := map[int64]string{}
+	old := ""
+	for i := 0; i < 50; i++ {
+		new := fmt.Sprintf("value %d", i)
+		ver, val, err := st.CompareAndSet(bg, old, new)
+		if err != nil || val != new {
+			t.Fatalf("CompareAndSet: %v %q %v", ver, val, err)
+		}
+		vals[ver] = val
+		old = val
+	}
+	latest := int64(50)
+
+	// Versions within the retention window must be readable.
+	for ver := latest - st.Retain; ver <= latest; ver++ {
+		actual, val, err := st.ReadAt(bg, ver)
+		if err != nil || actual != ver || val != vals[ver] {
+			t.Errorf("ReadAt(%v): %v %q %v", ver, actual, val, err)
+		}
+	}
+
+	// Reading beyond the latest version yields the latest.
+	if actual, _, err := st.ReadAt(bg, latest+10); err != nil ||
+		actual != latest {
+		t.Errorf("ReadAt beyond latest: %v %v", actual, err)
+	}
+
+	// Versions well before the retention window must have expired.
+	if _, _, err := st.ReadAt(bg, 1); err == nil {
+		t.Errorf("ReadAt expired version succeeded")
+	}
+
+	// Listing must include at least the retained versions, in order.
+	vers, err := st.ListVersions(bg, 0, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vers) < int(st.Retain)+1 || vers[len(vers)-1] != latest {
+		t.Fatalf("ListVersions: %v", vers)
+	}
+	for i := 1; i < len(vers); i++ {
+		if vers[i] != vers[i-1]+1 {
+			t.Errorf("ListVersions: %v", vers)
+		}
+	}
+	if vers, _ := st.ListVersions(bg, 40, 45); len(vers) != 6 ||
+		vers[0] != 40 {
+		t.Errorf("ListVersions(40, 45): %v", vers)
+	}
+}
+
+// Test concurrent clients of a state directory with the log layout,
+// some of them caching.
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "st")
+	stores := make([]cas.Store, 4)
+	for i := range stores {
+		st := &Store{Layout: verst.LayoutLog, Cache: i%2 == 1,
+			Policy: verst.Policy{VersPerGen: 25}}
+		if err := st.Init(path, true, false); err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = st
+	}
+	test.Stores(t, 1, 200, stores...)
+}
+
+// Test that concurrent clients of a state directory see a linearizable
+// history, even as they rapidly create and expire generations.
+func TestLinear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "st")
+	stores := make([]cas.Store, 5)
+	for i := range stores {
+		st := &Store{Policy: verst.Policy{VersPerGen: 10}}
+		if err := st.Init(path, true, false); err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = st
+	}
+	test.LinearStores(t, 1, 300, stores...)
+}
+
+// Test that a Store refuses values larger than its MaxValueSize,
+// leaving its state unchanged.
+func TestMaxValueSize(t *testing.T) {
+	ctx := context.Background()
+	st := &Store{MaxValueSize: 4}
+	if err := st.Init(filepath.Join(t.TempDir(), "st"), true, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := st.CompareAndSet(ctx, "", "12345"); !errors.Is(err,
+		cas.ErrTooLarge) {
+		t.Fatalf("oversized value yielded %v", err)
+	}
+	ver, val, err := st.CompareAndSet(ctx, "", "1234")
+	if err != nil || ver != 1 || val != "1234" {
+		t.Errorf("set %v %q %v", ver, val, err)
+	}
+}
+
+// Test that a Store given an old value other than the one it last returned
+// compares it against the latest state rather than panicking.
+func TestWrongOld(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "st")
+	a, b := &Store{}, &Store{}
+	if err := a.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.CompareAndSet(ctx, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// b has not read "a", but it is the latest value.
+	ver, val, err := b.CompareAndSet(ctx, "a", "b")
+	if err != nil || ver != 2 || val != "b" {
+		t.Errorf("set %v %q %v", ver, val, err)
+	}
+
+	// "x" is not the latest value, so nothing changes.
+	ver, val, err = a.CompareAndSet(ctx, "x", "y")
+	if err != nil || ver != 2 || val != "b" {
+		t.Errorf("mismatched set %v %q %v", ver, val, err)
+	}
+
+	// A strict Store panics instead.
+	a.Strict = true
+	defer func() {
+		if recover() == nil {
+			t.Errorf("strict Store accepted a wrong old value")
+		}
+	}()
+	a.CompareAndSet(ctx, "x", "y")
+}
+
+// Test that a cas.Cached client following a Store
+// sees the changes another client makes without reading the Store.
+func TestFollow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "st")
+	st, other := &Store{}, &Store{}
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the cache, which would then serve this state for an hour.
+	c := &cas.Cached{Store: st, MaxStale: time.Hour}
+	if _, _, err := c.CompareAndSet(ctx, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Follow(ctx) }()
+
+	old, last := "", int64(0)
+	for i := 1; i <= 10; i++ {
+		new := fmt.Sprintf("value %d", i)
+		for old != new { // retry if other has yet to catch up
+			_, actual, err := other.CompareAndSet(ctx, old, new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old = actual
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			ver, val, err := c.CompareAndSet(ctx, new, new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val == new {
+				if ver <= last {
+					t.Errorf("version %v after %v", ver, last)
+				}
+				last = ver
+				break
+			}
+			select {
+			case err := <-done:
+				if err == verst.ErrWatchUnsupported {
+					t.Skip(err)
+				}
+				t.Fatalf("Follow yielded %v", err)
+			default:
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("cache stuck at %q, want %q", val, new)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Follow yielded %v", err)
+	}
+}
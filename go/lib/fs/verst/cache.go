@@ -0,0 +1,135 @@
+package verst
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long ago a directory must have last changed
+// before we trust its unchanged modification time
+// to mean that its contents are unchanged.
+// File systems may keep timestamps with coarse granularity,
+// so changes within the same tick as the last can leave it unchanged.
+var dirCacheSlack = time.Second
+
+// Cached result of scanning a directory for its highest-numbered entry.
+type dirCache struct {
+	path string    // Pathname of the directory scanned, or "" if none
+	mod  time.Time // Directory's modification time when scanned
+	ver  int64     // Version number of highest-numbered entry
+	name string    // Name of highest-numbered entry
+}
+
+// Scan a directory for its highest-numbered entry matching format, as scan does,
+// but only if the directory may have changed since we last scanned it
+// or if caching is disabled.
+// Returns true in hit if the result came from the cache.
+func (st *State) scanCached(c *dirCache, path, format string) (
+	ver int64, name string, hit bool, err error) {
+
+	if !st.Cache {
+		ver, name, _, err = scan(path, format, 0)
+		return ver, name, false, err
+	}
+
+	// A stat is much cheaper than reading the whole directory.
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, "", false, err
+	}
+	mod := fi.ModTime()
+	if c.path == path && mod.Equal(c.mod) {
+		return c.ver, c.name, true, nil
+	}
+
+	ver, name, _, err = scan(path, format, 0)
+	if err != nil {
+		return 0, "", false, err
+	}
+	c.path = ""
+	if time.Since(mod) > dirCacheSlack {
+		*c = dirCache{path, mod, ver, name}
+	}
+	return ver, name, false, nil
+}
+
+// State of a memory mapping of the current generation's log file.
+type logMap struct {
+	path string   // Pathname of the mapped log file
+	f    *os.File // Open log file, or nil if none
+	data []byte   // Mapped region, which may extend past the end of file
+}
+
+// Read the current generation's log from offset off onwards,
+// via a memory mapping of the log file if caching is enabled.
+// The returned slice is valid only until the next call.
+func (st *State) readLog(off int64) ([]byte, error) {
+	logPath := filepath.Join(st.genPath, logName)
+	if !st.Cache || !mmapSupported {
+		return readLogFile(logPath, off)
+	}
+
+	// Open and map the log when we move to a new generation.
+	m := &st.logMap
+	if m.path != logPath {
+		st.unmapLog()
+		f, err := os.Open(logPath)
+		if err != nil {
+			return nil, err
+		}
+		m.path, m.f = logPath, f
+	}
+
+	// Remap the log, leaving room for it to grow, when it outgrows the map.
+	// We never touch the mapping beyond the end of the file,
+	// which only ever grows.
+	fi, err := m.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size > int64(len(m.data)) {
+		data, err := mmap(m.f, int(2*size+mapSlack))
+		if err != nil {
+			return nil, err
+		}
+		munmap(m.data)
+		m.data = data
+	}
+	if off > size {
+		return nil, nil
+	}
+	return m.data[off:size], nil
+}
+
+// Extra room to map past the end of a log file, so that we rarely remap it.
+const mapSlack = 1 << 20
+
+// Read the record at offset off in the current generation's log,
+// via its memory mapping if caching is enabled.
+func (st *State) readLogRecord(off int64) (val, nextGen string, err error) {
+	if !st.Cache || !mmapSupported {
+		return readRecord(filepath.Join(st.genPath, logName), off)
+	}
+	b, err := st.readLog(off)
+	if err != nil {
+		return "", "", err
+	}
+	_, p, n := decodeRecord(b)
+	if n == 0 {
+		logPath := filepath.Join(st.genPath, logName)
+		return "", "", corruptRecord(logPath, off)
+	}
+	return decodeVer(p)
+}
+
+// Release the memory mapping of a log file, if any.
+func (st *State) unmapLog() {
+	m := &st.logMap
+	if m.f != nil {
+		munmap(m.data)
+		m.f.Close()
+	}
+	*m = logMap{}
+}
@@ -0,0 +1,87 @@
+package verst
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a caching State sees another State's writes,
+// with both layouts.
+func TestCache(t *testing.T) {
+	defer func(slack time.Duration) { dirCacheSlack = slack }(dirCacheSlack)
+	dirCacheSlack = 10 * time.Millisecond
+
+	for _, layout := range []Layout{LayoutFiles, LayoutLog} {
+		path := filepath.Join(t.TempDir(), "st")
+		w, r := &State{Layout: layout}, &State{Cache: true}
+		if err := w.Init(path, true, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Init(path, false, false); err != nil {
+			t.Fatal(err)
+		}
+
+		// Write enough versions to cross several generations,
+		// letting the reader cache the directories between writes.
+		for ver := int64(1); ver <= 3*DefaultVersPerGen; ver++ {
+			val := fmt.Sprint(ver)
+			if err := w.WriteVersion(ver, val); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * dirCacheSlack)
+			for i := 0; i < 2; i++ { // cache, then hit
+				rv, rval, err := r.ReadLatest()
+				if err != nil || rv != ver || rval != val {
+					t.Fatalf("layout %v: ReadLatest %v %q %v",
+						layout, rv, rval, err)
+				}
+			}
+			if r.genCache.path == "" {
+				t.Errorf("layout %v: state directory not cached",
+					layout)
+			}
+		}
+		if _, val, err := r.ReadAt(5); err != nil || val != "5" {
+			t.Errorf("layout %v: ReadAt(5): %q %v", layout, val, err)
+		}
+		r.unmapLog()
+	}
+}
+
+// Compare the cost of polling for the latest version
+// with and without caching.
+func BenchmarkReadLatest(b *testing.B) {
+	defer func(slack time.Duration) { dirCacheSlack = slack }(dirCacheSlack)
+	dirCacheSlack = 0 // nothing changes while we poll
+
+	for _, l := range []struct {
+		name   string
+		layout Layout
+	}{{"files", LayoutFiles}, {"log", LayoutLog}} {
+		for _, cache := range []bool{false, true} {
+			name := fmt.Sprintf("%v/cache=%v", l.name, cache)
+			b.Run(name, func(b *testing.B) {
+				st := &State{Layout: l.layout, Cache: cache}
+				path := filepath.Join(b.TempDir(), "st")
+				if err := st.Init(path, true, true); err != nil {
+					b.Fatal(err)
+				}
+				defer st.unmapLog()
+				for ver := int64(1); ver <= 25; ver++ {
+					err := st.WriteVersion(ver, "value")
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := st.ReadLatest(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package verst
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CompactStats reports the progress or results of a Compact operation.
+type CompactStats struct {
+	Generations int   // Generation directories deleted
+	Versions    int64 // Version files deleted
+	Bytes       int64 // Bytes of version files reclaimed
+}
+
+// Compact explicitly deletes all expired versions the State's Policy permits,
+// instead of relying on the opportunistic garbage collection
+// that WriteVersion performs when it starts a new generation.
+//
+// Compact deletes one generation directory at a time,
+// pausing for Policy.CompactPause after each one
+// to avoid I/O storms when compacting huge histories.
+// If progress is non-nil, Compact calls it with the cumulative statistics
+// after each generation it deletes.
+// Compact returns the final statistics and the first error encountered,
+// which is ctx.Err() if the context is cancelled before Compact completes.
+// Generations that other clients delete concurrently are silently skipped.
+//
+func (st *State) Compact(ctx context.Context, progress func(CompactStats)) (
+	stats CompactStats, err error) {
+
+	paths, err := st.collectible()
+	if err != nil {
+		return stats, err
+	}
+	for i, genPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		// Tally the generation's contents, which no longer change,
+		// then remove it atomically, as expireOld does.
+		vers, bytes, err := tally(genPath)
+		if err == nil {
+			err = atomicRemoveAll(genPath)
+		}
+		if IsNotExist(err) {
+			continue // someone else collected it
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Generations++
+		stats.Versions += vers
+		stats.Bytes += bytes
+		if progress != nil {
+			progress(stats)
+		}
+
+		// Rate-limit our deletions between generations
+		if st.Policy.CompactPause > 0 && i+1 < len(paths) {
+			t := time.NewTimer(st.Policy.CompactPause)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return stats, ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+	return stats, nil
+}
+
+// Count the version files or log records in a generation directory
+// and their total size.
+func tally(genPath string) (vers, bytes int64, err error) {
+	logPath := filepath.Join(genPath, logName)
+	if fi, err := os.Stat(logPath); err == nil && fi.Mode().IsRegular() {
+		return tallyLog(logPath)
+	}
+	dir, err := os.Open(genPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dir.Close()
+	info, err := dir.Readdir(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, fi := range info {
+		var ver int64
+		name := fi.Name()
+		n, err := fmt.Sscanf(name, verFormat, &ver)
+		if n == 1 && err == nil && name == fmt.Sprintf(verFormat, ver) &&
+			fi.Mode().IsRegular() {
+			vers++
+			bytes += fi.Size()
+		}
+	}
+	return vers, bytes, nil
+}
@@ -0,0 +1,122 @@
+package verst
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cbor"
+)
+
+// Version files, and the payloads of log records, hold a CBOR map
+// (RFC 8949) prefixed with the CBOR self-describe tag,
+// so that tools outside this package may read them.
+// The map has the following text-string keys:
+//
+//	"fmt"	unsigned integer format version, currently formatVersion
+//	"val"	byte string holding the version's value
+//	"next"	text string naming the next generation's temporary directory,
+//		present only in versions starting a new generation
+//
+// Readers ignore keys they do not recognize,
+// so later releases may add optional fields, such as checksums or TTLs,
+// without changing the format version.
+// The format version changes only when older readers
+// could no longer correctly interpret the fields they recognize,
+// in which case they reject the version with ErrFormat.
+//
+// Releases before formatVersion 1 encoded the value and next-generation name
+// as two consecutive cbe byte strings, with no schema version.
+// Readers still accept that format, recognizing it by the missing tag.
+//
+const formatVersion = 1
+
+// ErrFormat is returned when reading a version
+// written in a later format than this package supports.
+// Since this reader cannot interpret it, it is in the class cas.ErrCorrupt.
+var ErrFormat = cas.Classify(cas.ErrCorrupt,
+	errors.New("verst: unsupported version format"))
+
+// Decoding a malformed version yields errMalformed,
+// which is in the class cas.ErrCorrupt.
+var errMalformed = cas.Classify(cas.ErrCorrupt, cbor.ErrMalformed)
+
+// The self-describe tag that starts every version in the current format.
+var formatMagic = cbor.AppendTag(nil, cbor.SelfDescribe)
+
+// Encode a version's value and optional next-generation directory name.
+func encodeVer(val, nextGen string) []byte {
+	n := 2
+	if nextGen != "" {
+		n++
+	}
+	b := cbor.AppendMap(formatMagic[:len(formatMagic):len(formatMagic)], n)
+	b = cbor.AppendText(b, "fmt")
+	b = cbor.AppendUint(b, formatVersion)
+	b = cbor.AppendText(b, "val")
+	b = cbor.AppendBytes(b, []byte(val))
+	if nextGen != "" {
+		b = cbor.AppendText(b, "next")
+		b = cbor.AppendText(b, nextGen)
+	}
+	return b
+}
+
+// Decode a version's value and optional next-generation directory name.
+func decodeVer(b []byte) (val, nextGen string, err error) {
+	if !bytes.HasPrefix(b, formatMagic) {
+		return decodeOldVer(b)
+	}
+	n, b, err := cbor.ReadMap(b[len(formatMagic):])
+	if err != nil {
+		return "", "", errMalformed
+	}
+
+	var format uint64
+	var hasVal bool
+	for i := uint64(0); i < n; i++ {
+		var key string
+		if key, b, err = cbor.ReadText(b); err != nil {
+			return "", "", errMalformed
+		}
+		switch key {
+		case "fmt":
+			format, b, err = cbor.ReadUint(b)
+		case "val":
+			var p []byte
+			p, b, err = cbor.ReadBytes(b)
+			val, hasVal = string(p), true
+		case "next":
+			nextGen, b, err = cbor.ReadText(b)
+		default:
+			b, err = cbor.Skip(b) // a field from a later release
+		}
+		if err != nil {
+			return "", "", errMalformed
+		}
+	}
+	switch {
+	case format > formatVersion:
+		return "", "", ErrFormat
+	case format < 1 || !hasVal || len(b) != 0:
+		return "", "", errMalformed
+	}
+	return val, nextGen, nil
+}
+
+// Decode a version in the format preceding formatVersion 1.
+func decodeOldVer(b []byte) (val, nextGen string, err error) {
+
+	// The encoded value is always first and not optional
+	rb, b, err := cbe.Decode(b)
+	if err != nil {
+		return "", "", errMalformed
+	}
+
+	// The encoded next-generation directory name is optional
+	nxg, b, err := cbe.Decode(b)
+	// (ignore decoding errors)
+
+	return string(rb), string(nxg), nil
+}
@@ -0,0 +1,62 @@
+package verst
+
+import (
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/dedis/tlc/go/lib/cbor"
+)
+
+// Test that versions round-trip through the current format.
+func TestFormat(t *testing.T) {
+	for _, c := range []struct{ val, nextGen string }{
+		{"", ""},
+		{"hello", ""},
+		{"\x00\xff not UTF-8", "gen-10-123.tmp"},
+	} {
+		val, nextGen, err := decodeVer(encodeVer(c.val, c.nextGen))
+		if err != nil || val != c.val || nextGen != c.nextGen {
+			t.Errorf("decoded %q %q %v, want %q %q",
+				val, nextGen, err, c.val, c.nextGen)
+		}
+	}
+}
+
+// Test that versions written in the format before formatVersion 1
+// remain readable.
+func TestOldFormat(t *testing.T) {
+	b := cbe.Encode(nil, []byte("old value"))
+	b = cbe.Encode(b, []byte("gen-20-456.tmp"))
+	val, nextGen, err := decodeVer(b)
+	if err != nil || val != "old value" || nextGen != "gen-20-456.tmp" {
+		t.Errorf("decoded %q %q %v", val, nextGen, err)
+	}
+}
+
+// Encode a version in the current format with an extra field,
+// as a later release might add, and the given format version.
+func encodeFuture(format uint64, val string) []byte {
+	b := cbor.AppendTag(nil, cbor.SelfDescribe)
+	b = cbor.AppendMap(b, 3)
+	b = cbor.AppendText(b, "fmt")
+	b = cbor.AppendUint(b, format)
+	b = cbor.AppendText(b, "sum")
+	b = cbor.AppendMap(b, 1)
+	b = cbor.AppendText(b, "crc")
+	b = cbor.AppendUint(b, 12345)
+	b = cbor.AppendText(b, "val")
+	return cbor.AppendBytes(b, []byte(val))
+}
+
+// Test that readers ignore fields added by later releases,
+// but reject versions in later, incompatible formats.
+func TestFutureFormat(t *testing.T) {
+	val, nextGen, err := decodeVer(encodeFuture(formatVersion, "v"))
+	if err != nil || val != "v" || nextGen != "" {
+		t.Errorf("decoded %q %q %v", val, nextGen, err)
+	}
+	_, _, err = decodeVer(encodeFuture(formatVersion+1, "v"))
+	if err != ErrFormat {
+		t.Errorf("decoded later format with error %v", err)
+	}
+}
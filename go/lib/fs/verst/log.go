@@ -0,0 +1,506 @@
+package verst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dedis/tlc/go/lib/fs/atomic"
+)
+
+// Layout selects how a State stores versions in its state directory.
+//
+// LayoutFiles, the default, stores each version in a separate file
+// within its generation subdirectory,
+// relying on atomic file creation to resolve races between writers.
+//
+// LayoutLog instead appends each version as a record
+// to a single append-only log file in its generation subdirectory.
+// Writers append records using O_APPEND writes and wait for each
+// to reach stable storage before reading back the log,
+// in which the first record for each version takes precedence
+// over any later ones that concurrent writers append for the same version.
+// This layout avoids creating a file per version,
+// and is thus much faster for workloads with high version churn,
+// but relies on the file system to make each O_APPEND write atomic.
+// Local POSIX file systems do, but network file systems like NFS do not.
+//
+type Layout int
+
+const (
+	LayoutFiles Layout = iota // One file per version
+	LayoutLog                 // One append-only log file per generation
+)
+
+const logName = "log" // Name of the log file in each generation directory
+
+// Each log record consists of a fixed-length header followed by a payload
+// encoded the same way as a version file.
+// The header holds a magic string, the version number, the payload length,
+// and a CRC-32 checksum of the version number, length, and payload.
+const recMagic = "vlog"
+const recHeaderLen = 4 + 8 + 4 + 4
+
+// Encode a log record for version ver.
+func encodeRecord(ver int64, val, nextGen string) []byte {
+	p := encodeVer(val, nextGen)
+	b := make([]byte, recHeaderLen+len(p))
+	copy(b, recMagic)
+	binary.BigEndian.PutUint64(b[4:], uint64(ver))
+	binary.BigEndian.PutUint32(b[12:], uint32(len(p)))
+	copy(b[recHeaderLen:], p)
+	crc := crc32.ChecksumIEEE(b[4:16])
+	crc = crc32.Update(crc, crc32.IEEETable, p)
+	binary.BigEndian.PutUint32(b[16:], crc)
+	return b
+}
+
+// Decode the log record at the start of b,
+// returning its version number, payload, and encoded length,
+// or a zero length if b does not start with a complete, valid record.
+func decodeRecord(b []byte) (ver int64, p []byte, n int) {
+	if len(b) < recHeaderLen || string(b[:4]) != recMagic {
+		return 0, nil, 0
+	}
+	l := binary.BigEndian.Uint32(b[12:])
+	if uint64(l) > uint64(len(b)-recHeaderLen) {
+		return 0, nil, 0
+	}
+	n = recHeaderLen + int(l)
+	p = b[recHeaderLen:n]
+	crc := crc32.ChecksumIEEE(b[4:16])
+	crc = crc32.Update(crc, crc32.IEEETable, p)
+	if crc != binary.BigEndian.Uint32(b[16:]) {
+		return 0, nil, 0
+	}
+	return int64(binary.BigEndian.Uint64(b[4:])), p, n
+}
+
+// Parse the log records in b, which starts at offset off in its log file,
+// calling f with each valid record's version number, payload, and offset.
+// Returns the offset just past the last complete record.
+//
+// A crash may leave a partial record in the log
+// followed by the records of other writers,
+// so we skip over invalid data if a valid record follows it.
+// Otherwise the invalid data may be a record that a concurrent writer
+// is still appending, so we stop there and look again later.
+//
+func parseLog(b []byte, off int64, f func(ver int64, p []byte, off int64)) int64 {
+	i := 0
+	for i < len(b) {
+		ver, p, n := decodeRecord(b[i:])
+		if n == 0 {
+			j := resyncLog(b, i+1)
+			if j < 0 {
+				break
+			}
+			i = j
+			continue
+		}
+		f(ver, p, off+int64(i))
+		i += n
+	}
+	return off + int64(i)
+}
+
+// Return the position of the first valid record in b at or after i,
+// or -1 if there is none.
+func resyncLog(b []byte, i int) int {
+	for i < len(b) {
+		j := bytes.Index(b[i:], []byte(recMagic))
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if _, _, n := decodeRecord(b[i:]); n > 0 {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// Index the log records in b, from the log of generation genVer,
+// recording in index the offset of the record for each version
+// and calling f with each newly-indexed version and payload.
+// Records for versions before genVer are stale and ignored,
+// and the first record for each version takes precedence over later ones.
+// Returns the offset just past the last complete record, as parseLog does.
+func indexLog(index map[int64]int64, genVer int64, b []byte, off int64,
+	f func(ver int64, p []byte)) int64 {
+
+	return parseLog(b, off, func(ver int64, p []byte, off int64) {
+		if _, ok := index[ver]; ok || ver < genVer {
+			return
+		}
+		index[ver] = off
+		f(ver, p)
+	})
+}
+
+// Create the log file in a new generation directory,
+// holding the record of the version that starts the generation.
+func writeLogFile(genPath string, ver int64, val, nextGen string) error {
+	logPath := filepath.Join(genPath, logName)
+	return atomic.WriteFileOnce(logPath, encodeRecord(ver, val, nextGen),
+		0644)
+}
+
+// Read the contents of a log file from offset off onwards.
+func readLogFile(logPath string, off int64) ([]byte, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// Read the log record at offset off in a log file.
+func readRecord(logPath string, off int64) (val, nextGen string, err error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	var hdr [recHeaderLen]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return "", "", err
+	}
+	b := make([]byte, recHeaderLen+int(binary.BigEndian.Uint32(hdr[12:])))
+	if _, err := f.ReadAt(b, off); err != nil {
+		return "", "", err
+	}
+	_, p, n := decodeRecord(b)
+	if n == 0 {
+		return "", "", corruptRecord(logPath, off)
+	}
+	return decodeVer(p)
+}
+
+// Return the error for a log record at offset off in a log file
+// that fails to decode, which is in the class cas.ErrCorrupt.
+func corruptRecord(logPath string, off int64) error {
+	return fmt.Errorf("verst: log file %s: record at offset %d: %w",
+		logPath, off, errMalformed)
+}
+
+// Determine the layout of an existing state directory
+// from its highest-numbered generation subdirectory.
+func (st *State) detectLayout() error {
+	_, genName, _, err := scan(st.path, genFormat, 0)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Join(st.path, genName, logName))
+	switch {
+	case err == nil:
+		st.Layout = LayoutLog
+	case IsNotExist(err):
+		st.Layout = LayoutFiles
+	default:
+		return err
+	}
+	return nil
+}
+
+// Make generation genVer our current generation for reading and writing logs.
+func (st *State) openGen(genVer int64) {
+	st.genVer = genVer
+	st.genPath = filepath.Join(st.path, fmt.Sprintf(genFormat, genVer))
+	st.index = make(map[int64]int64)
+	st.logOff = 0
+	st.genNext = 0
+}
+
+// Index any records appended to our current generation's log
+// since we last looked.
+func (st *State) updateLog() error {
+	b, err := st.readLog(st.logOff)
+	if err != nil {
+		return err
+	}
+	st.logOff = indexLog(st.index, st.genVer, b, st.logOff,
+		func(ver int64, p []byte) {
+			val, nextGen, err := decodeVer(p)
+			if err != nil {
+				return
+			}
+			if ver > st.ver {
+				st.ver, st.val = ver, val
+			}
+			if nextGen != "" && ver > st.genVer && st.genNext == 0 {
+				st.genNext = ver // the next generation starts here
+			}
+		})
+	return nil
+}
+
+// Refresh our cached state from the highest generation's log.
+// As in refresh, start over if others expire that generation meanwhile.
+func (st *State) refreshLog() error {
+	for {
+		genVer, _, _, err := st.scanCached(&st.genCache,
+			st.path, genFormat)
+		if err != nil && IsNotExist(err) && exists(st.path) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if st.index == nil || genVer != st.genVer {
+			st.openGen(genVer)
+		}
+		err = st.updateLog()
+		if err != nil && IsNotExist(err) && !exists(st.genPath) {
+			st.index = nil
+			continue
+		}
+		return err
+	}
+}
+
+// Return the pathname and index of generation genVer's log file,
+// indexing our current generation's log incrementally
+// but any other generation's log from scratch.
+func (st *State) genLog(genVer int64) (string, map[int64]int64, error) {
+	if st.index != nil && genVer == st.genVer {
+		logPath := filepath.Join(st.genPath, logName)
+		return logPath, st.index, st.updateLog()
+	}
+	genPath := filepath.Join(st.path, fmt.Sprintf(genFormat, genVer))
+	logPath := filepath.Join(genPath, logName)
+	b, err := readLogFile(logPath, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	index := make(map[int64]int64)
+	indexLog(index, genVer, b, 0, func(int64, []byte) {})
+	return logPath, index, nil
+}
+
+// Find the generation that would contain version ver.
+func (st *State) findGen(ver int64) (int64, error) {
+	if ver == 0 {
+		return 0, nil // a zero upTo argument would mean no limit
+	}
+	genVer, _, _, err := scan(st.path, genFormat, ver)
+	return genVer, err
+}
+
+// Read version ver from the log, as readUncached does for version files.
+func (st *State) readLogUncached(ver int64) (string, error) {
+
+	// Optimize for reads from our current generation
+	if ver >= st.genVer {
+		if err := st.updateLog(); err != nil && !IsNotExist(err) {
+			return "", err
+		}
+		if off, ok := st.index[ver]; ok {
+			val, _, err := st.readLogRecord(off)
+			return val, err
+		}
+	}
+
+	// Fallback: scan for the generation containing requested version,
+	// moving to it if it is newer than our current generation.
+	genVer, err := st.findGen(ver)
+	if err != nil {
+		return "", err
+	}
+	if genVer > st.genVer {
+		st.openGen(genVer)
+	}
+	logPath, index, err := st.genLog(genVer)
+	if err != nil {
+		return "", err
+	}
+	off, ok := index[ver]
+	if !ok {
+		return "", ErrNotExist
+	}
+	val, _, err := readRecord(logPath, off)
+	return val, err
+}
+
+// Read the latest version at or before ver from the log, as ReadAt does.
+func (st *State) readLogAt(ver int64) (actual int64, val string, err error) {
+	genVer, err := st.findGen(ver)
+	if err != nil {
+		return 0, "", err
+	}
+	logPath, index, err := st.genLog(genVer)
+	if err != nil {
+		return 0, "", err
+	}
+	actual = -1
+	for v := range index {
+		if v <= ver && v > actual {
+			actual = v
+		}
+	}
+	if actual < 0 {
+		return 0, "", ErrNotExist
+	}
+	val, _, err = readRecord(logPath, index[actual])
+	if err != nil {
+		return 0, "", err
+	}
+	return actual, val, nil
+}
+
+// List the versions from through to inclusive in generation logs,
+// as listFiles does for version files.
+func (st *State) listLog(from, to int64) ([]int64, error) {
+	gens, err := list(st.path, genFormat)
+	if err != nil {
+		return nil, err
+	}
+	var vers []int64
+	for i, gen := range gens {
+		if gen > to || (i+1 < len(gens) && gens[i+1] <= from) {
+			continue // Generation can't contain versions of interest
+		}
+		_, index, err := st.genLog(gen)
+		if err != nil && !IsNotExist(err) {
+			return nil, err // error other than concurrent expiry
+		}
+		for v := range index {
+			if v >= from && v <= to {
+				vers = append(vers, v)
+			}
+		}
+	}
+	return vers, nil
+}
+
+// Write version ver to the log, as WriteVersion does for version files,
+// using the same protocol to start new generations.
+func (st *State) writeLog(ver int64, val string) (err error) {
+
+	// Make sure we're appending to the latest generation,
+	// which the last version we know of may have started.
+	if err := st.nextGen(); err != nil {
+		return err
+	}
+
+	// Should this register version start a new generation?
+	tmpGenName := ""
+	if ver%st.Policy.VersPerGen == 0 {
+
+		// Prepare the new generation in a temporary directory first
+		pattern := fmt.Sprintf(genFormat+"-*.tmp", ver)
+		tmpPath, err := ioutil.TempDir(st.path, pattern)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			os.RemoveAll(tmpPath)
+		}()
+		tmpGenName = filepath.Base(tmpPath)
+
+		// Write the new register version in the new directory's log
+		err = writeLogFile(tmpPath, ver, val, tmpGenName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Append the new register version to the (old) generation's log,
+	// and wait for it to reach stable storage.
+	logPath := filepath.Join(st.genPath, logName)
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(encodeRecord(ver, val, tmpGenName))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Read back whatever record for the version actually came first,
+	// which might be from someone else's write that won over ours.
+	if err := st.updateLog(); err != nil {
+		return err
+	}
+	off, ok := st.index[ver]
+	if !ok {
+		return ErrNotExist // our append isn't visible!?
+	}
+	val, _, err = st.readLogRecord(off)
+	if err != nil {
+		return err
+	}
+
+	// If the (actual) new version starts a new generation, move to it.
+	if err := st.nextGen(); err != nil {
+		return err
+	}
+
+	// Update our cached version state
+	if ver > st.ver {
+		st.ver = ver
+		st.val = val
+	}
+
+	// Record the version actually written in the audit log, if any
+	if st.Audit != nil {
+		return st.Audit.Record(ver, val)
+	}
+	return nil
+}
+
+// If our current generation's log holds a version
+// that indicates a new generation directory,
+// try to move its temporary directory into place, and move to it.
+// As in WriteVersion, it's harmless if multiple writers attempt this:
+// it fails if either the old temporary directory no longer exists
+// or if a directory with the new name already exists.
+func (st *State) nextGen() error {
+	for st.genNext > 0 {
+		ver := st.genNext
+		_, tmpGenName, err := st.readLogRecord(st.index[ver])
+		if err != nil {
+			return err
+		}
+		oldGenPath := filepath.Join(st.path, tmpGenName)
+		newGenPath := filepath.Join(st.path, fmt.Sprintf(genFormat, ver))
+		err = os.Rename(oldGenPath, newGenPath)
+		if err != nil && !IsExist(err) && !IsNotExist(err) {
+			return err
+		}
+
+		// It's a good time to expire old generations when feasible
+		st.expireOld()
+
+		st.openGen(ver)
+		if err := st.updateLog(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count the records in a log file and its total size.
+func tallyLog(logPath string) (vers, bytes int64, err error) {
+	b, err := readLogFile(logPath, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	parseLog(b, 0, func(int64, []byte, int64) { vers++ })
+	return vers, int64(len(b)), nil
+}
@@ -0,0 +1,22 @@
+package verst
+
+import (
+	"os"
+	"syscall"
+)
+
+const mmapSupported = true
+
+// Map the first size bytes of file f read-only into memory.
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ,
+		syscall.MAP_SHARED)
+}
+
+// Unmap a region that mmap mapped, if any.
+func munmap(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	return syscall.Munmap(b)
+}
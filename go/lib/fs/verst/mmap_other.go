@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package verst
+
+import (
+	"errors"
+	"os"
+)
+
+// Memory-mapped reads are currently implemented only on Linux,
+// so elsewhere we read log files with ordinary file reads.
+const mmapSupported = false
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("verst: mmap unsupported")
+}
+
+func munmap(b []byte) error {
+	return nil
+}
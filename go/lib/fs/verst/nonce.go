@@ -0,0 +1,48 @@
+package verst
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/dedis/tlc/go/lib/fs/atomic"
+)
+
+const nonceName = "nonce" // Name of the nonce file in the state directory
+
+// Nonce returns a random nonce identifying this incarnation
+// of the state directory, which Init creates along with the directory.
+// If the directory is lost and recreated, even at the same path,
+// its nonce changes, so clients that recorded the old nonce
+// can detect that the state has lost its memory.
+// A state directory created before nonces existed gains one
+// the first time any client asks for it.
+//
+func (st *State) Nonce() (string, error) {
+	name := filepath.Join(st.path, nonceName)
+	for {
+		b, err := ioutil.ReadFile(name)
+		if err == nil {
+			return string(b), nil
+		}
+		if !IsNotExist(err) {
+			return "", err
+		}
+		err = writeNonce(st.path)
+		if err != nil && !IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// Write a fresh random nonce into a state directory,
+// failing with ErrExist if it already has one.
+func writeNonce(path string) error {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	return atomic.WriteFileOnce(filepath.Join(path, nonceName),
+		[]byte(hex.EncodeToString(b[:])), 0444)
+}
@@ -0,0 +1,52 @@
+package verst
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNonce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "st")
+	st := &State{}
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	n1, err := st.Nonce()
+	if err != nil || n1 == "" {
+		t.Fatalf("Nonce: %q %v", n1, err)
+	}
+
+	// Another client of the same directory sees the same nonce.
+	st2 := &State{}
+	if err := st2.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st2.Nonce(); err != nil || n != n1 {
+		t.Errorf("second client saw nonce %q %v", n, err)
+	}
+
+	// A directory lost and recreated at the same path has a new nonce.
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	n2, err := st.Nonce()
+	if err != nil || n2 == "" || n2 == n1 {
+		t.Errorf("recreated directory has nonce %q %v", n2, err)
+	}
+
+	// A directory without a nonce gains one, once.
+	if err := os.Remove(filepath.Join(path, nonceName)); err != nil {
+		t.Fatal(err)
+	}
+	n3, err := st.Nonce()
+	if err != nil || n3 == "" || n3 == n2 {
+		t.Errorf("directory without nonce gained %q %v", n3, err)
+	}
+	if n, err := st2.Nonce(); err != nil || n != n3 {
+		t.Errorf("second client saw nonce %q %v", n, err)
+	}
+}
@@ -0,0 +1,99 @@
+package verst
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultPinLease is the default time a pin remains in effect
+// without being renewed.
+const DefaultPinLease = time.Minute
+
+const pinFormat = "pin-%d-" // Format for pin file name prefixes
+
+// Pin prevents garbage collection of version ver and all later versions,
+// by any client sharing the state directory,
+// until the caller calls Unpin or the pin's lease lapses:
+// see Policy.PinLease.
+// Pinning a version this State already pins renews its lease.
+//
+// Pins protect slower readers, such as clients reading historical versions,
+// from racing with garbage collection.
+// A pin takes effect for collections that start after Pin returns:
+// a collection already under way may still remove the pinned version,
+// which subsequent reads then report as not existing.
+//
+func (st *State) Pin(ver int64) error {
+	if name, ok := st.pins[ver]; ok {
+		now := time.Now()
+		return os.Chtimes(filepath.Join(st.path, name), now, now)
+	}
+
+	// Record the pin in a uniquely-named file in the state directory.
+	f, err := ioutil.TempFile(st.path, fmt.Sprintf(pinFormat, ver))
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if st.pins == nil {
+		st.pins = make(map[int64]string)
+	}
+	st.pins[ver] = filepath.Base(f.Name())
+	return nil
+}
+
+// Unpin releases a pin this State holds on version ver.
+// Returns ErrNotExist if this State holds no such pin.
+func (st *State) Unpin(ver int64) error {
+	name, ok := st.pins[ver]
+	if !ok {
+		return ErrNotExist
+	}
+	delete(st.pins, ver)
+	err := os.Remove(filepath.Join(st.path, name))
+	if err != nil && !IsNotExist(err) { // lapsed pins may be removed
+		return err
+	}
+	return nil
+}
+
+// Return the earliest version any client's live pin protects,
+// or -1 if there are no live pins,
+// removing any lapsed pins we find along the way.
+func (st *State) pinned() (int64, error) {
+	dir, err := os.Open(st.path)
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	info, err := dir.Readdir(0)
+	if err != nil {
+		return 0, err
+	}
+
+	lease := st.Policy.PinLease
+	if lease <= 0 {
+		lease = DefaultPinLease
+	}
+	min := int64(-1)
+	for _, fi := range info {
+		var ver int64
+		n, err := fmt.Sscanf(fi.Name(), pinFormat, &ver)
+		if n < 1 || err != nil {
+			continue
+		}
+		if time.Since(fi.ModTime()) > lease {
+			os.Remove(filepath.Join(st.path, fi.Name()))
+			continue
+		}
+		if min < 0 || ver < min {
+			min = ver
+		}
+	}
+	return min, nil
+}
@@ -48,37 +48,118 @@
 package verst
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	//	"errors"
+	"sort"
+	"time"
 
-	"github.com/bford/cofo/cbe"
+	"github.com/dedis/tlc/go/lib/cas"
 	"github.com/dedis/tlc/go/lib/fs/atomic"
+	"github.com/dedis/tlc/go/lib/fs/audit"
 )
 
-//const versPerGen = 100 // Number of versions between generation subdirectories
-const versPerGen = 10 // Number of versions between generation subdirectories
+// DefaultVersPerGen is the default number of versions per generation
+const DefaultVersPerGen = 10
 
 const genFormat = "gen-%d" // Format for generation directory names
 const verFormat = "ver-%d" // Format for register version file names
 
+// Policy configures how verst garbage-collects expired state versions,
+// trading disk usage against the availability of past versions.
+//
+// VersPerGen is the number of versions between generation subdirectories,
+// which are the unit of garbage collection.
+// Larger generations mean less frequent but coarser collection.
+// All clients sharing a state directory should use the same VersPerGen.
+//
+// MinVersions is the minimum number of versions before the latest
+// that verst retains, regardless of how far the client expires state.
+// MinAge is the minimum time verst retains a generation
+// after the last version was written into it.
+// PinLease is the time a pin made by State.Pin protects versions
+// unless renewed, so that crashed clients don't block collection forever,
+// or zero for DefaultPinLease.
+// CompactPause is the time State.Compact pauses
+// after deleting each generation, to limit its I/O load.
+//
+// The zero Policy yields DefaultVersPerGen and no extra retention.
+//
+type Policy struct {
+	VersPerGen  int64         // Versions between generation subdirectories
+	MinVersions int64         // Minimum past versions to retain
+	MinAge      time.Duration // Minimum time to retain past versions
+	PinLease    time.Duration // Time a pin lasts without renewal
+
+	CompactPause time.Duration // Pause between deletions in Compact
+}
+
 // State holds cached state for a single verst versioned register.
+//
+// If Audit is non-nil, State records each version it writes successfully,
+// or finds already written by someone else, in this audit log.
+//
+// The Policy field configures garbage collection of expired versions.
+// It must be set before calling Init, and not changed thereafter.
+//
+// The Layout field selects how Init lays out a newly-created state directory.
+// When Init opens an existing state directory instead,
+// it sets Layout to the layout that directory was created with.
+//
+// If Cache is true, the State caches directory listings
+// for as long as directories' modification times show them unchanged,
+// and reads log files via memory mappings where supported,
+// substantially reducing the system calls that reads make,
+// especially repeated reads of the latest version by polling clients.
+// Caching relies on the file system updating directory modification times
+// and, with network file systems,
+// on client and server clocks being roughly synchronized.
+// Cache must be set before calling Init, and not changed thereafter.
+//
+// MaxValueSize optionally limits the size in bytes of the values
+// that WriteVersion accepts, or is zero for no limit.
+// WriteVersion returns a cas.SizeError for larger values.
+//
 type State struct {
-	path    string  // Base pathname of directory containing register state
-	genVer  int64 // Version number of highest generation subdirectory
-	genPath string  // Pathname to generation subdirectory
-	ver     int64 // Highest register version known to exist already
-	val     string  // Cached register value for highest known version
-	expVer  int64 // Version number before which state is expired
+	Audit        *audit.Log // Optional audit log of versions written
+	Policy       Policy     // Garbage collection policy
+	Layout       Layout     // Layout of the state directory
+	Cache        bool       // Cache directory listings and map log files
+	MaxValueSize int        // Maximum value size in bytes, or 0
+
+	path    string // Base pathname of directory containing register state
+	genVer  int64  // Version number of highest generation subdirectory
+	genPath string // Pathname to generation subdirectory
+	ver     int64  // Highest register version known to exist already
+	val     string // Cached register value for highest known version
+	expVer  int64  // Version number before which state is expired
+	watch   *watch // Directory watch state, if watching
+
+	pins map[int64]string // Names of pin files we hold, by version
+
+	index   map[int64]int64 // Offsets of versions in generation's log file
+	logOff  int64           // Offset up to which we've indexed the log
+	genNext int64           // Version starting the next generation, if known
+
+	genCache dirCache // Cached scan of the state directory
+	verCache dirCache // Cached scan of the current generation directory
+	logMap   logMap   // Memory mapping of the current generation's log
 }
 
 // Initialize State to refer to a verst register at a given file system path.
 // If create is true, create the designated directory if it doesn't exist.
 // If excl is true, fail if the designated directory already exists.
 func (st *State) Init(path string, create, excl bool) error {
-	*st = State{path: path} // Set path and clear cached state
+	// Clear cached state, keeping only our configuration
+	st.Unwatch()
+	st.unmapLog()
+	*st = State{Audit: st.Audit, Policy: st.Policy, Layout: st.Layout,
+		Cache: st.Cache, MaxValueSize: st.MaxValueSize, path: path}
+	if st.Policy.VersPerGen <= 0 {
+		st.Policy.VersPerGen = DefaultVersPerGen
+	}
 
 	// First check if the path already exists and is a directory.
 	stat, err := os.Stat(path)
@@ -86,8 +167,11 @@ func (st *State) Init(path string, create, excl bool) error {
 	case err == nil && !stat.IsDir():
 		return os.ErrExist // already exists, but not a directory
 
-	case err == nil && !excl:
-		return st.refresh() // exists: load our cache from it
+	case err == nil && !excl: // exists: load our cache from it
+		if err := st.detectLayout(); err != nil {
+			return err
+		}
+		return st.refresh()
 
 	case err != nil && (!IsNotExist(err) || !create):
 		return err // didn't exist and we can't create it
@@ -107,6 +191,11 @@ func (st *State) Init(path string, create, excl bool) error {
 		os.RemoveAll(tmpPath)
 	}()
 
+	// Give this incarnation of the state directory its nonce
+	if err := writeNonce(tmpPath); err != nil {
+		return err
+	}
+
 	// Create an initial generation directory for state version 0
 	genPath := filepath.Join(tmpPath, fmt.Sprintf(genFormat, 0))
 	err = os.Mkdir(genPath, 0777)
@@ -115,7 +204,11 @@ func (st *State) Init(path string, create, excl bool) error {
 	}
 
 	// Create an initial state version 0 with the empty string as its value
-	err = writeVerFile(genPath, fmt.Sprintf(verFormat, 0), "", "")
+	if st.Layout == LayoutLog {
+		err = writeLogFile(genPath, 0, "", "")
+	} else {
+		err = writeVerFile(genPath, fmt.Sprintf(verFormat, 0), "", "")
+	}
 	if err != nil {
 		return err
 	}
@@ -126,7 +219,11 @@ func (st *State) Init(path string, create, excl bool) error {
 		return err
 	}
 
-	// Finally, load our cache from the state directory.
+	// Finally, load our cache from the state directory,
+	// which someone else may have created with a different layout.
+	if err := st.detectLayout(); err != nil {
+		return err
+	}
 	return st.refresh()
 }
 
@@ -135,33 +232,60 @@ func (st *State) Init(path string, create, excl bool) error {
 // Of course the file system may be a constantly-moving target
 // so the refreshed state could be stale again immediately on return.
 func (st *State) refresh() error {
+	if st.Layout == LayoutLog {
+		return st.refreshLog()
+	}
+
+	for {
+		// First find the highest-numbered state generation subdirectory.
+		// A directory scan is not atomic, so it might miss generations
+		// that others are concurrently creating and expiring,
+		// but there is always at least one while the state exists.
+		genver, genname, genHit, err := st.scanCached(&st.genCache,
+			st.path, genFormat)
+		if err != nil && IsNotExist(err) && exists(st.path) {
+			continue
+		} else if err != nil {
+			return err
+		}
 
-	// First find the highest-numbered state generation subdirectory
-	genver, genname, _, err := scan(st.path, genFormat, 0)
-	if err != nil {
-		return err
-	}
+		// Then find the highest-numbered register version in it.
+		// Other clients may advance and expire that generation meanwhile,
+		// in which case we just start over from the newer generation.
+		genpath := filepath.Join(st.path, genname)
+		regver, regname, verHit, err := st.scanCached(&st.verCache,
+			genpath, verFormat)
+		if err != nil && IsNotExist(err) && !exists(genpath) {
+			continue
+		} else if err != nil {
+			return err
+		}
 
-	// Then find the highest-numbered register version in that subdirectory
-	genpath := filepath.Join(st.path, genname)
-	regver, regname, _, err := scan(genpath, verFormat, 0)
-	if err != nil {
-		return err
-	}
+		// If neither directory has changed,
+		// we already have the latest version.
+		if genHit && verHit && genver == st.genVer && regver == st.ver {
+			return nil
+		}
 
-	// Read that highest register version file
-	val, _, err := readVerFile(genpath, regname)
-	if err != nil {
-		return err
-	}
+		// Read that highest register version file
+		val, tmpGenName, err := readVerFile(genpath, regname)
+		if err != nil && IsNotExist(err) && !exists(genpath) {
+			continue
+		} else if err != nil {
+			return err
+		}
 
-	st.genVer = genver
-	st.genPath = genpath
+		st.genVer = genver
+		st.genPath = genpath
 
-	st.ver = regver
-	st.val = val
+		st.ver = regver
+		st.val = val
 
-	return nil
+		// If that version started a new generation that its writer
+		// has not yet moved into place, finish the job before we write
+		// any later versions, so that we don't write them into this one.
+		return st.moveGen(regver, tmpGenName)
+	}
 }
 
 // Scan a directory for highest-numbered file or subdirectory matching format.
@@ -209,6 +333,38 @@ func scan(path, format string, upTo int64) (
 	return
 }
 
+// Return true if a file or directory exists at path.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// List the version numbers of all files or subdirectories matching format
+// in a directory, in increasing order.
+func list(path, format string) ([]int64, error) {
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var vers []int64
+	for _, name := range names {
+		var ver int64
+		n, err := fmt.Sscanf(name, format, &ver)
+		if n == 1 && err == nil && name == fmt.Sprintf(format, ver) {
+			vers = append(vers, ver)
+		}
+	}
+	sort.Slice(vers, func(i, j int) bool { return vers[i] < vers[j] })
+	return vers, nil
+}
+
 // Read and parse the register version file at regpath.
 func readVerFile(genPath, verName string) (val, nextGen string, err error) {
 
@@ -218,18 +374,12 @@ func readVerFile(genPath, verName string) (val, nextGen string, err error) {
 		return "", "", err
 	}
 
-	// The encoded value is always first and not optional
-	rb, b, err := cbe.Decode(b)
+	val, nextGen, err = decodeVer(b)
 	if err != nil {
-		println("corrupt verst version file " + regPath)
-		return "", "", err
+		return "", "", fmt.Errorf("verst: version file %s: %w",
+			regPath, err)
 	}
-
-	// The encoded next-generation directory name is optional
-	nxg, b, err := cbe.Decode(b)
-	// (ignore decoding errors)
-
-	return string(rb), string(nxg), nil
+	return val, nextGen, nil
 }
 
 // Read the latest version of the stored state,
@@ -238,7 +388,7 @@ func readVerFile(genPath, verName string) (val, nextGen string, err error) {
 // so the caller must assume this information could become stale immediately.
 func (st *State) ReadLatest() (ver int64, val string, err error) {
 
-	if err := st.refresh(); err != nil {
+	if err := st.update(); err != nil {
 		return 0, "", err
 	}
 	return st.ver, st.val, nil
@@ -247,7 +397,8 @@ func (st *State) ReadLatest() (ver int64, val string, err error) {
 // Read a specific version of the stored state,
 // returning the associated value if possible.
 // Returns ErrNotExist if the specified version does not exist,
-// either because it has never been written or because it has been expired.
+// or ErrExpired, which also matches IsNotExist,
+// if a later version exists and this one has been skipped or expired.
 func (st *State) ReadVersion(ver int64) (val string, err error) {
 
 	// In the common case of reading back the last-written version,
@@ -259,7 +410,7 @@ func (st *State) ReadVersion(ver int64) (val string, err error) {
 	// Find and read the appropriate version file
 	val, err = st.readUncached(ver)
 	if err != nil {
-		return "", err
+		return "", st.expired(ver, err)
 	}
 
 	// Update our cached state as appropriate.
@@ -271,7 +422,121 @@ func (st *State) ReadVersion(ver int64) (val string, err error) {
 	return val, nil
 }
 
+// Read the latest version of the stored state at or before version ver,
+// returning both that version's number and its associated value.
+// Since writers may skip version numbers,
+// the version returned may be earlier than the one requested.
+// Returns ErrNotExist if there is no such version,
+// or ErrExpired if it has been expired and garbage collected.
+//
+func (st *State) ReadAt(ver int64) (actual int64, val string, err error) {
+	actual, val, err = st.readAt(ver)
+	if err != nil && ver >= 0 {
+		err = st.expired(ver, err)
+	}
+	return actual, val, err
+}
+
+func (st *State) readAt(ver int64) (actual int64, val string, err error) {
+
+	// Requests at or beyond the latest version read the latest version.
+	if ver >= st.ver {
+		if err := st.update(); err != nil {
+			return 0, "", err
+		}
+		if ver >= st.ver {
+			return st.ver, st.val, nil
+		}
+	}
+	if ver < 0 {
+		return 0, "", ErrNotExist
+	}
+	if st.Layout == LayoutLog {
+		return st.readLogAt(ver)
+	}
+
+	// Find the generation that would contain version ver,
+	// then the highest version no later than ver within that generation.
+	// (A zero upTo argument means no limit to scan, so special-case it.)
+	genName, verName := fmt.Sprintf(genFormat, 0), fmt.Sprintf(verFormat, 0)
+	if ver > 0 {
+		_, genName, _, err = scan(st.path, genFormat, ver)
+		if err != nil {
+			return 0, "", err
+		}
+		genPath := filepath.Join(st.path, genName)
+		actual, verName, _, err = scan(genPath, verFormat, ver)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	val, _, err = readVerFile(filepath.Join(st.path, genName), verName)
+	if err != nil {
+		return 0, "", err
+	}
+	return actual, val, nil
+}
+
+// List the version numbers from through to inclusive
+// that currently exist in the stored state, in increasing order.
+// Versions may be garbage collected concurrently, so the caller must be
+// prepared for subsequent attempts to read listed versions to fail.
+//
+func (st *State) ListVersions(from, to int64) ([]int64, error) {
+
+	list := st.listFiles
+	if st.Layout == LayoutLog {
+		list = st.listLog
+	}
+	vers, err := list(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions starting a generation appear in two places.
+	sort.Slice(vers, func(i, j int) bool { return vers[i] < vers[j] })
+	uniq := vers[:0]
+	for _, v := range vers {
+		if len(uniq) == 0 || v != uniq[len(uniq)-1] {
+			uniq = append(uniq, v)
+		}
+	}
+	return uniq, nil
+}
+
+// List the versions from through to inclusive in generation directories,
+// in no particular order.
+func (st *State) listFiles(from, to int64) ([]int64, error) {
+
+	// List all the generation directories that might hold these versions,
+	// then all the version files in each one.
+	gens, err := list(st.path, genFormat)
+	if err != nil {
+		return nil, err
+	}
+	var vers []int64
+	for i, gen := range gens {
+		if gen > to || (i+1 < len(gens) && gens[i+1] <= from) {
+			continue // Generation can't contain versions of interest
+		}
+		genPath := filepath.Join(st.path, fmt.Sprintf(genFormat, gen))
+		gvers, err := list(genPath, verFormat)
+		if err != nil && !IsNotExist(err) {
+			return nil, err // error other than concurrent expiry
+		}
+		for _, v := range gvers {
+			if v >= from && v <= to {
+				vers = append(vers, v)
+			}
+		}
+	}
+	return vers, nil
+}
+
 func (st *State) readUncached(ver int64) (val string, err error) {
+	if st.Layout == LayoutLog {
+		return st.readLogUncached(ver)
+	}
 
 	// Optimize for sequential reads of the "next" version
 	verName := fmt.Sprintf(verFormat, ver)
@@ -316,14 +581,20 @@ func (st *State) readUncached(ver int64) (val string, err error) {
 //
 func (st *State) WriteVersion(ver int64, val string) (err error) {
 
+	if err := cas.CheckSize(val, st.MaxValueSize); err != nil {
+		return err
+	}
 	if ver <= st.ver {
 		return ErrExist
 	}
+	if st.Layout == LayoutLog {
+		return st.writeLog(ver, val)
+	}
 	verName := fmt.Sprintf(verFormat, ver)
 
 	// Should this register version start a new generation?
 	tmpGenName := ""
-	if ver%versPerGen == 0 {
+	if ver%st.Policy.VersPerGen == 0 {
 
 		// Prepare the new generation in a temporary directory first
 		pattern := fmt.Sprintf(genFormat+"-*.tmp", ver)
@@ -349,46 +620,62 @@ func (st *State) WriteVersion(ver int64, val string) (err error) {
 		return err
 	}
 
-	// Read back whatever register version file actually got written,
-	// which might be from someone else's write that won over ours.
-	val, tmpGenName, err = readVerFile(st.genPath, verName)
+	// If someone else's write won over ours, read back what they wrote.
+	// If ours won, don't read it back: other clients might already have
+	// moved on and expired this generation, but our write still happened.
 	if err != nil {
-		return err
-	}
-
-	// If the (actual) new version indicates a new generation directory,
-	// try to move the temporary directory into its place.
-	// It's harmless if multiple writers attempt this redundantly:
-	// it fails if either the old temporary directory no longer exists
-	// or if a directory with the new name already exists.
-	if tmpGenName != "" {
-		oldGenPath := filepath.Join(st.path, tmpGenName)
-		newGenPath := filepath.Join(st.path,
-			fmt.Sprintf(genFormat, ver))
-		err := os.Rename(oldGenPath, newGenPath)
-		if err != nil && !IsExist(err) && !IsNotExist(err) {
+		val, tmpGenName, err = readVerFile(st.genPath, verName)
+		if err != nil {
 			return err
 		}
+	}
 
-		// It's a good time to expire old generations when feasible
-		st.expireOld()
-
-		// Update our cached generation state
-		st.genVer = ver
-		st.genPath = newGenPath
+	// If the (actual) new version indicates a new generation directory,
+	// move to it.
+	if err := st.moveGen(ver, tmpGenName); err != nil {
+		return err
 	}
 
 	// Update our cached version state
 	st.ver = ver
 	st.val = val
+
+	// Record the version actually written in the audit log, if any
+	if st.Audit != nil {
+		return st.Audit.Record(ver, val)
+	}
+	return nil
+}
+
+// If version ver indicates a new generation directory tmpGenName,
+// try to move the temporary directory into its place, and move to it.
+// It's harmless if multiple clients attempt this redundantly:
+// it fails if either the old temporary directory no longer exists
+// or if a directory with the new name already exists.
+func (st *State) moveGen(ver int64, tmpGenName string) error {
+	if tmpGenName == "" || ver == st.genVer {
+		return nil
+	}
+	oldGenPath := filepath.Join(st.path, tmpGenName)
+	newGenPath := filepath.Join(st.path, fmt.Sprintf(genFormat, ver))
+	err := os.Rename(oldGenPath, newGenPath)
+	if err != nil && !IsExist(err) && !IsNotExist(err) {
+		return err
+	}
+
+	// It's a good time to expire old generations when feasible
+	st.expireOld()
+
+	// Update our cached generation state
+	st.genVer = ver
+	st.genPath = newGenPath
 	return nil
 }
 
 func writeVerFile(genPath, verName, val, nextGen string) error {
 
 	// Encode the new register version file
-	b := cbe.Encode(nil, []byte(val))
-	b = cbe.Encode(b, []byte(nextGen))
+	b := encodeVer(val, nextGen)
 
 	// Write it atomically
 	verPath := filepath.Join(genPath, verName)
@@ -400,8 +687,10 @@ func writeVerFile(genPath, verName, val, nextGen string) error {
 }
 
 // Expire indicates that state versions earlier than before may be deleted.
-// It does not necessarily delete these older versions immediately, however.
-// Attempts either to read or to write expired versions will fail.
+// It does not necessarily delete these older versions immediately, however,
+// and the State's Policy may retain some of them for longer.
+// Attempts to write expired versions will fail,
+// and attempts to read them will fail once they are garbage collected.
 //
 func (st *State) Expire(before int64) {
 	if st.expVer < before {
@@ -409,28 +698,63 @@ func (st *State) Expire(before int64) {
 	}
 }
 
+// Return the version before which the Policy permits deleting versions.
+func (st *State) collectBefore() int64 {
+	before := st.expVer
+	if keep := st.ver - st.Policy.MinVersions; keep < before {
+		before = keep
+	}
+	return before
+}
+
 // Actually try to delete expired versions.
 // We do this only about once per generation for efficiency.
 func (st *State) expireOld() {
+	paths, _ := st.collectible() // ignore errors
+	for _, genPath := range paths {
+		atomicRemoveAll(genPath)
+	}
+}
+
+// Return the paths of the generation directories our Policy permits deleting,
+// in increasing version order.
+func (st *State) collectible() ([]string, error) {
 
 	// Find all existing generation directories up to version 'before'
-	maxVer, maxName, names, err := scan(st.path, genFormat, st.expVer)
-	if err != nil || len(names) == 0 {
-		return // ignore errors, e.g., no expired generations
+	// (a zero limit would mean no limit to scan),
+	// retaining the earliest version any client has pinned.
+	before := st.collectBefore()
+	if before <= 0 {
+		return nil, nil
 	}
-	if maxVer < 0 || maxVer > st.expVer {
-		println("expireOld oops", len(names), maxVer, st.expVer)
-		panic("shouldn't happen")
+	pin, err := st.pinned()
+	if err != nil {
+		return nil, err
 	}
-
-	// Delete all generation directories before maxVer,
-	// since those can only contain versions strictly before maxVer.
-	for _, genName := range names {
-		if genName != maxName {
-			genPath := filepath.Join(st.path, genName)
-			atomicRemoveAll(genPath)
+	if pin >= 0 && pin < before {
+		before = pin
+	}
+	gens, err := list(st.path, genFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete all generation directories before the last one up to before,
+	// since those can only contain versions strictly before it,
+	// unless they were written too recently to collect under our policy.
+	var paths []string
+	for i := 0; i+1 < len(gens) && gens[i+1] <= before; i++ {
+		genPath := filepath.Join(st.path, fmt.Sprintf(genFormat, gens[i]))
+		if st.Policy.MinAge > 0 {
+			info, err := os.Stat(genPath)
+			if err != nil ||
+				time.Since(info.ModTime()) < st.Policy.MinAge {
+				continue
+			}
 		}
+		paths = append(paths, genPath)
 	}
+	return paths, nil
 }
 
 // Atomically remove the directory at path,
@@ -449,14 +773,32 @@ func atomicRemoveAll(path string) error {
 // State.Write returns an error matching this predicate
 // when the version the caller asked to write already exists.
 func IsExist(err error) bool {
-	return os.IsExist(err)
+	return errors.Is(err, os.ErrExist)
 }
 
 // State.Read returns an error matching this predicat
 // when the version the caller asked to read does not exist.
 func IsNotExist(err error) bool {
-	return os.IsNotExist(err)
+	return errors.Is(err, os.ErrNotExist)
 }
 
-var ErrExist = os.ErrExist
+// ErrExist means a version the caller asked to write already exists,
+// because another client won the race to write it,
+// so it is in the class cas.ErrConflict.
+var ErrExist = cas.Classify(cas.ErrConflict, os.ErrExist)
 var ErrNotExist = os.ErrNotExist
+
+// ErrExpired means a version the caller asked to read
+// precedes the latest version but is not (or no longer) stored,
+// so it will never be readable. It matches IsNotExist as well.
+var ErrExpired = cas.Classify(cas.ErrExpired,
+	fmt.Errorf("verst: version expired: %w", os.ErrNotExist))
+
+// Return ErrExpired in place of a not-exist error reading version ver
+// if a later version exists.
+func (st *State) expired(ver int64, err error) error {
+	if IsNotExist(err) && ver < st.ver {
+		return ErrExpired
+	}
+	return err
+}
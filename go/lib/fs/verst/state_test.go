@@ -0,0 +1,325 @@
+package verst
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Write versions 1 through n to a fresh State, expiring as we go
+// all but the latest version, the way casdir does.
+func testWrite(t *testing.T, pol Policy, n int64) *State {
+	st := &State{Policy: pol}
+	path := filepath.Join(t.TempDir(), "st")
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	for ver := int64(1); ver <= n; ver++ {
+		if err := st.WriteVersion(ver, fmt.Sprint(ver)); err != nil {
+			t.Fatal(err)
+		}
+		st.Expire(ver)
+	}
+	return st
+}
+
+// Return the oldest version still available in a State.
+func testOldest(t *testing.T, st *State) int64 {
+	vers, err := st.ListVersions(0, st.ver)
+	if err != nil || len(vers) == 0 {
+		t.Fatalf("ListVersions: %v %v", vers, err)
+	}
+	return vers[0]
+}
+
+func TestPolicy(t *testing.T) {
+
+	// By default, only the last generation or two survive.
+	st := testWrite(t, Policy{}, 100)
+	if old := testOldest(t, st); old < 100-2*DefaultVersPerGen {
+		t.Errorf("default policy retained version %v", old)
+	}
+
+	// Larger generations retain more versions between collections.
+	st = testWrite(t, Policy{VersPerGen: 50}, 120)
+	if old := testOldest(t, st); old != 50 {
+		t.Errorf("VersPerGen 50: oldest version %v", old)
+	}
+
+	// MinVersions retains at least that many past versions.
+	st = testWrite(t, Policy{MinVersions: 35}, 100)
+	if old := testOldest(t, st); old > 100-35 {
+		t.Errorf("MinVersions 35: oldest version %v", old)
+	}
+	if _, val, err := st.ReadAt(65); err != nil || val != "65" {
+		t.Errorf("ReadAt(65): %q %v", val, err)
+	}
+
+	// MinAge retains everything written recently.
+	st = testWrite(t, Policy{MinAge: time.Hour}, 100)
+	if old := testOldest(t, st); old != 0 {
+		t.Errorf("MinAge: oldest version %v", old)
+	}
+}
+
+// Test that reads and writes report errors of the expected classes.
+func TestErrors(t *testing.T) {
+	st := testWrite(t, Policy{}, 100)
+
+	// Reading a collected version reports that it expired.
+	if _, err := st.ReadVersion(1); !errors.Is(err, ErrExpired) ||
+		!IsNotExist(err) || !cas.Fatal(err) {
+		t.Errorf("ReadVersion(1): %v", err)
+	}
+	if _, _, err := st.ReadAt(1); !errors.Is(err, cas.ErrExpired) {
+		t.Errorf("ReadAt(1): %v", err)
+	}
+
+	// Reading a version not yet written does not.
+	if _, err := st.ReadVersion(200); !IsNotExist(err) ||
+		errors.Is(err, cas.ErrExpired) {
+		t.Errorf("ReadVersion(200): %v", err)
+	}
+
+	// Losing a race to write a version is a retryable conflict.
+	if err := st.WriteVersion(100, "x"); !IsExist(err) ||
+		!errors.Is(err, cas.ErrConflict) || !cas.Retryable(err) {
+		t.Errorf("WriteVersion(100): %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+
+	// Retain everything during writing, then compact explicitly.
+	st := testWrite(t, Policy{MinVersions: 1000}, 100)
+	if old := testOldest(t, st); old != 0 {
+		t.Fatalf("oldest version %v before Compact", old)
+	}
+	st.Policy.MinVersions = 0
+	st.Policy.CompactPause = time.Millisecond
+
+	var calls int
+	stats, err := st.Compact(context.Background(), func(CompactStats) {
+		calls++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Generations != 10 || calls != 10 ||
+		stats.Versions != 10*(DefaultVersPerGen+1) || stats.Bytes <= 0 {
+		t.Errorf("Compact: %+v after %v calls", stats, calls)
+	}
+	if old := testOldest(t, st); old != 100 {
+		t.Errorf("oldest version %v after Compact", old)
+	}
+
+	// A cancelled Compact does nothing.
+	st = testWrite(t, Policy{MinVersions: 1000}, 100)
+	st.Policy.MinVersions = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if stats, err := st.Compact(ctx, nil); err != context.Canceled ||
+		stats.Generations != 0 {
+		t.Errorf("cancelled Compact: %+v %v", stats, err)
+	}
+}
+
+func TestPin(t *testing.T) {
+	write := func(st *State, from, to int64) {
+		for ver := from; ver <= to; ver++ {
+			if err := st.WriteVersion(ver, fmt.Sprint(ver)); err != nil {
+				t.Fatal(err)
+			}
+			st.Expire(ver)
+		}
+	}
+
+	// A pin retains the pinned version and all later versions,
+	// including against collection by other clients.
+	st := testWrite(t, Policy{}, 30)
+	if err := st.Pin(25); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Pin(25); err != nil { // renew
+		t.Fatal(err)
+	}
+	other := &State{}
+	if err := other.Init(st.path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	write(other, 31, 100)
+	if old := testOldest(t, other); old > 25 {
+		t.Errorf("pinned: oldest version %v", old)
+	}
+	if _, val, err := st.ReadAt(25); err != nil || val != "25" {
+		t.Errorf("ReadAt(25): %q %v", val, err)
+	}
+
+	// Once unpinned, the versions may be collected as usual.
+	if err := st.Unpin(25); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Unpin(25); !IsNotExist(err) {
+		t.Errorf("second Unpin: %v", err)
+	}
+	write(other, 101, 130)
+	if old := testOldest(t, other); old <= 25 {
+		t.Errorf("unpinned: oldest version %v", old)
+	}
+
+	// A pin that isn't renewed lapses after its lease.
+	st = testWrite(t, Policy{PinLease: time.Millisecond}, 30)
+	if err := st.Pin(25); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	write(st, 31, 100)
+	if old := testOldest(t, st); old <= 25 {
+		t.Errorf("lapsed pin: oldest version %v", old)
+	}
+	if err := st.Unpin(25); err != nil {
+		t.Errorf("Unpin lapsed pin: %v", err)
+	}
+}
+
+// Test that reading a corrupt version reports an error naming its file.
+func TestCorrupt(t *testing.T) {
+	for _, layout := range []Layout{LayoutFiles, LayoutLog} {
+		st := &State{Layout: layout}
+		path := filepath.Join(t.TempDir(), "st")
+		if err := st.Init(path, true, true); err != nil {
+			t.Fatal(err)
+		}
+		for ver := int64(1); ver <= 3; ver++ {
+			if err := st.WriteVersion(ver, fmt.Sprint(ver)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Overwrite the start of version 2 with garbage.
+		file := filepath.Join(st.genPath, fmt.Sprintf(verFormat, 2))
+		off := int64(0)
+		if layout == LayoutLog {
+			file, off = filepath.Join(st.genPath, logName), st.index[2]
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteAt([]byte("garbage"), off)
+		f.Close()
+
+		_, err = st.ReadVersion(2)
+		if !errors.Is(err, cas.ErrCorrupt) ||
+			!strings.Contains(err.Error(), file) {
+			t.Errorf("layout %v: read corrupt version: %v", layout, err)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	st := &State{Layout: LayoutLog}
+	path := filepath.Join(t.TempDir(), "st")
+	if err := st.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	for ver := int64(1); ver <= 100; ver++ {
+		if err := st.WriteVersion(ver, fmt.Sprint(ver)); err != nil {
+			t.Fatal(err)
+		}
+		st.Expire(ver)
+	}
+	if old := testOldest(t, st); old < 100-2*DefaultVersPerGen {
+		t.Errorf("log layout retained version %v", old)
+	}
+	if _, val, err := st.ReadAt(95); err != nil || val != "95" {
+		t.Errorf("ReadAt(95): %q %v", val, err)
+	}
+
+	// Another client must detect the layout and lose a race to write.
+	other := &State{}
+	if err := other.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if other.Layout != LayoutLog {
+		t.Fatalf("detected layout %v", other.Layout)
+	}
+	if err := st.WriteVersion(101, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.WriteVersion(101, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := other.ReadVersion(101); err != nil || val != "a" {
+		t.Errorf("ReadVersion(101): %q %v", val, err)
+	}
+
+	// Readers must skip over a partial record that a crash left behind.
+	logPath := filepath.Join(st.genPath, logName)
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(102, "torn", "")[:recHeaderLen+2])
+	f.Close()
+	if err := st.WriteVersion(102, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if ver, val, err := other.ReadLatest(); err != nil || ver != 102 ||
+		val != "c" {
+		t.Errorf("ReadLatest: %v %q %v", ver, val, err)
+	}
+
+	// Compact must collect log segments.
+	st = &State{Layout: LayoutLog, Policy: Policy{MinVersions: 1000}}
+	if err := st.Init(filepath.Join(t.TempDir(), "st"), true, true); err != nil {
+		t.Fatal(err)
+	}
+	for ver := int64(1); ver <= 100; ver++ {
+		if err := st.WriteVersion(ver, fmt.Sprint(ver)); err != nil {
+			t.Fatal(err)
+		}
+		st.Expire(ver)
+	}
+	st.Policy.MinVersions = 0
+	stats, err := st.Compact(context.Background(), nil)
+	if err != nil || stats.Generations != 10 ||
+		stats.Versions != 10*(DefaultVersPerGen+1) {
+		t.Errorf("Compact: %+v %v", stats, err)
+	}
+	if old := testOldest(t, st); old != 100 {
+		t.Errorf("oldest version %v after Compact", old)
+	}
+}
+
+// Compare the write throughput of the two state directory layouts.
+func BenchmarkWrite(b *testing.B) {
+	for _, l := range []struct {
+		name   string
+		layout Layout
+	}{{"files", LayoutFiles}, {"log", LayoutLog}} {
+		b.Run(l.name, func(b *testing.B) {
+			st := &State{Layout: l.layout,
+				Policy: Policy{VersPerGen: 1000}}
+			path := filepath.Join(b.TempDir(), "st")
+			if err := st.Init(path, true, true); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 1; i <= b.N; i++ {
+				ver := int64(i)
+				if err := st.WriteVersion(ver, "value"); err != nil {
+					b.Fatal(err)
+				}
+				st.Expire(ver)
+			}
+		})
+	}
+}
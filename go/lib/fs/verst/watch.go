@@ -0,0 +1,93 @@
+package verst
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrWatchUnsupported is returned by Watch when the platform
+// or the file system holding the state cannot deliver change notifications.
+var ErrWatchUnsupported = errors.New("verst: directory watch unsupported")
+
+// State of a directory watch on a verst state directory.
+type watch struct {
+	dirty int32         // nonzero if the directory may have changed
+	ch    chan struct{} // signals changes to the goroutine awaiting them
+	close func() error  // function to stop watching
+}
+
+// Mark the watched directory as possibly changed.
+// This is the only operation the watching goroutine performs on the State.
+func (w *watch) changed() {
+	atomic.StoreInt32(&w.dirty, 1)
+	select {
+	case w.ch <- struct{}{}:
+	default: // a change is already pending
+	}
+}
+
+// Watch enables watch mode, in which the State uses file system
+// change notifications to keep track of new versions,
+// instead of rescanning the state directory on every ReadLatest.
+// ReadLatest then returns the cached latest version with no file system
+// accesses at all, unless a change notification has arrived since.
+//
+// Watch returns ErrWatchUnsupported where change notifications
+// are not available, such as on network file systems like NFS,
+// which do not notify clients of other clients' changes.
+// The State then continues to work by scanning the directory.
+//
+func (st *State) Watch() error {
+	if st.watch != nil {
+		return nil // already watching
+	}
+	w := &watch{dirty: 1, ch: make(chan struct{}, 1)} // Scan at least once
+	close, err := startWatch(st.path, w.changed)
+	if err != nil {
+		return err
+	}
+	w.close = close
+	st.watch = w
+	return nil
+}
+
+// Unwatch disables watch mode, if enabled, releasing its resources.
+func (st *State) Unwatch() error {
+	if st.watch == nil {
+		return nil
+	}
+	err := st.watch.close()
+	st.watch = nil
+	return err
+}
+
+// Changed returns a channel that receives a value
+// when a change notification arrives in watch mode,
+// after which ReadLatest rescans the state directory,
+// or nil if the State is not in watch mode.
+// Several notifications arriving before the receiver takes one
+// yield only one value.
+func (st *State) Changed() <-chan struct{} {
+	if st.watch == nil {
+		return nil
+	}
+	return st.watch.ch
+}
+
+// Refresh our cached state if it might be stale:
+// always when we're not in watch mode,
+// otherwise only if a change notification has arrived since the last refresh.
+func (st *State) update() error {
+	w := st.watch
+	if w == nil {
+		return st.refresh()
+	}
+	if atomic.SwapInt32(&w.dirty, 0) == 0 {
+		return nil // nothing changed
+	}
+	if err := st.refresh(); err != nil {
+		w.changed() // try again next time
+		return err
+	}
+	return nil
+}
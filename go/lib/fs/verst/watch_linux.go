@@ -0,0 +1,91 @@
+package verst
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// File system types on which inotify cannot see other clients' changes
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517b
+	cifsSuperMagic = 0xff534d42
+)
+
+// Watch the verst directory at path and all its generation subdirectories,
+// calling changed whenever anything in them may have changed.
+// Returns a function to stop watching.
+func startWatch(path string, changed func()) (func() error, error) {
+
+	// Network file systems don't report remote changes to inotify.
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return nil, err
+	}
+	switch uint32(fs.Type) {
+	case nfsSuperMagic, smbSuperMagic, cifsSuperMagic:
+		return nil, ErrWatchUnsupported
+	}
+
+	// Use a nonblocking inotify descriptor wrapped in an os.File,
+	// so that the Go runtime poller wakes up our reader on Close.
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, ErrWatchUnsupported
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	const mask = syscall.IN_CREATE | syscall.IN_MOVED_TO |
+		syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+		syscall.IN_MODIFY // appends to log files
+	add := func(dir string) {
+		syscall.InotifyAddWatch(fd, dir, mask) // ignore races with GC
+	}
+
+	// Watch the state directory itself for new generations,
+	// then each existing generation directory for new versions.
+	if _, err := syscall.InotifyAddWatch(fd, path, mask); err != nil {
+		f.Close()
+		return nil, err
+	}
+	names, err := list(path, genFormat)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	for _, gen := range names {
+		add(filepath.Join(path, fmt.Sprintf(genFormat, gen)))
+	}
+
+	go func() {
+		var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+		for {
+			n, err := f.Read(buf[:])
+			if err != nil {
+				return // watch closed
+			}
+
+			// Watch new directories in the state directory,
+			// which are (or are about to become) generations.
+			// Watches on removed directories disappear automatically.
+			for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+				i += syscall.SizeofInotifyEvent + int(ev.Len)
+				if ev.Mask&syscall.IN_ISDIR != 0 && ev.Len > 0 &&
+					ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					name := buf[i-int(ev.Len) : i]
+					for len(name) > 0 && name[len(name)-1] == 0 {
+						name = name[:len(name)-1]
+					}
+					add(filepath.Join(path, string(name)))
+				}
+			}
+			changed()
+		}
+	}()
+
+	return f.Close, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package verst
+
+// Directory watches are currently implemented only on Linux.
+func startWatch(path string, changed func()) (func() error, error) {
+	return nil, ErrWatchUnsupported
+}
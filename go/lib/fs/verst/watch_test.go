@@ -0,0 +1,50 @@
+package verst
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a watching State sees another State's writes.
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "st")
+	w, r := &State{}, &State{}
+	if err := w.Init(path, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Init(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Watch(); err == ErrWatchUnsupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Unwatch()
+
+	// Write enough versions to cross several generations,
+	// and check that the reader eventually notices each one.
+	for ver := int64(1); ver <= 3*DefaultVersPerGen; ver++ {
+		val := fmt.Sprint(ver)
+		if err := w.WriteVersion(ver, val); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			rv, rval, err := r.ReadLatest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rv == ver && rval == val {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("reader stuck at version %v, want %v",
+					rv, ver)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
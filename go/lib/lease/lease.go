@@ -0,0 +1,170 @@
+// Package lease provides the building blocks shared by services
+// that coordinate clients through time-limited leases
+// recorded in the state of a cas.Store,
+// such as the leader election and distributed lock examples.
+//
+// A Lease names its holder, carries a fencing token
+// that increases with each new acquisition, and expires unless renewed.
+// A Register keeps a client's view of the leases' state in the Store,
+// which the client updates by compare-and-set,
+// re-evaluating each update on the latest state until it takes effect.
+//
+// Leases rely on loosely synchronized clocks:
+// a holder whose clock runs slow, or that pauses for longer than its TTL,
+// may still believe it holds a lease after another client has taken over.
+// Resources that lease holders act on should therefore check fencing tokens,
+// rejecting requests bearing a lower token than the highest they have seen.
+// The CAS operations themselves never rely on clocks for safety.
+//
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Lease describes one client's hold on a resource.
+type Lease struct {
+	Holder  string    // Identity of the client holding the lease
+	Token   int64     // Fencing token, increasing with each acquisition
+	Expires time.Time // When the lease expires unless renewed
+}
+
+// HeldError reports that a client could not acquire a lease
+// because another client holds a conflicting, unexpired lease.
+type HeldError struct {
+	Lease Lease // The conflicting lease that expires last
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lease held by %s until %v",
+		e.Lease.Holder, e.Lease.Expires)
+}
+
+// Unwrap returns ErrHeld.
+func (e *HeldError) Unwrap() error {
+	return ErrHeld
+}
+
+// ErrHeld is the error a HeldError wraps,
+// so that callers may detect it with errors.Is.
+var ErrHeld = errors.New("lease held by another client")
+
+// ErrLost is returned by operations on a lease
+// once the lease has expired and another client may have taken over.
+var ErrLost = errors.New("lease lost")
+
+// Clock supplies the current time to lease operations.
+// A nil Clock uses time.Now.
+type Clock func() time.Time
+
+// Now returns the current time according to c.
+func (c Clock) Now() time.Time {
+	if c != nil {
+		return c()
+	}
+	return time.Now()
+}
+
+// Register holds a client's view of the leases' state of type S,
+// which the Store records in JSON.
+// The zero Register is ready to use, and is safe for concurrent use.
+//
+type Register[S any] struct {
+	mut sync.Mutex // protects raw
+	raw string     // last state observed in the Store
+}
+
+// Update updates the state in store via f,
+// which returns the state to write, or nil to leave the state unchanged,
+// given the latest state, or a zero S if the Store's state is empty.
+// Update returns the state f last returned, or the latest state if nil.
+// An error f returns is final only once f has seen the latest state,
+// not just the state the Register last observed.
+//
+// If bo is nil, Update returns the errors of failed Store accesses.
+// Otherwise it retries them according to bo,
+// after which f sees whatever state the failed access left behind.
+// Unless bo's Report function aborts retries,
+// they continue until ctx is done,
+// except on errors that cas.Fatal classifies as fatal.
+//
+func (r *Register[S]) Update(ctx context.Context, store cas.Store,
+	bo *backoff.Config, f func(st *S) (*S, error)) (*S, error) {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	try := func(try func() error) error { return try() }
+	if bo != nil {
+		c := *bo
+		if c.Report == nil {
+			c.Report = func(err error) error {
+				if cas.Fatal(err) {
+					return err
+				}
+				return nil
+			}
+		}
+		try = func(try func() error) error { return c.Retry(ctx, try) }
+	}
+
+	for fresh := false; ; fresh = true {
+		cur, err := decode[S](r.raw)
+		if err != nil {
+			return nil, err
+		}
+		next, ferr := f(cur)
+		if ferr != nil && fresh {
+			return nil, ferr
+		}
+		new := r.raw
+		if ferr == nil && next != nil {
+			new = encode(next)
+		}
+		var act string
+		err = try(func() (err error) {
+			_, act, err = store.CompareAndSet(ctx, r.raw, new)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if act == new && ferr == nil {
+			r.raw = act
+			if next == nil {
+				return cur, nil
+			}
+			return next, nil
+		}
+		r.raw = act // re-evaluate f on the latest state
+	}
+}
+
+// Decode a state from the Store's state.
+func decode[S any](raw string) (*S, error) {
+	st := new(S)
+	if raw == "" {
+		return st, nil // no leases yet
+	}
+	if err := json.Unmarshal([]byte(raw), st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Encode a state for the Store.
+func encode[S any](st *S) string {
+	b, err := json.Marshal(st)
+	if err != nil {
+		panic("error encoding lease state: " + err.Error())
+	}
+	return string(b)
+}
@@ -0,0 +1,124 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+)
+
+// A counter kept in the Store's state.
+type counter struct {
+	N int
+}
+
+// Test that concurrent updates through separate Registers all take effect.
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := &cas.Register{}
+	nclients, nincs := 10, 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < nclients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &Register[counter]{}
+			for j := 0; j < nincs; j++ {
+				_, err := r.Update(ctx, store, nil,
+					func(st *counter) (*counter, error) {
+						return &counter{st.N + 1}, nil
+					})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	r := &Register[counter]{}
+	st, err := r.Update(ctx, store, nil, func(*counter) (*counter, error) {
+		return nil, nil
+	})
+	if err != nil || st.N != nclients*nincs {
+		t.Fatalf("read %v %v, want %v", st, err, nclients*nincs)
+	}
+}
+
+// Test that an error f returns on a stale state is not final.
+func TestUpdateStale(t *testing.T) {
+	ctx := context.Background()
+	store := &cas.Register{}
+	a, b := &Register[counter]{}, &Register[counter]{}
+	inc := func(st *counter) (*counter, error) {
+		return &counter{st.N + 1}, nil
+	}
+	if _, err := a.Update(ctx, store, nil, inc); err != nil {
+		t.Fatal(err)
+	}
+
+	// b has yet to observe a's increment, so it must look again.
+	errZero := errors.New("zero counter")
+	st, err := b.Update(ctx, store, nil, func(st *counter) (*counter, error) {
+		if st.N == 0 {
+			return nil, errZero
+		}
+		return inc(st)
+	})
+	if err != nil || st.N != 2 {
+		t.Fatalf("update %v %v, want 2", st, err)
+	}
+
+	// An error on the latest state is final.
+	_, err = b.Update(ctx, store, nil, func(*counter) (*counter, error) {
+		return nil, errZero
+	})
+	if err != errZero {
+		t.Fatalf("update returned %v, want %v", err, errZero)
+	}
+}
+
+// Test that Update retries failed Store accesses only given a backoff.
+func TestUpdateRetry(t *testing.T) {
+	ctx := context.Background()
+	read := func(*counter) (*counter, error) { return nil, nil }
+
+	r := &Register[counter]{}
+	store := test.Faulty(&cas.Register{}, test.Faults{Timeout: 1})
+	if _, err := r.Update(ctx, store, nil, read); err != test.ErrTimeout {
+		t.Fatalf("update returned %v, want %v", err, test.ErrTimeout)
+	}
+
+	store = test.Faulty(&cas.Register{}, test.Faults{Timeout: 0.5})
+	bo := &backoff.Config{Report: func(error) error { return nil }}
+	for i := 0; i < 20; i++ {
+		if _, err := r.Update(ctx, store, bo, read); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	store = test.Faulty(&cas.Register{}, test.Faults{Timeout: 1})
+	if _, err := r.Update(ctx, store, bo, read); err == nil {
+		t.Fatal("update of a failing store succeeded")
+	}
+}
+
+func TestClock(t *testing.T) {
+	at := time.Unix(1000, 0)
+	if now := Clock(func() time.Time { return at }).Now(); !now.Equal(at) {
+		t.Errorf("clock read %v, want %v", now, at)
+	}
+	before := time.Now()
+	if now := Clock(nil).Now(); now.Before(before) {
+		t.Errorf("nil clock read %v, before %v", now, before)
+	}
+}
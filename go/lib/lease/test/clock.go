@@ -0,0 +1,34 @@
+// Package test implements shareable code for testing services
+// built on package lease.
+package test
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a manually advanced clock that test clients may share
+// as their lease.Clock, via its Now method.
+type Clock struct {
+	mut sync.Mutex
+	t   time.Time
+}
+
+// NewClock returns a Clock reading time t until advanced.
+func NewClock(t time.Time) *Clock {
+	return &Clock{t: t}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.t = c.t.Add(d)
+}
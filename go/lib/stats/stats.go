@@ -0,0 +1,93 @@
+// Package stats exports per-round statistics of consensus runs,
+// such as those the model and dist test harnesses perform,
+// as CSV files for statistical analysis in a notebook or spreadsheet.
+// This supports reproducing figures such as those in the TLC/QSC paper
+// plotting commit probability against ticket entropy and group size.
+//
+// Each row records one node's outcome of one consensus round,
+// with columns named after the fields of Round in lower case.
+// The package writes only CSV, since the standard library has no
+// Parquet encoder, but notebook tools such as pandas and DuckDB
+// read the CSV directly, or can convert it to Parquet.
+//
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// Round is one node's statistics for one consensus round.
+type Round struct {
+	Run       string  // Description of the run, e.g., its test case name
+	Nodes     int     // Number of nodes in the group
+	Threshold int     // TLC and consensus threshold
+	Entropy   float64 // Bits of entropy in lottery tickets
+	Node      int     // Node reporting the round
+	Step      int     // Time step at which the round started
+	Commit    bool    // Whether the node saw the round commit
+	Chosen    int     // Node whose proposal the node chose
+	Messages  int     // Messages the node sent in the round's first step
+}
+
+var header = []string{"run", "nodes", "threshold", "entropy",
+	"node", "step", "commit", "chosen", "messages"}
+
+// Entropy returns the bits of entropy in lottery tickets
+// chosen uniformly from maxTicket possible values.
+func Entropy(maxTicket int64) float64 {
+	return math.Log2(float64(maxTicket))
+}
+
+// Writer writes Rounds to a CSV file, preceded by a header row.
+// It is safe for concurrent use, so that concurrent test cases
+// may record their statistics in the same file.
+type Writer struct {
+	mut   sync.Mutex  // protects the fields below
+	csv   *csv.Writer // underlying CSV encoder
+	wrote bool        // whether we have written the header row
+}
+
+// NewWriter returns a Writer writing CSV to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{csv: csv.NewWriter(w)}
+}
+
+// Write writes the statistics of each of rounds as a CSV row.
+// The rows may be buffered until the caller calls Flush.
+func (w *Writer) Write(rounds ...Round) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if !w.wrote {
+		if err := w.csv.Write(header); err != nil {
+			return err
+		}
+		w.wrote = true
+	}
+	for i := range rounds {
+		r := &rounds[i]
+		err := w.csv.Write([]string{r.Run,
+			strconv.Itoa(r.Nodes), strconv.Itoa(r.Threshold),
+			strconv.FormatFloat(r.Entropy, 'g', -1, 64),
+			strconv.Itoa(r.Node), strconv.Itoa(r.Step),
+			strconv.FormatBool(r.Commit), strconv.Itoa(r.Chosen),
+			strconv.Itoa(r.Messages)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	w.csv.Flush()
+	return w.csv.Error()
+}
@@ -0,0 +1,36 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	err := w.Write(Round{Run: "T=2,N=3", Nodes: 3, Threshold: 2,
+		Entropy: Entropy(8), Node: 1, Step: 7, Commit: true,
+		Chosen: 2, Messages: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Round{Run: "a,\"b\"", Chosen: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{header,
+		{"T=2,N=3", "3", "2", "3", "1", "7", "true", "2", "5"},
+		{"a,\"b\"", "0", "0", "0", "0", "0", "false", "-1", "0"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q, want %q", rows, want)
+	}
+}
@@ -0,0 +1,145 @@
+// Package status provides a minimal embedded HTTP status page
+// through which operators can inspect a running consensus participant,
+// such as a dist.Node or a qscas.Group, from a browser.
+//
+// A participant implements Reporter to describe its current state,
+// and the application registers a Handler for it on any HTTP server:
+//
+//	http.Handle("/status", status.Handler(node))
+//
+// The page shows committed values, so it should be served
+// only on interfaces that operators trust to see them.
+//
+package status
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// HistoryTail is the number of recent commits that reporters in this module
+// include in the history they report.
+const HistoryTail = 10
+
+// Reporter is implemented by consensus participants that report their status.
+// Status must be safe to call concurrently with the participant's operation.
+type Reporter interface {
+	Status() Status
+}
+
+// Status is a snapshot of a consensus participant's state.
+type Status struct {
+	Kind    string             // Kind of participant, e.g., "dist.Node"
+	Step    int64              // Current logical time step
+	Peers   []Peer             // Health of each peer or member store
+	History []Commit           // Most recent commits, oldest first
+	Config  map[string]string  // Configuration parameters by name
+	Metrics map[string]float64 // Measurements by name, if any
+}
+
+// Peer describes the health of one of a participant's peers,
+// or of one of its member stores.
+type Peer struct {
+	Name    string // Human-readable name of the peer
+	Healthy bool   // Whether the peer appears to be keeping up
+	Detail  string // Further human-readable details, if any
+}
+
+// Commit describes one committed value in a participant's history.
+type Commit struct {
+	Step  int64  // Logical time step of the committed value
+	Value string // Committed value, or a description of it
+}
+
+// Maximum length of the values the HTML status page displays
+const maxValue = 80
+
+// Handler returns an HTTP handler that serves r's status
+// as a human-readable HTML page,
+// or as JSON if the request has the query parameter format=json.
+func Handler(r Reporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		st := r.Status()
+		if req.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			enc.Encode(st)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.Execute(w, st)
+	})
+}
+
+// Sorted configuration parameters for display
+type param struct {
+	Name, Value string
+}
+
+var page = template.Must(template.New("status").Funcs(template.FuncMap{
+	"params": func(conf map[string]string) []param {
+		ps := make([]param, 0, len(conf))
+		for name, val := range conf {
+			ps = append(ps, param{name, val})
+		}
+		sort.Slice(ps, func(i, j int) bool {
+			return ps[i].Name < ps[j].Name
+		})
+		return ps
+	},
+	"metrics": func(ms map[string]float64) []param {
+		ps := make([]param, 0, len(ms))
+		for name, val := range ms {
+			v := strconv.FormatFloat(val, 'g', 6, 64)
+			ps = append(ps, param{name, v})
+		}
+		sort.Slice(ps, func(i, j int) bool {
+			return ps[i].Name < ps[j].Name
+		})
+		return ps
+	},
+	"short": func(s string) string {
+		if len(s) > maxValue {
+			return s[:maxValue] + "..."
+		}
+		return s
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Kind}} status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+.bad { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Kind}}</h1>
+<p>Current step: {{.Step}}</p>
+<h2>Peers</h2>
+<table>
+<tr><th>Peer</th><th>Health</th><th>Detail</th></tr>
+{{range .Peers}}<tr><td>{{.Name}}</td>{{if .Healthy}}<td>ok</td>{{else}}<td class="bad">lagging</td>{{end}}<td>{{.Detail}}</td></tr>
+{{end}}</table>
+<h2>Recent commits</h2>
+<table>
+<tr><th>Step</th><th>Value</th></tr>
+{{range .History}}<tr><td>{{.Step}}</td><td>{{printf "%q" (short .Value)}}</td></tr>
+{{end}}</table>
+<h2>Configuration</h2>
+<table>
+{{range params .Config}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Metrics}}<h2>Metrics</h2>
+<table>
+{{range metrics .Metrics}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
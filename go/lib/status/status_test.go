@@ -0,0 +1,55 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type reporter Status
+
+func (r reporter) Status() Status { return Status(r) }
+
+func TestHandler(t *testing.T) {
+	r := reporter{
+		Kind: "test",
+		Step: 42,
+		Peers: []Peer{{Name: "a", Healthy: true, Detail: "fine"},
+			{Name: "b", Detail: "<slow>"}},
+		History: []Commit{{Step: 39, Value: strings.Repeat("x", 100)}},
+		Config:  map[string]string{"threshold": "2", "nodes": "3"},
+		Metrics: map[string]float64{"fairness": 0.5},
+	}
+	h := Handler(r)
+
+	// The HTML page should show everything, suitably escaped.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	body := w.Body.String()
+	for _, s := range []string{"<h1>test</h1>", "Current step: 42",
+		"fine", "lagging", "&lt;slow&gt;", strings.Repeat("x", 80) + "...",
+		"<th>nodes</th><td>3</td>", "<th>fairness</th><td>0.5</td>"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("status page lacks %q:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, strings.Repeat("x", 81)) {
+		t.Errorf("status page shows long value in full")
+	}
+	if strings.Index(body, "nodes") > strings.Index(body, "threshold") {
+		t.Errorf("status page configuration not sorted")
+	}
+
+	// The JSON form should round-trip.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status?format=json", nil))
+	var st Status
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(st, Status(r)) {
+		t.Errorf("JSON status %+v, want %+v", st, r)
+	}
+}
@@ -0,0 +1,134 @@
+// Package witness implements the threshold-witnessed broadcast pattern
+// at the core of Threshold Logical Clocks (TLC).
+//
+// At the start of each time step, each node broadcasts a raw proposal
+// and collects unicast acknowledgments of it from other nodes.
+// Once a threshold of nodes have acknowledged the proposal,
+// it is threshold witnessed, and the node broadcasts a witness confirmation.
+// Once a node has seen witness confirmations of a threshold of
+// distinct nodes' proposals in the current step,
+// the node may advance to the next time step.
+//
+// This package tracks only the counting state of this pattern,
+// independent of any particular message format or network layer,
+// so that different TLC implementations can share it.
+//
+package witness
+
+import (
+	"sort"
+)
+
+// Tracker tracks the threshold witnessing progress of one node
+// within one time step.
+//
+// A Tracker counts each peer at most once for acknowledgments
+// and at most once for witness confirmations,
+// so duplicate deliveries cannot cause a node to reach a threshold early.
+// Tracker instances are not safe for concurrent use.
+//
+type Tracker struct {
+	thres int          // witness and advancement threshold
+	acks  map[int]bool // peers that acknowledged our proposal
+	wits  map[int]bool // peers whose proposals we saw witnessed
+	done  bool         // our proposal is threshold witnessed
+	adv   bool         // we have seen a threshold of witnessed proposals
+}
+
+// Init sets up a Tracker with the given threshold
+// and resets it for a new time step.
+func (t *Tracker) Init(thres int) {
+	t.thres = thres
+	t.Reset()
+}
+
+// Reset clears the Tracker's state at the start of a new time step,
+// reusing its maps so that long runs allocate none in each step.
+func (t *Tracker) Reset() {
+	if t.acks == nil {
+		t.acks = make(map[int]bool)
+		t.wits = make(map[int]bool)
+	}
+	clear(t.acks)
+	clear(t.wits)
+	t.done = false
+	t.adv = false
+}
+
+// Ack records an acknowledgment of our current proposal from a peer.
+// Returns true exactly once per time step, when our proposal first
+// becomes threshold witnessed, at which point the caller should
+// broadcast its witness confirmation.
+func (t *Tracker) Ack(peer int) bool {
+	t.acks[peer] = true
+	if !t.done && len(t.acks) >= t.thres {
+		t.done = true
+		return true
+	}
+	return false
+}
+
+// Wit records a peer's witness confirmation in the current time step.
+// Returns true exactly once per time step, when the node first has seen
+// a threshold of witnessed proposals and may advance to the next step.
+func (t *Tracker) Wit(peer int) bool {
+	t.wits[peer] = true
+	if !t.adv && len(t.wits) >= t.thres {
+		t.adv = true
+		return true
+	}
+	return false
+}
+
+// Witnessed returns true if our proposal is threshold witnessed.
+func (t *Tracker) Witnessed() bool {
+	return t.done
+}
+
+// Acks returns the number of distinct peers that acknowledged our proposal.
+func (t *Tracker) Acks() int {
+	return len(t.acks)
+}
+
+// Wits returns the number of distinct peers seen witnessed in this step.
+func (t *Tracker) Wits() int {
+	return len(t.wits)
+}
+
+// State is a serializable snapshot of a Tracker's progress,
+// e.g., for debugging or for restoring a node's state later.
+type State struct {
+	Thres int   // Witness and advancement threshold
+	Acks  []int // Peers that acknowledged our proposal, in order
+	Wits  []int // Peers whose proposals we saw witnessed, in order
+	Done  bool  // Whether our proposal is threshold witnessed
+	Adv   bool  // Whether we have seen a threshold of witnessed proposals
+}
+
+// State returns a snapshot of the Tracker's progress.
+func (t *Tracker) State() State {
+	return State{Thres: t.thres, Acks: peers(t.acks), Wits: peers(t.wits),
+		Done: t.done, Adv: t.adv}
+}
+
+// SetState restores the Tracker's progress from a snapshot.
+func (t *Tracker) SetState(s State) {
+	t.Init(s.Thres)
+	for _, peer := range s.Acks {
+		t.acks[peer] = true
+	}
+	for _, peer := range s.Wits {
+		t.wits[peer] = true
+	}
+	t.done, t.adv = s.Done, s.Adv
+}
+
+// Return the peers in a set in increasing order.
+func peers(set map[int]bool) []int {
+	l := make([]int, 0, len(set))
+	for peer := range set {
+		l = append(l, peer)
+	}
+	sort.Ints(l)
+	return l
+}
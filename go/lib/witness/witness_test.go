@@ -0,0 +1,58 @@
+package witness
+
+import "testing"
+
+func TestTracker(t *testing.T) {
+	var tr Tracker
+	tr.Init(3)
+
+	for step := 0; step < 2; step++ {
+		if tr.Ack(0) || tr.Ack(1) || tr.Ack(1) {
+			t.Errorf("witnessed below threshold")
+		}
+		if !tr.Ack(2) {
+			t.Errorf("not witnessed at threshold")
+		}
+		if tr.Ack(3) || !tr.Witnessed() {
+			t.Errorf("witnessed more than once")
+		}
+
+		if tr.Wit(0) || tr.Wit(0) || tr.Wit(0) {
+			t.Errorf("duplicate witness confirmations counted")
+		}
+		if tr.Wit(1) || !tr.Wit(2) || tr.Wit(3) {
+			t.Errorf("wrong advancement")
+		}
+		if tr.Acks() != 4 || tr.Wits() != 4 {
+			t.Errorf("got %v acks and %v wits", tr.Acks(), tr.Wits())
+		}
+
+		tr.Reset()
+		if tr.Witnessed() || tr.Acks() != 0 || tr.Wits() != 0 {
+			t.Errorf("Reset did not clear state")
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	var tr Tracker
+	tr.Init(3)
+	tr.Ack(3)
+	tr.Ack(1)
+	tr.Wit(2)
+
+	var cp Tracker
+	cp.SetState(tr.State())
+	if cp.Acks() != 2 || cp.Wits() != 1 || cp.Witnessed() {
+		t.Errorf("restored wrong progress")
+	}
+	if !cp.Ack(0) || cp.Ack(2) {
+		t.Errorf("restored tracker reached threshold wrongly")
+	}
+	if cp.Wit(0) || !cp.Wit(1) {
+		t.Errorf("restored tracker advanced wrongly")
+	}
+	if s := tr.State(); s.Acks[0] != 1 || s.Acks[1] != 3 {
+		t.Errorf("acks not in order: %v", s.Acks)
+	}
+}
@@ -0,0 +1,205 @@
+package model
+
+import "errors"
+
+// Chunk is one piece of an application payload too large to attach
+// to a single proposal, which a node instead splits across proposals
+// in consecutive time steps.
+// A chunked payload commits only if all of its chunks commit,
+// in order, in consecutive consensus rounds.
+type Chunk struct {
+	From  int    // Node proposing the payload
+	ID    uint64 // Payload identifier, increasing for each proposing node
+	Index int    // Index of this chunk within the payload
+	Count int    // Total number of chunks in the payload
+	Data  []byte // This chunk's portion of the payload
+}
+
+// ErrChunkSize is returned when splitting a payload
+// into chunks of a size that is not positive.
+var ErrChunkSize = errors.New("chunk size must be positive")
+
+// Split splits a payload that node from proposes
+// into chunks of at most size bytes each,
+// returning ErrChunkSize unless size is positive.
+// An empty payload yields a single empty chunk.
+func Split(from int, id uint64, payload []byte, size int) ([]Chunk, error) {
+	if size <= 0 {
+		return nil, ErrChunkSize
+	}
+	count := (len(payload) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	chunks := make([]Chunk, count)
+	for i := range chunks {
+		lo, hi := i*size, (i+1)*size
+		if hi > len(payload) {
+			hi = len(payload)
+		}
+		chunks[i] = Chunk{From: from, ID: id, Index: i, Count: count,
+			Data: payload[lo:hi]}
+	}
+	return chunks, nil
+}
+
+// Chunker tracks which chunk of a large payload a node should attach
+// to its proposal in each time step.
+//
+// The node proposes the payload's chunks in consecutive time steps.
+// Since consensus rounds are pipelined, the node learns whether a chunk
+// committed only after the pipeline depth, three steps by default,
+// by which time it has already proposed the following chunks.
+// If a round does not commit the node's chunk as far as it can tell,
+// the Chunker abandons that attempt and starts over from the first chunk.
+//
+type Chunker struct {
+	chunks []Chunk           // the payload's chunks
+	base   int               // step at which the current attempt began
+	sent   map[int]chunkSent // attempt and chunk we proposed at recent steps
+	tries  int               // number of the current attempt
+	done   bool              // whether the last chunk has committed
+}
+
+// Record of which chunk we proposed in a step, in which attempt
+type chunkSent struct {
+	try, index int
+}
+
+// Init prepares the Chunker to propose a payload for node from,
+// split into chunks of at most size bytes each,
+// returning ErrChunkSize unless size is positive.
+func (c *Chunker) Init(from int, id uint64, payload []byte, size int) error {
+	chunks, err := Split(from, id, payload, size)
+	if err != nil {
+		return err
+	}
+	c.chunks = chunks
+	c.base = -1
+	c.sent = make(map[int]chunkSent)
+	c.tries = 0
+	c.done = false
+	return nil
+}
+
+// Next returns the chunk to attach to the node's proposal
+// in the Raw message msg, which the node broadcasts to begin a time step,
+// or nil if the node has no chunk to propose in this step.
+// The client must call Next with each Raw message the node broadcasts,
+// in order, so that the Chunker learns the outcome of each round
+// in which it proposed a chunk from the message's QSC state.
+func (c *Chunker) Next(msg *Message) *Chunk {
+
+	// Learn the outcome of the round that ended at this step.
+	start := msg.Step - (len(msg.QSC) - 1)
+	if s, ok := c.sent[start]; ok {
+		delete(c.sent, start)
+		r := &msg.QSC[0]
+		committed := r.Commit && r.Conf.From == c.chunks[0].From
+		switch {
+		case s.try != c.tries:
+			// Outcome of an attempt we already abandoned
+		case !committed:
+			c.base = -1 // start over from the first chunk
+			c.tries++
+		case s.index == len(c.chunks)-1:
+			c.done = true
+		}
+	}
+	if c.done {
+		return nil
+	}
+
+	// Propose the next chunk of the current attempt, if any.
+	if c.base < 0 {
+		c.base = msg.Step
+	}
+	i := msg.Step - c.base
+	if i >= len(c.chunks) {
+		return nil // all chunks proposed; awaiting their outcome
+	}
+	c.sent[msg.Step] = chunkSent{c.tries, i}
+	return &c.chunks[i]
+}
+
+// Done returns true once the node has seen all chunks of its payload commit.
+func (c *Chunker) Done() bool {
+	return c.done
+}
+
+// Assembler reassembles chunked payloads
+// from the sequence of proposals committed in consecutive consensus rounds.
+//
+// A payload is complete only when its final chunk commits,
+// in the round immediately following the round that committed
+// the chunk before it, and so on back to the first chunk.
+// If any other proposal commits in between, or a round's outcome is unknown,
+// the partially reassembled payload is aborted.
+// As with any consensus result, different nodes may see different rounds
+// commit, so a node may reassemble a payload that another node aborts;
+// but any payload a node reassembles is exactly the payload proposed.
+//
+type Assembler struct {
+	// Abort, if non-nil, is called when a partially reassembled payload
+	// from node from with the given ID is aborted.
+	Abort func(from int, id uint64)
+
+	cur  []byte         // payload being reassembled, if next > 0
+	head Chunk          // first chunk of the payload being reassembled
+	next int            // index of the next chunk we expect, or 0
+	step int            // step of the round expected to commit it
+	last map[int]uint64 // ID of the last payload completed from each node
+}
+
+// Round processes the outcome of the consensus round that started at step,
+// where c is the chunk carried by the proposal that committed in the round,
+// or nil if the round did not commit as far as this node knows,
+// or its committed proposal carried no chunk.
+// The client must supply rounds in increasing step order.
+// Returns the reassembled payload if c completes one, and nil otherwise.
+func (a *Assembler) Round(step int, c *Chunk) []byte {
+	if a.last == nil {
+		a.last = make(map[int]uint64)
+	}
+
+	// Continue the payload in progress, if c is its next chunk.
+	if a.next > 0 && c != nil && step == a.step && c.Index == a.next &&
+		c.From == a.head.From && c.ID == a.head.ID {
+
+		a.cur = append(a.cur, c.Data...)
+		a.next++
+		a.step++
+		return a.complete()
+	}
+
+	// Otherwise abort any payload in progress.
+	if a.next > 0 {
+		a.next = 0
+		if a.Abort != nil {
+			a.Abort(a.head.From, a.head.ID)
+		}
+	}
+
+	// Start a new payload, unless we already completed it.
+	if c == nil || c.Index != 0 {
+		return nil
+	}
+	if id, ok := a.last[c.From]; ok && c.ID <= id {
+		return nil // retransmission of a completed payload
+	}
+	a.head = *c
+	a.cur = append([]byte{}, c.Data...)
+	a.next = 1
+	a.step = step + 1
+	return a.complete()
+}
+
+// Return the payload in progress if it is complete.
+func (a *Assembler) complete() []byte {
+	if a.next < a.head.Count {
+		return nil
+	}
+	a.next = 0
+	a.last[a.head.From] = a.head.ID
+	return a.cur
+}
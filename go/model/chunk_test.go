@@ -0,0 +1,158 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Test reassembly and abort semantics on hand-made round outcomes.
+func TestAssembler(t *testing.T) {
+	a, err := Split(1, 1, []byte("hello, world"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Split(2, 1, []byte("other"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 3 || len(b) != 1 {
+		t.Fatalf("wrong chunk counts %v %v", len(a), len(b))
+	}
+
+	aborts := 0
+	asm := Assembler{Abort: func(from int, id uint64) { aborts++ }}
+	expect := func(step int, c *Chunk, want string) {
+		got := asm.Round(step, c)
+		if (got == nil) != (want == "") || string(got) != want {
+			t.Errorf("step %v: got %q want %q", step, got, want)
+		}
+	}
+	expect(0, &a[0], "")
+	expect(1, &a[1], "")
+	expect(2, &b[0], "other") // foreign commit aborts a
+	expect(3, &a[2], "")      // orphaned last chunk
+	expect(4, &a[0], "")
+	expect(5, nil, "") // unknown outcome aborts a again
+	expect(6, &a[0], "")
+	expect(7, &a[1], "")
+	expect(9, &a[2], "") // skipped step aborts a
+	expect(10, &a[0], "")
+	expect(11, &a[1], "")
+	expect(12, &a[2], "hello, world")
+	expect(13, &a[0], "") // completed payloads are not repeated
+	expect(14, &a[1], "")
+	expect(15, &a[2], "")
+	if aborts != 3 {
+		t.Errorf("got %v aborts, want 3", aborts)
+	}
+}
+
+// Test that Split and Init reject chunk sizes that are not positive.
+func TestChunkSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		if c, err := Split(1, 1, []byte("hello"), size); err != ErrChunkSize {
+			t.Errorf("split with size %v: %v %v", size, c, err)
+		}
+		var c Chunker
+		if err := c.Init(1, 1, []byte("hello"), size); err != ErrChunkSize {
+			t.Errorf("init with size %v: %v", size, err)
+		}
+	}
+	if c, err := Split(1, 1, nil, 1); err != nil || len(c) != 1 {
+		t.Errorf("split empty payload: %v %v", c, err)
+	}
+}
+
+// Run a consensus group in which every node repeatedly proposes
+// chunked payloads, and check that nodes reassemble them correctly.
+func TestChunks(t *testing.T) {
+	testChunks(t, 2, 3, 10000, 1000) // One chunk per payload
+	testChunks(t, 2, 3, 10000, 50)   // Two chunks per payload
+	testChunks(t, 3, 5, 10000, 30)   // Four chunks per payload
+}
+
+// The payload node from proposes with a given ID
+func testPayload(from int, id uint64) []byte {
+	return []byte(fmt.Sprintf("payload %v from %v%80v", id, from, ""))
+}
+
+func testChunks(t *testing.T, thres, nnode, maxSteps, size int) {
+	desc := fmt.Sprintf("T=%v,N=%v,Steps=%v,Size=%v",
+		thres, nnode, maxSteps, size)
+	t.Run(desc, func(t *testing.T) {
+		all := make([]*Node, nnode)
+		peer := make([]chan *Message, nnode)
+		chunker := make([]Chunker, nnode)
+		asm := make([]Assembler, nnode)
+		ids := make([]uint64, nnode)
+		done := make([]int, nnode)
+
+		// Chunks proposed by each node in each step
+		var mut sync.Mutex
+		prop := make([]map[int]*Chunk, nnode)
+
+		// Attach chunks to proposals as each node begins a time step,
+		// and reassemble payloads as the node learns round outcomes.
+		send := func(dst int, msg *Message) {
+			if dst == 0 && msg.Type == Raw {
+				i := msg.From
+				if chunker[i].Done() {
+					ids[i]++
+					err := chunker[i].Init(i, ids[i],
+						testPayload(i, ids[i]), size)
+					if err != nil {
+						t.Error(err)
+					}
+				}
+				c := chunker[i].Next(msg)
+
+				mut.Lock()
+				prop[i][msg.Step] = c
+				var com *Chunk
+				if msg.Step >= 3 && msg.QSC[0].Commit {
+					com = prop[msg.QSC[0].Conf.From][msg.Step-3]
+				}
+				mut.Unlock()
+
+				if msg.Step >= 3 {
+					p := asm[i].Round(msg.Step-3, com)
+					if p != nil && !bytes.Equal(p,
+						testPayload(com.From, com.ID)) {
+						t.Errorf("node %v got wrong payload %q",
+							i, p)
+					}
+					if p != nil {
+						done[i]++
+					}
+				}
+			}
+			peer[dst] <- msg
+		}
+
+		for i := range all {
+			peer[i] = make(chan *Message, 3*nnode*maxSteps)
+			prop[i] = make(map[int]*Chunk)
+			err := chunker[i].Init(i, 0, testPayload(i, 0), size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all[i] = NewNode(i, thres, nnode, send)
+		}
+		wg := &sync.WaitGroup{}
+		for _, n := range all {
+			wg.Add(1)
+			go n.run(maxSteps, peer, wg)
+		}
+		wg.Wait()
+		testResults(t, all)
+
+		for i := range all {
+			t.Logf("node %v reassembled %v payloads", i, done[i])
+			if done[i] == 0 {
+				t.Errorf("node %v reassembled no payloads", i)
+			}
+		}
+	})
+}
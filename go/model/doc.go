@@ -31,7 +31,8 @@
 // Only node numbers are important to this package; it is oblivious to names.
 //
 // When each node in the consensus group starts,
-// the client calls NewNode to initialize the node's TLC and QSC state.
+// the client calls NewNode to initialize the node's TLC and QSC state,
+// or NewNodeF to derive the node's threshold from the failures to tolerate.
 // The client may then change optional Node configuration parameters,
 // such as Node.Rand, before actually commencing protocol message processing.
 // The client then calls Node.Advance to launch TLC and the consensus protocol,
@@ -70,6 +71,38 @@
 // Alternatively, the round may in fact have converged,
 // and other nodes might observe that fact, even though this node did not.
 //
+// Node.SetDepth configures a deeper pipeline of d rounds, each spanning d steps,
+// for experimenting with the tradeoff between commit latency
+// and the time nodes have to learn of competing proposals in each round.
+// Each Message then includes QSC state from d+1 rounds,
+// QSC[0] again holding the results of the round just completed,
+// which started at step Message.Step-d.
+//
+// Ticket ties
+//
+// QSC commits a round only if a node's best confirmed proposal
+// is also the best of all proposals, confirmed or not, the node saw,
+// since another proposal ranking above it might have won at other nodes.
+// Ranking proposals by ticket alone leaves ties between equal tickets,
+// so by default a node treats a tie for the best proposal as a spoiler,
+// and the round fails to commit.
+// This is rare with 63-bit tickets,
+// but with low-entropy tickets it markedly reduces the commit rate.
+//
+// Setting Node.TieBreak instead ranks proposals by ticket and then by node,
+// as the QSCOD and QuePaxa designs do.
+// This remains safe because the safety argument for QSC relies only on
+// all nodes ranking proposals in the same strict total order,
+// so that a proposal a node sees as best of all in a round it commits
+// outranks any proposal another node could confirm in that round.
+// Each node makes only one proposal per round,
+// so ranking by (ticket, node) is such an order whether or not tickets tie,
+// just as ranking by ticket alone is when no tickets tie.
+// Tie-breaking does make the outcome of a tie predictable,
+// favoring the higher-numbered node,
+// so tickets should still have enough entropy that ties are uncommon
+// if every node must have an equal chance of its proposal being chosen.
+//
 // Message transmission, marshaling
 //
 // This package invokes the send function provided to NewNode to send messages,
@@ -80,6 +113,15 @@
 // On receipt of a message from another node,
 // the client must unmarshal it as appropriate
 // and invoke Node.Receive with the unmarshalled Message.
+// Receive ignores malformed messages, such as those with out-of-range
+// node numbers or QSC state for the wrong rounds;
+// a client may call Node.Check first to detect and report them.
+//
+// A client wishing to reach consensus on payloads too large to attach
+// to a single message may use a Chunker to split each payload
+// across its proposals in consecutive time steps,
+// and an Assembler to reassemble payloads whose chunks all commit
+// in consecutive consensus rounds.
 //
 // Concurrency control
 //
@@ -87,4 +129,9 @@
 // it must be run in a single goroutine,
 // or else the client must implement appropriate locking.
 //
+// For testing and debugging, Sim runs a whole group deterministically
+// in one goroutine, with a Scheduler choosing the message delivery order.
+// A Recorder captures the schedule of a randomized run,
+// and Replay reproduces it exactly, e.g., to debug a safety violation.
+//
 package model
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Feed a node a stream of type-valid but otherwise arbitrary messages
+// decoded from data, checking after each one that the node has not panicked
+// and still satisfies the QSC window invariants.
+// Message steps are chosen relative to the node's current step,
+// so that the stream exercises the node's state machine
+// rather than being ignored as hopelessly stale.
+func testReceive(t *testing.T, data []byte) {
+	const thres, nnode = 2, 3
+
+	next := func() byte {
+		if len(data) == 0 {
+			return 0
+		}
+		b := data[0]
+		data = data[1:]
+		return b
+	}
+	node := func(b byte) int { // node number, occasionally out of range
+		switch b % 16 {
+		case 14:
+			return -1
+		case 15:
+			return nnode
+		}
+		return int(b) % nnode
+	}
+
+	send := func(peer int, msg *Message) {
+		if peer < 0 || peer >= nnode {
+			t.Fatalf("sent message to invalid peer %v", peer)
+		}
+	}
+	n := NewNode(0, thres, nnode, send)
+	tkt := int64(0)
+	n.Rand = func() int64 { tkt++; return tkt % 3 } // frequent collisions
+	n.Piggyback = true
+	n.Advance()
+
+	for len(data) > 0 {
+		msg := &Message{From: node(next()),
+			Step: n.m.Step + []int{0, 0, 1, -1, 2, -5}[next()%6]}
+		typ := next()
+		msg.Type, msg.Acked = Type(typ%4), typ&0x10 != 0
+		msg.Tkt = uint64(next())
+		nqsc := 4
+		if b := next(); b&0x80 != 0 {
+			nqsc = int(b % 5)
+		}
+		for i := 0; i < nqsc; i++ {
+			msg.QSC = append(msg.QSC, Round{
+				Spoil:  Best{node(next()), uint64(next() % 4)},
+				Conf:   Best{node(next()), uint64(next() % 4)},
+				Reconf: Best{node(next()), uint64(next() % 4)}})
+		}
+
+		old := n.m
+		old.QSC = append([]Round{}, n.m.QSC...)
+		n.Receive(msg)
+		testWindow(t, n, &old, msg)
+	}
+}
+
+// Check the QSC window invariants of node n after it received msg
+// in the state recorded in old.
+func testWindow(t *testing.T, n *Node, old, msg *Message) {
+	if n.m.Step < old.Step {
+		t.Fatalf("step regressed from %v to %v on %+v",
+			old.Step, n.m.Step, msg)
+	}
+	if len(n.m.QSC) != n.m.Step+n.depth+1 {
+		t.Fatalf("step %v has %v QSC rounds", n.m.Step, len(n.m.QSC))
+	}
+	for s, r := range n.m.QSC {
+		if r.Spoil.From < -1 || r.Spoil.From >= n.nnode ||
+			r.Conf.From < 0 || r.Conf.From >= n.nnode ||
+			r.Reconf.From < 0 || r.Reconf.From >= n.nnode {
+			t.Fatalf("round %v has invalid node number: %+v", s, r)
+		}
+		if s >= len(old.QSC) {
+			continue
+		}
+		o := old.QSC[s]
+		if s <= old.Step && r != o {
+			t.Fatalf("decided round %v changed from %+v to %+v on %+v",
+				s, o, r, msg)
+		}
+		if r.Spoil.Tkt < o.Spoil.Tkt || r.Conf.Tkt < o.Conf.Tkt ||
+			r.Reconf.Tkt < o.Reconf.Tkt {
+			t.Fatalf("round %v regressed from %+v to %+v on %+v",
+				s, o, r, msg)
+		}
+	}
+}
+
+// Fuzz the node's message processing with arbitrary message streams.
+// Run with go test -fuzz FuzzReceive to search beyond the seed corpus.
+func FuzzReceive(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{2, 0, 0, 0, 3, 1, 2, 2, 1, 0, 3, 0, 1, 1, 1, 2, 2})
+	f.Add([]byte{1, 2, 2, 0, 0xff, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{4, 4, 3, 9, 0x83, 0, 1, 2, 3, 4, 5, 6, 7, 8})
+	f.Fuzz(testReceive)
+}
+
+// Test the node's message processing with many random message streams.
+func TestReceiveRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		data := make([]byte, rng.Intn(4000))
+		rng.Read(data)
+		testReceive(t, data)
+	}
+}
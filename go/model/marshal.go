@@ -0,0 +1,225 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Message provides a compact binary encoding,
+// via the standard encoding.BinaryMarshaler and BinaryUnmarshaler
+// interfaces, which encoding/gob also uses automatically.
+// A Message's QSC window repeats nearly the same Best records
+// from one round to the next and within each round,
+// so the encoding sends each Best record only where it changes:
+// a bitfield for each Round flags which of its records
+// equal the corresponding record of the round before,
+// or the record before it within the same round.
+// Node numbers and time steps are varints,
+// as are tickets, rotated so that the high bit that Node sets
+// in every ticket costs nothing beyond the ticket's other bits.
+// Bandwidth-sensitive clients that marshal Messages themselves
+// can thus roughly halve their size.
+
+// Flags in the first byte of an encoded Message,
+// whose low bits hold its Type.
+const (
+	msgTypeMask = 0x03 // mask for the message Type
+	msgAcked    = 0x04 // Acked is set
+)
+
+// Flags in the first byte of each encoded Round.
+const (
+	rndCommit     = 1 << iota // Commit is set
+	rndSpoilPrev              // Spoil equals the previous round's
+	rndConfPrev               // Conf equals the previous round's
+	rndReconfPrev             // Reconf equals the previous round's
+	rndConfSpoil              // Conf equals this round's Spoil
+	rndReconfConf             // Reconf equals this round's Conf
+)
+
+var errMalformed = errors.New("malformed message encoding")
+
+// AppendBinary appends the compact encoding of msg to b.
+func (msg *Message) AppendBinary(b []byte) ([]byte, error) {
+	if msg.Type < Raw || msg.Type > Wit {
+		return nil, errors.New("invalid message type")
+	}
+	flags := byte(msg.Type)
+	if msg.Acked {
+		flags |= msgAcked
+	}
+	b = append(b, flags)
+	b = binary.AppendVarint(b, int64(msg.From))
+	b = binary.AppendVarint(b, int64(msg.Step))
+	b = appendTicket(b, msg.Tkt)
+
+	b = binary.AppendUvarint(b, uint64(len(msg.QSC)))
+	prev := Round{}
+	for _, r := range msg.QSC {
+		b = appendRound(b, &r, &prev)
+		prev = r
+	}
+	return b, nil
+}
+
+// MarshalBinary returns the compact encoding of msg.
+func (msg *Message) MarshalBinary() ([]byte, error) {
+	return msg.AppendBinary(nil)
+}
+
+// UnmarshalBinary decodes the compact encoding of a Message into msg,
+// reusing msg's QSC slice if it has the capacity.
+func (msg *Message) UnmarshalBinary(b []byte) error {
+	d := decoder{b: b}
+	flags := d.byte()
+	if flags&^(msgTypeMask|msgAcked) != 0 ||
+		Type(flags&msgTypeMask) > Wit {
+		return errMalformed
+	}
+	msg.Type = Type(flags & msgTypeMask)
+	msg.Acked = flags&msgAcked != 0
+	msg.From = d.int()
+	msg.Step = d.int()
+	msg.Tkt = d.ticket()
+
+	n := d.uvarint()
+	if n > uint64(len(d.b)) { // each Round takes at least a byte
+		return errMalformed
+	}
+	msg.QSC = msg.QSC[:0]
+	prev := Round{}
+	for i := uint64(0); i < n; i++ {
+		prev = d.round(&prev)
+		msg.QSC = append(msg.QSC, prev)
+	}
+	if d.err || len(d.b) != 0 {
+		return errMalformed
+	}
+	return nil
+}
+
+// Append the encoding of Round r, which follows Round prev.
+func appendRound(b []byte, r, prev *Round) []byte {
+	flags := byte(0)
+	if r.Commit {
+		flags |= rndCommit
+	}
+	if r.Spoil == prev.Spoil {
+		flags |= rndSpoilPrev
+	}
+	switch {
+	case r.Conf == prev.Conf:
+		flags |= rndConfPrev
+	case r.Conf == r.Spoil:
+		flags |= rndConfSpoil
+	}
+	switch {
+	case r.Reconf == prev.Reconf:
+		flags |= rndReconfPrev
+	case r.Reconf == r.Conf:
+		flags |= rndReconfConf
+	}
+	b = append(b, flags)
+	if flags&rndSpoilPrev == 0 {
+		b = appendBest(b, &r.Spoil)
+	}
+	if flags&(rndConfPrev|rndConfSpoil) == 0 {
+		b = appendBest(b, &r.Conf)
+	}
+	if flags&(rndReconfPrev|rndReconfConf) == 0 {
+		b = appendBest(b, &r.Reconf)
+	}
+	return b
+}
+
+func appendBest(b []byte, best *Best) []byte {
+	b = binary.AppendVarint(b, int64(best.From))
+	return appendTicket(b, best.Tkt)
+}
+
+func appendTicket(b []byte, tkt uint64) []byte {
+	return binary.AppendUvarint(b, bits.RotateLeft64(tkt, 1))
+}
+
+// A decoder consumes an encoded Message,
+// noting any error rather than returning it from each call.
+type decoder struct {
+	b   []byte // encoding left to decode
+	err bool   // whether the encoding was malformed
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) == 0 {
+		d.err = true
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = true
+		d.b = nil
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) int() int {
+	v, n := binary.Varint(d.b)
+	if n <= 0 || int64(int(v)) != v {
+		d.err = true
+		d.b = nil
+		return 0
+	}
+	d.b = d.b[n:]
+	return int(v)
+}
+
+func (d *decoder) ticket() uint64 {
+	return bits.RotateLeft64(d.uvarint(), -1)
+}
+
+func (d *decoder) best() Best {
+	return Best{From: d.int(), Tkt: d.ticket()}
+}
+
+// Decode a Round following Round prev.
+func (d *decoder) round(prev *Round) (r Round) {
+	flags := d.byte()
+	if flags >= rndReconfConf<<1 ||
+		flags&(rndConfPrev|rndConfSpoil) == rndConfPrev|rndConfSpoil ||
+		flags&(rndReconfPrev|rndReconfConf) ==
+			rndReconfPrev|rndReconfConf {
+		d.err = true
+	}
+	r.Commit = flags&rndCommit != 0
+	switch {
+	case flags&rndSpoilPrev != 0:
+		r.Spoil = prev.Spoil
+	default:
+		r.Spoil = d.best()
+	}
+	switch {
+	case flags&rndConfPrev != 0:
+		r.Conf = prev.Conf
+	case flags&rndConfSpoil != 0:
+		r.Conf = r.Spoil
+	default:
+		r.Conf = d.best()
+	}
+	switch {
+	case flags&rndReconfPrev != 0:
+		r.Reconf = prev.Reconf
+	case flags&rndReconfConf != 0:
+		r.Reconf = r.Conf
+	default:
+		r.Reconf = d.best()
+	}
+	return r
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Test that Messages survive the compact encoding unchanged,
+// by running simulations that deliver only decoded copies,
+// and that the encoding is at most half the size of plain gob encoding.
+func TestMarshal(t *testing.T) {
+	testMarshal(t, 2, 3, 0)
+	testMarshal(t, 3, 5, 0)
+	testMarshal(t, 3, 5, 10) // low-entropy tickets, as in tests
+}
+
+func testMarshal(t *testing.T, thres, nnode int, maxTicket int64) {
+	s := NewSim(thres, nnode, 1, &Random{rand.New(rand.NewSource(1))})
+	type plain Message // without the compact encoding methods
+	buf := &bytes.Buffer{}
+	enc := gob.NewEncoder(buf)
+	size := 0
+	for _, n := range s.Nodes {
+		if maxTicket > 0 {
+			rng := rand.New(rand.NewSource(int64(n.m.From)))
+			n.Rand = func() int64 { return rng.Int63n(maxTicket) }
+		}
+		send := n.send
+		n.send = func(to int, msg *Message) {
+			b, err := msg.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += len(b)
+			if err := enc.Encode((*plain)(msg)); err != nil {
+				t.Fatal(err)
+			}
+
+			dec := &Message{}
+			if err := dec.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dec, msg) {
+				t.Fatalf("decoded %+v, not %+v", dec, msg)
+			}
+			send(to, dec)
+		}
+	}
+	if err := s.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	testResults(t, s.Nodes)
+	t.Logf("T=%v,N=%v: compact %v bytes, gob %v bytes",
+		thres, nnode, size, buf.Len())
+	if size > buf.Len()/2 {
+		t.Errorf("compact encoding %v bytes, gob %v", size, buf.Len())
+	}
+}
+
+// Test that malformed encodings yield errors rather than panics.
+func TestUnmarshalMalformed(t *testing.T) {
+	msg := &Message{From: 1, Step: 7, Type: Raw, Tkt: 1<<63 | 5,
+		QSC: []Round{{Commit: true}, {Spoil: Best{2, 9}}, {}, {}}}
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(b); i++ {
+		if err := (&Message{}).UnmarshalBinary(b[:i]); err == nil {
+			t.Errorf("decoded truncated encoding %x", b[:i])
+		}
+	}
+	if err := (&Message{}).UnmarshalBinary(append(b, 0)); err == nil {
+		t.Errorf("decoded encoding with trailing garbage")
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		c := append([]byte{}, b...)
+		c[rng.Intn(len(c))] = byte(rng.Intn(256))
+		(&Message{}).UnmarshalBinary(c)
+	}
+}
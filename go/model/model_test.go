@@ -1,12 +1,42 @@
 package model
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
+	"os"
 	"sync"
 	"testing"
+
+	"github.com/dedis/tlc/go/lib/checker"
+	"github.com/dedis/tlc/go/lib/stats"
 )
 
+// The -stats test flag names a CSV file in which the test harness
+// records per-round statistics of each consensus test case it runs,
+// for analysis as the stats package describes.
+var statsPath = flag.String("stats", "",
+	"CSV file in which to record per-round statistics")
+
+var statsOnce sync.Once
+var statsOut *stats.Writer
+
+// Return the Writer recording per-round statistics, or nil if disabled.
+func testStatsWriter(t *testing.T) *stats.Writer {
+	statsOnce.Do(func() {
+		if *statsPath == "" {
+			return
+		}
+		f, err := os.Create(*statsPath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		statsOut = stats.NewWriter(f)
+	})
+	return statsOut
+}
+
 func (n *Node) run(maxSteps int, peer []chan *Message, wg *sync.WaitGroup) {
 
 	// broadcast message for initial time step s=0
@@ -32,7 +62,16 @@ func testRun(t *testing.T, thres, nnode, maxSteps, maxTicket int) {
 	t.Run(desc, func(t *testing.T) {
 		all := make([]*Node, nnode)
 		peer := make([]chan *Message, nnode)
-		send := func(dst int, msg *Message) { peer[dst] <- msg }
+		sent := make([][]int, nnode) // messages each node sent each step
+		send := func(dst int, msg *Message) {
+			peer[dst] <- msg
+
+			// Only the sender's goroutine touches its counts.
+			for len(sent[msg.From]) <= msg.Step {
+				sent[msg.From] = append(sent[msg.From], 0)
+			}
+			sent[msg.From][msg.Step]++
+		}
 
 		for i := range all { // Initialize all the nodes
 			peer[i] = make(chan *Message, 3*nnode*maxSteps)
@@ -50,9 +89,35 @@ func testRun(t *testing.T, thres, nnode, maxSteps, maxTicket int) {
 		}
 		wg.Wait()
 		testResults(t, all) // Report test results
+		testStats(t, all, sent, maxTicket)
 	})
 }
 
+// Record per-round statistics of a test run if the -stats flag is set,
+// given the number of messages each node sent in each step.
+func testStats(t *testing.T, all []*Node, sent [][]int, maxTicket int) {
+	w := testStatsWriter(t)
+	if w == nil {
+		return
+	}
+	for _, n := range all {
+		for s := 0; s+n.depth <= n.m.Step; s++ {
+			r := &n.m.QSC[s+n.depth]
+			err := w.Write(stats.Round{Run: t.Name(),
+				Nodes: n.nnode, Threshold: n.thres,
+				Entropy: stats.Entropy(int64(maxTicket)),
+				Node:    n.m.From, Step: s, Commit: r.Commit,
+				Chosen: r.Conf.From, Messages: sent[n.m.From][s]})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Dump the consensus state of node n in round s
 func (n *Node) testDump(t *testing.T, s, nnode int) {
 	r := &n.m.QSC[s]
@@ -63,6 +128,7 @@ func (n *Node) testDump(t *testing.T, s, nnode int) {
 
 // Globally sanity-check and summarize each node's observed results.
 func testResults(t *testing.T, all []*Node) {
+	testCheck(t, all)
 	for i, ni := range all {
 		commits := 0
 		for s, si := range ni.m.QSC {
@@ -104,3 +170,57 @@ func TestQSC(t *testing.T) {
 	testRun(t, 2, 3, 100000, 2) // Extreme low-entropy: rarely commits
 	testRun(t, 2, 3, 100000, 3) // A bit better bit still bad...
 }
+
+// Test threshold derivation and validation in NewNodeF.
+func TestNewNodeF(t *testing.T) {
+	for _, c := range []struct{ nnode, f, thres int }{
+		{1, 0, 1}, {2, 0, 2}, {3, 1, 2}, {4, 1, 3}, {5, 2, 3},
+		{3, -1, 2}, {4, -1, 3}, {10, -1, 6}, {21, -1, 11},
+		{2, 1, 0}, {4, 2, 0}, {0, 0, 0}, // unsafe configurations
+	} {
+		n, err := NewNodeF(0, c.nnode, c.f, nil)
+		if c.thres == 0 {
+			if err == nil {
+				t.Errorf("N=%v,F=%v: expected error", c.nnode, c.f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("N=%v,F=%v: %v", c.nnode, c.f, err)
+			continue
+		}
+		if n.Threshold() != c.thres || n.Nodes() != c.nnode ||
+			n.Faulty() != c.nnode-c.thres {
+			t.Errorf("N=%v,F=%v: got T=%v,F=%v",
+				c.nnode, c.f, n.Threshold(), n.Faulty())
+		}
+	}
+	if _, err := NewNodeF(3, 3, 1, nil); err == nil {
+		t.Errorf("node number out of range accepted")
+	}
+
+	// Even-sized groups with derived thresholds must work too.
+	for _, nnode := range []int{4, 6} {
+		thres, _ := Threshold(nnode, -1)
+		testRun(t, thres, nnode, 10000, 0)
+	}
+}
+
+// Validate each node's summary of each completed round
+// against the abstract QSC commit rule.
+func testCheck(t *testing.T, all []*Node) {
+	c := &checker.Checker{}
+	for _, n := range all {
+		for s := 0; s+n.depth <= n.m.Step; s++ {
+			r := &n.m.QSC[s+n.depth]
+			c.Summary(&checker.Summary{Node: n.m.From, Step: s,
+				Spoil:  checker.Best{From: r.Spoil.From, Ticket: r.Spoil.Tkt},
+				Conf:   checker.Best{From: r.Conf.From, Ticket: r.Conf.Tkt},
+				Reconf: checker.Best{From: r.Reconf.From, Ticket: r.Reconf.Tkt},
+				Commit: r.Commit})
+		}
+	}
+	if err := c.Err(); err != nil {
+		t.Error(err)
+	}
+}
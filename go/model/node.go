@@ -1,6 +1,11 @@
 package model
 
-import "math/rand"
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/dedis/tlc/go/lib/witness"
+)
 
 // Type represents the type of a QSC message: either Raw, Ack, or Wit.
 //
@@ -13,6 +18,12 @@ import "math/rand"
 // acknowledging that they have seen the sender's proposal
 // and merged in its QSC state.
 //
+// A node that receives a Raw message from a time step ahead of its own
+// advances to that step, broadcasting its own Raw proposal.
+// If the node's Piggyback option is set, it sends that proposal
+// to the sender of the Raw message it received only after merging in
+// the sender's QSC state, with Acked set, in place of a separate Ack.
+//
 // Once a node has received a threshold of Ack messages to its Raw proposal,
 // the node broadcasts a Wit message to announce that its proposal is witnessed.
 // Nodes wait to collect a threshold of Wit messages as their condition
@@ -29,13 +40,20 @@ const (
 	Wit
 )
 
+// DefaultDepth is the default depth of a Node's QSC pipeline,
+// the number of time steps each consensus round spans,
+// which is also the minimum depth at which QSC works.
+const DefaultDepth = 3
+
 // Message contains the information nodes must pass in messages
 // both to run the TLC clocking protocol and achieve QSC consensus.
 //
 // This implementation of QSC performs no message marshaling or unmarshalling;
 // the client using it must handle message wire-format serialization.
 // However, the Message struct is defined so as to be compatible with
-// standard Go encoders such as encoding/gob or encoding/json.
+// standard Go encoders such as encoding/gob or encoding/json,
+// and provides a compact binary encoding via MarshalBinary,
+// which encoding/gob uses automatically.
 // The client may also marshal/unmarshal its own larger message struct
 // containing a superset of the information here,
 // such as to attach semantic content in some form to consensus proposals.
@@ -45,6 +63,8 @@ type Message struct {
 	Type Type    // Message type: Prop, Ack, or Wit
 	Tkt  uint64  // Genetic fitness ticket for consensus
 	QSC  []Round // QSC consensus state for rounds ending at Step or later
+
+	Acked bool // Whether this Raw message acknowledges recipient's proposal
 }
 
 // Node contains per-node state and configuration for TLC and QSC.
@@ -62,22 +82,49 @@ type Message struct {
 // a full implementation should use cryptographic randomness
 // and hide the tickets from the network using encryption (e.g., TLS).
 //
+// Setting Piggyback saves one message each time a node catches up
+// to a time step on receiving a peer's proposal,
+// by acknowledging the peer's proposal in the node's own proposal,
+// as the Type documentation describes.
+// Every node in the group must be able to receive a Message's Acked field,
+// so the client must marshal that field if it sets Piggyback on any node.
+//
+// SetDepth configures a deeper QSC pipeline than the DefaultDepth,
+// letting each consensus round span more time steps,
+// so that more rounds are active concurrently.
+// Deeper rounds give nodes more time to learn of competing proposals
+// before deciding each round, at the cost of a longer commit latency.
+//
 // The Rand function must not be changed once the Node is in operation.
 // All nodes must use the same nonnegative random number distribution.
 // Ticket collisions are not a problem as long as they are rare,
 // which is why 63 bits of entropy is sufficient.
+// Similarly, all nodes in the group must use the same pipeline depth.
+//
+// By default, a ticket collision between the best proposals in a round
+// spoils the round, so that it cannot commit.
+// Setting TieBreak instead breaks ticket ties in favor of
+// the proposal from the higher-numbered node,
+// which preserves the commit rate when tickets have little entropy,
+// at the cost of favoring higher-numbered nodes when tickets tie:
+// see the package documentation.
+// All nodes in the group must set TieBreak alike,
+// and must not change it once the Node is in operation.
 //
 type Node struct {
 	m Message // Template for messages we send
 
 	thres int                          // TLC message and witness thresholds
 	nnode int                          // Total number of nodes
+	depth int                          // Time steps per consensus round
 	send  func(peer int, msg *Message) // Function to send message to a peer
 
-	acks int // # acknowledgments we've received in this step
-	wits int // # threshold witnessed messages seen this step
+	witness witness.Tracker // Acks and Wits we've received in this step
 
 	Rand func() int64 // Function to generate random genetic fitness tickets
+
+	Piggyback bool // Whether to piggyback Acks on Raw proposals
+	TieBreak  bool // Whether to break ticket ties by node number
 }
 
 // NewNode creates and initializes a new Node with the specified group configuration.
@@ -92,7 +139,94 @@ type Node struct {
 func NewNode(self, thres, nnode int, send func(peer int, msg *Message)) (n *Node) {
 	return &Node{
 		m: Message{From: self, Step: -1,
-			QSC: make([]Round, 3)}, // "rounds" ending in steps 0-2
-		thres: thres, nnode: nnode, send: send,
+			QSC: make([]Round, DefaultDepth)}, // "rounds" ending in steps 0-2
+		thres: thres, nnode: nnode, depth: DefaultDepth, send: send,
 		Rand: rand.Int63}
 }
+
+// SetDepth sets the depth of the Node's QSC pipeline,
+// the number of time steps each consensus round spans,
+// which must be at least DefaultDepth.
+// The caller must set the depth before calling Advance,
+// and must set the same depth on all the nodes in the group.
+func (n *Node) SetDepth(depth int) error {
+	if depth < DefaultDepth {
+		return errors.New("QSC pipeline depth must be at least 3")
+	}
+	if n.m.Step >= 0 {
+		return errors.New("cannot change QSC pipeline depth " +
+			"once the node has started")
+	}
+	n.depth = depth
+	n.m.QSC = make([]Round, depth) // "rounds" ending in steps 0-(depth-1)
+	return nil
+}
+
+// Depth returns the depth of the Node's QSC pipeline.
+func (n *Node) Depth() int {
+	return n.depth
+}
+
+// NewNodeF creates and initializes a new Node like NewNode,
+// but derives the TLC message and witness threshold
+// from the number of node failures f the group must tolerate,
+// instead of requiring the caller to supply a raw threshold.
+// If f is negative, NewNodeF uses the maximum tolerable f,
+// namely a minority of nnode.
+//
+// The derived threshold is nnode-f, the largest threshold that still
+// lets the group make progress with f nodes failed.
+// Because this package tolerates only failstop (non-Byzantine) failures,
+// safety requires any two threshold sets of nodes to overlap,
+// i.e., that the threshold be a strict majority of the group.
+// NewNodeF thus returns an error unless nnode > 2f.
+// Both odd and even group sizes are supported,
+// although an even-sized group tolerates no more failures
+// than a group one node smaller.
+//
+func NewNodeF(self, nnode, f int, send func(peer int, msg *Message)) (
+	*Node, error) {
+
+	thres, err := Threshold(nnode, f)
+	if err != nil {
+		return nil, err
+	}
+	if self < 0 || self >= nnode {
+		return nil, errors.New("node number out of range")
+	}
+	return NewNode(self, thres, nnode, send), nil
+}
+
+// Threshold returns the TLC message and witness threshold NewNodeF uses
+// for a group of nnode nodes tolerating f failures,
+// or an error if the configuration is unsafe.
+// If f is negative, Threshold uses the maximum tolerable f.
+func Threshold(nnode, f int) (int, error) {
+	if nnode < 1 {
+		return 0, errors.New("group must have at least one node")
+	}
+	if f < 0 {
+		f = (nnode - 1) / 2 // Default maximum fault tolerance
+	}
+	if 2*f >= nnode {
+		return 0, errors.New("tolerating f failures requires " +
+			"more than 2f nodes")
+	}
+	return nnode - f, nil
+}
+
+// Threshold returns the Node's TLC message and witness threshold.
+func (n *Node) Threshold() int {
+	return n.thres
+}
+
+// Nodes returns the total number of nodes in the Node's group.
+func (n *Node) Nodes() int {
+	return n.nnode
+}
+
+// Faulty returns the number of node failures the Node's group tolerates
+// while remaining live, given its threshold.
+func (n *Node) Faulty() int {
+	return n.nnode - n.thres
+}
@@ -19,17 +19,23 @@ package model
 // an invalid node number that will be unequal to, and hence properly "spoil",
 // a confirmed or reconfirmed proposal with the same ticket from any node.
 //
+// With deterministic tie-breaking, as Node.TieBreak enables,
+// proposals are instead ranked by ticket and then by node number,
+// so that no two proposals in a round tie and no collisions arise.
+//
 type Best struct {
 	From int    // Node the proposal is from (spoiler: -1 for tied tickets)
 	Tkt  uint64 // Proposal's genetic fitness ticket
 }
 
 // Find the Best of two records primarily according to highest ticket number.
-// For spoilers, detect and record ticket collisions with invalid node number.
-func (b *Best) merge(o *Best, spoiler bool) {
-	if o.Tkt > b.Tkt {
-		*b = *o // strictly better ticket
-	} else if o.Tkt == b.Tkt && o.From != b.From && spoiler {
+// If tiebreak is set, break ticket ties in favor of the higher node number.
+// Otherwise, for spoilers, detect and record ticket collisions
+// with invalid node number.
+func (b *Best) merge(o *Best, spoiler, tiebreak bool) {
+	if o.Tkt > b.Tkt || (tiebreak && o.Tkt == b.Tkt && o.From > b.From) {
+		*b = *o // strictly better ticket, or tie broken in favor of o
+	} else if o.Tkt == b.Tkt && o.From != b.From && spoiler && !tiebreak {
 		b.From = -1 // record ticket collision
 	}
 }
@@ -48,11 +54,11 @@ type Round struct {
 }
 
 // Merge QSC round info from an incoming message into our round history
-func mergeQSC(b, o []Round) {
+func mergeQSC(b, o []Round, tiebreak bool) {
 	for i := range b {
-		b[i].Spoil.merge(&o[i].Spoil, true)
-		b[i].Conf.merge(&o[i].Conf, false)
-		b[i].Reconf.merge(&o[i].Reconf, false)
+		b[i].Spoil.merge(&o[i].Spoil, true, tiebreak)
+		b[i].Conf.merge(&o[i].Conf, false, tiebreak)
+		b[i].Reconf.merge(&o[i].Reconf, false, tiebreak)
 	}
 }
 
@@ -79,8 +85,9 @@ func (n *Node) witnessedQSC() {
 	// Our proposal is now confirmed in the consensus round just starting
 	// Find best confirmed proposal, breaking ties in favor of lower node
 	myBest := &Best{From: n.m.From, Tkt: n.m.Tkt}
-	n.m.QSC[n.m.Step+3].Conf.merge(myBest, false)
+	n.m.QSC[n.m.Step+n.depth].Conf.merge(myBest, false, n.TieBreak)
 
 	// Find reconfirmed proposals for the consensus round that's in step 1
-	n.m.QSC[n.m.Step+2].Reconf.merge(&n.m.QSC[n.m.Step+2].Conf, false)
+	r := &n.m.QSC[n.m.Step+n.depth-1]
+	r.Reconf.merge(&r.Conf, false, n.TieBreak)
 }
@@ -0,0 +1,189 @@
+// Package bootstrap provisions the member stores of a new QSCOD consensus group,
+// which may reside on a mix of storage backends,
+// and records the group's configuration in a group descriptor.
+// The group's authoritative configuration is stored in-band,
+// as committed by Bootstrap: see qscas.Config.
+// The descriptor is an offline record of it,
+// which also records each member's backend.
+//
+// Each member is identified by a string whose form selects its backend:
+// a URL such as s3://bucket/path or https://host/path
+// selects the backend registered for the URL's scheme,
+// an scp-style host:path or an ssh:// URL selects the "ssh" backend,
+// and anything else is a local directory path.
+// Local directories are supported natively;
+// applications provide other backends by calling Register.
+//
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/fs/atomic"
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// Backend opens the member store a member identifier designates,
+// creating and initializing it first if create is true.
+// When creating a store, a Backend must fail if it already exists,
+// so that bootstrapping never clobbers an existing group's state.
+type Backend func(ctx context.Context, member string, create bool) (
+	cas.Store, error)
+
+var backends = map[string]Backend{"file": openDir}
+var backendsMut sync.Mutex
+
+// Register makes a storage backend available for members
+// whose identifiers select the given scheme,
+// replacing any backend previously registered for the scheme.
+func Register(scheme string, b Backend) {
+	backendsMut.Lock()
+	defer backendsMut.Unlock()
+
+	backends[scheme] = b
+}
+
+// Scheme returns the name of the backend a member identifier selects.
+func Scheme(member string) string {
+	if i := strings.Index(member, "://"); i > 0 {
+		return member[:i]
+	}
+	if i := strings.IndexByte(member, ':'); i > 0 &&
+		!strings.ContainsAny(member[:i], "/\\") {
+		return "ssh"
+	}
+	return "file"
+}
+
+// Open opens the member store a member identifier designates,
+// using the backend its scheme selects,
+// creating and initializing the store first if create is true.
+func Open(ctx context.Context, member string, create bool) (
+	cas.Store, error) {
+
+	b, err := backend(member)
+	if err != nil {
+		return nil, err
+	}
+	return b(ctx, member, create)
+}
+
+// Return the backend a member identifier selects.
+func backend(member string) (Backend, error) {
+	backendsMut.Lock()
+	defer backendsMut.Unlock()
+
+	scheme := Scheme(member)
+	if b := backends[scheme]; b != nil {
+		return b, nil
+	}
+	return nil, fmt.Errorf("member %s: no %s backend available",
+		member, scheme)
+}
+
+// The native backend for members in local directories.
+func openDir(ctx context.Context, member string, create bool) (
+	cas.Store, error) {
+
+	member = strings.TrimPrefix(member, "file://")
+	st := &casdir.Store{}
+	if err := st.Init(member, create, create); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Descriptor describes the configuration of a consensus group.
+type Descriptor struct {
+	Members []Member // Group members, in group order
+	Faulty  int      // Number of faulty members the group tolerates
+	Tr, Ts  int      // Receive and spread thresholds
+	Version int64    // Version of the initial configuration committed
+}
+
+// Member describes one member of a consensus group.
+type Member struct {
+	ID      string // Member identifier
+	Backend string // Name of the member's storage backend
+}
+
+// Bootstrap creates and initializes a new consensus group's member stores,
+// tolerating up to faulty failed members, or the default if faulty < 0,
+// then validates the configuration by committing it in-band,
+// and returns the group's descriptor.
+//
+// Bootstrap creates no member stores unless all of their backends
+// are available and the thresholds are valid,
+// but it does not remove stores it created if a later step fails.
+//
+func Bootstrap(ctx context.Context, members []string, faulty int) (
+	*Descriptor, error) {
+
+	if len(members) < 3 {
+		return nil, errors.New(
+			"consensus groups must have minimum three members")
+	}
+	if faulty < 0 {
+		faulty = len(members) / 3
+	}
+	conf, err := qscas.NewConfig(members, faulty, "")
+	if err != nil {
+		return nil, err
+	}
+	d := &Descriptor{Faulty: faulty, Tr: conf.Tr, Ts: conf.Ts}
+	for _, m := range members {
+		if _, err := backend(m); err != nil {
+			return nil, err
+		}
+		d.Members = append(d.Members, Member{m, Scheme(m)})
+	}
+
+	// Create each member store.
+	stores := make([]cas.Store, len(members))
+	for i, m := range members {
+		if stores[i], err = Open(ctx, m, true); err != nil {
+			return nil, err
+		}
+	}
+
+	// Commit the initial configuration in-band through consensus
+	// across the new stores.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g := (&qscas.Group{}).Start(ctx, stores, faulty)
+	if d.Version, err = g.Reconfigure(ctx, conf); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Write writes a group descriptor to a new file,
+// failing if the file already exists.
+func (d *Descriptor) Write(path string) error {
+	buf, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFileOnce(path, append(buf, '\n'), 0644)
+}
+
+// Read reads a group descriptor from a file.
+func Read(path string) (*Descriptor, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Descriptor{}
+	if err := json.Unmarshal(buf, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
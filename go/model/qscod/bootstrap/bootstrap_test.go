@@ -0,0 +1,93 @@
+package bootstrap
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+func TestScheme(t *testing.T) {
+	for member, scheme := range map[string]string{
+		"/var/qsc/a":         "file",
+		"relative/dir":       "file",
+		"file:///var/qsc/a":  "file",
+		"host1:path1":        "ssh",
+		"ssh://host1/path1":  "ssh",
+		"s3://bucket/qsc":    "s3",
+		"https://host/qsc/a": "https",
+		"./odd:name":         "file",
+		"C\\odd:name":        "file",
+	} {
+		if s := Scheme(member); s != scheme {
+			t.Errorf("%s: scheme %s, expected %s", member, s, scheme)
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Simulate a remote backend with in-memory registers.
+	regs := make(map[string]*cas.Register)
+	Register("mem", func(ctx context.Context, member string,
+		create bool) (cas.Store, error) {
+		if create {
+			regs[member] = &cas.Register{}
+		}
+		return regs[member], nil
+	})
+
+	members := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"),
+		"mem://c", "mem://d"}
+	d, err := Bootstrap(ctx, members, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Faulty != 1 || d.Tr != 3 || d.Ts != 2 || d.Version <= 0 ||
+		d.Members[0].Backend != "file" || d.Members[2].Backend != "mem" {
+		t.Errorf("bad descriptor %+v", d)
+	}
+
+	// The group's configuration must be committed in-band.
+	stores := make([]cas.Store, len(members))
+	for i, m := range members {
+		if stores[i], err = Open(ctx, m, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c, err := (&qscas.Group{}).Start(gctx, stores, -1).Config(gctx)
+	if err != nil || !reflect.DeepEqual(c.Members, members) {
+		t.Errorf("in-band configuration %+v %v", c, err)
+	}
+
+	// The descriptor must round-trip through its file,
+	// which must not be overwritten.
+	path := filepath.Join(dir, "group.json")
+	if err := d.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write(path); err == nil {
+		t.Errorf("overwrote descriptor")
+	}
+	rd, err := Read(path)
+	if err != nil || !reflect.DeepEqual(rd, d) {
+		t.Errorf("read descriptor %+v %v", rd, err)
+	}
+
+	// Existing stores must not be bootstrapped again,
+	// and members must have available backends.
+	if _, err := Bootstrap(ctx, members, -1); err == nil {
+		t.Errorf("bootstrapped existing stores")
+	}
+	if _, err := Bootstrap(ctx, []string{filepath.Join(dir, "e"),
+		filepath.Join(dir, "f"), "s3://bucket/g"}, -1); err == nil {
+		t.Errorf("bootstrapped member without backend")
+	}
+}
@@ -6,16 +6,18 @@
 // These algorithms are extremely simple but do impose one constraint:
 // the number of failing nodes must be at most one-third the group size.
 //
-// The unit tests for this package is in the test sub-package,
-// so that useful test framework code can be shared with other packages
+// The unit tests for this package are in the testsuite package,
+// which exports the test framework code so that every Store implementation
+// can run the same battery of tests
 // without requiring any of it to be imported into development builds.
-// (Too bad Go doesn't allow packages to export and import test code.)
 //
 package core
 
 //import "fmt"
 import "sync"
 import "context"
+import "time"
+import "sort"
 
 // Store represents an interface to one of the n key/value stores
 // representing the persistent state of each of the n consensus group members.
@@ -41,6 +43,9 @@ import "context"
 // which may simply return the value v that was requested to be written
 // in order to allow the per-node worker thread to terminate cleanly.
 //
+// Clients in the same process that share a Store may wrap it in a
+// proxy.Proxy, which coalesces their WriteRead calls on the same step.
+//
 type Store interface {
 	WriteRead(v Value) Value
 }
@@ -51,6 +56,7 @@ type Value struct {
 	P    string // Application data string for this proposal
 	I    int64  // Random integer priority for this proposal
 	R, B Set    // Read set and broadcast set from TLCB
+	H    uint64 // Hash of the writer's group configuration, or 0
 }
 
 // Set represents a set of proposed values from the same time-step,
@@ -73,6 +79,16 @@ func (S Set) best() (bn int, bv Value, bu bool) {
 	return bn, bv, bu
 }
 
+// nodes returns the node numbers of the values in a Set, in increasing order.
+func (S Set) nodes() []int {
+	n := make([]int, 0, len(S))
+	for i := range S {
+		n = append(n, i)
+	}
+	sort.Ints(n)
+	return n
+}
+
 // Client represents a logical client that can propose transactions
 // to the consensus group and drive the QSC/TLC state machine forward
 // asynchronously across the key/value storesu defining the group's state.
@@ -116,21 +132,66 @@ func (S Set) best() (bn int, bv Value, bu bool) {
 // and should be generated from a cryptographically strong private source
 // for maximum protection against denial-of-service attacks in the network.
 //
+// Ev is an optional callback that the Client calls on each commit it observes,
+// just before calling Pr, to report evidence of the commit:
+// the committed proposal's step number,
+// the step number at which the Client observed it to be committed,
+// and the nodes whose values the Client read at that step,
+// from which it determined the commit.
+//
+// Applications that only need to learn of commits, in order,
+// may receive them from the Commits channel instead of through Pr.
+//
+// Pace optionally enables contention control:
+// when many clients share a group and propose in every round,
+// a paced client adaptively sits out rounds to improve overall throughput.
+// The client still calls Pr in every round to report commitment.
+//
+// Lat optionally enables latency-aware scheduling of Store accesses,
+// so that slow Stores do not fall ever further behind: see Latency.
+//
+// Conc optionally bounds the number of WriteRead calls the Client
+// keeps in flight to each Store, or is 0 or 1 for one at a time.
+// With Conc greater than 1, a Store still busy with one time step
+// may already start on the next ones once the group completes them,
+// pipelining consecutive step writes to Stores with high latency,
+// such as network file systems, instead of waiting on each in turn.
+// Every Store must then support concurrent WriteRead calls.
+//
+// Yield is an optional hook that the Client calls at its scheduling points:
+// around each call to a Store and each time it releases its mutex.
+// Tests may use it to perturb goroutine scheduling: see package chaos.
+//
+// Go is an optional hook that the Client calls to start each goroutine,
+// running function f, so that the caller can account for the goroutines
+// still running after Run returns, such as workers blocked in a Store.
+// If Go is nil, the Client starts goroutines with the go statement.
+//
 type Client struct {
 	KV     []Store // Per-node key/value state storage interfaces
 	Tr, Ts int     // Receive and spread threshold configuration
 
 	Pr func(int64, string, bool) (string, int64) // Proposal function
+	Ev func(int64, int64, []int)                 // Commit evidence callback
+
+	Pace  *Pacing        // Optional adaptation to contention, or nil
+	Lat   *Latency       // Optional latency-aware scheduling, or nil
+	Conc  int            // Maximum WriteRead calls in flight to each Store
+	Yield func()         // Optional hook at scheduling points, for testing
+	Go    func(f func()) // Optional hook to start goroutines
 
-	mut sync.Mutex // Mutex protecting this client's state
+	mut  sync.Mutex // Mutex protecting this client's state
+	com  *commits   // Commit stream for Commits, or nil
+	last []*work    // Latest work-item claimed for each Store
 }
 
 type work struct {
-	cond *sync.Cond // For awaiting threshold conditions
-	val  Value      // Value template each worker will try to write
-	kvc  Set        // Key/value cache collected for this time-step
-	max  Value      // Value with highest time-step we must catch up to
-	next *work      // Forward pointer to next work item
+	cond *sync.Cond    // For awaiting threshold conditions
+	val  Value         // Value template each worker will try to write
+	kvc  Set           // Key/value cache collected for this time-step
+	max  Value         // Value with highest time-step we must catch up to
+	wait time.Duration // Time to defer our writes, when sitting out
+	next *work         // Forward pointer to next work item
 }
 
 // Run starts a client running with its given configuration parameters,
@@ -144,19 +205,47 @@ func (c *Client) Run(ctx context.Context) (err error) {
 	defer c.mut.Unlock()
 
 	// Launch one client thread to drive each of the n consensus nodes.
+	if c.Lat != nil {
+		c.Lat.init(len(c.KV))
+	}
+	if c.com != nil {
+		c.goroutine(func() { c.deliver(ctx) })
+	}
 	w := &work{kvc: make(Set), cond: sync.NewCond(&c.mut)}
+	c.last = make([]*work, len(c.KV))
 	for i := range c.KV {
-		go c.worker(i, w)
+		c.last[i] = &work{next: w} // nothing claimed yet
+		for j := 0; j < c.Conc || j == 0; j++ {
+			node := i
+			c.goroutine(func() { c.worker(node) })
+		}
 	}
 
+	// Stop waiting for the current work-item on cancellation,
+	// even if too few Stores respond to complete it.
+	stop := context.AfterFunc(ctx, func() {
+		c.mut.Lock()
+		w.cond.Broadcast()
+		c.mut.Unlock()
+	})
+	defer stop()
+
 	// Drive consensus state forever or until our context gets cancelled.
 	for ; ctx.Err() == nil; w = w.next {
 
 		// Wait for a threshold number of worker threads
 		// to complete the current work-item
-		for len(w.kvc) < c.Tr {
+		if c.Yield != nil {
+			c.mut.Unlock()
+			c.Yield()
+			c.mut.Lock()
+		}
+		for len(w.kvc) < c.Tr && ctx.Err() == nil {
 			w.cond.Wait()
 		}
+		if len(w.kvc) < c.Tr {
+			break
+		}
 
 		//str := fmt.Sprintf("at %v kvc contains:", w.val.S)
 		//for i, v := range w.kvc {
@@ -259,7 +348,23 @@ func (c *Client) Run(ctx context.Context) (err error) {
 			// Set the value for the first TLCB call
 			// in the next QSCOD round to broadcast,
 			// containing a proposal for the next round.
+			if com && c.Ev != nil {
+				c.Ev(b0.S, w.val.S, w.kvc.nodes())
+			}
+			if com {
+				c.queueCommit(b0.S, b0.P)
+			}
 			nv.P, nv.I = c.Pr(b0.S, b0.P, com)
+
+			// Under contention, a paced client may sit out the round,
+			// participating belatedly with a priority that can never win.
+			if c.Pace != nil {
+				c.Pace.observe(b0, com)
+				if !c.Pace.propose() {
+					nv.I = 0
+					w.next.wait = c.Pace.delay()
+				}
+			}
 		}
 
 		//fmt.Printf("at %v next step %v pri %v prop %q R %v B %v\n",
@@ -280,6 +385,22 @@ func (c *Client) Run(ctx context.Context) (err error) {
 	return ctx.Err()
 }
 
+// Start a goroutine running f, via the Go hook if any.
+func (c *Client) goroutine(f func()) {
+	if c.Go != nil {
+		c.Go(f)
+		return
+	}
+	go f()
+}
+
+// Call the Yield hook, if any.
+func (c *Client) yield() {
+	if c.Yield != nil {
+		c.Yield()
+	}
+}
+
 // worker handles a goroutine dedicated to submitting WriteRead requests
 // to each consensus group node asynchronously without delaying the main thread.
 //
@@ -289,17 +410,28 @@ func (c *Client) Run(ctx context.Context) (err error) {
 // trying to access the same slow node(s) and overloading those nodes further,
 // or creating local resource pressures such as too many open file descriptors
 // in case each WriteRead call opens a new file descriptor or socket, etc.
-// So we have only one worker per consensus group node do everything serially,
-// limiting resource usage while protecting the main thread from slow nodes.
+// So by default we have only one worker per consensus group node
+// do everything serially, limiting resource usage
+// while protecting the main thread from slow nodes.
+// The Conc configuration raises this limit to a fixed number of workers
+// per node, which claim successive work-items as they appear.
+//
+// Pipelining a node's work-items this way is safe
+// because the main thread creates each work-item
+// only after a threshold of workers completed the previous one.
+// A write for a later step that overtakes an earlier one at a Store
+// therefore only makes the earlier write read back a later value,
+// just as another client's catching up would,
+// and the earlier work-item no longer collects values.
 //
-func (c *Client) worker(node int, w *work) {
+func (c *Client) worker(node int) {
 
 	// Keep Client state locked while we're not waiting
 	c.mut.Lock()
 
 	// Process work-items defined by the main thread in sequence,
 	// terminating when we encounter a work-item with a nil kvc.
-	for ; w.kvc != nil; w = w.next {
+	for w := c.claimWork(node); w.kvc != nil; w = c.claimWork(node) {
 
 		//		// Pull the next Value template we're supposed to write
 		//		v := w.val
@@ -313,9 +445,20 @@ func (c *Client) worker(node int, w *work) {
 
 		//println(w, "before WriteRead step", w.val.S)
 
-		// Try to write new value, then read whatever the winner wrote.
+		// Try to write new value, then read whatever the winner wrote,
+		// first giving other clients' proposals a head start
+		// if we're sitting out this round.
 		c.mut.Unlock()
+		if w.wait > 0 {
+			time.Sleep(w.wait)
+		}
+		c.yield()
+		start := time.Now()
 		v := c.KV[node].WriteRead(w.val)
+		if c.Lat != nil {
+			c.Lat.observe(node, time.Since(start))
+		}
+		c.yield()
 		c.mut.Lock()
 
 		//println(w, "after WriteRead step", w.val.S, "read", v.S)
@@ -345,16 +488,36 @@ func (c *Client) worker(node int, w *work) {
 				w.cond.Broadcast()
 			}
 		}
-
-		// Wait until the main thread has created a next work-item.
-		for w.next == nil {
-			w.cond.Wait()
-		}
 	}
 
 	c.mut.Unlock()
 }
 
+// claimWork returns the next work-item a worker for Store node
+// should process after the latest any worker for it claimed,
+// waiting until the main thread has created a next work-item,
+// or the all-nil work-item that terminates all workers.
+// The workers for Stores outside the fastest Tr skip ahead
+// to the latest work-item, since the main thread creates a next item
+// only once a threshold of workers has completed the current one.
+func (c *Client) claimWork(node int) *work {
+	w := c.last[node]
+	for w.next == nil {
+		if w.kvc == nil {
+			return w // leave it for the node's other workers too
+		}
+		w.cond.Wait()
+	}
+	w = w.next
+	if c.Lat != nil && !c.Lat.fast(node, c.Tr) {
+		for w.next != nil {
+			w = w.next
+		}
+	}
+	c.last[node] = w
+	return w
+}
+
 // tlcbRB calculates the receive (R) and broadcast (B) sets
 // returned by the TLCB algorithm after its second TLCR call.
 //
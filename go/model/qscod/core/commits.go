@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// Commit describes a commit that a Client observed:
+// the step number and application data of the committed proposal.
+type Commit struct {
+	Step int64  // TLC step number of the committed proposal
+	Data string // Application data string of the committed proposal
+}
+
+// commits queues the commits a Client observes for delivery on a channel,
+// so that a slow consumer never delays the consensus state machine.
+type commits struct {
+	ch   chan Commit // channel returned by Client.Commits
+	cond *sync.Cond  // signalled on each newly queued commit
+	q    []Commit    // commits observed but not yet delivered
+	last int64       // step of the last commit queued
+}
+
+// Commits returns a channel on which the Client delivers
+// each commit it newly observes, in increasing step order,
+// independently of the Pr callback.
+// Like Pr, the channel may skip commits the Client never observed,
+// but never delivers a commit with a lower step than one delivered before.
+// The Client queues commits for as long as the consumer takes,
+// so the consumer never delays the consensus state machine.
+//
+// Commits must be called before Run, and always returns the same channel.
+// The Client closes the channel when Run's context is cancelled,
+// dropping any queued commits not yet received.
+//
+func (c *Client) Commits() <-chan Commit {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.com == nil {
+		c.com = &commits{ch: make(chan Commit),
+			cond: sync.NewCond(&c.mut)}
+	}
+	return c.com.ch
+}
+
+// Queue a commit the Client observed for delivery, if it is a new one.
+// The caller must hold the Client's mutex.
+func (c *Client) queueCommit(step int64, data string) {
+	cs := c.com
+	if cs == nil || step <= cs.last {
+		return
+	}
+	cs.last = step
+	cs.q = append(cs.q, Commit{step, data})
+	cs.cond.Signal()
+}
+
+// Deliver queued commits on the Commits channel in order,
+// until ctx is cancelled.
+func (c *Client) deliver(ctx context.Context) {
+	cs := c.com
+
+	// Wake up on cancellation even if the consensus state machine stalls.
+	c.goroutine(func() {
+		<-ctx.Done()
+		c.mut.Lock()
+		cs.cond.Broadcast()
+		c.mut.Unlock()
+	})
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for {
+		for len(cs.q) == 0 && ctx.Err() == nil {
+			cs.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			close(cs.ch)
+			return
+		}
+		com := cs.q[0]
+		cs.q = cs.q[1:]
+
+		c.mut.Unlock()
+		select {
+		case cs.ch <- com:
+		case <-ctx.Done():
+		}
+		c.mut.Lock()
+	}
+}
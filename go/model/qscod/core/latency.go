@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Latency tracks the latency of each Store a Client accesses,
+// so that the Client can schedule its accesses accordingly.
+//
+// In each time step, a Client proceeds with the first Tr Stores to respond,
+// which are usually the fastest.
+// By default, however, the worker for a slower Store
+// still writes every step's value to it in turn,
+// falling ever further behind whenever the Store's latency
+// exceeds the group's step time, e.g., when the Store is cross-continent.
+// With latency tracking, the worker for a Store
+// that is not among the Tr fastest skips directly to the latest step
+// whenever it completes an access,
+// keeping its Store caught up in the background
+// without wasting round trips on steps the group has already completed.
+//
+// Domain optionally assigns each Store to a failure domain,
+// such as the datacenter, zone, or rack hosting it,
+// in which case the Client prefers Tr Stores spanning domains
+// over the Tr fastest overall:
+// it takes the fastest Store in each domain before
+// the second fastest in any domain, and so on.
+// The Stores the Client keeps most closely up to date
+// then remain able to form a threshold if any one domain fails.
+// Domain must be set before the Client starts, if at all.
+//
+// The caller may Exclude a Store it knows to be failing,
+// such as one that has not responded for a long time,
+// which the Client then ranks slower than every other Store,
+// so that it does not count on that Store to keep up.
+// Excluding Stores affects only the Client's scheduling, not its thresholds.
+//
+// The zero value is ready to use.
+// A Latency must not be shared among Clients.
+//
+type Latency struct {
+	Domain []int // Failure domain of each Store, or nil
+
+	mut sync.Mutex      // protects lat and out
+	lat []time.Duration // smoothed latency of each Store, or 0 if unknown
+	out []bool          // whether each Store is excluded
+}
+
+// Weight of each new sample in the smoothed latency,
+// as in TCP's smoothed round-trip time estimate.
+const latencyGain = 1.0 / 8
+
+// Prepare to track the latency of n Stores.
+func (l *Latency) init(n int) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.Domain != nil && len(l.Domain) != n {
+		panic("Latency.Domain must have one entry per Store")
+	}
+	l.lat = make([]time.Duration, n)
+	l.out = make([]bool, n)
+}
+
+// Exclude marks Store node as excluded if excluded is true,
+// or readmits it otherwise.
+// It may safely be called concurrently with the Client's operation.
+func (l *Latency) Exclude(node int, excluded bool) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if node >= 0 && node < len(l.out) {
+		l.out[node] = excluded
+	}
+}
+
+// Record the duration d of an access to Store node.
+func (l *Latency) observe(node int, d time.Duration) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.lat[node] == 0 {
+		l.lat[node] = d // first sample
+	} else {
+		l.lat[node] += time.Duration(latencyGain *
+			float64(d-l.lat[node]))
+	}
+}
+
+// Returns true if Store node is among the tr Stores the Client prefers:
+// the fastest, spanning failure domains if any.
+// Stores of unknown latency count as fast until measured.
+func (l *Latency) fast(node, tr int) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	// Rank each Store first by how many Stores in its own domain
+	// are faster, then by its latency.
+	rank := func(i int) int {
+		r := 0
+		for j := range l.lat {
+			if l.faster(j, i) && l.domain(j) == l.domain(i) {
+				r++
+			}
+		}
+		return r
+	}
+	nr := rank(node)
+	faster := 0
+	for i := range l.lat {
+		if r := rank(i); r < nr || (r == nr && l.faster(i, node)) {
+			faster++
+		}
+	}
+	return faster < tr
+}
+
+// Returns true if Store i is faster than Store j,
+// breaking ties between measured latencies by Store number,
+// so that Stores with equal latencies don't crowd out slower ones.
+// No Store is faster than one of unknown latency,
+// and every Store is faster than an excluded one.
+func (l *Latency) faster(i, j int) bool {
+	if l.out[i] != l.out[j] {
+		return l.out[j]
+	}
+	return l.lat[i] < l.lat[j] ||
+		(l.lat[i] == l.lat[j] && l.lat[j] != 0 && i < j)
+}
+
+// Return the failure domain of Store node.
+func (l *Latency) domain(node int) int {
+	if l.Domain == nil {
+		return 0
+	}
+	return l.Domain[node]
+}
+
+// Of returns the smoothed latency of accesses to Store node,
+// or zero if the Client has yet to complete any access to it.
+// It may safely be called concurrently with the Client's operation.
+func (l *Latency) Of(node int) time.Duration {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if node < 0 || node >= len(l.lat) {
+		return 0
+	}
+	return l.lat[node]
+}
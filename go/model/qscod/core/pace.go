@@ -0,0 +1,69 @@
+package core
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultPaceDelay is the default time a paced Client sitting out a round
+// defers its writes, to give other clients' proposals a head start.
+const DefaultPaceDelay = time.Millisecond
+
+// Pacing adapts how often a Client proposes to the contention it observes.
+//
+// When many clients propose in every round,
+// their proposals' priorities frequently collide or spoil each other,
+// so that rounds fail to commit anything.
+// A paced Client counts the consecutive rounds that fail in this way,
+// and after f such failures sits out each round with probability f/(f+1).
+// A client sitting out a round still helps drive it forward,
+// but only with a no-op proposal of priority zero,
+// which can never commit or spoil another proposal,
+// and which it writes only after a delay,
+// so that it occupies only stores no proposing client has reached.
+// Each round that commits, or that fails only because
+// no client proposed with nonzero priority, halves the failure count,
+// so that the number of clients actually competing in each round
+// tends toward the few that the priority space can distinguish.
+//
+// The zero value is ready to use.
+// A Pacing must not be shared among Clients.
+//
+type Pacing struct {
+	// Delay is how long a client sitting out a round defers its writes,
+	// or zero for DefaultPaceDelay.
+	// It should exceed the typical latency of the Stores.
+	Delay time.Duration
+
+	// Rand, if non-nil, returns uniform random numbers in [0,1)
+	// for deciding whether to sit out a round.
+	Rand func() float64
+
+	fails int // consecutive rounds that failed due to contention
+}
+
+// Returns true if the client should propose in the next round.
+func (p *Pacing) propose() bool {
+	r := rand.Float64
+	if p.Rand != nil {
+		r = p.Rand
+	}
+	return r()*float64(p.fails+1) < 1
+}
+
+// Returns how long to defer writes when sitting out a round.
+func (p *Pacing) delay() time.Duration {
+	if p.Delay == 0 {
+		return DefaultPaceDelay
+	}
+	return p.Delay
+}
+
+// Adapt to the outcome of a round in which proposal best was chosen.
+func (p *Pacing) observe(best Value, committed bool) {
+	if committed || best.I == 0 {
+		p.fails /= 2
+	} else {
+		p.fails++
+	}
+}
@@ -0,0 +1,42 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// A sealed Value written under a configuration epoch above zero
+// starts with this prefix, followed by the epoch as a uvarint,
+// and then the sealed Value itself.
+// Values written under epoch zero carry no prefix,
+// so they remain readable by releases that predate epochs.
+const epochPrefix = "\x00qsc-epoch\x00"
+
+// TagEpoch prefixes the sealed value b with configuration epoch epoch,
+// so that clients reading it can recognize that they are stale
+// if they operate under an earlier epoch.
+// If epoch is zero, TagEpoch returns b unchanged.
+func TagEpoch(b []byte, epoch int64) []byte {
+	if epoch <= 0 {
+		return b
+	}
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(epoch))
+	t := make([]byte, 0, len(epochPrefix)+n+len(b))
+	t = append(append(t, epochPrefix...), l[:n]...)
+	return append(t, b...)
+}
+
+// SplitEpoch separates the configuration epoch tagged by TagEpoch
+// from the sealed value following it,
+// returning epoch zero and b unchanged if b has no epoch tag.
+func SplitEpoch(b []byte) (epoch int64, rest []byte) {
+	if !bytes.HasPrefix(b, []byte(epochPrefix)) {
+		return 0, b
+	}
+	e, n := binary.Uvarint(b[len(epochPrefix):])
+	if n <= 0 || int64(e) <= 0 {
+		return 0, b // not a valid epoch tag after all
+	}
+	return int64(e), b[len(epochPrefix)+n:]
+}
@@ -0,0 +1,151 @@
+package encoding
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	. "github.com/dedis/tlc/go/model/qscod/core"
+)
+
+// Keyring holds a consensus group's symmetric keys
+// for encrypting Values at rest in semi-trusted stores,
+// indexed by key epoch number.
+//
+// Seal always encrypts with the key for the current Epoch,
+// and tags the ciphertext with that epoch number,
+// so that the epoch is committed to the store along with the value.
+// Open uses this tag to find the right key to decrypt with,
+// so values written under earlier epochs remain readable
+// as long as their keys remain in Keys.
+// To rotate keys, add a new key under a higher epoch number and set Epoch,
+// retaining old keys until no stored values still depend on them.
+// A qscas.Group can instead commit the key epoch to use
+// through the group itself: see qscas.Group.RotateKeys.
+//
+// A Keyring encodes in JSON as its epoch and base64-encoded keys,
+// suitable for a key file.
+//
+// Keys must be 16, 24, or 32 bytes long, selecting AES-128, -192, or -256,
+// and are used with GCM for authenticated encryption.
+//
+type Keyring struct {
+	Epoch uint32            // Key epoch to use for new encryptions
+	Keys  map[uint32][]byte // Symmetric AES keys indexed by epoch
+}
+
+// ErrUnknownEpoch is returned by Keyring.Open when a sealed value
+// was encrypted under a key epoch for which the Keyring has no key.
+var ErrUnknownEpoch = errors.New("sealed value has unknown key epoch")
+
+// ErrMalformed is returned by Keyring.Open when a sealed value is too short.
+// It is in the class cas.ErrCorrupt, as are authentication failures.
+var ErrMalformed = cas.Classify(cas.ErrCorrupt,
+	errors.New("malformed sealed value"))
+
+// Produce an AEAD instance for the key of a given epoch.
+func (kr *Keyring) aead(epoch uint32) (cipher.AEAD, error) {
+	key, ok := kr.Keys[epoch]
+	if !ok {
+		return nil, ErrUnknownEpoch
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WithEpoch returns a Keyring sharing kr's keys
+// that encrypts new values under the key of the given epoch,
+// or ErrUnknownEpoch if kr has no key for that epoch.
+func (kr *Keyring) WithEpoch(epoch uint32) (*Keyring, error) {
+	if _, ok := kr.Keys[epoch]; !ok {
+		return nil, ErrUnknownEpoch
+	}
+	return &Keyring{Epoch: epoch, Keys: kr.Keys}, nil
+}
+
+// Retire deletes the keys of all epochs below epoch from kr,
+// other than that of kr.Epoch,
+// and returns the retired epochs in increasing order.
+// Values sealed under a retired key can no longer be opened.
+func (kr *Keyring) Retire(epoch uint32) []uint32 {
+	var retired []uint32
+	for e := range kr.Keys {
+		if e < epoch && e != kr.Epoch {
+			retired = append(retired, e)
+		}
+	}
+	sort.Slice(retired, func(i, j int) bool { return retired[i] < retired[j] })
+	for _, e := range retired {
+		delete(kr.Keys, e)
+	}
+	return retired
+}
+
+// Seal encrypts and authenticates plaintext with the current epoch's key.
+// The sealed format is a 4-byte big-endian key epoch, a random nonce,
+// and the AEAD ciphertext, which also authenticates the epoch.
+func (kr *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	aead, err := kr.aead(kr.Epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, 4+aead.NonceSize())
+	binary.BigEndian.PutUint32(hdr, kr.Epoch)
+	if _, err := rand.Read(hdr[4:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(hdr, hdr[4:], plaintext, hdr[:4]), nil
+}
+
+// Open authenticates and decrypts a value produced by Seal,
+// using the key for the epoch the value was sealed under.
+func (kr *Keyring) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, ErrMalformed
+	}
+	aead, err := kr.aead(binary.BigEndian.Uint32(sealed))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 4+aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce := sealed[4 : 4+aead.NonceSize()]
+	b, err := aead.Open(nil, nonce, sealed[4+aead.NonceSize():], sealed[:4])
+	if err != nil {
+		return nil, cas.Classify(cas.ErrCorrupt, err)
+	}
+	return b, nil
+}
+
+// SealValue encodes a Value for storage, encrypting it with keyring kr.
+// If kr is nil, SealValue is equivalent to EncodeValue.
+func SealValue(v Value, kr *Keyring) ([]byte, error) {
+	b, err := EncodeValue(v)
+	if err != nil || kr == nil {
+		return b, err
+	}
+	return kr.Seal(b)
+}
+
+// OpenValue decrypts and decodes a Value sealed by SealValue,
+// ignoring any configuration epoch tagged by TagEpoch.
+// If kr is nil, OpenValue is equivalent to DecodeValue.
+func OpenValue(b []byte, kr *Keyring) (Value, error) {
+	_, b = SplitEpoch(b)
+	if kr != nil {
+		var err error
+		if b, err = kr.Open(b); err != nil {
+			return Value{}, err
+		}
+	}
+	return DecodeValue(b)
+}
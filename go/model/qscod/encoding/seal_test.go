@@ -0,0 +1,103 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/dedis/tlc/go/model/qscod/core"
+)
+
+func TestSeal(t *testing.T) {
+	kr := &Keyring{Epoch: 1, Keys: map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+	}}
+	v := Value{S: 5, P: "secret proposal", I: 123,
+		R: Set{0: Value{S: 4, P: "older"}}}
+
+	b, err := SealValue(v, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(v.P)) {
+		t.Errorf("sealed value contains plaintext proposal")
+	}
+
+	// Rotate to a new key epoch, keeping the old key for reading
+	kr.Keys[2] = bytes.Repeat([]byte{2}, 32)
+	kr.Epoch = 2
+	nb, err := SealValue(v, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sealed := range [][]byte{b, nb} {
+		rv, err := OpenValue(sealed, kr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rv.S != v.S || rv.P != v.P || rv.I != v.I ||
+			rv.R[0].P != v.R[0].P {
+			t.Errorf("OpenValue yielded wrong value %v", rv)
+		}
+	}
+
+	// Once the old key is retired, old values become unreadable
+	delete(kr.Keys, 1)
+	if _, err := OpenValue(b, kr); err != ErrUnknownEpoch {
+		t.Errorf("OpenValue with retired key: got error %v", err)
+	}
+
+	// Tampering must be detected
+	nb[len(nb)-1] ^= 1
+	if _, err := OpenValue(nb, kr); err == nil {
+		t.Errorf("OpenValue accepted tampered value")
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	v := Value{S: 7, P: "proposal"}
+	b, err := SealValue(v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, rest := SplitEpoch(TagEpoch(b, 0)); e != 0 || !bytes.Equal(rest, b) {
+		t.Errorf("epoch zero yielded tag %v", e)
+	}
+	tb := TagEpoch(b, 300)
+	if e, rest := SplitEpoch(tb); e != 300 || !bytes.Equal(rest, b) {
+		t.Errorf("SplitEpoch yielded epoch %v", e)
+	}
+	if rv, err := OpenValue(tb, nil); err != nil || rv.S != v.S || rv.P != v.P {
+		t.Errorf("OpenValue of tagged value yielded %v %v", rv, err)
+	}
+}
+
+func TestKeyringEpochs(t *testing.T) {
+	kr := &Keyring{Epoch: 2, Keys: map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 16),
+		2: bytes.Repeat([]byte{2}, 16),
+		3: bytes.Repeat([]byte{3}, 16),
+	}}
+	if _, err := kr.WithEpoch(4); err != ErrUnknownEpoch {
+		t.Errorf("WithEpoch of unknown epoch yielded %v", err)
+	}
+	k3, err := kr.WithEpoch(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SealValue(Value{S: 1, P: "new"}, k3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv, err := OpenValue(b, kr); err != nil || rv.P != "new" {
+		t.Errorf("value sealed under epoch 3 opened as %v %v", rv, err)
+	}
+
+	// Retiring keys below epoch 3 keeps the key of the current epoch.
+	if r := kr.Retire(3); len(r) != 1 || r[0] != 1 {
+		t.Errorf("retired epochs %v", r)
+	}
+	if len(kr.Keys) != 2 || kr.Keys[2] == nil || kr.Keys[3] == nil {
+		t.Errorf("retained keys %v", kr.Keys)
+	}
+}
@@ -4,66 +4,51 @@ package casdir
 import (
 	"context"
 	"fmt"
-	"os"
+	"path/filepath"
 	"testing"
 
-	. "github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas"
 	"github.com/dedis/tlc/go/lib/cas/test"
 	"github.com/dedis/tlc/go/lib/fs/casdir"
-	. "github.com/dedis/tlc/go/model/qscod/cas"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
 )
 
-//  Run a consensus test case with the specified parameters.
-func testRun(t *testing.T, nfail, nnode, nclients, nthreads, naccesses int) {
-
-	// Create a test key/value store representing each node
+// Create a test file system CAS store directory representing each node,
+// and give each client its own set of Store objects accessing them.
+func members(t *testing.T, nnode int) func() []cas.Store {
+	dir := t.TempDir()
 	dirs := make([]string, nnode)
 	for i := range dirs {
-		dirs[i] = fmt.Sprintf("test-store-%d", i)
-
-		// Remove the test directory if one is left-over
-		// from a previous test run.
-		os.RemoveAll(dirs[i])
-
-		// Create the test directory afresh.
+		dirs[i] = filepath.Join(dir, fmt.Sprintf("test-store-%d", i))
 		fs := &casdir.Store{}
 		if err := fs.Init(dirs[i], true, true); err != nil {
 			t.Fatal(err)
 		}
-
-		// Clean it up once the test is done.
-		defer os.RemoveAll(dirs[i])
 	}
 
-	desc := fmt.Sprintf("F=%v,N=%v,Clients=%v,Threads=%v,Accesses=%v",
-		nfail, nnode, nclients, nthreads, naccesses)
-	t.Run(desc, func(t *testing.T) {
-
-		// Create a context and cancel it at the end of the test
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		// Create simulated clients to access the consensus group
-		clients := make([]Store, nclients)
-		for i := range clients {
-
-			// Create a set of Store objects for each client
-			members := make([]Store, nnode)
-			for j := range members {
-				fs := &casdir.Store{}
-				err := fs.Init(dirs[j], false, false)
-				if err != nil {
-					t.Fatal(err)
-				}
-				members[j] = fs
+	return func() []cas.Store {
+		members := make([]cas.Store, nnode)
+		for j := range members {
+			fs := &casdir.Store{}
+			if err := fs.Init(dirs[j], false, false); err != nil {
+				t.Fatal(err)
 			}
-
-			clients[i] = (&Group{}).Start(ctx, members, nfail)
+			members[j] = fs
 		}
+		return members
+	}
+}
 
-		// Run a standard torture test across all the clients
-		test.Stores(t, nthreads, naccesses, clients...)
-	})
+// Start a QSCOD consensus group client on the specified members.
+func start(ctx context.Context, members []cas.Store, faulty int) cas.Store {
+	return (&qscas.Group{}).Start(ctx, members, faulty)
+}
+
+//  Run a consensus test case with the specified parameters.
+func testRun(t *testing.T, nfail, nnode, nclients, nthreads, naccesses int) {
+	test.Groups(t, members, start, test.GroupConfig{
+		Faulty: nfail, Nodes: nnode, Clients: nclients,
+		Threads: nthreads, Accesses: naccesses})
 }
 
 func TestConsensus(t *testing.T) {
@@ -44,7 +44,7 @@ func (fs *FileStore) WriteRead(v Value) (rv Value) {
 		}
 
 		// Try to write the file, ignoring already-exists errors
-		name := fmt.Sprintf("ver-%d", v.P.Step)
+		name := fmt.Sprintf("ver-%d", v.S)
 		path := filepath.Join(fs.Path, name)
 		err = atomic.WriteFileOnce(path, buf, 0666)
 		if err != nil && !os.IsExist(err) {
@@ -69,11 +69,3 @@ func (fs *FileStore) WriteRead(v Value) (rv Value) {
 	backoff.Retry(context.Background(), try)
 	return rv
 }
-
-// QSCOD calls Committed to inform us that history comh is committed,
-// so we can garbage-collect entries before it in the key/value store.
-// But this Store does not implement garbage-collection.
-//
-func (fs *FileStore) Committed(comh Head) {
-	// do nothing - no garbage collection
-}
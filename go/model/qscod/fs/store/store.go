@@ -15,11 +15,15 @@ import (
 
 // FileStore implements a QSCOD key/value store
 // as a directory in a file system.
+// It is safe for concurrent use, serving up to its concurrency
+// WriteRead calls at once: see SetConcurrency.
 //
 type FileStore struct {
-	state verst.State
-	ctx   context.Context
-	bc    backoff.Config
+	states chan *verst.State // idle handles on the directory's state
+	conc   int               // number of handles, or 0 for one
+	ctx    context.Context
+	bo     backoff.Backoff
+	keys   *encoding.Keyring
 }
 
 // Initialize FileStore to use a directory at a given file system path.
@@ -28,7 +32,28 @@ type FileStore struct {
 func (fs *FileStore) Init(ctx context.Context, path string, create, excl bool) error {
 
 	fs.ctx = ctx
-	return fs.state.Init(path, create, excl)
+	fs.states = make(chan *verst.State, max(fs.conc, 1))
+	for i := 0; i < cap(fs.states); i++ {
+		st := &verst.State{}
+		if err := st.Init(path, create, excl); err != nil {
+			return err
+		}
+		fs.states <- st
+		create, excl = false, false // the directory now exists
+	}
+	return nil
+}
+
+// SetConcurrency sets the number of WriteRead calls FileStore serves at once,
+// 1 by default, e.g., to match a Client's Conc configuration.
+// Each concurrent call accesses the directory through its own handle,
+// as if a separate client, so that the calls' file system operations
+// overlap instead of waiting on each other,
+// as matters most on network file systems with high latency.
+// SetConcurrency must be called before Init.
+//
+func (fs *FileStore) SetConcurrency(n int) {
+	fs.conc = n
 }
 
 // SetBackoff sets the backoff configuration for handling errors that occur
@@ -40,7 +65,16 @@ func (fs *FileStore) Init(ctx context.Context, path string, create, excl bool) e
 // and keeps trying the access after a random exponential backoff.
 //
 func (fs *FileStore) SetReport(bc backoff.Config) {
-	fs.bc = bc
+	fs.bo.Config = bc
+}
+
+// SetKeyring enables encryption of the values FileStore writes,
+// using the current key epoch in keyring kr,
+// so that the file system holds only ciphertext.
+// All clients sharing the store must use the same keys.
+//
+func (fs *FileStore) SetKeyring(kr *encoding.Keyring) {
+	fs.keys = kr
 }
 
 // Attempt to write the value v to a file associated with time-step step,
@@ -50,7 +84,7 @@ func (fs *FileStore) SetReport(bc backoff.Config) {
 func (fs *FileStore) WriteRead(v Value) (rv Value) {
 
 	// Don't try to write version 0; that's a virtual placeholder.
-	if v.P.Step == 0 {
+	if v.S == 0 {
 		return v
 	}
 
@@ -59,15 +93,19 @@ func (fs *FileStore) WriteRead(v Value) (rv Value) {
 		return err
 	}
 
-	fs.bc.Retry(fs.ctx, try)
+	fs.bo.Retry(fs.ctx, try)
 	return rv
 }
 
 func (fs *FileStore) tryWriteRead(val Value) (Value, error) {
-	ver := int64(val.P.Step)
+	ver := val.S
+
+	// Borrow an idle handle on the state for the duration of this attempt
+	st := <-fs.states
+	defer func() { fs.states <- st }()
 
 	// Serialize the proposed value
-	valb, err := encoding.EncodeValue(val)
+	valb, err := encoding.SealValue(val, fs.keys)
 	if err != nil {
 		return Value{}, err
 	}
@@ -75,31 +113,31 @@ func (fs *FileStore) tryWriteRead(val Value) (Value, error) {
 
 	// Try to write it to the versioned store -
 	// but don't fret if someone else wrote it or if it has expired.
-	err = fs.state.WriteVersion(ver, vals)
+	err = st.WriteVersion(ver, vals)
 	if err != nil && !verst.IsExist(err) && !verst.IsNotExist(err) {
 		return Value{}, err
 	}
 
 	// Now read back whatever value was successfully written.
-	vals, err = fs.state.ReadVersion(ver)
+	vals, err = st.ReadVersion(ver)
 	if err != nil && verst.IsNotExist(err) {
 
 		// The requested version has probably been aged out,
-		// so catch up to the most recent committed Head.
-		_, vals, err = fs.state.ReadLatest()
+		// so catch up to the most recent committed value.
+		_, vals, err = st.ReadLatest()
 	}
 	if err != nil {
 		return Value{}, err
 	}
 
 	// Deserialize the value we read
-	val, err = encoding.DecodeValue([]byte(vals))
+	val, err = encoding.OpenValue([]byte(vals), fs.keys)
 	if err != nil {
 		return Value{}, err
 	}
 
 	// Expire all versions before this latest one
-	fs.state.Expire(int64(val.P.Step))
+	st.Expire(val.S)
 
 	// Return the value v that we read
 	return val, err
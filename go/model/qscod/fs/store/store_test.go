@@ -3,50 +3,192 @@ package store
 import (
 	"context"
 	"fmt"
-	"os"
+	"math/rand"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/dedis/tlc/go/model/qscod/core"
-	. "github.com/dedis/tlc/go/model/qscod/core/test"
+	"github.com/dedis/tlc/go/model/qscod/testsuite"
 )
 
-//  Run a consensus test case with the specified parameters.
-func testRun(t *testing.T, nfail, nnode, ncli, maxstep, maxpri int) {
+// Create a group of nnode versioned file system key/value Stores,
+// each serving conc concurrent accesses.
+func newFileStores(t testing.TB, nnode, conc int) []*FileStore {
+	dir := t.TempDir()
+	ctx := context.Background()
+	fs := make([]*FileStore, nnode)
+	for i := range fs {
+		path := filepath.Join(dir, fmt.Sprintf("test-store-%d", i))
+		fs[i] = &FileStore{}
+		fs[i].SetConcurrency(conc)
+		if err := fs[i].Init(ctx, path, true, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
 
-	// Create a test key/value store representing each node
+// Create a group of nnode versioned file system key/value Stores.
+func newKV(t *testing.T, nnode int) []Store {
 	kv := make([]Store, nnode)
-	ctx := context.Background()
-	for i := range kv {
-		path := fmt.Sprintf("test-store-%d", i)
+	for i, fs := range newFileStores(t, nnode, 1) {
+		kv[i] = fs
+	}
+	return kv
+}
 
-		// Remove the test directory if one is left-over
-		// from a previous test run.
-		os.RemoveAll(path)
+func TestSimpleStore(t *testing.T) {
+	testsuite.Battery(t, newKV)
+}
 
-		// Create the test directory afresh.
-		ss := &FileStore{}
-		if err := ss.Init(ctx, path, true, true); err != nil {
-			t.Fatal(err)
+// goWith returns a Client.Go hook that tracks goroutines in wg,
+// so that a test can wait for all of a Client's workers to finish.
+func goWith(wg *sync.WaitGroup) func(func()) {
+	return func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+}
+
+// slowStore is a FileStore that delays each access by a fixed latency
+// and records the most accesses it ever had in flight at once.
+type slowStore struct {
+	*FileStore
+	delay time.Duration
+	mu    sync.Mutex
+	cur   int // accesses now in flight
+	peak  int // most accesses ever in flight
+}
+
+func (ss *slowStore) WriteRead(v Value) Value {
+	ss.mu.Lock()
+	ss.cur++
+	ss.peak = max(ss.peak, ss.cur)
+	ss.mu.Unlock()
+
+	time.Sleep(ss.delay)
+	v = ss.FileStore.WriteRead(v)
+
+	ss.mu.Lock()
+	ss.cur--
+	ss.mu.Unlock()
+	return v
+}
+
+// Run a client for a given number of time steps on a group of nnode
+// FileStores with conc accesses in flight to each,
+// where the first Store's accesses take slow and the others' take fast.
+func runSlow(t testing.TB, nnode, conc, steps int,
+	slow, fast time.Duration) []*slowStore {
+
+	ss := make([]*slowStore, nnode)
+	kv := make([]Store, nnode)
+	for i, fs := range newFileStores(t, nnode, conc) {
+		ss[i] = &slowStore{FileStore: fs, delay: fast}
+		kv[i] = ss[i]
+	}
+	ss[0].delay = slow
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr := func(step int64, cur string, com bool) (string, int64) {
+		if step >= int64(steps) {
+			cancel()
+		}
+		return fmt.Sprintf("proposal %v", step), rand.Int63n(100)
+	}
+	f := nnode / 3
+	c := Client{KV: kv, Tr: 2 * f, Ts: f + 1, Pr: pr, Conc: conc,
+		Go: goWith(&wg)}
+	c.Run(ctx)
+
+	// Let workers finish their last accesses before the stores go away.
+	wg.Wait()
+	return ss
+}
+
+// Test that a Client keeps up to Conc accesses in flight to a Store
+// busy with slow accesses while the others carry its time steps forward,
+// and never more than Conc.
+func TestConcurrency(t *testing.T) {
+	const nnode, steps = 3, 30
+	const slow, fast = 10 * time.Millisecond, time.Millisecond
+	for _, conc := range []int{1, 4} {
+		ss := runSlow(t, nnode, conc, steps, slow, fast)
+		t.Logf("conc %v: slow store peaked at %v accesses in flight",
+			conc, ss[0].peak)
+		for i, s := range ss {
+			if s.peak > conc {
+				t.Errorf("conc %v: store %v had %v accesses in flight",
+					conc, i, s.peak)
+			}
 		}
-		kv[i] = ss
+		if conc > 1 && ss[0].peak < 2 {
+			t.Errorf("conc %v: slow store's accesses did not overlap",
+				conc)
+		}
+	}
+}
 
-		// Clean it up once the test is done.
-		defer os.RemoveAll(path)
+// Benchmark a client on FileStores with the access latency
+// of a network file system, whose round trips usually take
+// a couple of milliseconds but one time in five take ten times as long,
+// with accesses to each Store serialized or pipelined.
+func BenchmarkConcurrency(b *testing.B) {
+	for _, conc := range []int{1, 4} {
+		b.Run(fmt.Sprintf("Conc=%v", conc), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runNFS(b, 3, conc, 200)
+			}
+		})
 	}
+}
 
-	TestRun(t, kv, nfail, ncli, maxstep, maxpri)
+// nfsStore is a FileStore with NFS-like latencies.
+type nfsStore struct {
+	*FileStore
 }
 
-func TestSimpleStore(t *testing.T) {
-	testRun(t, 1, 3, 1, 10, 100) // Standard f=1 case,
-	testRun(t, 1, 3, 2, 10, 100) // varying number of clients
-	testRun(t, 1, 3, 10, 3, 100)
-	testRun(t, 1, 3, 20, 2, 100)
-	testRun(t, 1, 3, 40, 2, 100)
+func (ns nfsStore) WriteRead(v Value) Value {
+	d := 2 * time.Millisecond
+	if rand.Intn(5) == 0 {
+		d *= 10
+	}
+	time.Sleep(d)
+	return ns.FileStore.WriteRead(v)
+}
 
-	testRun(t, 2, 6, 10, 5, 100) // Standard f=2 case
-	testRun(t, 3, 9, 10, 3, 100) // Standard f=3 case
+// Run a client for a given number of time steps on a group of nnode
+// FileStores with NFS-like latencies, with conc accesses in flight to each.
+func runNFS(b *testing.B, nnode, conc, steps int) {
+	b.StopTimer()
+	kv := make([]Store, nnode)
+	for i, fs := range newFileStores(b, nnode, conc) {
+		kv[i] = nfsStore{fs}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr := func(step int64, cur string, com bool) (string, int64) {
+		if step >= int64(steps) {
+			cancel()
+		}
+		return fmt.Sprintf("proposal %v", step), rand.Int63n(100)
+	}
+	f := nnode / 3
+	var wg sync.WaitGroup
+	c := Client{KV: kv, Tr: 2 * f, Ts: f + 1, Pr: pr, Conc: conc,
+		Go: goWith(&wg)}
+	b.StartTimer()
+	c.Run(ctx)
+	b.StopTimer()
 
-	// Note: when nnode * ncli gets to be around 120-ish,
-	// we start running into default max-open-file limits.
+	// Let workers finish their last accesses before the stores go away.
+	wg.Wait()
 }
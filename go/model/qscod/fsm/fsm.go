@@ -0,0 +1,266 @@
+// Package fsm adapts replicated state machines written against
+// a hashicorp/raft-style FSM interface to run on QSCOD consensus,
+// easing the migration of existing Raft-based applications
+// onto TLC and QSC for experimentation.
+//
+// An Adapter maintains a replicated log of commands in a CAS Store,
+// typically a qscas.Group, and drives the application's FSM
+// from the stream of log entries that consensus commits.
+// Every Adapter sharing the same Store applies the same entries
+// to its FSM in the same order, as Raft followers do,
+// so deterministic FSMs stay consistent.
+//
+// Unlike Raft, QSCOD has no leader:
+// any Adapter may append commands to the log at any time.
+// Log entries therefore carry no term,
+// and Adapters need no elections or log repair.
+//
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Log is a log entry committed to the replicated log.
+type Log struct {
+	Index      uint64    // Position of the entry in the log, from 1
+	Data       []byte    // Command the application appended
+	AppendedAt time.Time // Wall-clock time at which it was appended
+}
+
+// FSM is a replicated state machine, as in hashicorp/raft.
+//
+// Apply applies a committed log entry to the state machine,
+// returning an application-defined response.
+// Snapshot returns a snapshot of the state machine's current state,
+// and Restore replaces the state machine's state with a snapshot's.
+// The adapter never calls these methods concurrently.
+//
+type FSM interface {
+	Apply(*Log) interface{}
+	Snapshot() (FSMSnapshot, error)
+	Restore(io.ReadCloser) error
+}
+
+// FSMSnapshot is a snapshot of an FSM's state, as in hashicorp/raft.
+// Persist writes the snapshot to a sink, calling its Close method on success
+// or its Cancel method on failure,
+// and Release releases the snapshot's resources afterwards.
+type FSMSnapshot interface {
+	Persist(sink SnapshotSink) error
+	Release()
+}
+
+// SnapshotSink receives a snapshot that an FSMSnapshot persists.
+type SnapshotSink interface {
+	io.WriteCloser
+	ID() string
+	Cancel() error
+}
+
+// DefaultMaxEntries is the default number of log entries an Adapter
+// keeps in its Store before compacting them into a snapshot.
+const DefaultMaxEntries = 64
+
+// Adapter drives an FSM from a replicated log maintained in a CAS Store.
+//
+// The Store's state holds the latest snapshot of the FSM, if any,
+// together with the log entries committed since.
+// When the log grows to MaxEntries, the next Adapter to append to it
+// compacts the log into a new snapshot of its FSM,
+// so the Store's state stays bounded by the FSM's snapshot size
+// plus the size of MaxEntries commands.
+// Adapters that fall behind beyond the start of the log
+// restore their FSMs from the snapshot.
+// All Adapters on a Store should therefore use the same MaxEntries.
+//
+// The caller must set Store and FSM before use,
+// after which an Adapter is safe for concurrent use,
+// although it serializes its calls to the FSM.
+//
+type Adapter struct {
+	Store      cas.Store // Store holding the replicated log
+	FSM        FSM       // Application state machine to drive
+	MaxEntries int       // Entries to keep before compaction, or 0
+
+	mut     sync.Mutex // serializes operations and protects the fields below
+	raw     string     // last state observed in the Store
+	applied uint64     // index of the last entry the FSM applied
+}
+
+// State of the replicated log in the Store.
+type logState struct {
+	Base     uint64 // Index of the last entry the snapshot covers
+	Snapshot []byte `json:",omitempty"` // FSM snapshot at Base, if any
+	Entries  []Log  `json:",omitempty"` // Entries after Base, in order
+}
+
+// Return the index of the last entry in the log.
+func (ls *logState) last() uint64 {
+	return ls.Base + uint64(len(ls.Entries))
+}
+
+// Decode the replicated log from the Store's state.
+func decodeState(raw string) (*logState, error) {
+	ls := &logState{}
+	if raw == "" {
+		return ls, nil // empty log
+	}
+	if err := json.Unmarshal([]byte(raw), ls); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// Encode the replicated log for the Store.
+func encodeState(ls *logState) string {
+	b, err := json.Marshal(ls)
+	if err != nil {
+		panic("error encoding log: " + err.Error())
+	}
+	return string(b)
+}
+
+// Apply appends a command to the replicated log,
+// waits for it to commit, and applies it to the FSM,
+// returning the new entry's index and the FSM's response.
+// Before applying the command, it applies to the FSM
+// all the entries that others appended before it.
+//
+func (a *Adapter) Apply(ctx context.Context, cmd []byte) (
+	index uint64, resp interface{}, err error) {
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	for {
+		ls, err := decodeState(a.raw)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Bring the FSM up to date with the log as we last saw it,
+		// so that it may serve for compaction.
+		if err := a.catchUp(ls); err != nil {
+			return 0, nil, err
+		}
+		next, err := a.compact(ls)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Try to append our entry to the log.
+		l := Log{Index: ls.last() + 1, Data: cmd, AppendedAt: time.Now()}
+		next.Entries = append(next.Entries, l)
+		new := encodeState(next)
+		_, act, err := a.Store.CompareAndSet(ctx, a.raw, new)
+		if err != nil {
+			return 0, nil, err
+		}
+		a.raw = act
+		if act == new {
+			return l.Index, a.apply(&l), nil
+		}
+		// Someone else appended first, so try again after their entries.
+	}
+}
+
+// Barrier reads the latest state of the replicated log,
+// applies all the entries committed so far to the FSM,
+// and returns the index of the last entry applied.
+// Calling Barrier repeatedly keeps the FSM up to date
+// with others' commands, as a Raft follower's is.
+//
+func (a *Adapter) Barrier(ctx context.Context) (uint64, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	_, act, err := a.Store.CompareAndSet(ctx, a.raw, a.raw)
+	if err != nil {
+		return 0, err
+	}
+	a.raw = act
+	ls, err := decodeState(act)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.catchUp(ls); err != nil {
+		return 0, err
+	}
+	return a.applied, nil
+}
+
+// Applied returns the index of the last entry the FSM has applied.
+func (a *Adapter) Applied() uint64 {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	return a.applied
+}
+
+// Apply to the FSM all the entries in ls that it has not yet applied,
+// first restoring it from the snapshot in ls if it has fallen behind.
+func (a *Adapter) catchUp(ls *logState) error {
+	if a.applied < ls.Base {
+		rc := ioutil.NopCloser(bytes.NewReader(ls.Snapshot))
+		if err := a.FSM.Restore(rc); err != nil {
+			return err
+		}
+		a.applied = ls.Base
+	}
+	for i := a.applied - ls.Base; i < uint64(len(ls.Entries)); i++ {
+		a.apply(&ls.Entries[i])
+	}
+	return nil
+}
+
+// Apply one log entry to the FSM.
+func (a *Adapter) apply(l *Log) interface{} {
+	a.applied = l.Index
+	return a.FSM.Apply(l)
+}
+
+// Return the log to which to append a new entry,
+// replacing the entries in ls with a snapshot of the FSM
+// if they have reached the limit.
+// The FSM must have applied all the entries in ls.
+func (a *Adapter) compact(ls *logState) (*logState, error) {
+	max := a.MaxEntries
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	if len(ls.Entries) < max {
+		next := *ls
+		next.Entries = append([]Log{}, ls.Entries...)
+		return &next, nil
+	}
+
+	snap, err := a.FSM.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+	sink := &sink{}
+	if err := snap.Persist(sink); err != nil {
+		return nil, err
+	}
+	return &logState{Base: ls.last(), Snapshot: sink.buf.Bytes()}, nil
+}
+
+// In-memory SnapshotSink for compaction
+type sink struct {
+	buf bytes.Buffer
+}
+
+func (s *sink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+func (s *sink) Close() error                { return nil }
+func (s *sink) ID() string                  { return "fsm" }
+func (s *sink) Cancel() error               { return nil }
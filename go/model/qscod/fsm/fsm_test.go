@@ -0,0 +1,128 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// A simple key/value FSM whose commands are "key=value" pairs.
+type kvFSM struct {
+	kv  map[string]string
+	log []uint64 // indexes of the entries applied since the last restore
+}
+
+func (f *kvFSM) Apply(l *Log) interface{} {
+	var k, v string
+	fmt.Sscanf(string(l.Data), "%s %s", &k, &v)
+	old := f.kv[k]
+	f.kv[k] = v
+	f.log = append(f.log, l.Index)
+	return old
+}
+
+func (f *kvFSM) Snapshot() (FSMSnapshot, error) {
+	b, err := json.Marshal(f.kv)
+	return kvSnapshot(b), err
+}
+
+func (f *kvFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	f.kv = make(map[string]string)
+	f.log = nil
+	return json.NewDecoder(rc).Decode(&f.kv)
+}
+
+type kvSnapshot []byte
+
+func (s kvSnapshot) Persist(sink SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s kvSnapshot) Release() {}
+
+func newAdapter(st cas.Store, max int) *Adapter {
+	return &Adapter{Store: st, FSM: &kvFSM{kv: make(map[string]string)},
+		MaxEntries: max}
+}
+
+// Run concurrent clients appending commands to a shared log,
+// and check that all their FSMs converge to the same state.
+func testAdapter(t *testing.T, st cas.Store, clients, cmds, max int) {
+	ctx := context.Background()
+	as := make([]*Adapter, clients)
+	wg := sync.WaitGroup{}
+	for i := range as {
+		as[i] = newAdapter(st, max)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			last := uint64(0)
+			for j := 0; j < cmds; j++ {
+				cmd := fmt.Sprintf("k%d %d", j%3, i*cmds+j)
+				index, _, err := as[i].Apply(ctx, []byte(cmd))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if index <= last {
+					t.Errorf("index %v after %v", index, last)
+				}
+				last = index
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// A latecomer must catch up, via the snapshot if there is one.
+	as = append(as, newAdapter(st, max))
+	for _, a := range as {
+		if index, err := a.Barrier(ctx); err != nil ||
+			index != uint64(clients*cmds) {
+			t.Fatalf("barrier %v %v", index, err)
+		}
+		if kv := a.FSM.(*kvFSM).kv; !reflect.DeepEqual(kv,
+			as[0].FSM.(*kvFSM).kv) {
+			t.Errorf("inconsistent state %v %v", kv,
+				as[0].FSM.(*kvFSM).kv)
+		}
+	}
+	f := as[clients].FSM.(*kvFSM)
+	if len(f.log) > max {
+		t.Errorf("latecomer applied %v entries", len(f.log))
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	testAdapter(t, &cas.Register{}, 1, 100, 10)
+	testAdapter(t, &cas.Register{}, 10, 100, 10)
+	testAdapter(t, &cas.Register{}, 10, 10, 1000) // never compacts
+
+	// The FSM's responses reflect the commands before each.
+	a := newAdapter(&cas.Register{}, 0)
+	ctx := context.Background()
+	a.Apply(ctx, []byte("x 1"))
+	if _, resp, _ := a.Apply(ctx, []byte("x 2")); resp != "1" {
+		t.Errorf("response %v", resp)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	testAdapter(t, (&qscas.Group{}).Start(ctx, members, 1), 3, 20, 5)
+}
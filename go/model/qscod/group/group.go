@@ -0,0 +1,422 @@
+// Package group opens QSCOD consensus groups of file system member stores
+// identified by resource identifiers, as the qsc command does,
+// so that Go applications can embed the command's functionality directly.
+//
+// A group is identified by a composable resource identifier (CRI)
+// listing the paths of its member stores, such as
+// qsc[host1:path1,host2:path2,host3:path3],
+// or just [path1,path2,path3] for short.
+// CRIs cleanly support nesting of resource identifiers.
+//
+// Create provisions a new group and Open opens an existing one,
+// after which Get, Set, and Watch operate on the group's state as a string.
+// The underlying qscas.Group supports further operations,
+// such as on structured values or the group's in-band configuration.
+//
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bford/cofo/cri"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// Group represents an open QSCOD consensus group.
+// Close the Group when it is no longer required,
+// to stop the goroutines that operate it.
+//
+type Group struct {
+	QSC *qscas.Group // underlying consensus group
+
+	paths []string           // paths of the member stores
+	keys  *encoding.Keyring  // keys for encryption at rest, if any
+	conf  *qscas.Config      // group's in-band configuration, if any
+	stop  context.CancelFunc // stops the running qscas.Group
+}
+
+// Create provisions a new consensus group identified by ri,
+// creating its member stores, which must not yet exist,
+// and committing its initial in-band configuration,
+// tolerating the default number of faulty members.
+//
+func Create(ctx context.Context, ri string) (*Group, error) {
+	return CreateKeys(ctx, ri, nil)
+}
+
+// CreateKeys provisions a new consensus group like Create,
+// but one that encrypts the values it stores with keys,
+// unless keys is nil.
+// All clients must then open the group with OpenKeys and the same keys.
+//
+func CreateKeys(ctx context.Context, ri string, keys *encoding.Keyring) (
+	*Group, error) {
+
+	g, err := open(ctx, ri, keys, true, true)
+	if err != nil {
+		return nil, err
+	}
+	suite := ""
+	if keys != nil {
+		suite = qscas.SuiteAESGCM
+	}
+	conf, err := qscas.NewConfig(g.paths, -1, suite)
+	if err == nil {
+		conf.Nonces, err = readNonces(ctx, g.paths)
+	}
+	if err == nil {
+		_, err = g.QSC.Reconfigure(ctx, conf)
+	}
+	if err != nil {
+		g.Close()
+		return nil, err
+	}
+	g.conf = conf
+	return g, nil
+}
+
+// Open opens the existing consensus group identified by ri.
+//
+// The group's in-band configuration, if it has one,
+// must list the same members as ri,
+// and determines the group's consensus thresholds
+// and the failure domains of its members.
+// Once another client reconfigures the group and opens it anew,
+// operations on the Group return qscas.ErrStaleEpoch,
+// and the caller should close the Group and open it again,
+// with the members of its new configuration.
+//
+func Open(ctx context.Context, ri string) (*Group, error) {
+	return OpenKeys(ctx, ri, nil)
+}
+
+// OpenKeys opens the existing consensus group identified by ri like Open,
+// decrypting the values it stores with keys, unless keys is nil.
+// The keys must include that of the key epoch
+// the group's configuration commits, if any: see qscas.Group.RotateKeys.
+//
+func OpenKeys(ctx context.Context, ri string, keys *encoding.Keyring) (
+	*Group, error) {
+
+	return open(ctx, ri, keys, false, true)
+}
+
+// Open a consensus group with the given keys, if any,
+// creating it if create is true,
+// and checking that its members match its in-band configuration
+// only if check is true.
+func open(ctx context.Context, ri string, keys *encoding.Keyring,
+	create, check bool) (*Group, error) {
+
+	// Parse the group resource identifier into individual members
+	paths, err := ParseRI(ri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start a CAS-based consensus group across this set of stores,
+	// with the default threshold configuration.
+	g := &Group{paths: paths, keys: keys}
+	if err := g.start(ctx, create, -1, nil, 0); err != nil {
+		return nil, err
+	}
+	if create {
+		return g, nil
+	}
+
+	// Load the group's in-band configuration, if any,
+	// and restart the group with its thresholds if they differ,
+	// with its members' failure domains if it records them,
+	// and fenced to its configuration epoch if it has been reconfigured.
+	conf, err := g.QSC.Config(ctx)
+	switch {
+	case err == qscas.ErrUnconfigured:
+		return g, nil // group predates in-band configuration
+	case conf == nil:
+	case !check:
+		err = nil
+	case !equalPaths(conf.Members, paths):
+		err = fmt.Errorf("group members differ from configuration "+
+			"epoch %d: %s", conf.Epoch, FormatRI(conf.Members))
+	case err != nil && conf.Suite != "":
+	case err != nil:
+		g.Close()
+		err = g.start(ctx, false, conf.Faulty, nil, conf.Epoch)
+	case conf.Domains != nil || conf.Epoch > 0:
+		g.Close()
+		err = g.start(ctx, false, conf.Faulty, conf.Domains, conf.Epoch)
+	}
+	if err != nil {
+		g.Close()
+		return nil, err
+	}
+	g.conf = conf
+	return g, nil
+}
+
+// Start a qscas.Group across the group's member stores,
+// creating them if create is true,
+// in the given failure domains, if known,
+// and fenced to the given configuration epoch, if nonzero.
+func (g *Group) start(ctx context.Context, create bool, faulty int,
+	domains []string, epoch int64) error {
+
+	// Create a POSIX directory-based CAS interface to each store,
+	// caching directory listings for polling operations like Watch.
+	// Each qscas.Group needs its own, since a Store tracks its client's view.
+	stores := make([]cas.Store, len(g.paths))
+	for i, path := range g.paths {
+		st := &casdir.Store{Cache: true}
+		if err := st.Init(path, create, create); err != nil {
+			return err
+		}
+		stores[i] = st
+	}
+
+	ctx, g.stop = context.WithCancel(ctx)
+	g.QSC = (&qscas.Group{Keys: g.keys, Domains: domains, Epoch: epoch,
+		Names: g.paths}).Start(ctx, stores, faulty)
+	return nil
+}
+
+// Close stops the group's operation,
+// waiting until the goroutines that operate it have terminated.
+func (g *Group) Close() {
+	g.stop()
+	g.QSC.Close(context.Background())
+}
+
+// Members returns the paths of the group's member stores.
+func (g *Group) Members() []string {
+	return append([]string{}, g.paths...)
+}
+
+// Config returns the group's in-band configuration as of when it was opened,
+// or nil if the group has none.
+func (g *Group) Config() *qscas.Config {
+	return g.conf
+}
+
+// Get reads the group's latest committed state,
+// returning the version at which it committed.
+func (g *Group) Get(ctx context.Context) (version int64, value string,
+	err error) {
+
+	return g.QSC.CompareAndSet(ctx, "", "")
+}
+
+// Set changes the group's state to new, provided it is still old,
+// then returns the version and value of the latest state,
+// which is new if the change succeeded.
+//
+func (g *Group) Set(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	return g.QSC.CompareAndSet(ctx, old, new)
+}
+
+// Watch polls the group for newly committed states at the given interval,
+// calling f with the version and value of each new version,
+// even if its value equals the one before it,
+// starting from the group's state when Watch is called.
+// If several versions commit between polls, f sees only the latest.
+// Watch runs until ctx is cancelled or an error occurs,
+// including any error f returns, and then returns the error.
+//
+func (g *Group) Watch(ctx context.Context, interval time.Duration,
+	f func(version int64, value string) error) error {
+
+	// Find the current version, then poll for changes to it.
+	last, _, err := g.Get(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		ver, val, err := g.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if ver == last {
+			continue // nothing new committed
+		}
+		last = ver
+
+		if err := f(ver, val); err != nil {
+			return err
+		}
+	}
+}
+
+// Reconfigure commits an in-band configuration for a group with new members,
+// identified by paths, in the given failure domains,
+// or if domains is nil, the domains the group's current configuration
+// records for the members it retains.
+// The group keeps its fault tolerance if still valid for its new size.
+// Reconfigure returns the failure domains whose failure alone
+// would stop the group: see qscas.Config.DomainRisks.
+//
+// Membership changes are performed offline:
+// no other clients may access the group while the change is in progress.
+//
+func Reconfigure(ctx context.Context, paths, domains []string) (
+	risks []string, err error) {
+
+	g, err := open(ctx, FormatRI(paths), nil, false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer g.Close()
+
+	// Keep the group's fault tolerance if it is still valid.
+	faulty := -1
+	if g.conf != nil {
+		_, _, err := qscas.Thresholds(len(paths), g.conf.Faulty)
+		if err == nil {
+			faulty = g.conf.Faulty
+		}
+	}
+	c, err := qscas.NewConfig(paths, faulty, "")
+	if err != nil {
+		return nil, err
+	}
+	if g.conf != nil {
+		c.Epoch = g.conf.Epoch + 1
+	}
+	if domains == nil && g.conf != nil && g.conf.Domains != nil {
+		domains = make([]string, len(paths))
+		for i, path := range paths {
+			for j, old := range g.conf.Members {
+				if old == path && j < len(g.conf.Domains) {
+					domains[i] = g.conf.Domains[j]
+				}
+			}
+		}
+	}
+	if domains != nil {
+		if err := c.SetDomains(domains); err != nil {
+			return nil, err
+		}
+	}
+	if c.Nonces, err = readNonces(ctx, paths); err != nil {
+		return nil, err
+	}
+	if _, err := g.QSC.Reconfigure(ctx, c); err != nil {
+		return nil, err
+	}
+	return c.DomainRisks(), nil
+}
+
+// Readmit commits an in-band configuration for the group of members
+// identified by paths, recording the current nonces of their stores,
+// so that clients readmit members they quarantined for losing their state:
+// see qscas.ErrAmnesia.
+// Readmit a member only after rebuilding its state, as by qscas.Repair.
+// It does nothing to a group with no in-band configuration.
+//
+func Readmit(ctx context.Context, paths []string) error {
+	g, err := open(ctx, FormatRI(paths), nil, false, true)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	if g.conf == nil {
+		return nil
+	}
+	c := *g.conf
+	c.Epoch++
+	if c.Nonces, err = readNonces(ctx, paths); err != nil {
+		return err
+	}
+	_, err = g.QSC.Reconfigure(ctx, &c)
+	return err
+}
+
+// Read the nonces of the member stores at paths.
+func readNonces(ctx context.Context, paths []string) ([]string, error) {
+	stores := make([]cas.Store, len(paths))
+	for i, path := range paths {
+		st := &casdir.Store{}
+		if err := st.Init(path, false, false); err != nil {
+			return nil, err
+		}
+		stores[i] = st
+	}
+	return qscas.Nonces(ctx, stores)
+}
+
+// Return true if two lists of member paths are identical.
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseRI parses a group resource identifier
+// into the paths of its member stores.
+func ParseRI(ri string) ([]string, error) {
+
+	// Allow just '[...]' as a command-line shorthand for 'qsc[...]'
+	if len(ri) > 0 && ri[0] == '[' {
+		ri = "qsc" + ri
+	}
+
+	// Parsing it as an actual CRI/URI is kind of unnecessary so far,
+	// but may get more interesting with query-string options and such.
+	rawurl, err := cri.URI.From(ri)
+	if err != nil {
+		return nil, err
+	}
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme != "qsc" {
+		return nil, errors.New("consensus groups must use qsc scheme")
+	}
+
+	// Parse the nested member paths from the opaque string in the URL.
+	str, path := url.Opaque, ""
+	var paths []string
+	for str != "" {
+		if i := strings.IndexByte(str, ','); i >= 0 {
+			path, str = str[:i], str[i+1:]
+		} else {
+			path, str = str, ""
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) < 3 {
+		return nil, errors.New(
+			"consensus groups must have minimum three members")
+	}
+
+	return paths, nil
+}
+
+// FormatRI formats the paths of a group's member stores
+// as a group resource identifier, the inverse of ParseRI.
+func FormatRI(paths []string) string {
+	return "qsc[" + strings.Join(paths, ",") + "]"
+}
@@ -0,0 +1,239 @@
+package group
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+func TestRI(t *testing.T) {
+	paths, err := ParseRI("[a,b,c]")
+	if err != nil || !reflect.DeepEqual(paths, []string{"a", "b", "c"}) {
+		t.Fatalf("parsed %v %v", paths, err)
+	}
+	if ri := FormatRI(paths); ri != "qsc[a,b,c]" {
+		t.Errorf("formatted %q", ri)
+	}
+	if p, err := ParseRI(FormatRI(paths)); err != nil ||
+		!reflect.DeepEqual(p, paths) {
+		t.Errorf("reparsed %v %v", p, err)
+	}
+	for _, ri := range []string{"qsc[a,b]", "foo[a,b,c]"} {
+		if _, err := ParseRI(ri); err == nil {
+			t.Errorf("parsed invalid %q", ri)
+		}
+	}
+}
+
+// Return the resource identifier of a group of members
+// with the given names in a fresh temporary directory.
+// The member paths are relative, as absolute paths are not opaque in a URI.
+func tempRI(t *testing.T, names ...string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.Rel(wd, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+	return FormatRI(paths)
+}
+
+func TestGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ri := tempRI(t, "a", "b", "c")
+	g, err := Create(ctx, ri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(ctx, ri); err == nil {
+		t.Errorf("created existing group")
+	}
+	for old := ""; old != "x"; {
+		_, val, err := g.Set(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+	g.Close()
+
+	// Another client opens the group and sees its state.
+	h, err := Open(ctx, ri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if c := h.Config(); c == nil || !reflect.DeepEqual(c.Members,
+		h.Members()) {
+		t.Errorf("opened configuration %+v", c)
+	}
+	if _, val, err := h.Get(ctx); err != nil || val != "x" {
+		t.Errorf("got %q %v", val, err)
+	}
+	if _, err := Open(ctx, tempRI(t, "a", "b", "c")); err == nil {
+		t.Errorf("opened group with a missing member")
+	}
+
+	// Watch reports each new version until told to stop,
+	// including versions that no-op rounds commit with the same state.
+	errDone := errors.New("done")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		g, err := Open(ctx, ri)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer g.Close()
+		for old := "x"; old != "y"; {
+			_, old, err = g.Set(ctx, old, "y")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	err = h.Watch(ctx, 10*time.Millisecond, func(ver int64, val string) error {
+		switch val {
+		case "x":
+			return nil
+		case "y":
+			return errDone
+		}
+		t.Errorf("watched %v %q", ver, val)
+		return nil
+	})
+	if err != errDone {
+		t.Errorf("watch: %v", err)
+	}
+	<-done
+}
+
+// Test that Watch reports a state that changes and then changes back
+// between polls, though its value is the same as before.
+func TestWatchRecommit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ri := tempRI(t, "a", "b", "c")
+	g, err := Create(ctx, ri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	set := func(old, new string) {
+		for old != new {
+			_, old, err = g.Set(ctx, old, new)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	set("", "x")
+	ver, _, err := g.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errDone := errors.New("done")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		set("x", "y")
+		set("y", "x")
+	}()
+	err = g.Watch(ctx, time.Second, func(v int64, val string) error {
+		if val != "x" || v <= ver {
+			t.Errorf("watched %v %q after version %v", v, val, ver)
+		}
+		return errDone
+	})
+	if err != errDone {
+		t.Errorf("watch: %v", err)
+	}
+	<-done
+}
+
+func TestReconfigure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ri := tempRI(t, "a", "b", "c", "d")
+	g, err := Create(ctx, ri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := g.Members()
+	g.Close()
+
+	risks, err := Reconfigure(ctx, paths, []string{"e", "e", "w", ""})
+	if err != nil || !reflect.DeepEqual(risks, []string{"e"}) {
+		t.Fatalf("reconfigured with risks %v %v", risks, err)
+	}
+
+	// Members keep their domains across reconfigurations.
+	if _, err = Reconfigure(ctx, paths, nil); err != nil {
+		t.Fatal(err)
+	}
+	h, err := Open(ctx, ri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if c := h.Config(); c == nil || c.Epoch != 2 ||
+		!reflect.DeepEqual(c.Domains, []string{"e", "e", "w", ""}) {
+		t.Errorf("opened configuration %+v", c)
+	}
+}
+
+// Test an encrypted group whose keys are rotated through the group.
+func TestKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys := &encoding.Keyring{Epoch: 1, Keys: map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 32)}}
+	ri := tempRI(t, "a", "b", "c")
+	g, err := CreateKeys(ctx, ri, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := g.Set(ctx, "", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.QSC.RotateKeys(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+
+	h, err := OpenKeys(ctx, ri, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if c := h.Config(); c == nil || c.KeyEpoch() != 2 {
+		t.Errorf("opened configuration %+v", c)
+	}
+	if _, val, err := h.Get(ctx); err != nil || val != "x" {
+		t.Errorf("read %q %v", val, err)
+	}
+}
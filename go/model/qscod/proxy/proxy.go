@@ -0,0 +1,111 @@
+// Package proxy provides a QSCOD key/value Store
+// that shares one backend Store among many co-located clients.
+//
+// When many clients drive consensus through the same store replica,
+// they mostly issue WriteRead operations for the same time-steps,
+// all but the first of which are bound to read back the same value.
+// A Proxy coalesces concurrent operations on the same step
+// into a single backend operation, fanning its result back out,
+// and caches results so that later operations on the same step,
+// or on steps the store has already moved beyond,
+// need not reach the backend at all.
+// This reduces backend load roughly by the client multiplicity.
+//
+// Coalescing preserves correctness because each step's value is write-once:
+// a client whose write a Proxy never forwards reads back the value
+// another client wrote first, exactly as if it had lost the race
+// to write that step at the backend.
+//
+package proxy
+
+import (
+	"sync"
+
+	. "github.com/dedis/tlc/go/model/qscod/core"
+)
+
+// Proxy implements a QSCOD key/value Store
+// by forwarding operations to a backend Store,
+// coalescing and caching them on behalf of many clients.
+// The caller must set Backend before use,
+// after which a Proxy is safe for concurrent use.
+//
+type Proxy struct {
+	Backend Store // Underlying store that operations are forwarded to
+
+	mut    sync.Mutex      // Mutex protecting the fields below
+	op     map[int64]*call // Operations in flight or completed, by step
+	latest Value           // Highest-step value the backend returned
+}
+
+// State of one backend operation that clients share
+type call struct {
+	done chan struct{} // Closed when the operation completes
+	val  Value         // The value the backend returned
+}
+
+// WriteRead attempts to write v to the backend store at step v.S,
+// then returns the first value written there by any client,
+// or a value from a higher step if the store has moved beyond v.S.
+// Implements the core.Store interface.
+//
+func (p *Proxy) WriteRead(v Value) Value {
+	p.mut.Lock()
+	for {
+		// If the store has already moved beyond this step,
+		// just catch the client up to the latest value we know of.
+		if v.S < p.latest.S {
+			rv := p.latest
+			p.mut.Unlock()
+			return rv
+		}
+
+		// Join the operation on this step if one is already under way.
+		c := p.op[v.S]
+		if c == nil {
+			break
+		}
+		p.mut.Unlock()
+		<-c.done
+		if c.val.S >= v.S {
+			return c.val
+		}
+
+		// The backend gave up on the client that forwarded the operation,
+		// which says nothing about our own client, so try again.
+		p.mut.Lock()
+	}
+
+	// Otherwise forward our own write to the backend on everyone's behalf.
+	if p.op == nil {
+		p.op = make(map[int64]*call)
+	}
+	c := &call{done: make(chan struct{})}
+	p.op[v.S] = c
+	p.mut.Unlock()
+
+	c.val = p.Backend.WriteRead(v)
+
+	p.mut.Lock()
+	if c.val.S < v.S {
+
+		// The backend gave up, e.g., because it was cancelled,
+		// so don't cache its result, and let each joined client retry.
+		delete(p.op, v.S)
+
+	} else if c.val.S > p.latest.S {
+
+		// Record the new latest value and forget older operations,
+		// which later clients will simply catch up past.
+		p.latest = c.val
+		for s := range p.op {
+			if s < p.latest.S {
+				delete(p.op, s)
+			}
+		}
+	}
+	p.mut.Unlock()
+
+	close(c.done)
+	return c.val
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/testsuite"
+)
+
+// countStore is a backend Store that counts the operations reaching it.
+type countStore struct {
+	testsuite.MemStore
+	ops int64
+}
+
+func (cs *countStore) WriteRead(v Value) Value {
+	atomic.AddInt64(&cs.ops, 1)
+	return cs.MemStore.WriteRead(v)
+}
+
+// Create a group of nnode in-memory Stores, each behind a Proxy.
+func proxyKV(t *testing.T, nnode int) []Store {
+	kv := make([]Store, nnode)
+	for i := range kv {
+		kv[i] = &Proxy{Backend: &countStore{}}
+	}
+	return kv
+}
+
+func TestProxy(t *testing.T) {
+	testsuite.Battery(t, proxyKV)
+	testsuite.Battery(t, proxyKV,
+		testsuite.Config{Fail: 1, Nodes: 3, Clients: 100, Steps: 1000,
+			MaxPri: 100})
+}
+
+// giveUpStore is a backend Store that gives up on its first operation,
+// returning an older value, once the test releases it.
+type giveUpStore struct {
+	testsuite.MemStore
+	release chan struct{}
+	ops     int64
+}
+
+func (gs *giveUpStore) WriteRead(v Value) Value {
+	if atomic.AddInt64(&gs.ops, 1) == 1 {
+		<-gs.release
+		return Value{}
+	}
+	return gs.MemStore.WriteRead(v)
+}
+
+// Test that clients that joined an operation the backend gave up on
+// retry rather than accept the older value.
+func TestGiveUp(t *testing.T) {
+	gs := &giveUpStore{release: make(chan struct{})}
+	p := &Proxy{Backend: gs}
+
+	first := make(chan Value)
+	go func() { first <- p.WriteRead(Value{S: 1, I: 1}) }()
+	for atomic.LoadInt64(&gs.ops) == 0 {
+		runtime.Gosched()
+	}
+
+	// Join the first client's operation, then let the backend give up.
+	joined := make(chan Value)
+	go func() { joined <- p.WriteRead(Value{S: 1, I: 2}) }()
+	time.Sleep(10 * time.Millisecond) // let the second client join
+	close(gs.release)
+
+	if v := <-first; v.S != 0 {
+		t.Errorf("forwarding client read step %v, expected 0", v.S)
+	}
+	if v := <-joined; v.S != 1 || v.I != 2 {
+		t.Errorf("joined client read %+v, expected its own write", v)
+	}
+}
+
+// Test that a Proxy coalesces many clients' operations on the same step.
+func TestFanIn(t *testing.T) {
+	const nclients = 100
+	const nsteps = 50
+
+	cs := &countStore{}
+	p := &Proxy{Backend: cs}
+	for s := int64(1); s <= nsteps; s++ {
+		var wg sync.WaitGroup
+		res := make([]Value, nclients)
+		for i := 0; i < nclients; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				res[i] = p.WriteRead(Value{S: s, I: int64(i)})
+			}(i)
+		}
+		wg.Wait()
+		for i := range res {
+			if res[i].S != s || res[i].I != res[0].I {
+				t.Fatalf("step %v: client %v read %v, client 0 read %v",
+					s, i, res[i].I, res[0].I)
+			}
+		}
+	}
+
+	// Operations on steps the store has moved beyond are served locally.
+	if v := p.WriteRead(Value{S: 1}); v.S != nsteps {
+		t.Errorf("read step %v, expected %v", v.S, nsteps)
+	}
+	if cs.ops != nsteps {
+		t.Errorf("%v backend operations for %v steps", cs.ops, nsteps)
+	}
+}
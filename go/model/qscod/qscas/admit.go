@@ -0,0 +1,119 @@
+package qscas
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// admission limits the operations a Group admits concurrently and per second,
+// queuing callers that must wait in first-come, first-served order.
+type admission struct {
+	max int           // maximum concurrent operations, or 0 for no limit
+	gap time.Duration // minimum time between admissions, or 0
+
+	mut    sync.Mutex    // protects the fields below
+	active int           // operations admitted and not yet released
+	next   time.Time     // earliest time of the next admission
+	queue  []*admitQueue // callers waiting for admission, in order
+	timer  *time.Timer   // pending dispatch once the rate allows
+}
+
+// Entry of a caller waiting in an admission queue.
+type admitQueue struct {
+	ready   chan struct{} // closed on admission
+	granted bool          // set on admission
+}
+
+// Configure an admission controller with the given limits.
+func (a *admission) init(max int, rate float64) {
+	a.max = max
+	if rate > 0 {
+		a.gap = time.Duration(float64(time.Second) / rate)
+	}
+}
+
+// Returns true if the admission controller imposes any limit.
+func (a *admission) limited() bool {
+	return a.max > 0 || a.gap > 0
+}
+
+// Wait for admission of an operation,
+// returning an error if ctx or the group's context gctx is cancelled first.
+// The caller must call release after each successful admission.
+func (a *admission) admit(ctx, gctx context.Context) error {
+	a.mut.Lock()
+	q := &admitQueue{ready: make(chan struct{})}
+	a.queue = append(a.queue, q)
+	a.dispatch()
+	a.mut.Unlock()
+
+	select {
+	case <-q.ready:
+		return nil
+	case <-ctx.Done():
+	case <-gctx.Done():
+	}
+
+	// We gave up waiting: leave the queue,
+	// or return our slot if we were admitted in the meantime.
+	a.mut.Lock()
+	if q.granted {
+		a.active--
+	}
+	for i := range a.queue {
+		if a.queue[i] == q {
+			a.queue = append(a.queue[:i], a.queue[i+1:]...)
+			break
+		}
+	}
+	a.dispatch()
+	a.mut.Unlock()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return gctx.Err()
+}
+
+// Release the slot of an operation that has completed.
+func (a *admission) release() {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	a.active--
+	a.dispatch()
+}
+
+// Admit waiting callers in order as far as the limits allow,
+// with the mutex locked.
+func (a *admission) dispatch() {
+	for len(a.queue) > 0 && (a.max == 0 || a.active < a.max) {
+		if a.gap > 0 {
+			now := time.Now()
+			if now.Before(a.next) {
+				if a.timer == nil {
+					a.timer = time.AfterFunc(a.next.Sub(now),
+						a.expire)
+				}
+				return
+			}
+			a.next = now.Add(a.gap)
+		}
+
+		q := a.queue[0]
+		a.queue = a.queue[1:]
+		q.granted = true
+		a.active++
+		close(q.ready)
+	}
+}
+
+// Dispatch waiting callers once the rate limit allows.
+func (a *admission) expire() {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	a.timer = nil
+	a.dispatch()
+}
@@ -0,0 +1,97 @@
+package qscas
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+)
+
+func TestAdmission(t *testing.T) {
+	ctx := context.Background()
+
+	// Waiting callers must be admitted in order, within the limit.
+	a := &admission{}
+	a.init(2, 0)
+	for i := 0; i < 2; i++ {
+		if err := a.admit(ctx, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mut sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := a.admit(ctx, ctx); err != nil {
+				t.Error(err)
+			}
+			mut.Lock()
+			order = append(order, i)
+			mut.Unlock()
+			a.release()
+		}(i)
+
+		// Wait for the caller to join the queue before the next.
+		for {
+			a.mut.Lock()
+			n := len(a.queue)
+			a.mut.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A caller that gives up must not hold up those behind it.
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := a.admit(cctx, ctx); err != context.DeadlineExceeded {
+		t.Errorf("admitted %v", err)
+	}
+
+	a.release()
+	wg.Wait()
+	for i := range order {
+		if order[i] != i {
+			t.Fatalf("admitted out of order: %v", order)
+		}
+	}
+	if a.active != 1 || len(a.queue) != 0 {
+		t.Errorf("%v active, %v queued", a.active, len(a.queue))
+	}
+
+	// Admissions must respect the rate limit.
+	a = &admission{}
+	a.init(0, 100)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := a.admit(ctx, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("admitted 10 operations at 100/s in %v", d)
+	}
+}
+
+// Test Groups that limit their operations.
+func TestLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	clients := make([]cas.Store, 3)
+	for i := range clients {
+		g := &Group{MaxConcurrent: 1 + i, MaxRate: 1000}
+		clients[i] = g.Start(ctx, members, 1)
+	}
+	test.Stores(t, 10, 100, clients...)
+}
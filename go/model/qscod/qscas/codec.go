@@ -0,0 +1,241 @@
+package qscas
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dedis/tlc/go/lib/cbor"
+)
+
+// Codec encodes and decodes structured application values
+// to and from the byte strings a Group commits: see Group.Get.
+// Each Codec has a unique name identifying its encoding format,
+// which a Group stores with each value it encodes.
+//
+type Codec interface {
+	Name() string                               // Encoding format name
+	Marshal(v interface{}) ([]byte, error)      // Encode v
+	Unmarshal(data []byte, v interface{}) error // Decode data into v
+}
+
+// JSON is a Codec encoding values in JSON, via encoding/json.
+var JSON Codec = jsonCodec{}
+
+// CBOR is a Codec encoding values in the more compact binary CBOR format,
+// via their JSON representations.
+var CBOR Codec = cborCodec{}
+
+// Codecs maps the name of each Codec this package provides to the Codec.
+var Codecs = map[string]Codec{JSON.Name(): JSON, CBOR.Name(): CBOR}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(b []byte, v interface{}) error {
+	return cbor.Unmarshal(b, v)
+}
+
+// Validator may be implemented by structured values
+// to check their own consistency.
+// Get calls Validate after decoding a value,
+// and Update calls it before committing one,
+// so that clients never act on or commit an invalid state.
+type Validator interface {
+	Validate() error
+}
+
+// ErrUnstructured is returned by Group.Get and Group.Update
+// when the group's state is a plain string rather than a structured value.
+var ErrUnstructured = errors.New("group state is not a structured value")
+
+// ErrNewerSchema is returned by Group.Get and Group.Update
+// when the group's state was written at a later schema version
+// than the client's Group.Schema, which it may not safely interpret.
+var ErrNewerSchema = errors.New("group state has a newer schema version")
+
+// A structured value starts with this prefix,
+// followed by the length of its codec name as a uvarint, the codec name,
+// its schema version as a uvarint, a random nonce as a uvarint,
+// and finally the encoded value.
+// The nonce makes each value a client commits unique,
+// so that Update can tell whether its own commit succeeded
+// even when another client concurrently commits an identical value.
+const valuePrefix = "\x00qsc-value\x00"
+
+// Return the Codec a group uses for structured values.
+func (g *Group) codec() Codec {
+	if g.Codec == nil {
+		return JSON
+	}
+	return g.Codec
+}
+
+// Encode and tag a structured value.
+func (g *Group) encode(v interface{}) (string, error) {
+	if vv, ok := v.(Validator); ok {
+		if err := vv.Validate(); err != nil {
+			return "", err
+		}
+	}
+	c := g.codec()
+	b, err := c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(c.Name())))
+	s := valuePrefix + string(l[:n]) + c.Name()
+	n = binary.PutUvarint(l[:], uint64(g.Schema))
+	s += string(l[:n])
+	n = binary.PutUvarint(l[:], uint64(randValue()))
+	return s + string(l[:n]) + string(b), nil
+}
+
+// Decode a tagged structured value into v, a non-nil pointer,
+// returning the schema version it was written at.
+// The empty initial state decodes as v's zero value at schema version 0.
+func (g *Group) decode(s string, v interface{}) (schema int, err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return 0, errors.New("structured value must be a pointer")
+	}
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	if s == "" {
+		return 0, nil
+	}
+
+	// Parse the value's tags.
+	if !strings.HasPrefix(s, valuePrefix) {
+		return 0, ErrUnstructured
+	}
+	b := []byte(s[len(valuePrefix):])
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return 0, ErrUnstructured
+	}
+	name := string(b[n : n+int(l)])
+	b = b[n+int(l):]
+	ver, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, ErrUnstructured
+	}
+	b = b[n:]
+	if _, n = binary.Uvarint(b); n <= 0 { // skip the nonce
+		return 0, ErrUnstructured
+	}
+	b = b[n:]
+
+	// Check that we can interpret it before decoding it.
+	c := g.codec()
+	if name != c.Name() {
+		return 0, fmt.Errorf("group state is encoded with codec %q, "+
+			"not %q", name, c.Name())
+	}
+	if ver > uint64(g.Schema) {
+		return 0, fmt.Errorf("%v: version %v, not %v",
+			ErrNewerSchema, ver, g.Schema)
+	}
+	if err := c.Unmarshal(b, v); err != nil {
+		return 0, err
+	}
+	if vv, ok := v.(Validator); ok {
+		if err := vv.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	return int(ver), nil
+}
+
+// Get reads the group's latest committed state as a structured value,
+// decoding it with the group's Codec into v, which must be a non-nil pointer.
+// Returns the version at which the state committed,
+// and the schema version of the client that wrote it,
+// which may be earlier than the group's Schema,
+// in which case the caller may need to migrate the value.
+//
+// Get fails if the state was encoded with a different Codec,
+// at a newer schema version than the group's Schema,
+// or if the decoded value fails validation: see Validator.
+//
+func (g *Group) Get(ctx context.Context, v interface{}) (
+	version int64, schema int, err error) {
+
+	version, s, err := g.CompareAndSet(ctx, "", "")
+	if err != nil {
+		return 0, 0, err
+	}
+	schema, err = g.decode(s, v)
+	return version, schema, err
+}
+
+// Update atomically modifies the group's state as a structured value.
+// It decodes the latest state into v, as Get does,
+// calls f with the schema version of that state to modify v in place,
+// then encodes v at the group's Schema version and commits it.
+// If another client commits a new state first, Update starts over,
+// until it commits successfully or f returns an error.
+// Returns the version at which the modified value committed.
+//
+func (g *Group) Update(ctx context.Context, v interface{},
+	f func(schema int) error) (version int64, err error) {
+
+	version, cur, err := g.CompareAndSet(ctx, "", "")
+	if err != nil {
+		return 0, err
+	}
+	for {
+		new, err := g.modify(cur, v, f)
+		if err != nil {
+			return 0, err
+		}
+		var actual string
+		version, actual, err = g.CompareAndSet(ctx, cur, new)
+		if err != nil {
+			return 0, err
+		}
+		if actual == new {
+			return version, nil
+		}
+		cur = actual
+	}
+}
+
+// Decode state cur into v, modify it with f, and return its new encoding.
+func (g *Group) modify(cur string, v interface{}, f func(int) error) (
+	string, error) {
+
+	schema, err := g.decode(cur, v)
+	if err != nil {
+		return "", err
+	}
+	if err := f(schema); err != nil {
+		return "", err
+	}
+	return g.encode(v)
+}
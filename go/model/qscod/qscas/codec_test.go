@@ -0,0 +1,129 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A structured test state, which must never go negative.
+type counter struct {
+	Count int
+	Names []string `json:",omitempty"`
+}
+
+func (c *counter) Validate() error {
+	if c.Count < 0 {
+		return errors.New("negative count")
+	}
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	for _, c := range []Codec{JSON, CBOR} {
+		t.Run(c.Name(), func(t *testing.T) { testCodec(t, c) })
+	}
+}
+
+func testCodec(t *testing.T, c Codec) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	start := func(schema int) *Group {
+		return (&Group{Codec: c, Schema: schema}).Start(ctx, members, 1)
+	}
+
+	// Concurrent clients increment a counter atomically.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				var v counter
+				_, err := g.Update(ctx, &v, func(int) error {
+					v.Count++
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(start(1))
+	}
+	wg.Wait()
+
+	g := start(1)
+	var v counter
+	if _, schema, err := g.Get(ctx, &v); err != nil || schema != 1 ||
+		v.Count < 15 {
+		t.Fatalf("got %+v schema %v: %v", v, schema, err)
+	}
+
+	// Invalid values are never committed.
+	_, err := g.Update(ctx, &v, func(int) error {
+		v.Count = -1
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("committed invalid value: %v", err)
+	}
+
+	// A client with a newer schema can read and upgrade the value,
+	// after which older clients refuse to read it.
+	h := start(2)
+	_, err = h.Update(ctx, &v, func(schema int) error {
+		if schema != 1 {
+			t.Errorf("upgrading from schema %v", schema)
+		}
+		v.Names = []string{"upgraded"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := g.Get(ctx, &v); err == nil ||
+		!strings.Contains(err.Error(), ErrNewerSchema.Error()) {
+		t.Errorf("old client read newer schema: %v", err)
+	}
+	if _, schema, err := h.Get(ctx, &v); err != nil || schema != 2 ||
+		v.Names[0] != "upgraded" {
+		t.Errorf("got %+v schema %v: %v", v, schema, err)
+	}
+
+	// Clients using another codec cannot read it.
+	other := JSON
+	if c == JSON {
+		other = CBOR
+	}
+	o := (&Group{Codec: other, Schema: 2}).Start(ctx, members, 1)
+	if _, _, err := o.Get(ctx, &v); err == nil {
+		t.Errorf("read value encoded with %s via %s",
+			c.Name(), other.Name())
+	}
+}
+
+func TestUnstructured(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g := (&Group{}).Start(ctx, []cas.Store{&cas.Register{},
+		&cas.Register{}, &cas.Register{}}, 1)
+	for old := ""; old != "x"; {
+		_, val, err := g.CompareAndSet(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+	var v counter
+	if _, _, err := g.Get(ctx, &v); err != ErrUnstructured {
+		t.Errorf("read plain string as structured value: %v", err)
+	}
+}
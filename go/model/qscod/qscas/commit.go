@@ -0,0 +1,291 @@
+package qscas
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"strings"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Commit describes the ordering of a committed state in a group's history.
+//
+// Index numbers the state changes in the group's history densely:
+// each proposal that changes the group's state, including its configuration,
+// commits with an index exactly one greater than that of the state it replaces.
+// The initial empty state, and the state of a group that predates
+// commit ordering metadata, has index zero.
+// A client that observes commits with indexes i and j > i+1
+// thus knows that j-i-1 state changes committed in between
+// that it did not observe.
+//
+// Version is the TLC step at which the state change committed.
+// Commits that merely repeat the prior committed state,
+// such as the no-op proposals that clients make to complete rounds,
+// report the version of the commit that first introduced the state,
+// unless the observing Group has NoOps set.
+//
+// Client identifies the client that proposed the state change,
+// as set in its Group's ID, or is empty if the proposer set none.
+// Together with Index, it allows applications to build logs
+// and idempotence layers atop a group.
+//
+type Commit struct {
+	Version int64  // TLC step number of the committed state change
+	Index   int64  // Dense index of the committed state change
+	Client  string // ID of the client that proposed it, or ""
+}
+
+// ErrUnknownOutcome is returned by a CompareAndSet operation
+// that proposed its change but cannot tell whether the change committed,
+// because the Group missed the commit of the state change
+// at the index of its proposal, and cannot trace the history
+// of the state changes committed since back that far.
+// The caller must read the group's state to learn the outcome.
+// It is in the class cas.ErrUnknownOutcome.
+var ErrUnknownOutcome = cas.Classify(cas.ErrUnknownOutcome,
+	errors.New("outcome of proposed change unknown"))
+
+// Ordering metadata that a Group commits in-band with each state change.
+type commitMeta struct {
+	index  int64
+	client string
+	step   int64  // step first proposing the state, in no-ops, or 0
+	parent uint64 // digest of the state replaced, or 0 if unknown
+}
+
+// Return the metadata for a state change proposed by client
+// atop a state with metadata m and remaining state rest.
+func (m commitMeta) next(client string, rest string) commitMeta {
+	return commitMeta{m.index + 1, client, 0, digest(m, rest)}
+}
+
+// Return the metadata for a no-op proposal repeating a state
+// with metadata m, proposed at step s and committed if com is true.
+// Only a state known to be committed keeps its version:
+// repeating a state that failed to commit, as in a round that
+// committed some other proposal, must yield a distinct version.
+func (m commitMeta) noop(s int64, com bool) commitMeta {
+	switch {
+	case !com:
+		m.step = 0
+	case m.step == 0:
+		m.step = s
+	}
+	return m
+}
+
+// Return the version of a state with metadata m committed at step s:
+// the step of the proposal that first introduced the state.
+func (m commitMeta) version(s int64) int64 {
+	if m.step != 0 {
+		return m.step
+	}
+	return s
+}
+
+// Return the version to report for a state with metadata m
+// committed at step s, depending on whether g reports no-ops.
+func (g *Group) version(s int64, m commitMeta) int64 {
+	if g.NoOps {
+		return s
+	}
+	return m.version(s)
+}
+
+// A group's state with ordering metadata starts with this prefix,
+// followed by the commit index as a uvarint,
+// the length of the proposing client's ID as a uvarint, and the ID.
+// Any in-band configuration and the application state follow.
+const commitPrefix = "\x00qsc-commit\x00"
+
+// The metadata of a state that records the state it replaced
+// starts with this prefix, followed by the replaced state's digest
+// as 8 bytes in big-endian order.
+// States written before Groups recorded digests lack it.
+const parentPrefix = "\x00qsc-parent\x00"
+
+// The state of a no-op proposal starts with this prefix,
+// followed by the step of the proposal that first introduced the state
+// as a uvarint, and then the ordering metadata and the rest of the state.
+// The noop prefix comes first, then the parent prefix, if any,
+// and then the commit prefix.
+const noopPrefix = "\x00qsc-noop\x00"
+
+// Join ordering metadata with the rest of a group's state.
+func joinCommit(m commitMeta, rest string) string {
+	var l [binary.MaxVarintLen64]byte
+	s := ""
+	if m.step != 0 {
+		n := binary.PutUvarint(l[:], uint64(m.step))
+		s = noopPrefix + string(l[:n])
+	}
+	if m.parent != 0 {
+		var p [8]byte
+		binary.BigEndian.PutUint64(p[:], m.parent)
+		s += parentPrefix + string(p[:])
+	}
+	n := binary.PutUvarint(l[:], uint64(m.index))
+	s += commitPrefix + string(l[:n])
+	n = binary.PutUvarint(l[:], uint64(len(m.client)))
+	return s + string(l[:n]) + m.client + rest
+}
+
+// Split a group's state into its ordering metadata, if any,
+// and the rest of its state.
+func splitCommit(state string) (commitMeta, string) {
+	step, rest := int64(0), state
+	if strings.HasPrefix(rest, noopPrefix) {
+		b := []byte(rest[len(noopPrefix):])
+		s, n := binary.Uvarint(b)
+		if n <= 0 {
+			return commitMeta{}, state // not valid metadata after all
+		}
+		step, rest = int64(s), string(b[n:])
+	}
+	parent := uint64(0)
+	if strings.HasPrefix(rest, parentPrefix) {
+		b := []byte(rest[len(parentPrefix):])
+		if len(b) < 8 {
+			return commitMeta{}, state
+		}
+		parent, rest = binary.BigEndian.Uint64(b), string(b[8:])
+	}
+	if !strings.HasPrefix(rest, commitPrefix) {
+		return commitMeta{}, state
+	}
+	b := []byte(rest[len(commitPrefix):])
+	index, n := binary.Uvarint(b)
+	if n <= 0 {
+		return commitMeta{}, state // not valid metadata after all
+	}
+	b = b[n:]
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return commitMeta{}, state
+	}
+	client := string(b[n : n+int(l)])
+	return commitMeta{int64(index), client, step, parent},
+		string(b[n+int(l):])
+}
+
+// Return a digest identifying the state change with metadata m
+// and remaining state rest.
+// No-op proposals repeating the state have the same digest.
+func digest(m commitMeta, rest string) uint64 {
+	m.step = 0
+	h := fnv.New64a()
+	h.Write([]byte(joinCommit(m, rest)))
+	return h.Sum64()
+}
+
+// CompareAndSetCommit performs a CompareAndSet operation,
+// additionally returning the ordering metadata of the commit
+// that completed it: see Commit.
+//
+func (g *Group) CompareAndSetCommit(ctx context.Context, old, new string) (
+	commit Commit, actual string, err error) {
+
+	version, actual, _, meta, err := g.compareAndSet(ctx, old, new)
+	if err != nil {
+		return Commit{}, "", err
+	}
+	return Commit{version, meta.index, meta.client}, actual, nil
+}
+
+// The number of distinct states a Group remembers observing
+// as the outcomes of consensus rounds.
+const numRecent = 64
+
+// A state that a Group observed as the outcome of a consensus round,
+// whether or not the Group knew it to be committed.
+type recentState struct {
+	digest  uint64 // digest identifying the state
+	index   int64  // commit index of the state
+	parent  uint64 // digest of the state it replaced, or 0
+	version int64  // version of the state if known committed, or 0
+	last    int64  // latest step at which the state was observed
+}
+
+// Return the version of a state observed in consensus rounds
+// and later found committed through the history of a later commit.
+// Without a known version, the latest step at which the Group
+// observed the state as a round's outcome serves:
+// every client's outcome of a committed round is the state committed,
+// so no other state could have committed at that step.
+func (rs *recentState) committed() int64 {
+	if rs.version != 0 {
+		return rs.version
+	}
+	return rs.last
+}
+
+// The distinct states a Group observed most recently, in a circular buffer.
+// Only the consensus core's main thread accesses them,
+// within the Group's proposal function.
+type recentStates struct {
+	s    [numRecent]recentState
+	next int // slot to fill next
+}
+
+// Record a state with metadata m and remaining state rest
+// observed as the outcome of a round at step s,
+// with its committed version if known or else 0.
+// Recording a state already recorded updates its latest step
+// and fills in its version if unknown.
+func (r *recentStates) observe(s int64, m commitMeta, rest string,
+	version int64) {
+
+	d := digest(m, rest)
+	if rs := r.lookup(d); rs != nil {
+		if rs.version == 0 {
+			rs.version = version
+		}
+		rs.last = s
+		return
+	}
+	r.s[r.next] = recentState{d, m.index, m.parent, version, s}
+	r.next = (r.next + 1) % numRecent
+}
+
+// Look up a recently observed state by digest, or return nil.
+func (r *recentStates) lookup(d uint64) *recentState {
+	for i := range r.s {
+		if r.s[i].digest == d && d != 0 {
+			return &r.s[i]
+		}
+	}
+	return nil
+}
+
+// Return the digest of the state at commit index i
+// in the history leading up to the committed state with metadata m
+// and remaining state rest, tracing the history back
+// through the parents of recently observed states.
+// Since every state in the history of a committed state
+// is itself committed, the state returned is the group's state at index i.
+// Returns false if the Group cannot trace the history back that far.
+func (r *recentStates) ancestor(m commitMeta, rest string, i int64) (
+	uint64, bool) {
+
+	if i > m.index {
+		return 0, false
+	}
+	d, parent := digest(m, rest), m.parent
+	for j := m.index; j > i; j-- {
+		if parent == 0 {
+			return 0, false
+		}
+		d = parent
+		if j-1 > i {
+			rs := r.lookup(d)
+			if rs == nil || rs.index != j-1 {
+				return 0, false
+			}
+			parent = rs.parent
+		}
+	}
+	return d, true
+}
@@ -0,0 +1,197 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestCommitState(t *testing.T) {
+	m := commitMeta{12345, "client", 0, 0x0123456789abcdef}
+	for _, rest := range []string{"", "x", commitPrefix, noopPrefix,
+		joinState(&Config{Epoch: 1}, "y")} {
+		m2, r := splitCommit(joinCommit(m, rest))
+		if m2 != m || r != rest {
+			t.Errorf("%q: split into %+v %q", rest, m2, r)
+		}
+		n, r := splitCommit(joinCommit(m.noop(678, true), rest))
+		if n.noop(999, true) != n || n.version(999) != 678 || r != rest {
+			t.Errorf("%q: split no-op into %+v %q", rest, n, r)
+		}
+	}
+	if m, r := splitCommit(commitPrefix); m.index != 0 || r != commitPrefix {
+		t.Errorf("split truncated metadata into %+v %q", m, r)
+	}
+}
+
+// Test tracing a committed state's history back through recent states.
+func TestRecentStates(t *testing.T) {
+	var r recentStates
+	m, rest := commitMeta{}, ""
+	ds := []uint64{digest(m, rest)}
+	for i := 1; i <= 5; i++ {
+		m = m.next("client", rest)
+		rest = fmt.Sprintf("state %d", i)
+		ds = append(ds, digest(m, rest))
+		if i != 3 {
+			r.observe(int64(10*i), m, rest, 0)
+		}
+	}
+	r.observe(60, m.noop(60, true), rest, 60)
+	r.observe(70, m, rest, 0)
+
+	// The history is traceable back through observed states,
+	// and one step past them, to the unobserved state 3.
+	for i := int64(5); i >= 3; i-- {
+		if d, ok := r.ancestor(m, rest, i); !ok || d != ds[i] {
+			t.Errorf("ancestor %d: %x %v, want %x", i, d, ok, ds[i])
+		}
+	}
+	if _, ok := r.ancestor(m, rest, 2); ok {
+		t.Errorf("traced history past an unobserved state")
+	}
+	if _, ok := r.ancestor(m, rest, 6); ok {
+		t.Errorf("traced history into the future")
+	}
+
+	// A state not known committed takes its latest observed step
+	// as its version, whereas observing a state again
+	// fills in only an unknown version.
+	if rs := r.lookup(ds[4]); rs == nil || rs.committed() != 40 {
+		t.Errorf("lookup: %+v", rs)
+	}
+	if rs := r.lookup(ds[5]); rs == nil || rs.committed() != 60 ||
+		rs.last != 70 {
+		t.Errorf("lookup after repeat: %+v", rs)
+	}
+}
+
+func TestCommitIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+
+	// A single client's changes are indexed consecutively.
+	g := (&Group{ID: "solo"}).Start(ctx, members, 1)
+	old := ""
+	for i := int64(1); i <= 10; i++ {
+		new := fmt.Sprintf("solo %d", i)
+		c, actual, err := g.CompareAndSetCommit(ctx, old, new)
+		if err != nil || actual != new {
+			t.Fatalf("CompareAndSetCommit: %q %v", actual, err)
+		}
+		if c.Index != i || c.Client != "solo" {
+			t.Errorf("commit %d: %+v", i, c)
+		}
+		old = new
+	}
+
+	// Reads don't advance the index.
+	c, _, err := g.CompareAndSetCommit(ctx, old, old)
+	if err != nil || c.Index != 10 || c.Client != "solo" {
+		t.Errorf("read %+v: %v", c, err)
+	}
+
+	// Configuration changes, by any client, are state changes too.
+	h := (&Group{ID: "admin"}).Start(ctx, members, 1)
+	conf, err := NewConfig([]string{"a", "b", "c"}, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Reconfigure(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err = g.CompareAndSetCommit(ctx, old, old)
+	if err != nil || c.Index != 11 || c.Client != "admin" {
+		t.Errorf("read after reconfiguration %+v: %v", c, err)
+	}
+
+	// Concurrent clients' successful changes get distinct indexes.
+	mut := sync.Mutex{}
+	seen := make(map[int64]string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("client %d", i)
+		g := (&Group{ID: id}).Start(ctx, members, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			old, last := "", int64(0)
+			for j := 0; j < 20; j++ {
+				new := fmt.Sprintf("%s write %d", id, j)
+				c, actual, err := g.CompareAndSetCommit(ctx,
+					old, new)
+				for errors.Is(err, ErrUnknownOutcome) {
+					c, actual, err = g.CompareAndSetCommit(ctx,
+						old, old)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if c.Index <= last {
+					t.Errorf("%s: index %d after %d",
+						id, c.Index, last)
+				}
+				old, last = actual, c.Index
+				if actual != new {
+					continue
+				}
+				mut.Lock()
+				if prev, ok := seen[c.Index]; ok {
+					t.Errorf("index %d committed by %s and %s",
+						c.Index, prev, id)
+				}
+				seen[c.Index] = id
+				mut.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Test that no-op commits surface as new versions only if requested.
+func TestNoOps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	g := (&Group{}).Start(ctx, members, 1)
+	raw := (&Group{NoOps: true}).Start(ctx, members, 1)
+
+	ver, val, err := g.CompareAndSet(ctx, "", "x")
+	for err == nil && val != "x" {
+		ver, val, err = g.CompareAndSet(ctx, val, "x")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads, each completing on a later commit, see the same version,
+	// except via the Group that reports no-ops.
+	rawVer := int64(0)
+	for i := 0; i < 5; i++ {
+		v, val, err := g.CompareAndSet(ctx, "", "")
+		if err != nil || v != ver || val != "x" {
+			t.Errorf("read %v %q %v, want version %v", v, val, err, ver)
+		}
+		v, _, err = raw.CompareAndSet(ctx, "", "")
+		if err != nil || v <= rawVer {
+			t.Errorf("raw read %v %v after %v", v, err, rawVer)
+		}
+		rawVer = v
+	}
+
+	// A change yields a new version.
+	v, val, err := g.CompareAndSet(ctx, "x", "y")
+	if err != nil || val != "y" || v <= ver {
+		t.Errorf("change yielded %v %q %v after %v", v, val, err, ver)
+	}
+}
@@ -0,0 +1,338 @@
+package qscas
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Config is the canonical configuration of a consensus group.
+// A Group stores its configuration in-band, alongside its state,
+// so that every client loads the same configuration from the group itself,
+// and the configuration changes only through consensus via Reconfigure.
+// CompareAndSet operates on the application state alone,
+// preserving the configuration stored with it.
+//
+type Config struct {
+	Epoch   int64    // Configuration epoch, incremented on each change
+	Members []string // Identifiers of the member stores, in group order
+	Faulty  int      // Number of faulty members the group tolerates
+	Tr, Ts  int      // Receive and spread thresholds derived from Faulty
+	Suite   string   // Crypto suite protecting values at rest, or ""
+
+	// Identifiers of observer stores, which mirror the group's state
+	// without counting toward its thresholds: see Observer.
+	Observers []string `json:",omitempty"`
+
+	// Failure domain of each member, in group order, or nil if unknown:
+	// see SetDomains.
+	Domains []string `json:",omitempty"`
+
+	// Nonce of each member's store, in group order, or nil if unknown,
+	// identifying the incarnation of its state: see ErrAmnesia.
+	Nonces []string `json:",omitempty"`
+
+	// Key rotations committed through the group, oldest first,
+	// the last giving the key epoch for new values: see Group.RotateKeys.
+	Keys []KeyRotation `json:",omitempty"`
+}
+
+// SuiteAESGCM is the crypto suite of groups that encrypt the values
+// they store using AES-GCM, with an encoding.Keyring in Group.Keys.
+const SuiteAESGCM = "aes-gcm"
+
+// ErrUnconfigured is returned by Group.Config
+// for a group with no in-band configuration.
+var ErrUnconfigured = errors.New("group has no in-band configuration")
+
+// ErrConfigChanged is returned by Group.Reconfigure
+// when another client changed the group's configuration first.
+// It is in the class cas.ErrConflict.
+var ErrConfigChanged = cas.Classify(cas.ErrConflict,
+	errors.New("group configuration changed concurrently"))
+
+// NewConfig returns an initial, epoch zero configuration for a group
+// of the given members tolerating up to faulty failed members,
+// or the default if faulty < 0, and using the given crypto suite.
+func NewConfig(members []string, faulty int, suite string) (*Config, error) {
+	if faulty < 0 {
+		faulty = len(members) / 3
+	}
+	Tr, Ts, err := Thresholds(len(members), faulty)
+	if err != nil {
+		return nil, err
+	}
+	if suite != "" && suite != SuiteAESGCM {
+		return nil, fmt.Errorf("unknown crypto suite %q", suite)
+	}
+	return &Config{Members: append([]string{}, members...),
+		Faulty: faulty, Tr: Tr, Ts: Ts, Suite: suite}, nil
+}
+
+// Promote returns the configuration succeeding c
+// in which the given observer becomes a full member of the group,
+// after its existing members, with thresholds recomputed for the larger group.
+// Commit the new configuration via Group.Reconfigure,
+// once the observer's store has mirrored the group's latest state.
+//
+func (c *Config) Promote(observer string) (*Config, error) {
+	obs := []string{}
+	for _, o := range c.Observers {
+		if o != observer {
+			obs = append(obs, o)
+		}
+	}
+	if len(obs) == len(c.Observers) {
+		return nil, fmt.Errorf("%s is not an observer", observer)
+	}
+	members := append(append([]string{}, c.Members...), observer)
+	n, err := NewConfig(members, c.Faulty, c.Suite)
+	if err != nil {
+		return nil, err
+	}
+	n.Epoch = c.Epoch + 1
+	if c.Domains != nil {
+		n.Domains = append(append([]string{}, c.Domains...), "")
+	}
+	if c.Nonces != nil {
+		n.Nonces = append(append([]string{}, c.Nonces...), "")
+	}
+	if len(obs) > 0 {
+		n.Observers = obs
+	}
+	return n, nil
+}
+
+// SetDomains records the failure domain of each of c's members,
+// in group order, such as the datacenter, zone, or rack hosting its store,
+// or the empty string for a member whose domain is unknown,
+// which is assumed to be a failure domain of its own.
+// Clients started with these domains in Group.Domains
+// prefer to keep quorums spanning failure domains up to date.
+//
+// A group tolerates the failure of a domain only if the domain
+// hosts no more than Faulty members: see DomainRisks.
+//
+func (c *Config) SetDomains(domains []string) error {
+	if len(domains) != len(c.Members) {
+		return fmt.Errorf("%v failure domains for %v members",
+			len(domains), len(c.Members))
+	}
+	c.Domains = append([]string{}, domains...)
+	return nil
+}
+
+// DomainRisks returns the failure domains, in sorted order,
+// whose failure alone would break the liveness of a group configured by c
+// because they host more than Faulty members,
+// leaving fewer than the receive threshold Tr to make progress.
+// Deployments should spread their members so that DomainRisks is empty.
+//
+func (c *Config) DomainRisks() []string {
+	count := make(map[string]int)
+	for _, d := range c.Domains {
+		if d != "" {
+			count[d]++
+		}
+	}
+	var risks []string
+	for d, n := range count {
+		if n > c.Faulty {
+			risks = append(risks, d)
+		}
+	}
+	sort.Strings(risks)
+	return risks
+}
+
+// Check that a configuration is one that g is able to operate under.
+func (c *Config) check(g *Group) error {
+	if len(c.Members) != len(g.c.KV) || c.Tr != g.c.Tr || c.Ts != g.c.Ts {
+		return fmt.Errorf("configuration epoch %v has %v members "+
+			"with thresholds %v,%v but group has %v with %v,%v",
+			c.Epoch, len(c.Members), c.Tr, c.Ts,
+			len(g.c.KV), g.c.Tr, g.c.Ts)
+	}
+	if (c.Suite == SuiteAESGCM) != (g.Keys != nil) {
+		return fmt.Errorf("configuration epoch %v uses crypto suite %q",
+			c.Epoch, c.Suite)
+	}
+	if c.Domains != nil && len(c.Domains) != len(c.Members) {
+		return fmt.Errorf("configuration epoch %v has %v failure domains "+
+			"for %v members", c.Epoch, len(c.Domains), len(c.Members))
+	}
+	if c.Nonces != nil && len(c.Nonces) != len(c.Members) {
+		return fmt.Errorf("configuration epoch %v has %v nonces "+
+			"for %v members", c.Epoch, len(c.Nonces), len(c.Members))
+	}
+	if e := c.KeyEpoch(); e != 0 && g.Keys != nil {
+		if _, ok := g.Keys.Keys[e]; !ok {
+			return fmt.Errorf("configuration epoch %v uses key epoch %v, "+
+				"for which the group has no key", c.Epoch, e)
+		}
+	}
+	return nil
+}
+
+// A group's state with in-band configuration starts with this prefix,
+// followed by the length of the encoded configuration as a uvarint,
+// the configuration encoded in JSON, and finally the application state.
+const configPrefix = "\x00qsc-config\x00"
+
+// Join a configuration, if any, with an application state.
+func joinState(c *Config, val string) string {
+	if c == nil {
+		return val
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic("error encoding configuration: " + err.Error())
+	}
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(b)))
+	return configPrefix + string(l[:n]) + string(b) + val
+}
+
+// Split a group's state into its configuration, if any,
+// and its application state, ignoring any ordering metadata.
+func splitState(state string) (*Config, string) {
+	_, state = splitCommit(state)
+	if !strings.HasPrefix(state, configPrefix) {
+		return nil, state
+	}
+	rest := state[len(configPrefix):]
+	l, n := binary.Uvarint([]byte(rest))
+	if n <= 0 || uint64(len(rest)-n) < l {
+		return nil, state // not a valid configuration after all
+	}
+	c := &Config{}
+	if err := json.Unmarshal([]byte(rest[n:n+int(l)]), c); err != nil {
+		return nil, state
+	}
+	return c, rest[n+int(l):]
+}
+
+// Record the latest configuration the group has observed.
+func (g *Group) observe(c *Config) {
+	g.confMut.Lock()
+	defer g.confMut.Unlock()
+
+	if c != nil && (g.conf == nil || c.Epoch > g.conf.Epoch) {
+		g.conf = c
+		g.rekey(c)
+	}
+}
+
+// Config loads and returns the group's in-band configuration,
+// waiting for a consensus round so that it reflects any recent changes.
+// Returns ErrUnconfigured if the group has no in-band configuration.
+// Returns an error if the configuration is inconsistent
+// with the member stores, thresholds, or keys the group was started with,
+// in which case the caller should restart the group accordingly.
+//
+func (g *Group) Config(ctx context.Context) (*Config, error) {
+	if _, _, err := g.CompareAndSet(ctx, "", ""); err != nil {
+		return nil, err
+	}
+
+	g.confMut.Lock()
+	c := g.conf
+	g.confMut.Unlock()
+	if c == nil {
+		return nil, ErrUnconfigured
+	}
+	return c, c.check(g)
+}
+
+// Reconfigure changes the group's in-band configuration to c through consensus,
+// provided the group's current configuration epoch is c.Epoch-1,
+// or the group has no configuration yet and c.Epoch is zero.
+// The application state is unaffected.
+// Returns the version at which the new configuration committed,
+// or ErrConfigChanged if another configuration committed instead.
+// Reapplying the group's current configuration succeeds without change.
+//
+// The new configuration takes effect for clients that subsequently
+// load it via Config, and restart their Groups with its members.
+//
+func (g *Group) Reconfigure(ctx context.Context, c *Config) (
+	version int64, err error) {
+
+	began := time.Now()
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	mut := sync.Mutex{}
+	start := int64(-1) // first step at which we were asked to propose
+	fin := false       // set once we've completed or abandoned our work
+	proposed := false  // set once we've proposed c
+
+	pr := func(s int64, cur string, com bool) (prop string, pri int64) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		if fin {
+			return "", 0
+		}
+		if start < 0 {
+			start = s
+		}
+		m, rest := splitCommit(cur)
+		conf, val := splitState(cur)
+		g.observe(conf)
+		epoch := int64(-1)
+		if conf != nil {
+			epoch = conf.Epoch
+		}
+
+		switch {
+
+		// Propose the new configuration if it builds on the current one.
+		case epoch == c.Epoch-1:
+			prop = joinCommit(m.next(g.ID, rest), joinState(c, val))
+			pri = g.proposalPriority(s)
+			proposed = true
+
+		// Otherwise complete as soon as anything commits after we start,
+		// successfully if it was our configuration.
+		case com && s > start:
+			version, fin = s, true
+			if epoch != c.Epoch || !reflect.DeepEqual(conf, c) {
+				err = ErrConfigChanged
+			} else if proposed {
+				g.fairCommitted()
+			}
+
+		// Otherwise make no-op proposals until something commits.
+		default:
+			prop = joinCommit(m.noop(s, com), joinState(conf, val))
+			pri = g.noopPriority()
+		}
+		return
+	}
+	done := func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return fin
+	}
+
+	if err := g.do(ctx, pr, done); err != nil {
+		return 0, err
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	if !fin {
+		fin = true // abandon the operation
+		return 0, g.incomplete(ctx, began, proposed)
+	}
+	return version, err
+}
@@ -0,0 +1,140 @@
+package qscas
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestState(t *testing.T) {
+	c := &Config{Epoch: 3, Members: []string{"a", "b", "c"},
+		Faulty: 1, Tr: 2, Ts: 2}
+	for _, val := range []string{"", "x", "\x00qsc", configPrefix} {
+		conf, v := splitState(joinState(c, val))
+		if conf == nil || conf.Epoch != 3 || v != val {
+			t.Errorf("%q: split into %+v %q", val, conf, v)
+		}
+		if conf, v := splitState(val); conf != nil || v != val {
+			t.Errorf("%q: split unconfigured into %+v %q",
+				val, conf, v)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}, &cas.Register{}}
+	names := []string{"a", "b", "c", "d"}
+	g := (&Group{}).Start(ctx, members, 1)
+	if _, err := g.Config(ctx); err != ErrUnconfigured {
+		t.Fatalf("unconfigured group: %v", err)
+	}
+
+	// Configure the group in-band, then change its application state.
+	c0, err := NewConfig(names, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reconfigure(ctx, c0); err != nil {
+		t.Fatal(err)
+	}
+	for old := ""; old != "x"; {
+		_, val, err := g.CompareAndSet(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+
+	// Another client loads the configuration along with the state.
+	h := (&Group{}).Start(ctx, members, 1)
+	c, err := h.Config(ctx)
+	if err != nil || c.Epoch != 0 || c.Tr != 3 || c.Ts != 2 {
+		t.Fatalf("loaded %+v %v", c, err)
+	}
+	if _, val, _ := h.CompareAndSet(ctx, "", ""); val != "x" {
+		t.Errorf("read %q", val)
+	}
+	_, raw, _ := members[0].CompareAndSet(ctx, "", "")
+	if strings.Contains(raw, "x") == false {
+		t.Errorf("member state lacks application state")
+	}
+
+	// Configuration changes must build on the current epoch,
+	// and must leave the application state alone.
+	// Reapplying the current configuration is harmless.
+	if _, err := h.Reconfigure(ctx, c0); err != nil {
+		t.Errorf("reapplied configuration: %v", err)
+	}
+	c1, _ := NewConfig(names, 0, "")
+	if _, err := h.Reconfigure(ctx, c1); err != ErrConfigChanged {
+		t.Errorf("reconfigured stale epoch: %v", err)
+	}
+	c1.Epoch = 1
+	if _, err := h.Reconfigure(ctx, c1); err != nil {
+		t.Fatal(err)
+	}
+	if _, val, _ := g.CompareAndSet(ctx, "", ""); val != "x" {
+		t.Errorf("read %q after reconfiguration", val)
+	}
+
+	// A client whose thresholds no longer match must detect the drift.
+	if c, err := g.Config(ctx); err == nil || c.Epoch != 1 {
+		t.Errorf("undetected drift: %+v %v", c, err)
+	}
+	k := (&Group{}).Start(ctx, members, 0)
+	if c, err := k.Config(ctx); err != nil || c.Epoch != 1 {
+		t.Errorf("loaded %+v %v", c, err)
+	}
+}
+
+func TestDomains(t *testing.T) {
+	c, err := NewConfig([]string{"a", "b", "c", "d"}, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDomains([]string{"east", "west"}); err == nil {
+		t.Errorf("set too few domains")
+	}
+	if err := c.SetDomains([]string{"east", "west", "north", ""}); err != nil ||
+		len(c.DomainRisks()) != 0 {
+		t.Errorf("spanning domains: %v %v", c.DomainRisks(), err)
+	}
+	c.SetDomains([]string{"west", "east", "east", "west"})
+	if r := c.DomainRisks(); !reflect.DeepEqual(r, []string{"east", "west"}) {
+		t.Errorf("domain risks %v", r)
+	}
+
+	// A promoted observer's domain is unknown.
+	c.Observers = []string{"e"}
+	p, err := c.Promote("e")
+	if err != nil || !reflect.DeepEqual(p.Domains,
+		[]string{"west", "east", "east", "west", ""}) {
+		t.Errorf("promoted to %+v %v", p, err)
+	}
+
+	dn := domainNumbers([]string{"x", "y", "x", "", ""})
+	if !reflect.DeepEqual(dn, []int{0, 1, 0, 3, 4}) {
+		t.Errorf("domain numbers %v", dn)
+	}
+
+	// A group spanning domains operates normally.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}, &cas.Register{}}
+	g := (&Group{Domains: c.Domains}).Start(ctx, members, 1)
+	for old := ""; old != "x"; {
+		_, val, err := g.CompareAndSet(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+}
@@ -0,0 +1,72 @@
+package qscas
+
+import "sync"
+
+// Layout of the proposal priorities a Fair group uses:
+// the capped age of the group's wait in QSC rounds in the top bits,
+// above random bits that break ties among clients of equal age.
+const (
+	fairShift  = 56                    // bits of randomness below the age
+	fairMaxAge = 1<<(63-fairShift) - 1 // age at which a wait stops aging
+	fairGap    = 4 * 4                 // idle steps after which a wait ends
+)
+
+// fairness tracks how long a Fair group has waited
+// for a proposal of its own to commit.
+// A waiting client thus loses only a few rounds
+// per fair competitor that began waiting before it,
+// rather than as many rounds as chance dictates.
+// A client that does not set Fair, proposing with fully random priority,
+// outranks fair clients that have not waited for long.
+type fairness struct {
+	mut     sync.Mutex // protects the fields below
+	waiting bool       // whether the group is waiting
+	since   int64      // step at which the group began waiting
+	last    int64      // step of the group's latest proposal
+}
+
+// Return the priority for a proposal by g at step s.
+// Unless g is Fair, this is simply a random value.
+func (g *Group) proposalPriority(s int64) int64 {
+	if !g.Fair {
+		return randValue()
+	}
+	f := &g.fair
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	// A wait ends if the group stops proposing for a while,
+	// such as when a caller abandons an operation that lost,
+	// so that a later operation does not inherit its age.
+	if !f.waiting || s-f.last > fairGap {
+		f.waiting, f.since = true, s
+	}
+	f.last = s
+	return fairPriority((s-f.since)/4, randValue())
+}
+
+// Return the priority for a no-op proposal by g,
+// which never outranks a waiting client's proposal in a Fair group.
+func (g *Group) noopPriority() int64 {
+	if !g.Fair {
+		return randValue()
+	}
+	return fairPriority(0, randValue())
+}
+
+// Record that a proposal of g's has committed, ending its wait.
+func (g *Group) fairCommitted() {
+	g.fair.mut.Lock()
+	defer g.fair.mut.Unlock()
+
+	g.fair.waiting = false
+}
+
+// Combine a wait's age in rounds with random tie-breaking bits r
+// into a proposal priority.
+func fairPriority(age, r int64) int64 {
+	if age > fairMaxAge {
+		age = fairMaxAge
+	}
+	return age<<fairShift | r&(1<<fairShift-1)
+}
@@ -0,0 +1,91 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestFairPriority(t *testing.T) {
+	max := int64(1<<63 - 1)
+
+	// An older wait outranks a younger one regardless of random bits,
+	// until both reach the maximum age.
+	if fairPriority(1, 0) <= fairPriority(0, max) {
+		t.Errorf("age 1 does not outrank age 0")
+	}
+	if fairPriority(fairMaxAge+5, max) != fairPriority(fairMaxAge, max) {
+		t.Errorf("age not capped")
+	}
+	if fairPriority(fairMaxAge, max) != max {
+		t.Errorf("maximum priority %x", fairPriority(fairMaxAge, max))
+	}
+
+	// A group's proposals age by round while it waits,
+	// and start over once one commits or the group goes idle.
+	g := &Group{Fair: true}
+	ages := []struct {
+		step, age int64
+		commit    bool
+	}{
+		{100, 0, false}, {104, 1, false}, {112, 3, true},
+		{116, 0, false}, {120, 1, false}, {200, 0, false},
+	}
+	for _, a := range ages {
+		if age := g.proposalPriority(a.step) >> fairShift; age != a.age {
+			t.Errorf("step %v: age %v, want %v", a.step, age, a.age)
+		}
+		if a.commit {
+			g.fairCommitted()
+		}
+	}
+	if age := g.noopPriority() >> fairShift; age != 0 {
+		t.Errorf("no-op age %v", age)
+	}
+}
+
+// Test that competing Fair clients each make progress,
+// none losing too many consecutive operations.
+func TestFair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const nclients, nwins, maxLosses = 4, 5, 30
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}, &cas.Register{}}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < nclients; i++ {
+		i := i
+		g := (&Group{Fair: true}).Start(ctx, members, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			old, losses := "", 0
+			for wins := 0; wins < nwins; {
+				new := fmt.Sprintf("%v%v", old, i)
+				_, actual, err := g.CompareAndSet(ctx, old, new)
+				for errors.Is(err, ErrUnknownOutcome) {
+					_, actual, err = g.CompareAndSet(ctx, old, old)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if actual == new {
+					wins, losses = wins+1, 0
+				} else if losses++; losses > maxLosses {
+					t.Errorf("client %v lost %v times in a row",
+						i, losses)
+					return
+				}
+				old = actual
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// ErrStaleEpoch is returned by the operations of a Group fenced to
+// a configuration epoch, via Group.Epoch, once the Group has read
+// a member value written by a client of a later configuration epoch.
+// The Group stops operating at that point,
+// since its membership and thresholds may no longer be those of the group,
+// and clients following a stale configuration could otherwise split the group
+// into independently committing halves.
+//
+// Each client of a fenced Group tags every value it writes to a member store
+// with its epoch, and the member stores' compare-and-set semantics
+// ensure that no client overwrites a value it has not read first.
+// Once any client writes under a new epoch, therefore,
+// clients of earlier epochs stop on their next access to that member,
+// and can commit nothing further, although an operation that fails
+// based on state the client already read may still complete.
+// Clients of an unfenced Group, with Epoch zero,
+// never stop, but preserve the latest epoch tag they have read.
+//
+// A client that discovers it is stale recovers by
+// starting an unfenced Group on the same member stores,
+// loading the latest configuration from it via Config,
+// and then starting a new Group on that configuration's members
+// with Epoch set to the configuration's epoch.
+// ErrStaleEpoch is in the class cas.ErrStale.
+//
+var ErrStaleEpoch = cas.Classify(cas.ErrStale,
+	errors.New("group configuration epoch is stale"))
+
+// fence tracks the configuration epochs a Group observes in member values.
+type fence struct {
+	mut    sync.Mutex         // protects the fields below
+	fixed  int64              // epoch set in Group.Epoch, or 0 if unfenced
+	latest int64              // latest epoch observed in any member value
+	failed error              // why the group stopped, or nil
+	cancel context.CancelFunc // stops the group once it is stale
+}
+
+// Initialize the fencing state for a group fenced to epoch,
+// returning a context derived from ctx that is cancelled if it goes stale.
+func (f *fence) init(ctx context.Context, epoch int64) context.Context {
+	f.fixed, f.latest = epoch, epoch
+	ctx, f.cancel = context.WithCancel(ctx)
+	return ctx
+}
+
+// Return the configuration epoch to tag member values with.
+func (f *fence) epoch() int64 {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.latest
+}
+
+// Check the configuration epoch of a member value the group read,
+// stopping the group and returning ErrStaleEpoch if it is stale.
+func (f *fence) check(val string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	e, _ := encoding.SplitEpoch([]byte(val))
+	switch {
+	case f.failed != nil:
+		return f.failed
+	case e <= f.latest:
+		return nil
+	case f.fixed == 0:
+		f.latest = e
+		return nil
+	}
+	return f.fail(ErrStaleEpoch)
+}
+
+// Stop the group because of err, unless it already stopped,
+// returning the error that stopped it.
+// The caller must hold f.mut.
+func (f *fence) fail(err error) error {
+	if f.failed == nil {
+		f.failed = err
+		f.cancel()
+	}
+	return f.failed
+}
+
+// Return the error explaining why a group stopped operating.
+func (g *Group) err() error {
+	g.fence.mut.Lock()
+	failed := g.fence.failed
+	g.fence.mut.Unlock()
+
+	if failed != nil {
+		return failed
+	}
+	return g.ctx.Err()
+}
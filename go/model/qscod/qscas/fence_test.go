@@ -0,0 +1,57 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// Test that clients of an earlier configuration epoch stop
+// once a client of a later epoch writes to the member stores.
+func TestStaleEpoch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	set := func(g *Group, old, new string) (string, error) {
+		for {
+			_, val, err := g.CompareAndSet(ctx, old, new)
+			if err != nil || val == new {
+				return val, err
+			}
+			old = val
+		}
+	}
+
+	a := (&Group{Epoch: 1}).Start(ctx, members, 1)
+	if _, err := set(a, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	b := (&Group{Epoch: 2}).Start(ctx, members, 1)
+	if _, err := set(b, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set(a, "b", "c"); err != ErrStaleEpoch ||
+		!errors.Is(err, cas.ErrStale) {
+		t.Fatalf("stale client yielded %v", err)
+	}
+	if _, _, err := a.CompareAndSet(ctx, "", ""); err != ErrStaleEpoch {
+		t.Errorf("stopped client yielded %v", err)
+	}
+
+	// An unfenced client operates normally, preserving the latest epoch.
+	u := (&Group{}).Start(ctx, members, 1)
+	if _, err := set(u, "b", "u"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members {
+		_, val, _ := m.CompareAndSet(ctx, "", "")
+		if e, _ := encoding.SplitEpoch([]byte(val)); e != 2 {
+			t.Errorf("member value has epoch %v", e)
+		}
+	}
+}
@@ -2,25 +2,174 @@ package qscas
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/dedis/tlc/go/lib/backoff"
 	"github.com/dedis/tlc/go/lib/cas"
 	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
 )
 
+// ErrMemberCount is the error that the operations of a Group return
+// if it was started with Domains or Names
+// not having one entry per member store.
+var ErrMemberCount = errors.New("wrong number of member entries")
+
 // Group implements the cas.Store interface as a QSCOD consensus group.
-// After creation, invoke Start to configure the consensus group state,
+// After creation, set any optional configuration fields,
+// invoke Start to configure the consensus group state,
 // then call CompareAndSet to perform CAS operations on the logical state.
+// The configuration fields must not change once the Group has started.
+//
+// The Group makes no-op proposals, repeating the group's current state,
+// to complete consensus rounds when it has nothing else to propose.
+// Versions reported to callers normally advance only when the state changes:
+// a commit that merely repeats the prior committed state
+// reports the version of the commit that first introduced the state.
+//
+// Besides the opaque string state of CompareAndSet,
+// a Group offers structured state via Get and Update,
+// in-band configuration via Config, session consistency via Token,
+// and commit ordering metadata via Commit.
+// It retries failing member stores indefinitely, quarantining any
+// that have lost their state: see ErrAmnesia.
+//
 type Group struct {
-	c   core.Client     // consensus client core
-	ctx context.Context // group operation context
+
+	// Keys optionally enables encryption of all consensus values
+	// before they are written to the underlying member stores,
+	// so that semi-trusted storage (e.g., NFS or cloud storage)
+	// sees only ciphertext. All clients of a group must use the same keys.
+	// The Group encrypts under the key epoch committed
+	// in its in-band Config, if any, rather than Keys.Epoch:
+	// see RotateKeys.
+	Keys *encoding.Keyring
+
+	// MaxConcurrent and MaxRate optionally limit the CAS operations
+	// the Group admits at once and per second, respectively,
+	// protecting the consensus core and member stores
+	// from a thundering herd of application threads.
+	// Callers beyond the limits wait their turn in first-come,
+	// first-served order, for as long as their contexts allow.
+	MaxConcurrent int
+	MaxRate       float64
+
+	// Aging sets how long an operation waits in the priority class
+	// it was tagged with via WithPriority before it ages into the next,
+	// or 0 for a default.
+	Aging time.Duration
+
+	// Codec encodes the structured values of Get and Update,
+	// or is nil for JSON, and Schema tags them with a schema version.
+	// Clients should increment Schema whenever they change
+	// the structure of the values they write,
+	// so that older clients refuse to misinterpret newer values.
+	// See also Registers.
+	Codec  Codec
+	Schema int
+
+	// ID optionally identifies this client in the ordering metadata
+	// the group commits with each state change: see Commit.
+	ID string
+
+	// Backoff configures how the Group retries failed accesses
+	// to member stores, with exponential backoff tracked per member.
+	// If Backoff's Report function returns an error, however,
+	// for instance for an error that cas.Fatal classifies as fatal,
+	// the Group abandons that member as failed until the Group is stopped.
+	Backoff backoff.Config
+
+	// Domains optionally assigns each member store to a failure domain,
+	// such as a datacenter, zone, or rack, as recorded in the group's Config.
+	// The Group then prefers to keep member stores spanning domains
+	// up to date, rather than just the fastest, so that it continues
+	// promptly if any one domain fails: see core.Latency.
+	Domains []string
+
+	// Timeout optionally limits how long each operation may take,
+	// in addition to any deadline on the context the caller passes.
+	// An operation that does not complete in time
+	// returns an IncompleteError describing the group's situation.
+	Timeout time.Duration
+
+	// MaxValueSize optionally limits the size in bytes
+	// of the application values the Group accepts,
+	// so that CompareAndSet returns a cas.SizeError promptly
+	// rather than proposing a value that its member stores cannot hold.
+	// Each consensus value written to a member store may carry
+	// the proposals of every member twice over, with metadata and encoding,
+	// so any size limit the member stores enforce must exceed
+	// 2N+1 times MaxValueSize, with some room to spare.
+	MaxValueSize int
+
+	// NoOps makes each commit report its own TLC step as its version,
+	// so that even no-op commits appear as new versions.
+	NoOps bool
+
+	// Epoch optionally fences the Group to a configuration epoch,
+	// guarding against split brain after reconfiguration:
+	// see ErrStaleEpoch and ConfigMismatchError.
+	Epoch int64
+
+	// Names optionally identifies each member store, such as by its path,
+	// so that a fenced Group also detects clients started
+	// with different members. All clients of a group must name
+	// its members identically, or not at all.
+	Names []string
+
+	// Exclude optionally excludes member stores that fail persistently:
+	// once every access to a member has failed for the Exclude period,
+	// the Group ranks it slower than every other member in scheduling,
+	// and probes it only once per Exclude period
+	// rather than as often as Backoff permits,
+	// readmitting it as soon as an access succeeds.
+	// The Group excludes at most N-Tr members at once,
+	// and exclusion never affects its consensus thresholds.
+	// See Excluded.
+	Exclude time.Duration
+
+	// Fair bounds how many rounds the Group's proposals can lose
+	// to those of other clients contending for the same member stores.
+	// Normally each round commits the proposal
+	// with the highest random priority,
+	// so an unlucky client's operations may lose round after round.
+	// A fair Group instead ranks its proposals by how many rounds
+	// it has been waiting for one of them to commit, breaking ties randomly,
+	// so that they outrank those of every competitor
+	// that began waiting after it.
+	// Fairness holds only among clients that all set Fair,
+	// and provided their proposals reach the member stores
+	// as promptly as their competitors' do.
+	Fair bool
+
+	c       core.Client        // consensus client core
+	ctx     context.Context    // group operation context
+	cancel  context.CancelFunc // shuts the group down
+	live    tracker            // goroutines the group started
+	members []cas.Store        // underlying member stores
+	hash    uint64             // hash of the group's configuration
+	proof   Proof              // evidence of the latest commit observed
+	recent  recentStates       // states recently observed in rounds
+	admit   admission          // admission control for CAS operations
+	fence   fence              // configuration epoch fencing state
+	health  health             // failing and excluded members
+	fair    fairness           // how long the group has waited to commit
+
+	confMut sync.Mutex        // protects conf and seal
+	conf    *Config           // latest in-band configuration observed
+	seal    *encoding.Keyring // keys under the committed key epoch, if any
+
+	stat groupStatus // state reported on the group's status page
 
 	mut  sync.Mutex     // for synchronizing shutdown
 	wg   sync.WaitGroup // counts active CAS operations
 	done bool           // set after group shutdown
 
-	// channel that CAS calls use to propose work to do
-	ch chan func(int64, string, bool) (string, int64)
+	// channels that CAS calls use to propose work to do, by priority
+	ch [numPriorities]chan func(int64, string, bool) (string, int64)
 }
 
 // Start initializes g to represent a consensus group comprised of
@@ -35,62 +184,102 @@ type Group struct {
 // Start launchers worker goroutines that help service CAS requests,
 // which will run and consume resources forever unless cancelled.
 // To define their lifetime, the caller should pass a cancelable context,
-// and cancel it when operations on the Group are no longer required.
+// and cancel it when operations on the Group are no longer required,
+// or else call Close.
+//
+// If Domains or Names does not have one entry per member,
+// the Group starts stopped, and all its operations return
+// an error wrapping ErrMemberCount.
 //
 func (g *Group) Start(ctx context.Context, members []cas.Store, faulty int) *Group {
 
 	// Calculate and sanity-check the threshold configuration parameters.
-	// For details on where these calculations come from, see:
-	// https://arxiv.org/abs/2003.02291
 	N := len(members)
-	if faulty < 0 {
-		faulty = N / 3		// Default fault tolerance threshold
-	}
-	Tr := N - faulty // receive threshold
-	Ts := N - Tr + 1 // spread threshold
-	if Tr <= 0 || Tr > N || Ts <= 0 || Ts > Tr || (Ts+Tr) <= N {
-		panic("faulty threshold yields unsafe configuration")
-	}
-	if N*(Tr-Ts+1)-Tr*(N-Tr) <= 0 { // test if Tb <= 0
-		panic("faulty threshold yields non-live configuration")
+	Tr, Ts, err := Thresholds(N, faulty)
+	if err != nil {
+		panic(err.Error())
 	}
 	//println("N", N, "Tr", Tr, "Ts", Ts)
 
-	// Create a consensus group state instance
-	g.c = core.Client{Tr: Tr, Ts: Ts}
+	// Create a consensus group state instance,
+	// which keeps any distant member stores caught up in the background.
+	lat := &core.Latency{}
+	var bad error
+	switch {
+	case g.Domains != nil && len(g.Domains) != N:
+		bad = fmt.Errorf("%w: %d Domains for %d members",
+			ErrMemberCount, len(g.Domains), N)
+	case g.Names != nil && len(g.Names) != N:
+		bad = fmt.Errorf("%w: %d Names for %d members",
+			ErrMemberCount, len(g.Names), N)
+	case g.Domains != nil:
+		lat.Domain = domainNumbers(g.Domains)
+	}
+	g.hash = configHash(N, Tr, Ts, g.Names)
+	g.live.init(N)
+	g.c = core.Client{Tr: Tr, Ts: Ts, Lat: lat, Go: g.live.goroutine}
+	ctx, g.cancel = context.WithCancel(ctx)
+	ctx = g.fence.init(ctx, g.Epoch)
+	if bad != nil {
+		g.fence.mut.Lock()
+		g.fence.fail(bad)
+		g.fence.mut.Unlock()
+	}
 	g.ctx = ctx
-	g.ch = make(chan func(s int64, p string, c bool) (string, int64))
+	g.members = members
+	g.admit.init(g.MaxConcurrent, g.MaxRate)
+	for i := range g.ch {
+		g.ch[i] = make(chan func(int64, string, bool) (string, int64))
+	}
 
 	// Create a core.Store wrapper around each cas.Store group member
 	g.c.KV = make([]core.Store, N)
 	for i := range members {
-		g.c.KV[i] = &coreStore{Store: members[i], g: g}
+		g.c.KV[i] = &coreStore{Store: members[i], g: g, i: i,
+			bo: backoff.Backoff{Config: g.Backoff}}
+	}
+	g.stat.members = make([]memberStatus, N)
+	g.health.init(N)
+
+	// Record the evidence of each commit the consensus core observes,
+	// for the proposal function it calls next to report.
+	g.c.Ev = func(ver, step int64, nodes []int) {
+		g.proof = Proof{Version: ver, Step: step, Members: nodes}
 	}
 
 	// Our proposal function normally just "punts" by waiting for
-	// an actual proposal to get sent on the group's channel,
+	// an actual proposal to get sent on one of the group's channels,
+	// highest priority first,
 	// and then we call that to form the proposal as appropriate.
 	// But we concurrently listen for channel cancellation
 	// and return promptly with a no-op proposal in that case.
 	g.c.Pr = func(s int64, p string, c bool) (prop string, pri int64) {
+		if c {
+			g.stat.commit(s, p)
+		}
+		// A round's outcome has a known version only once committed,
+		// or if a no-op repeating it records the step it committed at.
+		m, rest := splitCommit(p)
+		v := int64(0)
+		switch {
+		case c:
+			v = g.version(s, m)
+		case !g.NoOps:
+			v = m.step
+		}
+		g.recent.observe(s, m, rest, v)
 		for {
-			select {
-			case f := <-g.ch: // got a CAS work function to call
-				if f == nil { // context cancelled
-					println("Pr: channel closed")
-					return p, 0 // no-op proposal
-				}
-				//println("got work function\n")
-				prop, pri = f(s, p, c) // call work function
-				if prop != "" || pri != 0 {
-					return prop, pri // return its result
-				}
-				//println("work function yielded no work")
-
-			case <-ctx.Done(): // our context got cancelled
+			f := g.next(ctx)
+			if f == nil { // context cancelled or channel closed
 				//println("Pr: cancelled")
 				return p, 0 // produce no-op proposal
 			}
+			//println("got work function\n")
+			prop, pri = f(s, p, c) // call work function
+			if prop != "" || pri != 0 {
+				return prop, pri // return its result
+			}
+			//println("work function yielded no work")
 		}
 	}
 
@@ -98,23 +287,66 @@ func (g *Group) Start(ctx context.Context, members []cas.Store, faulty int) *Gro
 	// Make sure the group's WaitGroup remains nonzero until
 	// the context is cancelled and we're ready to shut down.
 	g.wg.Add(1)
-	go g.run(ctx)
+	g.live.goroutine(func() { g.run(ctx) })
 
 	return g
 }
 
+// Thresholds calculates the receive and spread thresholds Tr and Ts
+// for a QSCOD consensus group of N members tolerating faulty failed members,
+// returning an error if the resulting configuration is unsafe or non-live.
+// If faulty < 0, it is set to one-third of the group size, rounded down.
+//
+func Thresholds(N, faulty int) (Tr, Ts int, err error) {
+
+	// For details on where these calculations come from, see:
+	// https://arxiv.org/abs/2003.02291
+	if faulty < 0 {
+		faulty = N / 3 // Default fault tolerance threshold
+	}
+	Tr = N - faulty // receive threshold
+	Ts = N - Tr + 1 // spread threshold
+	if Tr <= 0 || Tr > N || Ts <= 0 || Ts > Tr || (Ts+Tr) <= N {
+		return 0, 0, errors.New(
+			"faulty threshold yields unsafe configuration")
+	}
+	if N*(Tr-Ts+1)-Tr*(N-Tr) <= 0 { // test if Tb <= 0
+		return 0, 0, errors.New(
+			"faulty threshold yields non-live configuration")
+	}
+	return Tr, Ts, nil
+}
+
+// Number failure domains by the index of their first member,
+// assigning each member of unknown domain a domain of its own.
+func domainNumbers(domains []string) []int {
+	num := make(map[string]int)
+	dn := make([]int, len(domains))
+	for i, d := range domains {
+		n, ok := num[d]
+		if !ok || d == "" {
+			n, num[d] = i, i
+		}
+		dn[i] = n
+	}
+	return dn
+}
+
 // Run consensus in a goroutine
 func (g *Group) run(ctx context.Context) {
 
 	// Run the consensus protocol until our context gets cancelled
 	g.c.Run(ctx)
 
-	// Drain any remaining proposal function sends to the group's channel.
+	// Drain any remaining proposal function sends to the group's channels.
 	// CompareAndSet won't add anymore after g.ctx has been cancelled.
-	go func() {
-		for range g.ch {
-		}
-	}()
+	for _, ch := range g.ch {
+		ch := ch
+		g.live.goroutine(func() {
+			for range ch {
+			}
+		})
+	}
 
 	g.mut.Lock()
 
@@ -122,8 +354,10 @@ func (g *Group) run(ctx context.Context) {
 	g.wg.Done()
 	g.wg.Wait()
 
-	// Now it's safe to close the group's channel.
-	close(g.ch)
+	// Now it's safe to close the group's channels.
+	for _, ch := range g.ch {
+		close(ch)
+	}
 	g.done = true
 
 	g.mut.Unlock()
@@ -132,31 +366,53 @@ func (g *Group) run(ctx context.Context) {
 // CompareAndSet conditionally writes a new version and reads the latest,
 // implementing the cas.Store interface.
 //
+// If new equals old, CompareAndSet just reads the latest state,
+// waiting for at least one consensus round so that the state it returns
+// reflects commits by other clients since the Group last ran.
+//
+// Concurrent operations on a Group take turns proposing
+// in the order they arrived, within each priority class: see WithPriority.
+// Across clients, each round commits one client's proposal: see Group.Fair.
+//
 func (g *Group) CompareAndSet(ctx context.Context, old, new string) (
 	version int64, actual string, err error) {
 
-	//println("CAS lastVer", lastVer, "reqVal", reqVal)
+	version, actual, _, err = g.CompareAndSetProof(ctx, old, new)
+	return version, actual, err
+}
 
-	// Record active CompareAndSet calls in a WaitGroup
-	// so that the group's main goroutine can wait for them to complete
-	// when shutting down gracefully in response to context cancellation.
-	// Atomically check that the group is still active before wg.Add.
-	g.mut.Lock()
-	if g.done {
-		//println("CAS after done")
-		// This should only ever happen once the context is cancelled
-		if g.ctx.Err() == nil {
-			panic("group done but context not cancelled?")
+// CompareAndSetProof performs a CompareAndSet operation,
+// additionally returning a Proof of the commit that completed it.
+//
+func (g *Group) CompareAndSetProof(ctx context.Context, old, new string) (
+	version int64, actual string, proof Proof, err error) {
+
+	version, actual, proof, _, err = g.compareAndSet(ctx, old, new)
+	return version, actual, proof, err
+}
+
+// Perform a CompareAndSet operation, returning both a Proof
+// and the ordering metadata of the commit that completed it.
+func (g *Group) compareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, proof Proof, meta commitMeta, err error) {
+
+	if old != new {
+		if err := cas.CheckSize(new, g.MaxValueSize); err != nil {
+			return 0, "", Proof{}, commitMeta{}, err
 		}
-		g.mut.Unlock()
-		return 0, "", g.ctx.Err()
 	}
-	g.wg.Add(1)
-	g.mut.Unlock()
-	defer g.wg.Done()
+
+	began := time.Now()
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
 
 	// We'll need a mutex to protect concurrent accesses to our locals.
 	mut := sync.Mutex{}
+	start := int64(-1)  // first step at which we were asked to propose
+	fin := false        // set once we've completed or abandoned our work
+	proposed := false   // set once we've proposed new
+	var mine commitMeta // metadata of our latest proposal
+	var ours uint64     // digest of our latest proposal
 
 	// Define the proposal formulation function that will do our work.
 	// Returns the empty string to keep this worker thread waiting
@@ -167,25 +423,77 @@ func (g *Group) CompareAndSet(ctx context.Context, old, new string) (
 
 		//println("CAS step", s, cur, com, "prop", old, "->", new)
 
+		// Leave the consensus workers to others once we're done.
+		if fin {
+			return "", 0
+		}
+
+		// Remember the step at which we started.
+		if start < 0 {
+			start = s
+		}
+
+		// Operate on the application state,
+		// preserving any in-band configuration alongside it,
+		// and ordering each change after the state it replaces.
+		m, rest := splitCommit(cur)
+		conf, cur := splitState(cur)
+		g.observe(conf)
+
 		// Now check the situation of what's known to be committed.
 		switch {
 
+		// A read-only CAS with new equal to old completes
+		// as soon as anything is committed after we started.
+		// A commit already known at our starting step may be stale,
+		// since the consensus workers may have been idle
+		// while other clients committed newer values.
+		case old == new && com && s > start:
+			version, actual, fin = g.version(s, m), cur, true
+			proof, meta = g.proof, m
+
 		// It's safe to propose new as the new string to commit
 		// if the prior value we're building on is equal to old.
-		case cur == old:
-			prop, pri = new, randValue()
+		case cur == old && old != new:
+			mine = m.next(g.ID, rest)
+			prop = joinCommit(mine, joinState(conf, new))
+			pri = g.proposalPriority(s)
+			proposed, ours = true, digest(mine, joinState(conf, new))
 
 		// Complete the CAS operation as soon as we commit anything,
 		// whether it was our new proposal or some other string.
-		case com:
-			version, actual = int64(s), cur
+		// If the commit is of a later state change than our proposal,
+		// we missed the commit of the state at our proposal's index,
+		// so find out from the committed state's history
+		// whether it was ours.
+		case com && old != new:
+			version, actual, fin = g.version(s, m), cur, true
+			proof, meta = g.proof, m
+			if proposed && m.index > mine.index {
+				d, ok := g.recent.ancestor(m, rest, mine.index)
+				rs := g.recent.lookup(ours)
+				switch {
+				case ok && d != ours:
+					// some other state replaced old: we failed
+				case ok && rs != nil:
+					version, actual = rs.committed(), new
+					proof.Version, meta = version, mine
+				default:
+					version, actual = 0, ""
+					proof, meta = Proof{}, commitMeta{}
+					err = ErrUnknownOutcome
+				}
+			}
+			if proposed && actual == new {
+				g.fairCommitted()
+			}
 
 		// Otherwise, if the current proposal isn't the same as old
 		// but also isn't committed, we have to make no-op proposals
 		// until we manage to get something committed.
 		default:
-			println("no-op proposal")
-			prop, pri = cur, randValue()
+			prop = joinCommit(m.noop(s, com), joinState(conf, cur))
+			pri = g.noopPriority()
 
 			//case int64(s) > lastVer && c && p != prop:
 			//	err = cas.Changed
@@ -240,19 +548,68 @@ func (g *Group) CompareAndSet(ctx context.Context, old, new string) (
 	done := func() bool {
 		mut.Lock()
 		defer mut.Unlock()
-		return actual != "" || err != nil
+		return fin || err != nil
 	}
 
+	if err := g.do(ctx, pr, done); err != nil {
+		return 0, "", Proof{}, commitMeta{}, err
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	if !fin {
+		fin = true // abandon the operation
+		return 0, "", Proof{}, commitMeta{}, g.incomplete(ctx, began, proposed)
+	}
+	return version, actual, proof, meta, err
+}
+
+// Repeatedly offer a proposal function to the consensus workers until done,
+// or until ctx is cancelled, in which case the caller must check done.
+// Returns an error only if the group shuts down first.
+func (g *Group) do(ctx context.Context,
+	pr func(int64, string, bool) (string, int64), done func() bool) error {
+
+	// Wait our turn if the group limits its operations.
+	if g.admit.limited() {
+		if err := g.admit.admit(ctx, g.ctx); err != nil {
+			return g.err()
+		}
+		defer g.admit.release()
+	}
+
+	// Record active operations in a WaitGroup
+	// so that the group's main goroutine can wait for them to complete
+	// when shutting down gracefully in response to context cancellation.
+	// Atomically check that the group is still active before wg.Add.
+	g.mut.Lock()
+	if g.done {
+		//println("CAS after done")
+		// This should only ever happen once the context is cancelled
+		if g.ctx.Err() == nil {
+			panic("group done but context not cancelled?")
+		}
+		g.mut.Unlock()
+		return g.err()
+	}
+	g.wg.Add(1)
+	g.mut.Unlock()
+	defer g.wg.Done()
+
 	// Continuously send references to our proposal function
-	// to the group's channel so it will get called until it finishes
+	// to the group's channel for our priority class,
+	// so it will get called until it finishes
 	// or until one of the contexts (ours or the group's) is cancelled.
-	// Since the channel is unbuffered, each send will block
+	// Since the channels are unbuffered, each send will block
 	// until some consensus worker thread is ready to receive it.
+	p := priorityOf(ctx)
 	for !done() && ctx.Err() == nil && g.ctx.Err() == nil {
 		//println("CAS sending", old, "->", new)
-		g.ch <- pr
+		p = g.send(ctx, p, pr)
 	}
 	//	println("CAS done", lastVer, "reqVal", reqVal,
 	//		"actualVer", actualVer, "actualVal", actualVal, "err", err)
-	return version, actual, err
+	if !done() && ctx.Err() == nil {
+		return g.err()
+	}
+	return nil
 }
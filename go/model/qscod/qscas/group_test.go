@@ -1,51 +1,47 @@
 package qscas
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dedis/tlc/go/lib/backoff"
 	"github.com/dedis/tlc/go/lib/cas"
 	"github.com/dedis/tlc/go/lib/cas/test"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
 )
 
+// Start a Group client on the specified members.
+func start(ctx context.Context, members []cas.Store, faulty int) cas.Store {
+	return (&Group{}).Start(ctx, members, faulty)
+}
+
 //  Run a consensus test case with the specified parameters.
 func testRun(t *testing.T, nfail, nnode, nclients, nthreads, naccesses int) {
 
-	desc := fmt.Sprintf("F=%v,N=%v,Clients=%v,Threads=%v,Accesses=%v",
-		nfail, nnode, nclients, nthreads, naccesses)
-	t.Run(desc, func(t *testing.T) {
-
-		// Create a cancelable context for the test run
-		ctx, cancel := context.WithCancel(context.Background())
-
-		// Create an in-memory CAS register representing each node
-		members := make([]cas.Store, nnode)
+	// Create an in-memory CAS register representing each node,
+	// and interpose checking wrappers on each client's accesses.
+	members := func(t *testing.T, nnode int) func() []cas.Store {
+		regs := test.Registers(t, nnode)()
 		memhist := make([]test.History, nnode)
-		for i := range members {
-			members[i] = &cas.Register{}
-		}
-
-		// Create a consensus group Store for each simulated client
-		clients := make([]cas.Store, nclients)
-		for i := range clients {
-
-			// Interpose checking wrappers on the CAS registers
+		return func() []cas.Store {
 			checkers := make([]cas.Store, nnode)
 			for i := range checkers {
 				checkers[i] = test.Checked(t, &memhist[i],
-					members[i])
+					regs[i])
 			}
-
-			clients[i] = (&Group{}).Start(ctx, checkers, nfail)
+			return checkers
 		}
+	}
 
-		// Run a standard torture-test across all the clients
-		test.Stores(t, nthreads, naccesses, clients...)
-
-		// Shut down all the clients by canceling the context
-		cancel()
-	})
+	test.Groups(t, members, start, test.GroupConfig{
+		Faulty: nfail, Nodes: nnode, Clients: nclients,
+		Threads: nthreads, Accesses: naccesses})
 }
 
 // Test the Client with a trivial in-memory key/value Store implementation.
@@ -66,3 +62,190 @@ func TestClient(t *testing.T) {
 	testRun(t, 1, 3, 10, 10, 1000) // Extreme low-entropy: rarely commits
 	testRun(t, 1, 3, 10, 10, 1000) // A bit better bit still bad...
 }
+
+// Test a Group that encrypts the values it writes to its member stores.
+func TestEncrypted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kr := &encoding.Keyring{Keys: map[uint32][]byte{
+		0: bytes.Repeat([]byte{42}, 32),
+	}}
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	clients := make([]cas.Store, 3)
+	for i := range clients {
+		clients[i] = (&Group{Keys: kr}).Start(ctx, members, 1)
+	}
+	test.Stores(t, 1, 100, clients...)
+
+	// The member stores must hold only ciphertext.
+	for _, m := range members {
+		_, val, _ := m.CompareAndSet(ctx, "", "")
+		if strings.Contains(val, "access") {
+			t.Errorf("member store contains plaintext")
+		}
+	}
+}
+
+// Test that a read-only CompareAndSet sees other clients' commits.
+func TestRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	a := (&Group{}).Start(ctx, members, 1)
+	b := (&Group{}).Start(ctx, members, 1)
+
+	// Each client reads the initial state, then a writes a new one.
+	if _, val, err := a.CompareAndSet(ctx, "", ""); err != nil || val != "" {
+		t.Fatalf("read %q %v", val, err)
+	}
+	if _, val, err := b.CompareAndSet(ctx, "", ""); err != nil || val != "" {
+		t.Fatalf("read %q %v", val, err)
+	}
+	for old := ""; old != "x"; {
+		_, val, err := a.CompareAndSet(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+
+	// The other client's next read must see the new state.
+	if _, val, err := b.CompareAndSet(ctx, "", ""); err != nil || val != "x" {
+		t.Errorf("stale read %q %v", val, err)
+	}
+}
+
+// Test that a Group's commit proofs are consistent and verifiable.
+func TestProof(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	g := (&Group{}).Start(ctx, members, 1)
+
+	ver, val, p, err := g.CompareAndSetProof(ctx, "", "x")
+	if err != nil || val != "x" {
+		t.Fatalf("set %q %v", val, err)
+	}
+	if p.Version != ver || p.Step < ver || len(p.Members) != 2 {
+		t.Fatalf("version %v: bad proof %+v", ver, p)
+	}
+	conf, err := g.Verify(ctx, p)
+	if err != nil || len(conf) != 2 {
+		t.Errorf("verify %v %v", conf, err)
+	}
+
+	// A proof of a commit beyond the members' state must not verify.
+	p.Step += 100
+	if _, err := g.Verify(ctx, p); err == nil {
+		t.Errorf("verified a proof from the future")
+	}
+}
+
+// Test that the Group reports member failures via its backoff policy.
+func TestBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mut sync.Mutex
+	reports := 0
+	report := func(err error) error {
+		mut.Lock()
+		defer mut.Unlock()
+		reports++
+		return nil
+	}
+	g := &Group{Backoff: backoff.Config{Report: report,
+		MaxWait: time.Millisecond}}
+	g.Start(ctx, []cas.Store{&cas.Register{}, &cas.Register{},
+		failedStore{}}, 1)
+	for old, i := "", 0; i < 10; i++ {
+		_, val, err := g.CompareAndSet(ctx, old, fmt.Sprintf("v%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	mut.Lock()
+	defer mut.Unlock()
+	if reports == 0 {
+		t.Errorf("failed member's errors were never reported")
+	}
+}
+
+// A member store whose state is corrupt.
+type corruptStore struct{}
+
+func (corruptStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+	return 0, "", cas.Classify(cas.ErrCorrupt, errors.New("store corrupt"))
+}
+
+// Test that a Group abandons a member whose errors its backoff policy
+// reports as fatal, and continues with the others.
+func TestAbandon(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mut sync.Mutex
+	fatal := 0
+	report := func(err error) error {
+		if !cas.Fatal(err) {
+			return nil
+		}
+		mut.Lock()
+		defer mut.Unlock()
+		fatal++
+		return err
+	}
+	g := &Group{Backoff: backoff.Config{Report: report}}
+	g.Start(ctx, []cas.Store{&cas.Register{}, &cas.Register{},
+		corruptStore{}}, 1)
+	for old, i := "", 0; i < 10; i++ {
+		_, val, err := g.CompareAndSet(ctx, old, fmt.Sprintf("v%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if fatal != 1 {
+		t.Errorf("corrupt member reported fatal %d times", fatal)
+	}
+}
+
+// Test that a Group and its member stores refuse oversized values.
+func TestMaxValueSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{MaxValueSize: 1000},
+		&cas.Register{MaxValueSize: 1000},
+		&cas.Register{MaxValueSize: 1000}}
+	g := (&Group{MaxValueSize: 10}).Start(ctx, members, 1)
+
+	_, _, err := g.CompareAndSet(ctx, "", "much too large a value")
+	var se *cas.SizeError
+	if !errors.As(err, &se) || se.Size != 22 || se.Max != 10 ||
+		!errors.Is(err, cas.ErrTooLarge) {
+		t.Fatalf("oversized value yielded %v", err)
+	}
+	if _, val, err := g.CompareAndSet(ctx, "", "small"); err != nil ||
+		val != "small" {
+		t.Errorf("set %q %v", val, err)
+	}
+
+	_, _, err = members[0].CompareAndSet(ctx, "", strings.Repeat("x", 1001))
+	if !errors.Is(err, cas.ErrTooLarge) {
+		t.Errorf("oversized member value yielded %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package qscas
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// health tracks how long each member store has been failing,
+// so that a Group can exclude members that fail persistently.
+type health struct {
+	mut     sync.Mutex  // protects the fields below
+	failing []time.Time // when each member began failing, or zero
+	next    []time.Time // when to next probe each excluded member
+	out     []bool      // whether each member is excluded
+	nout    int         // number of members excluded
+}
+
+// Prepare to track the health of n members.
+func (h *health) init(n int) {
+	h.failing = make([]time.Time, n)
+	h.next = make([]time.Time, n)
+	h.out = make([]bool, n)
+}
+
+// Wait until member i may next be accessed: immediately unless excluded,
+// or else once its next probe is due or until ctx is cancelled.
+func (g *Group) awaitProbe(ctx context.Context, i int) error {
+	h := &g.health
+	h.mut.Lock()
+	wait := time.Until(h.next[i])
+	if !h.out[i] {
+		wait = 0
+	}
+	h.mut.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Record the outcome of an access to member i,
+// excluding or readmitting it as appropriate.
+func (g *Group) checkHealth(i int, err error) {
+	h := &g.health
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		h.failing[i] = time.Time{}
+		if h.out[i] {
+			h.out[i] = false
+			h.nout--
+			g.c.Lat.Exclude(i, false)
+		}
+
+	case h.failing[i].IsZero():
+		h.failing[i] = now
+
+	case h.out[i]:
+		h.next[i] = now.Add(g.Exclude)
+
+	case g.Exclude > 0 && now.Sub(h.failing[i]) >= g.Exclude &&
+		h.nout < len(h.out)-g.c.Tr:
+		h.out[i] = true
+		h.nout++
+		h.next[i] = now.Add(g.Exclude)
+		g.c.Lat.Exclude(i, true)
+	}
+}
+
+// Excluded returns the members the Group currently excludes
+// for failing persistently, in increasing order: see Group.Exclude.
+// It may safely be called at any time, concurrently with the Group's operation.
+func (g *Group) Excluded() []int {
+	h := &g.health
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	var out []int
+	for i, x := range h.out {
+		if x {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Return true if member i is excluded.
+func (g *Group) excluded(i int) bool {
+	g.health.mut.Lock()
+	defer g.health.mut.Unlock()
+
+	return g.health.out[i]
+}
@@ -0,0 +1,104 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A member store that fails while down, counting the accesses it fails.
+type downStore struct {
+	cas.Register
+	down  atomic.Bool
+	tries atomic.Int64
+}
+
+func (ds *downStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	if ds.down.Load() {
+		ds.tries.Add(1)
+		return 0, "", errors.New("store down")
+	}
+	return ds.Register.CompareAndSet(ctx, old, new)
+}
+
+// Test that a Group excludes a persistently failing member,
+// probes it only occasionally, and readmits it once it recovers.
+func TestExclude(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	quiet := backoff.Config{Report: func(error) error { return nil },
+		MaxWait: time.Millisecond}
+	ds := &downStore{}
+	ds.down.Store(true)
+	g := &Group{Backoff: quiet, Exclude: 50 * time.Millisecond}
+	g.Start(ctx, []cas.Store{ds, &cas.Register{}, &cas.Register{},
+		&cas.Register{}}, 1)
+
+	// Keep the group busy until the member is excluded.
+	deadline := time.Now().Add(5 * time.Second)
+	for old := ""; len(g.Excluded()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("failing member never excluded")
+		}
+		_, val, err := g.CompareAndSet(ctx, old, old+".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+	if x := g.Excluded(); !reflect.DeepEqual(x, []int{0}) {
+		t.Fatalf("excluded %v", x)
+	}
+	if p := g.Status().Peers[0]; p.Healthy ||
+		!strings.Contains(p.Detail, "excluded") {
+		t.Errorf("excluded member reported as %+v", p)
+	}
+
+	// While excluded, the member should be probed only occasionally,
+	// rather than every millisecond as the backoff policy permits.
+	before := ds.tries.Load()
+	time.Sleep(200 * time.Millisecond)
+	if n := ds.tries.Load() - before; n > 10 {
+		t.Errorf("excluded member tried %v times", n)
+	}
+
+	// The member should be readmitted once it recovers.
+	ds.down.Store(false)
+	for len(g.Excluded()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("recovered member never readmitted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test that a Group excludes no more members than its thresholds tolerate.
+func TestExcludeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	quiet := backoff.Config{Report: func(error) error { return nil },
+		MaxWait: time.Millisecond}
+	members := []cas.Store{&downStore{}, &downStore{}, &cas.Register{},
+		&cas.Register{}}
+	for _, st := range members[:2] {
+		st.(*downStore).down.Store(true)
+	}
+	g := &Group{Backoff: quiet, Exclude: 10 * time.Millisecond}
+	g.Start(ctx, members, 1)
+
+	time.Sleep(200 * time.Millisecond)
+	if x := g.Excluded(); len(x) != 1 {
+		t.Errorf("excluded %v of 4 members, tolerating 1 failure", x)
+	}
+}
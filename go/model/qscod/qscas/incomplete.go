@@ -0,0 +1,103 @@
+package qscas
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// IncompleteError reports that a Group operation did not complete
+// before its context was cancelled or its deadline passed,
+// including the deadline that the Group's Timeout imposes.
+// It describes the group's situation as this client last observed it,
+// so that the caller can decide whether and when to retry.
+//
+// Responded counts the members that were responding to this client:
+// those whose last access succeeded, that had no access outstanding
+// for more than half the operation's duration,
+// and that were within a consensus round of the most advanced member.
+// The group can make progress only once at least Needed members respond.
+// Steps holds the latest TLC step this client read from each member.
+//
+// Proposed reports whether the operation had proposed its change.
+// If so, the change might still commit later,
+// as other clients drive the group forward,
+// so the caller must read the group's state to learn the outcome.
+// Otherwise the abandoned operation can no longer have any effect.
+//
+// An IncompleteError with fewer than Needed members responding
+// is in the class cas.ErrQuorumUnavailable.
+//
+type IncompleteError struct {
+	Err       error   // The context's error
+	Responded int     // Members recently responding
+	Needed    int     // Members needed for the group to progress
+	Steps     []int64 // Latest TLC step read from each member
+	Proposed  bool    // Whether the change might still commit
+}
+
+func (e *IncompleteError) Error() string {
+	outcome := "change was not proposed"
+	if e.Proposed {
+		outcome = "change might still commit"
+	}
+	return fmt.Sprintf("%v: %d of %d needed members responding, %s",
+		e.Err, e.Responded, e.Needed, outcome)
+}
+
+// Unwrap returns the context's error,
+// so that errors.Is recognizes context.DeadlineExceeded, for example.
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the operation lacked a quorum,
+// so that errors.Is recognizes cas.ErrQuorumUnavailable.
+func (e *IncompleteError) Is(target error) bool {
+	return target == cas.ErrQuorumUnavailable && e.Responded < e.Needed
+}
+
+// Apply the Group's Timeout, if any, to the context of an operation.
+func (g *Group) withTimeout(ctx context.Context) (
+	context.Context, context.CancelFunc) {
+
+	if g.Timeout > 0 {
+		return context.WithTimeout(ctx, g.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Return the error for an operation started at start
+// and abandoned when ctx was cancelled,
+// or the group's own context if the group is shutting down.
+func (g *Group) incomplete(ctx context.Context, start time.Time,
+	proposed bool) error {
+
+	if ctx.Err() == nil {
+		return g.ctx.Err()
+	}
+	now := time.Now()
+	stalled := start.Add(now.Sub(start) / 2)
+	e := &IncompleteError{Err: ctx.Err(), Needed: g.c.Tr,
+		Proposed: proposed}
+
+	g.stat.mut.Lock()
+	defer g.stat.mut.Unlock()
+
+	max := int64(0)
+	for _, m := range g.stat.members {
+		e.Steps = append(e.Steps, m.step)
+		if m.step > max {
+			max = m.step
+		}
+	}
+	for _, m := range g.stat.members {
+		if m.seen && m.err == nil && m.step > max-roundSteps &&
+			(m.since.IsZero() || m.since.After(stalled)) {
+			e.Responded++
+		}
+	}
+	return e
+}
@@ -0,0 +1,94 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A member store that stops responding while hung.
+type hangStore struct {
+	cas.Register
+	mut  sync.Mutex
+	hung chan struct{} // closed to resume, or nil if not hung
+}
+
+func (hs *hangStore) hang() {
+	hs.mut.Lock()
+	defer hs.mut.Unlock()
+	hs.hung = make(chan struct{})
+}
+
+func (hs *hangStore) resume() {
+	hs.mut.Lock()
+	defer hs.mut.Unlock()
+	close(hs.hung)
+	hs.hung = nil
+}
+
+func (hs *hangStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	hs.mut.Lock()
+	hung := hs.hung
+	hs.mut.Unlock()
+	if hung != nil {
+		select {
+		case <-hung:
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		}
+	}
+	return hs.Register.CompareAndSet(ctx, old, new)
+}
+
+func TestIncomplete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := &hangStore{}, &hangStore{}
+	members := []cas.Store{&cas.Register{}, a, b}
+	g := (&Group{}).Start(ctx, members, 1)
+	if _, _, err := g.CompareAndSet(ctx, "", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a quorum, operations give up at their deadlines.
+	a.hang()
+	b.hang()
+	tctx, tcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, _, err := g.CompareAndSet(tctx, "x", "y")
+	tcancel()
+	var ie *IncompleteError
+	if !errors.As(err, &ie) || !errors.Is(err, context.DeadlineExceeded) ||
+		!errors.Is(err, cas.ErrQuorumUnavailable) {
+		t.Fatalf("CompareAndSet without quorum: %v", err)
+	}
+	if ie.Responded != 1 || ie.Needed != 2 || len(ie.Steps) != 3 {
+		t.Errorf("incomplete operation reported %+v", ie)
+	}
+	proposed := ie.Proposed
+
+	// So do those of a Group with a Timeout.
+	h := (&Group{Timeout: 100 * time.Millisecond}).Start(ctx, members, 1)
+	if _, _, err := h.CompareAndSet(ctx, "x", "x"); !errors.As(err, &ie) ||
+		ie.Proposed {
+		t.Errorf("read without quorum: %v", err)
+	}
+
+	// The group recovers once the members respond again,
+	// and the abandoned change can have committed only if proposed.
+	a.resume()
+	b.resume()
+	_, val, err := g.CompareAndSet(ctx, "x", "x")
+	if err != nil || (val != "x" && val != "y") {
+		t.Fatalf("read after recovery: %q %v", val, err)
+	}
+	if val == "y" && !proposed {
+		t.Errorf("unproposed change committed")
+	}
+}
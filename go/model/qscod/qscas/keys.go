@@ -0,0 +1,127 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// KeyRotation records a change of the key epoch
+// under which a group's clients encrypt new values,
+// committed through the group by Group.RotateKeys.
+type KeyRotation struct {
+	Epoch uint32 // Key epoch for new values from then on
+	Time  int64  // When the rotation was committed, in Unix seconds
+}
+
+// ErrNoKeys is returned by Group.RotateKeys for a Group without Keys.
+var ErrNoKeys = errors.New("group has no encryption keys")
+
+// KeyEpoch returns the key epoch under which clients of the group
+// encrypt new values, as last committed by RotateKeys,
+// or zero if the group has never rotated its keys,
+// in which case clients use the epoch of their own Keys.
+func (c *Config) KeyEpoch() uint32 {
+	if len(c.Keys) == 0 {
+		return 0
+	}
+	return c.Keys[len(c.Keys)-1].Epoch
+}
+
+// KeyHorizon returns the lowest key epoch whose key clients must retain
+// to read the group's values, as of time now,
+// given a retention horizon after which no member of the group
+// still holds values sealed under the keys a rotation replaced.
+// Keys of lower epochs may be retired, as by encoding.Keyring.Retire.
+// KeyHorizon returns zero if every key must be retained.
+//
+// Members re-encrypt their latest state lazily,
+// each time a client writes the member a consensus value,
+// so the retention horizon must cover both how long a member may lag
+// before it catches up or is repaired,
+// and how long members retain the history of past values, if they do.
+//
+func (c *Config) KeyHorizon(retention time.Duration, now time.Time) uint32 {
+	horizon := uint32(0)
+	for _, r := range c.Keys {
+		if now.Sub(time.Unix(r.Time, 0)) >= retention {
+			horizon = r.Epoch
+		}
+	}
+	return horizon
+}
+
+// Switch to encrypting under the key epoch that configuration c commits,
+// if any and if the group holds its key.
+// The caller must hold g.confMut.
+func (g *Group) rekey(c *Config) {
+	if e := c.KeyEpoch(); e != 0 && g.Keys != nil {
+		if kr, err := g.Keys.WithEpoch(e); err == nil {
+			g.seal = kr
+		}
+	}
+}
+
+// Return the keys with which to encrypt new values, or nil if none.
+func (g *Group) sealKeys() *encoding.Keyring {
+	g.confMut.Lock()
+	defer g.confMut.Unlock()
+
+	if g.seal != nil {
+		return g.seal
+	}
+	return g.Keys
+}
+
+// RotateKeys commits through the group a rotation to key epoch epoch,
+// after which all clients encrypt new values under that epoch's key,
+// rather than the epoch of their own Keys.
+// The key epoch must be higher than the group's current one,
+// and every client must hold its key before the rotation,
+// since clients lacking it cannot read the values written under it.
+// RotateKeys returns the version at which the rotation committed,
+// or ErrConfigChanged if another configuration committed instead.
+//
+// Members re-encrypt their latest state under the new key lazily,
+// as clients next write to them.
+// RotateKeys completes a further consensus round before returning,
+// so that re-encryption is under way on the members keeping up.
+// Clients must retain the replaced keys until the retention horizon passes,
+// and may then retire them: see Config.KeyHorizon.
+//
+// The rotation is a reconfiguration, advancing the configuration epoch,
+// so clients fenced to the prior epoch must restart as for Reconfigure.
+//
+func (g *Group) RotateKeys(ctx context.Context, epoch uint32) (
+	version int64, err error) {
+
+	if g.Keys == nil {
+		return 0, ErrNoKeys
+	}
+	if _, err := g.Keys.WithEpoch(epoch); err != nil {
+		return 0, err
+	}
+	c, err := g.Config(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if cur := c.KeyEpoch(); epoch <= cur {
+		return 0, fmt.Errorf("key epoch %v does not follow "+
+			"the group's key epoch %v", epoch, cur)
+	}
+
+	n := *c
+	n.Epoch++
+	n.Keys = append(append([]KeyRotation{}, c.Keys...),
+		KeyRotation{Epoch: epoch, Time: time.Now().Unix()})
+	if version, err = g.Reconfigure(ctx, &n); err != nil {
+		return 0, err
+	}
+	if _, _, err := g.CompareAndSet(ctx, "", ""); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,118 @@
+package qscas
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// Return a keyring holding keys for the given epochs,
+// encrypting under the first.
+func testKeyring(epochs ...uint32) *encoding.Keyring {
+	kr := &encoding.Keyring{Epoch: epochs[0], Keys: map[uint32][]byte{}}
+	for _, e := range epochs {
+		kr.Keys[e] = bytes.Repeat([]byte{byte(e)}, 32)
+	}
+	return kr
+}
+
+// Return the key epochs under which the members' latest values are sealed.
+func memberKeyEpochs(ctx context.Context, members []cas.Store) []uint32 {
+	epochs := make([]uint32, len(members))
+	for i, m := range members {
+		_, val, _ := m.CompareAndSet(ctx, "?", "?")
+		_, b := encoding.SplitEpoch([]byte(val))
+		if len(b) >= 4 {
+			epochs[i] = binary.BigEndian.Uint32(b)
+		}
+	}
+	return epochs
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	g := (&Group{Keys: testKeyring(1, 2)}).Start(ctx, members, 1)
+	h := (&Group{Keys: testKeyring(1, 2)}).Start(ctx, members, 1)
+	if _, err := (&Group{}).RotateKeys(ctx, 2); err != ErrNoKeys {
+		t.Errorf("rotating without keys yielded %v", err)
+	}
+
+	c, err := NewConfig([]string{"a", "b", "c"}, 1, SuiteAESGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reconfigure(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.CompareAndSet(ctx, "", "x"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range memberKeyEpochs(ctx, members) {
+		if e != 1 {
+			t.Errorf("member value sealed under key epoch %v", e)
+		}
+	}
+
+	// Rotate to a key epoch that every client holds.
+	if _, err := g.RotateKeys(ctx, 3); err != encoding.ErrUnknownEpoch {
+		t.Errorf("rotating to unknown epoch yielded %v", err)
+	}
+	if _, err := g.RotateKeys(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.RotateKeys(ctx, 2); err == nil {
+		t.Errorf("rotating to the current key epoch succeeded")
+	}
+
+	// The members re-encrypt as other clients write to them.
+	deadline := time.Now().Add(5 * time.Second)
+	for done := false; !done; {
+		if time.Now().After(deadline) {
+			t.Fatalf("members sealed under %v",
+				memberKeyEpochs(ctx, members))
+		}
+		if _, _, err := h.CompareAndSet(ctx, "x", "x"); err != nil {
+			t.Fatal(err)
+		}
+		done = true
+		for _, e := range memberKeyEpochs(ctx, members) {
+			done = done && e == 2
+		}
+	}
+
+	// Once the retention horizon passes, the old key may be retired.
+	c, err = h.Config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.KeyEpoch() != 2 || c.KeyHorizon(time.Hour, time.Now()) != 0 {
+		t.Errorf("key epoch %v, horizon %v",
+			c.KeyEpoch(), c.KeyHorizon(time.Hour, time.Now()))
+	}
+	kr := testKeyring(2, 1)
+	kr.Retire(c.KeyHorizon(0, time.Now()))
+	if _, ok := kr.Keys[1]; ok {
+		t.Errorf("key epoch 1 not retired")
+	}
+	k := (&Group{Keys: kr}).Start(ctx, members, 1)
+	if _, val, err := k.CompareAndSet(ctx, "?", "?"); err != nil ||
+		val != "x" {
+		t.Errorf("client with retired key read %q %v", val, err)
+	}
+
+	// A client lacking the committed key cannot operate the group.
+	old := (&Group{Keys: testKeyring(1),
+		Timeout: 100 * time.Millisecond}).Start(ctx, members, 1)
+	if _, err := old.Config(ctx); err == nil {
+		t.Errorf("client lacking the committed key loaded configuration")
+	}
+}
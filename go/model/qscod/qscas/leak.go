@@ -0,0 +1,116 @@
+package qscas
+
+import (
+	"context"
+	"sync"
+)
+
+// tracker accounts for the goroutines a Group starts
+// and the calls each of them has in progress to member stores,
+// so that Close can wait for them and Leaked can report any that remain.
+type tracker struct {
+	mut     sync.Mutex    // protects the fields below
+	live    int           // goroutines still running
+	calls   []int         // calls in progress to each member
+	changed chan struct{} // closed and replaced whenever live decreases
+}
+
+// Initialize the tracker for a group of n members.
+func (t *tracker) init(n int) {
+	t.calls = make([]int, n)
+	t.changed = make(chan struct{})
+}
+
+// Start a tracked goroutine running f.
+func (t *tracker) goroutine(f func()) {
+	t.mut.Lock()
+	t.live++
+	t.mut.Unlock()
+
+	go func() {
+		defer t.exit()
+		f()
+	}()
+}
+
+// Record the exit of a tracked goroutine.
+func (t *tracker) exit() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.live--
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Record the start of a call to member i,
+// returning a function to record the call's completion.
+func (t *tracker) call(i int) func() {
+	t.mut.Lock()
+	t.calls[i]++
+	t.mut.Unlock()
+
+	return func() {
+		t.mut.Lock()
+		t.calls[i]--
+		t.mut.Unlock()
+	}
+}
+
+// Wait until no tracked goroutines are running or ctx is done.
+func (t *tracker) wait(ctx context.Context) error {
+	for {
+		t.mut.Lock()
+		live, changed := t.live, t.changed
+		t.mut.Unlock()
+
+		if live == 0 {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close shuts down the Group, as cancelling the context passed to Start does,
+// then waits until every goroutine the Group started has terminated.
+// A member store that ignores context cancellation may block
+// the goroutine accessing it indefinitely, however,
+// so Close returns ctx's error if ctx is done first.
+// Leaked then reports the goroutines that remain.
+//
+// Servers that create and discard many Groups should Close each one
+// and monitor Leaked, since goroutines blocked in a member store
+// hold on to the Group's memory for as long as they remain blocked.
+//
+func (g *Group) Close(ctx context.Context) error {
+	g.cancel()
+	return g.live.wait(ctx)
+}
+
+// Leaked reports the goroutines the Group started that are still running
+// after the Group shut down, such as by Close or context cancellation,
+// returning their number and the members with calls still in progress,
+// which are normally what blocks them.
+// While the Group is operating, Leaked returns 0 and nil.
+func (g *Group) Leaked() (goroutines int, members []int) {
+	g.mut.Lock()
+	done := g.done
+	g.mut.Unlock()
+	if !done {
+		return 0, nil
+	}
+
+	g.live.mut.Lock()
+	defer g.live.mut.Unlock()
+
+	for i, n := range g.live.calls {
+		if n > 0 {
+			members = append(members, i)
+		}
+	}
+	return g.live.live, members
+}
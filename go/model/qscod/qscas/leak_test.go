@@ -0,0 +1,111 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A member store that hangs, ignoring context cancellation,
+// until release is closed.
+type stuckStore struct {
+	release chan struct{}
+}
+
+func (s stuckStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	<-s.release
+	return 0, "", errors.New("store hung")
+}
+
+// Wait for the number of running goroutines to fall to n,
+// since tracked goroutines finish exiting just after the tracker notes it.
+func waitGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v goroutines still running, want %v",
+				runtime.NumGoroutine(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test that all the goroutines a Group starts terminate after it shuts down.
+func TestLeak(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	// Shut down a group with a cancelled context,
+	// after operating it and verifying a proof, but before Close.
+	ctx, cancel := context.WithCancel(context.Background())
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}, &cas.Register{}}
+	g := (&Group{}).Start(ctx, members, 1)
+	_, _, p, err := g.CompareAndSetProof(ctx, "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Verify(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := g.Leaked(); n != 0 || m != nil {
+		t.Errorf("running group leaked %v goroutines in %v", n, m)
+	}
+	cancel()
+	tctx, tcancel := context.WithTimeout(context.Background(), time.Minute)
+	defer tcancel()
+	if err := g.Close(tctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := g.Leaked(); n != 0 || m != nil {
+		t.Errorf("closed group leaked %v goroutines in %v", n, m)
+	}
+	waitGoroutines(t, base)
+
+	// Close groups some of whose members hang,
+	// including a group with too few live members to make progress.
+	for _, hung := range []int{1, 4} {
+		release := make(chan struct{})
+		members := []cas.Store{&cas.Register{}, &cas.Register{},
+			&cas.Register{}, &cas.Register{}}
+		want := []int{}
+		for i := 0; i < hung; i++ {
+			members[i] = stuckStore{release}
+			want = append(want, i)
+		}
+		g := (&Group{}).Start(context.Background(), members, 1)
+		sctx, scancel := context.WithTimeout(tctx, 100*time.Millisecond)
+		_, _, err := g.CompareAndSet(sctx, "", "a")
+		scancel()
+		if (err == nil) != (hung == 1) {
+			t.Errorf("%v hung: CompareAndSet yielded %v", hung, err)
+		}
+
+		sctx, scancel = context.WithTimeout(tctx, 100*time.Millisecond)
+		err = g.Close(sctx)
+		scancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%v hung: Close yielded %v", hung, err)
+		}
+		n, m := g.Leaked()
+		if n < hung || !reflect.DeepEqual(m, want) {
+			t.Errorf("%v hung: leaked %v goroutines in %v", hung, n, m)
+		}
+
+		close(release)
+		if err := g.Close(tctx); err != nil {
+			t.Fatal(err)
+		}
+		if n, m := g.Leaked(); n != 0 || m != nil {
+			t.Errorf("%v hung: released group leaked %v in %v",
+				hung, n, m)
+		}
+		waitGoroutines(t, base)
+	}
+}
@@ -0,0 +1,93 @@
+package qscas
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// ErrConfigMismatch is the error a ConfigMismatchError wraps,
+// so that callers may detect misconfigured clients with errors.Is.
+var ErrConfigMismatch = errors.New("group configuration mismatch")
+
+// ConfigMismatchError reports that a Group fenced to a configuration epoch
+// read a member value written by a client of the same epoch
+// but configured with different thresholds or members.
+// Clients that disagree on a group's configuration could commit
+// conflicting states, so the Group stops operating at that point,
+// and all further operations return the ConfigMismatchError.
+//
+// Each client of a fenced Group tags every value it writes under its epoch
+// with a hash of its configuration: the number of members,
+// the consensus thresholds, and the names of the members if Names is set.
+// Clients reading values written under other epochs,
+// or by releases predating configuration hashes, check nothing,
+// leaving reconfiguration to epoch fencing: see ErrStaleEpoch.
+// Unfenced Groups neither tag nor check values,
+// since clients commonly start them with provisional configurations
+// only to discover the group's actual configuration via Config.
+//
+type ConfigMismatchError struct {
+	Member int    // Member store holding the mismatched value
+	Local  uint64 // Hash of this Group's configuration
+	Remote uint64 // Hash of the configuration that wrote the value
+}
+
+func (e *ConfigMismatchError) Error() string {
+	return fmt.Sprintf("%v: member %d holds a value written "+
+		"under configuration %016x, not %016x",
+		ErrConfigMismatch, e.Member, e.Remote, e.Local)
+}
+
+// Unwrap returns ErrConfigMismatch.
+func (e *ConfigMismatchError) Unwrap() error {
+	return ErrConfigMismatch
+}
+
+// Return the hash of a group configuration of n members with names,
+// if known, and thresholds tr and ts. The hash is never zero.
+func configHash(n, tr, ts int, names []string) uint64 {
+	h := sha256.New()
+	var b []byte
+	b = binary.AppendUvarint(b, uint64(n))
+	b = binary.AppendUvarint(b, uint64(tr))
+	b = binary.AppendUvarint(b, uint64(ts))
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+	}
+	h.Write(b)
+	if v := binary.BigEndian.Uint64(h.Sum(nil)); v != 0 {
+		return v
+	}
+	return 1
+}
+
+// Return val tagged with our configuration's hash
+// if we are fenced and writing it under our own configuration epoch.
+func (g *Group) tagConfig(val core.Value, epoch int64) core.Value {
+	if g.Epoch != 0 && epoch == g.Epoch {
+		val.H = g.hash
+	}
+	return val
+}
+
+// Check the configuration hash of value val that member i held,
+// whose sealed form is vals,
+// stopping the group if a client with a different configuration wrote it.
+func (g *Group) checkConfig(i int, vals string, val core.Value) error {
+	e, _ := encoding.SplitEpoch([]byte(vals))
+	if g.Epoch == 0 || e != g.Epoch || val.H == 0 || val.H == g.hash {
+		return nil
+	}
+	f := &g.fence
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.fail(&ConfigMismatchError{Member: i, Local: g.hash,
+		Remote: val.H})
+}
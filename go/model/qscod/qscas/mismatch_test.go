@@ -0,0 +1,75 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Test that a fenced client stops on reading a value that a client
+// configured with different thresholds or members wrote.
+func TestConfigMismatch(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	test := func(desc string, a, b *Group, faulty int, mismatch bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		members := []cas.Store{&cas.Register{}, &cas.Register{},
+			&cas.Register{}}
+		a.Start(ctx, members, 1)
+		if _, _, err := a.CompareAndSet(ctx, "", "a"); err != nil {
+			t.Fatal(err)
+		}
+		b.Start(ctx, members, faulty)
+		_, _, err := b.CompareAndSet(ctx, "a", "b")
+		var me *ConfigMismatchError
+		switch {
+		case !mismatch && err != nil:
+			t.Errorf("%s: %v", desc, err)
+		case mismatch && (!errors.As(err, &me) ||
+			!errors.Is(err, ErrConfigMismatch) ||
+			me.Local != b.hash || me.Remote != a.hash):
+			t.Errorf("%s: %v", desc, err)
+		}
+
+		// Once stopped, the client stays stopped.
+		if mismatch {
+			_, _, err := b.CompareAndSet(ctx, "", "")
+			if !errors.Is(err, ErrConfigMismatch) {
+				t.Errorf("%s: stopped client yielded %v", desc, err)
+			}
+		}
+	}
+	test("same", &Group{Epoch: 1}, &Group{Epoch: 1}, 1, false)
+	test("same names", &Group{Epoch: 1, Names: names},
+		&Group{Epoch: 1, Names: names}, 1, false)
+	test("thresholds", &Group{Epoch: 1}, &Group{Epoch: 1}, 0, true)
+	test("names", &Group{Epoch: 1, Names: names},
+		&Group{Epoch: 1, Names: []string{"a", "b", "x"}}, 1, true)
+
+	// Unfenced clients may be discovering the group's configuration.
+	test("unfenced", &Group{}, &Group{}, 0, false)
+
+	// Clients of different epochs leave the check to epoch fencing.
+	test("epochs", &Group{Epoch: 1}, &Group{Epoch: 2}, 0, false)
+}
+
+// Test that a Group started with the wrong number of member entries
+// refuses to operate.
+func TestMemberCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	for _, g := range []*Group{{Domains: []string{"east", "west"}},
+		{Names: []string{"a", "b", "c", "d"}}} {
+		g.Start(ctx, members, 1)
+		_, _, err := g.CompareAndSet(ctx, "", "x")
+		if !errors.Is(err, ErrMemberCount) {
+			t.Errorf("Domains %v, Names %v: %v", g.Domains, g.Names, err)
+		}
+	}
+}
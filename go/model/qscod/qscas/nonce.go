@@ -0,0 +1,87 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// ErrAmnesia is the error a Group reports for accesses to a member store
+// whose nonce differs from the one the group's in-band configuration records
+// for it in Config.Nonces, indicating that the member's state was lost,
+// as on a disk wipe, and recreated empty since the configuration committed.
+// A member that forgot the values it acknowledged may silently
+// violate the group's safety assumptions if clients keep trusting it,
+// so the Group quarantines such a member, treating it as failed,
+// until a later configuration records its new nonce.
+//
+// To readmit a quarantined member, rebuild its state via Repair,
+// then commit a configuration recording its new nonce via Reconfigure.
+// A Group rechecks each member's nonce about once a second,
+// once it has observed the configuration, so it may briefly use
+// a member that lost its state before noticing.
+//
+var ErrAmnesia = errors.New("member store lost its state")
+
+// How often a Group rechecks the nonce of a member store in good standing.
+const nonceCheck = time.Second
+
+// Nonces reads the nonce of each member store, in group order,
+// as a configuration records them in Config.Nonces,
+// returning the empty string for stores that do not implement cas.Nonced.
+func Nonces(ctx context.Context, members []cas.Store) ([]string, error) {
+	nonces := make([]string, len(members))
+	for i, st := range members {
+		ns, ok := st.(cas.Nonced)
+		if !ok {
+			continue
+		}
+		nonce, err := ns.Nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nonces[i] = nonce
+	}
+	return nonces, nil
+}
+
+// Return the nonce the latest configuration records for member i,
+// or the empty string if unknown.
+func (g *Group) memberNonce(i int) string {
+	g.confMut.Lock()
+	defer g.confMut.Unlock()
+
+	c := g.conf
+	if c == nil || len(c.Nonces) != len(g.members) {
+		return ""
+	}
+	return c.Nonces[i]
+}
+
+// Check that our member store still has the nonce
+// that the group's configuration records for it,
+// returning an error wrapping ErrAmnesia if not.
+// A member in good standing is rechecked only every nonceCheck,
+// but a quarantined one on every access, until readmitted.
+func (cs *coreStore) checkNonce() error {
+	ns, ok := cs.Store.(cas.Nonced)
+	if !ok || time.Since(cs.nonceAt) < nonceCheck {
+		return nil
+	}
+	want := cs.g.memberNonce(cs.i)
+	if want == "" {
+		return nil
+	}
+	nonce, err := ns.Nonce(cs.g.ctx)
+	if err != nil {
+		return err
+	}
+	if nonce != want {
+		return fmt.Errorf("member %d: %w", cs.i, ErrAmnesia)
+	}
+	cs.nonceAt = time.Now()
+	return nil
+}
@@ -0,0 +1,101 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A member store that can lose its state, changing its nonce.
+type amnesiacStore struct {
+	mut   sync.Mutex
+	reg   *cas.Register
+	nonce string
+}
+
+func (as *amnesiacStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	as.mut.Lock()
+	reg := as.reg
+	as.mut.Unlock()
+	return reg.CompareAndSet(ctx, old, new)
+}
+
+func (as *amnesiacStore) Nonce(ctx context.Context) (string, error) {
+	as.mut.Lock()
+	defer as.mut.Unlock()
+	return as.nonce, nil
+}
+
+// Wipe the store's state, giving it a new nonce.
+func (as *amnesiacStore) wipe(nonce string) {
+	as.mut.Lock()
+	defer as.mut.Unlock()
+	as.reg, as.nonce = &cas.Register{}, nonce
+}
+
+// Test that a Group quarantines a member that lost its state
+// until a new configuration readmits it.
+func TestAmnesia(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stores := []*amnesiacStore{}
+	members := []cas.Store{}
+	for _, nonce := range []string{"a", "b", "c"} {
+		st := &amnesiacStore{reg: &cas.Register{}, nonce: nonce}
+		stores = append(stores, st)
+		members = append(members, st)
+	}
+	g := (&Group{}).Start(ctx, members, 1)
+	c, err := NewConfig([]string{"a", "b", "c"}, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Nonces, err = Nonces(ctx, members); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reconfigure(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run operations until member 2's last access yields want.
+	memberErr := func(want error) {
+		deadline := time.Now().Add(10 * time.Second)
+		for i := 0; time.Now().Before(deadline); i++ {
+			if _, _, err := g.CompareAndSet(ctx, "", ""); err != nil {
+				t.Fatal(err)
+			}
+			g.stat.mut.Lock()
+			err := g.stat.members[2].err
+			g.stat.mut.Unlock()
+			if errors.Is(err, want) || err == want {
+				return
+			}
+		}
+		t.Fatalf("member 2 never yielded %v", want)
+	}
+
+	// The group keeps operating without the amnesiac member.
+	stores[2].wipe("c2")
+	memberErr(ErrAmnesia)
+
+	// Once repaired, a configuration recording its new nonce readmits it.
+	if _, err := Repair(ctx, members, 2, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	n := *c
+	n.Epoch++
+	if n.Nonces, err = Nonces(ctx, members); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reconfigure(ctx, &n); err != nil {
+		t.Fatal(err)
+	}
+	memberErr(nil)
+}
@@ -0,0 +1,280 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// DefaultPoll is the default maximum interval
+// at which an Observer polls a member store for progress.
+const DefaultPoll = 100 * time.Millisecond
+
+// Observer tracks the commits of a QSCOD consensus group
+// without participating in consensus,
+// e.g., for dashboards, backup archivers, or audit nodes.
+//
+// An Observer only ever reads the group's member stores,
+// polling them until other clients advance the consensus state.
+// It follows the QSCOD protocol exactly as a client
+// whose every write is preempted by another client's,
+// so it learns of commits just as a participating client would,
+// but it never counts toward the group's thresholds
+// and can neither propose nor commit anything.
+// Like any client, an Observer may not realize that a round committed
+// until a later round does, so it may lag an idle group.
+//
+// Keys must be the group's keys if it encrypts its values.
+// Mirror optionally designates a store that the Observer keeps
+// up to date with the latest member state it reads,
+// so that the store may later join the group as a full member:
+// see Config.Promote.
+// Like a Group's, an Observer's versions advance only when the state changes,
+// unless NoOps is true.
+// The configuration fields must be set before calling Start.
+//
+type Observer struct {
+	Keys   *encoding.Keyring // Optional keys for encryption at rest
+	Mirror cas.Store         // Optional store to mirror member state into
+	Poll   time.Duration     // Maximum poll interval, or 0 for DefaultPoll
+	NoOps  bool              // Report no-op commits as new versions
+
+	// Error, if non-nil, is called with each error reading a member store
+	// or opening the value read, after which the Observer retries
+	// the member until its context is cancelled.
+	// It may be called concurrently for different members.
+	Error func(member int, err error)
+
+	c   core.Client     // consensus client core
+	ctx context.Context // observer operation context
+	ev  Proof           // evidence of the commit being reported
+
+	mut   sync.Mutex    // protects the fields below
+	ver   int64         // version of the latest commit observed
+	val   string        // application state of the latest commit
+	proof Proof         // evidence of the latest commit
+	conf  *Config       // latest in-band configuration observed
+	raw   string        // latest member state read, for Mirror
+	rawS  int64         // TLC step of raw
+	ch    chan struct{} // closed and replaced on each commit
+}
+
+// Start initializes o to observe a consensus group comprised of
+// particular member stores, starts it operating, and returns o.
+// The faulty parameter must match the group's, as in Group.Start.
+//
+// The Observer runs until the passed context is cancelled.
+//
+func (o *Observer) Start(ctx context.Context, members []cas.Store,
+	faulty int) *Observer {
+
+	Tr, Ts, err := Thresholds(len(members), faulty)
+	if err != nil {
+		panic(err.Error())
+	}
+	o.c = core.Client{Tr: Tr, Ts: Ts}
+	o.ctx = ctx
+	o.ch = make(chan struct{})
+
+	o.c.KV = make([]core.Store, len(members))
+	for i := range members {
+		o.c.KV[i] = &observerStore{Store: members[i], o: o, i: i}
+	}
+
+	// Record each commit the consensus core observes,
+	// proposing nothing of our own.
+	o.c.Ev = func(ver, step int64, nodes []int) {
+		o.ev = Proof{Version: ver, Step: step, Members: nodes}
+	}
+	o.c.Pr = func(s int64, p string, c bool) (string, int64) {
+		if c {
+			o.commit(s, p)
+		}
+		return p, 0
+	}
+
+	go o.c.Run(ctx)
+	if o.Mirror != nil {
+		go o.mirror(ctx)
+	}
+	return o
+}
+
+// Record a commit of state p at step s.
+func (o *Observer) commit(s int64, p string) {
+	m, _ := splitCommit(p)
+	conf, val := splitState(p)
+	ver := s
+	if !o.NoOps {
+		ver = m.version(s)
+	}
+
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if ver <= o.ver {
+		return // already observed, or a no-op repeating it
+	}
+	o.ver, o.val, o.proof = ver, val, o.ev
+	if conf != nil {
+		o.conf = conf
+	}
+	close(o.ch)
+	o.ch = make(chan struct{})
+}
+
+// Latest returns the latest commit the Observer has observed,
+// or version zero and the empty state if it has observed none yet.
+func (o *Observer) Latest() (version int64, actual string, proof Proof) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	return o.ver, o.val, o.proof
+}
+
+// Wait waits until the Observer observes a commit with version after,
+// then returns the latest commit it has observed.
+// Returns an error if either ctx or the Observer's context is cancelled.
+//
+func (o *Observer) Wait(ctx context.Context, after int64) (
+	version int64, actual string, proof Proof, err error) {
+
+	for {
+		o.mut.Lock()
+		ver, val, proof, ch := o.ver, o.val, o.proof, o.ch
+		o.mut.Unlock()
+		if ver > after {
+			return ver, val, proof, nil
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return 0, "", Proof{}, ctx.Err()
+		case <-o.ctx.Done():
+			return 0, "", Proof{}, o.ctx.Err()
+		}
+	}
+}
+
+// Config returns the latest in-band configuration the Observer observed,
+// or nil if it has observed none.
+func (o *Observer) Config() *Config {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	return o.conf
+}
+
+// Keep the Mirror store up to date with the latest member state,
+// after each commit we observe.
+func (o *Observer) mirror(ctx context.Context) {
+	old := "" // the state we last found in the Mirror
+	for ver := int64(0); ; {
+		var err error
+		if ver, _, _, err = o.Wait(ctx, ver); err != nil {
+			return
+		}
+		o.mut.Lock()
+		raw, rawS := o.raw, o.rawS
+		o.mut.Unlock()
+
+		// Advance the Mirror to raw unless it has already moved beyond,
+		// e.g., because it has since been promoted to a group member.
+		try := func() error {
+			for old != raw {
+				_, act, err := o.Mirror.CompareAndSet(ctx, old, raw)
+				if err != nil {
+					return err
+				}
+				old = act
+				if act == "" {
+					continue
+				}
+				v, err := encoding.OpenValue([]byte(act), o.Keys)
+				if err == nil && v.S >= rawS {
+					break
+				}
+			}
+			return nil
+		}
+		if err := backoff.Retry(ctx, try); err != nil {
+			return
+		}
+	}
+}
+
+// errBehind indicates that a member store has yet to reach a step.
+var errBehind = errors.New("member store behind")
+
+// observerStore implements QSCOD core's native Store interface
+// by polling a member cas.Store, never writing it.
+type observerStore struct {
+	cas.Store            // underlying CAS state store
+	o         *Observer  // observer this store is associated with
+	i         int        // index of this store among the members
+	lvals     string     // last value we observed in the underlying Store
+	lval      core.Value // deserialized last value
+}
+
+// WriteRead returns the member's value at step v.S or later,
+// waiting for other clients to write one, instead of writing v.
+func (obs *observerStore) WriteRead(v core.Value) core.Value {
+	o := obs.o
+
+	try := func() error {
+		if obs.lval.S >= v.S {
+			return nil
+		}
+		_, avals, err := obs.CompareAndSet(o.ctx, "", "")
+		if err != nil {
+			return err
+		}
+		if avals == obs.lvals {
+			return errBehind
+		}
+		aval, err := encoding.OpenValue([]byte(avals), o.Keys)
+		if err != nil {
+			return err
+		}
+		if aval.S > obs.lval.S {
+			obs.lvals, obs.lval = avals, aval
+			o.saw(avals, aval.S)
+		}
+		if aval.S < v.S {
+			return errBehind
+		}
+		return nil
+	}
+	report := func(err error) error {
+		if err != errBehind && o.Error != nil {
+			o.Error(obs.i, err)
+		}
+		return nil
+	}
+	poll := o.Poll
+	if poll == 0 {
+		poll = DefaultPoll
+	}
+	conf := backoff.Config{Report: report, MaxWait: poll}
+	if err := conf.Retry(o.ctx, try); err != nil {
+		return core.Value{} // our context was cancelled
+	}
+	return obs.lval
+}
+
+// Record the latest raw member state read, for the Mirror.
+func (o *Observer) saw(raw string, s int64) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if s > o.rawS {
+		o.raw, o.rawS = raw, s
+	}
+}
@@ -0,0 +1,145 @@
+package qscas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// readOnly wraps a member store to fail the test on any write.
+type readOnly struct {
+	cas.Store
+	t *testing.T
+}
+
+func (ro readOnly) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	if old != new {
+		ro.t.Errorf("observer wrote member store")
+	}
+	return ro.Store.CompareAndSet(ctx, old, new)
+}
+
+func TestObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	ro := make([]cas.Store, len(members))
+	for i := range members {
+		ro[i] = readOnly{members[i], t}
+	}
+	mirror := &cas.Register{}
+	octx, ocancel := context.WithCancel(ctx)
+	o := (&Observer{Mirror: mirror, Poll: time.Millisecond}).
+		Start(octx, ro, 1)
+	g := (&Group{}).Start(ctx, members, 1)
+
+	// Configure the group with an observer, and commit some state.
+	c0, err := NewConfig([]string{"a", "b", "c"}, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c0.Observers = []string{"d"}
+	if _, err := g.Reconfigure(ctx, c0); err != nil {
+		t.Fatal(err)
+	}
+	for old := ""; old != "x"; {
+		_, val, err := g.CompareAndSet(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+
+	// The observer learns of commits only as the group progresses,
+	// so keep it busy with reads until the observer sees what we expect.
+	observe := func(ok func() bool) {
+		for !ok() {
+			if _, _, err := g.CompareAndSet(ctx, "", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The observer must see the same commits.
+	observe(func() bool { _, val, _ := o.Latest(); return val == "x" })
+	ver, val, proof := o.Latest()
+	if proof.Version != ver || len(proof.Members) < 2 {
+		t.Fatalf("observed %v %q %+v", ver, val, proof)
+	}
+	if v, _, _, err := o.Wait(ctx, ver-1); err != nil || v < ver {
+		t.Errorf("waited for %v %v", v, err)
+	}
+	if c := o.Config(); c == nil || !reflect.DeepEqual(c.Observers, c0.Observers) {
+		t.Errorf("observed configuration %+v", c)
+	}
+
+	// Promote the observer, whose mirror can then join the group.
+	if _, err := c0.Promote("e"); err == nil {
+		t.Errorf("promoted a non-observer")
+	}
+	c1, err := c0.Promote("d")
+	if err != nil || c1.Epoch != 1 || len(c1.Members) != 4 ||
+		c1.Observers != nil || c1.Tr != 3 || c1.Ts != 2 {
+		t.Fatalf("promoted to %+v %v", c1, err)
+	}
+	if _, err := g.Reconfigure(ctx, c1); err != nil {
+		t.Fatal(err)
+	}
+	observe(func() bool { return o.Config().Epoch == 1 })
+	for {
+		if _, raw, _ := mirror.CompareAndSet(ctx, "", ""); raw != "" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The observer must stop observing the old configuration
+	// before the group moves on under the new one.
+	ocancel()
+	h := (&Group{}).Start(ctx, append(members, mirror), 1)
+	if c, err := h.Config(ctx); err != nil || c.Epoch != 1 {
+		t.Fatalf("loaded %+v %v", c, err)
+	}
+	if _, val, err := h.CompareAndSet(ctx, "", ""); err != nil || val != "x" {
+		t.Errorf("read %q %v", val, err)
+	}
+}
+
+// Test that an Observer reports errors reading member stores.
+func TestObserverError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{}, failedStore{}}
+	failed := make(chan int, 1)
+	report := func(member int, err error) {
+		select {
+		case failed <- member:
+		default:
+		}
+	}
+	(&Observer{Poll: time.Millisecond, Error: report}).
+		Start(ctx, members, 1)
+	g := (&Group{}).Start(ctx, members, 1)
+
+	for {
+		select {
+		case i := <-failed:
+			if i != 2 {
+				t.Errorf("observer reported member %v failed", i)
+			}
+			return
+		default:
+		}
+		if _, _, err := g.CompareAndSet(ctx, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
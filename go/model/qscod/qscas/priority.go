@@ -0,0 +1,103 @@
+package qscas
+
+import (
+	"context"
+	"time"
+)
+
+// Priority is the class of a Group operation.
+// When many operations contend for a Group,
+// it prefers to propose the work of higher classes first,
+// so that latency-critical control operations aren't starved by bulk updates.
+// Operations that wait too long age into higher classes,
+// so that those of every class are eventually served.
+//
+type Priority int
+
+// The priority classes, from lowest to highest.
+const (
+	PriorityBulk    Priority = iota // Bulk updates that may wait
+	PriorityNormal                  // Default for untagged operations
+	PriorityControl                 // Latency-critical control operations
+
+	numPriorities = iota
+)
+
+// DefaultAging is the default time an operation waits
+// before it ages into the next higher priority class.
+const DefaultAging = 100 * time.Millisecond
+
+// Key under which a context carries an operation's priority.
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that tags the Group operations
+// performed with it, such as CompareAndSet, with priority class p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	if p < PriorityBulk {
+		p = PriorityBulk
+	} else if p > PriorityControl {
+		p = PriorityControl
+	}
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// Return the priority class of the operation a context tags.
+func priorityOf(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// Send a proposal function to the consensus core
+// in priority class p, or higher as it ages while waiting,
+// and return the class in which it was received,
+// or in which it was waiting when ctx was cancelled.
+func (g *Group) send(ctx context.Context, p Priority,
+	pr func(int64, string, bool) (string, int64)) Priority {
+
+	for p < PriorityControl {
+		aging := g.Aging
+		if aging == 0 {
+			aging = DefaultAging
+		}
+		t := time.NewTimer(aging)
+		select {
+		case g.ch[p] <- pr:
+			t.Stop()
+			return p
+		case <-t.C:
+			p++ // waited long enough to age into the next class
+		case <-ctx.Done():
+			t.Stop()
+			return p
+		}
+	}
+	select {
+	case g.ch[p] <- pr:
+	case <-ctx.Done():
+	}
+	return p
+}
+
+// Receive the next proposal function to call,
+// preferring the highest priority class any is waiting in.
+// Returns nil if the group is shutting down or ctx is cancelled.
+func (g *Group) next(ctx context.Context) (
+	f func(int64, string, bool) (string, int64)) {
+
+	for p := PriorityControl; p >= PriorityBulk; p-- {
+		select {
+		case f = <-g.ch[p]:
+			return f
+		default:
+		}
+	}
+	select {
+	case f = <-g.ch[PriorityControl]:
+	case f = <-g.ch[PriorityNormal]:
+	case f = <-g.ch[PriorityBulk]:
+	case <-ctx.Done():
+	}
+	return f
+}
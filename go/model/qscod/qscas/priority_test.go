@@ -0,0 +1,71 @@
+package qscas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if p := priorityOf(ctx); p != PriorityNormal {
+		t.Errorf("untagged priority %v", p)
+	}
+	if p := priorityOf(WithPriority(ctx, 10)); p != PriorityControl {
+		t.Errorf("clamped priority %v", p)
+	}
+
+	// Waiting work must be received highest priority first.
+	g := &Group{Aging: time.Hour}
+	for i := range g.ch {
+		g.ch[i] = make(chan func(int64, string, bool) (string, int64), 1)
+	}
+	work := func(p Priority) func(int64, string, bool) (string, int64) {
+		return func(int64, string, bool) (string, int64) {
+			return "", int64(p)
+		}
+	}
+	for _, p := range []Priority{PriorityBulk, PriorityControl,
+		PriorityNormal} {
+		g.ch[p] <- work(p)
+	}
+	for p := PriorityControl; p >= PriorityBulk; p-- {
+		if _, pri := g.next(ctx)(0, "", false); pri != int64(p) {
+			t.Errorf("received priority %v before %v", pri, p)
+		}
+	}
+
+	// Waiting work must age into higher classes.
+	g = &Group{Aging: time.Millisecond}
+	for i := range g.ch {
+		g.ch[i] = make(chan func(int64, string, bool) (string, int64))
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.next(ctx)
+	}()
+	p := g.send(ctx, PriorityBulk, work(PriorityBulk))
+	if p != PriorityControl {
+		t.Errorf("sent at priority %v", p)
+	}
+
+	// Tagged operations must work as usual.
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	h := (&Group{}).Start(ctx, members, 1)
+	for _, p := range []Priority{PriorityBulk, PriorityControl} {
+		pctx := WithPriority(ctx, p)
+		_, old, err := h.CompareAndSet(pctx, "", "")
+		for err == nil && old != string('a'+rune(p)) {
+			_, old, err = h.CompareAndSet(pctx, old,
+				string('a'+rune(p)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
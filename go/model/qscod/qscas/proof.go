@@ -0,0 +1,84 @@
+package qscas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// Proof is evidence of a commit that a Group observed:
+// the TLC step number of the committed value,
+// the TLC step at which the Group observed the commit,
+// and the members whose values at that step the Group read,
+// which together determined that the value was committed.
+//
+type Proof struct {
+	Version int64 // TLC step number of the committed value
+	Step    int64 // TLC step at which the commit was observed
+	Members []int // Members whose values evidence the commit
+}
+
+// Verify re-contacts the group's members to confirm a Proof,
+// rather than relying on the state this Group last observed.
+// It succeeds once a read quorum of members, as many as the commit required,
+// all hold state at or beyond the step at which the commit was observed,
+// so that any later consensus state necessarily builds on the commit.
+// Returns the members that confirmed the proof, in increasing order.
+//
+// Verify waits for members that are slow to respond,
+// but returns an error if the context is cancelled before enough confirm,
+// or if too many members respond with state that fails to confirm it.
+//
+func (g *Group) Verify(ctx context.Context, p Proof) ([]int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read each member's latest state concurrently.
+	type reply struct {
+		member int
+		val    core.Value
+		err    error
+	}
+	ch := make(chan reply, len(g.members))
+	for i, st := range g.members {
+		i, st := i, st
+		g.live.goroutine(func() {
+			defer g.live.call(i)()
+			_, vals, err := st.CompareAndSet(ctx, "", "")
+			var v core.Value
+			if err == nil && vals != "" {
+				v, err = encoding.OpenValue([]byte(vals), g.Keys)
+			}
+			ch <- reply{i, v, err}
+		})
+	}
+
+	// Collect confirmations until we have a quorum.
+	var confirmed []int
+	failed := 0
+	for range g.members {
+		r := <-ch
+		if r.err == nil && r.val.S >= p.Step {
+			confirmed = append(confirmed, r.member)
+			if len(confirmed) == g.c.Tr {
+				sort.Ints(confirmed)
+				return confirmed, nil
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failed++
+		if failed > len(g.members)-g.c.Tr {
+			break
+		}
+	}
+	return nil, cas.Classify(cas.ErrQuorumUnavailable,
+		fmt.Errorf("only %v of %v members confirm step %v",
+			len(confirmed), len(g.members), p.Step))
+}
@@ -0,0 +1,96 @@
+package qscas
+
+import (
+	"context"
+)
+
+// Registers is the state of a multi-register group,
+// mapping each register's name to its value.
+// An absent register has the empty string as its value,
+// just as the state of a single-register group starts out empty.
+//
+// A multi-register group commits all of its registers together
+// as a single structured value, encoded with the group's Codec,
+// so every commit is an atomic transition of the whole map.
+// Snapshot therefore reads all registers as of a single commit,
+// and applications never observe a torn state
+// in which only part of a multi-register update has taken effect.
+//
+type Registers map[string]string
+
+// Snapshot reads the latest committed state of a multi-register group,
+// returning the value of every register as of the commit at version.
+// The returned map is the caller's to keep or modify.
+//
+func (g *Group) Snapshot(ctx context.Context) (
+	version int64, regs Registers, err error) {
+
+	version, _, err = g.Get(ctx, &regs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if regs == nil {
+		regs = Registers{}
+	}
+	return version, regs, nil
+}
+
+// CompareAndSetRegister sets the register named key to new,
+// provided it is still old, leaving all other registers unchanged.
+// Returns the version of the commit it observed or made,
+// and the register's value as of that commit,
+// which is new if the change succeeded.
+// Setting a register to the empty string removes it.
+//
+func (g *Group) CompareAndSetRegister(ctx context.Context,
+	key, old, new string) (version int64, actual string, err error) {
+
+	version, cur, err := g.CompareAndSet(ctx, "", "")
+	for err == nil {
+		var regs Registers
+		if _, err = g.decode(cur, &regs); err != nil {
+			break
+		}
+		if regs[key] != old || old == new {
+			return version, regs[key], nil
+		}
+		if regs == nil {
+			regs = Registers{}
+		}
+		if new == "" {
+			delete(regs, key)
+		} else {
+			regs[key] = new
+		}
+
+		var s, actual string
+		if s, err = g.encode(regs); err != nil {
+			break
+		}
+		version, actual, err = g.CompareAndSet(ctx, cur, s)
+		if err == nil && actual == s {
+			return version, new, nil
+		}
+		cur = actual // another client committed first, so start over
+	}
+	return 0, "", err
+}
+
+// UpdateRegisters atomically modifies any number of registers
+// in a multi-register group.
+// It calls f with the registers' latest committed state,
+// which f may modify in place, then commits the modified state,
+// starting over as Update does if another client commits first.
+// Returns the version at which the modified state committed.
+//
+func (g *Group) UpdateRegisters(ctx context.Context,
+	f func(regs Registers) error) (version int64, err error) {
+
+	var regs Registers
+	return g.Update(ctx, &regs, func(int) error {
+		if regs == nil {
+			regs = Registers{}
+		}
+		return f(regs)
+	})
+}
@@ -0,0 +1,95 @@
+package qscas
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestRegisters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	g := (&Group{}).Start(ctx, members, 1)
+
+	// Registers start out empty, and are set independently.
+	if _, regs, err := g.Snapshot(ctx); err != nil || len(regs) != 0 {
+		t.Fatalf("initial snapshot %v %v", regs, err)
+	}
+	for old := ""; old != "10"; {
+		_, val, err := g.CompareAndSetRegister(ctx, "a", old, "10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = val
+	}
+	if _, val, err := g.CompareAndSetRegister(ctx, "a", "5", "0"); err != nil ||
+		val != "10" {
+		t.Errorf("set register with wrong old value: %q %v", val, err)
+	}
+	if _, val, err := g.CompareAndSetRegister(ctx, "b", "", "0"); err != nil ||
+		val != "0" {
+		t.Errorf("set register b: %q %v", val, err)
+	}
+
+	// Concurrent clients transfer amounts between two registers,
+	// while others take snapshots that must never see a torn transfer.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		h := (&Group{}).Start(ctx, members, 1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := h.UpdateRegisters(ctx, func(r Registers) error {
+					a, _ := strconv.Atoi(r["a"])
+					b, _ := strconv.Atoi(r["b"])
+					r["a"] = strconv.Itoa(a - i)
+					r["b"] = strconv.Itoa(b + i)
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i + 1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, regs, err := h.Snapshot(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				a, _ := strconv.Atoi(regs["a"])
+				b, _ := strconv.Atoi(regs["b"])
+				if a+b != 10 {
+					t.Errorf("torn snapshot %v", regs)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ver, regs, err := g.Snapshot(ctx)
+	a, _ := strconv.Atoi(regs["a"])
+	b, _ := strconv.Atoi(regs["b"])
+	if err != nil || a > -20 || a+b != 10 {
+		t.Fatalf("final snapshot %v %v", regs, err)
+	}
+
+	// Removing a register leaves the others as they were.
+	v, _, err := g.CompareAndSetRegister(ctx, "a", regs["a"], "")
+	if err != nil || v <= ver {
+		t.Fatalf("removed register at version %v: %v", v, err)
+	}
+	if _, snap, err := g.Snapshot(ctx); err != nil || len(snap) != 1 ||
+		snap["b"] != regs["b"] {
+		t.Errorf("snapshot after removal %v %v", snap, err)
+	}
+}
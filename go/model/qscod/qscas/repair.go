@@ -0,0 +1,158 @@
+package qscas
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// Repair rebuilds the state of member i of a consensus group,
+// whose store was lost, e.g., to a disk failure,
+// and has been replaced with a new empty store,
+// from the states of the group's surviving members.
+//
+// Repair reads the latest state of every other member,
+// requiring at least a read quorum of them to respond,
+// and copies the state of the most advanced one, the donor, into member i.
+// If the donor's store implements cas.History,
+// Repair first copies the past versions it retains, in order,
+// so that member i also regains the history it lost.
+// It never moves member i's state back to an earlier TLC step,
+// so it is safe to repair a member that clients have started
+// writing to again, or whose store was not actually lost.
+// Returns the index of the donor member.
+//
+// The members, faulty, and keys parameters are as for Group.Start,
+// except that Repair accesses the members directly:
+// they must be Store handles that no running Group is using.
+// Until clients complete a consensus round on the repaired member,
+// it may lag behind the step it had reached before it was lost,
+// and counts as one of the faulty members the group tolerates.
+//
+func Repair(ctx context.Context, members []cas.Store, i, faulty int,
+	keys *encoding.Keyring) (donor int, err error) {
+
+	Tr, _, err := Thresholds(len(members), faulty)
+	if err != nil {
+		return -1, err
+	}
+
+	// Read the latest state of each surviving member concurrently.
+	type reply struct {
+		member int
+		ver    int64
+		val    string
+		step   int64
+		err    error
+	}
+	ch := make(chan reply, len(members))
+	for j, st := range members {
+		if j == i {
+			continue
+		}
+		go func(j int, st cas.Store) {
+			ver, val, err := st.CompareAndSet(ctx, "", "")
+			step := int64(0)
+			if err == nil {
+				step, err = valueStep(val, keys)
+			}
+			ch <- reply{j, ver, val, step, err}
+		}(j, st)
+	}
+
+	// Choose the most advanced member among those that respond.
+	var best reply
+	best.member = -1
+	ok := 0
+	for n := 0; n < len(members)-1; n++ {
+		r := <-ch
+		if r.err != nil {
+			continue
+		}
+		ok++
+		if best.member < 0 || r.step > best.step {
+			best = r
+		}
+	}
+	if ok < Tr {
+		return -1, cas.Classify(cas.ErrQuorumUnavailable,
+			fmt.Errorf("only %v of %v surviving members "+
+				"responded, fewer than the read quorum of %v",
+				ok, len(members)-1, Tr))
+	}
+
+	// Copy the donor's retained history, then its latest state.
+	lost := &repairStore{Store: members[i], keys: keys}
+	if err := lost.read(ctx); err != nil {
+		return -1, err
+	}
+	if h, ok := members[best.member].(cas.History); ok {
+		vers, err := h.ListVersions(ctx, 1, best.ver-1)
+		if err != nil {
+			vers = nil // history unavailable: just copy the latest
+		}
+		for _, ver := range vers {
+			actual, val, err := h.ReadAt(ctx, ver)
+			if err != nil || actual != ver {
+				continue // collected since we listed it
+			}
+			if err := lost.advance(ctx, val); err != nil {
+				return -1, err
+			}
+		}
+	}
+	if err := lost.advance(ctx, best.val); err != nil {
+		return -1, err
+	}
+	return best.member, nil
+}
+
+// repairStore tracks the state of a member store under repair.
+type repairStore struct {
+	cas.Store                   // the member's new store
+	keys      *encoding.Keyring // keys to decrypt member states, if any
+	val       string            // last state we read or wrote
+	step      int64             // TLC step of that state
+}
+
+// Read the store's current state.
+func (rs *repairStore) read(ctx context.Context) (err error) {
+	_, rs.val, err = rs.CompareAndSet(ctx, "", "")
+	if err == nil {
+		rs.step, err = valueStep(rs.val, rs.keys)
+	}
+	return err
+}
+
+// Advance the store to state val, unless it is already at a later step.
+func (rs *repairStore) advance(ctx context.Context, val string) error {
+	step, err := valueStep(val, rs.keys)
+	if err != nil {
+		return err
+	}
+	for step > rs.step {
+		_, actual, err := rs.CompareAndSet(ctx, rs.val, val)
+		if err != nil {
+			return err
+		}
+		rs.val = actual
+		if rs.step, err = valueStep(actual, rs.keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the TLC step of a member state, or zero for the initial state.
+func valueStep(val string, keys *encoding.Keyring) (int64, error) {
+	if val == "" {
+		return 0, nil
+	}
+	v, err := encoding.OpenValue([]byte(val), keys)
+	if err != nil {
+		return 0, err
+	}
+	return v.S, nil
+}
@@ -0,0 +1,143 @@
+package qscas
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// A CAS register that retains every version it ever held.
+type historyRegister struct {
+	mut  sync.Mutex
+	vals []string // vals[v-1] is the value at version v
+}
+
+func (r *historyRegister) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	cur := ""
+	if len(r.vals) > 0 {
+		cur = r.vals[len(r.vals)-1]
+	}
+	if old == cur && new != cur {
+		r.vals = append(r.vals, new)
+		cur = new
+	}
+	return int64(len(r.vals)), cur, nil
+}
+
+func (r *historyRegister) ReadAt(ctx context.Context, ver int64) (
+	int64, string, error) {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if ver < 1 || len(r.vals) == 0 {
+		return 0, "", fmt.Errorf("version %v not retained", ver)
+	}
+	if ver > int64(len(r.vals)) {
+		ver = int64(len(r.vals))
+	}
+	return ver, r.vals[ver-1], nil
+}
+
+func (r *historyRegister) ListVersions(ctx context.Context, from, to int64) (
+	[]int64, error) {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var vers []int64
+	for v := from; v <= to && v <= int64(len(r.vals)); v++ {
+		if v >= 1 {
+			vers = append(vers, v)
+		}
+	}
+	return vers, nil
+}
+
+var _ cas.History = (*historyRegister)(nil)
+
+func TestRepair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&historyRegister{}, &historyRegister{},
+		&historyRegister{}}
+	write := func(members []cas.Store, n int) string {
+		g := (&Group{}).Start(ctx, members, 1)
+		old := ""
+		for i := 0; i < n; i++ {
+			new := fmt.Sprintf("write %d", i)
+			for {
+				_, actual, err := g.CompareAndSet(ctx, old, new)
+				if err != nil {
+					t.Fatal(err)
+				}
+				old = actual
+				if actual == new {
+					break
+				}
+			}
+		}
+		return old
+	}
+	last := write(members, 10)
+
+	// Lose member 1's store and rebuild it from the survivors.
+	members[1] = &historyRegister{}
+	donor, err := Repair(ctx, members, 1, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if donor == 1 {
+		t.Fatalf("repaired member from itself")
+	}
+	want, _ := members[donor].(*historyRegister)
+	got, _ := members[1].(*historyRegister)
+	if len(got.vals) == 0 ||
+		got.vals[len(got.vals)-1] != want.vals[len(want.vals)-1] {
+		t.Fatalf("repaired member does not hold the donor's state")
+	}
+	if len(got.vals) < 2 {
+		t.Errorf("repaired member retains no history")
+	}
+	for i := 1; i < len(got.vals); i++ {
+		s0, _ := valueStep(got.vals[i-1], nil)
+		s1, _ := valueStep(got.vals[i], nil)
+		if s1 <= s0 {
+			t.Errorf("repaired history regresses from step %v to %v",
+				s0, s1)
+		}
+	}
+
+	// Repairing a healthy member changes nothing.
+	n := len(got.vals)
+	if _, err := Repair(ctx, members, 1, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.vals) != n {
+		t.Errorf("repair rewrote a healthy member")
+	}
+
+	// The group keeps working with the repaired member,
+	// even when it must rely on that member for a quorum.
+	g := (&Group{}).Start(ctx, []cas.Store{&cas.Register{}, members[1],
+		members[2]}, 1)
+	_, actual, err := g.CompareAndSet(ctx, last, "after repair")
+	if err != nil || actual != "after repair" {
+		t.Errorf("CompareAndSet after repair: %q %v", actual, err)
+	}
+
+	// Repair requires a read quorum of survivors.
+	members[2] = failedStore{}
+	if _, err := Repair(ctx, members, 0, 1, nil); err == nil {
+		t.Errorf("repaired member without a read quorum")
+	}
+}
@@ -0,0 +1,132 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Token is a session token recording a commit that a client has observed,
+// either by writing it or by reading it.
+// Passing a token from one operation to the next,
+// even across different Group or Observer instances,
+// gives an application session consistency:
+// a read that requires a token never returns state
+// older than the commit the token records.
+//
+// Tokens are opaque strings that applications may store or transmit,
+// e.g., in a web session cookie.
+// The empty Token records no commit, and so imposes no requirement.
+//
+type Token string
+
+// ErrToken is returned for a Token that this package did not produce.
+var ErrToken = errors.New("malformed session token")
+
+// ErrStale is returned by operations that do not wait
+// when the state they observed is older than a required Token.
+// It is in the class cas.ErrStale.
+var ErrStale = cas.Classify(cas.ErrStale,
+	errors.New("state older than session token"))
+
+// A Token consists of this prefix followed by the commit version in decimal.
+const tokenPrefix = "qscas:"
+
+// Return a Token recording the commit at version.
+func newToken(version int64) Token {
+	return Token(tokenPrefix + strconv.FormatInt(version, 10))
+}
+
+// Version returns the commit version a Token records,
+// or zero for the empty Token.
+func (t Token) Version() (int64, error) {
+	if t == "" {
+		return 0, nil
+	}
+	s := string(t)
+	if !strings.HasPrefix(s, tokenPrefix) {
+		return 0, ErrToken
+	}
+	ver, err := strconv.ParseInt(s[len(tokenPrefix):], 10, 64)
+	if err != nil || ver < 0 {
+		return 0, ErrToken
+	}
+	return ver, nil
+}
+
+// CompareAndSetToken performs a CompareAndSet operation,
+// additionally returning a Token recording the commit that completed it.
+//
+func (g *Group) CompareAndSetToken(ctx context.Context, old, new string) (
+	version int64, actual string, tok Token, err error) {
+
+	version, actual, err = g.CompareAndSet(ctx, old, new)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return version, actual, newToken(version), nil
+}
+
+// ReadToken reads the group's latest committed state,
+// as CompareAndSet does when new equals old,
+// but returns only state at least as new as the commit tok records,
+// reading repeatedly until the group's state catches up,
+// or until ctx is cancelled or the group's Timeout expires.
+// This way a client whose Group has only just started,
+// and hence may not yet know of the latest commits,
+// still reads its own writes and any state it has already seen.
+// Returns the state read and a Token recording its commit.
+//
+func (g *Group) ReadToken(ctx context.Context, tok Token) (
+	version int64, actual string, rtok Token, err error) {
+
+	want, err := tok.Version()
+	if err != nil {
+		return 0, "", "", err
+	}
+	for {
+		version, actual, err = g.CompareAndSet(ctx, "", "")
+		if err != nil {
+			return 0, "", "", err
+		}
+		if version >= want {
+			return version, actual, newToken(version), nil
+		}
+	}
+}
+
+// LatestToken returns the latest commit the Observer has observed,
+// as Latest does, provided it is at least as new as the commit tok records.
+// Otherwise it returns ErrStale without waiting: see WaitToken.
+//
+func (o *Observer) LatestToken(tok Token) (
+	version int64, actual string, proof Proof, err error) {
+
+	want, err := tok.Version()
+	if err != nil {
+		return 0, "", Proof{}, err
+	}
+	version, actual, proof = o.Latest()
+	if version < want {
+		return 0, "", Proof{}, ErrStale
+	}
+	return version, actual, proof, nil
+}
+
+// WaitToken waits until the Observer observes a commit
+// at least as new as the commit tok records,
+// then returns the latest commit it has observed.
+// Returns an error if either ctx or the Observer's context is cancelled.
+//
+func (o *Observer) WaitToken(ctx context.Context, tok Token) (
+	version int64, actual string, proof Proof, err error) {
+
+	want, err := tok.Version()
+	if err != nil {
+		return 0, "", Proof{}, err
+	}
+	return o.Wait(ctx, want-1)
+}
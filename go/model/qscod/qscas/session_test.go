@@ -0,0 +1,74 @@
+package qscas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+func TestSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{},
+		&cas.Register{}}
+	g := (&Group{}).Start(ctx, members, 1)
+	o := (&Observer{Poll: time.Millisecond}).Start(ctx, members, 1)
+
+	// Write through one client instance...
+	var tok Token
+	for old := ""; old != "x"; {
+		_, val, t1, err := g.CompareAndSetToken(ctx, old, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, tok = val, t1
+	}
+	ver, err := tok.Version()
+	if err != nil || ver <= 0 {
+		t.Fatalf("token %q version %v %v", tok, ver, err)
+	}
+
+	// ...and read our write through another.
+	h := (&Group{}).Start(ctx, members, 1)
+	v, val, t2, err := h.ReadToken(ctx, tok)
+	if err != nil || v < ver || val != "x" {
+		t.Fatalf("read %v %q %v", v, val, err)
+	}
+	if v2, _ := t2.Version(); v2 != v {
+		t.Errorf("read token %q for version %v", t2, v)
+	}
+
+	// Observers report state older than a token as stale,
+	// or wait for it while the group progresses.
+	_, _, _, err = o.LatestToken(newToken(ver + 1000))
+	if err != ErrStale || !cas.Retryable(err) {
+		t.Errorf("LatestToken from the future: %v", err)
+	}
+	wctx, wcancel := context.WithCancel(ctx)
+	go func() {
+		for wctx.Err() == nil {
+			g.CompareAndSet(wctx, "", "")
+		}
+	}()
+	v, val, _, err = o.WaitToken(ctx, tok)
+	wcancel()
+	if err != nil || v < ver || val != "x" {
+		t.Fatalf("observed %v %q %v", v, val, err)
+	}
+	if _, val, _, err := o.LatestToken(tok); err != nil || val != "x" {
+		t.Errorf("LatestToken %q %v", val, err)
+	}
+
+	// The empty token imposes no requirement; others must be well-formed.
+	if _, _, _, err := o.LatestToken(""); err != nil {
+		t.Errorf("LatestToken with empty token: %v", err)
+	}
+	for _, bad := range []Token{"x", "qscas:", "qscas:-1", "qscas:1x"} {
+		if _, _, _, err := h.ReadToken(ctx, bad); err != ErrToken {
+			t.Errorf("ReadToken %q: %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package qscas
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/status"
+)
+
+// Steps in a QSCOD consensus round,
+// within which a healthy member keeps up with the group's latest step.
+const roundSteps = 4
+
+// State a Group tracks for its embedded status page.
+type groupStatus struct {
+	mut     sync.Mutex      // protects the fields below
+	members []memberStatus  // health of each member store
+	commits []status.Commit // most recent commits, oldest first
+}
+
+// Health of one member store, as of our last access to it.
+type memberStatus struct {
+	step  int64     // latest TLC step we read from the member
+	err   error     // error from our last access, or nil
+	seen  bool      // set once we've completed any access to the member
+	since time.Time // start of the access outstanding, if any
+}
+
+// Record the start of an access to member i.
+func (gs *groupStatus) begin(i int) {
+	gs.mut.Lock()
+	defer gs.mut.Unlock()
+
+	gs.members[i].since = time.Now()
+}
+
+// Record the result of an access to member i, at which we read step.
+func (gs *groupStatus) access(i int, step int64, err error) {
+	gs.mut.Lock()
+	defer gs.mut.Unlock()
+
+	gs.members[i] = memberStatus{step: step, err: err, seen: true}
+}
+
+// Record a commit of state p at step s, if we haven't already.
+func (gs *groupStatus) commit(s int64, p string) {
+	gs.mut.Lock()
+	defer gs.mut.Unlock()
+
+	if n := len(gs.commits); n > 0 && s <= gs.commits[n-1].Step {
+		return
+	}
+	_, val := splitState(p)
+	gs.commits = append(gs.commits, status.Commit{Step: s, Value: val})
+	if len(gs.commits) > status.HistoryTail {
+		gs.commits = gs.commits[1:]
+	}
+}
+
+// MemberLatency returns the smoothed latency of the Group's accesses
+// to member store i, or zero if the Group has yet to measure it.
+// It may safely be called at any time, concurrently with the Group's operation.
+func (g *Group) MemberLatency(i int) time.Duration {
+	return g.c.Lat.Of(i)
+}
+
+// Status reports the Group's current state for an embedded status page,
+// implementing status.Reporter.
+// The current step is the latest TLC step read from any member store,
+// and a member is reported healthy if our last access to it succeeded
+// and it was within a consensus round of the current step.
+// Each member's details include the smoothed latency of our accesses to it.
+// It may safely be called at any time, concurrently with the Group's operation.
+//
+func (g *Group) Status() status.Status {
+	st := status.Status{Kind: "qscas.Group"}
+	st.Config = map[string]string{
+		"members":       strconv.Itoa(len(g.members)),
+		"Tr":            strconv.Itoa(g.c.Tr),
+		"Ts":            strconv.Itoa(g.c.Ts),
+		"encrypted":     strconv.FormatBool(g.Keys != nil),
+		"maxConcurrent": strconv.Itoa(g.MaxConcurrent),
+		"maxRate":       strconv.FormatFloat(g.MaxRate, 'g', -1, 64),
+		"aging":         g.Aging.String(),
+	}
+
+	var names []string
+	g.confMut.Lock()
+	if c := g.conf; c != nil {
+		names = c.Members
+		st.Config["epoch"] = strconv.FormatInt(c.Epoch, 10)
+		st.Config["faulty"] = strconv.Itoa(c.Faulty)
+		st.Config["suite"] = c.Suite
+	}
+	g.confMut.Unlock()
+
+	g.stat.mut.Lock()
+	defer g.stat.mut.Unlock()
+
+	for _, m := range g.stat.members {
+		if m.step > st.Step {
+			st.Step = m.step
+		}
+	}
+	for i, m := range g.stat.members {
+		name := fmt.Sprintf("member %d", i)
+		if len(names) == len(g.stat.members) {
+			name += " (" + names[i] + ")"
+		}
+		out := g.excluded(i)
+		p := status.Peer{Name: name,
+			Healthy: m.err == nil && m.step > st.Step-roundSteps &&
+				!out,
+			Detail: fmt.Sprintf("step %d", m.step)}
+		if out {
+			p.Detail += ", excluded"
+		}
+		if lat := g.c.Lat.Of(i); lat > 0 {
+			p.Detail += ", latency " + lat.String()
+		}
+		if m.err != nil {
+			p.Detail += ", error: " + m.err.Error()
+		}
+		st.Peers = append(st.Peers, p)
+	}
+	st.History = append([]status.Commit{}, g.stat.commits...)
+	return st
+}
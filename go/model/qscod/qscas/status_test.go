@@ -0,0 +1,57 @@
+package qscas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/status"
+)
+
+// A member store that has failed.
+type failedStore struct{}
+
+func (failedStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+	return 0, "", errors.New("store failed")
+}
+
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	members := []cas.Store{&cas.Register{}, &cas.Register{}, failedStore{}}
+	g := (&Group{MaxRate: 1000}).Start(ctx, members, 1)
+
+	old := ""
+	for i := 0; i < 2*status.HistoryTail; i++ {
+		new := fmt.Sprintf("v%d", i)
+		if _, val, err := g.CompareAndSet(ctx, old, new); err != nil ||
+			val != new {
+			t.Fatalf("set %q %v", val, err)
+		}
+		old = new
+	}
+
+	st := g.Status()
+	if st.Config["Tr"] != "2" || st.Config["maxRate"] != "1000" {
+		t.Errorf("bad config %v", st.Config)
+	}
+	if len(st.Peers) != 3 || !st.Peers[0].Healthy && !st.Peers[1].Healthy {
+		t.Errorf("no healthy members %v", st.Peers)
+	}
+	if p := st.Peers[2]; p.Healthy || !strings.Contains(p.Detail, "failed") {
+		t.Errorf("failed member reported %+v", p)
+	}
+	if p := st.Peers[0]; !strings.Contains(p.Detail, "latency") {
+		t.Errorf("member latency not reported %+v", p)
+	}
+	h := st.History
+	if len(h) != status.HistoryTail || h[len(h)-1].Value != old ||
+		h[len(h)-1].Step > st.Step {
+		t.Errorf("bad history %v at step %v", h, st.Step)
+	}
+}
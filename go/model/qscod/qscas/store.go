@@ -1,6 +1,9 @@
 package qscas
 
 import (
+	"errors"
+	"time"
+
 	"github.com/dedis/tlc/go/lib/backoff"
 	"github.com/dedis/tlc/go/lib/cas"
 	"github.com/dedis/tlc/go/model/qscod/core"
@@ -10,22 +13,37 @@ import (
 // coreStore implements QSCOD core's native Store interface
 // based on a cas.Store interface.
 type coreStore struct {
-	cas.Store            // underlying CAS state store
-	g         *Group     // group this store is associated with
-	lvals     string     // last value we observed in the underlying Store
-	lval      core.Value // deserialized last value
+	cas.Store                 // underlying CAS state store
+	g         *Group          // group this store is associated with
+	i         int             // index of this store among the group's members
+	lvals     string          // last value we observed in the underlying Store
+	lval      core.Value      // deserialized last value
+	bo        backoff.Backoff // backoff state for retrying this member
+	nonceAt   time.Time       // when we last verified the member's nonce
 }
 
+// errNoAdvance is returned when a member store's compare-and-set
+// fails to advance the TLC step, which a correct Store never does.
+var errNoAdvance = cas.Classify(cas.ErrCorrupt,
+	errors.New("member store failed to advance TLC step"))
+
 func (cs *coreStore) WriteRead(v core.Value) (rv core.Value) {
 
 	try := func() (err error) {
+		if err := cs.g.awaitProbe(cs.g.ctx, cs.i); err != nil {
+			return err
+		}
+		cs.g.stat.begin(cs.i)
 		rv, err = cs.tryWriteRead(v)
+		cs.g.stat.access(cs.i, cs.lval.S, err)
+		cs.g.checkHealth(cs.i, err)
 		return err
 	}
 
 	// Try to perform the atomic operation until it succeeds
 	// or until the group's context gets cancelled.
-	err := backoff.Retry(cs.g.ctx, try)
+	defer cs.g.live.call(cs.i)()
+	err := cs.bo.Retry(cs.g.ctx, try)
 	if err != nil && cs.g.ctx.Err() != nil {
 
 		// The group's context got cancelled,
@@ -35,25 +53,42 @@ func (cs *coreStore) WriteRead(v core.Value) (rv core.Value) {
 		return core.Value{}
 	}
 	if err != nil {
-		panic("backoff.Retry inexplicably gave up: " + err.Error())
+
+		// The backoff policy's Report function chose to give up,
+		// so treat this member as permanently failed:
+		// block until the consensus worker threads terminate.
+		<-cs.g.ctx.Done()
+		return core.Value{}
 	}
 	return rv
 }
 
 func (cs *coreStore) tryWriteRead(val core.Value) (core.Value, error) {
 
-	// Serialize the proposed value
-	valb, err := encoding.EncodeValue(val)
-	if err != nil {
-		println("encoding error", err.Error())
+	// Refuse to use a member that has lost its state
+	if err := cs.checkNonce(); err != nil {
 		return core.Value{}, err
 	}
-	vals := string(valb)
 
 	// Try to set the underlying CAS register to the proposed value
 	// only as long as doing so would strictly increase its TLC step
+	epoch, vals := int64(-1), ""
 	for val.S > cs.lval.S {
 
+		// Serialize the proposed value, tagged with
+		// the latest configuration epoch we know of,
+		// again only if that has changed.
+		if e := cs.g.fence.epoch(); e != epoch {
+			epoch = e
+			valb, err := encoding.SealValue(cs.g.tagConfig(val, e),
+				cs.g.sealKeys())
+			if err != nil {
+				println("encoding error", err.Error())
+				return core.Value{}, err
+			}
+			vals = string(encoding.TagEpoch(valb, e))
+		}
+
 		// Write the serialized value to the underlying CAS interface
 		_, avals, err := cs.CompareAndSet(cs.g.ctx, cs.lvals, vals)
 		if err != nil {
@@ -61,19 +96,29 @@ func (cs *coreStore) tryWriteRead(val core.Value) (core.Value, error) {
 			return core.Value{}, err
 		}
 
+		// Stop if a client of a later configuration epoch wrote it
+		if err := cs.g.fence.check(avals); err != nil {
+			return core.Value{}, err
+		}
+
 		// Deserialize the actual value we read back
-		aval, err := encoding.DecodeValue([]byte(avals))
+		aval, err := encoding.OpenValue([]byte(avals), cs.g.Keys)
 		if err != nil {
 			println("decoding error", err.Error())
 			return core.Value{}, err
 		}
 
+		// Stop if a client with a different configuration wrote it
+		if err := cs.g.checkConfig(cs.i, avals, aval); err != nil {
+			return core.Value{}, err
+		}
+
 		//		println("tryWriteRead step",
 		//			cs.lval.S, "w", val.S, "->", aval.S,
 		//			"casver", cs.lver, "->", aver)
 
 		if aval.S <= cs.lval.S {
-			panic("CAS failed to advance TLC step!")
+			return core.Value{}, errNoAdvance
 		}
 
 		// Update our record of the underlying CAS version and value
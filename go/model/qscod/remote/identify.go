@@ -0,0 +1,97 @@
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// Identifier determines the identity of the client making a request,
+// by which a Server's RFQ engine accounts for the client's requests.
+// The identity must not be the empty string unless the client is anonymous,
+// since the RFQ engine accounts for all anonymous requests together.
+//
+// An identifier is only as strong as what it identifies clients by:
+// a client that can cheaply obtain many identities can claim
+// a correspondingly large share of the server.
+//
+type Identifier func(w http.ResponseWriter, r *http.Request) string
+
+// SourceIP identifies clients by their source IP address,
+// aggregated into prefixes of v4bits for IPv4 and v6bits for IPv6,
+// so that a client controlling a whole block of addresses,
+// such as an IPv6 /64, cannot multiply its identity.
+// For example, SourceIP(24, 48) accounts for each IPv4 /24
+// and each IPv6 /48 as one client.
+//
+// SourceIP trusts the address of the immediate peer,
+// so is unsuitable behind a proxy.
+//
+func SourceIP(v4bits, v6bits int) Identifier {
+	return func(w http.ResponseWriter, r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		a, err := netip.ParseAddr(host)
+		if err != nil {
+			return "ip:" + host
+		}
+		a = a.Unmap().WithZone("")
+		bits := v6bits
+		if a.Is4() {
+			bits = v4bits
+		}
+		if p, err := a.Prefix(bits); err == nil {
+			return "ip:" + p.String()
+		}
+		return "ip:" + a.String()
+	}
+}
+
+// Certificate identifies clients by the hash of the certificate
+// they authenticated the TLS connection with,
+// and identifies clients that presented none via fallback,
+// or as one anonymous client if fallback is nil.
+// The Server's TLS configuration must verify client certificates.
+//
+func Certificate(fallback Identifier) Identifier {
+	return func(w http.ResponseWriter, r *http.Request) string {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			h := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+			return "cert:" + hex.EncodeToString(h[:])
+		}
+		if fallback != nil {
+			return fallback(w, r)
+		}
+		return ""
+	}
+}
+
+// Cookie identifies clients by an anonymous random identifier
+// the Server hands them in the named cookie,
+// which clients present again with later requests
+// if their http.Client has a cookie jar.
+// A client that discards the cookie gets a new identity each request,
+// so cookies distinguish well-behaved clients sharing an address,
+// but do not resist clients that multiply their identities.
+//
+func Cookie(name string) Identifier {
+	return func(w http.ResponseWriter, r *http.Request) string {
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return "cookie:" + c.Value
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic("rand.Read: " + err.Error())
+		}
+		v := base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{Name: name, Value: v, Path: "/",
+			HttpOnly: true})
+		return "cookie:" + v
+	}
+}
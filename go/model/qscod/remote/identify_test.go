@@ -0,0 +1,73 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Return the identity an Identifier assigns a request from addr.
+func identify(id Identifier, addr string) string {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = addr
+	return id(httptest.NewRecorder(), r)
+}
+
+func TestSourceIP(t *testing.T) {
+	id := SourceIP(24, 48)
+	for _, c := range []struct{ a, b string }{
+		{"192.0.2.1:1234", "192.0.2.200:5678"},
+		{"192.0.2.1:1234", "[::ffff:192.0.2.9]:1"},
+		{"[2001:db8:1:2::1]:80", "[2001:db8:1:3::5]:80"},
+	} {
+		if x, y := identify(id, c.a), identify(id, c.b); x != y {
+			t.Errorf("%v is %v but %v is %v", c.a, x, c.b, y)
+		}
+	}
+	for _, c := range []struct{ a, b string }{
+		{"192.0.2.1:1234", "192.0.3.1:1234"},
+		{"[2001:db8:1::1]:80", "[2001:db8:2::1]:80"},
+	} {
+		if x, y := identify(id, c.a), identify(id, c.b); x == y {
+			t.Errorf("%v and %v are both %v", c.a, c.b, x)
+		}
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	id := Certificate(SourceIP(32, 128))
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	anon := id(httptest.NewRecorder(), r)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+		{Raw: []byte("client a")}}}
+	a := id(httptest.NewRecorder(), r)
+	r.TLS.PeerCertificates[0] = &x509.Certificate{Raw: []byte("client b")}
+	b := id(httptest.NewRecorder(), r)
+	if anon != identify(SourceIP(32, 128), r.RemoteAddr) || a == b ||
+		a == anon {
+		t.Errorf("identities %q, %q, %q", anon, a, b)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	id := Cookie("rfq")
+
+	// A client without the cookie gets a fresh identity and the cookie.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	a := id(w, r)
+	if a == "" || a == identify(id, r.RemoteAddr) {
+		t.Fatalf("fresh clients share identity %q", a)
+	}
+
+	// Presenting the cookie again preserves the identity.
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if b := id(httptest.NewRecorder(), r); b != a {
+		t.Errorf("cookie identity %q became %q", a, b)
+	}
+}
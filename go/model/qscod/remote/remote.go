@@ -0,0 +1,205 @@
+// Package remote serves QSCOD key/value Stores over HTTP,
+// so that consensus group members can run as networked store servers
+// shared by many clients on other hosts.
+//
+// Each WriteRead operation is one POST request carrying the encoded Value,
+// whose response carries the encoded Value the backend Store returned.
+// A Server may admit requests via an RFQ admission engine,
+// so that many clients share an overloaded store fairly:
+// requests the server cannot queue are rejected with status 503
+// and an RFQ token in the TokenHeader response header,
+// which the Client presents again in the same header when it resubmits,
+// so that slow clients are not starved by aggressive ones.
+// The Server's Identify function determines which client each request
+// comes from, so that the RFQ engine enforces fairness among clients
+// rather than among connections or requests.
+//
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff"
+	"github.com/dedis/tlc/go/lib/backoff/rfq"
+	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+)
+
+// TokenHeader is the HTTP header carrying RFQ tokens,
+// in rejections from the Server and in resubmissions from the Client.
+const TokenHeader = "Rfq-Token"
+
+// WaitHeader is the HTTP header in which the Server suggests
+// how long the Client should wait before resubmitting a rejected request.
+const WaitHeader = "Rfq-Wait"
+
+// Maximum size of an encoded Value a Server accepts
+const maxRequest = 1 << 20
+
+// Server serves a backend QSCOD Store over HTTP, implementing http.Handler.
+// Set the fields before serving requests.
+//
+type Server struct {
+	Store    core.Store  // Backend Store to serve
+	RFQ      *rfq.Server // Admission engine, or nil to admit every request
+	Identify Identifier  // Client identification, or nil for one anonymous client
+}
+
+// ServeHTTP performs the WriteRead operation a request encodes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "WriteRead requires POST",
+			http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxRequest))
+	if err != nil {
+		return // the client went away
+	}
+	v, err := encoding.DecodeValue(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for admission, or outsource the request's place in line
+	// to the client if there is no room for it here.
+	if s.RFQ != nil {
+		tok, _ := base64.RawURLEncoding.DecodeString(
+			r.Header.Get(TokenHeader))
+		id := ""
+		if s.Identify != nil {
+			id = s.Identify(w, r)
+		}
+		done, err := s.RFQ.AdmitAs(r.Context(), id, tok)
+		var be *rfq.BusyError
+		if errors.As(err, &be) {
+			h := w.Header()
+			h.Set(TokenHeader,
+				base64.RawURLEncoding.EncodeToString(be.Token))
+			h.Set(WaitHeader, be.Wait.String())
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			return // the client went away
+		}
+		defer done()
+	}
+
+	b, err = encoding.EncodeValue(s.Store.WriteRead(v))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
+
+// Client implements a QSCOD key/value Store
+// by forwarding operations to a remote Server.
+//
+type Client struct {
+	ctx  context.Context
+	url  string
+	http *http.Client
+	bo   backoff.Backoff
+}
+
+// Init sets the Client to forward operations to the Server at url,
+// via HTTP client hc, or http.DefaultClient if hc is nil.
+// The Client gives up its operations once ctx is cancelled.
+func (c *Client) Init(ctx context.Context, url string, hc *http.Client) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	c.ctx, c.url, c.http = ctx, url, hc
+}
+
+// SetReport sets the backoff configuration for handling errors
+// that occur while attempting to reach the Server.
+//
+// Since the Server may be down only temporarily,
+// the Client assumes all errors may be transitory, just reports them,
+// and keeps trying the operation after a random exponential backoff.
+// Rejections by an overloaded Server are not errors:
+// the Client waits as the Server suggests and resubmits the operation.
+//
+func (c *Client) SetReport(bc backoff.Config) {
+	c.bo.Config = bc
+}
+
+// WriteRead attempts to write v to the remote store at step v.S,
+// then returns the first value written there by any client,
+// or a value from a higher step if the store has moved beyond v.S.
+// If the Client's context is cancelled, WriteRead returns v.
+// Implements the core.Store interface.
+//
+func (c *Client) WriteRead(v core.Value) core.Value {
+	rv := v
+	try := func() error {
+		return rfq.Retry(c.ctx, func(token []byte) error {
+			val, err := c.tryWriteRead(v, token)
+			if err == nil {
+				rv = val
+			}
+			return err
+		})
+	}
+	c.bo.Retry(c.ctx, try)
+	return rv
+}
+
+func (c *Client) tryWriteRead(v core.Value, token []byte) (core.Value, error) {
+	b, err := encoding.EncodeValue(v)
+	if err != nil {
+		return core.Value{}, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url,
+		bytes.NewReader(b))
+	if err != nil {
+		return core.Value{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token != nil {
+		req.Header.Set(TokenHeader,
+			base64.RawURLEncoding.EncodeToString(token))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return core.Value{}, err
+	}
+	defer resp.Body.Close()
+	b, err = io.ReadAll(io.LimitReader(resp.Body, maxRequest))
+	if err != nil {
+		return core.Value{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return encoding.DecodeValue(b)
+
+	case http.StatusServiceUnavailable:
+		if tok := resp.Header.Get(TokenHeader); tok != "" {
+			be := &rfq.BusyError{}
+			be.Token, err = base64.RawURLEncoding.DecodeString(tok)
+			if err != nil {
+				return core.Value{}, err
+			}
+			be.Wait, _ = time.ParseDuration(resp.Header.Get(WaitHeader))
+			if be.Wait <= 0 {
+				be.Wait = time.Millisecond
+			}
+			return core.Value{}, be
+		}
+	}
+	return core.Value{}, fmt.Errorf("%s: %s: %s", c.url, resp.Status,
+		bytes.TrimSpace(b))
+}
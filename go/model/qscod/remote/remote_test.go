@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/backoff/rfq"
+	"github.com/dedis/tlc/go/model/qscod/core"
+	"github.com/dedis/tlc/go/model/qscod/testsuite"
+)
+
+// Serve a Store via a test HTTP server, returning a Client for it.
+func serve(t *testing.T, h http.Handler) *Client {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	c := &Client{}
+	c.Init(context.Background(), ts.URL, ts.Client())
+	return c
+}
+
+// Create a group of nnode remote Stores backed by in-memory Stores,
+// each admitting requests via a small RFQ engine.
+func newKV(t *testing.T, nnode int) []core.Store {
+	kv := make([]core.Store, nnode)
+	for i := range kv {
+		kv[i] = serve(t, &Server{Store: &testsuite.MemStore{},
+			RFQ:      &rfq.Server{Slots: 1, Queue: 2},
+			Identify: SourceIP(24, 48)})
+	}
+	return kv
+}
+
+func TestRemote(t *testing.T) {
+	testsuite.Battery(t, newKV)
+}
+
+// slowStore delays each operation on a backend Store.
+type slowStore struct {
+	testsuite.MemStore
+}
+
+func (ss *slowStore) WriteRead(v core.Value) core.Value {
+	time.Sleep(time.Millisecond)
+	return ss.MemStore.WriteRead(v)
+}
+
+// Test that an overloaded Server rejects requests it cannot queue,
+// and that Clients resubmit them until they succeed.
+func TestOverload(t *testing.T) {
+	var busy int32
+	srv := &Server{Store: &slowStore{}, RFQ: &rfq.Server{Slots: 1, Queue: 1}}
+	c := serve(t, http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, r)
+		if rec.Code == http.StatusServiceUnavailable {
+			atomic.AddInt32(&busy, 1)
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v := core.Value{S: int64(i), P: "x"}
+			if rv := c.WriteRead(v); rv.S < v.S {
+				t.Errorf("step %v: read back step %v", v.S, rv.S)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&busy) == 0 {
+		t.Errorf("overloaded server rejected no requests")
+	}
+}
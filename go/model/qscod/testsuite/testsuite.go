@@ -0,0 +1,231 @@
+// Package testsuite contains shareable code for testing instantiations
+// of QSCOD, so that each key/value Store implementation can run
+// the same battery of consensus tests without duplicating test code.
+package testsuite
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/chaos"
+	. "github.com/dedis/tlc/go/model/qscod/core"
+)
+
+// The -chaos test flag enables chaos mode with the given seed,
+// or a random seed if negative, inserting random scheduling jitter
+// around each client's Store calls and mutex boundaries.
+// Chaos mode is most effective together with the -race flag.
+var chaosSeed = flag.Int64("chaos", 0,
+	"seed for random scheduling jitter, -1 for random, 0 to disable")
+
+// chaosStore wraps a Store with scheduling jitter around each access.
+type chaosStore struct {
+	Store
+	c *chaos.Chaos
+}
+
+func (cs chaosStore) WriteRead(v Value) Value {
+	cs.c.Point()
+	v = cs.Store.WriteRead(v)
+	cs.c.Point()
+	return v
+}
+
+// Object to record the common total order and verify it for consistency
+type testOrder struct {
+	hist []string   // all history known to be committed so far
+	mut  sync.Mutex // mutex protecting this reference order
+}
+
+// When a client reports a history h has been committed,
+// record that in the testOrder and check it for global consistency.
+func (to *testOrder) committed(t *testing.T, step int64, prop string) {
+	to.mut.Lock()
+	defer to.mut.Unlock()
+
+	// Ensure history slice is long enough
+	for step >= int64(len(to.hist)) {
+		to.hist = append(to.hist, "")
+	}
+
+	// Check commit consistency across all concurrent clients
+	switch {
+	case to.hist[step] == "":
+		to.hist[step] = prop
+	case to.hist[step] != prop:
+		t.Errorf("Inconsistency at %v:\n old %q\n new %q",
+			step, to.hist[step], prop)
+	}
+}
+
+// testCli creates a test client with particular configuration parameters.
+func testCli(t *testing.T, self, f, maxstep, maxpri int, pace, lat bool,
+	conc int, kv []Store, ch *chaos.Chaos, to *testOrder, wg *sync.WaitGroup) {
+
+	// Create a cancelable context for the test run
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Our proposal function simply collects and consistency-checks
+	// committed Heads until a designated time-step is reached.
+	pr := func(step int64, cur string, com bool) (string, int64) {
+		//fmt.Printf("cli %v saw commit %v %q\n", self, C.Step, C.Data)
+
+		// Consistency-check the history h known to be committed
+		if com {
+			to.committed(t, step, cur)
+		}
+
+		// Stop once we reach the step limit
+		if step >= int64(maxstep) {
+			cancel()
+		}
+
+		// Use the simple Int63n for random number generation,
+		// with values constrained to be lower than maxpri for testing.
+		// A real deployment should use cryptographic randomness
+		// and should preferably be high-entropy,
+		// close to the full 64 bits.
+		pri := rand.Int63n(int64(maxpri))
+
+		return fmt.Sprintf("cli %v proposal %v", self, step), pri
+	}
+
+	// Start the test client with appropriate parameters assuming
+	// n=3f, tr=2f, tb=f, and ts=f+1, satisfying TLCB's constraints.
+	c := Client{KV: kv, Tr: 2 * f, Ts: f + 1, Pr: pr, Conc: conc,
+		Yield: ch.Hook()}
+	if pace {
+		c.Pace = &Pacing{}
+	}
+	if lat {
+		c.Lat = &Latency{}
+	}
+	c.Run(ctx)
+
+	wg.Done()
+}
+
+// Run runs a consensus test case on a given set of Store interfaces
+// and with the specified group configuration and test parameters.
+func Run(t *testing.T, kv []Store, nfail, ncli, maxstep, maxpri int) {
+	run(t, kv, nfail, ncli, maxstep, maxpri, false, false, 0)
+}
+
+// Run a consensus test case with or without client pacing
+// and latency-aware scheduling, with conc WriteRead calls in flight
+// to each Store, and return the number of rounds observed to commit.
+func run(t *testing.T, kv []Store, nfail, ncli, maxstep, maxpri int,
+	pace, lat bool, conc int) (commits int) {
+
+	// Create a reference total order for safety checking
+	to := &testOrder{}
+
+	// In chaos mode, perturb scheduling around every Store access.
+	ch := chaos.New(*chaosSeed, 0)
+	if ch != nil {
+		ckv := make([]Store, len(kv))
+		for i := range kv {
+			ckv[i] = chaosStore{kv[i], ch}
+		}
+		kv = ckv
+	}
+
+	desc := fmt.Sprintf("F=%v,N=%v,Clients=%v,Commits=%v,Tickets=%v",
+		nfail, len(kv), ncli, maxstep, maxpri)
+	if pace {
+		desc += ",Paced"
+	}
+	if lat {
+		desc += ",Latency"
+	}
+	if conc > 1 {
+		desc += fmt.Sprintf(",Conc=%v", conc)
+	}
+	if ch != nil {
+		desc += fmt.Sprintf(",Chaos=%v", ch.Seed())
+	}
+	t.Run(desc, func(t *testing.T) {
+
+		// Simulate the appropriate number of concurrent clients
+		wg := &sync.WaitGroup{}
+		for i := 0; i < ncli; i++ {
+			wg.Add(1)
+			go testCli(t, i, nfail, maxstep, maxpri, pace, lat,
+				conc, kv, ch, to, wg)
+		}
+		wg.Wait()
+	})
+
+	to.mut.Lock()
+	defer to.mut.Unlock()
+	for _, prop := range to.hist {
+		if prop != "" {
+			commits++
+		}
+	}
+	return commits
+}
+
+// Config describes the group configuration and parameters
+// of one consensus test case for Run.
+type Config struct {
+	Fail    int // number of failures to tolerate
+	Nodes   int // number of nodes, and hence Stores, in the group
+	Clients int // number of concurrent clients
+	Steps   int // number of time-steps each client runs
+	MaxPri  int // exclusive upper bound on random proposal priorities
+}
+
+// Standard is the standard battery of consensus test cases,
+// moderate enough in size for Stores that keep their state on disk.
+// Note: when nodes * clients gets to be around 120-ish,
+// file system Stores start running into default max-open-file limits.
+var Standard = []Config{
+	{1, 3, 1, 10, 100}, // Standard f=1 case,
+	{1, 3, 2, 10, 100}, // varying number of clients
+	{1, 3, 10, 3, 100},
+	{1, 3, 20, 2, 100},
+	{1, 3, 40, 2, 100},
+
+	{2, 6, 10, 5, 100}, // Standard f=2 case
+	{3, 9, 10, 3, 100}, // Standard f=3 case
+}
+
+// Battery runs each of the consensus test cases in configs,
+// calling newKV to create a fresh group of nnode Stores for each case.
+// If configs is empty, Battery runs the Standard test cases.
+func Battery(t *testing.T, newKV func(t *testing.T, nnode int) []Store,
+	configs ...Config) {
+
+	if len(configs) == 0 {
+		configs = Standard
+	}
+	for _, c := range configs {
+		Run(t, newKV(t, c.Nodes), c.Fail, c.Clients, c.Steps, c.MaxPri)
+	}
+}
+
+// MemStore is a trivial intra-process key/value Store implementation,
+// useful as a reference when testing consensus clients.
+type MemStore struct {
+	mut sync.Mutex // synchronization for MemStore state
+	v   Value      // the latest value written
+}
+
+// WriteRead implements the Store interface with a simple intra-process map.
+func (ms *MemStore) WriteRead(v Value) Value {
+	ms.mut.Lock()
+	defer ms.mut.Unlock()
+
+	// Write value v only if it's newer than the last value written.
+	if v.S > ms.v.S {
+		ms.v = v
+	}
+
+	// Then return whatever was last written, regardless.
+	return ms.v
+}
@@ -0,0 +1,189 @@
+package testsuite
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/dedis/tlc/go/model/qscod/core"
+)
+
+// Create a group of nnode in-memory key/value Stores.
+func memKV(t *testing.T, nnode int) []Store {
+	kv := make([]Store, nnode)
+	for i := range kv {
+		kv[i] = &MemStore{}
+	}
+	return kv
+}
+
+// Test the Client with a trivial in-memory key/value Store implementation.
+func TestClient(t *testing.T) {
+	Battery(t, memKV,
+		Config{1, 3, 1, 100000, 100}, // Standard f=1 case
+		Config{1, 3, 2, 100000, 100},
+		Config{1, 3, 10, 100000, 100},
+		Config{1, 3, 20, 100000, 100},
+		Config{1, 3, 50, 100000, 100},
+		Config{1, 3, 100, 100000, 100},
+
+		Config{2, 6, 10, 100000, 100},  // Standard f=2 case
+		Config{3, 9, 10, 100000, 100},  // Standard f=3 case
+		Config{4, 12, 10, 100000, 100}, // Standard f=4 case
+		Config{5, 15, 10, 100000, 100}, // Standard f=10 case
+
+		// Test with low-entropy tickets:
+		// hurts commit rate, but still safe!
+		Config{1, 3, 10, 100000, 2}, // Extreme low-entropy
+		Config{1, 3, 10, 100000, 3}, // A bit better bit still bad...
+	)
+}
+
+// Test the Standard battery with the in-memory key/value Store.
+func TestStandard(t *testing.T) {
+	Battery(t, memKV)
+}
+
+// jitterStore is an in-memory Store with random access delays,
+// so that concurrent clients' writes win at different nodes
+// as they would over a real network.
+type jitterStore struct {
+	MemStore
+}
+
+func (js *jitterStore) WriteRead(v Value) Value {
+	time.Sleep(time.Duration(rand.Int63n(int64(100 * time.Microsecond))))
+	return js.MemStore.WriteRead(v)
+}
+
+// Create a group of nnode in-memory key/value Stores with jitter.
+func jitterKV(t *testing.T, nnode int) []Store {
+	kv := make([]Store, nnode)
+	for i := range kv {
+		kv[i] = &jitterStore{}
+	}
+	return kv
+}
+
+// Test that client pacing improves the commit rate
+// when many clients contend with low-entropy priorities.
+func TestPacing(t *testing.T) {
+	const steps = 2000
+	unpaced := run(t, jitterKV(t, 9), 3, 20, steps, 4, false, false, 0)
+	paced := run(t, jitterKV(t, 9), 3, 20, steps, 4, true, false, 0)
+	t.Logf("commits: unpaced %v, paced %v", unpaced, paced)
+	if paced <= unpaced {
+		t.Errorf("pacing did not improve commits: unpaced %v, paced %v",
+			unpaced, paced)
+	}
+}
+
+// Test that clients pipelining their accesses to each Store remain consistent,
+// both alone and together with latency-aware scheduling.
+func TestConcurrency(t *testing.T) {
+	run(t, jitterKV(t, 3), 1, 10, 1000, 100, false, false, 4)
+	run(t, jitterKV(t, 9), 3, 10, 1000, 100, false, true, 4)
+}
+
+// slowStore is an in-memory Store with a fixed access latency,
+// like a distant member of a group whose other members are nearby.
+type slowStore struct {
+	MemStore
+	n int32 // number of accesses so far
+}
+
+func (ss *slowStore) WriteRead(v Value) Value {
+	atomic.AddInt32(&ss.n, 1)
+	time.Sleep(time.Millisecond)
+	return ss.MemStore.WriteRead(v)
+}
+
+// Test that latency-aware scheduling keeps a slow Store caught up,
+// instead of leaving its worker to write every step the group completed.
+func TestLatency(t *testing.T) {
+	slow := &slowStore{}
+	kv := []Store{&MemStore{}, &MemStore{}, slow}
+	run(t, kv, 1, 1, 1000, 100, false, true, 0)
+
+	// The slow Store's worker terminates after at most one more access,
+	// instead of working through a backlog of completed steps.
+	n := atomic.LoadInt32(&slow.n)
+	time.Sleep(10 * time.Millisecond)
+	if m := atomic.LoadInt32(&slow.n); m > n+1 {
+		t.Errorf("slow store accessed %v times after run, %v during",
+			m-n, n)
+	}
+}
+
+// Test that latency-aware scheduling prefers Stores spanning failure domains,
+// keeping a slow Store alone in its domain as up to date as the fast ones.
+func TestDomains(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := &slowStore{}
+	pr := func(step int64, cur string, com bool) (string, int64) {
+		if step >= 200 && atomic.LoadInt32(&slow.n) > 2 {
+			cancel() // once the slow Store's latency is known
+		}
+		return cur, rand.Int63n(100)
+	}
+	c := Client{KV: []Store{&MemStore{}, &MemStore{}, slow}, Tr: 2, Ts: 2,
+		Pr: pr, Lat: &Latency{Domain: []int{0, 0, 1}}}
+	c.Run(ctx)
+	if c.Lat.Of(2) <= c.Lat.Of(0) {
+		t.Errorf("slow store latency %v, fast %v",
+			c.Lat.Of(2), c.Lat.Of(0))
+	}
+
+	// The slow Store's worker continues through every step,
+	// unlike in TestLatency, where the Store shares its domain.
+	n := atomic.LoadInt32(&slow.n)
+	time.Sleep(10 * time.Millisecond)
+	if m := atomic.LoadInt32(&slow.n); m < n+3 {
+		t.Errorf("slow store accessed %v times after run, %v during",
+			m-n, n)
+	}
+}
+
+// Test that the Commits channel delivers the commits Pr observes, in order.
+func TestCommits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	to := &testOrder{}
+	pr := func(step int64, cur string, com bool) (string, int64) {
+		if com {
+			to.committed(t, step, cur)
+		}
+		return fmt.Sprintf("proposal %v", step), rand.Int63n(100)
+	}
+	c := Client{KV: memKV(t, 3), Tr: 2, Ts: 2, Pr: pr}
+	ch := c.Commits()
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	// Consume commits slowly, so that the Client must queue them.
+	last, n := int64(0), 0
+	for com := range ch {
+		if com.Step <= last {
+			t.Errorf("commit at step %v after %v", com.Step, last)
+		}
+		to.committed(t, com.Step, com.Data)
+		last, n = com.Step, n+1
+		if n == 100 {
+			cancel()
+		}
+		time.Sleep(10 * time.Microsecond)
+	}
+	<-done
+	if n < 100 {
+		t.Errorf("received only %v commits", n)
+	}
+}
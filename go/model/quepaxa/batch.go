@@ -0,0 +1,185 @@
+package quepaxa
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+// Batch is the application data a Batcher proposes for agreement:
+// a sequence of application commands of type C,
+// together with a random identifier that lets the Batcher recognize
+// whether a decided batch was its own proposal or some other proposer's.
+type Batch[C any] struct {
+	ID   uint64 // Random batch identifier
+	Cmds []C    // Application commands in this batch
+}
+
+// Batcher groups application commands into batches,
+// each of which it proposes for agreement as a single proposal,
+// and keeps several agreements in flight concurrently (pipelining).
+//
+// A Batcher pipelines agreements across a fixed number of lanes.
+// Each lane is an independent Proposer with its own set of replicas,
+// responsible for every k-th slot of the Batcher's global decision sequence,
+// where k is the number of lanes.
+// Agreements in consecutive slots are started in order,
+// but proceed concurrently and may be decided in any order.
+// The Batcher nevertheless delivers decided batches strictly in slot order.
+//
+// Commands whose batch was not the one decided in its slot,
+// because some other proposer's batch won instead,
+// are returned to the front of the pending queue to be proposed again.
+//
+// MaxBatch, if nonzero, limits the number of commands in each batch.
+// It must not be changed after calling Init.
+//
+type Batcher[C any] struct {
+	MaxBatch int // Maximum number of commands per batch
+
+	lane    []Proposer[BasicProposal[Batch[C]]] // one proposer per lane
+	deliver func(slot int64, cmds []C)          // delivery function
+
+	mut  sync.Mutex     // protects the following state
+	cond sync.Cond      // signals changes in the state below
+	wg   sync.WaitGroup // counts running lane goroutines
+
+	pending    []C           // commands waiting to be proposed
+	start      int64         // next slot to start agreement on
+	next       int64         // next slot to deliver
+	decided    map[int64][]C // decided slots awaiting delivery
+	delivering bool          // a lane goroutine is calling deliver
+	stop       bool          // set once the Batcher is stopped
+}
+
+// Init starts a Batcher running with one pipeline lane per replica set,
+// so the number of replica sets determines the pipelining depth.
+//
+// The Batcher calls deliver with the commands of each decided batch,
+// in slot order starting from slot zero, from one goroutine at a time.
+// The deliver function may call Submit but must not call Stop.
+//
+func (b *Batcher[C]) Init(lanes [][]Replica[BasicProposal[Batch[C]]],
+	deliver func(slot int64, cmds []C)) {
+
+	if b.lane != nil {
+		panic("Batcher.Init must not be invoked twice")
+	}
+	if len(lanes) == 0 {
+		panic("Batcher needs at least one lane")
+	}
+
+	b.lane = make([]Proposer[BasicProposal[Batch[C]]], len(lanes))
+	b.deliver = deliver
+	b.cond.L = &b.mut
+	b.decided = make(map[int64][]C)
+
+	for i := range lanes {
+		b.lane[i].Init(lanes[i])
+		b.wg.Add(1)
+		go b.run(i)
+	}
+}
+
+// Submit queues application commands to be proposed in a future batch.
+func (b *Batcher[C]) Submit(cmds ...C) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.pending = append(b.pending, cmds...)
+	b.cond.Broadcast()
+}
+
+// Stop permanently shuts down the Batcher and all its lanes,
+// abandoning any pending or in-flight commands.
+func (b *Batcher[C]) Stop() {
+	b.mut.Lock()
+	b.stop = true
+	b.cond.Broadcast()
+	b.mut.Unlock()
+
+	for i := range b.lane {
+		b.lane[i].Stop()
+	}
+	b.wg.Wait()
+}
+
+// Run the agreement pipeline lane number i,
+// which is responsible for slots i, i+k, i+2k, etc.
+func (b *Batcher[C]) run(i int) {
+	defer b.wg.Done()
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	k := int64(len(b.lane))
+	for slot := int64(i); ; slot += k {
+
+		// Wait until it's our turn to start the next slot
+		// and there is some work to be done.
+		for !b.stop && (b.start != slot || len(b.pending) == 0) {
+			b.cond.Wait()
+		}
+		if b.stop {
+			return
+		}
+
+		// Take the next batch of pending commands.
+		n := len(b.pending)
+		if b.MaxBatch > 0 && n > b.MaxBatch {
+			n = b.MaxBatch
+		}
+		batch := Batch[C]{ID: batchID(), Cmds: b.pending[:n:n]}
+		b.pending = b.pending[n:]
+		b.start++
+		b.cond.Broadcast()
+
+		// Agree on a batch for this slot with our mutex unlocked.
+		b.mut.Unlock()
+		_, d := b.lane[i].Agree(BasicProposal[Batch[C]]{D: batch})
+		b.mut.Lock()
+		if b.stop {
+			return
+		}
+
+		// If some other proposer's batch won in this slot,
+		// then we'll have to try again to get our commands in.
+		if d.D.ID != batch.ID {
+			b.pending = append(batch.Cmds, b.pending...)
+			b.cond.Broadcast()
+		}
+
+		// Record the decision and deliver whatever we can in order.
+		b.decided[slot] = d.D.Cmds
+		for !b.delivering {
+			cmds, ok := b.decided[b.next]
+			if !ok {
+				break
+			}
+			delete(b.decided, b.next)
+			s := b.next
+			b.next++
+
+			b.delivering = true
+			b.mut.Unlock()
+			b.deliver(s, cmds)
+			b.mut.Lock()
+			b.delivering = false
+		}
+	}
+}
+
+// Generate a random nonzero batch identifier.
+func batchID() uint64 {
+	var b [8]byte
+	for {
+		_, err := rand.Read(b[:])
+		if err != nil {
+			panic("unable to read cryptographically random bits: " +
+				err.Error())
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
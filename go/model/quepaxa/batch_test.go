@@ -0,0 +1,76 @@
+package quepaxa
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Run a Batcher with a given pipeline depth and group size,
+// and check that every submitted command is delivered once and in order.
+func testBatcher(t *testing.T, nlanes, nreplicas, ncmds, maxBatch int) {
+	desc := fmt.Sprintf("Lanes=%v,Replicas=%v,Commands=%v,MaxBatch=%v",
+		nlanes, nreplicas, ncmds, maxBatch)
+	t.Run(desc, func(t *testing.T) {
+
+		// Create a set of persistent recorders for each lane
+		lanes := make([][]Replica[BasicProposal[Batch[int]]], nlanes)
+		for i := range lanes {
+			lanes[i] = make([]Replica[BasicProposal[Batch[int]]],
+				nreplicas)
+			for j := range lanes[i] {
+				r := &Recorder[BasicProposal[Batch[int]]]{}
+				r.Init(&cas.Register{})
+				lanes[i][j] = r
+			}
+		}
+
+		// Collect delivered commands and check their order
+		var mut sync.Mutex
+		var got []int
+		var lastSlot int64 = -1
+		done := make(chan struct{})
+		deliver := func(slot int64, cmds []int) {
+			mut.Lock()
+			defer mut.Unlock()
+
+			if slot != lastSlot+1 {
+				t.Errorf("slot %v delivered after %v", slot, lastSlot)
+			}
+			lastSlot = slot
+			if maxBatch > 0 && len(cmds) > maxBatch {
+				t.Errorf("batch of %v exceeds max %v",
+					len(cmds), maxBatch)
+			}
+			got = append(got, cmds...)
+			if len(got) == ncmds {
+				close(done)
+			}
+		}
+
+		b := &Batcher[int]{MaxBatch: maxBatch}
+		b.Init(lanes, deliver)
+		for i := 0; i < ncmds; i++ {
+			b.Submit(i)
+		}
+		<-done
+		b.Stop()
+
+		for i := range got {
+			if got[i] != i {
+				t.Fatalf("command %v delivered at position %v",
+					got[i], i)
+			}
+		}
+	})
+}
+
+func TestBatcher(t *testing.T) {
+	testBatcher(t, 1, 3, 100, 1) // No batching or pipelining
+	testBatcher(t, 1, 3, 1000, 10)
+	testBatcher(t, 4, 3, 1000, 1) // Pipelining without batching
+	testBatcher(t, 4, 3, 10000, 10)
+	testBatcher(t, 8, 5, 10000, 0) // Unlimited batch size
+}
@@ -64,6 +64,10 @@ func (p *Proposer[P]) Init(replicas []Replica[P]) {
 	// set up a cancelable context for when we want to stop
 	p.ctx, p.cancel = context.WithCancel(context.Background())
 
+	// workers and the agreement thread wait on a condition variable
+	p.c.L = &p.m
+	p.ld = -1 // initially leaderless
+
 	// set the threshold appropriately for group size
 	p.th = len(replicas)/2 + 1
 
@@ -77,19 +81,25 @@ func (p *Proposer[P]) Init(replicas []Replica[P]) {
 	}
 }
 
+// Agree proposes preferred for the proposer's next undecided choice,
+// waits until a decision is reached, and returns the choice and decision.
+//
+// If other proposers have already moved the replicas on to a later choice,
+// the proposer catches up and helps decide that later choice instead,
+// in which case preferred might not have been proposed at all.
+// The choice returned may therefore skip choices this proposer never saw.
+// Agree also returns when the proposer is stopped, with meaningless results.
 func (p *Proposer[P]) Agree(preferred P) (choice Choice, decision P) {
 
 	// keep our mutex locked except while waiting on a condition
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	c := p.t.c
 	if p.t.s < 4 {
 		p.advance(Time{p.t.c, 4}, preferred)
 	}
-	for !p.stop && p.t.c == c {
-		// signal any non-busy workers that there's new work to do
-		p.c.Broadcast()
+	for !p.stop && p.t.s != 0 {
+		p.c.Wait() // wait for the workers to reach a decision
 	}
 
 	// return choice at which last decision was made, and that decision
@@ -107,6 +117,9 @@ func (p *Proposer[P]) advance(t Time, pp P) {
 	if t.s == 4 { // only when advancing to fast-path step...
 		p.nf = 0 // initialize fast-path response count
 	}
+
+	// signal any non-busy workers that there's new work to do
+	p.c.Broadcast()
 }
 
 // Each worker thread calls workDone when it gets a response from a recorder.
@@ -117,7 +130,8 @@ func (p *Proposer[P]) workDone(rt Time, rf, rl P) {
 
 	// When we receive fast-path responses from phase 4 of current choice,
 	// count them towards the fast-path threshold even if they come late.
-	if rt.c == p.t.c && rt.s == 4 {
+	// Only a leader's proposal can be decided on the fast path.
+	if rt.c == p.t.c && rt.s == 4 && p.ld >= 0 {
 		p.nf++
 		if p.nf == p.th {
 			p.decided(rf) // fast-path decision
@@ -189,6 +203,8 @@ func (p *Proposer[P]) SetLeader(leader Node) {
 
 // Stop permanently shuts down this proposer and its worker threads.
 func (p *Proposer[P]) Stop() {
+	p.m.Lock()
+	defer p.m.Unlock()
 
 	p.stop = true   // signal that workers should stop
 	p.c.Broadcast() // wake them up to see the signal
@@ -203,14 +219,20 @@ type worker[P Proposal[P]] struct {
 }
 
 func (w *worker[P]) work() {
-	p := w.p // keep handy pointer back to proposer
+	p := w.p    // keep handy pointer back to proposer
+	var lt Time // last time step we recorded a proposal at
 	p.m.Lock()
 	for !p.stop {
 		// we're done with prior steps so wait until proposer advances
-		t := p.t // save proposer's current time
-		for p.t == t {
+		// to a time step we haven't yet recorded and that isn't idle
+		for !p.stop && (p.t == lt || p.t.s == 0) {
 			p.c.Wait()
 		}
+		if p.stop {
+			break
+		}
+		t := p.t // save proposer's current time
+		lt = t
 
 		pp := p.pp      // save proposer's preferred proposal
 		if t.s&3 == 0 { // in phase zero we must re-rank proposals
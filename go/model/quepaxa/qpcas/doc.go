@@ -0,0 +1,9 @@
+// Package qpcas provides an implementation of QuePaxa consensus
+// that both builds on, and provides, a Check-and-Set (CAS) Store interface
+// as defined by the tlc/go/lib/cas package.
+//
+// It mirrors the qscas package, which provides the same interface via QSCOD,
+// so that applications can switch between the two consensus algorithms
+// without changing their calling code.
+//
+package qpcas
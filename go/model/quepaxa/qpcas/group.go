@@ -0,0 +1,122 @@
+package qpcas
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/model/quepaxa"
+)
+
+type proposal = quepaxa.BasicProposal[string]
+
+// Group implements the cas.Store interface as a QuePaxa consensus group.
+// After creation, invoke Start to configure the consensus group state,
+// then call CompareAndSet to perform CAS operations on the logical state.
+//
+// The group's logical state is the value decided in the latest choice,
+// and its version number is that choice number plus one,
+// so that the initial empty state before any decision is version zero.
+//
+type Group struct {
+	p   quepaxa.Proposer[proposal] // consensus proposer
+	ctx context.Context            // group operation context
+
+	mut sync.Mutex // serializes agreements on the proposer
+	ver int64      // version number of the latest decision we know
+	val string     // value of the latest decision we know
+}
+
+// Start initializes g to represent a consensus group comprised of
+// particular member nodes, starts it operating, and returns g.
+//
+// Each member's cas.Store holds the persistent recorder state
+// of one QuePaxa replica, as maintained by quepaxa.Recorder.
+//
+// The faulty parameter is the maximum number of faulty nodes
+// the group should tolerate, which must be less than half the group size.
+// If faulty < 0, it is set to the maximum the group size allows.
+//
+// Start launches worker goroutines that help service CAS requests,
+// which will run and consume resources forever unless cancelled.
+// To define their lifetime, the caller should pass a cancelable context,
+// and cancel it when operations on the Group are no longer required.
+//
+func (g *Group) Start(ctx context.Context, members []cas.Store, faulty int) *Group {
+
+	// Sanity-check the threshold configuration parameters.
+	N := len(members)
+	if err := CheckThreshold(N, faulty); err != nil {
+		panic(err.Error())
+	}
+
+	// Create a persistent recorder on each cas.Store group member
+	replicas := make([]quepaxa.Replica[proposal], N)
+	for i := range members {
+		r := &quepaxa.Recorder[proposal]{}
+		r.Init(members[i])
+		replicas[i] = r
+	}
+
+	g.ctx = ctx
+	g.p.Init(replicas)
+
+	// Stop the proposer's workers when our context gets cancelled.
+	go func() {
+		<-ctx.Done()
+		g.p.Stop()
+	}()
+
+	return g
+}
+
+// CheckThreshold returns an error if a QuePaxa consensus group
+// of N members cannot safely tolerate faulty failed members.
+// A negative faulty value selects the maximum the group size allows.
+func CheckThreshold(N, faulty int) error {
+	if faulty < 0 {
+		faulty = (N - 1) / 2 // Default fault tolerance threshold
+	}
+	if N == 0 || 2*faulty >= N {
+		return errors.New("faulty threshold yields unsafe configuration")
+	}
+	return nil
+}
+
+// CompareAndSet conditionally writes a new version and reads the latest,
+// implementing the cas.Store interface.
+//
+// Each call runs one agreement on the group's next choice.
+// If the latest state we know of is old, we propose new for this choice;
+// otherwise we propose the latest known state again as a no-op.
+// Either way, the decision becomes the new latest state we return.
+// If other clients have moved the group ahead, we first catch up,
+// and our proposal for an obsolete choice is never decided.
+//
+func (g *Group) CompareAndSet(ctx context.Context, old, new string) (
+	version int64, actual string, err error) {
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	if err := g.ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
+	prop := g.val // no-op proposal unless our CAS may succeed
+	if g.val == old {
+		prop = new
+	}
+
+	c, d := g.p.Agree(proposal{D: prop})
+	if err := g.ctx.Err(); err != nil {
+		return 0, "", err // proposer stopped before deciding
+	}
+
+	g.ver, g.val = int64(c)+1, d.D
+	return g.ver, g.val, nil
+}
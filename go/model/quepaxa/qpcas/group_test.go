@@ -0,0 +1,34 @@
+package qpcas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+)
+
+// Start a Group client on the specified members.
+func start(ctx context.Context, members []cas.Store, faulty int) cas.Store {
+	return (&Group{}).Start(ctx, members, faulty)
+}
+
+//  Run a consensus test case with the specified parameters.
+func testRun(t *testing.T, nfail, nnode, nclients, nthreads, naccesses int) {
+	test.Groups(t, test.Registers, start, test.GroupConfig{
+		Faulty: nfail, Nodes: nnode, Clients: nclients,
+		Threads: nthreads, Accesses: naccesses})
+}
+
+// Test the Group with trivial in-memory CAS registers as members.
+func TestGroup(t *testing.T) {
+	testRun(t, 1, 3, 1, 1, 1000) // Standard f=1 case
+	testRun(t, 1, 3, 2, 1, 1000)
+	testRun(t, 1, 3, 10, 1, 100)
+	testRun(t, 1, 3, 10, 10, 10)
+
+	testRun(t, 2, 5, 10, 10, 10) // Standard f=2 case
+	testRun(t, 3, 7, 10, 10, 10) // Standard f=3 case
+
+	testRun(t, -1, 4, 10, 10, 10) // Default threshold, even group size
+}
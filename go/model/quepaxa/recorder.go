@@ -0,0 +1,116 @@
+package quepaxa
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+// Recorder implements the Replica interface as a durable
+// interval summary register (ISR) whose state lives in a cas.Store.
+//
+// Each Record call applies exactly the state transition of ISR.Record,
+// then atomically commits the resulting register state to the Store
+// via CompareAndSet before returning anything to the proposer.
+// A Recorder thus never reveals recorder state that could be lost on a crash.
+// If the Store holds a persistent register, such as a casdir.Store,
+// then the recorder's state survives restarts:
+// a freshly-initialized Recorder on the same Store recovers the state
+// from the Store the first time it attempts to commit a new state.
+// Several Recorder instances may even share a single Store concurrently,
+// since conflicting updates simply cause CompareAndSet to fail and retry.
+//
+// Register state is serialized using encoding/json,
+// so the proposal type P must be JSON-serializable.
+//
+type Recorder[P Proposal[P]] struct {
+	st cas.Store // underlying persistent state store
+
+	mut  sync.Mutex // serializes Record calls on this instance
+	isr  ISR[P]     // last register state read from or written to st
+	isrs string     // serialized form of isr as it appears in st
+}
+
+// isrState is the serialized representation of an ISR.
+type isrState[P any] struct {
+	C    Choice // choice number of current logical time
+	S    Step   // step number of current logical time
+	F, A P      // first and aggregate values in this step
+	L    P      // aggregate values seen in last step
+}
+
+// Init sets up a Recorder to keep its register state in a given Store.
+func (r *Recorder[P]) Init(st cas.Store) {
+	r.st = st
+	r.isr = ISR[P]{}
+	r.isrs = ""
+}
+
+// Record implements the Replica interface for a persistent Recorder.
+//
+// If the underlying Store returns an error,
+// Record returns that error and the caller must disregard the other results,
+// which may not have been committed to the Store.
+func (r *Recorder[P]) Record(ctx context.Context, t Time, p P) (
+	rt Time, rf P, rl P, err error) {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	for {
+		// Apply the ISR state transition to a copy of our cached state.
+		isr := r.isr
+		rt, rf, rl = isr.Record(t, p)
+
+		new, err := encodeISR(&isr)
+		if err != nil {
+			return rt, rf, rl, err
+		}
+
+		// Try to commit the new register state atomically.
+		// Even if the state is unchanged, this confirms that
+		// our cached state is still current before we return it.
+		_, actual, err := r.st.CompareAndSet(ctx, r.isrs, new)
+		if err != nil {
+			return rt, rf, rl, err
+		}
+		if actual == new {
+			r.isr, r.isrs = isr, new
+			return rt, rf, rl, nil // success
+		}
+
+		// Someone else changed the stored state since we last looked,
+		// or we just restarted and are discovering the recovered state.
+		// Catch up to the actual state and try again.
+		if err := decodeISR(actual, &r.isr); err != nil {
+			return rt, rf, rl, err
+		}
+		r.isrs = actual
+	}
+}
+
+// Serialize the state of an ISR into a string for storage.
+func encodeISR[P Proposal[P]](r *ISR[P]) (string, error) {
+	b, err := json.Marshal(&isrState[P]{r.t.c, r.t.s, r.f, r.a, r.l})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Deserialize the state of an ISR from its stored representation.
+// The empty string represents the initial ISR state.
+func decodeISR[P Proposal[P]](s string, r *ISR[P]) error {
+	if s == "" {
+		*r = ISR[P]{}
+		return nil
+	}
+	var st isrState[P]
+	if err := json.Unmarshal([]byte(s), &st); err != nil {
+		return err
+	}
+	*r = ISR[P]{t: Time{st.C, st.S}, f: st.F, a: st.A, l: st.L}
+	return nil
+}
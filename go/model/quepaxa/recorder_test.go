@@ -0,0 +1,74 @@
+package quepaxa
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/dedis/tlc/go/lib/cas"
+)
+
+type testProposal = BasicProposal[string]
+
+// Generate a random logical time and proposal to record.
+func testRecordArgs(i int) (Time, testProposal) {
+	t := Time{Choice(rand.Intn(3)), Step(rand.Intn(8))}
+	p := testProposal{R: uint32(rand.Intn(10)), N: Node(rand.Intn(3)),
+		D: string(rune('a' + i%26))}
+	return t, p
+}
+
+// Check that a Recorder follows exactly the same state transitions
+// as an in-memory ISR, including across simulated restarts.
+func TestRecorder(t *testing.T) {
+	bg := context.Background()
+	st := &cas.Register{}
+	isr := &ISR[testProposal]{}
+	rec := &Recorder[testProposal]{}
+	rec.Init(st)
+
+	for i := 0; i < 10000; i++ {
+
+		// Occasionally simulate a restart of the recorder,
+		// which must recover its state from the persistent store.
+		if rand.Intn(100) == 0 {
+			rec = &Recorder[testProposal]{}
+			rec.Init(st)
+		}
+
+		tm, p := testRecordArgs(i)
+		et, ef, el := isr.Record(tm, p)
+		rt, rf, rl, err := rec.Record(bg, tm, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt != et || rf != ef || rl != el {
+			t.Errorf("Record(%v, %v): got %v %v %v, want %v %v %v",
+				tm, p, rt, rf, rl, et, ef, el)
+		}
+	}
+}
+
+// Check that several Recorder instances sharing a Store remain consistent.
+func TestRecorderShared(t *testing.T) {
+	bg := context.Background()
+	st := &cas.Register{}
+	isr := &ISR[testProposal]{}
+	rec := make([]Recorder[testProposal], 3)
+	for i := range rec {
+		rec[i].Init(st)
+	}
+
+	for i := 0; i < 10000; i++ {
+		tm, p := testRecordArgs(i)
+		et, ef, el := isr.Record(tm, p)
+		rt, rf, rl, err := rec[rand.Intn(len(rec))].Record(bg, tm, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt != et || rf != ef || rl != el {
+			t.Errorf("Record(%v, %v): got %v %v %v, want %v %v %v",
+				tm, p, rt, rf, rl, et, ef, el)
+		}
+	}
+}
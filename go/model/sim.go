@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Link identifies the directed network link from one node to another.
+type Link struct {
+	From, To int
+}
+
+// Scheduler decides the order in which a simulated network delivers messages.
+// Since nodes assume that each link delivers messages in order,
+// a Scheduler chooses only which link delivers its next message.
+//
+// Next returns one of the links in ready,
+// which lists all the links with messages awaiting delivery
+// in a deterministic order, by sender and then receiver.
+// Next may return an error to stop the simulation.
+//
+type Scheduler interface {
+	Next(ready []Link) (Link, error)
+}
+
+// Random is a Scheduler that chooses uniformly among ready links.
+// The delivery order is determined by the random source,
+// so a run may be repeated by reusing the same seed.
+type Random struct {
+	Rand *rand.Rand // Source of random choices
+}
+
+// Next chooses a ready link at random.
+func (r *Random) Next(ready []Link) (Link, error) {
+	return ready[r.Rand.Intn(len(ready))], nil
+}
+
+// Recorder is a Scheduler that records the choices of another Scheduler,
+// so that a run may be replayed exactly using Replay,
+// e.g., to reproduce and debug a safety violation a randomized run found.
+type Recorder struct {
+	Scheduler Scheduler // Scheduler making the choices
+	Schedule  []Link    // Choices made so far, in order
+}
+
+// Next records and returns the underlying Scheduler's choice.
+func (r *Recorder) Next(ready []Link) (Link, error) {
+	l, err := r.Scheduler.Next(ready)
+	if err == nil {
+		r.Schedule = append(r.Schedule, l)
+	}
+	return l, err
+}
+
+// Replay is a Scheduler that replays a schedule a Recorder recorded.
+// It returns an error if the run diverges from the recorded schedule,
+// which happens if the group's configuration or code has changed.
+type Replay struct {
+	Schedule []Link // Schedule to replay
+	pos      int    // Number of deliveries replayed so far
+}
+
+// Next returns the next link in the recorded schedule.
+func (r *Replay) Next(ready []Link) (Link, error) {
+	if r.pos >= len(r.Schedule) {
+		return Link{}, errors.New("replay schedule exhausted")
+	}
+	l := r.Schedule[r.pos]
+	for _, rl := range ready {
+		if rl == l {
+			r.pos++
+			return l, nil
+		}
+	}
+	return Link{}, fmt.Errorf("replay diverged at delivery %v: "+
+		"link %v->%v not ready", r.pos, l.From, l.To)
+}
+
+// Sim runs a consensus group deterministically within a single goroutine,
+// over a simulated network whose delivery order a Scheduler decides.
+// Given the same seed and the same sequence of scheduling choices,
+// a simulation always produces the same results.
+type Sim struct {
+	Nodes []*Node        // The group's nodes
+	sched Scheduler      // Scheduler deciding delivery order
+	queue [][][]*Message // Messages awaiting delivery on each link
+}
+
+// NewSim creates a simulation of a group of nnode nodes with threshold thres,
+// whose message delivery order sched decides.
+// Each node's Rand function is seeded deterministically from seed,
+// so that lottery tickets are also reproducible.
+// The caller may change the nodes' optional configuration before Run.
+//
+func NewSim(thres, nnode int, seed int64, sched Scheduler) *Sim {
+	s := &Sim{sched: sched}
+	s.Nodes = make([]*Node, nnode)
+	s.queue = make([][][]*Message, nnode)
+	for i := range s.Nodes {
+		from := i
+		send := func(to int, msg *Message) {
+			s.queue[from][to] = append(s.queue[from][to], msg)
+		}
+		s.Nodes[i] = NewNode(i, thres, nnode, send)
+		s.Nodes[i].Rand = rand.New(rand.NewSource(seed + int64(i))).Int63
+		s.queue[i] = make([][]*Message, nnode)
+	}
+	return s
+}
+
+// Run launches the protocol on all nodes if not already running,
+// then delivers messages until every node reaches time-step maxSteps.
+// Returns an error if the Scheduler does,
+// or if the network runs out of messages to deliver.
+func (s *Sim) Run(maxSteps int) error {
+	for _, n := range s.Nodes {
+		if n.m.Step < 0 {
+			n.Advance()
+		}
+	}
+
+	for s.minStep() < maxSteps {
+		var ready []Link
+		for from := range s.queue {
+			for to, q := range s.queue[from] {
+				if len(q) > 0 {
+					ready = append(ready, Link{from, to})
+				}
+			}
+		}
+		if len(ready) == 0 {
+			return errors.New("no messages left to deliver")
+		}
+
+		l, err := s.sched.Next(ready)
+		if err != nil {
+			return err
+		}
+		msg := s.queue[l.From][l.To][0]
+		s.queue[l.From][l.To] = s.queue[l.From][l.To][1:]
+		s.Nodes[l.To].Receive(msg)
+	}
+	return nil
+}
+
+// Return the lowest time-step any node in the simulation has reached.
+func (s *Sim) minStep() int {
+	min := s.Nodes[0].m.Step
+	for _, n := range s.Nodes[1:] {
+		if n.m.Step < min {
+			min = n.m.Step
+		}
+	}
+	return min
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Run randomly-scheduled simulations, then replay each one
+// and check that the replay reproduces exactly the same results.
+func TestSim(t *testing.T) {
+	testSim(t, 2, 3, 1000, 1)
+	testSim(t, 3, 5, 1000, 2)
+	testSim(t, 4, 7, 100, 3)
+	testSim(t, 3, 3, 1000, 4)
+}
+
+func testSim(t *testing.T, thres, nnode, maxSteps int, seed int64) {
+	desc := fmt.Sprintf("T=%v,N=%v,Steps=%v,Seed=%v",
+		thres, nnode, maxSteps, seed)
+	t.Run(desc, func(t *testing.T) {
+		rec := &Recorder{Scheduler: &Random{rand.New(rand.NewSource(seed))}}
+		s := NewSim(thres, nnode, seed, rec)
+		if err := s.Run(maxSteps); err != nil {
+			t.Fatal(err)
+		}
+		testResults(t, s.Nodes)
+
+		r := NewSim(thres, nnode, seed, &Replay{Schedule: rec.Schedule})
+		if err := r.Run(maxSteps); err != nil {
+			t.Fatal(err)
+		}
+		for i := range s.Nodes {
+			if !reflect.DeepEqual(s.Nodes[i].m, r.Nodes[i].m) {
+				t.Errorf("node %v: replay produced different state", i)
+			}
+		}
+	})
+}
+
+// Run simulations with deeper QSC pipelines.
+func TestDepth(t *testing.T) {
+	n := NewNode(0, 2, 3, func(int, *Message) {})
+	if err := n.SetDepth(2); err == nil {
+		t.Errorf("pipeline depth 2 accepted")
+	}
+
+	for _, depth := range []int{3, 4, 6} {
+		s := NewSim(2, 3, 1, &Random{rand.New(rand.NewSource(1))})
+		for _, n := range s.Nodes {
+			if err := n.SetDepth(depth); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Run(1000); err != nil {
+			t.Fatal(err)
+		}
+		testResults(t, s.Nodes)
+		for _, n := range s.Nodes {
+			if n.Depth() != depth || len(n.newMsg().QSC) != depth+1 {
+				t.Errorf("depth %v: node has depth %v, %v rounds",
+					depth, n.Depth(), len(n.newMsg().QSC))
+			}
+			if err := n.SetDepth(depth); err == nil {
+				t.Errorf("depth changed on a running node")
+			}
+			msg := n.newMsg()
+			msg.QSC = msg.QSC[1:]
+			if n.Check(msg) == nil {
+				t.Errorf("depth %v: accepted %v rounds",
+					depth, len(msg.QSC))
+			}
+		}
+	}
+}
+
+// Compare commit rates with and without deterministic tie-breaking
+// when tickets have little entropy.
+func TestTieBreak(t *testing.T) {
+	for _, c := range []struct{ thres, nnode, maxTicket int }{
+		{2, 3, 1}, {2, 3, 2}, {3, 5, 4}, {4, 7, 8},
+	} {
+		commits := [2]int{}
+		for i, tiebreak := range []bool{false, true} {
+			s := NewSim(c.thres, c.nnode, 1,
+				&Random{rand.New(rand.NewSource(1))})
+			for j, n := range s.Nodes {
+				rng := rand.New(rand.NewSource(int64(j)))
+				n.Rand = func() int64 {
+					return rng.Int63n(int64(c.maxTicket))
+				}
+				n.TieBreak = tiebreak
+			}
+			if err := s.Run(1000); err != nil {
+				t.Fatal(err)
+			}
+			testResults(t, s.Nodes)
+			for _, r := range s.Nodes[0].m.QSC {
+				if r.Commit {
+					commits[i]++
+				}
+			}
+		}
+		t.Logf("T=%v,N=%v,Tickets=%v: %v commits, %v with tie-breaking",
+			c.thres, c.nnode, c.maxTicket, commits[0], commits[1])
+		if commits[1] <= commits[0] {
+			t.Errorf("T=%v,N=%v,Tickets=%v: tie-breaking committed "+
+				"%v rounds, not more than %v",
+				c.thres, c.nnode, c.maxTicket, commits[1], commits[0])
+		}
+	}
+}
+
+// Check that replays report schedules that don't match the run.
+func TestReplayDiverged(t *testing.T) {
+	rec := &Recorder{Scheduler: &Random{rand.New(rand.NewSource(1))}}
+	if err := NewSim(2, 3, 1, rec).Run(100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncated schedule
+	short := &Replay{Schedule: rec.Schedule[:len(rec.Schedule)/2]}
+	if err := NewSim(2, 3, 1, short).Run(100); err == nil {
+		t.Errorf("replay of truncated schedule succeeded")
+	}
+
+	// Schedule for a different group
+	if err := NewSim(3, 4, 1, &Replay{Schedule: rec.Schedule}).Run(100); err == nil {
+		t.Errorf("replay of another group's schedule succeeded")
+	}
+}
+
+// Test that piggybacking acknowledgments on proposals saves messages
+// without compromising consensus.
+func TestPiggyback(t *testing.T) {
+	const thres, nnode, maxSteps, seed = 6, 10, 1000, 1
+	var sent [2][Wit + 1]int
+	for i, piggyback := range []bool{false, true} {
+		s := NewSim(thres, nnode, seed,
+			&Random{rand.New(rand.NewSource(seed))})
+		for _, n := range s.Nodes {
+			n.Piggyback = piggyback
+			send := n.send
+			n.send = func(to int, msg *Message) {
+				sent[i][msg.Type]++
+				send(to, msg)
+			}
+		}
+		if err := s.Run(maxSteps); err != nil {
+			t.Fatal(err)
+		}
+		testResults(t, s.Nodes)
+	}
+	t.Logf("sent %v messages without piggybacking, %v with", sent[0], sent[1])
+	if sent[1][Ack] >= sent[0][Ack] {
+		t.Errorf("piggybacking sent %v Acks, not %v",
+			sent[1][Ack], sent[0][Ack])
+	}
+}
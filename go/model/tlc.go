@@ -1,5 +1,9 @@
 package model
 
+import (
+	"errors"
+)
+
 // Create a copy of our message template for transmission.
 // Sends QSC state only for the rounds still in our window.
 func (n *Node) newMsg() *Message {
@@ -9,10 +13,13 @@ func (n *Node) newMsg() *Message {
 }
 
 // Broadcast a copy of our current message template to all nodes
-func (n *Node) broadcastTLC() {
+// except skip, which may be -1 to skip none.
+func (n *Node) broadcastTLC(skip int) {
 	msg := n.newMsg()
 	for i := 0; i < n.nnode; i++ {
-		n.send(i, msg)
+		if i != skip {
+			n.send(i, msg)
+		}
 	}
 }
 
@@ -23,18 +30,23 @@ func (n *Node) broadcastTLC() {
 // Thereafter, TLC advances time automatically based on network communication.
 //
 func (n *Node) Advance() {
+	n.advance(-1)
+}
+
+// Advance to the next TLC time step,
+// broadcasting our raw proposal to all nodes except skip.
+func (n *Node) advance(skip int) {
 
 	// Initialize message template with a proposal for the new time step
-	n.m.Step++     // Advance to next time step
-	n.m.Type = Raw // Broadcast raw proposal first
-	n.acks = 0     // No acknowledgments received yet in this step
-	n.wits = 0     // No threshold witnessed messages received yet
+	n.m.Step++              // Advance to next time step
+	n.m.Type = Raw          // Broadcast raw proposal first
+	n.witness.Init(n.thres) // No Acks or Wits received yet in this step
 
 	// Notify the upper (QSC) layer of the advancement of time,
 	// and let it fill in its part of the new message to broadcast.
 	n.advanceQSC()
 
-	n.broadcastTLC() // broadcast our raw proposal
+	n.broadcastTLC(skip) // broadcast our raw proposal
 }
 
 // Receive is called by the client or network layer on receipt of a Message
@@ -46,7 +58,14 @@ func (n *Node) Advance() {
 // It also assumes that connection or peer failures are permanent:
 // this implementation of QSC does not support restarting/resuming connections.
 //
+// Receive silently ignores malformed messages that Check rejects,
+// so that a client feeding it slightly wrong data cannot crash the node
+// or corrupt its consensus state.
+//
 func (n *Node) Receive(msg *Message) {
+	if n.Check(msg) != nil {
+		return
+	}
 
 	// Process only messages from the current or next time step.
 	// We could accept and merge in information from older messages,
@@ -56,33 +75,96 @@ func (n *Node) Receive(msg *Message) {
 		// If msg is ahead of us, then virally catch up to it
 		// Since we receive messages from a given peer in order,
 		// a message we receive can be at most one step ahead of ours.
-		if msg.Step > n.m.Step {
+		// If msg is a proposal, we can piggyback our acknowledgment
+		// on the proposal we send its sender, sending that below.
+		piggyback := msg.Step > n.m.Step && msg.Type == Raw && n.Piggyback
+		if piggyback {
+			n.advance(msg.From)
+		} else if msg.Step > n.m.Step {
 			n.Advance()
 		}
 
-		// Merge in received QSC state for rounds still in our pipeline
-		mergeQSC(n.m.QSC[msg.Step:], msg.QSC)
+		// Merge in received QSC state for rounds still in our pipeline.
+		// The round ending at msg.Step is already decided,
+		// so leave it as it was when we made our decision.
+		mergeQSC(n.m.QSC[msg.Step+1:], msg.QSC[1:], n.TieBreak)
 
 		// Now process this message according to type.
 		switch msg.Type {
 		case Raw: // Acknowledge unwitnessed proposals.
 			ack := n.newMsg()
-			ack.Type = Ack
+			if piggyback {
+				ack.Acked = true // our proposal, acknowledging msg
+			} else {
+				ack.Type = Ack
+			}
 			n.send(msg.From, ack)
-
-		case Ack: // Collect a threshold of acknowledgments.
-			n.acks++
-			if n.m.Type == Raw && n.acks >= n.thres {
-				n.m.Type = Wit // Prop now threshold witnessed
-				n.witnessedQSC()
-				n.broadcastTLC()
+			if msg.Acked {
+				n.ackTLC(msg.From)
 			}
 
+		case Ack:
+			n.ackTLC(msg.From)
+
 		case Wit: // Collect a threshold of threshold witnessed messages
-			n.wits++ // witnessed messages in this step
-			if n.wits >= n.thres {
+			if n.witness.Wit(msg.From) {
 				n.Advance() // tick the clock
 			}
 		}
 	}
 }
+
+// Collect a threshold of acknowledgments of our proposal.
+func (n *Node) ackTLC(from int) {
+	if n.witness.Ack(from) {
+		n.m.Type = Wit // Prop now threshold witnessed
+		n.witnessedQSC()
+		n.broadcastTLC(-1)
+	}
+}
+
+// Check reports whether msg is a well-formed message that Receive
+// could have received from a correct peer given this node's current state,
+// returning an error describing the problem if not.
+// Clients that unmarshal messages from the network may use Check
+// to detect and report malformed messages, which Receive ignores.
+//
+// Check verifies only the message's structure, not its content:
+// in this non-Byzantine model, a well-formed message from a peer
+// is trusted to report the peer's QSC state faithfully.
+//
+func (n *Node) Check(msg *Message) error {
+	if msg.From < 0 || msg.From >= n.nnode {
+		return errors.New("sender node number out of range")
+	}
+	if msg.Type < Raw || msg.Type > Wit {
+		return errors.New("invalid message type")
+	}
+	if msg.Step < 0 {
+		return errors.New("negative time step")
+	}
+	if msg.Acked && msg.Type != Raw {
+		return errors.New("acknowledgment on non-Raw message")
+	}
+
+	// Since peer connections are ordered and reliable,
+	// a message can be at most one step ahead of ours.
+	if msg.Step > n.m.Step+1 {
+		return errors.New("time step too far ahead")
+	}
+
+	// Correct peers send QSC state for exactly the rounds
+	// ending at their current step and the depth steps following.
+	if len(msg.QSC) != n.depth+1 {
+		return errors.New("wrong number of QSC rounds")
+	}
+	for i := range msg.QSC {
+		r := &msg.QSC[i]
+		if r.Spoil.From < -1 || r.Spoil.From >= n.nnode ||
+			r.Conf.From < 0 || r.Conf.From >= n.nnode ||
+			r.Reconf.From < 0 || r.Reconf.From >= n.nnode {
+			return errors.New("QSC proposal node number out of range")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas/test"
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+)
+
+// driver runs a test: the workers, the nemesis, and the final check.
+type driver struct {
+	dir     string   // test directory holding histories and logs
+	members []string // member store paths
+	args    []string // common worker arguments
+	until   time.Time
+
+	workers []*worker // running workers
+	nextID  int       // number of the next worker to start
+}
+
+// worker is a running worker process.
+type worker struct {
+	id   int
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the process exits
+}
+
+func runCommand(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() { usage(runUsageStr) }
+	nodes := fs.Int("nodes", 3, "number of member stores to create")
+	members := fs.String("members", "", "existing member stores to use")
+	faulty := fs.Int("faulty", -1, "number of faulty members to tolerate")
+	workers := fs.Int("workers", 5, "number of concurrent clients")
+	duration := fs.Duration("time", 30*time.Second, "test duration")
+	interval := fs.Duration("interval", 3*time.Second,
+		"time between nemesis actions")
+	kill := fs.Bool("kill", true, "kill worker processes")
+	part := fs.Bool("partition", true, "partition workers from members")
+	partCmd := fs.String("partition-cmd", "",
+		"shell command to partition a member")
+	healCmd := fs.String("heal-cmd", "", "shell command to heal a member")
+	reads := fs.Float64("reads", 0.5, "fraction of operations that read")
+	timeout := fs.Duration("timeout", 5*time.Second, "operation timeout")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "random seed")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*partCmd == "") != (*healCmd == "") {
+		usage(runUsageStr)
+	}
+
+	d := &driver{dir: fs.Arg(0)}
+	if err := os.Mkdir(d.dir, 0777); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("seed %d", *seed)
+
+	// Create the member stores, unless the caller supplied them,
+	// e.g., as remote file systems that partition commands can cut off.
+	if *members != "" {
+		d.members = strings.Split(*members, ",")
+	} else {
+		for i := 0; i < *nodes; i++ {
+			path := filepath.Join(d.dir, fmt.Sprintf("member-%d", i))
+			if err := (&casdir.Store{}).Init(path, true, true); err != nil {
+				log.Fatal(err)
+			}
+			d.members = append(d.members, path)
+		}
+	}
+
+	d.until = time.Now().Add(*duration)
+	d.args = []string{
+		"-members", strings.Join(d.members, ","),
+		"-faulty", strconv.Itoa(*faulty),
+		"-until", strconv.FormatInt(d.until.UnixNano(), 10),
+		"-reads", strconv.FormatFloat(*reads, 'g', -1, 64),
+		"-timeout", timeout.String(),
+	}
+	if *partCmd == "" {
+		d.args = append(d.args, "-partition", d.partitionPath())
+	}
+	for i := 0; i < *workers; i++ {
+		d.start()
+	}
+
+	// Inject a fault at each interval, healing partitions in between.
+	nm := &nemesis{d: d, rnd: rand.New(rand.NewSource(*seed)),
+		partCmd: *partCmd, healCmd: *healCmd}
+	var faults []func()
+	if *kill {
+		faults = append(faults, nm.kill)
+	}
+	if *part {
+		faults = append(faults, nm.partition)
+	}
+	healed := true
+	for time.Now().Add(*interval).Before(d.until) {
+		time.Sleep(*interval)
+		switch {
+		case !healed:
+			nm.heal()
+			healed = true
+		case len(faults) > 0:
+			faults[nm.rnd.Intn(len(faults))]()
+			healed = false
+		}
+	}
+	nm.heal()
+
+	// Wait for the workers to reach their deadline and exit,
+	// killing any that get stuck.
+	grace := time.After(time.Until(d.until) + *timeout + 10*time.Second)
+	for _, w := range d.workers {
+		select {
+		case <-w.done:
+		case <-grace:
+			log.Printf("worker %d stuck: killing it", w.id)
+			w.cmd.Process.Kill()
+			<-w.done
+		}
+	}
+
+	// Check the recorded history.
+	ops, err := readHistories(d.dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &reporter{}
+	l := &test.Linear{}
+	l.Replay(r, ops)
+	l.Check(r)
+
+	failed := 0
+	for _, op := range ops {
+		if op.Failed {
+			failed++
+		}
+	}
+	fmt.Printf("%d operations, %d failed or indeterminate\n",
+		len(ops), failed)
+	fmt.Printf("%d workers killed, %d partitions\n",
+		nm.kills, nm.partitions)
+	if r.n > 0 {
+		fmt.Printf("FAIL: %d linearizability violations\n", r.n)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: history is linearizable\n")
+}
+
+// Start a new worker process.
+func (d *driver) start() {
+	id := d.nextID
+	d.nextID++
+
+	logf, err := os.Create(filepath.Join(d.dir,
+		fmt.Sprintf("worker-%d.log", id)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	args := append([]string{"worker", "-id", strconv.Itoa(id),
+		"-history", filepath.Join(d.dir,
+			fmt.Sprintf("history-%d.json", id))}, d.args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdout, cmd.Stderr = logf, logf
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	w := &worker{id, cmd, make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		logf.Close()
+		close(w.done)
+	}()
+	d.workers = append(d.workers, w)
+}
+
+// Replace a dead worker with a new one.
+func (d *driver) replace(w *worker) {
+	for i := range d.workers {
+		if d.workers[i] == w {
+			d.workers = append(d.workers[:i], d.workers[i+1:]...)
+			break
+		}
+	}
+	d.start()
+}
+
+// Return the path of the partition file the workers obey.
+func (d *driver) partitionPath() string {
+	return filepath.Join(d.dir, "partition.json")
+}
+
+// reporter counts and logs the violations a test.Linear checker reports,
+// standing in for a testing.TB outside of a test.
+// The checker only ever calls its Errorf method.
+type reporter struct {
+	testing.TB
+	n int
+}
+
+func (r *reporter) Errorf(format string, args ...interface{}) {
+	r.n++
+	log.Printf(format, args...)
+}
+
+const runUsageStr = `
+Usage: jepsen run [options] <dir>
+
+where <dir> is a new directory to hold the test's member stores,
+worker histories, and worker logs.
+
+Runs a QSCOD consensus group as concurrent worker processes,
+each a client performing random reads and compare-and-set writes,
+while a nemesis periodically kills workers and partitions them from members.
+When the test time is up, checks the recorded history for linearizability,
+and exits with a nonzero status if it finds any violations.
+
+Options:
+
+	-nodes <n>		number of member stores to create (default 3)
+	-members <paths>	comma-separated existing member stores to use
+	-faulty <n>		number of faulty members to tolerate (default N/3)
+	-workers <n>		number of concurrent clients (default 5)
+	-time <duration>	test duration (default 30s)
+	-interval <duration>	time between nemesis actions (default 3s)
+	-kill=false		don't kill worker processes
+	-partition=false	don't partition workers from members
+	-partition-cmd <cmd>	shell command to partition a member
+	-heal-cmd <cmd>		shell command to heal a member's partition
+	-reads <fraction>	fraction of operations that read (default 0.5)
+	-timeout <duration>	operation timeout (default 5s)
+	-seed <n>		random seed for the nemesis
+
+By default, partitions are simulated within the workers.
+To partition real networks instead, e.g., with members on remote
+file systems, give -partition-cmd and -heal-cmd commands,
+such as ones that add and remove iptables rules or toxiproxy proxies.
+They receive the index and path of the member to partition
+in the environment variables JEPSEN_MEMBER and JEPSEN_MEMBER_PATH.
+`
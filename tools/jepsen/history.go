@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dedis/tlc/go/lib/cas/test"
+)
+
+// record is one line of a worker's history file.
+// A worker writes a record when it invokes each operation,
+// and another with the operation's results when it completes,
+// so that the history shows operations in flight when a worker is killed.
+type record struct {
+	Seq  int     // Worker-local operation sequence number
+	Done bool    // Whether this record marks the operation's completion
+	Op   test.Op // The operation, with results if Done
+}
+
+// history appends records to a worker's history file.
+type history struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// Create a history file for a worker.
+func createHistory(path string) (*history, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &history{f, json.NewEncoder(f)}, nil
+}
+
+// Record an operation's invocation or completion.
+// Each record is a single unbuffered write,
+// so a killed worker loses at most the record it was writing.
+func (h *history) record(r *record) error {
+	return h.enc.Encode(r)
+}
+
+func (h *history) Close() error {
+	return h.f.Close()
+}
+
+// Read all the worker history files in dir,
+// returning the operations they record, sorted by invocation time.
+// Operations that never completed are marked failed:
+// they may or may not have taken effect.
+func readHistories(dir string) ([]test.Op, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "history-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var ops []test.Op
+	for _, path := range paths {
+		wops, err := readHistory(path)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, wops...)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Invoke < ops[j].Invoke
+	})
+	return ops, nil
+}
+
+// Read one worker's history file.
+func readHistory(path string) ([]test.Op, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ops []test.Op
+	index := make(map[int]int) // Seq to index in ops
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<24)
+	for s.Scan() {
+		var r record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			break // a partial last record from a killed worker
+		}
+		if !r.Done {
+			r.Op.Failed = true // until we see it complete
+			index[r.Seq] = len(ops)
+			ops = append(ops, r.Op)
+		} else if i, ok := index[r.Seq]; ok {
+			ops[i] = r.Op
+		}
+	}
+	return ops, s.Err()
+}
@@ -0,0 +1,46 @@
+// The jepsen command is a Jepsen-style external consistency test driver
+// for QSCOD consensus groups.
+//
+// It runs a consensus group as multiple independent client processes
+// sharing a set of member stores, performs randomized CAS operations
+// from each client while injecting process kills and network partitions,
+// records the complete operation history, and finally checks it
+// with the linearizability checker in go/lib/cas/test.
+//
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usageStr = `
+The jepsen command tests QSCOD consensus groups for linearizability
+under process kills and network partitions.
+
+Usage:
+
+	jepsen run [options] <dir>
+
+Run jepsen run -help for options.
+`
+
+func usage(usageString string) {
+	fmt.Println(usageString)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(usageStr)
+	}
+
+	switch os.Args[1] {
+	case "run":
+		runCommand(os.Args[2:])
+	case "worker":
+		workerCommand(os.Args[2:]) // internal: run by the driver
+	default:
+		usage(usageStr)
+	}
+}
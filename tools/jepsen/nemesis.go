@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// partition maps each worker number to the member indexes
+// cut off from that worker.
+type partition map[int][]int
+
+// nemesis injects faults into the system under test.
+type nemesis struct {
+	d   *driver
+	rnd *rand.Rand
+
+	partCmd string // shell command to partition a member, or ""
+	healCmd string // shell command to heal a member's partition
+
+	partitioned []int // members partitioned by partCmd
+	kills       int   // number of workers killed
+	partitions  int   // number of partitions formed
+}
+
+// Kill a random worker, as if its process crashed,
+// and start a replacement client in its place.
+func (nm *nemesis) kill() {
+	w := nm.d.workers[nm.rnd.Intn(len(nm.d.workers))]
+	log.Printf("nemesis: killing worker %d", w.id)
+	w.cmd.Process.Kill()
+	<-w.done
+	nm.kills++
+	nm.d.replace(w)
+}
+
+// Partition a random subset of the workers from a random minority
+// of the members, or a random member from all workers
+// if partitions are external.
+func (nm *nemesis) partition() {
+	nm.partitions++
+	n := len(nm.d.members)
+	if nm.partCmd != "" {
+		m := nm.rnd.Intn(n)
+		log.Printf("nemesis: partitioning member %d", m)
+		nm.run(nm.partCmd, m)
+		nm.partitioned = append(nm.partitioned, m)
+		return
+	}
+
+	// Cut off up to half of the members from each chosen worker.
+	p := make(partition)
+	for _, w := range nm.d.workers {
+		if nm.rnd.Intn(2) == 0 {
+			continue
+		}
+		for _, m := range nm.rnd.Perm(n)[:1+nm.rnd.Intn((n+1)/2)] {
+			p[w.id] = append(p[w.id], m)
+		}
+	}
+	log.Printf("nemesis: partitioning %v", p)
+	nm.write(p)
+}
+
+// Heal all partitions.
+func (nm *nemesis) heal() {
+	log.Printf("nemesis: healing")
+	for _, m := range nm.partitioned {
+		nm.run(nm.healCmd, m)
+	}
+	nm.partitioned = nil
+	nm.write(partition{})
+}
+
+// Write a new partition file atomically,
+// so that workers never read a partial one.
+func (nm *nemesis) write(p partition) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := nm.d.partitionPath()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "partition-*.tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Run an external partition or heal command for member m,
+// e.g., one that manipulates iptables rules or a toxiproxy proxy.
+func (nm *nemesis) run(command string, m int) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"JEPSEN_MEMBER="+strconv.Itoa(m),
+		"JEPSEN_MEMBER_PATH="+nm.d.members[m])
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("nemesis %q: %v", command, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+// Run a worker process, a single client of the consensus group under test,
+// performing random operations until its deadline
+// and recording them in its history file.
+func workerCommand(args []string) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	id := fs.Int("id", 0, "unique worker number")
+	members := fs.String("members", "", "comma-separated member stores")
+	faulty := fs.Int("faulty", -1, "number of faulty members to tolerate")
+	hist := fs.String("history", "", "history file to write")
+	part := fs.String("partition", "", "partition file to obey")
+	until := fs.Int64("until", 0, "deadline in Unix nanoseconds")
+	reads := fs.Float64("reads", 0.5, "fraction of operations that read")
+	timeout := fs.Duration("timeout", 5*time.Second, "operation timeout")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithDeadline(context.Background(),
+		time.Unix(0, *until))
+	defer cancel()
+
+	// Open the member stores, each partitionable from this worker.
+	paths := strings.Split(*members, ",")
+	stores := make([]cas.Store, len(paths))
+	for i, path := range paths {
+		st := &casdir.Store{}
+		if err := st.Init(path, false, false); err != nil {
+			log.Fatal(err)
+		}
+		stores[i] = &partitionStore{st, *id, i, *part}
+	}
+	g := (&qscas.Group{ID: fmt.Sprintf("worker %d", *id)}).Start(ctx,
+		stores, *faulty)
+
+	h, err := createHistory(*hist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+
+	rnd := rand.New(rand.NewSource(int64(*id)))
+	old := ""
+	for seq := 0; ctx.Err() == nil; seq++ {
+
+		// Values we write must be unique for the checker,
+		// so we name them after the worker and operation.
+		new := old
+		if rnd.Float64() >= *reads {
+			new = fmt.Sprintf("worker %d op %d", *id, seq)
+		}
+		op := test.Op{Invoke: time.Now().UnixNano(), Old: old, New: new}
+		if err := h.record(&record{seq, false, op}); err != nil {
+			log.Fatal(err)
+		}
+
+		octx, ocancel := context.WithTimeout(ctx, *timeout)
+		op.Version, op.Actual, err = g.CompareAndSet(octx, old, new)
+		ocancel()
+		op.Complete = time.Now().UnixNano()
+		if ctx.Err() != nil {
+			break // leave the operation in flight at the deadline
+		}
+		if err != nil {
+			op = test.Op{Invoke: op.Invoke, Old: old, New: new,
+				Failed: true}
+		} else {
+			old = op.Actual
+		}
+		if err := h.record(&record{seq, true, op}); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// partitionStore simulates a network partition between a worker
+// and a member store, as directed by the driver via a partition file.
+type partitionStore struct {
+	cas.Store        // underlying member store
+	worker    int    // this worker's number
+	member    int    // this member's index
+	path      string // partition file, or "" for none
+}
+
+// Interval at which partitioned workers check whether the partition healed
+const partitionPoll = 10 * time.Millisecond
+
+// CompareAndSet waits until the member is reachable from this worker,
+// then performs the operation on the underlying store.
+// A partition that forms while an operation is under way
+// does not affect that operation.
+func (ps *partitionStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+
+	for ps.partitioned() {
+		select {
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		case <-time.After(partitionPoll):
+		}
+	}
+	return ps.Store.CompareAndSet(ctx, old, new)
+}
+
+// Return true if the partition file currently cuts this worker off
+// from this member.
+func (ps *partitionStore) partitioned() bool {
+	if ps.path == "" {
+		return false
+	}
+	b, err := ioutil.ReadFile(ps.path)
+	if err != nil {
+		return false // no partition in effect
+	}
+	var p partition
+	if err := json.Unmarshal(b, &p); err != nil {
+		return false // the driver is rewriting it
+	}
+	for _, m := range p[ps.worker] {
+		if m == ps.member {
+			return true
+		}
+	}
+	return false
+}
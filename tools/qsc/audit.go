@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dedis/tlc/go/lib/fs/audit"
+)
+
+const auditUsageStr = `
+Usage: qsc audit <command> [arguments]
+
+The commands for store audit logs are:
+`
+
+func auditVerifyCommand(ctx context.Context, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage(auditVerifyUsageStr)
+	}
+
+	// Parse the optional hex-encoded public key
+	var pub ed25519.PublicKey
+	if len(args) == 2 {
+		b, err := hex.DecodeString(args[1])
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			log.Fatal("Invalid Ed25519 public key")
+		}
+		pub = b
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	n, last, err := audit.Verify(f, pub)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n == 0 {
+		fmt.Printf("audit log valid: no entries\n")
+		return
+	}
+	fmt.Printf("audit log valid: %d entries, last version %d state %q "+
+		"at %v\n", n, last.Ver, last.Val, last.Time)
+}
+
+const auditVerifyUsageStr = `
+Usage: qsc audit verify <log> [<public-key>]
+
+where:
+<log> is the audit log file to verify
+<public-key> is the hex-encoded Ed25519 key every entry must be signed with
+
+Checks that the log's hash chain is intact and its versions increase,
+then prints the number of entries and the last state recorded.
+`
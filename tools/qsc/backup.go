@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+	"github.com/dedis/tlc/go/lib/fs/verst"
+	"github.com/dedis/tlc/go/model/qscod/group"
+)
+
+// archive is the portable representation of a consensus group's state
+// that the backup command writes and the restore command reads.
+// It records the raw state versions of each member store,
+// independently of how any particular storage backend lays them out.
+type archive struct {
+	Group   string          // resource identifier of the backed-up group
+	Members []archiveMember // state of each member store, in group order
+}
+
+// archiveMember holds the state versions of one member store,
+// in increasing version order, ending with the latest.
+type archiveMember struct {
+	Path     string           // original path of the member store
+	Versions []archiveVersion // retained state versions
+}
+
+// archiveVersion holds one state version of a member store.
+type archiveVersion struct {
+	Ver int64  // state version number
+	Val []byte // raw member state value
+}
+
+func backupCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	history := fs.Bool("history", false, "include retained history")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 2 {
+			usage(backupUsageStr)
+		}
+		backup(ctx, args[0], args[1], *history)
+	}
+}
+
+// Back up group ri to archive file, with its history if requested.
+func backup(ctx context.Context, ri, file string, history bool) {
+
+	paths, err := parseGroup(ri)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Commit the latest state so that the member stores hold it.
+	if err := commitLatest(ctx, ri); err != nil {
+		log.Fatal(err)
+	}
+
+	// Snapshot the state of each member store
+	a := archive{Group: ri}
+	for _, path := range paths {
+		m, err := backupMember(ctx, path, history)
+		if err != nil {
+			log.Fatal(err)
+		}
+		a.Members = append(a.Members, m)
+	}
+
+	b, err := json.MarshalIndent(&a, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+const backupUsageStr = `
+Usage: qsc backup [-history] <group> <file>
+
+where <group> specifies the consensus group
+and <file> is the archive file to write.
+Snapshots the latest committed state of every member store,
+and with -history all the past versions they retain, into the archive.
+`
+
+// Read the latest version of a member store, and its history if requested.
+func backupMember(ctx context.Context, path string, history bool) (
+	archiveMember, error) {
+
+	m := archiveMember{Path: path}
+	st := &casdir.Store{}
+	if err := st.Init(path, false, false); err != nil {
+		return m, err
+	}
+	latest, val, err := st.ReadAt(ctx, math.MaxInt64)
+	if err != nil {
+		return m, err
+	}
+
+	if history {
+		// Pin the retained history so that it isn't collected
+		// while we copy it.
+		if err := st.Pin(ctx, 0); err != nil {
+			return m, err
+		}
+		defer st.Unpin(ctx, 0)
+
+		vers, err := st.ListVersions(ctx, 1, latest-1)
+		if err != nil {
+			return m, err
+		}
+		for _, ver := range vers {
+			actual, val, err := st.ReadAt(ctx, ver)
+			if verst.IsNotExist(err) || (err == nil && actual != ver) {
+				continue // garbage collected before we pinned it
+			}
+			if err != nil {
+				return m, err
+			}
+			m.Versions = append(m.Versions, archiveVersion{ver, []byte(val)})
+		}
+	}
+	if latest > 0 {
+		m.Versions = append(m.Versions, archiveVersion{latest, []byte(val)})
+	}
+	return m, nil
+}
+
+func restoreCommand(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage(restoreUsageStr)
+	}
+	ri, file := args[0], args[1]
+
+	paths, err := parseGroup(ri)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var a archive
+	if err := json.Unmarshal(b, &a); err != nil {
+		log.Fatal(err)
+	}
+	if len(a.Members) != len(paths) {
+		log.Fatalf("archive has %d members but group has %d",
+			len(a.Members), len(paths))
+	}
+
+	// Rebuild each member store from its archived versions
+	for i, path := range paths {
+		m := a.Members[i]
+		if err := restoreMember(path, m); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("restored %d versions of %s to %s\n",
+			len(m.Versions), m.Path, path)
+	}
+
+	// Record the group's new members in-band if they have moved
+	old, err := group.ParseRI(a.Group)
+	if err != nil || group.FormatRI(old) != group.FormatRI(paths) {
+		if err := reconfigureMembers(ctx, paths); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+const restoreUsageStr = `
+Usage: qsc restore <group> <file>
+
+where <group> specifies the consensus group to create
+and <file> is an archive written by qsc backup.
+Creates each member store of the group afresh from the archive.
+The group must have the same number of members as the archived group,
+but its members may reside at different paths,
+in which case the group's in-band configuration is updated to match.
+`
+
+// Create a member store at path holding the archived versions of m.
+func restoreMember(path string, m archiveMember) error {
+	st := &verst.State{}
+	if err := st.Init(path, true, true); err != nil {
+		return err
+	}
+	for _, v := range m.Versions {
+		if err := st.WriteVersion(v.Ver, string(v.Val)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dedis/tlc/go/model/qscod/group"
+)
+
+// Results of the CAS operations one benchmark writer performed.
+type benchResult struct {
+	lat     []time.Duration // latency of each completed operation
+	commits int             // operations that committed the writer's value
+	aborts  int             // operations preempted by other writers' values
+	errs    int             // operations that failed with an error
+}
+
+func benchCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	writers := fs.Int("writers", 4, "number of concurrent writers")
+	duration := fs.Duration("duration", 10*time.Second, "length of the run")
+	size := fs.Int("size", 16, "size in bytes of the values written")
+	return func(ctx context.Context, args []string) {
+
+		// Accept options after the group as well as before it.
+		if len(args) < 1 {
+			usage(benchUsageStr)
+		}
+		ri := args[0]
+		fs.Parse(args[1:])
+		if fs.NArg() != 0 || *writers < 1 || *duration <= 0 ||
+			*size < 1 {
+			usage(benchUsageStr)
+		}
+		bench(ctx, ri, *writers, *duration, *size)
+	}
+}
+
+// Benchmark group ri with a number of writers for a given duration,
+// writing values of a given size.
+func bench(ctx context.Context, ri string, writers int,
+	duration time.Duration, size int) {
+
+	// Open the group once per writer, as independent clients would.
+	groups := make([]*group.Group, writers)
+	for i := range groups {
+		g, err := openGroup(ctx, ri)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer g.Close()
+		groups[i] = g
+	}
+
+	// Drive CAS operations from every writer until the run ends.
+	results := make([]benchResult, writers)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	end := start.Add(duration)
+	for i := range groups {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			benchWriter(ctx, groups[i], i, size, end, &results[i])
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Combine and report the writers' results.
+	var all benchResult
+	for _, r := range results {
+		all.lat = append(all.lat, r.lat...)
+		all.commits += r.commits
+		all.aborts += r.aborts
+		all.errs += r.errs
+	}
+	ops := len(all.lat)
+	secs := elapsed.Seconds()
+	fmt.Printf("%d writers for %v: %d operations (%.1f/s)\n",
+		writers, elapsed.Round(time.Millisecond), ops,
+		float64(ops)/secs)
+	fmt.Printf("committed %d (%.1f/s), aborted %d (%s), errors %d\n",
+		all.commits, float64(all.commits)/secs,
+		all.aborts, percent(all.aborts, ops), all.errs)
+	if ops > 0 {
+		sort.Slice(all.lat, func(i, j int) bool {
+			return all.lat[i] < all.lat[j]
+		})
+		fmt.Printf("latency p50 %v, p90 %v, p99 %v, max %v\n",
+			quantile(all.lat, 0.50), quantile(all.lat, 0.90),
+			quantile(all.lat, 0.99), quantile(all.lat, 1))
+	}
+
+	// Report each member's store latency, averaged across writers.
+	for i, path := range groups[0].Members() {
+		var sum time.Duration
+		n := 0
+		for _, g := range groups {
+			if lat := g.QSC.MemberLatency(i); lat > 0 {
+				sum += lat
+				n++
+			}
+		}
+		if n == 0 {
+			fmt.Printf("member %d (%s): latency unknown\n", i, path)
+			continue
+		}
+		fmt.Printf("member %d (%s): latency %v\n", i, path,
+			(sum / time.Duration(n)).Round(time.Microsecond))
+	}
+}
+
+// Perform CAS operations on a group until time end,
+// recording their outcomes and latencies in r.
+func benchWriter(ctx context.Context, g *group.Group, w, size int,
+	end time.Time, r *benchResult) {
+
+	ctx, cancel := context.WithDeadline(ctx, end)
+	defer cancel()
+
+	cur := ""
+	for n := 0; ctx.Err() == nil; n++ {
+		new := benchValue(w, n, size)
+		began := time.Now()
+		_, actual, err := g.Set(ctx, cur, new)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return // the run ended during the operation
+		case err != nil:
+			r.errs++
+			continue
+		case actual == new:
+			r.commits++
+		default:
+			r.aborts++
+		}
+		r.lat = append(r.lat, time.Since(began))
+		cur = actual
+	}
+}
+
+// Return a distinct value of the given size for operation n of writer w.
+func benchValue(w, n, size int) string {
+	s := fmt.Sprintf("bench %d %d ", w, n)
+	if len(s) >= size {
+		return s
+	}
+	return s + strings.Repeat("x", size-len(s))
+}
+
+// Return quantile q of a sorted list of durations.
+func quantile(lat []time.Duration, q float64) time.Duration {
+	return lat[int(q*float64(len(lat)-1))].Round(time.Microsecond)
+}
+
+// Format n as a percentage of total.
+func percent(n, total int) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+const benchUsageStr = `
+Usage: qsc bench <group> [options]
+
+where <group> specifies the existing consensus group to load.
+
+Drives concurrent compare-and-set operations against the group
+from independent writers, each opening the group as a separate client,
+for a fixed duration, then reports:
+
+	the throughput of operations, and of those that committed
+	the rate at which operations aborted, preempted by other writers
+	percentiles of operation latency
+	each member store's access latency, averaged across writers
+
+Each writer repeatedly replaces the latest value it knows of with its own.
+Use the results to size groups and thresholds empirically.
+The benchmark overwrites the group's state, so run it on a scratch group.
+
+Options:
+
+	-writers <n>		number of concurrent writers (default 4)
+	-duration <d>		length of the run, such as 30s (default 10s)
+	-size <n>		size in bytes of the values written (default 16)
+`
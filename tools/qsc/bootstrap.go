@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/dedis/tlc/go/model/qscod/bootstrap"
+	"github.com/dedis/tlc/go/model/qscod/group"
+)
+
+func bootstrapCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	faulty := fs.Int("faulty", -1, "number of faulty members to tolerate")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 2 {
+			usage(bootstrapUsageStr)
+		}
+
+		paths, f, err := loadGroup(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *faulty < 0 && f != nil {
+			*faulty = f.Group.Faulty
+		}
+		d, err := bootstrap.Bootstrap(ctx, paths, *faulty)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.Write(args[1]); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("group %s\n", group.FormatRI(paths))
+		fmt.Printf("thresholds Tr %d Ts %d faulty %d version %d\n",
+			d.Tr, d.Ts, d.Faulty, d.Version)
+	}
+}
+
+const bootstrapUsageStr = `
+Usage: qsc bootstrap [-faulty <n>] <group> <descriptor>
+
+where:
+<group> specifies the consensus group to create
+<descriptor> is the group descriptor file to write
+
+Creates and initializes each member store of a new consensus group,
+commits an initial version to validate the configuration,
+then writes a descriptor file recording the group's members,
+their storage backends, and its consensus thresholds.
+
+Members may be local directories, or URLs such as s3://bucket/path
+or scp-style host:path identifiers for which a backend is available.
+
+Options:
+
+	-faulty <n>	number of faulty members to tolerate
+			(default N/3, or as a configuration file sets)
+`
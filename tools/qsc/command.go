@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// A command is a node in the tree of qsc commands:
+// either a group of subcommands, such as string or member,
+// or a leaf command that runs, such as string get.
+// The tree describes itself, so that help and shell completion
+// cover every command and flag without listing them again by hand.
+type command struct {
+	name    string // word selecting this command in its group
+	summary string // one-line description for its group's help
+	usage   string // usage text, before the generated command list if any
+	notes   string // text to follow a group's generated command list
+	hidden  bool   // omit from help and completion
+
+	// A group has subcommands.
+	sub []*command
+
+	// A leaf command either runs directly without flags,
+	// or declares its flags on a FlagSet in setup,
+	// which returns the function that runs it with the arguments left
+	// after the flags, so that help and completion may introspect
+	// a command's flags without running it.
+	run   func(ctx context.Context, args []string)
+	setup func(fs *flag.FlagSet) func(ctx context.Context, args []string)
+}
+
+// Return the subcommand of a group with a given name, or nil.
+func (c *command) lookup(name string) *command {
+	for _, s := range c.sub {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Return a new FlagSet declaring a leaf command's flags,
+// together with the function that runs the command once they're parsed.
+func (c *command) flags(path string) (
+	*flag.FlagSet, func(context.Context, []string)) {
+
+	fs := flag.NewFlagSet(path, flag.ExitOnError)
+	fs.Usage = func() { usage(c.help(path)) }
+	if c.setup == nil {
+		return fs, c.run
+	}
+	return fs, c.setup(fs)
+}
+
+// Return the help text of a command whose full name is path.
+// A group's help lists its visible subcommands from their summaries.
+func (c *command) help(path string) string {
+	if c.sub == nil {
+		return c.usage
+	}
+	b := &strings.Builder{}
+	b.WriteString(c.usage)
+	b.WriteString("\n")
+	w := tabwriter.NewWriter(b, 0, 8, 1, '\t', 0)
+	for _, s := range c.sub {
+		if !s.hidden {
+			fmt.Fprintf(w, "\t%s\t%s\n", s.name, s.summary)
+		}
+	}
+	w.Flush()
+	b.WriteString(c.notes)
+	fmt.Fprintf(b, "\nRun %s help <command> for more about each command.\n",
+		path)
+	return b.String()
+}
+
+// Dispatch the arguments args to command c, whose full name is path.
+// A group accepts help, alone or followed by the names of subcommands,
+// to print the help of the command those names select.
+func (c *command) dispatch(ctx context.Context, path string, args []string) {
+	if c.sub == nil {
+		fs, run := c.flags(path)
+		if c.setup != nil {
+			fs.Parse(args)
+			args = fs.Args()
+		}
+		run(ctx, args)
+		return
+	}
+	if len(args) == 0 {
+		usage(c.help(path))
+	}
+	if args[0] == "help" {
+		for _, name := range args[1:] {
+			if c = c.lookup(name); c == nil {
+				usage(fmt.Sprintf("\nUnknown command %q.\n", name))
+			}
+			path += " " + name
+		}
+		fmt.Println(c.help(path))
+		os.Exit(0)
+	}
+	s := c.lookup(args[0])
+	if s == nil {
+		usage(c.help(path))
+	}
+	s.dispatch(ctx, path+" "+s.name, args[1:])
+}
+
+// Return the completions of the last of the words following qsc,
+// which may be partial or empty, given the words before it.
+// Completing a group's subcommands includes help,
+// and completing a word starting with a dash gives a leaf command's flags.
+func (c *command) complete(words []string) []string {
+	path := "qsc"
+	for _, w := range words[:len(words)-1] {
+		if c.sub == nil {
+			break // an argument of a leaf command
+		}
+		if w == "help" {
+			continue // help takes command names in turn
+		}
+		if c = c.lookup(w); c == nil {
+			return nil
+		}
+		path += " " + w
+	}
+	word := words[len(words)-1]
+
+	var cands []string
+	switch {
+	case c.sub != nil:
+		for _, s := range c.sub {
+			if !s.hidden {
+				cands = append(cands, s.name)
+			}
+		}
+		cands = append(cands, "help")
+	case strings.HasPrefix(word, "-"):
+		fs, _ := c.flags(path)
+		fs.VisitAll(func(f *flag.Flag) {
+			cands = append(cands, "-"+f.Name)
+		})
+	}
+
+	var l []string
+	for _, cand := range cands {
+		if strings.HasPrefix(cand, word) {
+			l = append(l, cand)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// The completion command's generated shell scripts,
+// which ask qsc itself to complete each command line.
+const bashCompletion = `# bash completion for qsc
+_qsc() {
+	local IFS=$'\n'
+	COMPREPLY=($(qsc complete -- "${COMP_WORDS[@]:1:COMP_CWORD}"))
+}
+complete -o default -F _qsc qsc
+`
+
+const zshCompletion = `#compdef qsc
+# zsh completion for qsc
+_qsc() {
+	local -a cands
+	cands=("${(@f)$(qsc complete -- "${(@)words[2,CURRENT]}")}")
+	if [[ -n ${cands[1]} ]]; then
+		compadd -a cands
+	else
+		_files
+	fi
+}
+compdef _qsc qsc
+`
+
+func completionCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage(completionUsageStr)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	default:
+		usage(completionUsageStr)
+	}
+}
+
+const completionUsageStr = `
+Usage: qsc completion <shell>
+
+where <shell> is bash or zsh.
+Prints a script that completes qsc commands and their flags in that shell.
+To enable completion in the current shell, for example:
+
+	source <(qsc completion bash)
+`
+
+// Print the completions of a partial command line, one per line,
+// for the completion scripts.
+func completeCommand(ctx context.Context, args []string) {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	for _, w := range qscCommand.complete(args) {
+		fmt.Println(w)
+	}
+}
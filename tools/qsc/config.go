@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dedis/tlc/go/lib/config"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+	"github.com/dedis/tlc/go/model/qscod/group"
+)
+
+const configNotesStr = `
+Wherever a command takes a <group>, it may instead take @<file>,
+naming a group configuration file in TOML, in YAML if it ends in .yaml
+or .yml, or in JSON if it ends in .json, that lists the group's member
+stores, in the form package config defines.
+Commands then use the key file the configuration names
+unless the QSC_KEYS environment variable names one,
+bootstrap tolerates the faulty members it configures,
+and member domains records the failure domains it lists.
+`
+
+// Parse a group argument into the paths of its member stores.
+func parseGroup(arg string) ([]string, error) {
+	paths, _, err := loadGroup(arg)
+	return paths, err
+}
+
+// Parse a group argument into the paths of its member stores,
+// and return the configuration file it names, if any.
+func loadGroup(arg string) ([]string, *config.File, error) {
+	if !strings.HasPrefix(arg, "@") {
+		paths, err := group.ParseRI(arg)
+		return paths, nil, err
+	}
+	f, err := config.Load(arg[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	stores, err := f.Stores()
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, err := group.ParseRI(group.FormatRI(stores))
+	return paths, f, err
+}
+
+// Return the resource identifier of the group a group argument specifies,
+// and the keys QSC_KEYS or else the group's configuration file names, if any.
+func groupKeys(arg string) (string, *encoding.Keyring, error) {
+	paths, f, err := loadGroup(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := envKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if keys == nil && f != nil && f.Group.Keys != "" {
+		if keys, err = readKeys(f.Group.Keys); err != nil {
+			return "", nil, err
+		}
+	}
+	return group.FormatRI(paths), keys, nil
+}
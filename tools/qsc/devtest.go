@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/cas/test"
+	"github.com/dedis/tlc/go/model/qscod/bootstrap"
+)
+
+const devtestUsageStr = `
+Usage: qsc devtest <command> [arguments]
+
+The commands are:
+`
+
+// A conformance test suite run against fresh stores.
+type suite struct {
+	name string
+	desc string
+	run  func(t testing.TB, threads, accesses int, stores []cas.Store)
+}
+
+var suites = []suite{
+	{"torture", "concurrent accesses observe consistent versions",
+		func(t testing.TB, threads, accesses int, st []cas.Store) {
+			test.Stores(t, threads, accesses, st...)
+		}},
+	{"linear", "concurrent accesses are linearizable",
+		func(t testing.TB, threads, accesses int, st []cas.Store) {
+			test.LinearStores(t, threads, accesses, st...)
+		}},
+	{"timeout", "retried accesses of unknown outcome are linearizable",
+		func(t testing.TB, threads, accesses int, st []cas.Store) {
+			test.FaultyStores(t, test.Faults{Timeout: 0.2},
+				threads, accesses, st...)
+		}},
+	{"cancel", "cancelled and retried accesses are linearizable",
+		func(t testing.TB, threads, accesses int, st []cas.Store) {
+			test.FaultyStores(t, test.Faults{Cancel: 0.5,
+				Delay: 10 * time.Millisecond},
+				threads, accesses, st...)
+		}},
+}
+
+func devtestStoreCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	clients := fs.Int("clients", 3, "number of store interfaces to open")
+	threads := fs.Int("threads", 2, "number of threads per interface")
+	accesses := fs.Int("accesses", 100, "number of accesses per thread")
+	only := fs.String("suite", "", "run only the named suite")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 1 || *clients < 1 || *threads < 1 ||
+			*accesses < 1 {
+			usage(devtestStoreUsageStr)
+		}
+		devtestStore(ctx, args[0], *clients, *threads, *accesses, *only)
+	}
+}
+
+// Run the conformance suites, or only the one named only if not empty,
+// on test stores in location loc.
+func devtestStore(ctx context.Context, loc string,
+	clients, threads, accesses int, only string) {
+
+	failed := false
+	for _, s := range suites {
+		if only != "" && s.name != only {
+			continue
+		}
+
+		// Run each suite on a fresh store,
+		// through several independently opened interfaces to it.
+		member := loc + "/" + s.name
+		stores := make([]cas.Store, clients)
+		for i := range stores {
+			st, err := bootstrap.Open(ctx, member, i == 0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			stores[i] = st
+		}
+
+		r := &report{}
+		start := time.Now()
+		s.run(r, threads, accesses, stores)
+		elapsed := time.Since(start).Round(time.Millisecond)
+
+		if len(r.errs) == 0 {
+			fmt.Printf("PASS %-8s %s (%v)\n", s.name, s.desc, elapsed)
+			continue
+		}
+		failed = true
+		fmt.Printf("FAIL %-8s %s (%v): %d errors\n",
+			s.name, s.desc, elapsed, len(r.errs))
+		for i, e := range r.errs {
+			if i == maxReported {
+				fmt.Printf("\t...\n")
+				break
+			}
+			fmt.Printf("\t%s\n", e)
+		}
+	}
+
+	if failed {
+		fmt.Printf("stores in %s do not conform\n", loc)
+		os.Exit(1)
+	}
+	fmt.Printf("stores in %s conform\n", loc)
+}
+
+// Maximum number of errors to report per suite.
+const maxReported = 5
+
+// A report collects the errors a conformance suite reports,
+// standing in for the testing context of a Go test.
+// Only the methods the cas/test package uses are implemented.
+type report struct {
+	testing.TB
+
+	mut  sync.Mutex
+	errs []string
+}
+
+func (r *report) Error(args ...interface{}) {
+	r.add(fmt.Sprint(args...))
+}
+
+func (r *report) Errorf(format string, args ...interface{}) {
+	r.add(fmt.Sprintf(format, args...))
+}
+
+func (r *report) Helper() {}
+
+func (r *report) add(err string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+const devtestStoreUsageStr = `
+Usage: qsc devtest store [options] <location>
+
+where:
+<location> is an existing location, such as a directory,
+in which to create test stores identified as group members are
+
+Checks that a storage backend correctly implements the check-and-set (CAS)
+interface that consensus groups rely on, before trusting it with their state.
+Creates a fresh test store named <location>/<suite> for each suite,
+opens it through several independent interfaces,
+then runs concurrent accesses through them and checks their results.
+The timeout and cancel suites inject failures into accesses
+and check that retrying them keeps the store consistent.
+Prints a conformance report, and exits with status 1 on any failure.
+
+The suites are:
+
+	torture	concurrent accesses observe consistent versions
+	linear	concurrent accesses are linearizable
+	timeout	retried accesses of unknown outcome are linearizable
+	cancel	cancelled and retried accesses are linearizable
+
+Options:
+
+	-clients <n>	number of store interfaces to open (default 3)
+	-threads <n>	number of threads per interface (default 2)
+	-accesses <n>	number of accesses per thread (default 100)
+	-suite <name>	run only the named suite
+`
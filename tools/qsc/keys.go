@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dedis/tlc/go/lib/fs/atomic"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+	"github.com/dedis/tlc/go/model/qscod/group"
+)
+
+// The environment variable naming the key file
+// with which commands encrypt and decrypt consensus groups' values.
+const keysEnv = "QSC_KEYS"
+
+const keysUsageStr = `
+Usage: qsc keys <command> [arguments]
+
+The commands for managing the keys of encrypted consensus groups are:
+`
+
+const keysNotesStr = `
+A key file holds a group's AES keys, indexed by key epoch, in JSON.
+Other commands encrypt and decrypt the values of a group's member stores
+with the keys in the file named by the QSC_KEYS environment variable,
+and string init creates an encrypted group if QSC_KEYS is set.
+
+To rotate a group's keys, add a key to the key file,
+distribute the file to every client of the group,
+then commit the rotation, after which clients encrypt under the new key.
+Once the retention horizon passes, retire the replaced keys.
+`
+
+// Open the consensus group ri with the keys QSC_KEYS names, if any,
+// or else the keys the group's configuration file names.
+func openGroup(ctx context.Context, ri string) (*group.Group, error) {
+	ri, keys, err := groupKeys(ri)
+	if err != nil {
+		return nil, err
+	}
+	return group.OpenKeys(ctx, ri, keys)
+}
+
+// Create the consensus group ri with the keys QSC_KEYS names, if any,
+// or else the keys the group's configuration file names.
+func createGroup(ctx context.Context, ri string) (*group.Group, error) {
+	ri, keys, err := groupKeys(ri)
+	if err != nil {
+		return nil, err
+	}
+	return group.CreateKeys(ctx, ri, keys)
+}
+
+// Read the key file QSC_KEYS names, or return nil if it is not set.
+func envKeys() (*encoding.Keyring, error) {
+	file := os.Getenv(keysEnv)
+	if file == "" {
+		return nil, nil
+	}
+	return readKeys(file)
+}
+
+// Read a key file.
+func readKeys(file string) (*encoding.Keyring, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	kr := &encoding.Keyring{}
+	if err := json.Unmarshal(b, kr); err != nil {
+		return nil, fmt.Errorf("key file %s: %v", file, err)
+	}
+	if _, ok := kr.Keys[kr.Epoch]; !ok {
+		return nil, fmt.Errorf("key file %s: no key for epoch %d",
+			file, kr.Epoch)
+	}
+	return kr, nil
+}
+
+// Replace a key file, atomically so that it is never left without keys.
+func writeKeys(file string, kr *encoding.Keyring) error {
+	b, err := json.MarshalIndent(kr, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	if err := atomic.WriteFileOnce(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Add a new random key to a keyring, under an epoch above all its others.
+func addKey(kr *encoding.Keyring) (uint32, error) {
+	epoch := uint32(0)
+	for e := range kr.Keys {
+		if e > epoch {
+			epoch = e
+		}
+	}
+	epoch++
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	kr.Keys[epoch] = key
+	return epoch, nil
+}
+
+func keysNewCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage(keysNewUsageStr)
+	}
+
+	kr := &encoding.Keyring{Keys: map[uint32][]byte{}}
+	epoch, err := addKey(kr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kr.Epoch = epoch
+	b, err := json.MarshalIndent(kr, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := atomic.WriteFileOnce(args[0], b, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+const keysNewUsageStr = `
+Usage: qsc keys new <keyfile>
+
+Creates a key file, which must not yet exist,
+holding a new random key under key epoch 1.
+`
+
+func keysAddCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage(keysAddUsageStr)
+	}
+
+	kr, err := readKeys(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	epoch, err := addKey(kr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeKeys(args[0], kr); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("added key epoch %d\n", epoch)
+}
+
+const keysAddUsageStr = `
+Usage: qsc keys add <keyfile>
+
+Adds a new random key to a key file, under the next key epoch,
+and prints the epoch.
+Clients do not encrypt under the new key until the rotation is committed,
+so distribute the updated file to every client of the group first.
+`
+
+func keysRotateCommand(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage(keysRotateUsageStr)
+	}
+
+	kr, err := readKeys(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	epoch := uint32(0)
+	for e := range kr.Keys {
+		if e > epoch {
+			epoch = e
+		}
+	}
+	g, err := group.OpenKeys(ctx, args[0], kr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer g.Close()
+	ver, err := g.QSC.RotateKeys(ctx, epoch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("rotated to key epoch %d at version %d\n", epoch, ver)
+}
+
+const keysRotateUsageStr = `
+Usage: qsc keys rotate <group> <keyfile>
+
+where <group> specifies the encrypted consensus group
+and <keyfile> holds its keys.
+Commits through the group a rotation to the highest key epoch in the file,
+after which every client encrypts new values under that epoch's key,
+and the members re-encrypt their latest state as clients next write to them.
+Every client must hold the new key before the rotation.
+`
+
+func keysRetireCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	retention := fs.Duration("retention", 7*24*time.Hour,
+		"how long members may hold values under replaced keys")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 2 {
+			usage(keysRetireUsageStr)
+		}
+
+		kr, err := readKeys(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := group.OpenKeys(ctx, args[0], kr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer g.Close()
+		c, err := g.QSC.Config(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if e := c.KeyEpoch(); e != 0 {
+			kr.Epoch = e
+		}
+		retired := kr.Retire(c.KeyHorizon(*retention, time.Now()))
+		if len(retired) == 0 {
+			fmt.Println("no keys to retire")
+			return
+		}
+		if err := writeKeys(args[1], kr); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("retired key epochs %v\n", retired)
+	}
+}
+
+const keysRetireUsageStr = `
+Usage: qsc keys retire [options] <group> <keyfile>
+
+where <group> specifies the encrypted consensus group
+and <keyfile> holds its keys.
+Deletes from the key file the keys that rotations committed
+longer than the retention period ago replaced,
+since no member holds values sealed under them any longer.
+Distribute the updated file to the group's clients afterwards.
+
+Options:
+
+	-retention <duration>	how long members may hold values
+				under replaced keys (default 168h)
+
+The retention period must cover how long members retain their history,
+and how long a member may lag behind the group before it is repaired.
+`
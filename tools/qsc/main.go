@@ -15,16 +15,142 @@ The qsc command provides tools using Que Sera Consensus (QSC).
 
 Usage:
 
-	qsc <type> <command> [arguments]
+	qsc <command> [arguments]
 
-The types of consensus groups are:
+The commands are:
+`
 
-	string		Consensus on simple strings
-	git		Consensus on Git repositories
-	hg		Consensus on Mercurial repositories
+const notesStr = `
+The string and value commands operate on consensus groups of each type.
+` + configNotesStr
 
-Run qsc <type> help for commands that apply to each type.
-`
+// The tree of all qsc commands, which init builds,
+// since the complete command refers back to it.
+var qscCommand *command
+
+func init() {
+	qscCommand = &command{name: "qsc", usage: usageStr, notes: notesStr,
+		sub: []*command{
+			{name: "string", summary: "consensus on simple strings",
+				usage: stringUsageStr, sub: []*command{
+					{name: "init",
+						summary: "initialize a new consensus group",
+						usage:   stringInitUsageStr,
+						run:     stringInitCommand},
+					{name: "get",
+						summary: "output the current consensus state " +
+							"as a quoted string",
+						usage: stringGetUsageStr,
+						setup: stringGetCommand},
+					{name: "set",
+						summary: "change the consensus state " +
+							"via atomic compare-and-set",
+						usage: stringSetUsageStr,
+						run:   stringSetCommand},
+					{name: "watch",
+						summary: "report and run hooks " +
+							"on each newly committed state",
+						usage: stringWatchUsageStr,
+						setup: stringWatchCommand},
+				}},
+			{name: "value",
+				summary: "consensus on structured values, in JSON or CBOR",
+				usage:   valueUsageStr, notes: valueNotesStr,
+				sub: []*command{
+					{name: "get",
+						summary: "output the current consensus state " +
+							"as JSON",
+						usage: valueGetUsageStr,
+						setup: valueGetCommand},
+					{name: "set",
+						summary: "change the consensus state " +
+							"to a new JSON value",
+						usage: valueSetUsageStr,
+						setup: valueSetCommand},
+				}},
+			{name: "bootstrap",
+				summary: "provision the member stores of a new group",
+				usage:   bootstrapUsageStr,
+				setup:   bootstrapCommand},
+			{name: "member", summary: "change group membership",
+				usage: memberUsageStr, notes: memberNotesStr,
+				sub: []*command{
+					{name: "add",
+						summary: "add a new member to a consensus group",
+						usage:   memberAddUsageStr,
+						run:     memberAddCommand},
+					{name: "remove",
+						summary: "remove a member from a consensus group",
+						usage:   memberRemoveUsageStr,
+						run:     memberRemoveCommand},
+					{name: "repair",
+						summary: "rebuild a member's lost store " +
+							"from the surviving members",
+						usage: memberRepairUsageStr,
+						run:   memberRepairCommand},
+					{name: "domains",
+						summary: "record the failure domain " +
+							"of each member",
+						usage: memberDomainsUsageStr,
+						run:   memberDomainsCommand},
+				}},
+			{name: "keys",
+				summary: "manage the keys of encrypted groups",
+				usage:   keysUsageStr, notes: keysNotesStr,
+				sub: []*command{
+					{name: "new",
+						summary: "create a key file",
+						usage:   keysNewUsageStr,
+						run:     keysNewCommand},
+					{name: "add",
+						summary: "add a new key to a key file",
+						usage:   keysAddUsageStr,
+						run:     keysAddCommand},
+					{name: "rotate",
+						summary: "commit a rotation " +
+							"to a key file's newest key",
+						usage: keysRotateUsageStr,
+						run:   keysRotateCommand},
+					{name: "retire",
+						summary: "delete replaced keys " +
+							"past their retention period",
+						usage: keysRetireUsageStr,
+						setup: keysRetireCommand},
+				}},
+			{name: "backup", summary: "save a group's state to a file",
+				usage: backupUsageStr,
+				setup: backupCommand},
+			{name: "restore",
+				summary: "rebuild a group's state from a backup",
+				usage:   restoreUsageStr,
+				run:     restoreCommand},
+			{name: "audit", summary: "verify store audit logs",
+				usage: auditUsageStr, sub: []*command{
+					{name: "verify",
+						summary: "check the integrity of an audit log",
+						usage:   auditVerifyUsageStr,
+						run:     auditVerifyCommand},
+				}},
+			{name: "devtest", summary: "test store backends",
+				usage: devtestUsageStr, sub: []*command{
+					{name: "store",
+						summary: "check a store backend's conformance " +
+							"to the CAS interface",
+						usage: devtestStoreUsageStr,
+						setup: devtestStoreCommand},
+				}},
+			{name: "bench",
+				summary: "measure a group's performance under load",
+				usage:   benchUsageStr,
+				setup:   benchCommand},
+			{name: "completion",
+				summary: "print a shell completion script",
+				usage:   completionUsageStr,
+				run:     completionCommand},
+			{name: "complete", hidden: true,
+				run: completeCommand},
+		}}
+}
 
 func usage(usageString string) {
 	fmt.Println(usageString)
@@ -32,20 +158,11 @@ func usage(usageString string) {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		usage(usageStr)
-	}
 
 	// Create a cancelable top-level context and cancel it when we're done,
 	// to shut down asynchronous consensus access operations cleanly.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Parse consensus group kind
-	switch os.Args[1] {
-	case "string":
-		stringCommand(ctx, os.Args[2:])
-	default:
-		usage(usageStr)
-	}
+	qscCommand.dispatch(ctx, "qsc", os.Args[1:])
 }
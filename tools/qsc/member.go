@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/dedis/tlc/go/lib/cas"
+	"github.com/dedis/tlc/go/lib/fs/casdir"
+	"github.com/dedis/tlc/go/model/qscod/encoding"
+	"github.com/dedis/tlc/go/model/qscod/group"
+	"github.com/dedis/tlc/go/model/qscod/qscas"
+)
+
+const memberUsageStr = `
+Usage: qsc member <command> [arguments]
+
+The commands for changing consensus group membership are:
+`
+
+const memberNotesStr = `
+Since a consensus group is identified by its list of members,
+the add and remove commands print the resource identifier of the new group,
+which must be used to access the group from then on.
+
+Membership changes are performed offline:
+no other clients may access the group while the change is in progress,
+and all clients must switch to the new group identifier afterwards.
+`
+
+func memberAddCommand(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage(memberAddUsageStr)
+	}
+
+	paths, err := parseGroup(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	member := args[1]
+	if memberIndex(paths, member) >= 0 {
+		log.Fatalf("%s is already a member of the group", member)
+	}
+	newPaths := append(paths, member)
+	if err := checkMembers(newPaths); err != nil {
+		log.Fatal(err)
+	}
+
+	// Commit the latest state in the old group,
+	// then bootstrap the new member from the most advanced member.
+	if err := commitLatest(ctx, args[0]); err != nil {
+		log.Fatal(err)
+	}
+	val, err := latestMemberValue(ctx, paths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	st := &casdir.Store{}
+	if err := st.Init(member, true, true); err != nil {
+		log.Fatal(err)
+	}
+	if _, _, err := st.CompareAndSet(ctx, "", val); err != nil {
+		log.Fatal(err)
+	}
+	if err := reconfigureMembers(ctx, newPaths); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(group.FormatRI(newPaths))
+}
+
+const memberAddUsageStr = `
+Usage: qsc member add <group> <member>
+
+where <group> specifies the existing consensus group
+and <member> is the path of the new member's store, which must not yet exist.
+Creates the new member's store and initializes it
+with the latest state of the existing group,
+then prints the resource identifier of the enlarged group.
+`
+
+func memberRemoveCommand(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage(memberRemoveUsageStr)
+	}
+
+	paths, err := parseGroup(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	member := args[1]
+	i := memberIndex(paths, member)
+	if i < 0 {
+		log.Fatalf("%s is not a member of the group", member)
+	}
+	newPaths := append(append([]string{}, paths[:i]...), paths[i+1:]...)
+	if err := checkMembers(newPaths); err != nil {
+		log.Fatal(err)
+	}
+
+	// Commit the latest state in the old group,
+	// so that the remaining members hold it before the member leaves.
+	if err := commitLatest(ctx, args[0]); err != nil {
+		log.Fatal(err)
+	}
+	if err := reconfigureMembers(ctx, newPaths); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(group.FormatRI(newPaths))
+}
+
+const memberRemoveUsageStr = `
+Usage: qsc member remove <group> <member>
+
+where <group> specifies the existing consensus group
+and <member> is the path of the member's store to remove.
+Prints the resource identifier of the reduced group.
+The removed member's store is left intact and may be deleted manually.
+`
+
+func memberRepairCommand(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage(memberRepairUsageStr)
+	}
+
+	paths, err := parseGroup(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	member := args[1]
+	i := memberIndex(paths, member)
+	if i < 0 {
+		log.Fatalf("%s is not a member of the group", member)
+	}
+
+	// Create a new empty store in place of the lost one,
+	// refusing to touch any store that still exists there.
+	st := &casdir.Store{}
+	if err := st.Init(member, true, true); err != nil {
+		log.Fatalf("creating %s: %v "+
+			"(remove a damaged store before repairing it)", member, err)
+	}
+
+	// Copy the most advanced surviving member's state and history.
+	stores := make([]cas.Store, len(paths))
+	for j, path := range paths {
+		if j == i {
+			stores[j] = st
+			continue
+		}
+		ds := &casdir.Store{}
+		if err := ds.Init(path, false, false); err != nil {
+			log.Printf("opening %s: %v", path, err)
+			stores[j] = failedStore{}
+			continue
+		}
+		stores[j] = ds
+	}
+	donor, err := qscas.Repair(ctx, stores, i, -1, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Readmit the member, whose store has a new nonce,
+	// then commit the latest state so that it catches up,
+	// and check that it is at least as advanced as its donor was.
+	if err := group.Readmit(ctx, paths); err != nil {
+		log.Fatal(err)
+	}
+	if err := commitLatest(ctx, args[0]); err != nil {
+		log.Fatal(err)
+	}
+	step, err := memberStep(ctx, member)
+	if err != nil {
+		log.Fatal(err)
+	}
+	donorStep, err := memberStep(ctx, paths[donor])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if step < donorStep {
+		log.Fatalf("%s is at step %d, behind %s at step %d",
+			member, step, paths[donor], donorStep)
+	}
+	fmt.Printf("repaired %s from %s: healthy at step %d\n",
+		member, paths[donor], step)
+}
+
+const memberRepairUsageStr = `
+Usage: qsc member repair <group> <member>
+
+where <group> specifies the consensus group
+and <member> is the path of the member whose store was lost.
+Creates a new store for the member, which must not exist,
+and rebuilds it from the latest state and retained history
+of the most advanced member among a read quorum of the others.
+Then records the new store's nonce in the group's configuration,
+so that clients stop quarantining the member for losing its state,
+commits the group's latest state so the member catches up,
+and reports the member healthy once it has.
+
+Unlike copying another member's store by hand,
+repair never moves the member back to an earlier consensus step,
+so it is safe while other clients are accessing the group.
+To repair a member whose store is damaged rather than lost,
+first move the damaged store out of the way.
+`
+
+func memberDomainsCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		usage(memberDomainsUsageStr)
+	}
+
+	paths, f, err := loadGroup(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	domains := args[1:]
+	if len(domains) == 0 && f != nil {
+		domains = f.Domains()
+	}
+	if len(domains) == 0 {
+		usage(memberDomainsUsageStr)
+	}
+	if len(domains) != len(paths) {
+		log.Fatalf("%d failure domains for %d members",
+			len(domains), len(paths))
+	}
+	if err := reconfigure(ctx, paths, domains); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Commit an in-band configuration for a group with new members,
+// succeeding the configuration its state carries, if any.
+// Members keep any failure domains the configuration records.
+func reconfigureMembers(ctx context.Context, paths []string) error {
+	return reconfigure(ctx, paths, nil)
+}
+
+// Commit an in-band configuration for a group with the given members
+// in the given failure domains, if not nil,
+// warning of any domain whose failure would stop the group.
+func reconfigure(ctx context.Context, paths, domains []string) error {
+	risks, err := group.Reconfigure(ctx, paths, domains)
+	for _, d := range risks {
+		log.Printf("warning: failure of domain %q "+
+			"would stop the group", d)
+	}
+	return err
+}
+
+const memberDomainsUsageStr = `
+Usage: qsc member domains <group> [<domain>...]
+
+where <group> specifies the consensus group
+and each <domain> names the failure domain of the corresponding member,
+such as the datacenter, zone, or rack hosting its store,
+or is empty if unknown.
+If <group> is a configuration file, the domains default to those it lists.
+Records the domains in the group's configuration,
+so that clients prefer to keep members spanning domains up to date,
+and warns of any domain hosting so many members
+that its failure alone would stop the group.
+Members keep their domains across later membership changes,
+and added members have unknown domains until recorded.
+`
+
+// A member store that could not be opened.
+type failedStore struct{}
+
+func (failedStore) CompareAndSet(ctx context.Context, old, new string) (
+	int64, string, error) {
+	return 0, "", errors.New("store unavailable")
+}
+
+// Return the index of member in paths, or -1 if it is not present.
+func memberIndex(paths []string, member string) int {
+	for i, path := range paths {
+		if path == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check that a consensus group of the given members
+// remains large enough and has valid thresholds.
+func checkMembers(paths []string) error {
+	if len(paths) < 3 {
+		return errors.New(
+			"consensus groups must have minimum three members")
+	}
+	if strings.ContainsAny(strings.Join(paths, ""), ",[]") {
+		return errors.New("member paths may not contain ',', '[' or ']'")
+	}
+	_, _, err := qscas.Thresholds(len(paths), -1)
+	return err
+}
+
+// Open a consensus group and commit its latest state.
+func commitLatest(ctx context.Context, ri string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	g, err := openGroup(ctx, ri)
+	if err != nil {
+		return err
+	}
+	_, _, err = g.Get(ctx)
+	return err
+}
+
+// Read the latest raw state of each member store,
+// and return the one with the most advanced consensus time-step.
+func latestMemberValue(ctx context.Context, paths []string) (string, error) {
+	var best string
+	var bestStep int64 = -1
+	for _, path := range paths {
+		st := &casdir.Store{}
+		if err := st.Init(path, false, false); err != nil {
+			return "", err
+		}
+		_, val, err := st.ReadAt(ctx, math.MaxInt64)
+		if err != nil {
+			return "", err
+		}
+		if val == "" {
+			continue
+		}
+		v, err := encoding.OpenValue([]byte(val), nil)
+		if err != nil {
+			return "", err
+		}
+		if v.S > bestStep {
+			best, bestStep = val, v.S
+		}
+	}
+	return best, nil
+}
+
+// Return the consensus time-step of a member store's latest state.
+func memberStep(ctx context.Context, path string) (int64, error) {
+	val, err := latestMemberValue(ctx, []string{path})
+	if err != nil || val == "" {
+		return 0, err
+	}
+	v, err := encoding.OpenValue([]byte(val), nil)
+	return v.S, err
+}
@@ -2,35 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 )
 
-func stringCommand(ctx context.Context, args []string) {
-	if len(args) == 0 {
-		usage(stringUsageStr)
-	}
-	switch args[0] {
-	case "init":
-		stringInitCommand(ctx, args[1:])
-	case "get":
-		stringGetCommand(ctx, args[1:])
-	case "set":
-		stringSetCommand(ctx, args[1:])
-	default:
-		usage(stringUsageStr)
-	}
-}
-
 const stringUsageStr = `
 Usage: qsc string <command> [arguments]
 
 The commands for string-value consensus groups are:
-
-	init	initialize a new consensus group
-	get	output the current consensus state as a quoted string
-	set	change the consensus state via atomic compare-and-set
 `
 
 func stringInitCommand(ctx context.Context, args []string) {
@@ -38,10 +19,9 @@ func stringInitCommand(ctx context.Context, args []string) {
 		usage(stringInitUsageStr)
 	}
 
-	// Create the consensus group state on each member node
-	var g group
-	err := g.Open(ctx, args[0], true)
-	if err != nil {
+	// Create the consensus group state on each member node,
+	// and commit the group's initial configuration in-band.
+	if _, err := createGroup(ctx, args[0]); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -54,34 +34,60 @@ as a composable resource identifier (CRI).
 For example:
 
 	qsc git init qsc[host1:path1,host2:path2,host3:path3]
-`
 
-func stringGetCommand(ctx context.Context, args []string) {
-	if len(args) != 1 {
-		usage(stringGetUsageStr)
-	}
-
-	// Open the file stores
-	var g group
-	err := g.Open(ctx, args[0], false)
-	if err != nil {
-		log.Fatal(err)
-	}
+If the QSC_KEYS environment variable names a key file,
+the group encrypts the values its member stores hold with its keys:
+see qsc help keys.
+`
 
-	// Find a consensus view of the last known commit.
-	ver, val, err := g.CompareAndSet(ctx, "", "")
-	if err != nil {
-		log.Fatal(err)
+func stringGetCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	verify := fs.Bool("verify", false, "verify the commit with a quorum")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 1 {
+			usage(stringGetUsageStr)
+		}
+
+		// Open the file stores
+		g, err := openGroup(ctx, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Find a consensus view of the last known commit.
+		ver, val, proof, err := g.QSC.CompareAndSetProof(ctx, "", "")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("version %d state %q\n", ver, val)
+		fmt.Printf("evidence step %d members %v\n",
+			proof.Step, proof.Members)
+
+		// Optionally re-contact a read quorum to confirm the commit.
+		if *verify {
+			members, err := g.QSC.Verify(ctx, proof)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("verified members %v\n", members)
+		}
 	}
-
-	fmt.Printf("version %d state %q\n", ver, val)
 }
 
 const stringGetUsageStr = `
-Usage: qsc string get <group>
+Usage: qsc string get [options] <group>
 
 where <group> specifies the consensus group.
-Reads and prints the version number and string last committed.
+Reads and prints the version number and string last committed,
+followed by the TLC step number at which the commit was observed
+and the member stores whose states evidenced it.
+
+Options:
+
+	-verify		re-contact a read quorum of members to verify the commit
+
+With -verify, also prints the members that confirmed the commit,
+or fails with a nonzero exit status if a quorum cannot confirm it.
 `
 
 func stringSetCommand(ctx context.Context, args []string) {
@@ -96,14 +102,13 @@ func stringSetCommand(ctx context.Context, args []string) {
 	}
 
 	// Open the file stores
-	var g group
-	err := g.Open(ctx, args[0], false)
+	g, err := openGroup(ctx, args[0])
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Invoke the request compare-and-set operation.
-	ver, val, err := g.CompareAndSet(ctx, old, new)
+	ver, val, err := g.Set(ctx, old, new)
 	if err != nil {
 		log.Fatal(err)
 	}
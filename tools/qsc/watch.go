@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hooks describes the actions to take on each newly committed value.
+type hooks struct {
+	exec    string // shell command to run, if nonempty
+	webhook string // URL to post to, if nonempty
+}
+
+// commit is the JSON representation of a committed value
+// that a webhook receives.
+type commit struct {
+	Version int64  `json:"version"`
+	Value   string `json:"value"`
+}
+
+// Run all configured hooks on a newly committed version and value,
+// logging rather than returning any errors so that watching continues.
+func (h *hooks) run(ctx context.Context, ver int64, val string) {
+	if h.exec != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.exec)
+		cmd.Env = append(os.Environ(),
+			"QSC_VERSION="+strconv.FormatInt(ver, 10),
+			"QSC_VALUE="+val)
+		cmd.Stdin = strings.NewReader(val)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("hook %q: %v", h.exec, err)
+		}
+	}
+	if h.webhook != "" {
+		b, err := json.Marshal(&commit{ver, val})
+		if err != nil {
+			log.Printf("webhook %s: %v", h.webhook, err)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", h.webhook,
+			bytes.NewReader(b))
+		if err != nil {
+			log.Printf("webhook %s: %v", h.webhook, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("webhook %s: %v", h.webhook, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("webhook %s: %s", h.webhook, resp.Status)
+		}
+	}
+}
+
+func stringWatchCommand(fs *flag.FlagSet) func(context.Context, []string) {
+	interval := fs.Duration("interval", time.Second, "polling interval")
+	var h hooks
+	fs.StringVar(&h.exec, "exec", "", "shell command to run on commits")
+	fs.StringVar(&h.webhook, "webhook", "", "URL to post commits to")
+	return func(ctx context.Context, args []string) {
+		if len(args) != 1 {
+			usage(stringWatchUsageStr)
+		}
+
+		// Open the file stores
+		g, err := openGroup(ctx, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Poll for changes to the current state until interrupted.
+		err = g.Watch(ctx, *interval, func(ver int64, val string) error {
+			fmt.Printf("version %d state %q\n", ver, val)
+			h.run(ctx, ver, val)
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+const stringWatchUsageStr = `
+Usage: qsc string watch [options] <group>
+
+where <group> specifies the consensus group.
+Runs until interrupted, polling the group for newly committed states,
+and prints the version number and string of each one.
+
+Options:
+
+	-interval <duration>	how often to poll the group (default 1s)
+	-exec <command>		shell command to run on each new commit
+	-webhook <url>		URL to POST each new commit to
+
+The -exec command receives the new state on its standard input,
+and in the environment variables QSC_VERSION and QSC_VALUE.
+The -webhook URL receives a JSON object with version and value fields.
+`
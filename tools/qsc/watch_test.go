@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHookExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	h := &hooks{exec: `printf '%s %s|' "$QSC_VERSION" "$QSC_VALUE" >` +
+		out + ` && cat >>` + out}
+	h.run(context.Background(), 7, "hello")
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "7 hello|hello" {
+		t.Errorf("hook wrote %q", s)
+	}
+}
+
+func TestHookWebhook(t *testing.T) {
+	got := make(chan commit, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var c commit
+			if r.Method != "POST" ||
+				r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("webhook got %v %v", r.Method,
+					r.Header.Get("Content-Type"))
+			}
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Error(err)
+			}
+			got <- c
+		}))
+	defer srv.Close()
+
+	h := &hooks{webhook: srv.URL}
+	h.run(context.Background(), 7, "hello")
+	select {
+	case c := <-got:
+		if c != (commit{7, "hello"}) {
+			t.Errorf("webhook got %+v", c)
+		}
+	default:
+		t.Errorf("webhook not called")
+	}
+}